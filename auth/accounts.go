@@ -0,0 +1,229 @@
+// Copyright 2015 Daniel C. Brotsky.  All rights reserved.
+
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Accounts
+
+Accounts are the server's own way of identifying users, for
+deployments that don't have a login system of their own.  An
+account has a username and a password, which is kept only as a
+salted PBKDF2 hash.  Logging in gives out a token, which the
+client sends back either in the TokenCookie cookie or as a
+bearer token in the Authorization header.  Only a hash of each
+token is stored, so the storage doesn't hold anything that can
+be used to log in.
+
+*/
+
+// Storage kinds for account records.
+const (
+	accountKind = "account"
+	tokenKind   = "token"
+)
+
+// Account parameters.
+const (
+	// TokenCookie is the name of the cookie holding the login
+	// token.
+	TokenCookie = "susenToken"
+	// TokenLifetime is how long a login lasts.
+	TokenLifetime = 30 * 24 * time.Hour
+	// MinPasswordLength is the shortest acceptable password.
+	MinPasswordLength = 8
+
+	hashIterations = 100000
+	hashLength     = 32
+)
+
+// Account errors.
+var (
+	ErrInvalidUsername = errors.New("Usernames must be 1 to 32 letters, digits, dots, dashes, or underscores")
+	ErrShortPassword   = errors.New("Passwords must be at least 8 characters")
+	ErrAccountExists   = errors.New("That username is taken")
+	ErrLoginFailed     = errors.New("Unknown username or wrong password")
+	ErrInvalidToken    = errors.New("Invalid or expired login token")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{1,32}$`)
+
+// An account is the stored form of an account.
+type account struct {
+	Username   string    `json:"username"`
+	Salt       []byte    `json:"salt"`
+	Hash       []byte    `json:"hash"`
+	Iterations int       `json:"iterations"`
+	Created    time.Time `json:"created"`
+}
+
+// A token is the stored form of a login token, which is kept
+// under the hash of the token.
+type token struct {
+	Username string    `json:"username"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Accounts is an Authenticator for users with accounts kept in a
+// Store.  Users it identifies have the Source "account", and
+// their ID is their username.
+type Accounts struct {
+	mutex sync.Mutex // serializes registrations
+	store storage.Store
+}
+
+// NewAccounts returns the accounts kept in a store.
+func NewAccounts(store storage.Store) *Accounts {
+	return &Accounts{store: store}
+}
+
+func accountUser(username string) *User {
+	return &User{ID: username, Name: username, Source: "account"}
+}
+
+func hashPassword(password string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, iterations, hashLength)
+}
+
+func hashToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
+
+// Register makes a new account.  Usernames aren't case
+// sensitive, and are kept in lower case.
+func (as *Accounts) Register(username, password string) (*User, error) {
+	username = strings.ToLower(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrShortPassword
+	}
+	acct := account{Username: username, Salt: make([]byte, 16), Iterations: hashIterations, Created: time.Now()}
+	if _, e := rand.Read(acct.Salt); e != nil {
+		return nil, e
+	}
+	hash, e := hashPassword(password, acct.Salt, acct.Iterations)
+	if e != nil {
+		return nil, e
+	}
+	acct.Hash = hash
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	var existing account
+	if ok, e := as.store.Get(accountKind, username, &existing); e != nil {
+		return nil, e
+	} else if ok {
+		return nil, ErrAccountExists
+	}
+	if e := as.store.Put(accountKind, username, acct); e != nil {
+		return nil, e
+	}
+	return accountUser(username), nil
+}
+
+// Login checks a username and password, and returns a new login
+// token for the account.
+func (as *Accounts) Login(username, password string) (*User, string, error) {
+	username = strings.ToLower(username)
+	var acct account
+	ok, e := as.store.Get(accountKind, username, &acct)
+	if e != nil {
+		return nil, "", e
+	}
+	if !ok || len(acct.Hash) == 0 {
+		return nil, "", ErrLoginFailed // no such account, or one for OAuth identities only
+	}
+	hash, e := hashPassword(password, acct.Salt, acct.Iterations)
+	if e != nil {
+		return nil, "", e
+	}
+	if subtle.ConstantTimeCompare(hash, acct.Hash) != 1 {
+		return nil, "", ErrLoginFailed
+	}
+	t, e := as.issueToken(username)
+	if e != nil {
+		return nil, "", e
+	}
+	return accountUser(username), t, nil
+}
+
+// issueToken returns a new login token for an account.
+func (as *Accounts) issueToken(username string) (string, error) {
+	b := make([]byte, 32)
+	if _, e := rand.Read(b); e != nil {
+		return "", e
+	}
+	t := base64.RawURLEncoding.EncodeToString(b)
+	if e := as.store.Put(tokenKind, hashToken(t), token{username, time.Now().Add(TokenLifetime)}); e != nil {
+		return "", e
+	}
+	return t, nil
+}
+
+// Logout invalidates a login token.
+func (as *Accounts) Logout(t string) error {
+	return as.store.Delete(tokenKind, hashToken(t))
+}
+
+// lookup returns the user whose login token this is.
+func (as *Accounts) lookup(t string) (*User, error) {
+	var tok token
+	ok, e := as.store.Get(tokenKind, hashToken(t), &tok)
+	if e != nil {
+		return nil, e
+	}
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(tok.Expires) {
+		as.store.Delete(tokenKind, hashToken(t))
+		return nil, ErrInvalidToken
+	}
+	return accountUser(tok.Username), nil
+}
+
+// RequestToken returns the login token sent with a request, and
+// whether it was sent as a bearer token (rather than a cookie).
+func RequestToken(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):]), true
+	}
+	if c, e := r.Cookie(TokenCookie); e == nil && c.Value != "" {
+		return c.Value, false
+	}
+	return "", false
+}
+
+// Authenticate identifies the account whose token was sent with
+// the request.  Invalid bearer tokens are rejected, but invalid
+// cookies are ignored (so a browser with an old login cookie
+// just becomes anonymous).
+func (as *Accounts) Authenticate(r *http.Request) (*User, error) {
+	t, bearer := RequestToken(r)
+	if t == "" {
+		return nil, nil
+	}
+	u, e := as.lookup(t)
+	if e == ErrInvalidToken && !bearer {
+		return nil, nil
+	}
+	return u, e
+}
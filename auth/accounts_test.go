@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccounts(t *testing.T) {
+	store := storage.NewMemory()
+	as := NewAccounts(store)
+	for _, tc := range []struct {
+		username, password string
+		err                error
+	}{
+		{"", "password", ErrInvalidUsername},
+		{"dan b", "password", ErrInvalidUsername},
+		{"dan", "short", ErrShortPassword},
+		{"Dan", "password", nil},
+		{"dan", "other password", ErrAccountExists},
+	} {
+		if _, e := as.Register(tc.username, tc.password); e != tc.err {
+			t.Errorf("Register(%q, %q) gave %v, expected %v", tc.username, tc.password, e, tc.err)
+		}
+	}
+	if _, _, e := as.Login("dan", "wrong password"); e != ErrLoginFailed {
+		t.Errorf("Login with wrong password gave %v", e)
+	}
+	if _, _, e := as.Login("sue", "password"); e != ErrLoginFailed {
+		t.Errorf("Login to unknown account gave %v", e)
+	}
+	u, tok, e := as.Login("DAN", "password")
+	if e != nil || u.Key() != "account:dan" || tok == "" {
+		t.Fatalf("Login gave %+v, %q, %v", u, tok, e)
+	}
+
+	// the token works as a cookie or a bearer token
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: TokenCookie, Value: tok})
+	if u, e := as.Authenticate(r); e != nil || u == nil || u.ID != "dan" {
+		t.Errorf("Cookie login gave %+v, %v", u, e)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tok)
+	if u, e := as.Authenticate(r); e != nil || u == nil || u.ID != "dan" {
+		t.Errorf("Bearer login gave %+v, %v", u, e)
+	}
+
+	// after logout, bearer tokens are rejected and cookies ignored
+	if e := as.Logout(tok); e != nil {
+		t.Fatalf("Logout failed: %v", e)
+	}
+	if u, e := as.Authenticate(r); e != ErrInvalidToken {
+		t.Errorf("Bearer login after logout gave %+v, %v", u, e)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: TokenCookie, Value: tok})
+	if u, e := as.Authenticate(r); u != nil || e != nil {
+		t.Errorf("Cookie login after logout gave %+v, %v", u, e)
+	}
+
+	// expired tokens don't work either
+	_, tok, _ = as.Login("dan", "password")
+	store.Put(tokenKind, hashToken(tok), token{"dan", time.Now().Add(-time.Minute)})
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tok)
+	if u, e := as.Authenticate(r); e != ErrInvalidToken {
+		t.Errorf("Expired login gave %+v, %v", u, e)
+	}
+}
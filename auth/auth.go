@@ -0,0 +1,127 @@
+// Copyright 2015 Daniel C. Brotsky.  All rights reserved.
+
+// Package auth identifies the users behind requests to a susen
+// server.
+//
+// The server doesn't care how users are identified, only that
+// each identified request maps to a User.  Deployments plug in
+// their own identification scheme (LDAP, SAML, a reverse proxy
+// that does the login, or the server's own accounts) by
+// implementing the Authenticator interface.  Requests that
+// aren't identified are anonymous, and are tracked the way they
+// always have been: by browser cookie.
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// A User is a person known to the server.  The ID is the stable
+// key under which the user's data (such as statistics and
+// leaderboard entries) is kept, so it must never be reused; the
+// Name is for display and can change.  The Source says which
+// authenticator identified the user, so IDs from different
+// sources can't collide.
+type User struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// Key returns the user's unique key across all sources.
+func (u *User) Key() string {
+	return u.Source + ":" + u.ID
+}
+
+// An Authenticator identifies the user making a request.  It
+// returns a nil User (and no error) for anonymous requests, and
+// an error for requests whose credentials it rejects.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// AuthenticatorFunc lets an ordinary function be an
+// Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*User, error)
+
+// Authenticate calls the function.
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*User, error) {
+	return f(r)
+}
+
+// Anonymous is the Authenticator that never identifies anyone.
+var Anonymous Authenticator = AuthenticatorFunc(func(*http.Request) (*User, error) {
+	return nil, nil
+})
+
+// A HeaderAuthenticator trusts a reverse proxy in front of the
+// server to have done the login, and identifies the user from
+// the header the proxy sets.  The proxy must remove any copy of
+// the header sent by clients, otherwise anyone can claim to be
+// anyone.  If NameHeader is empty or missing, the user's name is
+// the same as the ID.
+type HeaderAuthenticator struct {
+	UserHeader string
+	NameHeader string
+}
+
+// Authenticate returns the user named in the request headers.
+func (ha HeaderAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	id := r.Header.Get(ha.UserHeader)
+	if id == "" {
+		return nil, nil
+	}
+	name := id
+	if ha.NameHeader != "" {
+		if n := r.Header.Get(ha.NameHeader); n != "" {
+			name = n
+		}
+	}
+	return &User{ID: id, Name: name, Source: "header"}, nil
+}
+
+// Chain returns an Authenticator that tries each of the given
+// ones in order.  The first one that identifies a user or
+// rejects the request decides.
+func Chain(as ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*User, error) {
+		for _, a := range as {
+			if u, e := a.Authenticate(r); u != nil || e != nil {
+				return u, e
+			}
+		}
+		return nil, nil
+	})
+}
+
+// contextKey is the type of the request context key for users.
+type contextKey int
+
+const userKey contextKey = 0
+
+// Middleware runs the authenticator on each request before
+// passing it to the handler, which can get the user with
+// FromRequest.  Rejected requests get a 401 response.
+func Middleware(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, e := a.Authenticate(r)
+		if e != nil {
+			log.Printf("Authentication failed for %s %s: %v", r.Method, r.URL.Path, e)
+			http.Error(w, "Authentication failed: "+e.Error(), http.StatusUnauthorized)
+			return
+		}
+		if u != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userKey, u))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FromRequest returns the user identified by the middleware, or
+// nil if the request is anonymous.
+func FromRequest(r *http.Request) *User {
+	u, _ := r.Context().Value(userKey).(*User)
+	return u
+}
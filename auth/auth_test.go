@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderAuthenticator(t *testing.T) {
+	ha := HeaderAuthenticator{UserHeader: "X-Remote-User", NameHeader: "X-Remote-Name"}
+	r := httptest.NewRequest("GET", "/", nil)
+	if u, e := ha.Authenticate(r); u != nil || e != nil {
+		t.Errorf("Request without header gave %+v, %v", u, e)
+	}
+	r.Header.Set("X-Remote-User", "dan")
+	if u, _ := ha.Authenticate(r); !reflect.DeepEqual(u, &User{"dan", "dan", "header"}) {
+		t.Errorf("Request with user header gave %+v", u)
+	}
+	r.Header.Set("X-Remote-Name", "Dan B.")
+	if u, _ := ha.Authenticate(r); !reflect.DeepEqual(u, &User{"dan", "Dan B.", "header"}) {
+		t.Errorf("Request with both headers gave %+v", u)
+	} else if u.Key() != "header:dan" {
+		t.Errorf("User key is %q", u.Key())
+	}
+}
+
+func TestChainAndMiddleware(t *testing.T) {
+	reject := AuthenticatorFunc(func(r *http.Request) (*User, error) {
+		if r.Header.Get("X-Bad") != "" {
+			return nil, fmt.Errorf("bad credentials")
+		}
+		return nil, nil
+	})
+	a := Chain(Anonymous, reject, HeaderAuthenticator{UserHeader: "X-Remote-User"})
+	var seen *User
+	h := Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromRequest(r)
+	}))
+
+	w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || seen != nil {
+		t.Errorf("Anonymous request gave %d, %+v", w.Code, seen)
+	}
+	w, r = httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Remote-User", "dan")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || seen == nil || seen.ID != "dan" {
+		t.Errorf("Identified request gave %d, %+v", w.Code, seen)
+	}
+	seen = nil
+	w, r = httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Remote-User", "dan")
+	r.Header.Set("X-Bad", "yes")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || seen != nil {
+		t.Errorf("Rejected request gave %d, %+v", w.Code, seen)
+	}
+}
@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+OAuth sign-in
+
+Accounts can also be signed into through an OAuth provider (such
+as GitHub or Google) instead of with a password.  Begin sends
+the browser to the provider with a random state, which is kept
+(briefly) in the store and in the browser's OAuthStateCookie,
+and the provider sends it back with that state and a code, which
+Complete exchanges for the user's identity.  The state has to be
+one that was handed out for that provider and hasn't been used,
+and the server checks that it matches the cookie, so that nobody
+can finish a sign-in that another browser started.  OpenID
+Connect providers (Google) also get a nonce, which has to come
+back in the ID token they return, along with the server's client
+ID; those ID tokens come straight from the provider, so their
+signatures aren't checked.
+
+Each identity is linked to an account.  The first sign-in with
+an identity makes a new account for it, named after the
+identity (and made unique), unless the user is already signed
+in to an account, in which case the identity is linked to that
+one.  Accounts made for identities have no password, so they
+can only be signed into through their identities.
+
+*/
+
+// Storage kinds for OAuth records.
+const (
+	oauthStateKind = "oauth-state"
+	identityKind   = "identity"
+)
+
+// OAuth parameters.
+const (
+	// OAuthStateCookie is the name of the cookie holding the
+	// state of a sign-in in progress.
+	OAuthStateCookie = "susenOAuthState"
+	// OAuthStateLifetime is how long a sign-in can take.
+	OAuthStateLifetime = 10 * time.Minute
+)
+
+// OAuth errors.
+var (
+	ErrUnknownProvider = errors.New("Unknown sign-in provider")
+	ErrOAuthState      = errors.New("Invalid or expired sign-in state")
+	ErrOAuthNonce      = errors.New("The sign-in provider's ID token doesn't match the sign-in")
+	ErrOAuthIdentity   = errors.New("The sign-in provider didn't identify the user")
+	ErrIdentityLinked  = errors.New("That identity is linked to another account")
+)
+
+// An OAuthProvider is a service users can sign in through.
+// IDField and NameField are the fields of the user info (or, for
+// OpenID Connect providers, the ID token) that have the user's
+// ID and name.
+type OAuthProvider struct {
+	Name         string   `json:"name"`
+	AuthURL      string   `json:"-"`
+	TokenURL     string   `json:"-"`
+	UserURL      string   `json:"-"` // unused by OpenID Connect providers
+	Scopes       []string `json:"-"`
+	OpenID       bool     `json:"-"`
+	IDField      string   `json:"-"`
+	NameField    string   `json:"-"`
+	ClientID     string   `json:"-"`
+	ClientSecret string   `json:"-"`
+}
+
+// OAuthProviders are the providers the server knows, without
+// their client credentials.
+var OAuthProviders = map[string]OAuthProvider{
+	"github": {
+		Name:      "github",
+		AuthURL:   "https://github.com/login/oauth/authorize",
+		TokenURL:  "https://github.com/login/oauth/access_token",
+		UserURL:   "https://api.github.com/user",
+		Scopes:    []string{"read:user"},
+		IDField:   "id",
+		NameField: "login",
+	},
+	"google": {
+		Name:      "google",
+		AuthURL:   "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:  "https://oauth2.googleapis.com/token",
+		Scopes:    []string{"openid", "profile"},
+		OpenID:    true,
+		IDField:   "sub",
+		NameField: "name",
+	},
+}
+
+// An oauthState is the stored form of a sign-in in progress,
+// which is kept under the hash of its state.
+type oauthState struct {
+	Provider string    `json:"provider"`
+	Nonce    string    `json:"nonce,omitempty"`
+	Redirect string    `json:"redirect"`
+	Return   string    `json:"return,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// An identity is the stored form of a link from a provider's
+// user to an account, which is kept under the provider's name
+// and the user's ID.
+type identity struct {
+	Username string    `json:"username"`
+	Name     string    `json:"name"`
+	Linked   time.Time `json:"linked"`
+}
+
+// OAuth signs users in to Accounts through providers.
+type OAuth struct {
+	accounts  *Accounts
+	providers map[string]OAuthProvider
+	// Client makes the requests to the providers.
+	Client *http.Client
+}
+
+// NewOAuth returns sign-in to the accounts through the given
+// providers.
+func NewOAuth(as *Accounts, providers ...OAuthProvider) *OAuth {
+	o := &OAuth{accounts: as, providers: make(map[string]OAuthProvider), Client: &http.Client{Timeout: 10 * time.Second}}
+	for _, p := range providers {
+		o.providers[p.Name] = p
+	}
+	return o
+}
+
+// Providers returns the names of the providers users can sign in
+// through, in order.
+func (o *OAuth) Providers() []string {
+	names := make([]string, 0, len(o.providers))
+	for name := range o.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// randomString returns a random string for states and nonces.
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, e := rand.Read(b); e != nil {
+		return "", e
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Begin starts a sign-in through a provider, which sends the
+// user back to the redirect URL when they've signed in there.
+// It returns the URL to send the user to, and the sign-in's
+// state; returnPath is kept with the state for Complete.
+func (o *OAuth) Begin(provider, redirect, returnPath string) (string, string, error) {
+	p, ok := o.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	state, e := randomString()
+	if e != nil {
+		return "", "", e
+	}
+	s := oauthState{Provider: provider, Redirect: redirect, Return: returnPath, Expires: time.Now().Add(OAuthStateLifetime)}
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {redirect},
+		"scope":         {strings.Join(p.Scopes, " ")},
+		"state":         {state},
+	}
+	if p.OpenID {
+		if s.Nonce, e = randomString(); e != nil {
+			return "", "", e
+		}
+		q.Set("nonce", s.Nonce)
+	}
+	if e := o.accounts.store.Put(oauthStateKind, hashToken(state), s); e != nil {
+		return "", "", e
+	}
+	return p.AuthURL + "?" + q.Encode(), state, nil
+}
+
+// Complete finishes a sign-in through a provider, given the state
+// and code the provider sent back, and returns the account user
+// signed in, a login token for them (see Accounts.Login), and
+// the path kept by Begin.  If the current user is signed in to
+// an account, the identity is linked to it.
+func (o *OAuth) Complete(provider, state, code string, current *User) (*User, string, string, error) {
+	p, ok := o.providers[provider]
+	if !ok {
+		return nil, "", "", ErrUnknownProvider
+	}
+	var s oauthState
+	found, e := o.accounts.store.Get(oauthStateKind, hashToken(state), &s)
+	if e != nil {
+		return nil, "", "", e
+	}
+	if !found || state == "" {
+		return nil, "", "", ErrOAuthState
+	}
+	// each state can only be used once, even by simultaneous
+	// requests
+	if used, e := storage.Swap(o.accounts.store, oauthStateKind, hashToken(state), s, nil); e != nil {
+		return nil, "", "", e
+	} else if !used {
+		return nil, "", "", ErrOAuthState
+	}
+	if s.Provider != provider || time.Now().After(s.Expires) {
+		return nil, "", "", ErrOAuthState
+	}
+	id, name, e := o.identify(p, s, code)
+	if e != nil {
+		return nil, "", "", e
+	}
+	username := ""
+	if current != nil && current.Source == "account" {
+		username = current.ID
+	}
+	username, e = o.accounts.link(provider, id, name, username)
+	if e != nil {
+		return nil, "", "", e
+	}
+	t, e := o.accounts.issueToken(username)
+	if e != nil {
+		return nil, "", "", e
+	}
+	return accountUser(username), t, s.Return, nil
+}
+
+// oauthToken is a provider's response to a code exchange.
+type oauthToken struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+// identify exchanges a sign-in's code for the ID and name of the
+// user signed in to the provider.
+func (o *OAuth) identify(p OAuthProvider, s oauthState, code string) (string, string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.Redirect},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	req, e := http.NewRequest("POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if e != nil {
+		return "", "", e
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var tok oauthToken
+	if e := o.fetch(req, &tok); e != nil {
+		return "", "", e
+	}
+	if tok.Error != "" || tok.AccessToken == "" {
+		return "", "", fmt.Errorf("The sign-in provider refused the code: %s", tok.Error)
+	}
+	var info map[string]interface{}
+	if p.OpenID {
+		if info, e = idTokenClaims(tok.IDToken); e != nil {
+			return "", "", e
+		}
+		if info["nonce"] != s.Nonce || !claimAudience(info["aud"], p.ClientID) {
+			return "", "", ErrOAuthNonce
+		}
+	} else {
+		req, e := http.NewRequest("GET", p.UserURL, nil)
+		if e != nil {
+			return "", "", e
+		}
+		req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+		req.Header.Set("Accept", "application/json")
+		if e := o.fetch(req, &info); e != nil {
+			return "", "", e
+		}
+	}
+	id, name := claimString(info[p.IDField]), claimString(info[p.NameField])
+	if id == "" {
+		return "", "", ErrOAuthIdentity
+	}
+	if name == "" {
+		name = id
+	}
+	return id, name, nil
+}
+
+// fetch makes a request to a provider, and decodes its JSON
+// response.
+func (o *OAuth) fetch(req *http.Request, v interface{}) error {
+	resp, e := o.Client.Do(req)
+	if e != nil {
+		return e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("The sign-in provider failed: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// idTokenClaims returns the claims in an ID token, without
+// checking its signature.
+func idTokenClaims(t string) (map[string]interface{}, error) {
+	parts := strings.Split(t, ".")
+	if len(parts) != 3 {
+		return nil, ErrOAuthIdentity
+	}
+	payload, e := base64.RawURLEncoding.DecodeString(parts[1])
+	if e != nil {
+		return nil, ErrOAuthIdentity
+	}
+	var claims map[string]interface{}
+	if e := json.Unmarshal(payload, &claims); e != nil {
+		return nil, ErrOAuthIdentity
+	}
+	return claims, nil
+}
+
+// claimAudience tells whether an ID token's audience (a string or
+// a list of them) includes the client.
+func claimAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString returns a user info field as a string: providers
+// such as GitHub have numeric IDs.
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// unusableUsername matches the runs of characters that can't be
+// in usernames.
+var unusableUsername = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// link returns the account an identity is linked to, linking it
+// to the given account if it isn't linked (or, if there's no
+// account, to a new one named after the identity).
+func (as *Accounts) link(provider, id, name, username string) (string, error) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	key := provider + ":" + id
+	var ident identity
+	if found, e := as.store.Get(identityKind, key, &ident); e != nil {
+		return "", e
+	} else if found {
+		if username != "" && username != ident.Username {
+			return "", ErrIdentityLinked
+		}
+		return ident.Username, nil
+	}
+	if username == "" {
+		base := strings.Trim(unusableUsername.ReplaceAllString(strings.ToLower(name), "-"), "-")
+		if len(base) > 24 {
+			base = base[:24]
+		}
+		if base == "" {
+			base = provider
+		}
+		for n := 1; ; n++ {
+			username = base
+			if n > 1 {
+				username += "-" + strconv.Itoa(n)
+			}
+			var existing account
+			if found, e := as.store.Get(accountKind, username, &existing); e != nil {
+				return "", e
+			} else if !found {
+				break
+			}
+		}
+		if e := as.store.Put(accountKind, username, account{Username: username, Created: time.Now()}); e != nil {
+			return "", e
+		}
+	}
+	return username, as.store.Put(identityKind, key, identity{Username: username, Name: name, Linked: time.Now()})
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// helperOAuthProviders returns a fake provider server, and GitHub
+// and Google style providers using it.  The codes it accepts name
+// users, with the nonce to put in ID tokens after a slash.
+func helperOAuthProviders() (*httptest.Server, OAuthProvider, OAuthProvider) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			if r.Form.Get("client_secret") != "secret" || r.Form.Get("code") == "" {
+				json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+				return
+			}
+			code := strings.SplitN(r.Form.Get("code"), "/", 2)
+			claims, _ := json.Marshal(map[string]interface{}{"sub": code[0], "name": "Google " + code[0],
+				"aud": r.Form.Get("client_id"), "nonce": code[len(code)-1]})
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "at-" + code[0],
+				"id_token": "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"})
+		case "/user":
+			id := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer at-")
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 1000 + len(id), "login": "Git Hubber"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	github := OAuthProviders["github"]
+	github.TokenURL, github.UserURL, github.ClientID, github.ClientSecret = srv.URL+"/token", srv.URL+"/user", "gh", "secret"
+	google := OAuthProviders["google"]
+	google.TokenURL, google.ClientID, google.ClientSecret = srv.URL+"/token", "gg", "secret"
+	return srv, github, google
+}
+
+func TestOAuth(t *testing.T) {
+	srv, github, google := helperOAuthProviders()
+	defer srv.Close()
+	as := NewAccounts(storage.NewMemory())
+	o := NewOAuth(as, github, google)
+	if names := o.Providers(); len(names) != 2 || names[0] != "github" {
+		t.Errorf("Providers are %v", names)
+	}
+	if _, _, e := o.Begin("myspace", "https://susen/auth/myspace/callback", ""); e != ErrUnknownProvider {
+		t.Errorf("Begin with an unknown provider gave %v", e)
+	}
+
+	// a first sign-in makes an account named after the identity
+	to, state, e := o.Begin("github", "https://susen/auth/github/callback", "/solver/")
+	if u, _ := url.Parse(to); e != nil || u.Query().Get("state") != state || u.Query().Get("client_id") != "gh" ||
+		u.Query().Get("nonce") != "" {
+		t.Fatalf("Begin gave %q, %q, %v", to, state, e)
+	}
+	u, tok, back, e := o.Complete("github", state, "7", nil)
+	if e != nil || u.Key() != "account:git-hubber" || tok == "" || back != "/solver/" {
+		t.Fatalf("Complete gave %+v, %q, %q, %v", u, tok, back, e)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tok)
+	if user, e := as.Authenticate(r); e != nil || user == nil || user.ID != "git-hubber" {
+		t.Errorf("Token from a sign-in gave %+v, %v", user, e)
+	}
+	if _, _, e := as.Login("git-hubber", ""); e != ErrLoginFailed {
+		t.Errorf("Password login to an identity's account gave %v", e)
+	}
+
+	// states can't be used twice, or for other providers
+	if _, _, _, e := o.Complete("github", state, "7", nil); e != ErrOAuthState {
+		t.Errorf("Reused state gave %v", e)
+	}
+	_, state, _ = o.Begin("github", "https://susen/auth/github/callback", "")
+	if _, _, _, e := o.Complete("google", state, "7", nil); e != ErrOAuthState {
+		t.Errorf("State for another provider gave %v", e)
+	}
+
+	// the same identity signs into the same account, and another
+	// with the same name gets another account
+	_, state, _ = o.Begin("github", "https://susen/auth/github/callback", "")
+	if u, _, _, e := o.Complete("github", state, "7", nil); e != nil || u.ID != "git-hubber" {
+		t.Errorf("Second sign-in gave %+v, %v", u, e)
+	}
+	_, state, _ = o.Begin("github", "https://susen/auth/github/callback", "")
+	if u, _, _, e := o.Complete("github", state, "77", nil); e != nil || u.ID != "git-hubber-2" {
+		t.Errorf("Sign-in with another identity gave %+v, %v", u, e)
+	}
+
+	// OpenID Connect sign-ins check the nonce
+	to, state, _ = o.Begin("google", "https://susen/auth/google/callback", "")
+	nonce := mustQuery(t, to).Get("nonce")
+	if _, _, _, e := o.Complete("google", state, "42/wrong", nil); e != ErrOAuthNonce || nonce == "" {
+		t.Errorf("Sign-in with the wrong nonce gave %v", e)
+	}
+
+	// signed-in users link identities to their accounts
+	as.Register("dan", "password")
+	dan := accountUser("dan")
+	to, state, _ = o.Begin("google", "https://susen/auth/google/callback", "")
+	if u, _, _, e := o.Complete("google", state, "42/"+mustQuery(t, to).Get("nonce"), dan); e != nil || u.ID != "dan" {
+		t.Errorf("Linking sign-in gave %+v, %v", u, e)
+	}
+	_, state, _ = o.Begin("github", "https://susen/auth/github/callback", "")
+	if _, _, _, e := o.Complete("github", state, "7", dan); e != ErrIdentityLinked {
+		t.Errorf("Linking another account's identity gave %v", e)
+	}
+}
+
+func mustQuery(t *testing.T, to string) url.Values {
+	u, e := url.Parse(to)
+	if e != nil {
+		t.Fatalf("Bad URL %q: %v", to, e)
+	}
+	return u.Query()
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Daniel C. Brotsky.  All rights reserved.
+
+package auth
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// A Role is a set of permissions granted to users.  Every
+// identified user is a player; the other roles must be granted.
+type Role string
+
+// The roles known to the server.  Setters can add puzzles to the
+// catalog, teachers can run classes, moderators can remove
+// puzzles and close rooms, and admins can do anything, including
+// granting roles.
+const (
+	RolePlayer    Role = "player"
+	RoleSetter    Role = "setter"
+	RoleTeacher   Role = "teacher"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// AllRoles lists the known roles, from least to most powerful.
+var AllRoles = []Role{RolePlayer, RoleSetter, RoleTeacher, RoleModerator, RoleAdmin}
+
+// Valid tells whether a role is one of the known roles.
+func (role Role) Valid() bool {
+	for _, r := range AllRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles keeps the roles granted to users, by user key.  It's
+// safe for concurrent use.
+type Roles struct {
+	mutex  sync.RWMutex
+	grants map[string]map[Role]bool
+}
+
+// NewRoles returns an empty set of role grants.
+func NewRoles() *Roles {
+	return &Roles{grants: make(map[string]map[Role]bool)}
+}
+
+// Grant gives a role to the user with the given key.
+func (rs *Roles) Grant(key string, role Role) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.grants[key] == nil {
+		rs.grants[key] = make(map[Role]bool)
+	}
+	rs.grants[key][role] = true
+}
+
+// Revoke takes a role away from the user with the given key.
+// The player role can't be revoked, since it isn't granted.
+func (rs *Roles) Revoke(key string, role Role) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	delete(rs.grants[key], role)
+	if len(rs.grants[key]) == 0 {
+		delete(rs.grants, key)
+	}
+}
+
+// Of returns the roles of the user with the given key, in the
+// order of AllRoles.  The player role is always included.
+func (rs *Roles) Of(key string) []Role {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	roles := []Role{RolePlayer}
+	for _, r := range AllRoles[1:] {
+		if rs.grants[key][r] {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// Granted returns the keys of all users who have been granted
+// roles, in sorted order.
+func (rs *Roles) Granted() []string {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	keys := make([]string, 0, len(rs.grants))
+	for key := range rs.grants {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Has tells whether a user has a role.  Anonymous (nil) users
+// have no roles, and admins have every role.
+func (rs *Roles) Has(u *User, role Role) bool {
+	if u == nil {
+		return false
+	}
+	if role == RolePlayer {
+		return true
+	}
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	return rs.grants[u.Key()][role] || rs.grants[u.Key()][RoleAdmin]
+}
+
+// RequireRole passes requests to the handler only if the user
+// identified by the Middleware has the given role.  Anonymous
+// requests get a 401 response, and users without the role get a
+// 403 response.
+func RequireRole(rs *Roles, role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := FromRequest(r)
+		if u == nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !rs.Has(u, role) {
+			log.Printf("User %s lacks role %s for %s %s", u.Key(), role, r.Method, r.URL.Path)
+			http.Error(w, "Requires the "+string(role)+" role", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
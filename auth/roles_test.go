@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	rs := NewRoles()
+	dan, sue := &User{"dan", "dan", "header"}, &User{"sue", "sue", "header"}
+	if rs.Has(nil, RolePlayer) {
+		t.Errorf("Anonymous user is a player")
+	}
+	if !rs.Has(dan, RolePlayer) || rs.Has(dan, RoleSetter) {
+		t.Errorf("New user has roles %v", rs.Of(dan.Key()))
+	}
+	rs.Grant(dan.Key(), RoleSetter)
+	rs.Grant(sue.Key(), RoleAdmin)
+	if !rs.Has(dan, RoleSetter) || rs.Has(dan, RoleModerator) {
+		t.Errorf("Setter has roles %v", rs.Of(dan.Key()))
+	}
+	if !rs.Has(sue, RoleModerator) {
+		t.Errorf("Admin isn't a moderator")
+	}
+	if roles := rs.Of(dan.Key()); !reflect.DeepEqual(roles, []Role{RolePlayer, RoleSetter}) {
+		t.Errorf("Setter's roles are %v", roles)
+	}
+	if keys := rs.Granted(); !reflect.DeepEqual(keys, []string{"header:dan", "header:sue"}) {
+		t.Errorf("Granted keys are %v", keys)
+	}
+	rs.Revoke(dan.Key(), RoleSetter)
+	if rs.Has(dan, RoleSetter) || len(rs.Granted()) != 1 {
+		t.Errorf("Revoke left roles %v, grants %v", rs.Of(dan.Key()), rs.Granted())
+	}
+	if !RoleModerator.Valid() || Role("wizard").Valid() {
+		t.Errorf("Role validity is wrong")
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	rs := NewRoles()
+	rs.Grant("header:sue", RoleModerator)
+	called := false
+	h := Middleware(HeaderAuthenticator{UserHeader: "X-Remote-User"},
+		RequireRole(rs, RoleModerator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})))
+	for _, tc := range []struct {
+		user   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"dan", http.StatusForbidden},
+		{"sue", http.StatusOK},
+	} {
+		called = false
+		w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)
+		if tc.user != "" {
+			r.Header.Set("X-Remote-User", tc.user)
+		}
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status || called != (tc.status == http.StatusOK) {
+			t.Errorf("User %q got status %d (handler called: %v)", tc.user, w.Code, called)
+		}
+	}
+}
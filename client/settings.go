@@ -2,6 +2,7 @@ package client
 
 import (
 	"html/template"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
@@ -17,6 +18,7 @@ const (
 	applicationVersion             = "0.6"
 	solverPageHead                 = "Puzzle Solver"
 	errorPageHead                  = "Error Encountered"
+	recoveryPageHead               = "Recover a Board"
 	templatePageSuffix             = "Page.tmpl.html"
 	defaultTemplateDirectoryEnvVar = "TEMPLATE_DIRECTORY"
 	staticDirPrefix                = "/static/"
@@ -26,6 +28,7 @@ const (
 
 var (
 	defaultTemplateDirectory = filepath.Join("static", "tmpl")
+	defaultTemplateFS        fs.FS // if set, used instead of the default directory
 )
 
 /*
@@ -34,11 +37,27 @@ find and parse templates
 
 */
 
-func findTemplateDirectory() string {
+// SetDefaultTemplateDirectory sets the directory that templates
+// are found in when it isn't given in the environment.
+func SetDefaultTemplateDirectory(dir string) {
+	defaultTemplateDirectory, defaultTemplateFS = dir, nil
+}
+
+// SetDefaultTemplateFS sets the file system that templates are
+// found in (at its top level) when their directory isn't given
+// in the environment, such as the server's embedded assets.
+func SetDefaultTemplateFS(fsys fs.FS) {
+	defaultTemplateFS = fsys
+}
+
+func findTemplateFS() fs.FS {
 	if dir := os.Getenv(defaultTemplateDirectoryEnvVar); dir != "" {
-		return dir
+		return os.DirFS(dir)
+	}
+	if defaultTemplateFS != nil {
+		return defaultTemplateFS
 	}
-	return defaultTemplateDirectory
+	return os.DirFS(defaultTemplateDirectory)
 }
 
 // loadedTemplates is the cache of already-parsed templates
@@ -51,8 +70,7 @@ func loadPageTemplate(name string) (*template.Template, error) {
 	if tmpl, ok := loadedTemplates[name]; ok {
 		return tmpl, nil
 	}
-	fp := filepath.Join(findTemplateDirectory(), name+templatePageSuffix)
-	tmpl, err := template.ParseFiles(fp)
+	tmpl, err := template.ParseFS(findTemplateFS(), name+templatePageSuffix)
 	if err != nil {
 		return nil, err
 	}
@@ -31,12 +31,13 @@ type templateSolverPage struct {
 // section of the solver page template.
 type templatePuzzle [][]templatePuzzleCell
 
-// A templatePuzzleCell contains the cell's index, value, and CSS
-// styling classes as expected by the puzzle grid section of the
-// solver page template.
+// A templatePuzzleCell contains the cell's index, row and column
+// (from 1), value, and CSS styling classes as expected by the
+// puzzle grid section of the solver page templates.
 type templatePuzzleCell struct {
-	Index                   int
+	Index, Row, Column      int
 	Value                   template.HTML
+	Empty                   bool
 	Shade, HBorder, VBorder string
 }
 
@@ -44,15 +45,7 @@ type templatePuzzleCell struct {
 // session and puzzle info, and returns the solver page content as a
 // string.
 func SolverPage(sessionID string, puzzleID string, state puzzle.State) string {
-	var tp templatePuzzle
-	var err error
-	if state.Geometry == puzzle.SudokuGeometryCode {
-		tp, err = sudokuTemplatePuzzle(state.Values)
-	} else if state.Geometry == puzzle.DudokuGeometryCode {
-		tp, err = dudokuTemplatePuzzle(state.Values)
-	} else {
-		err = fmt.Errorf("Can't generate puzzle grid for Geometry Code %v", state.Geometry)
-	}
+	tp, err := stateTemplatePuzzle(state)
 	if err != nil {
 		return errorPage(err)
 	}
@@ -80,6 +73,111 @@ func SolverPage(sessionID string, puzzleID string, state puzzle.State) string {
 	return buf.String()
 }
 
+// stateTemplatePuzzle returns the templatePuzzle for a puzzle's
+// state, according to its geometry.
+func stateTemplatePuzzle(state puzzle.State) (tp templatePuzzle, err error) {
+	if _, killer := puzzle.KillerCages(state.Geometry); killer || state.Geometry == puzzle.SudokuGeometryCode {
+		tp, err = sudokuTemplatePuzzle(state.Values) // cages come with the squares
+	} else if state.Geometry >= puzzle.XSudokuGeometryCode && state.Geometry <= puzzle.HyperXSudokuGeometryCode {
+		tp, err = sudokuTemplatePuzzle(state.Values)
+	} else if state.Geometry == puzzle.DudokuGeometryCode {
+		tp, err = dudokuTemplatePuzzle(state.Values)
+	} else {
+		err = fmt.Errorf("Can't generate puzzle grid for Geometry Code %v", state.Geometry)
+	}
+	return tp, err
+}
+
+/*
+
+plain solver pages
+
+*/
+
+// A templatePlainPage contains the values to fill the plain
+// solver page template.
+type templatePlainPage struct {
+	PuzzleID, Action  string
+	Title, TopHead    string
+	IconFile, CssFile string
+	Message           string
+	Errors, Choices   []string
+	Done              bool
+	Puzzle            templatePuzzle
+}
+
+// PlainSolverPage executes the plain solver page template, which
+// needs no scripts: its empty squares are inputs of a form that
+// posts to the action path, as do its undo and new puzzle
+// buttons.  The message (if any) reports on the last post, and
+// the choices are the puzzle IDs that can be started.
+func PlainSolverPage(action, puzzleID string, state puzzle.State, message string, choices []string) string {
+	tp, err := stateTemplatePuzzle(state)
+	if err != nil {
+		return errorPage(err)
+	}
+	tpp := templatePlainPage{
+		PuzzleID: puzzleID,
+		Action:   action,
+		Title:    fmt.Sprintf("%s v%s", applicationName, applicationVersion),
+		TopHead:  solverPageHead,
+		IconFile: staticDirPrefix + iconPath,
+		CssFile:  staticDirPrefix + "css/puzzle.css",
+		Message:  message,
+		Choices:  choices,
+		Done:     state.Done,
+		Puzzle:   tp,
+	}
+	for _, e := range state.Errors {
+		tpp.Errors = append(tpp.Errors, e.Message)
+	}
+
+	tmpl, err := loadPageTemplate("plain")
+	if err != nil {
+		return errorPage(fmt.Errorf("Couldn't load the %q template: %v", "plain", err))
+	}
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, tpp)
+	if err != nil {
+		return errorPage(err)
+	}
+	return buf.String()
+}
+
+// A templateRecoveryPage contains the values to fill the
+// recovery page template.
+type templateRecoveryPage struct {
+	Title, TopHead     string
+	IconFile, CssFile  string
+	Code, Redeem, Next string
+}
+
+// RecoveryPage executes the recovery page template, which asks
+// before a session recovery code is used: its button posts the
+// code to the redeem path, and then goes to the next path.
+func RecoveryPage(code, redeem, next string) string {
+	trp := templateRecoveryPage{
+		Title:    fmt.Sprintf("%s v%s", applicationName, applicationVersion),
+		TopHead:  recoveryPageHead,
+		IconFile: staticDirPrefix + iconPath,
+		CssFile:  staticDirPrefix + "css/puzzle.css",
+		Code:     code,
+		Redeem:   redeem,
+		Next:     next,
+	}
+
+	tmpl, err := loadPageTemplate("recover")
+	if err != nil {
+		return errorPage(fmt.Errorf("Couldn't load the %q template: %v", "recover", err))
+	}
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, trp)
+	if err != nil {
+		return errorPage(err)
+	}
+	return buf.String()
+}
+
 /*
 
 Sudoku puzzle templates
@@ -129,7 +227,10 @@ func sudokuTemplatePuzzle(vals []int) (templatePuzzle, error) {
 			}
 			rows[i][j] = templatePuzzleCell{
 				Index:   index + 1,
+				Row:     i + 1,
+				Column:  j + 1,
 				Value:   value,
+				Empty:   vals[index] == 0,
 				Shade:   shade,
 				HBorder: hborder,
 				VBorder: vborder,
@@ -199,7 +300,10 @@ func dudokuTemplatePuzzle(vals []int) (templatePuzzle, error) {
 			}
 			rows[i][j] = templatePuzzleCell{
 				Index:   index + 1,
+				Row:     i + 1,
+				Column:  j + 1,
 				Value:   value,
+				Empty:   vals[index] == 0,
 				Shade:   shade,
 				HBorder: hborder,
 				VBorder: vborder,
@@ -57,6 +57,24 @@ func TestSolverPage(t *testing.T) {
 	}
 }
 
+func TestPlainSolverPage(t *testing.T) {
+	p0, e := puzzle.New(rotation4Puzzle1PartialValues)
+	if e != nil {
+		t.Fatalf("Failed to create p0: %v", e)
+	}
+	body0 := PlainSolverPage("/solver/plain", "test-0", p0.State(), "Test <message> 0", []string{"test-0", "test-1"})
+	if !sameAsResultFile(body0, "TestPlainSolverPage0.html") {
+		t.Errorf("Test Plain 0: got unexpected result body:\n%v\n", body0)
+	}
+}
+
+func TestRecoveryPage(t *testing.T) {
+	body0 := RecoveryPage("ABCD-<EF>", "/api/recovery-code/redeem", "/solver/")
+	if !sameAsResultFile(body0, "TestRecoveryPage0.html") {
+		t.Errorf("Test Recovery 0: got unexpected result body:\n%v\n", body0)
+	}
+}
+
 /*
 
 helpers
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+/*
+
+Benchmarking
+
+	susen-tool bench [-format f] [-builtin=false] [-rounds n] [-generate n] [-cpuprofile file] [-memprofile file] [-json] [file...]
+
+bench times the solver, the rater, and the generator, so that
+changes to them can be checked for slowdowns.  Its corpus is a
+handful of built-in puzzles (from easy to ones that need
+guessing), plus the puzzles in the named files, without the
+built-in ones if -builtin=false.  Each round solves and rates
+every puzzle in the corpus, and generates -generate puzzles from
+fixed seeds, so the work is the same from run to run.  The
+report gives the time each of them took, the solver's search
+rate (in nodes per second, see puzzle.Progress), and how long
+the rater spent on each technique (see puzzle.RateTimed); with
+-json, it's written as a JSON benchReport, for comparing runs
+with scripts.  -cpuprofile and -memprofile write pprof profiles
+of the whole run.
+
+*/
+
+// benchCorpus is the built-in corpus, in the SDM format.
+const benchCorpus = `# susen-tool bench corpus
+0000020430200000
+090005300010200400070000800000020040000106000080070000008000010005003020006800070
+700006200030020000000008906006540001500000003800093400205100000000050080008300002
+004079020050100000010043500400000900600204008008000001009420050000005010070690400
+800000000003600000070090200050007000000045700000100030001000068008500010090000400
+100007090030020008009600500005300900010080002600004000300000010040000007007000300
+`
+
+// benchSeed is the seed the generated puzzles' seeds are made
+// from.
+const benchSeed = "bench"
+
+// A benchTiming is how long some work took, on how many puzzles.
+type benchTiming struct {
+	Puzzles int           `json:"puzzles"`
+	Time    time.Duration `json:"time"`
+}
+
+// A benchReport is the result of a benchmark run.
+type benchReport struct {
+	Corpus      int                    `json:"corpus"`
+	Rounds      int                    `json:"rounds"`
+	Solve       benchTiming            `json:"solve"`
+	Nodes       int                    `json:"nodes"`
+	NodesPerSec float64                `json:"nodesPerSecond"`
+	Rate        benchTiming            `json:"rate"`
+	Generate    benchTiming            `json:"generate"`
+	Techniques  []puzzle.TechniqueTime `json:"techniques"`
+}
+
+// bench runs the benchmark with the given arguments, returning
+// the exit status.
+func bench(args []string, in io.Reader, out, errOut io.Writer) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	formatName := flags.String("format", string(puzzle.SDMFormat), "text format: sdm, line, sdk, or grid")
+	builtin := flags.Bool("builtin", true, "include the built-in corpus")
+	rounds := flags.Int("rounds", 1, "number of times to go through the corpus")
+	generated := flags.Int("generate", 3, "number of puzzles to generate each round")
+	cpuProfile := flags.String("cpuprofile", "", "file to write a CPU profile to")
+	memProfile := flags.String("memprofile", "", "file to write a memory profile to")
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+	if e := flags.Parse(args); e != nil {
+		return 2
+	}
+	format, ok := puzzle.LookupTextFormat(*formatName)
+	if !ok {
+		fmt.Fprintf(errOut, "susen-tool: unknown format %q\n", *formatName)
+		return 2
+	}
+	if *rounds < 1 || *generated < 0 {
+		fmt.Fprintf(errOut, "susen-tool: bench needs at least 1 round, and can't generate fewer than 0 puzzles\n")
+		return 2
+	}
+
+	var corpus [][]int
+	if *builtin {
+		corpus, _ = puzzle.ParseText(benchCorpus, puzzle.SDMFormat)
+	}
+	if flags.NArg() > 0 {
+		puzzles, e := readPuzzles(flags.Args(), in, format)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		corpus = append(corpus, puzzles...)
+	}
+	if len(corpus) == 0 && *generated == 0 {
+		fmt.Fprintf(errOut, "susen-tool: there's nothing to benchmark\n")
+		return 2
+	}
+
+	if *cpuProfile != "" {
+		f, e := os.Create(*cpuProfile)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		defer f.Close()
+		if e := pprof.StartCPUProfile(f); e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		defer pprof.StopCPUProfile()
+	}
+	report, status := runBench(corpus, *rounds, *generated, errOut)
+	if *memProfile != "" {
+		f, e := os.Create(*memProfile)
+		if e == nil {
+			runtime.GC()
+			e = pprof.WriteHeapProfile(f)
+			f.Close()
+		}
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if e := enc.Encode(report); e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 1
+		}
+		return status
+	}
+	writeBenchReport(report, out)
+	return status
+}
+
+// runBench benchmarks the corpus for a number of rounds.  Puzzles
+// that can't be solved or rated are reported, and skipped, and the
+// returned status is 1 if there were any.
+func runBench(corpus [][]int, rounds, generated int, errOut io.Writer) (benchReport, int) {
+	report := benchReport{Corpus: len(corpus), Rounds: rounds}
+	status := 0
+	for round := 1; round <= rounds; round++ {
+		for i, vals := range corpus {
+			p, e := puzzle.New(vals)
+			if e != nil {
+				if round == 1 {
+					fmt.Fprintf(errOut, "susen-tool: puzzle %d: %v\n", i+1, e)
+				}
+				status = 1
+				continue
+			}
+			var progress puzzle.Progress
+			began := time.Now()
+			_, e = puzzle.SolveWatched(p, func(pr puzzle.Progress) bool {
+				progress = pr
+				return true
+			})
+			report.Solve.Time += time.Since(began)
+			if e != nil {
+				if round == 1 {
+					fmt.Fprintf(errOut, "susen-tool: puzzle %d: %v\n", i+1, e)
+				}
+				status = 1
+				continue
+			}
+			report.Solve.Puzzles++
+			report.Nodes += progress.Nodes
+
+			began = time.Now()
+			_, times, e := puzzle.RateTimed(p, puzzle.RatingProfiles[0])
+			report.Rate.Time += time.Since(began)
+			if e != nil {
+				if round == 1 {
+					fmt.Fprintf(errOut, "susen-tool: puzzle %d: %v\n", i+1, e)
+				}
+				status = 1
+				continue
+			}
+			report.Rate.Puzzles++
+			report.addTechniqueTimes(times)
+		}
+		for n := 1; n <= generated; n++ {
+			began := time.Now()
+			_, e := puzzle.Generate(puzzle.GenerateParams{Seed: benchSeed + "-" + strconv.Itoa(n), SideLength: 9})
+			report.Generate.Time += time.Since(began)
+			if e != nil {
+				fmt.Fprintf(errOut, "susen-tool: generate: %v\n", e)
+				return report, 2
+			}
+			report.Generate.Puzzles++
+		}
+	}
+	if secs := report.Solve.Time.Seconds(); secs > 0 {
+		report.NodesPerSec = float64(report.Nodes) / secs
+	}
+	return report, status
+}
+
+// addTechniqueTimes adds a rating's technique times to the
+// report's.
+func (report *benchReport) addTechniqueTimes(times []puzzle.TechniqueTime) {
+	if report.Techniques == nil {
+		report.Techniques = make([]puzzle.TechniqueTime, len(times))
+	}
+	for i, t := range times {
+		total := &report.Techniques[i]
+		total.Technique = t.Technique
+		total.Tries += t.Tries
+		total.Uses += t.Uses
+		total.Time += t.Time
+	}
+}
+
+// writeBenchReport writes a benchmark report as a table.
+func writeBenchReport(report benchReport, out io.Writer) {
+	fmt.Fprintf(out, "corpus: %d puzzles, %d rounds\n", report.Corpus, report.Rounds)
+	for _, line := range []struct {
+		name   string
+		timing benchTiming
+	}{{"solve", report.Solve}, {"rate", report.Rate}, {"generate", report.Generate}} {
+		fmt.Fprintf(out, "%-9s %5d puzzles in %v", line.name+":", line.timing.Puzzles, line.timing.Time)
+		if line.timing.Puzzles > 0 {
+			fmt.Fprintf(out, " (%v each)", line.timing.Time/time.Duration(line.timing.Puzzles))
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "search:   %d nodes, %.0f nodes/second\n", report.Nodes, report.NodesPerSec)
+	if len(report.Techniques) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\n%-24s %8s %8s %14s %12s\n", "technique", "tries", "uses", "time", "per try")
+	for _, t := range report.Techniques {
+		perTry := time.Duration(0)
+		if t.Tries > 0 {
+			perTry = t.Time / time.Duration(t.Tries)
+		}
+		fmt.Fprintf(out, "%-24s %8d %8d %14v %12v\n", t.Technique, t.Tries, t.Uses, t.Time, perTry)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	status, table, _ := helperRun([]string{"bench", "-generate", "1"}, "")
+	if status != 0 || !strings.HasPrefix(table, "corpus: 6 puzzles, 1 rounds\n") ||
+		!strings.Contains(table, "generate:     1 puzzles") || !strings.Contains(table, "hidden single") {
+		t.Errorf("bench gave %d, %q", status, table)
+	}
+
+	// user puzzles are added to the corpus, or replace it, and
+	// the ones that can't be handled are reported
+	dir := t.TempDir()
+	file, profile := filepath.Join(dir, "extra.sdm"), filepath.Join(dir, "cpu.prof")
+	if e := ioutil.WriteFile(file, []byte("1000000000000000\n1100000000000000\n"), 0644); e != nil {
+		t.Fatalf("Can't write puzzle file: %v", e)
+	}
+	status, text, log := helperRun([]string{"bench", "-builtin=false", "-rounds", "2", "-generate", "0",
+		"-json", "-cpuprofile", profile, file}, "")
+	var report benchReport
+	if e := json.Unmarshal([]byte(text), &report); e != nil {
+		t.Fatalf("bench -json gave %q: %v", text, e)
+	}
+	if status != 1 || !strings.Contains(log, "puzzle 2: ") || strings.Count(log, "\n") != 1 {
+		t.Errorf("bench of a bad puzzle gave %d, %q", status, log)
+	}
+	if report.Corpus != 2 || report.Rounds != 2 || report.Solve.Puzzles != 4 || report.Rate.Puzzles != 2 ||
+		report.Generate.Puzzles != 0 || report.Nodes == 0 || report.NodesPerSec <= 0 ||
+		len(report.Techniques) == 0 || report.Techniques[0].Tries == 0 {
+		t.Errorf("bench report is %+v", report)
+	}
+	if info, e := os.Stat(profile); e != nil || info.Size() == 0 {
+		t.Errorf("bench didn't write a CPU profile: %v", e)
+	}
+
+	if status, _, _ := helperRun([]string{"bench", "-builtin=false", "-generate", "0"}, ""); status != 2 {
+		t.Errorf("bench of nothing gave status %d", status)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+
+susen-tool
+
+susen-tool does batch work with the puzzle package, without the
+web server.  Each subcommand reads or writes puzzles in one of
+the text formats (see puzzle.ParseText), given by -format: sdm
+(the default, one puzzle per line), line, sdk, or grid (rows of
+squares, as pasted from a spreadsheet).
+
+	susen-tool solve [-format f] [file...]
+	susen-tool rate [-format f] [-profile p] [file...]
+	susen-tool validate [-format f] [file...]
+	susen-tool generate [-format f] [-seed s] [-sidelen n] [-givens n | -minimize] [-count n]
+	susen-tool play [-format f] [-seed s] [-givens n] [file]
+	susen-tool bench [-format f] [-builtin=false] [-rounds n] [-generate n] [-cpuprofile file] [-memprofile file] [-json] [file...]
+
+solve writes the solution of each proper puzzle, rate writes
+the star rating of each puzzle and the techniques it needs, and
+validate says whether each puzzle is proper (has exactly one
+solution).  rate uses the standard rating profile unless -profile
+names another (see puzzle.RatingProfiles).
+They read the named files, or the standard input if there are
+none.  generate writes new puzzles: with a seed and a count of
+more than one, the puzzles' seeds are the seed followed by "-1",
+"-2", and so on; without a seed, a random one is used.  No two
+of the puzzles are the same up to symmetry and relabeling of
+their digits (see puzzle.Equivalent): seeds that would repeat a
+puzzle are skipped.  Seeds are reported on the standard error,
+so the puzzles can be made again.  With -minimize, each puzzle
+is then made minimal, with the same seed (see puzzle.Minimize),
+which leaves fewer givens than the generator's symmetric
+puzzles, but takes longer; it can't be combined with -givens.
+play is a game in the terminal (see play.go), and bench times
+the solver, rater, and generator (see bench.go).
+
+The exit status is 0 if all went well, 1 if any of the puzzles
+couldn't be handled, and 2 if the command couldn't be run.
+
+*/
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// usage is the summary of the subcommands.
+const usage = `usage:
+	susen-tool solve [-format f] [file...]
+	susen-tool rate [-format f] [-profile p] [file...]
+	susen-tool validate [-format f] [file...]
+	susen-tool generate [-format f] [-seed s] [-sidelen n] [-givens n | -minimize] [-count n]
+	susen-tool play [-format f] [-seed s] [-givens n] [file]
+	susen-tool bench [-format f] [-builtin=false] [-rounds n] [-generate n] [-cpuprofile file] [-memprofile file] [-json] [file...]
+`
+
+// run runs the subcommand in args, returning the exit status.
+func run(args []string, in io.Reader, out, errOut io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(errOut, usage)
+		return 2
+	}
+	cmd := args[0]
+	switch cmd {
+	case "play":
+		return play(args[1:], in, out, errOut)
+	case "bench":
+		return bench(args[1:], in, out, errOut)
+	}
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	formatName := flags.String("format", string(puzzle.SDMFormat), "text format: sdm, line, sdk, or grid")
+	var params puzzle.GenerateParams
+	count, minimize := 1, false
+	if cmd == "generate" {
+		flags.StringVar(&params.Seed, "seed", "", "seed for the puzzles (random if not given)")
+		flags.IntVar(&params.SideLength, "sidelen", 9, "side length of the puzzles")
+		flags.IntVar(&params.Givens, "givens", 0, "least number of givens (0 for as few as possible)")
+		flags.IntVar(&count, "count", 1, "number of puzzles")
+		flags.BoolVar(&minimize, "minimize", false, "make the puzzles minimal")
+	}
+	var profileName string
+	if cmd == "rate" {
+		flags.StringVar(&profileName, "profile", "", "rating profile (standard if not given)")
+	}
+	if e := flags.Parse(args[1:]); e != nil {
+		return 2
+	}
+	format, ok := puzzle.LookupTextFormat(*formatName)
+	if !ok {
+		fmt.Fprintf(errOut, "susen-tool: unknown format %q\n", *formatName)
+		return 2
+	}
+
+	var handle func(n int, vals []int) ([]int, error)
+	switch cmd {
+	case "generate":
+		if flags.NArg() != 0 || count < 1 || (count > 1 && format != puzzle.SDMFormat) {
+			fmt.Fprintf(errOut, "susen-tool: generate takes no files, and only sdm holds more than 1 puzzle\n")
+			return 2
+		}
+		if minimize && params.Givens != 0 {
+			fmt.Fprintf(errOut, "susen-tool: generate can't both minimize and keep a number of givens\n")
+			return 2
+		}
+		return generate(params, count, minimize, format, out, errOut)
+	case "solve":
+		handle = func(n int, vals []int) ([]int, error) {
+			return solve(vals)
+		}
+	case "rate":
+		profile, e := puzzle.FindRatingProfile(profileName)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		handle = func(n int, vals []int) ([]int, error) {
+			return nil, rate(n, vals, profile, out)
+		}
+	case "validate":
+		handle = func(n int, vals []int) ([]int, error) {
+			return nil, validate(n, vals, out)
+		}
+	default:
+		fmt.Fprintf(errOut, "susen-tool: unknown command %q\n%s", cmd, usage)
+		return 2
+	}
+
+	puzzles, e := readPuzzles(flags.Args(), in, format)
+	if e != nil {
+		fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+		return 2
+	}
+	status := 0
+	var results [][]int
+	for i, vals := range puzzles {
+		result, e := handle(i+1, vals)
+		if e != nil {
+			if e != errReported {
+				fmt.Fprintf(errOut, "susen-tool: puzzle %d: %v\n", i+1, e)
+			}
+			status = 1
+			continue
+		}
+		if result != nil {
+			results = append(results, result)
+		}
+	}
+	if len(results) > 0 {
+		if e := writePuzzles(results, format, out); e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 1
+		}
+	}
+	return status
+}
+
+// readPuzzles reads the puzzles in the named files, or in the
+// input if there are no files.
+func readPuzzles(files []string, in io.Reader, format puzzle.TextFormat) ([][]int, error) {
+	if len(files) == 0 {
+		text, e := ioutil.ReadAll(in)
+		if e != nil {
+			return nil, e
+		}
+		return puzzle.ParseText(string(text), format)
+	}
+	var puzzles [][]int
+	for _, file := range files {
+		text, e := ioutil.ReadFile(file)
+		if e != nil {
+			return nil, e
+		}
+		ps, e := puzzle.ParseText(string(text), format)
+		if e != nil {
+			return nil, fmt.Errorf("%s: %v", file, e)
+		}
+		puzzles = append(puzzles, ps...)
+	}
+	return puzzles, nil
+}
+
+// writePuzzles writes puzzles in a format.  Formats that only
+// hold one puzzle get one after the other, separated by blank
+// lines in the SDK and grid formats.
+func writePuzzles(puzzles [][]int, format puzzle.TextFormat, out io.Writer) error {
+	if format == puzzle.SDMFormat {
+		text, e := puzzle.WriteText(puzzles, format)
+		if e == nil {
+			_, e = io.WriteString(out, text)
+		}
+		return e
+	}
+	for i, vals := range puzzles {
+		text, e := puzzle.WriteText([][]int{vals}, format)
+		if e != nil {
+			return e
+		}
+		if i > 0 && (format == puzzle.SDKFormat || format == puzzle.GridFormat) {
+			text = "\n" + text
+		}
+		if _, e := io.WriteString(out, text); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// errReported is the error for puzzles whose problems have
+// already been written out.
+var errReported = fmt.Errorf("already reported")
+
+// solve returns the solution of a proper puzzle, with its
+// geometry code.
+func solve(vals []int) ([]int, error) {
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return nil, e
+	}
+	solutions := p.Solutions()
+	switch len(solutions) {
+	case 0:
+		return nil, fmt.Errorf("no solution")
+	case 1:
+	default:
+		return nil, fmt.Errorf("%d solutions", len(solutions))
+	}
+	return append([]int{vals[0]}, solutions[0].Values...), nil
+}
+
+// rate writes the rating of a puzzle under a profile.
+func rate(n int, vals []int, profile puzzle.RatingProfile, out io.Writer) error {
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return e
+	}
+	rating, e := puzzle.RateWith(p, profile)
+	if e != nil {
+		return e
+	}
+	var used []string
+	for _, t := range rating.Techniques {
+		used = append(used, t.Technique+" "+strconv.Itoa(t.Count))
+	}
+	_, e = fmt.Fprintf(out, "%d: %d stars (%s)\n", n, rating.Stars, strings.Join(used, ", "))
+	return e
+}
+
+// validate writes whether a puzzle is proper.  Improper puzzles
+// are (reported) errors.
+func validate(n int, vals []int, out io.Writer) error {
+	p, e := puzzle.New(vals)
+	if e == nil {
+		e = p.IsProper()
+	}
+	if e != nil {
+		fmt.Fprintf(out, "%d: %v\n", n, e)
+		return errReported
+	}
+	_, e = fmt.Fprintf(out, "%d: ok\n", n)
+	return e
+}
+
+// generateTries is how many seeds generate tries, for each
+// puzzle it writes, before giving up on finding puzzles that
+// differ from the ones it already has.
+const generateTries = 10
+
+// generate writes count generated puzzles, minimized if asked,
+// no two of which are the same up to symmetry and relabeling
+// (see puzzle.Equivalent).
+func generate(params puzzle.GenerateParams, count int, minimize bool, format puzzle.TextFormat, out, errOut io.Writer) int {
+	if params.Seed == "" {
+		params.Seed = randomSeed()
+	}
+	seed := params.Seed
+	var puzzles [][]int
+	forms := make(map[string]bool)
+	for n := 1; len(puzzles) < count; n++ {
+		if n > count*generateTries {
+			fmt.Fprintf(errOut, "susen-tool: only found %d different puzzles\n", len(puzzles))
+			return 2
+		}
+		if count > 1 {
+			params.Seed = seed + "-" + strconv.Itoa(n)
+		}
+		g, e := puzzle.Generate(params)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		if minimize {
+			p, e := puzzle.New(g.Values)
+			if e == nil {
+				g.Values, e = puzzle.Minimize(p, g.Seed)
+			}
+			if e != nil {
+				fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+				return 2
+			}
+		}
+		form, e := puzzle.CanonicalFingerprint(g.Values)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		if forms[form] {
+			fmt.Fprintf(errOut, "skipped seed %s: same as an earlier puzzle\n", g.Seed)
+			continue
+		}
+		forms[form] = true
+		puzzles = append(puzzles, g.Values)
+		fmt.Fprintf(errOut, "puzzle %d: seed %s\n", len(puzzles), g.Seed)
+	}
+	if e := writePuzzles(puzzles, format, out); e != nil {
+		fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+		return 1
+	}
+	return 0
+}
+
+// seedSource is where random generation seeds come from (see
+// puzzle.NewSeed).  Tests can replace it.
+var seedSource io.Reader = rand.Reader
+
+// randomSeed returns a random generation seed.
+func randomSeed() string {
+	seed, e := puzzle.NewSeed(seedSource)
+	if e != nil {
+		panic(e)
+	}
+	return seed
+}
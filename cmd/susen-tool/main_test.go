@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// helperRun runs a subcommand, returning its status and output.
+func helperRun(args []string, in string) (int, string, string) {
+	var out, errOut bytes.Buffer
+	status := run(args, strings.NewReader(in), &out, &errOut)
+	return status, out.String(), errOut.String()
+}
+
+func TestGenerateAndSolve(t *testing.T) {
+	status, sdm, log := helperRun([]string{"generate", "-seed", "batch", "-count", "3", "-sidelen", "4"}, "")
+	if status != 0 || strings.Count(sdm, "\n") != 3 || !strings.Contains(log, "puzzle 3: seed 1:batch-3") {
+		t.Fatalf("generate gave %d, %q, %q", status, sdm, log)
+	}
+	if status, again, _ := helperRun([]string{"generate", "-seed", "batch", "-count", "3", "-sidelen", "4"}, ""); status != 0 || again != sdm {
+		t.Errorf("generate isn't reproducible: %q then %q", sdm, again)
+	}
+
+	// the puzzles are all different, even up to symmetry
+	generated, _ := puzzle.ParseText(sdm, puzzle.SDMFormat)
+	for i := range generated {
+		for j := i + 1; j < len(generated); j++ {
+			if same, _ := puzzle.Equivalent(generated[i], generated[j]); same {
+				t.Errorf("generate gave equivalent puzzles %d and %d: %q", i+1, j+1, sdm)
+			}
+		}
+	}
+
+	// minimizing never leaves more givens, and is reproducible
+	status, minimal, _ := helperRun([]string{"generate", "-seed", "batch", "-count", "3", "-sidelen", "4", "-minimize"}, "")
+	if status != 0 || strings.Count(minimal, "\n") != 3 || strings.Count(minimal, "0") < strings.Count(sdm, "0") {
+		t.Errorf("generate -minimize gave %d, %q", status, minimal)
+	}
+	if _, again, _ := helperRun([]string{"generate", "-seed", "batch", "-count", "3", "-sidelen", "4", "-minimize"}, ""); again != minimal {
+		t.Errorf("generate -minimize isn't reproducible: %q then %q", minimal, again)
+	}
+	if status, validated, _ := helperRun([]string{"validate"}, minimal); status != 0 || validated != "1: ok\n2: ok\n3: ok\n" {
+		t.Errorf("validate of minimized puzzles gave %d, %q", status, validated)
+	}
+
+	status, validated, _ := helperRun([]string{"validate"}, sdm)
+	if status != 0 || validated != "1: ok\n2: ok\n3: ok\n" {
+		t.Errorf("validate gave %d, %q", status, validated)
+	}
+	status, solved, _ := helperRun([]string{"solve"}, sdm)
+	if status != 0 || strings.Count(solved, "\n") != 3 || strings.Contains(solved, "0") {
+		t.Errorf("solve gave %d, %q", status, solved)
+	}
+
+	// input can come from files, in other formats
+	file := filepath.Join(t.TempDir(), "one.sdk")
+	_, sdk, _ := helperRun([]string{"generate", "-seed", "file", "-format", "sdk"}, "")
+	if e := ioutil.WriteFile(file, []byte(sdk), 0644); e != nil {
+		t.Fatalf("Can't write puzzle file: %v", e)
+	}
+	status, rated, _ := helperRun([]string{"rate", "-format", "sdk", file}, "")
+	if status != 0 || !strings.HasPrefix(rated, "1: ") || !strings.Contains(rated, " stars (hidden single ") {
+		t.Errorf("rate gave %d, %q", status, rated)
+	}
+	status, rated, _ = helperRun([]string{"rate", "-format", "sdk", "-profile", "singles", file}, "")
+	if status != 0 || strings.Contains(rated, "locked") || strings.Contains(rated, "pair") {
+		t.Errorf("rate with the singles profile gave %d, %q", status, rated)
+	}
+	status, solved, _ = helperRun([]string{"solve", "-format", "sdk", file}, "")
+	if status != 0 || strings.Count(solved, "\n") != 9 || strings.Contains(solved, ".") {
+		t.Errorf("solve of an SDK file gave %d, %q", status, solved)
+	}
+
+	// grids can be pasted from spreadsheets
+	var rows []string
+	for _, row := range strings.Fields(sdk) {
+		rows = append(rows, strings.Join(strings.Split(row, ""), "\t"))
+	}
+	status, solved, _ = helperRun([]string{"solve", "-format", "grid"}, strings.Join(rows, "\n"))
+	if status != 0 || strings.Count(solved, "\n") != 9 || strings.Contains(solved, ".") {
+		t.Errorf("solve of a grid gave %d, %q", status, solved)
+	}
+}
+
+func TestProblems(t *testing.T) {
+	improper := "1" + strings.Repeat("0", 15) + "\n"
+	status, out, log := helperRun([]string{"validate"}, "1234341221434321\n"+improper)
+	if status != 1 || !strings.HasPrefix(out, "1: ok\n2: ") || log != "" {
+		t.Errorf("validate of an improper puzzle gave %d, %q, %q", status, out, log)
+	}
+	if status, out, log := helperRun([]string{"solve"}, improper); status != 1 || out != "" ||
+		!strings.Contains(log, "puzzle 1: ") {
+		t.Errorf("solve of an improper puzzle gave %d, %q, %q", status, out, log)
+	}
+	for _, args := range [][]string{
+		nil,
+		{"bogus"},
+		{"solve", "-format", "csv"},
+		{"solve", "-bogus"},
+		{"generate", "-count", "2", "-format", "sdk"},
+		{"generate", "-sidelen", "5"},
+		{"generate", "-minimize", "-givens", "30"},
+		{"rate", "no-such-file"},
+		{"rate", "-profile", "bogus"},
+	} {
+		if status, _, _ := helperRun(args, ""); status != 2 {
+			t.Errorf("%v gave status %d", args, status)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/*
+
+Playing in the terminal
+
+	susen-tool play [-format f] [-seed s] [-givens n] [file]
+
+play is a game in the terminal, driven directly by the puzzle
+package: it plays the first puzzle in the file, or a generated
+one if there's no file.  The arrow keys (or h, j, k, and l) move
+around the grid, a value's key fills the square it's on, u takes
+back the last move, ? points to the square a hint would fill
+(and says how to find its value), and q quits.  The terminal is
+put in character mode (with stty) while the game is on.
+
+*/
+
+// playKeys are the keys for values, in value order from 1.
+const playKeys = "123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// A game is a puzzle being played, with its step history.
+type game struct {
+	givens  []int
+	steps   []puzzle.Puzzle
+	sidelen int
+	cursor  int // the index of the square the cursor is on, from 0
+	moves   int
+	message string
+}
+
+// newGame starts a game of a puzzle.
+func newGame(givens []int) (*game, error) {
+	p, e := puzzle.New(givens)
+	if e != nil {
+		return nil, e
+	}
+	return &game{givens: givens, steps: []puzzle.Puzzle{p}, sidelen: p.State().SideLenth}, nil
+}
+
+// current returns the puzzle in its current state.
+func (g *game) current() puzzle.Puzzle {
+	return g.steps[len(g.steps)-1]
+}
+
+// handle handles a key, and tells whether the game is over.
+func (g *game) handle(key string) bool {
+	g.message = ""
+	row, col := g.cursor/g.sidelen, g.cursor%g.sidelen
+	switch key {
+	case "q", "\x03":
+		return true
+	case "up", "k":
+		row = (row + g.sidelen - 1) % g.sidelen
+	case "down", "j":
+		row = (row + 1) % g.sidelen
+	case "left", "h":
+		col = (col + g.sidelen - 1) % g.sidelen
+	case "right", "l":
+		col = (col + 1) % g.sidelen
+	case "u":
+		if len(g.steps) == 1 {
+			g.message = "There's nothing to take back."
+		} else {
+			g.steps = g.steps[:len(g.steps)-1]
+		}
+	case "?":
+		hint, e := puzzle.Suggest(g.current())
+		if e != nil {
+			g.message = "No hint: " + e.Error()
+			break
+		}
+		g.cursor = hint.Choice.Index - 1
+		g.message = "Try this square (" + hint.Technique + ")."
+		return false
+	default:
+		v := strings.Index(playKeys, strings.ToUpper(key)) + 1
+		if len(key) != 1 || v == 0 || v > g.sidelen {
+			g.message = fmt.Sprintf("Unknown key %q.", key)
+			break
+		}
+		next := g.current().Copy()
+		update, e := next.Assign(puzzle.Choice{Index: g.cursor + 1, Value: v})
+		if e != nil {
+			g.message = e.Error()
+			break
+		}
+		g.steps = append(g.steps, next)
+		g.moves++
+		if len(update.Errors) > 0 {
+			g.message = "That doesn't work: " + update.Errors[0].Error() + "  (u takes it back.)"
+		} else if update.Solved {
+			g.message = fmt.Sprintf("Solved in %d moves!  (q quits.)", g.moves)
+		}
+	}
+	g.cursor = row*g.sidelen + col
+	return false
+}
+
+// render draws the game on an ANSI terminal.
+func (g *game) render(w io.Writer) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	tile := tileSide(g.sidelen)
+	border := "+" + strings.Repeat(strings.Repeat("-", 2*tile+1)+"+", g.sidelen/tile) + "\r\n"
+	for i, v := range g.current().State().Values {
+		row, col := i/g.sidelen, i%g.sidelen
+		if col == 0 {
+			if row%tile == 0 {
+				b.WriteString(border)
+			}
+			b.WriteString("|")
+		}
+		s := "."
+		if v > 0 {
+			s = string(playKeys[v-1])
+		}
+		if g.givens[i+1] != 0 {
+			s = "\x1b[1m" + s + "\x1b[0m"
+		}
+		if i == g.cursor {
+			s = "\x1b[7m" + s + "\x1b[0m"
+		}
+		b.WriteString(" " + s)
+		if (col+1)%tile == 0 {
+			b.WriteString(" |")
+		}
+		if col == g.sidelen-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(border)
+	b.WriteString("arrows/hjkl move, 1-" + string(playKeys[g.sidelen-1]) + " fill, u undo, ? hint, q quit\r\n")
+	b.WriteString(g.message + "\r\n")
+	io.WriteString(w, b.String())
+}
+
+// tileSide returns the side length of a Sudoku puzzle's tiles.
+func tileSide(sidelen int) int {
+	for t := 1; t*t <= sidelen; t++ {
+		if t*t == sidelen {
+			return t
+		}
+	}
+	return sidelen
+}
+
+// readKey reads a key press, naming the arrow keys.
+func readKey(r *bufio.Reader) (string, error) {
+	c, e := r.ReadByte()
+	if e != nil || c != '\x1b' {
+		return string(c), e
+	}
+	if c, e = r.ReadByte(); e != nil || c != '[' {
+		return "\x1b", e
+	}
+	c, e = r.ReadByte()
+	arrows := map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left"}
+	if name, ok := arrows[c]; ok {
+		return name, e
+	}
+	return "\x1b[" + string(c), e
+}
+
+// play runs the play subcommand.
+func play(args []string, in io.Reader, out, errOut io.Writer) int {
+	flags := flag.NewFlagSet("play", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	formatName := flags.String("format", string(puzzle.SDMFormat), "text format: sdm, line, sdk, or grid")
+	var params puzzle.GenerateParams
+	flags.StringVar(&params.Seed, "seed", "", "seed for a generated puzzle (random if not given)")
+	flags.IntVar(&params.Givens, "givens", 30, "least number of givens of a generated puzzle")
+	if e := flags.Parse(args); e != nil {
+		return 2
+	}
+	format, ok := puzzle.LookupTextFormat(*formatName)
+	if !ok || flags.NArg() > 1 {
+		fmt.Fprintf(errOut, "susen-tool: play takes a puzzle file in a known format\n")
+		return 2
+	}
+	var givens []int
+	if flags.NArg() == 1 {
+		puzzles, e := readPuzzles(flags.Args(), nil, format)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		givens = puzzles[0]
+	} else {
+		if params.Seed == "" {
+			params.Seed = randomSeed()
+		}
+		gen, e := puzzle.Generate(params)
+		if e != nil {
+			fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+			return 2
+		}
+		givens = gen.Values
+		defer fmt.Fprintf(errOut, "puzzle seed %s\n", gen.Seed)
+	}
+	g, e := newGame(givens)
+	if e != nil {
+		fmt.Fprintf(errOut, "susen-tool: %v\n", e)
+		return 1
+	}
+	if f, ok := in.(*os.File); ok {
+		if restore := characterMode(f); restore != nil {
+			defer restore()
+		}
+	}
+	keys := bufio.NewReader(in)
+	for {
+		g.render(out)
+		key, e := readKey(keys)
+		if e != nil || g.handle(key) {
+			break
+		}
+	}
+	fmt.Fprint(out, "\r\n")
+	return 0
+}
+
+// characterMode puts a terminal in character mode, without echo,
+// and returns the function that puts it back.  It returns nil if
+// the file isn't a terminal, or its mode can't be changed.
+func characterMode(f *os.File) func() {
+	if info, e := f.Stat(); e != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	stty := func(args ...string) error {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = f
+		return cmd.Run()
+	}
+	if stty("cbreak", "-echo") != nil {
+		return nil
+	}
+	return func() { stty("-cbreak", "echo") }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"strings"
+	"testing"
+)
+
+func TestGame(t *testing.T) {
+	gen, _ := puzzle.Generate(puzzle.GenerateParams{Seed: "play", SideLength: 4, Givens: 6})
+	g, e := newGame(gen.Values)
+	if e != nil {
+		t.Fatalf("Can't start game: %v", e)
+	}
+	p, _ := puzzle.New(gen.Values)
+	solution := p.Solutions()[0].Values
+
+	// moving wraps around the grid
+	for _, key := range []string{"up", "left", "h", "down", "j", "right"} {
+		g.handle(key)
+	}
+	if g.cursor != 7 {
+		t.Errorf("Cursor is at %d after moving", g.cursor)
+	}
+	if g.handle("?"); !strings.HasPrefix(g.message, "Try this square (") || gen.Values[g.cursor+1] != 0 {
+		t.Errorf("Hint put the cursor on %d, said %q", g.cursor, g.message)
+	}
+	wrong := solution[g.cursor]%4 + 1
+	if g.handle(string(playKeys[wrong-1])); len(g.steps) != 2 || !strings.HasPrefix(g.message, "That doesn't work") {
+		t.Errorf("Wrong entry gave %d steps, %q", len(g.steps), g.message)
+	}
+	if g.handle("u"); len(g.steps) != 1 {
+		t.Errorf("Undo left %d steps", len(g.steps))
+	}
+	if g.handle("u"); g.message != "There's nothing to take back." {
+		t.Errorf("Undo at the start said %q", g.message)
+	}
+	if g.handle("7"); len(g.steps) != 1 || g.message != `Unknown key "7".` {
+		t.Errorf("Out of range entry gave %d steps, %q", len(g.steps), g.message)
+	}
+	for i := range solution {
+		if gen.Values[i+1] == 0 {
+			g.cursor = i
+			g.handle(string(playKeys[solution[i]-1]))
+		}
+	}
+	if !strings.HasPrefix(g.message, "Solved in ") {
+		t.Errorf("Filling the puzzle said %q", g.message)
+	}
+	var b strings.Builder
+	g.render(&b)
+	if screen := b.String(); strings.Count(screen, "+-----+-----+") != 3 || strings.Contains(screen, " .") {
+		t.Errorf("Solved game is drawn as %q", screen)
+	}
+	if !g.handle("q") {
+		t.Errorf("q didn't quit")
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	keys := bufio.NewReader(strings.NewReader("5\x1b[A\x1b[Dq"))
+	for _, want := range []string{"5", "up", "left", "q"} {
+		if key, e := readKey(keys); e != nil || key != want {
+			t.Errorf("readKey gave %q, %v; want %q", key, e, want)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	status, screen, log := helperRun([]string{"play", "-seed", "keys"}, "l?q")
+	if status != 0 || strings.Count(screen, "\x1b[2J") != 3 || !strings.Contains(log, "puzzle seed 1:keys") {
+		t.Errorf("play gave %d, %q, %q", status, screen, log)
+	}
+	if status, _, _ := helperRun([]string{"play", "a", "b"}, ""); status != 2 {
+		t.Errorf("play of two files gave status %d", status)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/*
+
+Accounts
+
+Players can make accounts, so that their puzzles and history
+follow them from browser to browser.  Each identified user
+(whether identified by an account or by a login proxy) has one
+session, shared by all their browsers; the first browser they
+log in from brings its session along.  Anonymous browsers keep
+their cookie sessions.  Players can also sign in through GitHub
+or Google (see oauth.go).
+
+Accounts are kept in the store named by SUSEN_STORE (see
+storage.New), or else by DATABASE_URL, as Heroku Postgres sets
+it.  Without either, the store is in memory, so set one of them
+if accounts should outlast the server.  SQL stores bring their
+schemas up to date when the server starts (see storage.NewSQL),
+and Postgres needs a binary built with the postgres tag, which
+links its driver (see postgres.go).  (Reports can read from a
+replica of the store: see replica.go.)
+
+*/
+
+var (
+	store    storage.Store = monitoredStore{faultyStore{storage.NewMemory()}}
+	accounts               = auth.NewAccounts(store)
+)
+
+// storeDSN returns the data source name of the configured store.
+func storeDSN() string {
+	if dsn := os.Getenv("SUSEN_STORE"); dsn != "" {
+		return dsn
+	}
+	return os.Getenv("DATABASE_URL")
+}
+
+// openStore switches to the store configured in the environment.
+func openStore() {
+	s, e := storage.New(storeDSN())
+	if e != nil {
+		log.Fatalf("Can't open store: %v", e)
+	}
+	store = monitoredStore{faultyStore{s}}
+	accounts = auth.NewAccounts(store)
+	oauth = auth.NewOAuth(accounts, oauthProviders(os.Getenv)...)
+}
+
+// userSession returns the session of an identified user.  If
+// the user doesn't have one yet, the given browser session
+// becomes theirs; if they do, and the browser session has been
+// played, it's offered for merging (see merge.go).  A browser
+// session that's already another user's is never theirs: the
+// user gets a fresh session instead, and the browser's cookie no
+// longer leads to the other user's.  If the user's session can't
+// be claimed (see leases.go), it returns nil, with the lease or
+// error that refuses it.
+func userSession(session *susenSession, user *auth.User) (*susenSession, *sessionLease, error) {
+	key := "user:" + user.Key()
+	session.infoMutex.Lock()
+	other := session.user != nil && session.user.Key() != user.Key()
+	session.infoMutex.Unlock()
+	if other {
+		sessionMutex.Lock()
+		if sessions[session.sessionID] == session {
+			delete(sessions, session.sessionID)
+		}
+		sessionMutex.Unlock()
+		proto := strings.SplitN(session.sessionID, "-", 2)[0]
+		session = newSession(newSessionID(proto))
+	}
+	us, held, e := claimUserSession(key, session)
+	if us == nil {
+		return nil, held, e
+	}
+	if !other && us != session && session.currentBoard() != us.currentBoard() && session.worthMerging() {
+		us.offerMerge(session)
+	}
+	return us, nil, nil
+}
+
+// accountRequest is the body of register and login requests.
+type accountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// accountInfo is the response to account requests.  The token
+// is only sent on register and login.
+type accountInfo struct {
+	User  *auth.User `json:"user"`
+	Token string     `json:"token,omitempty"`
+}
+
+// setLoginCookie sets the login cookie, with a login token.
+func setLoginCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, sessionCookie.apply(&http.Cookie{
+		Name:     auth.TokenCookie,
+		Value:    token,
+		Path:     cookiePath,
+		MaxAge:   int(auth.TokenLifetime.Seconds()),
+		HttpOnly: true,
+	}, r))
+}
+
+// accountHandler handles the account endpoints:
+//
+// - POST /api/account/register makes an account and logs in
+//
+// - POST /api/account/login logs in
+//
+// - POST /api/account/logout logs out
+//
+// - GET /api/account/ gives the logged-in user (if any)
+//
+// Logging in sets the login cookie, and also returns the token
+// for clients that would rather send it in a header.
+func (session *susenSession) accountHandler(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled("accounts") {
+		featureOff(w, "accounts")
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/account/"), "/")
+	if r.Method != "POST" {
+		sendJSON(w, http.StatusOK, accountInfo{User: auth.FromRequest(r)})
+		return
+	}
+	switch path {
+	case "register", "login":
+		var req accountRequest
+		if e := decodeRequest(w, r, &req); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid account request: "+e.Error()))
+			return
+		}
+		if path == "register" {
+			if _, e := accounts.Register(req.Username, req.Password); e != nil {
+				status := http.StatusBadRequest
+				if e == auth.ErrAccountExists {
+					status = http.StatusConflict
+				}
+				sendError(w, status, requestError(e.Error()))
+				return
+			}
+			log.Printf("Session %v registered account %q.", session.sessionID, strings.ToLower(req.Username))
+		}
+		user, token, e := accounts.Login(req.Username, req.Password)
+		if e != nil {
+			status := http.StatusInternalServerError
+			if e == auth.ErrLoginFailed {
+				status = http.StatusUnauthorized
+			}
+			sendError(w, status, requestError(e.Error()))
+			return
+		}
+		setLoginCookie(w, r, token)
+		if us, _, _ := userSession(session, user); us != nil {
+			us.setUser(user)
+		}
+		log.Printf("Session %v logged in as %v.", session.sessionID, user.Key())
+		sendJSON(w, http.StatusOK, accountInfo{User: user, Token: token})
+	case "logout":
+		if token, _ := auth.RequestToken(r); token != "" {
+			accounts.Logout(token)
+		}
+		// the browser goes back to being anonymous, so it
+		// mustn't keep the user's session
+		if c, e := r.Cookie(sessionCookie.Name); e == nil {
+			sid, _ := cookieSessionID(c.Value)
+			sessionMutex.Lock()
+			if sessions[sid] == session {
+				delete(sessions, sid)
+			}
+			sessionMutex.Unlock()
+		}
+		http.SetCookie(w, sessionCookie.apply(&http.Cookie{Name: auth.TokenCookie, Path: cookiePath, MaxAge: -1}, r))
+		log.Printf("Session %v logged out.", session.sessionID)
+		sendJSON(w, http.StatusOK, accountInfo{})
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown account operation: "+path))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// helperBrowser makes an HTTP client with its own cookies.
+func helperBrowser(t *testing.T) *http.Client {
+	jar, e := cookiejar.New(nil)
+	if e != nil {
+		t.Fatalf("Failed to create cookie jar: %v", e)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// helperAccountRequest makes an account request from a browser,
+// returning the status and (if successful) the response.
+func helperAccountRequest(t *testing.T, c *http.Client, srv *httptest.Server, op string, req *accountRequest) (int, accountInfo) {
+	var r *http.Response
+	var e error
+	if req == nil {
+		r, e = c.Get(srv.URL + "/api/account/")
+	} else {
+		body, _ := json.Marshal(req)
+		r, e = c.Post(srv.URL+"/api/account/"+op, "application/json", bytes.NewReader(body))
+	}
+	if e != nil {
+		t.Fatalf("Account %q request error: %v", op, e)
+	}
+	defer r.Body.Close()
+	var info accountInfo
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(&info); e != nil {
+			t.Fatalf("Account %q decode error: %v", op, e)
+		}
+	}
+	return r.StatusCode, info
+}
+
+// helperBrowserSession returns the cookie session of a browser.
+func helperBrowserSession(t *testing.T, c *http.Client, srv *httptest.Server) *susenSession {
+	u, _ := url.Parse(srv.URL)
+	for _, cookie := range c.Jar.Cookies(u) {
+		if cookie.Name == sessionCookie.Name {
+			sid, _ := cookieSessionID(cookie.Value)
+			sessionMutex.RLock()
+			defer sessionMutex.RUnlock()
+			return sessions[sid]
+		}
+	}
+	t.Fatalf("Browser has no session cookie")
+	return nil
+}
+
+func TestAccountSessions(t *testing.T) {
+	// this test makes sessions through the real session
+	// selection, so it has to clean them up afterwards
+	existing := make(map[string]bool)
+	sessionMutex.RLock()
+	for key := range sessions {
+		existing[key] = true
+	}
+	sessionMutex.RUnlock()
+	defer func() {
+		sessionMutex.Lock()
+		for key := range sessions {
+			if !existing[key] {
+				delete(sessions, key)
+			}
+		}
+		sessionMutex.Unlock()
+	}()
+
+	srv := httptest.NewServer(susenHandler(accounts))
+	defer srv.Close()
+	first, second := helperBrowser(t), helperBrowser(t)
+	creds := &accountRequest{"test-player", "password1"}
+
+	// the first browser picks a puzzle, then registers
+	r, e := first.Get(srv.URL + "/reset/3-star")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if status, info := helperAccountRequest(t, first, srv, "register", creds); status != http.StatusOK || info.User == nil || info.Token == "" {
+		t.Fatalf("Register gave %d, %+v", status, info)
+	}
+	if status, _ := helperAccountRequest(t, second, srv, "register", creds); status != http.StatusConflict {
+		t.Errorf("Second register gave status %d", status)
+	}
+	if status, _ := helperAccountRequest(t, second, srv, "login", &accountRequest{"test-player", "wrong"}); status != http.StatusUnauthorized {
+		t.Errorf("Login with wrong password gave status %d", status)
+	}
+
+	// the second browser logs in and gets the same puzzle
+	if status, info := helperAccountRequest(t, second, srv, "login", creds); status != http.StatusOK || info.User.ID != "test-player" {
+		t.Fatalf("Login gave %d, %+v", status, info)
+	}
+	if _, info := helperAccountRequest(t, second, srv, "", nil); info.User == nil || info.User.Key() != "account:test-player" {
+		t.Errorf("Logged-in browser is user %+v", info.User)
+	}
+	sessionMutex.RLock()
+	shared := sessions["user:account:test-player"]
+	sessionMutex.RUnlock()
+	if shared == nil || shared.puzzleID != "3-star" {
+		t.Fatalf("User session is %+v", shared)
+	}
+
+	// after logging out, the first browser is anonymous with a
+	// fresh session, but the second is still logged in
+	if status, _ := helperAccountRequest(t, first, srv, "logout", &accountRequest{}); status != http.StatusOK {
+		t.Errorf("Logout gave status %d", status)
+	}
+	if _, info := helperAccountRequest(t, first, srv, "", nil); info.User != nil {
+		t.Errorf("Logged-out browser is user %+v", info.User)
+	}
+	if session := helperBrowserSession(t, first, srv); session == shared || session.puzzleID != defaultPuzzleID {
+		t.Errorf("Logged-out browser kept the user's session")
+	}
+	if _, info := helperAccountRequest(t, second, srv, "", nil); info.User == nil {
+		t.Errorf("Other browser was logged out too")
+	}
+
+	// when the second browser logs in as someone else, they get
+	// their own session, and the first user's is left alone
+	other := &accountRequest{"test-player-2", "password2"}
+	if status, info := helperAccountRequest(t, second, srv, "register", other); status != http.StatusOK || info.User == nil {
+		t.Fatalf("Register as another user gave %d, %+v", status, info)
+	}
+	sessionMutex.RLock()
+	theirs := sessions["user:account:test-player-2"]
+	sessionMutex.RUnlock()
+	if theirs == nil || theirs == shared || theirs.puzzleID != defaultPuzzleID {
+		t.Errorf("Other user's session is %+v", theirs)
+	}
+	if shared.user == nil || shared.user.Key() != "account:test-player" {
+		t.Errorf("First user's session is now user %+v", shared.user)
+	}
+	if _, info := helperAccountRequest(t, second, srv, "", nil); info.User == nil || info.User.Key() != "account:test-player-2" {
+		t.Errorf("Browser logged in as someone else is user %+v", info.User)
+	}
+	if session := helperBrowserSession(t, second, srv); session == shared {
+		t.Errorf("Browser logged in as someone else kept the first user's session")
+	}
+}
+
+func TestStoreDSN(t *testing.T) {
+	t.Setenv("SUSEN_STORE", "")
+	t.Setenv("DATABASE_URL", "")
+	if dsn := storeDSN(); dsn != "" {
+		t.Errorf("Unconfigured store is %q", dsn)
+	}
+	t.Setenv("DATABASE_URL", "postgres://heroku/db")
+	if dsn := storeDSN(); dsn != "postgres://heroku/db" {
+		t.Errorf("Store with DATABASE_URL is %q", dsn)
+	}
+	t.Setenv("SUSEN_STORE", "dir:/var/lib/susen")
+	if dsn := storeDSN(); dsn != "dir:/var/lib/susen" {
+		t.Errorf("Store with SUSEN_STORE and DATABASE_URL is %q", dsn)
+	}
+}
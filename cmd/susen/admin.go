@@ -0,0 +1,397 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+
+Roles, catalog, and moderation
+
+Some endpoints change things for everyone, so they are limited to
+users with the right role: setters can add puzzles to the
+catalog, teachers can create classes, moderators can remove
+puzzles and close rooms, and admins can grant and revoke roles.  The first admins are named (by user
+key) in SUSEN_ADMINS, separated by commas.
+
+*/
+
+var (
+	roles        = auth.NewRoles()
+	catalogMutex sync.RWMutex // guards puzzleValues, catalogForms, and catalogMeta (see puzzles.go)
+	catalogForms map[string]string
+)
+
+// grantAdmins gives the admin role to the users named in the
+// environment.
+func grantAdmins() {
+	for _, key := range strings.Split(os.Getenv("SUSEN_ADMINS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			roles.Grant(key, auth.RoleAdmin)
+			log.Printf("User %v is an admin.", key)
+		}
+	}
+}
+
+// lookupPuzzle finds the values of the catalog puzzle with the
+// given ID.
+func lookupPuzzle(puzzleID string) ([]int, bool) {
+	catalogMutex.RLock()
+	defer catalogMutex.RUnlock()
+	vals, ok := puzzleValues[puzzleID]
+	return vals, ok
+}
+
+// generatedPuzzleID tells whether a puzzle ID names generated
+// puzzles (which have statistics and leaderboards of their own,
+// but aren't in the catalog).
+func generatedPuzzleID(puzzleID string) bool {
+	if puzzleID == blitzPuzzleID {
+		return true
+	}
+	if _, ok := parseSeedID(puzzleID); ok {
+		return true
+	}
+	_, ok := parseDailyID(puzzleID)
+	return ok
+}
+
+// knownPuzzleID tells whether a puzzle ID names a catalog puzzle
+// or generated puzzles.
+func knownPuzzleID(puzzleID string) bool {
+	if generatedPuzzleID(puzzleID) {
+		return true
+	}
+	_, ok := lookupPuzzle(puzzleID)
+	return ok
+}
+
+// catalogIDs lists the IDs of the catalog puzzles, in order.
+func catalogIDs() []string {
+	catalogMutex.RLock()
+	defer catalogMutex.RUnlock()
+	ids := make([]string, 0, len(puzzleValues))
+	for id := range puzzleValues {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sendCatalog sends the page of the catalog IDs that a request
+// asks for.
+func sendCatalog(w http.ResponseWriter, r *http.Request) {
+	if ids, ok := pageOfKeys(w, r, catalogIDs()); ok {
+		sendJSON(w, http.StatusOK, ids)
+	}
+}
+
+// addToCatalog adds a valid puzzle, with its metadata, to the
+// catalog under the metadata's ID, unless the ID is taken or the catalog already has the puzzle:
+// the same puzzle, or one that's the same up to symmetry and
+// relabeling of its digits (see puzzle.Canonical), so that the
+// catalog stays diverse.  It returns the ID of the puzzle in the
+// way, if there is one.  catalogForms indexes the catalog by
+// canonical fingerprint; it's built when first needed, and after
+// puzzles are removed.
+func addToCatalog(meta puzzleMeta, vals []int) string {
+	id := meta.ID
+	form, _ := puzzle.CanonicalFingerprint(vals)
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	if _, exists := puzzleValues[id]; exists || generatedPuzzleID(id) {
+		return id
+	}
+	if catalogForms == nil {
+		catalogForms = make(map[string]string, len(puzzleValues))
+		for other, ovals := range puzzleValues {
+			f, _ := puzzle.CanonicalFingerprint(ovals)
+			catalogForms[f] = other
+		}
+	}
+	if other, exists := catalogForms[form]; exists {
+		return other
+	}
+	puzzleValues[id] = vals
+	catalogForms[form] = id
+	catalogMeta[id] = meta
+	return ""
+}
+
+// catalogHandler handles the catalog endpoints:
+//
+// - GET /api/catalog/ lists the puzzle IDs
+//
+// - POST /api/catalog/<id> adds a puzzle (setters only); the
+// body is the puzzle's geometry code and values, or an object
+// with them and the puzzle's metadata (see puzzles.go), and the
+// puzzle must be proper; adding is metered as an import
+//
+// - POST /api/catalog/?format=<format>&prefix=<prefix> adds a
+// collection of puzzles in a text format (setters only), with
+// the tags in any tag parameters, and responds with what was
+// added
+//
+// - DELETE /api/catalog/<id> removes a puzzle (moderators only)
+//
+// The others respond with the list of puzzle IDs, a page at a
+// time (see paging.go).
+func catalogHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/catalog/"), "/")
+	switch r.Method {
+	case "POST":
+		auth.RequireRole(roles, auth.RoleSetter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id == "" && r.URL.Query().Get("format") != "" {
+				importCollection(w, r)
+				return
+			}
+			addCatalogPuzzle(w, r, id)
+		})).ServeHTTP(w, r)
+	case "DELETE":
+		auth.RequireRole(roles, auth.RoleModerator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			removeCatalogPuzzle(w, r, id)
+		})).ServeHTTP(w, r)
+	default:
+		sendCatalog(w, r)
+	}
+}
+
+// addCatalogPuzzle adds the puzzle in the request body to the
+// catalog.
+func addCatalogPuzzle(w http.ResponseWriter, r *http.Request, id string) {
+	if id == "" {
+		sendError(w, http.StatusBadRequest, requestError("A puzzle ID is required"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, nil), quotaImport) {
+		return
+	}
+	body, e := puzzle.ReadBody(r.Body, puzzle.MaxPuzzleBytes)
+	if e != nil {
+		sendError(w, puzzle.DecodingStatus(e), e.(puzzle.Error))
+		return
+	}
+	upload, e := decodeUpload(body)
+	if err, ok := e.(puzzle.Error); ok {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	} else if e != nil {
+		sendError(w, http.StatusBadRequest, requestError("Invalid puzzle values: "+e.Error()))
+		return
+	}
+	vals := upload.Values
+	p, e := puzzle.New(vals)
+	if e == nil {
+		e = p.IsProper()
+	}
+	if e != nil {
+		err, ok := e.(puzzle.Error)
+		if !ok {
+			err = requestError(e.Error())
+		}
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+	if other := addToCatalog(upload.meta(id, auth.FromRequest(r).Key()), vals); other == id {
+		sendError(w, http.StatusConflict, requestError("There is already a puzzle "+id))
+		return
+	} else if other != "" {
+		sendError(w, http.StatusConflict, requestError("Puzzle "+id+" is the same as puzzle "+other))
+		return
+	}
+	log.Printf("User %v added puzzle %q (fingerprint %s).",
+		auth.FromRequest(r).Key(), id, puzzle.Fingerprint(vals))
+	sendCatalog(w, r)
+}
+
+// removeCatalogPuzzle removes a puzzle from the catalog.
+// Sessions already playing it can keep going.  The default
+// puzzle can't be removed.
+func removeCatalogPuzzle(w http.ResponseWriter, r *http.Request, id string) {
+	if id == defaultPuzzleID {
+		sendError(w, http.StatusBadRequest, requestError("The default puzzle can't be removed"))
+		return
+	}
+	catalogMutex.Lock()
+	_, exists := puzzleValues[id]
+	delete(puzzleValues, id)
+	delete(catalogMeta, id)
+	catalogForms = nil
+	catalogMutex.Unlock()
+	if !exists {
+		sendError(w, http.StatusNotFound, requestError("No puzzle "+id))
+		return
+	}
+	log.Printf("User %v removed puzzle %q.", auth.FromRequest(r).Key(), id)
+	sendCatalog(w, r)
+}
+
+// modHandler handles the moderation endpoints, which are only
+// routed to for moderators:
+//
+// - POST /api/mod/close/<code> closes a room, giving each of its
+// members a private copy of the room's board
+func modHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/mod/")
+	if r.Method != "POST" || !strings.HasPrefix(path, "close/") {
+		sendError(w, http.StatusNotFound, requestError("Unknown moderation operation: "+path))
+		return
+	}
+	code := strings.ToUpper(strings.Trim(path[len("close/"):], "/"))
+	roomMutex.RLock()
+	room, ok := rooms[code]
+	roomMutex.RUnlock()
+	if !ok {
+		sendError(w, http.StatusNotFound, requestError("No room with code "+code))
+		return
+	}
+	room.board.mutex.Lock()
+	members := append([]*susenSession(nil), room.board.members...)
+	room.board.mutex.Unlock()
+	for _, member := range members {
+		member.leaveRoom()
+	}
+	log.Printf("User %v closed room %v.", auth.FromRequest(r).Key(), code)
+	sendJSON(w, http.StatusOK, roomInfo{Room: code})
+}
+
+// adminHandler handles the role management endpoints, which are
+// only routed to for admins:
+//
+// - GET /api/admin/roles/ gives the roles of every user who has
+// been granted any
+//
+// - GET /api/admin/roles/<key> gives the roles of a user
+//
+// - POST /api/admin/roles/<key>/<role> grants a role to a user
+//
+// - DELETE /api/admin/roles/<key>/<role> revokes a role
+//
+// - GET /api/admin/sessions/ lists the live sessions, a page at a
+// time (see paging.go)
+//
+// The /api/admin/config/ endpoints are handled by configHandler,
+// the /api/admin/selftest/ endpoints by selfTestHandler, the
+// /api/admin/alerts/ endpoint by alertsHandler, the
+// /api/admin/faults/ endpoints by faultsHandler, the
+// /api/admin/checkpoints/ endpoints by checkpointsHandler, the
+// /api/admin/expiry/ endpoints by expiryHandler, and the
+// /api/admin/footprint/ endpoints by footprintHandler.
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/admin/")
+	if strings.HasPrefix(path, "config") {
+		configHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "selftest") {
+		selfTestHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "alerts") {
+		alertsHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "faults") {
+		faultsHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "checkpoints") {
+		checkpointsHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "footprint") {
+		footprintHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "expiry") {
+		expiryHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "cookies") {
+		cookiesHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(path, "sessions") {
+		sessionsHandler(w, r)
+		return
+	}
+	if !strings.HasPrefix(path, "roles/") {
+		sendError(w, http.StatusNotFound, requestError("Unknown admin operation: "+path))
+		return
+	}
+	path = strings.Trim(path[len("roles/"):], "/")
+	if path == "" {
+		all := make(map[string][]auth.Role)
+		for _, key := range roles.Granted() {
+			all[key] = roles.Of(key)
+		}
+		sendJSON(w, http.StatusOK, all)
+		return
+	}
+	key, role := path, auth.Role("")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		key, role = path[:i], auth.Role(path[i+1:])
+	}
+	if r.Method == "POST" || r.Method == "DELETE" {
+		if !role.Valid() || role == auth.RolePlayer {
+			sendError(w, http.StatusBadRequest, requestError("Not a grantable role: "+string(role)))
+			return
+		}
+		if r.Method == "POST" {
+			roles.Grant(key, role)
+			log.Printf("User %v granted %v the %s role.", auth.FromRequest(r).Key(), key, role)
+		} else {
+			roles.Revoke(key, role)
+			log.Printf("User %v revoked the %s role from %v.", auth.FromRequest(r).Key(), role, key)
+		}
+	}
+	sendJSON(w, http.StatusOK, roles.Of(key))
+}
+
+// A sessionInfo describes a live session, by the key it's
+// selected with and the user it belongs to (if any).
+type sessionInfo struct {
+	Key  string `json:"key"`
+	User string `json:"user,omitempty"`
+}
+
+// sessionsHandler lists the live sessions, in order of their
+// keys.
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Sessions can only be listed"))
+		return
+	}
+	sessionMutex.RLock()
+	keys := make([]string, 0, len(sessions))
+	for key := range sessions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	page, ok := pageOfKeys(w, r, keys)
+	live := make([]*susenSession, len(page))
+	for i, key := range page {
+		live[i] = sessions[key]
+	}
+	sessionMutex.RUnlock()
+	if !ok {
+		return
+	}
+	infos := make([]sessionInfo, len(page))
+	for i, session := range live {
+		infos[i].Key = page[i]
+		session.infoMutex.Lock()
+		if session.user != nil {
+			infos[i].User = session.user.Key()
+		}
+		session.infoMutex.Unlock()
+	}
+	sendJSON(w, http.StatusOK, infos)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// helperUserRequest makes a request as the given user (or
+// anonymously, if the user is empty), returning the status.  If
+// the request succeeds, the response is decoded into out.
+func helperUserRequest(t *testing.T, srv *httptest.Server, user, method, path string, body, out interface{}) int {
+	var bs []byte
+	if body != nil {
+		bs, _ = json.Marshal(body)
+	}
+	req, e := http.NewRequest(method, srv.URL+path, bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Failed to make %s %s request: %v", method, path, e)
+	}
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	r, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatalf("%s %s request error: %v", method, path, e)
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusOK && out != nil {
+		if e := json.NewDecoder(r.Body).Decode(out); e != nil {
+			t.Fatalf("%s %s decode error: %v", method, path, e)
+		}
+	}
+	return r.StatusCode
+}
+
+func helperUserServer(session *susenSession) *httptest.Server {
+	return httptest.NewServer(auth.Middleware(auth.HeaderAuthenticator{UserHeader: "X-Test-User"},
+		http.HandlerFunc(session.rootHandler)))
+}
+
+func TestRoleManagement(t *testing.T) {
+	srv := helperUserServer(newSession("test-role-management"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+
+	if status := helperUserRequest(t, srv, "", "GET", "/api/admin/roles/", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Anonymous role listing gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "sam", "POST", "/api/admin/roles/header:sam/admin", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Self-promotion gave status %d", status)
+	}
+	var got []auth.Role
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/roles/header:sam/setter", nil, &got); status != http.StatusOK {
+		t.Fatalf("Grant gave status %d", status)
+	}
+	defer roles.Revoke("header:sam", auth.RoleSetter)
+	if !reflect.DeepEqual(got, []auth.Role{auth.RolePlayer, auth.RoleSetter}) {
+		t.Errorf("Grant gave roles %v", got)
+	}
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/roles/header:sam/wizard", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Grant of unknown role gave status %d", status)
+	}
+	var all map[string][]auth.Role
+	helperUserRequest(t, srv, "root", "GET", "/api/admin/roles/", nil, &all)
+	if !reflect.DeepEqual(all["header:sam"], got) || len(all["header:root"]) != 2 {
+		t.Errorf("Role listing was %v", all)
+	}
+	helperUserRequest(t, srv, "root", "DELETE", "/api/admin/roles/header:sam/setter", nil, &got)
+	if !reflect.DeepEqual(got, []auth.Role{auth.RolePlayer}) {
+		t.Errorf("Revoke left roles %v", got)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	srv := helperUserServer(newSession("test-catalog"))
+	defer srv.Close()
+	roles.Grant("header:setter", auth.RoleSetter)
+	defer roles.Revoke("header:setter", auth.RoleSetter)
+	roles.Grant("header:mod", auth.RoleModerator)
+	defer roles.Revoke("header:mod", auth.RoleModerator)
+
+	var ids []string
+	if status := helperUserRequest(t, srv, "", "GET", "/api/catalog/", nil, &ids); status != http.StatusOK || len(ids) != len(puzzleValues) {
+		t.Fatalf("Catalog listing gave %d, %v", status, ids)
+	}
+	vals := puzzleValues["1-star"]
+	if status := helperUserRequest(t, srv, "player", "POST", "/api/catalog/test-new", vals, nil); status != http.StatusForbidden {
+		t.Errorf("Player add gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/test-new", puzzleValues["6-star"], nil); status != http.StatusBadRequest {
+		t.Errorf("Add of improper puzzle gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/2-star", vals, nil); status != http.StatusConflict {
+		t.Errorf("Add of existing ID gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/test-new", vals, nil); status != http.StatusConflict {
+		t.Errorf("Add of a puzzle already in the catalog gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/test-new", helperFlippedPuzzle("1-star"), nil); status != http.StatusConflict {
+		t.Errorf("Add of a transformed catalog puzzle gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/test-new", helperNewPuzzle("1-star"), &ids); status != http.StatusOK {
+		t.Fatalf("Setter add gave status %d", status)
+	}
+	if _, ok := lookupPuzzle("test-new"); !ok || len(ids) != len(puzzleValues) {
+		t.Errorf("Added puzzle isn't in catalog %v", ids)
+	}
+	if status := helperUserRequest(t, srv, "setter", "DELETE", "/api/catalog/test-new", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Setter remove gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "mod", "DELETE", "/api/catalog/"+defaultPuzzleID, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Remove of default puzzle gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "mod", "DELETE", "/api/catalog/test-new", nil, nil); status != http.StatusOK {
+		t.Errorf("Moderator remove gave status %d", status)
+	}
+	if _, ok := lookupPuzzle("test-new"); ok {
+		t.Errorf("Removed puzzle is still in the catalog")
+	}
+}
+
+func TestCloseRoom(t *testing.T) {
+	host, guest := newSession("test-close-host"), newSession("test-close-guest")
+	hsrv := helperUserServer(host)
+	defer hsrv.Close()
+	gsrv := httptest.NewServer(http.HandlerFunc(guest.rootHandler))
+	defer gsrv.Close()
+	roles.Grant("header:mod", auth.RoleModerator)
+	defer roles.Revoke("header:mod", auth.RoleModerator)
+
+	_, info := helperRoomRequest(t, hsrv, "create/")
+	helperRoomRequest(t, gsrv, "join/"+info.Room)
+	if status := helperUserRequest(t, hsrv, "player", "POST", "/api/mod/close/"+info.Room, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Player close gave status %d", status)
+	}
+	if status := helperUserRequest(t, hsrv, "mod", "POST", "/api/mod/close/NOSUCHRM", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Close of unknown room gave status %d", status)
+	}
+	if status := helperUserRequest(t, hsrv, "mod", "POST", "/api/mod/close/"+info.Room, nil, nil); status != http.StatusOK {
+		t.Fatalf("Moderator close gave status %d", status)
+	}
+	if host.room != nil || guest.room != nil || host.susenBoard == guest.susenBoard {
+		t.Errorf("Members still share a room after close")
+	}
+	roomMutex.RLock()
+	_, ok := rooms[info.Room]
+	roomMutex.RUnlock()
+	if ok {
+		t.Errorf("Room %q still registered after close", info.Room)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+Alerts
+
+Small deployments may not have a monitoring stack, so the server
+can watch itself: alert rules set thresholds on its operational
+metrics, and when a metric goes over its rule's threshold the
+server sends an alert to the rule's webhook (as a JSON POST) or
+email addresses.  When the metric comes back under the
+threshold, it sends a resolution the same way.  The metrics are:
+
+	errorRate           the fraction of requests in the window that
+	                    got server errors (5xx responses)
+	storeFailures       the number of store operations in the window
+	                    that failed
+	generationBacklog   the number of puzzle generations in progress
+
+The rules are in a JSON file named by SUSEN_ALERTS, which is read
+at startup.  The rules are checked every interval (60 seconds by
+default), and windows are in seconds (300 by default, and at
+most an hour).  Email needs an SMTP server, for example:
+
+	{"interval": 30,
+	 "smtp": {"server": "smtp.example.com:587", "from": "susen@example.com",
+	          "username": "susen", "password": "secret"},
+	 "rules": [
+	   {"name": "errors", "metric": "errorRate", "above": 0.05,
+	    "webhook": "https://hooks.example.com/susen"},
+	   {"name": "store", "metric": "storeFailures", "above": 0, "window": 60,
+	    "email": ["ops@example.com"]}]}
+
+Admins can see the rules, their metrics' current values, and
+which are firing at /api/admin/alerts/.
+
+*/
+
+// Alert metrics.
+const (
+	errorRateMetric         = "errorRate"
+	storeFailuresMetric     = "storeFailures"
+	generationBacklogMetric = "generationBacklog"
+)
+
+const (
+	defaultAlertInterval = 60 * time.Second
+	defaultAlertWindow   = 300 // seconds
+	maxAlertWindow       = time.Hour
+)
+
+// An alertRule fires when its metric goes over a threshold.
+type alertRule struct {
+	Name    string   `json:"name"`
+	Metric  string   `json:"metric"`
+	Above   float64  `json:"above"`
+	Window  int      `json:"window,omitempty"` // seconds
+	Webhook string   `json:"webhook,omitempty"`
+	Email   []string `json:"email,omitempty"`
+}
+
+// smtpConfig is where alert emails are sent from.
+type smtpConfig struct {
+	Server   string `json:"server"` // host:port
+	From     string `json:"from"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// alertConfig is the content of the alerts file.
+type alertConfig struct {
+	Interval int         `json:"interval,omitempty"` // seconds
+	SMTP     smtpConfig  `json:"smtp"`
+	Rules    []alertRule `json:"rules"`
+}
+
+// An alertNotice is what's sent when a rule fires or resolves.
+type alertNotice struct {
+	Rule   string    `json:"rule"`
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+	Above  float64   `json:"above"`
+	Firing bool      `json:"firing"` // false when the alert is resolved
+	Time   time.Time `json:"time"`
+}
+
+// alertStatus is a rule's state, for admins.
+type alertStatus struct {
+	alertRule
+	Value  float64 `json:"value"`
+	Firing bool    `json:"firing"`
+}
+
+var (
+	alertMutex  sync.Mutex
+	alerts      alertConfig
+	alertFiring = make(map[string]bool) // by rule name
+)
+
+// check checks that an alert configuration can be used.
+func (c alertConfig) check() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("Invalid alert interval: %d", c.Interval)
+	}
+	names := make(map[string]bool)
+	for _, rule := range c.Rules {
+		switch {
+		case rule.Name == "" || names[rule.Name]:
+			return fmt.Errorf("Alert rules need distinct names: %q", rule.Name)
+		case rule.Metric != errorRateMetric && rule.Metric != storeFailuresMetric &&
+			rule.Metric != generationBacklogMetric:
+			return fmt.Errorf("Alert %s: unknown metric %q", rule.Name, rule.Metric)
+		case rule.Above < 0 || rule.Window < 0 || time.Duration(rule.Window)*time.Second > maxAlertWindow:
+			return fmt.Errorf("Alert %s: invalid threshold or window", rule.Name)
+		case rule.Webhook == "" && len(rule.Email) == 0:
+			return fmt.Errorf("Alert %s: no webhook or email to send to", rule.Name)
+		case len(rule.Email) > 0 && (c.SMTP.Server == "" || c.SMTP.From == ""):
+			return fmt.Errorf("Alert %s: email needs an SMTP server and from address", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
+
+// loadAlerts reads the alerts file named in the environment, if
+// there is one, and starts checking its rules.  The server
+// doesn't start with an unusable alerts file.
+func loadAlerts() {
+	name := os.Getenv("SUSEN_ALERTS")
+	if name == "" {
+		return
+	}
+	f, e := os.Open(name)
+	if e != nil {
+		log.Fatalf("Can't read alerts file: %v", e)
+	}
+	defer f.Close()
+	var c alertConfig
+	if e = json.NewDecoder(f).Decode(&c); e == nil {
+		e = c.check()
+	}
+	if e != nil {
+		log.Fatalf("Invalid alerts file %s: %v", name, e)
+	}
+	alertMutex.Lock()
+	alerts = c
+	alertMutex.Unlock()
+	interval := defaultAlertInterval
+	if c.Interval > 0 {
+		interval = time.Duration(c.Interval) * time.Second
+	}
+	log.Printf("Loaded %d alert rules from %s, checking every %v.", len(c.Rules), name, interval)
+	go func() {
+		for now := range time.Tick(interval) {
+			for _, notice := range checkAlerts(now) {
+				sendAlert(notice)
+			}
+		}
+	}()
+}
+
+// An eventCounter counts events by the second, for as long as
+// the longest alert window.
+type eventCounter struct {
+	mutex   sync.Mutex
+	seconds []int64 // the seconds with events, in order
+	counts  []int
+}
+
+// add counts an event at the given time.
+func (c *eventCounter) add(now time.Time) {
+	sec := now.Unix()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if n := len(c.seconds); n > 0 && c.seconds[n-1] == sec {
+		c.counts[n-1]++
+		return
+	}
+	old := 0
+	for old < len(c.seconds) && c.seconds[old] <= sec-int64(maxAlertWindow/time.Second) {
+		old++
+	}
+	c.seconds, c.counts = append(c.seconds[old:], sec), append(c.counts[old:], 1)
+}
+
+// count returns the number of events in the window ending at the
+// given time.
+func (c *eventCounter) count(now time.Time, window time.Duration) int {
+	since := now.Unix() - int64(window/time.Second)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for i, sec := range c.seconds {
+		if sec > since {
+			n += c.counts[i]
+		}
+	}
+	return n
+}
+
+var (
+	requestEvents      eventCounter
+	serverErrorEvents  eventCounter
+	storeFailureEvents eventCounter
+	generations        int64 // puzzle generations in progress
+)
+
+// metricValue returns the current value of a metric.
+func metricValue(metric string, now time.Time, window time.Duration) float64 {
+	switch metric {
+	case errorRateMetric:
+		requests := requestEvents.count(now, window)
+		if requests == 0 {
+			return 0
+		}
+		return float64(serverErrorEvents.count(now, window)) / float64(requests)
+	case storeFailuresMetric:
+		return float64(storeFailureEvents.count(now, window))
+	case generationBacklogMetric:
+		return float64(atomic.LoadInt64(&generations))
+	}
+	return 0
+}
+
+// generatePuzzle generates a puzzle, counting it in the
+// generation backlog while it's in progress, and telling the
+// watcher (if there is one) how it's going.  Without a watcher,
+// generation stops when the context is done.
+func generatePuzzle(ctx context.Context, params puzzle.GenerateParams, watch func(puzzle.Progress) bool) (puzzle.Generated, error) {
+	atomic.AddInt64(&generations, 1)
+	defer atomic.AddInt64(&generations, -1)
+	if watch != nil {
+		return puzzle.GenerateWatched(params, watch)
+	}
+	return puzzle.GenerateContext(ctx, params)
+}
+
+// A monitoredStore is a Store whose failures are counted for
+// alerting.
+type monitoredStore struct {
+	storage.Store
+}
+
+func (s monitoredStore) failed(e error) error {
+	if e != nil {
+		storeFailureEvents.add(time.Now())
+	}
+	return e
+}
+
+func (s monitoredStore) Get(kind, key string, v interface{}) (bool, error) {
+	found, e := s.Store.Get(kind, key, v)
+	return found, s.failed(e)
+}
+
+func (s monitoredStore) Put(kind, key string, v interface{}) error {
+	return s.failed(s.Store.Put(kind, key, v))
+}
+
+func (s monitoredStore) Delete(kind, key string) error {
+	return s.failed(s.Store.Delete(kind, key))
+}
+
+func (s monitoredStore) Keys(kind string) ([]string, error) {
+	keys, e := s.Store.Keys(kind)
+	return keys, s.failed(e)
+}
+
+func (s monitoredStore) Swap(kind, key string, old, v interface{}) (bool, error) {
+	ok, e := storage.Swap(s.Store, kind, key, old, v)
+	return ok, s.failed(e)
+}
+
+// alertStatuses returns the state of every rule at the given
+// time.
+func alertStatuses(now time.Time) []alertStatus {
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+	statuses := []alertStatus{}
+	for _, rule := range alerts.Rules {
+		window := time.Duration(rule.Window) * time.Second
+		if rule.Window == 0 {
+			window = defaultAlertWindow * time.Second
+		}
+		statuses = append(statuses, alertStatus{rule, metricValue(rule.Metric, now, window), alertFiring[rule.Name]})
+	}
+	return statuses
+}
+
+// checkAlerts checks the rules at the given time, returning the
+// notices for the rules that have started firing or resolved.
+func checkAlerts(now time.Time) []alertNotice {
+	var notices []alertNotice
+	for _, status := range alertStatuses(now) {
+		firing := status.Value > status.Above
+		if firing == status.Firing {
+			continue
+		}
+		alertMutex.Lock()
+		alertFiring[status.Name] = firing
+		alertMutex.Unlock()
+		notices = append(notices, alertNotice{
+			Rule:   status.Name,
+			Metric: status.Metric,
+			Value:  status.Value,
+			Above:  status.Above,
+			Firing: firing,
+			Time:   now,
+		})
+	}
+	return notices
+}
+
+// alertClient sends alert webhooks.
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendMail sends alert emails (it's a variable so tests can
+// catch them).
+var sendMail = smtp.SendMail
+
+// sendAlert sends a notice to its rule's webhook and email
+// addresses.  Failures are logged, since there's nowhere else
+// to report them.
+func sendAlert(notice alertNotice) {
+	alertMutex.Lock()
+	var rule alertRule
+	for _, r := range alerts.Rules {
+		if r.Name == notice.Rule {
+			rule = r
+		}
+	}
+	conf := alerts.SMTP
+	alertMutex.Unlock()
+	state := "firing"
+	if !notice.Firing {
+		state = "resolved"
+	}
+	log.Printf("Alert %s is %s: %s is %g (threshold %g).",
+		notice.Rule, state, notice.Metric, notice.Value, notice.Above)
+	if rule.Webhook != "" {
+		body, _ := json.Marshal(notice)
+		r, e := alertClient.Post(rule.Webhook, "application/json", bytes.NewReader(body))
+		if e == nil {
+			r.Body.Close()
+			if r.StatusCode >= 300 {
+				e = fmt.Errorf("status %s", r.Status)
+			}
+		}
+		if e != nil {
+			log.Printf("Failed to send alert %s to webhook: %v", notice.Rule, e)
+		}
+	}
+	if len(rule.Email) > 0 {
+		var a smtp.Auth
+		if conf.Username != "" {
+			a = smtp.PlainAuth("", conf.Username, conf.Password, strings.Split(conf.Server, ":")[0])
+		}
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: susen alert %s is %s\r\n\r\n"+
+			"%s is %g (threshold %g) at %s.\r\n", conf.From, strings.Join(rule.Email, ", "),
+			notice.Rule, state, notice.Metric, notice.Value, notice.Above, notice.Time.Format(time.RFC3339))
+		if e := sendMail(conf.Server, a, conf.From, rule.Email, []byte(msg)); e != nil {
+			log.Printf("Failed to send alert %s by email: %v", notice.Rule, e)
+		}
+	}
+}
+
+// alertsHandler handles the alerts endpoint, which is only
+// routed to for admins:
+//
+// - GET /api/admin/alerts/ gives the state of every alert rule
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, alertStatuses(time.Now()))
+}
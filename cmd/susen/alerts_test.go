@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlerts(t *testing.T) {
+	var hooked []alertNotice
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notice alertNotice
+		json.NewDecoder(r.Body).Decode(&notice)
+		hooked = append(hooked, notice)
+	}))
+	defer hook.Close()
+	var mailed []string
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mailed = append(mailed, strings.Join(to, ",")+": "+string(msg))
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	c := alertConfig{
+		SMTP: smtpConfig{Server: "smtp.example.com:25", From: "susen@example.com"},
+		Rules: []alertRule{
+			{Name: "errors", Metric: errorRateMetric, Above: 0.1, Window: 60, Webhook: hook.URL},
+			{Name: "store", Metric: storeFailuresMetric, Email: []string{"ops@example.com"}},
+			{Name: "backlog", Metric: generationBacklogMetric, Above: 5, Webhook: hook.URL},
+		},
+	}
+	if e := c.check(); e != nil {
+		t.Fatalf("Valid alert config failed: %v", e)
+	}
+	for _, bad := range []alertConfig{
+		{Rules: []alertRule{{Name: "x", Metric: "mood", Webhook: hook.URL}}},
+		{Rules: []alertRule{{Name: "x", Metric: errorRateMetric}}},
+		{Rules: []alertRule{{Name: "x", Metric: errorRateMetric, Email: []string{"ops@example.com"}}}},
+		{Rules: []alertRule{{Name: "x", Metric: errorRateMetric, Window: 7200, Webhook: hook.URL}}},
+		{Rules: []alertRule{{Metric: errorRateMetric, Webhook: hook.URL}}},
+	} {
+		if e := bad.check(); e == nil {
+			t.Errorf("Invalid alert config %+v was accepted", bad)
+		}
+	}
+	alertMutex.Lock()
+	saved := alerts
+	alerts, alertFiring = c, make(map[string]bool)
+	alertMutex.Unlock()
+	defer func() {
+		alertMutex.Lock()
+		alerts, alertFiring = saved, make(map[string]bool)
+		alertMutex.Unlock()
+	}()
+
+	// a window with one server error in four requests, and a
+	// store failure, fires two of the rules once
+	now := time.Now().Add(2 * maxAlertWindow)
+	for i := 0; i < 4; i++ {
+		requestEvents.add(now)
+	}
+	serverErrorEvents.add(now)
+	if _, e := (monitoredStore{failingStore{}}).Get("kind", "key", nil); e == nil {
+		t.Fatalf("Failing store didn't fail")
+	}
+	storeFailureEvents.add(now)
+	notices := checkAlerts(now)
+	if len(notices) != 2 || notices[0].Rule != "errors" || notices[0].Value != 0.25 || !notices[1].Firing {
+		t.Fatalf("Firing notices are %+v", notices)
+	}
+	if again := checkAlerts(now.Add(time.Second)); len(again) != 0 {
+		t.Errorf("Rules fired again: %+v", again)
+	}
+	for _, notice := range notices {
+		sendAlert(notice)
+	}
+	if len(hooked) != 1 || hooked[0].Rule != "errors" || !hooked[0].Firing {
+		t.Errorf("Webhook got %+v", hooked)
+	}
+	if len(mailed) != 1 || !strings.HasPrefix(mailed[0], "ops@example.com: ") ||
+		!strings.Contains(mailed[0], "Subject: susen alert store is firing") {
+		t.Errorf("Email was %q", mailed)
+	}
+
+	// admins can see what's firing
+	srv := helperUserServer(newSession("test-alerts"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+	var statuses []alertStatus
+	if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/alerts/", nil, &statuses); status != http.StatusOK {
+		t.Fatalf("Alerts request gave status %d", status)
+	}
+	if len(statuses) != 3 || statuses[2].Name != "backlog" || statuses[2].Firing {
+		t.Errorf("Alert statuses are %+v", statuses)
+	}
+
+	// once the window has passed, the rules resolve
+	notices = checkAlerts(now.Add(maxAlertWindow))
+	if len(notices) != 2 || notices[0].Firing || notices[1].Firing {
+		t.Errorf("Resolving notices are %+v", notices)
+	}
+}
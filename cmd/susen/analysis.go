@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+)
+
+/*
+
+Board pre-analysis
+
+Deployments that turn on pre-analysis (see config.go) have each
+board's starting puzzle analyzed when it's started, and the
+analysis (see puzzle.Analysis) goes with the board's squares
+while it's at its starting step, as the JSON value of the
+X-Board-Analysis header.  Clients use it to highlight the
+bivalue squares and the most constrained groups before the
+player has done anything; the squares themselves are the same
+with or without it.  Contest and unassisted boards aren't
+analyzed, since they get no help.
+
+*/
+
+// analysisHeader is the response header with a board's analysis.
+const analysisHeader = "X-Board-Analysis"
+
+// preAnalysisEnabled tells whether boards are pre-analyzed.
+func preAnalysisEnabled() bool {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return preAnalysis
+}
+
+// preAnalyze works out the analysis of the board's starting
+// puzzle, if pre-analysis is on and the board gets help.
+func (board *susenBoard) preAnalyze() {
+	board.analysis = nil
+	if !preAnalysisEnabled() || board.contest || board.unassisted {
+		return
+	}
+	a, e := puzzle.Analyze(board.steps[0])
+	if e != nil {
+		log.Printf("Can't analyze puzzle %q: %v", board.puzzleID, e)
+		return
+	}
+	board.analysis = &a
+}
+
+// attachAnalysis adds the board's analysis to a response, working
+// it out if pre-analysis was turned on after the board started.
+func (board *susenBoard) attachAnalysis(w http.ResponseWriter) {
+	if !preAnalysisEnabled() {
+		return
+	}
+	if board.analysis == nil {
+		board.preAnalyze()
+		if board.analysis == nil {
+			return
+		}
+	}
+	bytes, e := json.Marshal(board.analysis)
+	if e != nil {
+		log.Printf("Failed to encode analysis of puzzle %q: %v", board.puzzleID, e)
+		return
+	}
+	w.Header().Set(analysisHeader, string(bytes))
+}
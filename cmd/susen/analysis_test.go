@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// helperAnalysis gets a board's squares, returning the status and
+// the analysis that came with them (if any).
+func helperAnalysis(t *testing.T, srv *httptest.Server, path string) (int, *puzzle.Analysis) {
+	r, e := http.Get(srv.URL + path)
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	defer r.Body.Close()
+	header := r.Header.Get(analysisHeader)
+	if header == "" {
+		return r.StatusCode, nil
+	}
+	var a puzzle.Analysis
+	if e := json.Unmarshal([]byte(header), &a); e != nil {
+		t.Fatalf("Can't decode analysis %q: %v", header, e)
+	}
+	return r.StatusCode, &a
+}
+
+func TestPreAnalysis(t *testing.T) {
+	saved := currentConfig().PreAnalysis
+	defer func() { applyConfig(configUpdate{PreAnalysis: &saved}) }()
+	off, on := false, true
+	applyConfig(configUpdate{PreAnalysis: &off})
+	session := newSession("test-pre-analysis")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	if status, a := helperAnalysis(t, srv, "/api/reset/"); status != http.StatusOK || a != nil {
+		t.Errorf("Reset without pre-analysis gave status %d, analysis %+v", status, a)
+	}
+
+	// the starting squares come with the analysis
+	applyConfig(configUpdate{PreAnalysis: &on})
+	expected, _ := puzzle.Analyze(session.steps[0])
+	status, a := helperAnalysis(t, srv, "/api/reset/")
+	if status != http.StatusOK || a == nil || len(a.Bivalue) != len(expected.Bivalue) ||
+		len(a.Constrained) == 0 || a.Constrained[0] != expected.Constrained[0] {
+		t.Fatalf("Reset with pre-analysis gave status %d, analysis %+v", status, a)
+	}
+	if session.analysis == nil {
+		t.Errorf("Board wasn't pre-analyzed")
+	}
+	if _, again := helperAnalysis(t, srv, "/api/"); again == nil {
+		t.Errorf("Starting squares came without the analysis")
+	}
+
+	// later squares, and contest boards, don't
+	index := 0
+	for i, v := range session.values[1:] {
+		if v == 0 {
+			index = i + 1
+			break
+		}
+	}
+	helperRoomAssign(t, srv, puzzle.Choice{Index: index, Value: session.steps[0].Solutions()[0].Values[index-1]})
+	if _, later := helperAnalysis(t, srv, "/api/"); later != nil {
+		t.Errorf("Squares after a move came with analysis %+v", later)
+	}
+	if status, contest := helperAnalysis(t, srv, "/reset/"+defaultPuzzleID+"?mode=contest"); contest != nil {
+		t.Errorf("Contest reset gave status %d, analysis %+v", status, contest)
+	}
+	if _, contest := helperAnalysis(t, srv, "/api/"); contest != nil || session.analysis != nil {
+		t.Errorf("Contest board has analysis %+v", contest)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+
+API versions
+
+The API is versioned, so that its response shapes (squares,
+errors, choices, and the rest) can change without breaking
+clients written against an earlier shape.  Each version is
+served under its own prefix, so version 1 is /api/v1/squares/
+and so on.  The unversioned /api/ prefix is the legacy API: for
+now it's the same as version 1, but its responses say it's
+deprecated and point to version 1, and it will go away.
+
+Clients can also ask for a version with the Accept header, using
+the media type application/vnd.susen.v<N>+json: that's how
+unversioned paths get a newer version, and a request for a
+version the server doesn't have gets a Not Acceptable
+response.  Other media types (or no Accept header at all) get
+the version in the path.  Every API response names its version
+in the Susen-Api-Version header.
+
+*/
+
+// The API versions.
+const (
+	latestAPIVersion = 1
+	apiVersionHeader = "Susen-Api-Version"
+	apiMediaPrefix   = "application/vnd.susen.v"
+	apiMediaSuffix   = "+json"
+)
+
+// apiVersion works out the API version of a request, and rewrites
+// versioned paths to the unversioned paths the handlers use.  It
+// returns false (having sent the response) if the request can't
+// be served in the version it asks for.  Requests outside the
+// API are left alone.
+func apiVersion(w http.ResponseWriter, r *http.Request) bool {
+	path := r.URL.Path
+	if !strings.HasPrefix(path, "/api/") {
+		return true
+	}
+	version, legacy := 0, true
+	if rest := strings.TrimPrefix(path, "/api/v"); rest != path {
+		if slash := strings.Index(rest, "/"); slash > 0 {
+			if n, e := strconv.Atoi(rest[:slash]); e == nil {
+				version, legacy = n, false
+				r.URL.Path = "/api" + rest[slash:]
+			}
+		}
+	}
+	if accepted, ok := acceptedAPIVersion(r.Header.Get("Accept")); !ok {
+		sendNotAcceptable(w)
+		return false
+	} else if accepted > 0 {
+		if !legacy && accepted != version {
+			sendNotAcceptable(w)
+			return false
+		}
+		version, legacy = accepted, false
+	}
+	if legacy {
+		version = latestAPIVersion
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "</api/v"+strconv.Itoa(version)+path[len("/api"):]+`>; rel="successor-version"`)
+	}
+	if version < 1 || version > latestAPIVersion {
+		sendError(w, http.StatusNotFound, requestError("Unknown API version: "+strconv.Itoa(version)))
+		return false
+	}
+	w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+	return true
+}
+
+// acceptedAPIVersion returns the API version asked for by an
+// Accept header (0 if it doesn't ask for one), and false if the
+// header only accepts susen formats the server doesn't have.
+// Other media types are left to the handlers, since some API
+// responses are images or text.
+func acceptedAPIVersion(accept string) (int, bool) {
+	version, other, unknown := 0, false, false
+	for _, part := range strings.Split(accept, ",") {
+		media, params, e := mime.ParseMediaType(part)
+		if e != nil || params["q"] == "0" {
+			continue
+		}
+		if !strings.HasPrefix(media, apiMediaPrefix) || !strings.HasSuffix(media, apiMediaSuffix) {
+			other = true
+			continue
+		}
+		n, e := strconv.Atoi(media[len(apiMediaPrefix) : len(media)-len(apiMediaSuffix)])
+		if e != nil || n < 1 || n > latestAPIVersion {
+			unknown = true
+		} else if n > version {
+			version = n
+		}
+	}
+	return version, version > 0 || other || !unknown
+}
+
+// sendNotAcceptable sends the response for a request that
+// doesn't accept any API version the server has.
+func sendNotAcceptable(w http.ResponseWriter) {
+	sendError(w, http.StatusNotAcceptable, requestError("Acceptable API versions are 1 to "+
+		strconv.Itoa(latestAPIVersion)+" ("+apiMediaPrefix+"<N>"+apiMediaSuffix+")"))
+}
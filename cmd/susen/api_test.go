@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIVersions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(newSession("test-api-versions").rootHandler))
+	defer srv.Close()
+	get := func(path, accept string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("GET %s request error: %v", path, e)
+		}
+		r.Body.Close()
+		return r
+	}
+
+	for _, c := range []struct {
+		path, accept string
+		status       int
+		deprecated   bool
+	}{
+		{"/api/v1/squares/", "", http.StatusOK, false},
+		{"/api/v1/squares/", "application/json", http.StatusOK, false},
+		{"/api/squares/", "", http.StatusOK, true},
+		{"/api/squares/", "application/vnd.susen.v1+json", http.StatusOK, false},
+		{"/api/squares/", "application/vnd.susen.v9+json, application/vnd.susen.v1+json;q=0.5", http.StatusOK, false},
+		{"/api/image.svg", "image/svg+xml", http.StatusOK, true},
+		{"/api/v1/squares/", "application/vnd.susen.v9+json", http.StatusNotAcceptable, false},
+		{"/api/squares/", "application/vnd.susen.v0+json", http.StatusNotAcceptable, false},
+		{"/api/v9/squares/", "", http.StatusNotFound, false},
+	} {
+		r := get(c.path, c.accept)
+		if r.StatusCode != c.status {
+			t.Errorf("GET %s (Accept %q) gave status %d", c.path, c.accept, r.StatusCode)
+			continue
+		}
+		if deprecated := r.Header.Get("Deprecation") != ""; deprecated != c.deprecated {
+			t.Errorf("GET %s (Accept %q) deprecated = %v", c.path, c.accept, deprecated)
+		}
+		if c.status == http.StatusOK && r.Header.Get(apiVersionHeader) != "1" {
+			t.Errorf("GET %s (Accept %q) has version %q", c.path, c.accept, r.Header.Get(apiVersionHeader))
+		}
+	}
+	if link := get("/api/squares/", "").Header.Get("Link"); link != `</api/v1/squares/>; rel="successor-version"` {
+		t.Errorf("Legacy link is %q", link)
+	}
+	// pages outside the API aren't versioned
+	if r := get("/solver/", "text/html"); r.StatusCode != http.StatusOK || r.Header.Get(apiVersionHeader) != "" {
+		t.Errorf("Solver page gave status %d, version %q", r.StatusCode, r.Header.Get(apiVersionHeader))
+	}
+}
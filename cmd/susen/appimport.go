@@ -0,0 +1,535 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+App imports
+
+Players coming from other Sudoku apps can bring their games with
+them.  POST /api/import/<format>, with an app's progress export
+as the body, imports its games into the user's account:
+
+- games in progress become boards, each in a new slot named
+imported-1, imported-2, and so on (see slots.go), with the app's
+entries filled in as the first move and the app's time already on
+the clock
+
+- completed games are added to the user's imported statistics,
+which GET /api/stats/imported gives, and completed games of
+catalog puzzles also count as the user's results on them (see
+classroom.go)
+
+The formats are the two kinds of export the mobile apps make:
+
+- csv: a CSV file with a header row and a row for each game
+
+- json: a JSON document with an array of games, either at the top
+or in a field such as "games", "puzzles", or "history", each game
+an object
+
+Apps name their columns and fields differently, so they're found
+by the names in importFields, ignoring case, spaces, dashes, and
+underscores.  A game needs its puzzle's givens, and can have its
+current values, its time, and whether it's completed.  Grids are
+lines of 81 characters (see puzzle/text.go), or JSON arrays of 81
+numbers or 9 rows of 9, with 0 for empty squares; times are
+seconds, or m:ss or h:mm:ss clock times.  A game with no current
+values hasn't been started, and a game whose values fill the grid
+is completed.
+
+Only identified users can import, since the games go into their
+account.  Games that can't be read are skipped, with the reason,
+and so are games that were imported before (so importing an
+export twice doesn't count its games twice) and games in progress
+once the session's slots are full.
+
+*/
+
+// The app import formats.
+const (
+	csvImportFormat  = "csv"
+	jsonImportFormat = "json"
+)
+
+// maxImportBytes is the largest export that can be imported.
+const maxImportBytes = 4 << 20
+
+// importedSlotPrefix starts the names of the slots of imported
+// boards.
+const importedSlotPrefix = "imported-"
+
+// importFields are the names apps use for each part of a game,
+// as they're compared: lower case, without separators.
+var importFields = map[string][]string{
+	"puzzle":    {"puzzle", "givens", "clues", "initial", "initialboard", "original", "problem", "question", "start"},
+	"progress":  {"progress", "current", "currentboard", "state", "board", "grid", "values", "user", "userboard", "answer"},
+	"time":      {"time", "elapsed", "elapsedtime", "duration", "seconds", "timer", "playtime", "timespent"},
+	"completed": {"completed", "complete", "solved", "finished", "iscompleted", "issolved", "won", "status"},
+}
+
+// The lists of games in JSON exports are found under these
+// names, compared the same way.
+var importLists = []string{"games", "puzzles", "boards", "saves", "savedgames", "history", "records"}
+
+// importedStatsKind is the storage kind for users' imported
+// statistics, which are keyed by user key.
+const importedStatsKind = "imported-stats"
+
+// importedStatsMutex serializes the updates of imported
+// statistics.
+var importedStatsMutex sync.Mutex
+
+// importedStats are the statistics of a user's imported games.
+type importedStats struct {
+	Games     int             `json:"games"`
+	Completed int             `json:"completed"`
+	Timed     int             `json:"timed"`              // completed games with times
+	BestTime  float64         `json:"bestTime,omitempty"` // seconds
+	MeanTime  float64         `json:"meanTime,omitempty"` // seconds
+	Updated   time.Time       `json:"updated"`
+	Seen      map[string]bool `json:"seen,omitempty"` // the imported games, by importedGame.key
+}
+
+// An importedGame is a game read from an app's export.
+type importedGame struct {
+	Givens    []int   // the geometry code and givens
+	Values    []int   // the geometry code and current values, or nil if there are none
+	Time      float64 // seconds, or 0 if it's not known
+	Completed bool
+}
+
+// key identifies the game, so it isn't imported twice.
+func (g importedGame) key() string {
+	current := ""
+	if g.Values != nil {
+		current = puzzle.Fingerprint(g.Values)
+	}
+	return fmt.Sprintf("%s|%s|%g|%v", puzzle.Fingerprint(g.Givens), current, g.Time, g.Completed)
+}
+
+// An importSkip is a game that wasn't imported, and why.
+type importSkip struct {
+	Game   int    `json:"game"` // from 1, in the order of the export
+	Reason string `json:"reason"`
+}
+
+// An importSummary says what an import did.
+type importSummary struct {
+	Format    string       `json:"format"`
+	Games     int          `json:"games"`
+	Completed int          `json:"completed"`
+	Slots     []string     `json:"slots"` // the slots of the games in progress
+	Skipped   []importSkip `json:"skipped,omitempty"`
+}
+
+// importName returns a column or field name as it's compared.
+func importName(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.TrimSpace(name)))
+}
+
+// importField returns which part of a game a column or field
+// name is, or "".
+func importField(name string) string {
+	name = importName(name)
+	for field, names := range importFields {
+		for _, n := range names {
+			if n == name {
+				return field
+			}
+		}
+	}
+	return ""
+}
+
+// parseImportGrid reads a grid, written as a line of characters
+// or (from JSON) an array of values or rows.
+func parseImportGrid(v interface{}) ([]int, error) {
+	switch v := v.(type) {
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil, nil
+		}
+		puzzles, e := puzzle.ParseText(strings.Join(strings.Fields(v), ""), puzzle.LineFormat)
+		if e != nil {
+			return nil, e
+		}
+		return puzzles[0], nil
+	case []interface{}:
+		vals := []int{puzzle.SudokuGeometryCode}
+		for _, item := range v {
+			if row, ok := item.([]interface{}); ok {
+				for _, cell := range row {
+					n, ok := cell.(float64)
+					if !ok {
+						return nil, fmt.Errorf("grid has a non-numeric value %v", cell)
+					}
+					vals = append(vals, int(n))
+				}
+				continue
+			}
+			n, ok := item.(float64)
+			if !ok {
+				return nil, fmt.Errorf("grid has a non-numeric value %v", item)
+			}
+			vals = append(vals, int(n))
+		}
+		if len(vals) != 82 {
+			return nil, fmt.Errorf("grid has %d squares, not 81", len(vals)-1)
+		}
+		return vals, nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("grid isn't a string or an array")
+}
+
+// parseImportTime reads a time, in seconds or as a clock time.
+func parseImportTime(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case nil:
+		return 0, nil
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return 0, nil
+		}
+		seconds := 0.0
+		for _, part := range strings.Split(v, ":") {
+			n, e := strconv.ParseFloat(part, 64)
+			if e != nil || n < 0 {
+				return 0, fmt.Errorf("invalid time %q", v)
+			}
+			seconds = seconds*60 + n
+		}
+		return seconds, nil
+	}
+	return 0, fmt.Errorf("invalid time %v", v)
+}
+
+// parseImportCompleted reads whether a game is completed.
+func parseImportCompleted(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "1", "true", "yes", "y", "completed", "complete", "solved", "finished", "won", "done":
+			return true
+		}
+	}
+	return false
+}
+
+// importGame makes a game from its parts.
+func importGame(parts map[string]interface{}) (importedGame, error) {
+	var g importedGame
+	var e error
+	if g.Givens, e = parseImportGrid(parts["puzzle"]); e != nil {
+		return g, fmt.Errorf("Invalid puzzle: %v", e)
+	}
+	if g.Givens == nil {
+		return g, fmt.Errorf("The game has no puzzle")
+	}
+	if len(g.Givens) != 82 {
+		return g, fmt.Errorf("Only 9x9 puzzles can be imported")
+	}
+	if g.Values, e = parseImportGrid(parts["progress"]); e != nil {
+		return g, fmt.Errorf("Invalid progress: %v", e)
+	}
+	if g.Values != nil && len(g.Values) != len(g.Givens) {
+		return g, fmt.Errorf("The progress doesn't fit the puzzle")
+	}
+	if g.Time, e = parseImportTime(parts["time"]); e != nil {
+		return g, fmt.Errorf("Invalid time: %v", e)
+	}
+	g.Completed = parseImportCompleted(parts["completed"])
+	if g.Values != nil {
+		full := true
+		for i, v := range g.Values[1:] {
+			if given := g.Givens[i+1]; given != 0 && v != given {
+				return g, fmt.Errorf("The progress changes the puzzle's givens")
+			}
+			full = full && v != 0
+		}
+		g.Completed = g.Completed || full
+	}
+	if _, e := puzzle.New(g.Givens); e != nil {
+		return g, fmt.Errorf("Invalid puzzle: %v", e)
+	}
+	return g, nil
+}
+
+// readImportCSV reads the games in a CSV export.  Games that
+// can't be read are returned as errors in their place.
+func readImportCSV(body io.Reader) ([]importedGame, []error, error) {
+	rows, e := csv.NewReader(body).ReadAll()
+	if e != nil {
+		return nil, nil, e
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("the export is empty")
+	}
+	columns := make(map[int]string)
+	for i, name := range rows[0] {
+		if field := importField(name); field != "" {
+			columns[i] = field
+		}
+	}
+	var games []importedGame
+	var errs []error
+	for _, row := range rows[1:] {
+		parts := make(map[string]interface{})
+		for i, cell := range row {
+			if field := columns[i]; field != "" {
+				parts[field] = cell
+			}
+		}
+		g, e := importGame(parts)
+		games, errs = append(games, g), append(errs, e)
+	}
+	return games, errs, nil
+}
+
+// readImportJSON reads the games in a JSON export, the same way
+// as readImportCSV.
+func readImportJSON(body io.Reader) ([]importedGame, []error, error) {
+	var doc interface{}
+	if e := json.NewDecoder(body).Decode(&doc); e != nil {
+		return nil, nil, e
+	}
+	list, ok := doc.([]interface{})
+	if obj, isObject := doc.(map[string]interface{}); isObject {
+		for name, v := range obj {
+			for _, listName := range importLists {
+				if importName(name) == listName {
+					list, ok = v.([]interface{})
+				}
+			}
+		}
+		if !ok {
+			list, ok = []interface{}{obj}, true // a single game
+		}
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("the export has no list of games")
+	}
+	var games []importedGame
+	var errs []error
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			games, errs = append(games, importedGame{}), append(errs, fmt.Errorf("The game isn't an object"))
+			continue
+		}
+		parts := make(map[string]interface{})
+		for name, v := range obj {
+			if field := importField(name); field != "" {
+				parts[field] = v
+			}
+		}
+		g, e := importGame(parts)
+		games, errs = append(games, g), append(errs, e)
+	}
+	return games, errs, nil
+}
+
+// importPuzzleID returns the puzzle ID for imported givens: the
+// catalog puzzle's, if they're one, and otherwise a shared
+// position's (see share.go).
+func importPuzzleID(givens []int) string {
+	fingerprint := puzzle.Fingerprint(givens)
+	for _, id := range catalogIDs() {
+		if vals, ok := lookupPuzzle(id); ok && puzzle.Fingerprint(vals) == fingerprint {
+			return id
+		}
+	}
+	return sharedIDPrefix + fingerprint
+}
+
+// freeImportSlot returns the name of the first imported slot the
+// session doesn't have, or "" if the session's slots are full.
+func (session *susenSession) freeImportSlot() string {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if len(session.slots) >= maxSlots {
+		return ""
+	}
+	for n := 1; ; n++ {
+		name := importedSlotPrefix + strconv.Itoa(n)
+		if _, used := session.slots[name]; !used {
+			return name
+		}
+	}
+}
+
+// startImported starts the (slot) session's board on an
+// imported game in progress.
+func (session *susenSession) startImported(g importedGame) error {
+	session.lockBoard()
+	defer session.unlockBoard()
+	session.contest, session.unassisted, session.relaxed = false, false, false
+	session.start(importPuzzleID(g.Givens), g.Givens)
+	var choices []puzzle.Choice
+	for i, v := range g.Values[1:] {
+		if v != 0 && g.Givens[i+1] == 0 {
+			choices = append(choices, puzzle.Choice{Index: i + 1, Value: v})
+		}
+	}
+	if len(choices) > 0 {
+		next := session.steps[0].Copy()
+		updates, e := puzzle.AssignAll(next, choices)
+		if e != nil {
+			return e
+		}
+		session.addStep(next)
+		for i, update := range updates {
+			session.countAssign(update)
+			session.autoFilled(choices[i].Index)
+		}
+	}
+	session.stats.Started = time.Now().Add(-time.Duration(g.Time * float64(time.Second)))
+	session.notifySquares()
+	return nil
+}
+
+// addImportedCompletion adds a completed game to the user's
+// imported statistics, and (for catalog puzzles) their results.
+func (session *susenSession) addImportedCompletion(user *auth.User, stats *importedStats, g importedGame) {
+	stats.Completed++
+	if g.Time > 0 {
+		if stats.Timed == 0 || g.Time < stats.BestTime {
+			stats.BestTime = g.Time
+		}
+		stats.MeanTime = (stats.MeanTime*float64(stats.Timed) + g.Time) / float64(stats.Timed+1)
+		stats.Timed++
+	}
+	if puzzleID := importPuzzleID(g.Givens); !sharedPuzzleID(puzzleID) {
+		session.recordResult(puzzleID, leaderboardEntry{Key: user.Key(), Name: user.Name, SolveTime: g.Time, Completed: time.Now()})
+	}
+}
+
+// appImportHandler handles POST /api/import/<format>.
+func (session *susenSession) appImportHandler(w http.ResponseWriter, r *http.Request) {
+	format := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/import/"), "/")
+	if r.Method != "POST" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("App exports can only be posted"))
+		return
+	}
+	user := auth.FromRequest(r)
+	if user == nil {
+		sendError(w, http.StatusUnauthorized, requestError("Imports are only for logged-in users"))
+		return
+	}
+	var read func(io.Reader) ([]importedGame, []error, error)
+	switch format {
+	case csvImportFormat:
+		read = readImportCSV
+	case jsonImportFormat:
+		read = readImportJSON
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown import format: "+format))
+		return
+	}
+	games, errs, e := read(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if e != nil {
+		sendError(w, http.StatusBadRequest, requestError("Invalid "+format+" export: "+e.Error()))
+		return
+	}
+
+	key := user.Key()
+	importedStatsMutex.Lock()
+	defer importedStatsMutex.Unlock()
+	var stats importedStats
+	if _, e := store.Get(importedStatsKind, key, &stats); e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't read imported statistics: "+e.Error()))
+		return
+	}
+	if stats.Seen == nil {
+		stats.Seen = make(map[string]bool)
+	}
+	summary := importSummary{Format: format, Slots: []string{}}
+	for i, g := range games {
+		skip := func(reason string) {
+			summary.Skipped = append(summary.Skipped, importSkip{Game: i + 1, Reason: reason})
+		}
+		if errs[i] != nil {
+			skip(errs[i].Error())
+			continue
+		}
+		if stats.Seen[g.key()] {
+			skip("The game was imported before")
+			continue
+		}
+		if g.Completed {
+			session.addImportedCompletion(user, &stats, g)
+			summary.Completed++
+		} else if g.Values != nil {
+			name := session.freeImportSlot()
+			if name == "" {
+				skip("The session's slots are full")
+				continue
+			}
+			slot, _ := session.slot(name)
+			if e := slot.startImported(g); e != nil {
+				session.removeSlot(name)
+				skip("Invalid progress: " + e.Error())
+				continue
+			}
+			summary.Slots = append(summary.Slots, name)
+		}
+		stats.Seen[g.key()] = true
+		stats.Games++
+		summary.Games++
+	}
+	sort.Strings(summary.Slots)
+	stats.Updated = time.Now().UTC()
+	if e := store.Put(importedStatsKind, key, stats); e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't save imported statistics: "+e.Error()))
+		return
+	}
+	log.Printf("Session %v imported %d games (%d completed, %d in progress) from a %s export.",
+		session.sessionID, summary.Games, summary.Completed, len(summary.Slots), format)
+	sendJSON(w, http.StatusOK, summary)
+}
+
+// importedStatsHandler handles GET /api/stats/imported.
+func (session *susenSession) importedStatsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.FromRequest(r)
+	if user == nil {
+		sendError(w, http.StatusUnauthorized, requestError("Imports are only for logged-in users"))
+		return
+	}
+	key := user.Key()
+	var stats importedStats
+	importedStatsMutex.Lock()
+	_, e := reportGet(r.Context(), importedStatsKind, key, &stats)
+	importedStatsMutex.Unlock()
+	if e != nil {
+		if refuseExpired(w, r) {
+			return
+		}
+		sendError(w, http.StatusInternalServerError, requestError("Can't read imported statistics: "+e.Error()))
+		return
+	}
+	stats.Seen = nil
+	sendJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperImport posts an app export, and returns the status and
+// summary.
+func helperImport(t *testing.T, srv *httptest.Server, user, format, body string) (int, importSummary) {
+	req, _ := http.NewRequest("POST", srv.URL+"/api/import/"+format, strings.NewReader(body))
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	r, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatalf("Import request error: %v", e)
+	}
+	defer r.Body.Close()
+	var summary importSummary
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(&summary); e != nil {
+			t.Fatalf("Import decode error: %v", e)
+		}
+	}
+	return r.StatusCode, summary
+}
+
+// helperGrid writes values (without the geometry code) as a line.
+func helperGrid(vals []int) string {
+	var b strings.Builder
+	for _, v := range vals {
+		b.WriteByte(byte('0' + v))
+	}
+	return b.String()
+}
+
+func TestAppImport(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-app-import")
+	session.setUser(&auth.User{ID: "ann", Name: "Ann", Source: "header"})
+	srv := helperUserServer(session)
+	defer srv.Close()
+
+	givens, _ := lookupPuzzle(defaultPuzzleID)
+	p, _ := puzzle.New(givens)
+	solution := p.Solutions()[0].Values
+	progress := append([]int(nil), givens[1:]...)
+	for i := 0; i < len(progress); i++ {
+		if progress[i] == 0 && i%3 == 0 {
+			progress[i] = solution[i]
+		}
+	}
+	wrong := append([]int(nil), givens[1:]...)
+	for i, v := range wrong {
+		if v == 0 {
+			wrong[i] = givens[1+(i+1)%81] // probably conflicts, certainly isn't the solution
+		}
+	}
+
+	if status, _ := helperImport(t, srv, "", "csv", ""); status != http.StatusUnauthorized {
+		t.Errorf("Anonymous import gave status %d", status)
+	}
+	if status, _ := helperImport(t, srv, "ann", "xml", ""); status != http.StatusNotFound {
+		t.Errorf("Unknown format gave status %d", status)
+	}
+	if status, _ := helperImport(t, srv, "ann", "json", "not json"); status != http.StatusBadRequest {
+		t.Errorf("Invalid export gave status %d", status)
+	}
+
+	// a CSV export, with a game in progress, a completed one, and
+	// one that can't be read
+	csv := "Puzzle,Current Board,Elapsed Time,Status\n" +
+		helperGrid(givens[1:]) + "," + helperGrid(progress) + ",12:30,in progress\n" +
+		helperGrid(givens[1:]) + "," + helperGrid(solution) + ",7:05,\n" +
+		"123,,,\n"
+	status, summary := helperImport(t, srv, "ann", "csv", csv)
+	if status != http.StatusOK || summary.Games != 2 || summary.Completed != 1 ||
+		len(summary.Slots) != 1 || summary.Slots[0] != "imported-1" ||
+		len(summary.Skipped) != 1 || summary.Skipped[0].Game != 3 {
+		t.Fatalf("CSV import gave %d, %+v", status, summary)
+	}
+	slot, _ := session.slot("imported-1")
+	if elapsed := time.Since(slot.stats.Started); slot.puzzleID != defaultPuzzleID || len(slot.steps) != 2 || elapsed < 750*time.Second {
+		t.Errorf("Imported board is %q with %d steps, started %v ago", slot.puzzleID, len(slot.steps), elapsed)
+	}
+	for i, s := range slot.steps[1].Squares() {
+		if s.Aval != progress[i] {
+			t.Errorf("Imported square %d is %d, not %d", i+1, s.Aval, progress[i])
+		}
+	}
+	if result, found := readResult("header:ann", defaultPuzzleID); !found || result.Best.SolveTime != 425 {
+		t.Errorf("Imported result is %+v (found %v)", result, found)
+	}
+
+	// importing it again adds nothing
+	if status, summary := helperImport(t, srv, "ann", "csv", csv); status != http.StatusOK || summary.Games != 0 ||
+		len(summary.Skipped) != 3 {
+		t.Errorf("Repeated CSV import gave %d, %+v", status, summary)
+	}
+
+	// a JSON export, with grids as arrays
+	rows := make([][]int, 9)
+	for r := range rows {
+		rows[r] = wrong[r*9 : r*9+9]
+	}
+	doc, _ := json.Marshal(map[string]interface{}{
+		"version": 3,
+		"saved_games": []interface{}{
+			map[string]interface{}{"initial": givens[1:], "board": solution, "time_spent": 300, "solved": true},
+			map[string]interface{}{"initial": givens[1:], "board": rows, "time_spent": 10},
+		},
+	})
+	status, summary = helperImport(t, srv, "ann", "json", string(doc))
+	if status != http.StatusOK || summary.Games != 1 || summary.Completed != 1 || len(summary.Skipped) != 1 {
+		t.Errorf("JSON import gave %d, %+v", status, summary)
+	}
+
+	var stats importedStats
+	if status := helperUserRequest(t, srv, "ann", "GET", "/api/stats/imported", nil, &stats); status != http.StatusOK ||
+		stats.Games != 3 || stats.Completed != 2 || stats.BestTime != 300 || stats.MeanTime != 362.5 || stats.Seen != nil {
+		t.Errorf("Imported stats gave %d, %+v", status, stats)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/api/stats/imported", nil, &stats); status != http.StatusUnauthorized {
+		t.Errorf("Anonymous imported stats gave status %d", status)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Solve archive
+
+When a board's puzzle is completed, the server keeps a record of
+the solve in the store: who played, how long it took, the final
+values, which player filled each square and where its value came
+from (see sources.go), and an SVG image of the final board (see
+puzzle.RenderSVG) with each player's entries in their own color.
+The image is made once, when the puzzle is completed, so replays
+and shares of the solve all show the same picture of it, whatever
+happens to the renderer later.
+
+The filler of a square is whoever assigned it last, so on a
+shared board (see rooms.go) the record says which member put in
+each value.  Players are named the same way as on leaderboards,
+and their keys aren't kept.  Each puzzle keeps its latest
+maxArchivedSolves records:
+
+- GET /api/archive/<puzzleID> gives the puzzle's records, oldest
+first, without their images, a page at a time (see paging.go);
+"daily" means today's daily puzzle
+
+- GET /api/archive/<puzzleID>/<solveID> gives a record, without
+its image
+
+- GET /api/archive/<puzzleID>/<solveID>.svg gives its image
+
+Unassisted solves (see main.go) of puzzles rated at least
+certificateStars also get a certificate: a statement of the
+solve, with the puzzle's fingerprint and the players, time, and
+date, signed with the server's provenance key (see provenance.go
+and puzzle/certificate.go).  The certificate is in the record,
+and also:
+
+- GET /api/archive/<puzzleID>/<solveID>.pdf gives it as a
+printable PDF document, with the solved grid
+
+- POST /api/archive/verify checks a posted certificate (and,
+optionally, the values of the puzzle it's claimed for) the same
+way POST /api/provenance/verify checks a provenance
+
+Only boards started on this server are certified: a board that
+was replaced by an imported backup or a resumed attempt (see
+backup.go and attempts.go), or by a browser session's board when
+the sessions merged (see merge.go), has a history the server
+can't vouch for, so its solves get no certificate, unassisted or
+not.
+
+*/
+
+// A solveRecord is an archived solve.
+type solveRecord struct {
+	ID        string    `json:"id"`
+	PuzzleID  string    `json:"puzzleID"`
+	Completed time.Time `json:"completed"`
+	SolveTime float64   `json:"solveTime"` // seconds
+	Moves     int       `json:"moves"`
+	Players   []string  `json:"players"` // in order of their first entry
+	Filled    []int     `json:"filled"`  // the player (from 1) who filled each square (by index from 1), 0 for givens
+	Sources   []string  `json:"sources"` // the source of each square (by index from 1; see sources.go)
+	Values    []int     `json:"values"`  // the final geometry code and values
+	Image     string    `json:"image,omitempty"`
+
+	Certificate *puzzle.Certificate `json:"certificate,omitempty"`
+}
+
+// certificateStars is the star rating a puzzle needs for its
+// unassisted solves to be certified.
+var certificateStars = 4
+
+// A certificateClaim is a posted certificate to check, optionally
+// with the values of the puzzle it's claimed for.
+type certificateClaim struct {
+	Certificate puzzle.Certificate `json:"certificate"`
+	Values      []int              `json:"values,omitempty"`
+}
+
+// archiveKind is the storage kind for solve records, which are
+// keyed by puzzle ID and solve ID, and maxArchivedSolves is how
+// many each puzzle keeps.
+const (
+	archiveKind       = "solve-archive"
+	maxArchivedSolves = 100
+)
+
+// archiveMutex serializes the updates of the archive.
+var archiveMutex sync.Mutex
+
+// archiveKey returns the storage key of a solve record.
+func archiveKey(puzzleID, solveID string) string {
+	return puzzleID + "|" + solveID
+}
+
+// fillSquare notes that the session filled a square of its
+// board.  Like all board statistics operations, it must be
+// called with the board locked.
+func (session *susenSession) fillSquare(index int) {
+	if index <= len(session.stats.sources) {
+		session.stats.sources[index-1] = session.fillSource(index)
+	}
+	session.stats.hinted = 0
+	if index > len(session.stats.fillers) {
+		return // restored from an older checkpoint
+	}
+	key, name := session.player()
+	session.stats.fillers[index-1] = key
+	if session.stats.names == nil {
+		session.stats.names = make(map[string]string)
+	}
+	session.stats.names[key] = name
+}
+
+// archiveSolve puts the board's completed puzzle in the archive.
+func (board *susenBoard) archiveSolve() {
+	final := board.steps[len(board.steps)-1]
+	state := final.State()
+	rec := solveRecord{
+		ID:        fmt.Sprintf("%019d", board.stats.Completed.UnixNano()), // sorts by completion
+		PuzzleID:  board.puzzleID,
+		Completed: *board.stats.Completed,
+		SolveTime: board.stats.SolveTime,
+		Moves:     board.stats.Assignments,
+		Players:   []string{},
+		Filled:    make([]int, len(board.values)),
+		Sources:   make([]string, len(board.values)),
+		Values:    append([]int{state.Geometry}, state.Values...),
+	}
+	for i := 1; i < len(board.values); i++ {
+		rec.Sources[i] = board.recordedSource(i)
+	}
+	number := make(map[string]int) // player key -> number
+	for i, key := range board.stats.fillers {
+		if key == "" || state.Values[i] == 0 || board.values[i+1] != 0 {
+			continue
+		}
+		if number[key] == 0 {
+			rec.Players = append(rec.Players, board.stats.names[key])
+			number[key] = len(rec.Players)
+		}
+		rec.Filled[i+1] = number[key]
+	}
+	var b strings.Builder
+	opts := puzzle.SVGOptions{Givens: board.values, Players: rec.Players, Filled: rec.Filled}
+	if e := puzzle.RenderSVG(&b, final, opts); e != nil {
+		log.Printf("Can't render archive image of puzzle %q: %v", board.puzzleID, e)
+		return
+	}
+	rec.Image = b.String()
+	rec.Certificate = board.certificate(rec)
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+	if e := store.Put(archiveKind, archiveKey(rec.PuzzleID, rec.ID), rec); e != nil {
+		log.Printf("Can't archive solve of puzzle %q: %v", rec.PuzzleID, e)
+		return
+	}
+	keys, e := archiveKeys(rec.PuzzleID)
+	if e != nil {
+		log.Printf("Can't list archive of puzzle %q: %v", rec.PuzzleID, e)
+		return
+	}
+	for len(keys) > maxArchivedSolves {
+		if e := store.Delete(archiveKind, keys[0]); e != nil {
+			log.Printf("Can't remove archived solve %q: %v", keys[0], e)
+			return
+		}
+		keys = keys[1:]
+	}
+}
+
+// certificate returns the signed certificate of an archived
+// solve, or nil if the solve doesn't get one.
+func (board *susenBoard) certificate(rec solveRecord) *puzzle.Certificate {
+	if !board.unassisted || !board.certifiable {
+		return nil
+	}
+	p, _ := puzzle.New(board.values) // the board was started with them
+	rating, e := puzzle.Rate(p)
+	if e != nil || rating.Stars < certificateStars {
+		return nil
+	}
+	key, e := signingKey()
+	if e != nil {
+		log.Printf("Can't certify solve of puzzle %q: %v", board.puzzleID, e)
+		return nil
+	}
+	c := puzzle.SignCertificate(key, puzzle.Certificate{
+		PuzzleID:    rec.PuzzleID,
+		SolveID:     rec.ID,
+		Fingerprint: puzzle.Fingerprint(board.values),
+		Solvers:     rec.Players,
+		Stars:       rating.Stars,
+		SolveTime:   rec.SolveTime,
+		Completed:   rec.Completed,
+		Issuer:      issuerName(),
+	})
+	return &c
+}
+
+// verifyCertificate handles POST /api/archive/verify.
+func verifyCertificate(w http.ResponseWriter, r *http.Request) {
+	key, e := signingKey()
+	if e != nil {
+		log.Printf("Couldn't load the provenance key: %v", e)
+		sendError(w, http.StatusServiceUnavailable, requestError("Certificate checking is unavailable"))
+		return
+	}
+	var claim certificateClaim
+	if e := decodeRequest(w, r, &claim); e != nil {
+		sendError(w, requestStatus(e), requestError("Invalid certificate: "+e.Error()))
+		return
+	}
+	if e := claim.Certificate.Verify(key.Public().(ed25519.PublicKey), claim.Values); e != nil {
+		err := e.(puzzle.Error)
+		sendJSON(w, http.StatusOK, provenanceCheck{Error: &err})
+		return
+	}
+	sendJSON(w, http.StatusOK, provenanceCheck{Valid: true})
+}
+
+// certificatePDF sends the certificate of an archived solve as a
+// PDF document.
+func certificatePDF(w http.ResponseWriter, rec solveRecord) {
+	if rec.Certificate == nil {
+		sendError(w, http.StatusNotFound, requestError("Archived solve "+rec.ID+" has no certificate"))
+		return
+	}
+	givens := append([]int(nil), rec.Values...)
+	for i := 1; i < len(givens) && i < len(rec.Filled); i++ {
+		if rec.Filled[i] != 0 {
+			givens[i] = 0
+		}
+	}
+	solved, e := puzzle.New(rec.Values)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't render the certificate: "+e.Error()))
+		return
+	}
+	var b bytes.Buffer
+	if e := puzzle.RenderCertificatePDF(&b, *rec.Certificate, solved, givens); e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't render the certificate: "+e.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="susen-certificate-%s.pdf"`, rec.ID))
+	w.Header().Set("Cache-Control", "public, max-age=86400") // records don't change
+	w.Write(b.Bytes())
+}
+
+// archiveKeys lists the storage keys of a puzzle's solve records,
+// oldest first.
+func archiveKeys(puzzleID string) ([]string, error) {
+	all, e := store.Keys(archiveKind)
+	if e != nil {
+		return nil, e
+	}
+	var keys []string
+	for _, key := range all {
+		if strings.HasPrefix(key, puzzleID+"|") && !strings.Contains(key[len(puzzleID)+1:], "|") {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// archiveHandler handles the archive endpoints.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && strings.Trim(r.URL.Path, "/") == "api/archive/verify" {
+		verifyCertificate(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("The solve archive can only be read, or check certificates"))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/archive/"), "/")
+	puzzleID, solveID := path, ""
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		puzzleID, solveID = path[:i], path[i+1:]
+	}
+	if puzzleID == dailyPuzzleID {
+		puzzleID = todayID(r)
+	}
+	if solveID == "" {
+		archiveMutex.Lock()
+		defer archiveMutex.Unlock()
+		keys, e := archiveKeys(puzzleID)
+		if e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't read the solve archive: "+e.Error()))
+			return
+		}
+		page, ok := pageOfKeys(w, r, keys)
+		if !ok {
+			return
+		}
+		recs := []solveRecord{}
+		for _, key := range page {
+			var rec solveRecord
+			if _, e := store.Get(archiveKind, key, &rec); e != nil {
+				sendError(w, http.StatusInternalServerError, requestError("Can't read the solve archive: "+e.Error()))
+				return
+			}
+			rec.Image = ""
+			recs = append(recs, rec)
+		}
+		sendJSON(w, http.StatusOK, recs)
+		return
+	}
+	image, printable := strings.HasSuffix(solveID, ".svg"), strings.HasSuffix(solveID, ".pdf")
+	var rec solveRecord
+	archiveMutex.Lock()
+	found, e := store.Get(archiveKind, archiveKey(puzzleID, strings.TrimSuffix(strings.TrimSuffix(solveID, ".svg"), ".pdf")), &rec)
+	archiveMutex.Unlock()
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't read the solve archive: "+e.Error()))
+		return
+	}
+	if !found {
+		sendError(w, http.StatusNotFound, requestError("No archived solve "+solveID+" of puzzle "+puzzleID))
+		return
+	}
+	if image {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "public, max-age=86400") // records don't change
+		w.Write([]byte(rec.Image))
+		return
+	}
+	if printable {
+		certificatePDF(w, rec)
+		return
+	}
+	rec.Image = ""
+	sendJSON(w, http.StatusOK, rec)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-archive")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var recs []solveRecord
+	if status := helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID, &recs); status != http.StatusOK || len(recs) != 0 {
+		t.Errorf("Empty archive gave %d, %+v", status, recs)
+	}
+
+	// solving the puzzle archives it, with the filler of each square
+	helperSolve(t, srv, session)
+	helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID, &recs)
+	if len(recs) != 1 || recs[0].Image != "" || recs[0].Moves != session.stats.Assignments ||
+		len(recs[0].Players) != 1 || recs[0].Players[0] != "anonymous" {
+		t.Fatalf("Archive after solve is %+v", recs)
+	}
+	rec := recs[0]
+	for i, v := range session.values[1:] {
+		if (v == 0) != (rec.Filled[i+1] == 1) || rec.Values[i+1] == 0 {
+			t.Errorf("Square %d (given %d) has filler %d, value %d", i+1, v, rec.Filled[i+1], rec.Values[i+1])
+		}
+		if (v == 0) != (rec.Sources[i+1] == playerSource) {
+			t.Errorf("Square %d (given %d) has source %q", i+1, v, rec.Sources[i+1])
+		}
+	}
+	var got solveRecord
+	if status := helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID+"/"+rec.ID, &got); status != http.StatusOK ||
+		got.ID != rec.ID || got.Image != "" {
+		t.Errorf("Record request gave %d, %+v", status, got)
+	}
+	r, e := http.Get(srv.URL + "/api/archive/" + defaultPuzzleID + "/" + rec.ID + ".svg")
+	if e != nil {
+		t.Fatalf("Image request error: %v", e)
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || r.Header.Get("Content-Type") != "image/svg+xml" ||
+		!strings.HasPrefix(string(body), "<svg") || !strings.Contains(string(body), "anonymous") {
+		t.Errorf("Image request gave %d, %q: %.80s", r.StatusCode, r.Header.Get("Content-Type"), body)
+	}
+
+	// unknown solves and writes are errors
+	if status := helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID+"/0", &got); status != http.StatusNotFound {
+		t.Errorf("Unknown solve gave status %d", status)
+	}
+	r, e = http.Post(srv.URL+"/api/archive/"+defaultPuzzleID, "application/json", strings.NewReader("{}"))
+	if e != nil {
+		t.Fatalf("Post request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Post to archive gave status %d", r.StatusCode)
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	saved, savedStars := store, certificateStars
+	store = storage.NewMemory()
+	defer func() { store, certificateStars = saved, savedStars }()
+	certificateStars = 0 // so the default puzzle is hard enough
+	session := newSession("test-certificate")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	// assisted solves aren't certified
+	helperSolve(t, srv, session)
+	var recs []solveRecord
+	helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID, &recs)
+	if len(recs) != 1 || recs[0].Certificate != nil {
+		t.Fatalf("Archive after assisted solve is %+v", recs)
+	}
+	r, e := http.Get(srv.URL + "/api/archive/" + defaultPuzzleID + "/" + recs[0].ID + ".pdf")
+	if e != nil {
+		t.Fatalf("Certificate request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("Uncertified solve's certificate gave status %d", r.StatusCode)
+	}
+
+	// unassisted ones are
+	var squares []puzzle.Square
+	helperGetJSON(t, srv, "/api/reset/", &squares)
+	session.unassisted = true
+	helperSolve(t, srv, session)
+	helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID, &recs)
+	if len(recs) != 2 || recs[1].Certificate == nil {
+		t.Fatalf("Archive after unassisted solve is %+v", recs)
+	}
+	c := *recs[1].Certificate
+	if c.PuzzleID != defaultPuzzleID || c.SolveID != recs[1].ID || c.Fingerprint != puzzle.Fingerprint(session.values) ||
+		c.Issuer != issuerName() || len(c.Solvers) != 1 || c.Solvers[0] != "anonymous" {
+		t.Errorf("Certificate is %+v", c)
+	}
+	r, e = http.Get(srv.URL + "/api/archive/" + defaultPuzzleID + "/" + recs[1].ID + ".pdf")
+	if e != nil {
+		t.Fatalf("Certificate request error: %v", e)
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || r.Header.Get("Content-Type") != "application/pdf" ||
+		!strings.HasPrefix(string(body), "%PDF-") || !strings.Contains(string(body), c.Signature) {
+		t.Errorf("Certificate request gave %d, %q: %.20q", r.StatusCode, r.Header.Get("Content-Type"), body)
+	}
+
+	// certificates check out, and changed ones don't
+	verify := func(claim certificateClaim) provenanceCheck {
+		bytes, _ := json.Marshal(claim)
+		r, e := http.Post(srv.URL+"/api/archive/verify", "application/json", strings.NewReader(string(bytes)))
+		if e != nil {
+			t.Fatalf("Verify request error: %v", e)
+		}
+		defer r.Body.Close()
+		var check provenanceCheck
+		if r.StatusCode != http.StatusOK || json.NewDecoder(r.Body).Decode(&check) != nil {
+			t.Fatalf("Verify request gave status %d", r.StatusCode)
+		}
+		return check
+	}
+	if check := verify(certificateClaim{Certificate: c, Values: session.values}); !check.Valid {
+		t.Errorf("Certificate didn't verify: %+v", check.Error)
+	}
+	c.Solvers = []string{"impostor"}
+	if check := verify(certificateClaim{Certificate: c}); check.Valid || check.Error == nil {
+		t.Errorf("Changed certificate verified")
+	}
+
+	// imported boards aren't certified, even if they're unassisted
+	var backup sessionBackup
+	helperGetJSON(t, srv, "/api/reset/", &squares)
+	session.unassisted = false
+	helperUserRequest(t, srv, "", "GET", "/api/export", nil, &backup)
+	if status := helperUserRequest(t, srv, "", "POST", "/api/import", backup, nil); status != http.StatusOK {
+		t.Fatalf("Import gave status %d", status)
+	}
+	session.unassisted = true
+	defer func() { session.unassisted = false }()
+	helperSolve(t, srv, session)
+	helperGetJSON(t, srv, "/api/archive/"+defaultPuzzleID, &recs)
+	if len(recs) != 3 || recs[2].Certificate != nil {
+		t.Errorf("Archive after imported solve is %+v", recs)
+	}
+}
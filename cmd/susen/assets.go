@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/ancientHacker/susen.go/static"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Static assets
+
+The solver client's pages, scripts, styles, and images, and the
+templates of the server's own pages, are compiled into the
+binary (see the static package), so the server runs the same
+wherever it's started from.  For development, the server can
+serve them from a directory instead (see staticDir in
+server.go), so they can be changed without rebuilding.
+
+Assets are served under /static/ with an ETag (a hash of their
+contents), so browsers can check that theirs are still current
+with a conditional request, and get a 304 if they are.  Embedded
+assets can only change when the server is redeployed, so
+browsers may keep them for a while without checking; assets
+from a directory are always checked.
+
+*/
+
+// staticDir is the directory of static assets the server uses
+// instead of the embedded ones, if any.
+var staticDir string
+
+// staticAssets returns the file system of the static assets.
+func staticAssets() fs.FS {
+	if staticDir != "" {
+		return os.DirFS(staticDir)
+	}
+	return static.Files
+}
+
+// embeddedETags caches the ETags of the embedded assets, by name.
+var (
+	embeddedETagMutex sync.Mutex
+	embeddedETags     = make(map[string]string)
+)
+
+// assetETag returns the ETag of an asset with the given contents.
+func assetETag(name string, contents []byte) string {
+	if staticDir != "" {
+		return contentETag(contents)
+	}
+	embeddedETagMutex.Lock()
+	defer embeddedETagMutex.Unlock()
+	etag, ok := embeddedETags[name]
+	if !ok {
+		etag = contentETag(contents)
+		embeddedETags[name] = etag
+	}
+	return etag
+}
+
+// contentETag makes a strong ETag from contents.
+func contentETag(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// serveAsset serves the named static asset, answering
+// conditional requests.
+func serveAsset(w http.ResponseWriter, r *http.Request, name string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	contents, e := fs.ReadFile(staticAssets(), name)
+	if e != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", assetETag(name, contents))
+	if staticDir != "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600") // until the next deploy, at most
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(contents))
+}
+
+// staticHandler serves the static assets under /static/.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Static assets can only be read", http.StatusMethodNotAllowed)
+		return
+	}
+	serveAsset(w, r, strings.TrimPrefix(r.URL.Path, "/static/"))
+}
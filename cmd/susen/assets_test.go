@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticAssets(t *testing.T) {
+	saved := staticDir
+	defer func() { staticDir = saved }()
+	srv := httptest.NewServer(http.HandlerFunc(staticHandler))
+	defer srv.Close()
+	get := func(path, etag string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("GET %s request error: %v", path, e)
+		}
+		defer r.Body.Close()
+		body, _ := ioutil.ReadAll(r.Body)
+		return r, string(body)
+	}
+
+	// the embedded assets are served with ETags
+	staticDir = ""
+	r, body := get("/static/css/puzzle.css", "")
+	etag := r.Header.Get("ETag")
+	if r.StatusCode != http.StatusOK || etag == "" || len(body) == 0 ||
+		r.Header.Get("Cache-Control") != "public, max-age=3600" {
+		t.Fatalf("Embedded asset gave status %d, headers %v", r.StatusCode, r.Header)
+	}
+	if r, _ := get("/static/css/puzzle.css", etag); r.StatusCode != http.StatusNotModified {
+		t.Errorf("Conditional request for current asset gave status %d", r.StatusCode)
+	}
+	if r, _ := get("/static/css/missing.css", ""); r.StatusCode != http.StatusNotFound {
+		t.Errorf("Missing asset gave status %d", r.StatusCode)
+	}
+	if r, _ := get("/static/../static.go", ""); r.StatusCode != http.StatusNotFound {
+		t.Errorf("Asset outside the assets gave status %d", r.StatusCode)
+	}
+
+	// an override directory is served instead, and always checked
+	staticDir = t.TempDir()
+	os.Mkdir(filepath.Join(staticDir, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(staticDir, "css", "puzzle.css"), []byte("body {}"), 0644)
+	r, body = get("/static/css/puzzle.css", etag)
+	if r.StatusCode != http.StatusOK || body != "body {}" || r.Header.Get("ETag") == etag ||
+		r.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("Override asset gave status %d, body %q, headers %v", r.StatusCode, body, r.Header)
+	}
+
+	// the server's configuration doesn't need an asset directory
+	if c, e := loadServerConfig(nil, func(string) string { return "" }); e != nil || c.StaticDir != "" {
+		t.Errorf("Config without an asset directory is %+v, %v", c, e)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Archived attempts
+
+Starting a puzzle over used to throw away the attempt in
+progress, however long it had gone on.  Now an unfinished
+attempt is put away in the session's archive instead: its
+position, its history, and the time on its clock.  Players can
+put an attempt away themselves, and come back to it later:
+
+- POST /api/abandon/puzzle archives the board's attempt and
+starts its puzzle over, responding with the archived attempt
+
+- GET /api/archive/attempts lists the session's archived
+attempts, oldest first, each with its position and progress
+
+- GET /api/archive/attempts/<id> gives one of them
+
+- POST /api/archive/attempts/<id>/resume puts the attempt back on
+the board, archiving the board's own attempt first, and responds
+with the board's squares
+
+- DELETE /api/archive/attempts/<id> throws the attempt away
+
+Starting a puzzle over with /reset/ (or /api/reset/) archives
+the attempt the same way, so nothing is lost by mistake.  Only
+attempts with moves that haven't been completed are archived,
+and a session (or each of its slots; see slots.go) keeps its
+latest maxArchivedAttempts of them, in its checkpoints.  An
+archived attempt's clock is stopped while it's put away (see
+timer.go), so resuming it doesn't charge the time in between.
+Boards in a running blitz attempt can't be archived, and boards
+shared in a room can't be replaced by resuming.
+
+*/
+
+// maxArchivedAttempts is how many archived attempts a session
+// keeps.
+const maxArchivedAttempts = 20
+
+// attemptInfo describes an archived attempt.
+type attemptInfo struct {
+	ID        string    `json:"id"`
+	Archived  time.Time `json:"archived"`
+	TimeSpent float64   `json:"timeSpent"` // seconds on the board's clock
+	Values    []int     `json:"values"`    // the position: the geometry code and values
+	boardSummary
+}
+
+// An archivedAttempt is an attempt put away for later: the
+// checkpoint of its board (see shutdown.go) and its pencil marks.
+type archivedAttempt struct {
+	attemptInfo
+	Board sessionCheckpoint `json:"board"`
+	Marks []puzzle.Choice   `json:"marks,omitempty"`
+}
+
+// archiveAttempt puts the board's attempt in the session's
+// archive, if it's worth keeping, and returns its description.
+// It must be called with the board locked.
+func (session *susenSession) archiveAttempt() (attemptInfo, bool) {
+	if len(session.steps) < 2 || session.stats.Completed != nil {
+		return attemptInfo{}, false
+	}
+	c, ok := session.boardCheckpoint()
+	if !ok {
+		return attemptInfo{}, false
+	}
+	now := time.Now()
+	if c.Stats.Paused == nil {
+		c.Stats.Paused = &now
+	}
+	last := session.steps[len(session.steps)-1]
+	state := last.State()
+	attempt := archivedAttempt{
+		attemptInfo: attemptInfo{
+			ID:           fmt.Sprintf("%019d", now.UnixNano()), // sorts by archiving
+			Archived:     now,
+			TimeSpent:    session.elapsed(now).Seconds(),
+			Values:       append([]int{state.Geometry}, state.Values...),
+			boardSummary: session.summary(),
+		},
+		Board: c,
+		Marks: stepMarks(last),
+	}
+	session.infoMutex.Lock()
+	session.attempts = append(session.attempts, attempt)
+	if len(session.attempts) > maxArchivedAttempts {
+		session.attempts = session.attempts[len(session.attempts)-maxArchivedAttempts:]
+	}
+	session.infoMutex.Unlock()
+	log.Printf("Session %v archived attempt %s at puzzle %q with %d moves.",
+		session.sessionID, attempt.ID, session.puzzleID, len(c.Moves))
+	return attempt.attemptInfo, true
+}
+
+// findAttempt returns the index of the session's archived attempt
+// with the given ID, or -1 if there isn't one.  It must be called
+// with the session's info locked.
+func (session *susenSession) findAttempt(id string) int {
+	for i, attempt := range session.attempts {
+		if attempt.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// abandonPuzzleHandler handles POST /api/abandon/puzzle.  It's
+// called with the board locked.
+func (session *susenSession) abandonPuzzleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Puzzles can only be abandoned with a POST"))
+		return
+	}
+	info, ok := session.archiveAttempt()
+	if !ok {
+		sendError(w, http.StatusConflict, requestError("There is no unfinished attempt to archive"))
+		return
+	}
+	warnImproper(w, session.reset(session.puzzleID))
+	session.notifySquares()
+	session.moved()
+	session.recordAction(resetAction)
+	sendJSON(w, http.StatusOK, info)
+}
+
+// attemptsHandler handles the archived attempt endpoints.
+func (session *susenSession) attemptsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/archive/attempts"), "/")
+	id, op := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		id, op = path[:i], path[i+1:]
+	}
+	switch {
+	case id == "" && r.Method == "GET":
+		session.infoMutex.Lock()
+		infos := make([]attemptInfo, 0, len(session.attempts))
+		for _, attempt := range session.attempts {
+			infos = append(infos, attempt.attemptInfo)
+		}
+		session.infoMutex.Unlock()
+		sendJSON(w, http.StatusOK, infos)
+	case id != "" && op == "" && (r.Method == "GET" || r.Method == "DELETE"):
+		session.infoMutex.Lock()
+		i := session.findAttempt(id)
+		var info attemptInfo
+		if i >= 0 {
+			info = session.attempts[i].attemptInfo
+			if r.Method == "DELETE" {
+				session.attempts = append(session.attempts[:i:i], session.attempts[i+1:]...)
+			}
+		}
+		session.infoMutex.Unlock()
+		if i < 0 {
+			sendError(w, http.StatusNotFound, requestError("No archived attempt "+id))
+			return
+		}
+		if r.Method == "DELETE" {
+			log.Printf("Session %v threw away archived attempt %s.", session.sessionID, id)
+		}
+		sendJSON(w, http.StatusOK, info)
+	case id != "" && op == "resume" && r.Method == "POST":
+		session.resumeAttempt(w, r, id)
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown archived attempt operation: "+r.Method+" "+r.URL.Path))
+	}
+}
+
+// resumeAttempt puts an archived attempt back on the session's
+// board.
+func (session *susenSession) resumeAttempt(w http.ResponseWriter, r *http.Request, id string) {
+	session.infoMutex.Lock()
+	i := session.findAttempt(id)
+	var attempt archivedAttempt
+	if i >= 0 {
+		attempt = session.attempts[i]
+	}
+	session.infoMutex.Unlock()
+	if i < 0 {
+		sendError(w, http.StatusNotFound, requestError("No archived attempt "+id))
+		return
+	}
+	board, e := restoreBoard(attempt.Board, session.sessionID, attempt.Marks)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't restore the archived attempt: "+e.Error()))
+		return
+	}
+
+	session.lockBoard()
+	defer session.unlockBoard()
+	if session.refuseTurnChange(w, r) || session.refuseRaceChange(w, r) {
+		return
+	}
+	if session.room != nil {
+		sendError(w, http.StatusConflict, requestError("Boards shared in a room can't be replaced"))
+		return
+	}
+	session.infoMutex.Lock()
+	i = session.findAttempt(id)
+	if i >= 0 {
+		session.attempts = append(session.attempts[:i:i], session.attempts[i+1:]...)
+	}
+	session.infoMutex.Unlock()
+	if i < 0 {
+		sendError(w, http.StatusNotFound, requestError("No archived attempt "+id))
+		return
+	}
+	session.archiveAttempt()
+	session.replaceBoard(board)
+	session.resumeTimer()
+	log.Printf("Session %v resumed archived attempt %s at puzzle %q.", session.sessionID, id, session.puzzleID)
+	last := session.steps[len(session.steps)-1]
+	attachProgress(w, last)
+	puzzle.SquaresHandler(session.shown(last), w, r)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestArchivedAttempts(t *testing.T) {
+	session := newSession("test-attempts")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	empty := func(n int) puzzle.Choice {
+		for _, s := range session.steps[len(session.steps)-1].Squares() {
+			if s.Aval == 0 && len(s.Pvals) > 0 {
+				if n == 0 {
+					return puzzle.Choice{Index: s.Index, Value: s.Pvals[0]}
+				}
+				n--
+			}
+		}
+		t.Fatalf("No empty square left")
+		return puzzle.Choice{}
+	}
+
+	// boards without moves have nothing to archive
+	if status := helperUserRequest(t, srv, "", "POST", "/api/abandon/puzzle", nil, nil); status != http.StatusConflict {
+		t.Errorf("Abandon of an unplayed board gave status %d", status)
+	}
+	first := empty(0)
+	if status := helperRoomAssign(t, srv, first); status != http.StatusOK {
+		t.Fatalf("Assign gave status %d", status)
+	}
+	var info attemptInfo
+	if status := helperUserRequest(t, srv, "", "POST", "/api/abandon/puzzle", nil, &info); status != http.StatusOK ||
+		info.PuzzleID != session.puzzleID || info.Steps != 2 || info.Filled != 1 || info.Values[first.Index] != first.Value {
+		t.Fatalf("Abandon gave %d, %+v", status, info)
+	}
+	if len(session.steps) != 1 {
+		t.Errorf("Abandon left %d steps", len(session.steps))
+	}
+
+	// resets archive unfinished attempts too
+	if status := helperRoomAssign(t, srv, empty(1)); status != http.StatusOK {
+		t.Fatalf("Assign gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/reset/", nil, nil); status != http.StatusOK {
+		t.Fatalf("Reset gave status %d", status)
+	}
+	var infos []attemptInfo
+	if status := helperGetJSON(t, srv, "/api/archive/attempts", &infos); status != http.StatusOK ||
+		len(infos) != 2 || infos[0].ID != info.ID {
+		t.Fatalf("Attempt list gave %d, %+v", status, infos)
+	}
+
+	// resuming puts the attempt back, with its clock running
+	if status := helperUserRequest(t, srv, "", "POST", "/api/archive/attempts/"+info.ID+"/resume", nil, nil); status != http.StatusOK {
+		t.Fatalf("Resume gave status %d", status)
+	}
+	if len(session.steps) != 2 || session.steps[1].State().Values[first.Index-1] != first.Value ||
+		session.stats.Paused != nil || session.stats.Assignments != 1 {
+		t.Errorf("Resumed board has %d steps, stats %+v", len(session.steps), session.stats)
+	}
+	if status := helperGetJSON(t, srv, "/api/archive/attempts/"+info.ID, nil); status != http.StatusNotFound {
+		t.Errorf("Resumed attempt gave status %d", status)
+	}
+
+	// attempts are kept in checkpoints
+	session.mutex.Lock()
+	session.archiveAttempt()
+	session.mutex.Unlock()
+	c, ok := session.checkpoint()
+	restored, e := c.restore("test-attempts-restored")
+	if !ok || e != nil || len(restored.attempts) != 2 || restored.attempts[1].Board.Stats.Paused == nil {
+		t.Fatalf("Restored attempts are %+v (%v, %v)", restored.attempts, ok, e)
+	}
+
+	if status := helperUserRequest(t, srv, "", "DELETE", "/api/archive/attempts/"+infos[1].ID, nil, nil); status != http.StatusOK {
+		t.Errorf("Delete gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/archive/attempts/"+infos[1].ID+"/resume", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Resume of a deleted attempt gave status %d", status)
+	}
+	if len(session.attempts) != 1 || time.Since(session.attempts[0].Archived) > time.Minute {
+		t.Errorf("Session has attempts %+v", session.attempts)
+	}
+}
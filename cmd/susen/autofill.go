@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*
+
+Autofill
+
+POST /api/autofill fills in the board's singles (see
+puzzle.NextSingle), one after another, until there are none
+left, and responds with the assignments it made, in order, each
+with the technique that forced it, so clients can animate them,
+and the update they make together:
+
+	{"assignments":[{"index":3,"value":7,"technique":"naked single"},...],
+	 "update":{"squares":[...],"progress":{...}}}
+
+The whole autofill is one move, undone by one undo, unless the
+request says steps=each, when each assignment is a move of its
+own.  The squares it fills are the server's, so they're assisted
+(see sources.go), and contest and unassisted boards can't be
+autofilled.  A board with errors, or with no singles, is left as
+it is, and the response has no assignments.
+
+*/
+
+// autofillResponse is the response to an autofill.
+type autofillResponse struct {
+	Assignments []puzzle.Single `json:"assignments"`
+	Update      puzzle.Update   `json:"update"`
+}
+
+// autofillHandler handles POST /api/autofill.  It's called with
+// the board locked.
+func (session *susenSession) autofillHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.Trim(r.URL.Path, "/") != "api/autofill" {
+		sendError(w, http.StatusNotFound, requestError("Unknown autofill operation: "+r.URL.Path))
+		return
+	}
+	each := false
+	switch steps := r.URL.Query().Get("steps"); steps {
+	case "", "one":
+	case "each":
+		each = true
+	default:
+		sendError(w, http.StatusBadRequest, requestError("Invalid autofill steps: "+steps))
+		return
+	}
+	if session.contest || session.unassisted {
+		sendError(w, http.StatusForbidden, requestError("Contest and unassisted boards can't be autofilled"))
+		return
+	}
+	session.attachPollInterval(w)
+	next := session.steps[len(session.steps)-1].Copy()
+	singles := []puzzle.Single{}
+	var updates []puzzle.Update
+	for {
+		single, ok := puzzle.NextSingle(next)
+		if !ok {
+			break
+		}
+		update, e := next.Assign(single.Choice)
+		if e != nil {
+			log.Printf("Session %v failed to autofill single %+v: %v", session.sessionID, single, e)
+			break
+		}
+		singles, updates = append(singles, single), append(updates, update)
+		if each {
+			session.addStep(next)
+			session.chargeScore(scoreAutofill, 1) // before the assignment can complete the puzzle
+			session.countAssign(update)
+			session.autoFilled(single.Index)
+			next = next.Copy()
+		}
+	}
+	if len(singles) == 0 {
+		sendJSON(w, http.StatusOK, autofillResponse{Assignments: singles})
+		return
+	}
+	if !each {
+		session.addStep(next)
+		session.chargeScore(scoreAutofill, len(updates))
+		for i, update := range updates {
+			session.countAssign(update)
+			session.autoFilled(singles[i].Index)
+		}
+	}
+	merged := puzzle.MergeUpdates(updates)
+	session.notifyUpdate(merged)
+	session.pushRoomMove()
+	session.moved()
+	session.recordAction(assignAction)
+	log.Printf("Session %v autofilled %d singles of puzzle %q.", session.sessionID, len(singles), session.puzzleID)
+	sendJSON(w, http.StatusOK, autofillResponse{singles, session.shownUpdate(session.steps[len(session.steps)-1], merged)})
+}
+
+// shownUpdate shows an update of a step to the session, with its
+// squares as they are shown (see prefs.go) and the step's fill
+// progress.  It must be called with the board locked.
+func (session *susenSession) shownUpdate(step puzzle.Puzzle, update puzzle.Update) puzzle.Update {
+	changed := make(map[int]bool, len(update.Squares))
+	for _, s := range update.Squares {
+		changed[s.Index] = true
+	}
+	update.Squares = nil
+	for _, s := range session.shown(step).Squares() {
+		if changed[s.Index] {
+			update.Squares = append(update.Squares, s)
+		}
+	}
+	progress := puzzle.MeasureFill(step)
+	update.Progress = &progress
+	return update
+}
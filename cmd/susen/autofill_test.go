@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutofill(t *testing.T) {
+	session := newSession("test-autofill")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	if status := helperUserRequest(t, srv, "", "POST", "/api/autofill?steps=some", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Autofill with invalid steps gave status %d", status)
+	}
+
+	// the default puzzle is solved by singles, in one step
+	var af autofillResponse
+	if status := helperUserRequest(t, srv, "", "POST", "/api/autofill", nil, &af); status != http.StatusOK {
+		t.Fatalf("Autofill gave status %d", status)
+	}
+	if len(af.Assignments) == 0 || len(af.Update.Squares) != len(af.Assignments) || !af.Update.Solved ||
+		af.Update.Progress == nil || af.Update.Progress.Remaining != 0 {
+		t.Fatalf("Autofill gave %d assignments, update %+v", len(af.Assignments), af.Update)
+	}
+	for _, s := range af.Update.Squares {
+		if s.Source != autoSource {
+			t.Fatalf("Autofilled square %d has source %q", s.Index, s.Source)
+		}
+	}
+	if len(session.steps) != 2 || session.assistedSquares() != len(af.Assignments) {
+		t.Errorf("Autofill left %d steps, %d assisted squares", len(session.steps), session.assistedSquares())
+	}
+	var again autofillResponse
+	if status := helperUserRequest(t, srv, "", "POST", "/api/autofill", nil, &again); status != http.StatusOK ||
+		len(again.Assignments) != 0 || len(session.steps) != 2 {
+		t.Errorf("Autofill of a solved board gave %d, %+v", status, again)
+	}
+
+	// each assignment can be its own step
+	helperUserRequest(t, srv, "", "GET", "/api/reset/", nil, nil)
+	var each autofillResponse
+	if status := helperUserRequest(t, srv, "", "POST", "/api/autofill?steps=each", nil, &each); status != http.StatusOK ||
+		len(each.Assignments) != len(af.Assignments) {
+		t.Fatalf("Autofill of each step gave %d, %d assignments", status, len(each.Assignments))
+	}
+	if len(session.steps) != len(each.Assignments)+1 {
+		t.Errorf("Autofill of each step left %d steps", len(session.steps))
+	}
+	var squares []puzzle.Square
+	helperGetJSON(t, srv, "/api/back/", &squares)
+	if filled := puzzle.MeasureFill(session.steps[len(session.steps)-1]); filled.Remaining != 1 {
+		t.Errorf("Undo of an autofill step left %+v", filled)
+	}
+
+	// contest boards get no help
+	session.contest = true
+	if status := helperUserRequest(t, srv, "", "POST", "/api/autofill", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Autofill of contest board gave status %d", status)
+	}
+}
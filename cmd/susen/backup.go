@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+
+Session backups
+
+Players can keep their own copy of a board, and put it back
+later (or on another server):
+
+- GET /api/export gives a self-contained JSON document of the
+board: its puzzle, the moves made on it, its guesses, symbols,
+statistics, and pencil marks
+
+- POST /api/import, with such a document as the body, replaces
+the board with the one in the document
+
+The document is a session checkpoint (see shutdown.go) with the
+pencil marks added, so it's versioned along with checkpoints,
+and documents exported by older releases are brought up to date
+when they're imported (see versions.go).  It doesn't have the
+session's user or tutorial history, and only has one board: the
+other slots can be exported and imported one at a time with the
+slot parameter (see slots.go).  Boards in a running blitz
+attempt can't be exported, and boards shared in a room can't be
+replaced by importing.
+
+Nothing in a document can be checked, so importing doesn't trust
+it with anything but the board itself: contest and unassisted
+boards can't be imported, the imported board's statistics start
+over as if its puzzle had just been started, and completing an
+imported board (or any board it's saved and resumed as) only
+gives it statistics, not leaderboard entries, results, or
+streaks (see stats.go).
+
+*/
+
+// backupFormat identifies session backup documents.
+const backupFormat = "susen-session"
+
+// A sessionBackup is an exported board.
+type sessionBackup struct {
+	Format   string    `json:"format"`
+	Exported time.Time `json:"exported"`
+	sessionCheckpoint
+	Marks []puzzle.Choice `json:"marks,omitempty"`
+}
+
+// backupHandler handles the export and import endpoints.
+func (session *susenSession) backupHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/export" && r.Method == "GET":
+		session.exportBackup(w)
+	case r.URL.Path == "/api/import" && r.Method == "POST":
+		session.importBackup(w, r)
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown backup operation: "+r.Method+" "+r.URL.Path))
+	}
+}
+
+// exportBackup sends the session's board as a backup document.
+func (session *susenSession) exportBackup(w http.ResponseWriter) {
+	c, ok := session.checkpoint()
+	if !ok {
+		sendError(w, http.StatusConflict, requestError("The board can't be exported right now"))
+		return
+	}
+	c.Version, c.User, c.Actions, c.Active, c.Slots, c.Prefs = checkpointVersion, nil, nil, time.Time{}, nil, nil
+	c.Attempts = nil
+	backup := sessionBackup{Format: backupFormat, Exported: time.Now().UTC(), sessionCheckpoint: c}
+	session.lockBoard()
+	backup.Marks = stepMarks(session.steps[len(session.steps)-1])
+	session.unlockBoard()
+	w.Header().Set("Content-Disposition", `attachment; filename="susen-session.json"`)
+	sendJSON(w, http.StatusOK, backup)
+}
+
+// stepMarks returns the pencil marks of a step, as choices.
+func stepMarks(step puzzle.Puzzle) []puzzle.Choice {
+	var marks []puzzle.Choice
+	for _, s := range step.Squares() {
+		for _, v := range s.Marks {
+			marks = append(marks, puzzle.Choice{Index: s.Index, Value: v})
+		}
+	}
+	return marks
+}
+
+// restoreBoard makes the board of a checkpoint, with the given
+// pencil marks on its current step.
+func restoreBoard(c sessionCheckpoint, sessionID string, marks []puzzle.Choice) (*susenBoard, error) {
+	c.Slots = nil
+	restored, e := c.restore(sessionID)
+	if e != nil {
+		return nil, e
+	}
+	board := restored.susenBoard
+	last := board.steps[len(board.steps)-1]
+	for _, mark := range marks {
+		if _, e := last.MarkCandidate(mark); e != nil {
+			return nil, e
+		}
+	}
+	return board, nil
+}
+
+// replaceBoard replaces the session's board with a restored one
+// (see restoreBoard).  It must be called with the board locked.
+func (session *susenSession) replaceBoard(board *susenBoard) {
+	session.stopBlitz()
+	session.puzzleID, session.contest, session.unassisted = board.puzzleID, board.contest, board.unassisted
+	session.relaxed, session.values, session.steps = board.relaxed, board.values, board.steps
+	session.stats, session.guesses, session.symbols = board.stats, board.guesses, board.symbols
+	session.handicapped, session.imported, session.analysis = false, board.imported, nil
+	session.certifiable = false
+	session.changedSteps()
+	session.notifySquares()
+}
+
+// importBackup replaces the session's board with the one in the
+// posted backup document.
+func (session *susenSession) importBackup(w http.ResponseWriter, r *http.Request) {
+	var raw map[string]interface{}
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes)).Decode(&raw); e != nil {
+		sendError(w, requestStatus(e), requestError("Invalid session backup: "+e.Error()))
+		return
+	}
+	if format, _ := raw["format"].(string); format != backupFormat {
+		sendError(w, http.StatusBadRequest, requestError("Not a session backup"))
+		return
+	}
+	var marks []puzzle.Choice
+	if bytes, e := json.Marshal(raw["marks"]); e == nil {
+		json.Unmarshal(bytes, &marks)
+	}
+	c, version, e := upgradeCheckpoint(raw)
+	if e != nil {
+		sendError(w, http.StatusBadRequest, requestError("Invalid session backup: "+e.Error()))
+		return
+	}
+	if c.Contest || c.Unassisted {
+		sendError(w, http.StatusBadRequest, requestError("Contest and unassisted boards can't be imported"))
+		return
+	}
+	board, e := restoreBoard(c, session.sessionID, marks)
+	if e != nil {
+		sendError(w, http.StatusBadRequest, requestError("Invalid session backup: "+e.Error()))
+		return
+	}
+	board.startStats()
+	board.imported = true
+
+	session.lockBoard()
+	defer session.unlockBoard()
+	if session.refuseTurnChange(w, r) || session.refuseRaceChange(w, r) {
+		return
+	}
+	if session.room != nil {
+		sendError(w, http.StatusConflict, requestError("Boards shared in a room can't be replaced"))
+		return
+	}
+	session.replaceBoard(board)
+	log.Printf("Session %v imported a backup of puzzle %q (version %d) with %d moves.",
+		session.sessionID, session.puzzleID, version, len(c.Moves))
+	last := session.steps[len(session.steps)-1]
+	attachProgress(w, last)
+	puzzle.SquaresHandler(session.shown(last), w, r)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionBackup(t *testing.T) {
+	from, to := newSession("test-backup-from"), newSession("test-backup-to")
+	from.reset("2-star")
+	fsrv := httptest.NewServer(http.HandlerFunc(from.rootHandler))
+	defer fsrv.Close()
+	tsrv := httptest.NewServer(http.HandlerFunc(to.rootHandler))
+	defer tsrv.Close()
+
+	// a couple of moves and a pencil mark
+	p, _ := puzzle.New(puzzleValues["2-star"])
+	solution := p.Solutions()[0].Values
+	var empty []int
+	for i, v := range puzzleValues["2-star"][1:] {
+		if v == 0 {
+			empty = append(empty, i+1)
+		}
+	}
+	for _, idx := range empty[:2] {
+		helperRoomAssign(t, fsrv, puzzle.Choice{Index: idx, Value: solution[idx-1]})
+	}
+	mark := puzzle.Choice{Index: empty[2], Value: solution[empty[2]-1]}
+	from.mutex.Lock()
+	from.steps[len(from.steps)-1].MarkCandidate(mark)
+	from.mutex.Unlock()
+
+	var backup sessionBackup
+	if status := helperUserRequest(t, fsrv, "", "GET", "/api/export", nil, &backup); status != http.StatusOK {
+		t.Fatalf("Export gave status %d", status)
+	}
+	if backup.Format != backupFormat || backup.PuzzleID != "2-star" || len(backup.Moves) != 2 ||
+		!reflect.DeepEqual(backup.Marks, []puzzle.Choice{mark}) || backup.Version != checkpointVersion {
+		t.Errorf("Exported backup is %+v", backup)
+	}
+
+	if status := helperUserRequest(t, tsrv, "", "POST", "/api/import", map[string]string{"format": "other"}, nil); status != http.StatusBadRequest {
+		t.Errorf("Import of a non-backup gave status %d", status)
+	}
+	if status := helperUserRequest(t, tsrv, "", "POST", "/api/import", backup, nil); status != http.StatusOK {
+		t.Fatalf("Import gave status %d", status)
+	}
+	to.mutex.Lock()
+	puzzleID, steps := to.puzzleID, len(to.steps)
+	squares := to.steps[len(to.steps)-1].Squares()
+	to.mutex.Unlock()
+	if puzzleID != "2-star" || steps != 3 {
+		t.Errorf("Imported board has puzzle %q with %d steps", puzzleID, steps)
+	}
+	if marks := squares[mark.Index-1].Marks; len(marks) != 1 || marks[0] != mark.Value {
+		t.Errorf("Imported board has marks %v", marks)
+	}
+
+	// imported boards can be taken back move by move
+	if helperUserRequest(t, tsrv, "", "GET", "/api/back/", nil, nil); len(to.steps) != 2 {
+		t.Errorf("Imported board has %d steps after undo", len(to.steps))
+	}
+
+	// old backups are migrated
+	backup.Version, backup.Sizes = 1, nil
+	if status := helperUserRequest(t, tsrv, "", "POST", "/api/import", backup, nil); status != http.StatusOK || len(to.steps) != 3 {
+		t.Errorf("Import of a version 1 backup gave status %d, %d steps", status, len(to.steps))
+	}
+}
+
+func TestImportedBackupTrust(t *testing.T) {
+	session := newSession("test-backup-trust")
+	session.reset("2-star")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	var backup sessionBackup
+	if status := helperUserRequest(t, srv, "", "GET", "/api/export", nil, &backup); status != http.StatusOK {
+		t.Fatalf("Export gave status %d", status)
+	}
+
+	// contest and unassisted boards are refused
+	backup.Unassisted = true
+	if status := helperUserRequest(t, srv, "", "POST", "/api/import", backup, nil); status != http.StatusBadRequest {
+		t.Errorf("Import of an unassisted backup gave status %d", status)
+	}
+	backup.Unassisted, backup.Contest = false, true
+	if status := helperUserRequest(t, srv, "", "POST", "/api/import", backup, nil); status != http.StatusBadRequest {
+		t.Errorf("Import of a contest backup gave status %d", status)
+	}
+
+	// the document's statistics are ignored
+	backup.Contest = false
+	backup.Stats.Started, backup.Stats.Assignments = time.Now().Add(-time.Hour), 1
+	if status := helperUserRequest(t, srv, "", "POST", "/api/import", backup, nil); status != http.StatusOK {
+		t.Fatalf("Import gave status %d", status)
+	}
+	session.mutex.Lock()
+	stats, imported := session.stats, session.imported
+	session.mutex.Unlock()
+	if !imported || stats.Assignments != 0 || time.Since(stats.Started) > time.Minute {
+		t.Errorf("Imported board (imported %v) has statistics %+v", imported, stats)
+	}
+
+	// and completing it gives no leaderboard entry, even after a
+	// checkpoint
+	before, _ := readLeaderboard(context.Background(), "2-star")
+	c, _ := session.checkpoint()
+	restored, e := c.restore(session.sessionID)
+	if e != nil || !restored.imported {
+		t.Fatalf("Restored imported board (%v) isn't imported", e)
+	}
+	restored.mutex.Lock()
+	restored.complete()
+	restored.mutex.Unlock()
+	after, _ := readLeaderboard(context.Background(), "2-star")
+	if len(after.Entries) != len(before.Entries) {
+		t.Errorf("Completed imported board has leaderboard %+v", after)
+	}
+
+	// starting over makes the board the player's own again
+	helperUserRequest(t, srv, "", "GET", "/reset/2-star", nil, nil)
+	if session.imported {
+		t.Errorf("Reset board is still imported")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+
+Batch checking
+
+Setters who want to check a whole collection before adding it
+to the catalog can post it to /api/batch: either a JSON array of
+puzzles (each its geometry code and values), or, with the
+format=<format> parameter, the text of a collection in that
+format (see puzzle.ParseText).  The response reports on each
+puzzle, in order: whether it's proper, its solutions (no more
+than two, which is enough to show an improper puzzle is), and,
+for proper puzzles, its rating (with the same profile and
+disable parameters as /api/rating/).  Puzzles that can't be made
+or have errors (such as a value twice in a row), and puzzles that take longer than batchItemTimeout to check, get
+an error instead, which doesn't stop the others being checked.
+
+Puzzles are checked concurrently, by as many workers as the
+server has CPUs, and a batch can have at most maxBatchPuzzles
+puzzles, in at most maxBatchBytes (or it's refused with a 413).
+A batch is metered as one analysis, and only setters
+can check them.
+
+*/
+
+// maxBatchPuzzles is how many puzzles a batch can have.
+const maxBatchPuzzles = 100
+
+// maxBatchBytes is the biggest a batch's body can be.
+const maxBatchBytes = 4 << 20
+
+// batchSolutions is how many solutions are found for each
+// puzzle of a batch.
+const batchSolutions = 2
+
+// batchItemTimeout is how long each puzzle of a batch can take
+// to check.
+var batchItemTimeout = 10 * time.Second
+
+// A batchResult reports on one puzzle of a batch.
+type batchResult struct {
+	Index     int               `json:"index"` // from 1, in batch order
+	Proper    bool              `json:"proper"`
+	Solutions []puzzle.Solution `json:"solutions"`
+	Rating    *puzzle.Rating    `json:"rating,omitempty"`
+	Error     *puzzle.Error     `json:"error,omitempty"`
+}
+
+// A batchReport reports on a batch.
+type batchReport struct {
+	Proper   int           `json:"proper"`
+	Improper int           `json:"improper"`
+	Failed   int           `json:"failed"` // those with errors
+	Results  []batchResult `json:"results"`
+}
+
+// checkBatchPuzzle checks one puzzle of a batch.
+func checkBatchPuzzle(ctx context.Context, index int, vals []int, profile puzzle.RatingProfile) batchResult {
+	ctx, cancel := context.WithTimeout(ctx, batchItemTimeout)
+	defer cancel()
+	result := batchResult{Index: index, Solutions: []puzzle.Solution{}}
+	fail := func(e error) batchResult {
+		err, ok := e.(puzzle.Error)
+		if !ok {
+			err = requestError(e.Error())
+		}
+		if ctx.Err() != nil {
+			err = deadlineError()
+		}
+		result.Proper, result.Rating, result.Error = false, nil, &err
+		return result
+	}
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return fail(e)
+	}
+	if errors := p.State().Errors; len(errors) > 0 {
+		return fail(errors[0])
+	}
+	solutions, e := puzzle.SolveWatched(p, func(progress puzzle.Progress) bool {
+		return ctx.Err() == nil && progress.Solutions < batchSolutions
+	})
+	if ctx.Err() != nil || (e != nil && len(solutions) < batchSolutions) {
+		return fail(e)
+	}
+	result.Solutions = append(result.Solutions, solutions...)
+	if result.Proper = len(solutions) == 1; result.Proper {
+		rating, e := puzzle.RateContext(ctx, p, profile)
+		if e != nil {
+			return fail(e)
+		}
+		result.Rating = &rating
+	}
+	return result
+}
+
+// checkBatch checks the puzzles of a batch concurrently.
+func checkBatch(ctx context.Context, puzzles [][]int, profile puzzle.RatingProfile) batchReport {
+	report := batchReport{Results: make([]batchResult, len(puzzles))}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < runtime.NumCPU() && n < len(puzzles); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				report.Results[i] = checkBatchPuzzle(ctx, i+1, puzzles[i], profile)
+			}
+		}()
+	}
+	for i := range puzzles {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	for _, result := range report.Results {
+		switch {
+		case result.Error != nil:
+			report.Failed++
+		case result.Proper:
+			report.Proper++
+		default:
+			report.Improper++
+		}
+	}
+	return report
+}
+
+// batchHandler handles POST /api/batch, which is only routed to
+// for setters.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendError(w, http.StatusNotFound, requestError("Unknown batch operation: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	q := r.URL.Query()
+	profile, e := ratingProfile(q)
+	if e != nil {
+		sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+		return
+	}
+	body, e := puzzle.ReadBody(r.Body, maxBatchBytes)
+	if e != nil {
+		sendError(w, puzzle.DecodingStatus(e), e.(puzzle.Error))
+		return
+	}
+	var puzzles [][]int
+	if name := q.Get("format"); name != "" {
+		format, ok := puzzle.LookupTextFormat(name)
+		if !ok {
+			sendError(w, http.StatusBadRequest, fieldError("format", "Unknown batch format: "+name))
+			return
+		}
+		if puzzles, e = puzzle.ParseText(string(body), format); e != nil {
+			sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+			return
+		}
+	} else if e := json.Unmarshal(body, &puzzles); e != nil {
+		sendError(w, http.StatusBadRequest, requestError("Invalid batch: "+e.Error()))
+		return
+	}
+	if len(puzzles) == 0 {
+		sendError(w, http.StatusBadRequest, requestError("The batch has no puzzles"))
+		return
+	}
+	if len(puzzles) > maxBatchPuzzles {
+		sendError(w, http.StatusRequestEntityTooLarge,
+			requestError("Batches can have at most "+strconv.Itoa(maxBatchPuzzles)+" puzzles"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, nil), quotaAnalyze) || refuseSolverFault(w) {
+		return
+	}
+	start := time.Now()
+	report := checkBatch(r.Context(), puzzles, profile)
+	log.Printf("User %v checked a batch of %d puzzles in %v: %d proper, %d improper, %d failed.",
+		auth.FromRequest(r).Key(), len(puzzles), time.Since(start), report.Proper, report.Improper, report.Failed)
+	sendJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	srv := helperUserServer(newSession("test-batch"))
+	defer srv.Close()
+	roles.Grant("header:setter", auth.RoleSetter)
+	defer roles.Revoke("header:setter", auth.RoleSetter)
+	post := func(user, query string, body string) (int, batchReport) {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/batch"+query, strings.NewReader(body))
+		req.Header.Set("X-Test-User", user)
+		var report batchReport
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("Batch request error: %v", e)
+		}
+		defer r.Body.Close()
+		if r.StatusCode == http.StatusOK {
+			if e := json.NewDecoder(r.Body).Decode(&report); e != nil {
+				t.Fatalf("Batch decode error: %v", e)
+			}
+		}
+		return r.StatusCode, report
+	}
+
+	// a proper puzzle, an improper one, and one that can't be made
+	improper := append([]int(nil), puzzleValues["1-star"]...)
+	for i := 1; i < 30; i++ {
+		improper[i] = 0
+	}
+	invalid := append([]int{puzzle.SudokuGeometryCode}, make([]int, 81)...)
+	invalid[1], invalid[2] = 5, 5
+	text, _ := puzzle.WriteText([][]int{puzzleValues["1-star"], improper, invalid}, puzzle.SDMFormat)
+	if status, _ := post("player", "?format=sdm", text); status != http.StatusForbidden {
+		t.Errorf("Batch by a player gave status %d", status)
+	}
+	status, report := post("setter", "?format=sdm", text)
+	if status != http.StatusOK || len(report.Results) != 3 ||
+		report.Proper != 1 || report.Improper != 1 || report.Failed != 1 {
+		t.Fatalf("Batch gave %d, %+v", status, report)
+	}
+	for i, result := range report.Results {
+		if result.Index != i+1 {
+			t.Errorf("Result %d has index %d", i, result.Index)
+		}
+	}
+	if r := report.Results[0]; !r.Proper || len(r.Solutions) != 1 || r.Rating == nil ||
+		r.Rating.Stars != starsOf(puzzleValues["1-star"], true) {
+		t.Errorf("Proper puzzle gave %+v", r)
+	}
+	if r := report.Results[1]; r.Proper || len(r.Solutions) != batchSolutions || r.Rating != nil || r.Error != nil {
+		t.Errorf("Improper puzzle gave %+v", r)
+	}
+	if r := report.Results[2]; r.Error == nil {
+		t.Errorf("Invalid puzzle gave %+v", r)
+	}
+
+	// JSON batches, and puzzles that run out of time
+	defer func(timeout time.Duration) { batchItemTimeout = timeout }(batchItemTimeout)
+	batchItemTimeout = time.Nanosecond
+	status, report = post("setter", "", `[[0,`+strings.Repeat("0,", 80)+`0]]`)
+	if status != http.StatusOK || report.Failed != 1 ||
+		report.Results[0].Error.Condition != puzzle.DeadlineExceededCondition {
+		t.Errorf("Timed out batch gave %d, %+v", status, report)
+	}
+
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat("[0],", maxBatchPuzzles+1), ",") + "]"
+	for body, want := range map[string]int{"[]": http.StatusBadRequest, "not json": http.StatusBadRequest,
+		tooMany: http.StatusRequestEntityTooLarge, strings.Repeat(" ", maxBatchBytes+1): http.StatusRequestEntityTooLarge} {
+		if status, _ := post("setter", "", body); status != want {
+			t.Errorf("Batch %.20q gave status %d, not %d", body, status, want)
+		}
+	}
+	if status, _ := post("setter", "?format=csv", text); status != http.StatusBadRequest {
+		t.Errorf("Batch in an unknown format gave status %d", status)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Blitz
+
+In blitz mode, players race the clock on easy puzzles, which
+are generated fresh (with lots of givens) for every attempt.
+Resetting to the puzzle ID "blitz" starts an attempt, and the
+server keeps its countdown.  Solving the puzzle in time enters
+the solve on the blitz leaderboard (the leaderboard of the
+"blitz" puzzle ID) like for any other puzzle.  When time runs
+out, the attempt is over: the board takes no more changes, and
+the result (how many of the empty squares were filled in right)
+is entered on the blitz leaderboard, after all the solves.  GET
+/api/blitz/ gives the state of the board's attempt.
+
+The time limit is set by SUSEN_BLITZ_SECONDS, and is 3 minutes
+by default.
+
+*/
+
+// blitzPuzzleID is the puzzle ID of blitz puzzles, and
+// blitzGivens is how many givens they have.
+const (
+	blitzPuzzleID = "blitz"
+	blitzGivens   = 50
+)
+
+// blitzDuration is the time limit for blitz attempts.
+var blitzDuration = blitzDurationFromEnv()
+
+// blitzDurationFromEnv returns the blitz time limit, as set in
+// the environment or else the default.
+func blitzDurationFromEnv() time.Duration {
+	if s := os.Getenv("SUSEN_BLITZ_SECONDS"); s != "" {
+		if n, e := strconv.Atoi(s); e == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+		log.Printf("Ignoring invalid SUSEN_BLITZ_SECONDS value %q.", s)
+	}
+	return 3 * time.Minute
+}
+
+// A blitzAttempt is a board's race against the clock.  Like the
+// board it's on, it must only be used with the board locked.
+type blitzAttempt struct {
+	seed     string
+	deadline time.Time
+	solution []int         // the puzzle's solution
+	board    *susenBoard   // the board the attempt is on
+	player   *susenSession // the session credited with the result
+	timer    *time.Timer   // fires at the deadline
+	over     bool          // solved, expired, or abandoned
+	expired  bool
+}
+
+// A blitzStatus is the state of a blitz attempt, as given to
+// clients.  Filled is only given once the attempt is over.
+type blitzStatus struct {
+	Seed      string    `json:"seed"`
+	Deadline  time.Time `json:"deadline"`
+	Remaining float64   `json:"remaining"` // seconds
+	Over      bool      `json:"over"`
+	Expired   bool      `json:"expired,omitempty"`
+	Filled    int       `json:"filled,omitempty"`
+}
+
+// startBlitz starts the session's board over with a new blitz
+// attempt.
+func (session *susenSession) startBlitz() error {
+	g, e := generatePuzzle(context.Background(), puzzle.GenerateParams{Seed: randomSeed(), Givens: blitzGivens}, nil)
+	if e != nil {
+		log.Fatal(e)
+	}
+	p, e := puzzle.New(g.Values)
+	if e != nil {
+		log.Fatal(e)
+	}
+	solution := p.Solutions()[0].Values // generated puzzles are proper
+	if e := session.start(blitzPuzzleID, g.Values); e != nil {
+		return e
+	}
+	a := &blitzAttempt{
+		seed:     g.Seed,
+		deadline: session.stats.Started.Add(blitzDuration),
+		solution: solution,
+		board:    session.susenBoard,
+		player:   session,
+	}
+	a.timer = time.AfterFunc(blitzDuration, a.timeUp)
+	session.blitz = a
+	log.Printf("Session %v started blitz attempt with seed %q.", session.sessionID, a.seed)
+	return nil
+}
+
+// continueOn makes a copy of an attempt for a copy of its board,
+// with the same deadline, credited to the given session.
+func (a *blitzAttempt) continueOn(board *susenBoard, player *susenSession) *blitzAttempt {
+	c := *a
+	c.board, c.player = board, player
+	c.timer = time.AfterFunc(time.Until(a.deadline), c.timeUp)
+	if c.over {
+		c.timer.Stop()
+	}
+	return &c
+}
+
+// finish ends an attempt without a result, as when it's solved
+// (and so has its result as a solve) or abandoned.
+func (a *blitzAttempt) finish() {
+	a.over = true
+	a.timer.Stop()
+}
+
+// stopBlitz abandons the board's blitz attempt, if it has one.
+func (board *susenBoard) stopBlitz() {
+	if board.blitz != nil {
+		board.blitz.finish()
+		board.blitz = nil
+	}
+}
+
+// timeUp is called at an attempt's deadline, to expire it
+// unless it's already over.
+func (a *blitzAttempt) timeUp() {
+	a.board.mutex.Lock()
+	defer a.board.mutex.Unlock()
+	a.expire()
+}
+
+// checkBlitz expires the board's blitz attempt if it's past its
+// deadline, in case the timer hasn't got to it yet.  It tells
+// whether the board has an attempt that's over.
+func (board *susenBoard) checkBlitz() bool {
+	a := board.blitz
+	if a == nil {
+		return false
+	}
+	if !a.over && !time.Now().Before(a.deadline) {
+		a.expire()
+	}
+	return a.over
+}
+
+// expire ends an attempt that ran out of time, recording its
+// result in the board's statistics and on the blitz leaderboard,
+// and telling the board's watchers.
+func (a *blitzAttempt) expire() {
+	board := a.board
+	if board.blitz != a || a.over {
+		return
+	}
+	a.over, a.expired = true, true
+	board.stats.Completed = &a.deadline
+	board.stats.SolveTime = a.deadline.Sub(board.stats.Started).Seconds()
+	board.stats.Expired = true
+	filled := a.filled()
+	log.Printf("Blitz attempt %q ran out of time with %d squares right.", a.seed, filled)
+	key, name := a.player.player()
+	addLeaderboardEntry(blitzPuzzleID, leaderboardEntry{
+		Key:        key,
+		Name:       name,
+		SolveTime:  board.stats.SolveTime,
+		Moves:      board.stats.Assignments,
+		Undos:      board.stats.Undos,
+		Hints:      board.stats.Hints,
+		Completed:  a.deadline,
+		Unassisted: board.unassisted,
+		Expired:    true,
+		Filled:     filled,
+	})
+	for _, member := range board.members {
+		member.notify(sessionEvent{Type: expiredEventType})
+	}
+}
+
+// filled counts the squares that weren't given and that have
+// been filled in right.
+func (a *blitzAttempt) filled() int {
+	count := 0
+	values := a.board.steps[len(a.board.steps)-1].State().Values
+	for i, v := range values {
+		if a.board.values[i+1] == 0 && v == a.solution[i] {
+			count++
+		}
+	}
+	return count
+}
+
+// refuseBlitzChange sends an error response, and returns true,
+// if the request would change a board whose blitz attempt is
+// over.  Starting the board over is always allowed.
+func (board *susenBoard) refuseBlitzChange(w http.ResponseWriter, r *http.Request) bool {
+	if strings.Contains(r.URL.Path, "/reset/") || !board.checkBlitz() {
+		return false
+	}
+	if r.Method == "GET" && !strings.Contains(r.URL.Path, "/back/") {
+		return false
+	}
+	sendError(w, http.StatusConflict, requestError("The blitz attempt is over"))
+	return true
+}
+
+// blitzHandler handles GET /api/blitz/, which gives the state of
+// the board's blitz attempt.
+func (session *susenSession) blitzHandler(w http.ResponseWriter, r *http.Request) {
+	session.checkBlitz()
+	a := session.blitz
+	if a == nil {
+		sendError(w, http.StatusNotFound, requestError("The board isn't in a blitz attempt"))
+		return
+	}
+	status := blitzStatus{Seed: a.seed, Deadline: a.deadline, Over: a.over, Expired: a.expired}
+	if a.over {
+		status.Filled = a.filled()
+	} else {
+		status.Remaining = time.Until(a.deadline).Seconds()
+	}
+	sendJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBlitz(t *testing.T) {
+	savedStore, savedDuration := store, blitzDuration
+	store, blitzDuration = storage.NewMemory(), 100*time.Millisecond
+	defer func() { store, blitzDuration = savedStore, savedDuration }()
+	session := newSession("test-blitz")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var status blitzStatus
+	if code := helperGetJSON(t, srv, "/api/blitz/", &status); code != http.StatusNotFound {
+		t.Errorf("Blitz status without an attempt gave status %d", code)
+	}
+	r, e := http.Get(srv.URL + "/reset/blitz")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if code := helperGetJSON(t, srv, "/api/blitz/", &status); code != http.StatusOK || status.Over || status.Remaining <= 0 {
+		t.Fatalf("Blitz status after start is %d, %+v", code, status)
+	}
+	givens := 0
+	for _, v := range session.values[1:] {
+		if v != 0 {
+			givens++
+		}
+	}
+	if session.puzzleID != blitzPuzzleID || givens < blitzGivens {
+		t.Errorf("Blitz puzzle is %q with %d givens", session.puzzleID, givens)
+	}
+
+	// fill one square, then run out of time
+	session.mutex.Lock()
+	solution := session.blitz.solution
+	session.mutex.Unlock()
+	empty := 0
+	for session.values[empty+1] != 0 {
+		empty++
+	}
+	helperRoomAssign(t, srv, puzzle.Choice{Index: empty + 1, Value: solution[empty]})
+	time.Sleep(2 * blitzDuration)
+	status = blitzStatus{}
+	helperGetJSON(t, srv, "/api/blitz/", &status)
+	if !status.Over || !status.Expired || status.Filled != 1 {
+		t.Errorf("Blitz status after time ran out is %+v", status)
+	}
+	for empty++; session.values[empty+1] != 0; empty++ {
+	}
+	if code := helperRoomAssign(t, srv, puzzle.Choice{Index: empty + 1, Value: solution[empty]}); code != http.StatusConflict {
+		t.Errorf("Assign after time ran out gave status %d", code)
+	}
+	var lb leaderboard
+	helperGetJSON(t, srv, "/api/leaderboard/"+blitzPuzzleID, &lb)
+	if len(lb.Entries) != 1 || !lb.Entries[0].Expired || lb.Entries[0].Filled != 1 {
+		t.Errorf("Blitz leaderboard after time ran out is %+v", lb)
+	}
+
+	// a solve in time ranks before running out of time
+	blitzDuration = time.Minute
+	r, e = http.Get(srv.URL + "/reset/blitz")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	helperSolve(t, srv, session)
+	status = blitzStatus{}
+	helperGetJSON(t, srv, "/api/blitz/", &status)
+	if !status.Over || status.Expired {
+		t.Errorf("Blitz status after solve is %+v", status)
+	}
+	lb = leaderboard{}
+	helperGetJSON(t, srv, "/api/leaderboard/"+blitzPuzzleID, &lb)
+	if len(lb.Entries) != 1 || lb.Entries[0].Expired || lb.Entries[0].SolveTime != session.stats.SolveTime {
+		t.Errorf("Blitz leaderboard after solve is %+v", lb)
+	}
+}
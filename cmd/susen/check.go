@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+)
+
+/*
+
+Checking choices
+
+POST /api/check with a choice in the body (as for an
+assignment) says what assigning the choice would do, without
+assigning it (see puzzle.Check): whether it's legal, what values
+it would eliminate from the other squares, what errors it would
+cause, and whether the puzzle would still be solvable.  Since
+the board isn't changed, checks don't count as moves, and leave
+the undo history alone.
+
+Checks tell whether a choice is right, so contest and unassisted
+boards can't have them, and they're metered as analysis (see
+quota.go).  The board is unlocked while the check searches for a
+solution.
+
+*/
+
+// checkHandler handles POST /api/check.
+func (session *susenSession) checkHandler(w http.ResponseWriter, r *http.Request) {
+	if session.contest || session.unassisted {
+		sendError(w, http.StatusForbidden, requestError("Contest and unassisted boards can't check choices"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) || refuseSolverFault(w) {
+		return
+	}
+	var result puzzle.CheckResult
+	var e error
+	session.unlocked(func(step puzzle.Puzzle) {
+		result, e = puzzle.CheckHandler(step, w, r)
+	})
+	if e == nil {
+		debugf("Checked choice %+v for session %v: legal %v, solvable %v.",
+			result.Choice, session.sessionID, result.Legal, result.Solvable)
+	} else {
+		log.Printf("Check for session %v failed: %v", session.sessionID, e)
+	}
+}
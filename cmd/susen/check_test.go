@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckChoice(t *testing.T) {
+	session := newSession("test-check")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	p, e := puzzle.New(session.values)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	solution := p.Solutions()[0].Values
+	index := 0
+	for i, s := range p.Squares() {
+		if s.Aval == 0 {
+			index = i + 1
+			break
+		}
+	}
+
+	// checks report on the choice, and leave the board alone
+	var result puzzle.CheckResult
+	choice := puzzle.Choice{Index: index, Value: solution[index-1]}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/check", choice, &result); status != http.StatusOK ||
+		!result.Legal || !result.Solvable || result.Choice != choice {
+		t.Fatalf("Check of the right value gave %d, %+v", status, result)
+	}
+	wrong := puzzle.Choice{Index: index, Value: solution[index-1]%9 + 1}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/check", wrong, &result); status != http.StatusOK || result.Solvable {
+		t.Errorf("Check of a wrong value gave %d, %+v", status, result)
+	}
+	if len(session.steps) != 1 || session.stats.Assignments != 0 {
+		t.Errorf("Checks left %d steps, %d assignments", len(session.steps), session.stats.Assignments)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/check", "index", nil); status != http.StatusBadRequest {
+		t.Errorf("Check of a bad choice gave status %d", status)
+	}
+
+	// contest boards can't check
+	session.contest = true
+	defer func() { session.contest = false }()
+	if status := helperUserRequest(t, srv, "", "POST", "/api/check", choice, nil); status != http.StatusForbidden {
+		t.Errorf("Check on a contest board gave status %d", status)
+	}
+}
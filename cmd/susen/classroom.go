@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/csv"
+	"github.com/ancientHacker/susen.go/auth"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Classrooms
+
+Teachers (users with the teacher role) can create classes, which
+students join by the class's code.  The teacher assigns puzzles
+to the class as homework, and gets a dashboard of how each
+student is doing on each of them: not started, in progress (the
+student's board has the puzzle now), or completed.
+
+Homework can have a due date.  A student's homework is on time
+if they first completed it by then, late if they completed it
+after, and overdue if it's past due and they haven't.  The
+teacher can get a summary of each homework's completions, and
+the whole dashboard as a CSV gradebook.
+
+Completions by identified users are recorded per user and
+puzzle, like the leaderboards (a user's best solve, plus when
+they first completed the puzzle and how often), and the
+dashboard reports them.  Classes and results are kept in the
+store.
+
+*/
+
+// A classMember is a student in a class.
+type classMember struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// A homework is a puzzle assigned to a class, maybe with a due
+// date.
+type homework struct {
+	PuzzleID string     `json:"puzzleID"`
+	Assigned time.Time  `json:"assigned"`
+	Due      *time.Time `json:"due,omitempty"`
+}
+
+// A class is a teacher's group of students and their homework.
+type class struct {
+	Code     string        `json:"code"`
+	Name     string        `json:"name"`
+	Teacher  classMember   `json:"teacher"`
+	Students []classMember `json:"students"`
+	Homework []homework    `json:"homework"`
+}
+
+// A puzzleResult is a user's record on a puzzle: their best
+// solve, when they first completed it, and how many times they
+// have.
+type puzzleResult struct {
+	First       time.Time        `json:"first"`
+	Completions int              `json:"completions"`
+	Best        leaderboardEntry `json:"best"`
+}
+
+// A homeworkProgress is how a student is doing on a homework
+// puzzle.  The result is only given for completed homework, and
+// the timing only for homework with a due date.
+type homeworkProgress struct {
+	PuzzleID string        `json:"puzzleID"`
+	Status   string        `json:"status"`
+	Timing   string        `json:"timing,omitempty"`
+	Result   *puzzleResult `json:"result,omitempty"`
+}
+
+// A homeworkSummary counts how a class's students are doing on
+// a homework puzzle.
+type homeworkSummary struct {
+	PuzzleID  string     `json:"puzzleID"`
+	Due       *time.Time `json:"due,omitempty"`
+	Students  int        `json:"students"`
+	Completed int        `json:"completed"`
+	OnTime    int        `json:"onTime"`
+	Late      int        `json:"late"`
+	Overdue   int        `json:"overdue"`
+}
+
+// A studentProgress is a student's progress on all of a class's
+// homework.
+type studentProgress struct {
+	classMember
+	Homework []homeworkProgress `json:"homework"`
+}
+
+// A classProgress is the teacher's dashboard for a class.
+type classProgress struct {
+	Code     string            `json:"code"`
+	Name     string            `json:"name"`
+	Students []studentProgress `json:"students"`
+}
+
+// The homework statuses and timings.
+const (
+	notStartedStatus = "not started"
+	inProgressStatus = "in progress"
+	completedStatus  = "completed"
+	onTimeTiming     = "on time"
+	lateTiming       = "late"
+	overdueTiming    = "overdue"
+)
+
+// classKind and resultKind are the storage kinds for classes and
+// puzzle results.
+const (
+	classKind  = "class"
+	resultKind = "puzzle-result"
+)
+
+var (
+	classMutex  sync.Mutex // serializes the updates of classes
+	resultMutex sync.Mutex // serializes the updates of results
+)
+
+// resultKey is the storage key for a user's result on a puzzle.
+func resultKey(userKey, puzzleID string) string {
+	return userKey + "|" + puzzleID
+}
+
+// recordResult adds a completion to the session's user's result
+// for a puzzle.  Anonymous sessions have no results.
+func (session *susenSession) recordResult(puzzleID string, entry leaderboardEntry) {
+	session.infoMutex.Lock()
+	identified := session.user != nil
+	session.infoMutex.Unlock()
+	if !identified {
+		return
+	}
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+	key := resultKey(entry.Key, puzzleID)
+	var result puzzleResult
+	found, e := store.Get(resultKind, key, &result)
+	if e != nil {
+		log.Printf("Can't read result %q: %v", key, e)
+		return
+	}
+	if !found {
+		result.First, result.Best = entry.Completed, entry
+	} else if entry.ranksBefore(result.Best) {
+		result.Best = entry
+	}
+	result.Completions++
+	if e := store.Put(resultKind, key, result); e != nil {
+		log.Printf("Can't save result %q: %v", key, e)
+	}
+}
+
+// readResult returns a user's result on a puzzle, if they have
+// completed it.
+func readResult(userKey, puzzleID string) (puzzleResult, bool) {
+	return getResult(store.Get, userKey, puzzleID)
+}
+
+// reportResult is readResult for reports, which can read from
+// the replica (see replica.go).
+func reportResult(ctx context.Context, userKey, puzzleID string) (puzzleResult, bool) {
+	get := func(kind, key string, v interface{}) (bool, error) {
+		return reportGet(ctx, kind, key, v)
+	}
+	return getResult(get, userKey, puzzleID)
+}
+
+// getResult reads a user's result on a puzzle with the given
+// store read.
+func getResult(get func(kind, key string, v interface{}) (bool, error), userKey, puzzleID string) (puzzleResult, bool) {
+	var result puzzleResult
+	resultMutex.Lock()
+	defer resultMutex.Unlock()
+	found, e := get(resultKind, resultKey(userKey, puzzleID), &result)
+	if e != nil {
+		log.Printf("Can't read result %q: %v", resultKey(userKey, puzzleID), e)
+	}
+	return result, found
+}
+
+// newClassCode returns a code that isn't used by any class.  It
+// must be called with the classes locked.
+func newClassCode() string {
+	for {
+		var b [5]byte
+		if _, e := rand.Read(b[:]); e != nil {
+			log.Fatal(e)
+		}
+		code := base32.StdEncoding.EncodeToString(b[:])
+		if found, e := store.Get(classKind, code, &class{}); e == nil && !found {
+			return code
+		}
+	}
+}
+
+// teaches tells whether a user can manage a class: its teacher
+// can, and so can admins.
+func (c *class) teaches(u *auth.User) bool {
+	return c.Teacher.Key == u.Key() || roles.Has(u, auth.RoleAdmin)
+}
+
+// member tells whether a user is the teacher or a student of a
+// class.
+func (c *class) member(u *auth.User) bool {
+	if c.Teacher.Key == u.Key() {
+		return true
+	}
+	for _, s := range c.Students {
+		if s.Key == u.Key() {
+			return true
+		}
+	}
+	return false
+}
+
+// classHandler handles the classroom endpoints, which are only
+// for identified users:
+//
+// - POST /api/class/ creates a class (teachers only); the body
+// gives the class's name
+//
+// - GET /api/class/ lists the classes the user is in (or, for
+// admins, all classes)
+//
+// - POST /api/class/<code>/join adds the user to a class
+//
+// - GET /api/class/<code> describes a class (members only)
+//
+// - POST /api/class/<code>/homework/<puzzleID> assigns a puzzle
+// to a class, or changes its due date (the body, if any, gives
+// the due date), and DELETE unassigns it (the class's teacher
+// only)
+//
+// - GET /api/class/<code>/progress gives the class's dashboard,
+// /summary gives the homework summaries, and /gradebook gives
+// the dashboard as CSV (the class's teacher only)
+//
+// The others respond with the class (or the list of classes).
+func classHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.FromRequest(r)
+	if user == nil {
+		sendError(w, http.StatusUnauthorized, requestError("Classes are only for logged-in users"))
+		return
+	}
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/class/"), "/"), "/", 3)
+	if parts[0] == "" {
+		if r.Method == "POST" {
+			createClass(w, r, user)
+		} else {
+			listClasses(w, user)
+		}
+		return
+	}
+	code := strings.ToUpper(parts[0])
+	classMutex.Lock()
+	defer classMutex.Unlock()
+	var c class
+	if found, e := store.Get(classKind, code, &c); e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't read class: "+e.Error()))
+		return
+	} else if !found {
+		sendError(w, http.StatusNotFound, requestError("No class with code "+code))
+		return
+	}
+	op := ""
+	if len(parts) > 1 {
+		op = parts[1]
+	}
+	switch {
+	case op == "" && r.Method == "GET":
+		if !c.member(user) && !c.teaches(user) {
+			sendError(w, http.StatusForbidden, requestError("You aren't in class "+code))
+			return
+		}
+		sendJSON(w, http.StatusOK, c)
+	case op == "join" && r.Method == "POST":
+		if !c.member(user) {
+			c.Students = append(c.Students, classMember{user.Key(), user.Name})
+			if !saveClass(w, c) {
+				return
+			}
+			log.Printf("User %v joined class %v.", user.Key(), code)
+		}
+		sendJSON(w, http.StatusOK, c)
+	case op == "homework" && len(parts) == 3 && (r.Method == "POST" || r.Method == "DELETE"):
+		if !c.teaches(user) {
+			sendError(w, http.StatusForbidden, requestError("Only the teacher can assign homework"))
+			return
+		}
+		changeHomework(w, r, c, parts[2])
+	case (op == "progress" || op == "summary" || op == "gradebook") && r.Method == "GET":
+		if !c.teaches(user) {
+			sendError(w, http.StatusForbidden, requestError("Only the teacher can see the class's progress"))
+			return
+		}
+		dash := c.progress(r.Context())
+		if refuseExpired(w, r) {
+			return
+		}
+		switch op {
+		case "progress":
+			sendJSON(w, http.StatusOK, dash)
+		case "summary":
+			sendJSON(w, http.StatusOK, c.summarize(dash))
+		default:
+			sendGradebook(w, c, dash)
+		}
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown class operation: "+r.Method+" "+op))
+	}
+}
+
+// saveClass saves a class.  If that fails, it sends an error
+// response and returns false.
+func saveClass(w http.ResponseWriter, c class) bool {
+	if e := store.Put(classKind, c.Code, c); e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't save class: "+e.Error()))
+		return false
+	}
+	return true
+}
+
+// createClass creates a class taught by the user.
+func createClass(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	if !roles.Has(user, auth.RoleTeacher) {
+		sendError(w, http.StatusForbidden, requestError("Only teachers can create classes"))
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if e := decodeRequest(w, r, &req); e != nil || strings.TrimSpace(req.Name) == "" {
+		sendError(w, requestStatus(e), requestError("A class name is required"))
+		return
+	}
+	classMutex.Lock()
+	defer classMutex.Unlock()
+	c := class{
+		Code:     newClassCode(),
+		Name:     strings.TrimSpace(req.Name),
+		Teacher:  classMember{user.Key(), user.Name},
+		Students: []classMember{},
+		Homework: []homework{},
+	}
+	if !saveClass(w, c) {
+		return
+	}
+	log.Printf("User %v created class %v (%q).", user.Key(), c.Code, c.Name)
+	sendJSON(w, http.StatusOK, c)
+}
+
+// listClasses sends the classes the user is in.
+func listClasses(w http.ResponseWriter, user *auth.User) {
+	classMutex.Lock()
+	defer classMutex.Unlock()
+	codes, e := store.Keys(classKind)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't list classes: "+e.Error()))
+		return
+	}
+	list := []class{}
+	for _, code := range codes {
+		var c class
+		if found, e := store.Get(classKind, code, &c); e != nil || !found {
+			continue
+		}
+		if c.member(user) || roles.Has(user, auth.RoleAdmin) {
+			list = append(list, c)
+		}
+	}
+	sendJSON(w, http.StatusOK, list)
+}
+
+// changeHomework assigns a puzzle to a class (or changes its due
+// date), or unassigns it, depending on the request method.
+// "daily" means today's daily puzzle.  It must be called with
+// the classes locked.
+func changeHomework(w http.ResponseWriter, r *http.Request, c class, puzzleID string) {
+	if puzzleID == dailyPuzzleID {
+		puzzleID = todayID(r)
+	}
+	var req struct {
+		Due *time.Time `json:"due"`
+	}
+	if e := decodeRequest(w, r, &req); e != nil && e != io.EOF {
+		sendError(w, requestStatus(e), requestError("Invalid homework request: "+e.Error()))
+		return
+	}
+	index := -1
+	for i, hw := range c.Homework {
+		if hw.PuzzleID == puzzleID {
+			index = i
+		}
+	}
+	if r.Method == "DELETE" {
+		if index < 0 {
+			sendError(w, http.StatusNotFound, requestError("Puzzle "+puzzleID+" isn't assigned"))
+			return
+		}
+		c.Homework = append(c.Homework[:index], c.Homework[index+1:]...)
+	} else if index < 0 {
+		// blitz puzzles are different on every attempt, so
+		// they can't be homework
+		if puzzleID == blitzPuzzleID || !knownPuzzleID(puzzleID) {
+			sendError(w, http.StatusNotFound, requestError("No puzzle "+puzzleID))
+			return
+		}
+		c.Homework = append(c.Homework, homework{puzzleID, time.Now(), req.Due})
+	} else {
+		c.Homework[index].Due = req.Due
+	}
+	if !saveClass(w, c) {
+		return
+	}
+	log.Printf("Class %v homework is now %d puzzles.", c.Code, len(c.Homework))
+	sendJSON(w, http.StatusOK, c)
+}
+
+// progress returns the class's dashboard.  Results that can't
+// be read before the context is done are left out.
+func (c *class) progress(ctx context.Context) classProgress {
+	dash := classProgress{Code: c.Code, Name: c.Name, Students: []studentProgress{}}
+	for _, s := range c.Students {
+		sp := studentProgress{classMember: s, Homework: []homeworkProgress{}}
+		current := currentPuzzle(s.Key)
+		for _, hw := range c.Homework {
+			hp := homeworkProgress{PuzzleID: hw.PuzzleID, Status: notStartedStatus}
+			if result, found := reportResult(ctx, s.Key, hw.PuzzleID); found {
+				hp.Status, hp.Result = completedStatus, &result
+			} else if current == hw.PuzzleID {
+				hp.Status = inProgressStatus
+			}
+			hp.Timing = hw.timing(hp.Result)
+			sp.Homework = append(sp.Homework, hp)
+		}
+		dash.Students = append(dash.Students, sp)
+	}
+	return dash
+}
+
+// currentPuzzle returns the ID of the puzzle on a user's board,
+// if they have a session.
+func currentPuzzle(userKey string) string {
+	sessionMutex.RLock()
+	session, ok := sessions["user:"+userKey]
+	sessionMutex.RUnlock()
+	if !ok {
+		return ""
+	}
+	session.lockBoard()
+	defer session.unlockBoard()
+	return session.puzzleID
+}
+
+// timing returns whether a student's homework is on time, late,
+// or overdue, given their result (nil if they haven't completed
+// it).  Homework without a due date has no timing, and neither
+// does incomplete homework that isn't due yet.
+func (hw homework) timing(result *puzzleResult) string {
+	switch {
+	case hw.Due == nil:
+		return ""
+	case result != nil && !result.First.After(*hw.Due):
+		return onTimeTiming
+	case result != nil:
+		return lateTiming
+	case time.Now().After(*hw.Due):
+		return overdueTiming
+	}
+	return ""
+}
+
+// summarize returns the summaries of the class's homework, given
+// its dashboard.
+func (c *class) summarize(dash classProgress) []homeworkSummary {
+	summaries := make([]homeworkSummary, len(c.Homework))
+	for i, hw := range c.Homework {
+		summaries[i] = homeworkSummary{PuzzleID: hw.PuzzleID, Due: hw.Due, Students: len(dash.Students)}
+	}
+	for _, sp := range dash.Students {
+		for i, hp := range sp.Homework {
+			if hp.Status == completedStatus {
+				summaries[i].Completed++
+			}
+			switch hp.Timing {
+			case onTimeTiming:
+				summaries[i].OnTime++
+			case lateTiming:
+				summaries[i].Late++
+			case overdueTiming:
+				summaries[i].Overdue++
+			}
+		}
+	}
+	return summaries
+}
+
+// sendGradebook sends a class's dashboard as CSV, with a row for
+// each student, and for each homework the student's status, its
+// timing, and when they first completed it.
+func sendGradebook(w http.ResponseWriter, c class, dash classProgress) {
+	header := []string{"student", "key"}
+	for _, hw := range c.Homework {
+		header = append(header, hw.PuzzleID, hw.PuzzleID+" timing", hw.PuzzleID+" completed")
+	}
+	rows := [][]string{header}
+	for _, sp := range dash.Students {
+		row := []string{sp.Name, sp.Key}
+		for _, hp := range sp.Homework {
+			completed := ""
+			if hp.Result != nil {
+				completed = hp.Result.First.UTC().Format(time.RFC3339)
+			}
+			row = append(row, hp.Status, hp.Timing, completed)
+		}
+		rows = append(rows, row)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+c.Code+`.csv"`)
+	if e := csv.NewWriter(w).WriteAll(rows); e != nil {
+		log.Printf("Can't send gradebook for class %v: %v", c.Code, e)
+	}
+}
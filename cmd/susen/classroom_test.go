@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClassroom(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	srv := helperUserServer(newSession("test-classroom"))
+	defer srv.Close()
+	roles.Grant("header:tina", auth.RoleTeacher)
+	defer roles.Revoke("header:tina", auth.RoleTeacher)
+
+	// the student has a session of their own, to play homework on
+	student := newSession("test-classroom-student")
+	student.setUser(&auth.User{ID: "sam", Name: "sam", Source: "header"})
+	sessionMutex.Lock()
+	sessions["user:header:sam"] = student
+	sessionMutex.Unlock()
+	defer func() {
+		sessionMutex.Lock()
+		delete(sessions, "user:header:sam")
+		sessionMutex.Unlock()
+	}()
+	studentSrv := httptest.NewServer(http.HandlerFunc(student.rootHandler))
+	defer studentSrv.Close()
+
+	if status := helperUserRequest(t, srv, "", "GET", "/api/class/", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Anonymous class listing gave status %d", status)
+	}
+	body := map[string]string{"name": "Logic 101"}
+	if status := helperUserRequest(t, srv, "sam", "POST", "/api/class/", body, nil); status != http.StatusForbidden {
+		t.Errorf("Class creation by a student gave status %d", status)
+	}
+	var c class
+	if status := helperUserRequest(t, srv, "tina", "POST", "/api/class/", body, &c); status != http.StatusOK {
+		t.Fatalf("Class creation gave status %d", status)
+	}
+	if c.Name != "Logic 101" || c.Teacher.Key != "header:tina" || len(c.Code) != 8 {
+		t.Errorf("Created class is %+v", c)
+	}
+	base := "/api/class/" + c.Code
+	if status := helperUserRequest(t, srv, "sam", "GET", base, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Class description for a non-member gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "sam", "POST", base+"/join", nil, &c); status != http.StatusOK || len(c.Students) != 1 {
+		t.Fatalf("Join gave %d, %+v", status, c)
+	}
+	var list []class
+	if helperUserRequest(t, srv, "sam", "GET", "/api/class/", nil, &list); len(list) != 1 || list[0].Code != c.Code {
+		t.Errorf("Student's class list is %+v", list)
+	}
+
+	// only the teacher assigns homework, and only known puzzles
+	if status := helperUserRequest(t, srv, "sam", "POST", base+"/homework/"+defaultPuzzleID, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Homework assignment by a student gave status %d", status)
+	}
+	for _, id := range []string{"no-such-puzzle", blitzPuzzleID} {
+		if status := helperUserRequest(t, srv, "tina", "POST", base+"/homework/"+id, nil, nil); status != http.StatusNotFound {
+			t.Errorf("Assigning %q gave status %d", id, status)
+		}
+	}
+	for _, id := range []string{defaultPuzzleID, "2-star", "daily"} {
+		if status := helperUserRequest(t, srv, "tina", "POST", base+"/homework/"+id, nil, &c); status != http.StatusOK {
+			t.Fatalf("Assigning %q gave status %d", id, status)
+		}
+	}
+	if status := helperUserRequest(t, srv, "tina", "DELETE", base+"/homework/daily", nil, &c); status != http.StatusOK || len(c.Homework) != 2 {
+		t.Errorf("Unassigning daily gave %d, %+v", status, c.Homework)
+	}
+
+	// the dashboard follows the student's play
+	var dash classProgress
+	if status := helperUserRequest(t, srv, "sam", "GET", base+"/progress", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Dashboard for a student gave status %d", status)
+	}
+	checkStatuses := func(when string, want ...string) {
+		dash = classProgress{}
+		if status := helperUserRequest(t, srv, "tina", "GET", base+"/progress", nil, &dash); status != http.StatusOK {
+			t.Fatalf("%s: dashboard gave status %d", when, status)
+		}
+		if len(dash.Students) != 1 || len(dash.Students[0].Homework) != len(want) {
+			t.Fatalf("%s: dashboard is %+v", when, dash)
+		}
+		for i, hp := range dash.Students[0].Homework {
+			if hp.Status != want[i] {
+				t.Errorf("%s: homework %q has status %q, not %q", when, hp.PuzzleID, hp.Status, want[i])
+			}
+		}
+	}
+	reset := func(puzzleID string) {
+		student.mutex.Lock()
+		defer student.mutex.Unlock()
+		student.reset(puzzleID)
+	}
+	reset("2-star")
+	checkStatuses("started", notStartedStatus, inProgressStatus)
+	reset(defaultPuzzleID)
+	helperSolve(t, studentSrv, student)
+	checkStatuses("solved", completedStatus, notStartedStatus)
+	result := dash.Students[0].Homework[0].Result
+	if result == nil || result.Completions != 1 || result.Best.Moves != student.stats.Assignments {
+		t.Errorf("Completed homework result is %+v", result)
+	}
+}
+
+func TestClassroomDeadlines(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	srv := helperUserServer(newSession("test-classroom-deadlines"))
+	defer srv.Close()
+	roles.Grant("header:tina", auth.RoleTeacher)
+	defer roles.Revoke("header:tina", auth.RoleTeacher)
+
+	var c class
+	helperUserRequest(t, srv, "tina", "POST", "/api/class/", map[string]string{"name": "Logic 102"}, &c)
+	base := "/api/class/" + c.Code
+	for _, student := range []string{"ann", "bob"} {
+		helperUserRequest(t, srv, student, "POST", base+"/join", nil, nil)
+	}
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	order := []string{defaultPuzzleID, "2-star", "3-star"}
+	for i, due := range []time.Time{past, future, now} {
+		body := map[string]time.Time{"due": due}
+		if status := helperUserRequest(t, srv, "tina", "POST", base+"/homework/"+order[i], body, &c); status != http.StatusOK {
+			t.Fatalf("Assigning %q gave status %d", order[i], status)
+		}
+	}
+	// changing a due date keeps the homework in place
+	body := map[string]time.Time{"due": past}
+	if status := helperUserRequest(t, srv, "tina", "POST", base+"/homework/3-star", body, &c); status != http.StatusOK ||
+		len(c.Homework) != 3 || !c.Homework[2].Due.Equal(past) {
+		t.Fatalf("Changing a due date gave %d, %+v", status, c.Homework)
+	}
+	if status := helperUserRequest(t, srv, "tina", "POST", base+"/homework/2-star", "tomorrow", nil); status != http.StatusBadRequest {
+		t.Errorf("Invalid due date gave status %d", status)
+	}
+
+	// ann did the first two on time and the third late; bob did nothing
+	for id, first := range map[string]time.Time{defaultPuzzleID: past.Add(-time.Minute), "2-star": now, "3-star": now} {
+		store.Put(resultKind, resultKey("header:ann", id), puzzleResult{First: first, Completions: 1})
+	}
+	var dash classProgress
+	helperUserRequest(t, srv, "tina", "GET", base+"/progress", nil, &dash)
+	if len(dash.Students) != 2 {
+		t.Fatalf("Dashboard is %+v", dash)
+	}
+	for i, want := range [][]string{{onTimeTiming, onTimeTiming, lateTiming}, {overdueTiming, "", overdueTiming}} {
+		for j, hp := range dash.Students[i].Homework {
+			if hp.PuzzleID != order[j] || hp.Timing != want[j] {
+				t.Errorf("Student %d homework %d is %+v, not %q", i, j, hp, want[j])
+			}
+		}
+	}
+	var summaries []homeworkSummary
+	helperUserRequest(t, srv, "tina", "GET", base+"/summary", nil, &summaries)
+	want := []homeworkSummary{
+		{PuzzleID: defaultPuzzleID, Students: 2, Completed: 1, OnTime: 1, Overdue: 1},
+		{PuzzleID: "2-star", Students: 2, Completed: 1, OnTime: 1},
+		{PuzzleID: "3-star", Students: 2, Completed: 1, Late: 1, Overdue: 1},
+	}
+	for i := range summaries {
+		summaries[i].Due = nil
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("Summaries are %+v", summaries)
+	}
+
+	// the gradebook has the same information
+	r, e := http.Get(srv.URL + base + "/gradebook")
+	if e != nil {
+		t.Fatalf("Gradebook request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Anonymous gradebook gave status %d", r.StatusCode)
+	}
+	req, _ := http.NewRequest("GET", srv.URL+base+"/gradebook", nil)
+	req.Header.Set("X-Test-User", "tina")
+	if r, e = http.DefaultClient.Do(req); e != nil {
+		t.Fatalf("Gradebook request error: %v", e)
+	}
+	defer r.Body.Close()
+	rows, e := csv.NewReader(r.Body).ReadAll()
+	if e != nil || r.Header.Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("Gradebook is %v (%q), %v", rows, r.Header.Get("Content-Type"), e)
+	}
+	if len(rows) != 3 || len(rows[0]) != 11 || rows[0][2] != defaultPuzzleID ||
+		rows[1][1] != "header:ann" || rows[1][9] != lateTiming || rows[2][2] != notStartedStatus || rows[2][4] != "" {
+		t.Errorf("Gradebook is %v", rows)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Puzzle collections
+
+Existing collections of puzzles, in the common text formats (see
+puzzle.ParseText), can be added to the catalog in bulk.  Setters
+can post them to /api/catalog/, and the server loads any
+collection files in the directory named by SUSEN_CATALOG at
+startup: .sdk files are SDK grids, .csv files are grids from
+spreadsheets, and .sdm and .txt files have one puzzle per line.
+
+A collection's puzzles are named by a prefix (for files, the
+file's name without its extension): a collection of one puzzle
+gets the prefix as its ID, and otherwise the puzzles are
+numbered from 1, as in "<prefix>-1".  Like puzzles added one at
+a time, each must be proper, have a new ID, and not already be
+in the catalog (even rotated, reflected, or with its digits
+relabeled); the ones that fail any of these are reported
+rather than added.  The puzzles have the prefix as their source,
+and the posting setter as their author (see puzzles.go).  A
+posted collection can be at most maxCollectionBytes, and is
+refused with a 413 if it's bigger.
+
+*/
+
+// maxCollectionPuzzles is how many puzzles can be added from one
+// collection.
+const maxCollectionPuzzles = 1000
+
+// maxCollectionBytes is the biggest a posted collection can be.
+const maxCollectionBytes = 4 << 20
+
+// A collectionImport reports which puzzles of a collection were
+// added to the catalog, and why the others weren't.
+type collectionImport struct {
+	Added    []string `json:"added"`
+	Rejected []string `json:"rejected"`
+}
+
+// collectionFormats are the text formats of collection files, by
+// extension.
+var collectionFormats = map[string]puzzle.TextFormat{
+	".csv": puzzle.GridFormat,
+	".sdk": puzzle.SDKFormat,
+	".sdm": puzzle.SDMFormat,
+	".txt": puzzle.SDMFormat,
+}
+
+// addCollection adds the puzzles of a collection to the catalog,
+// under IDs made from the prefix, with the given metadata.
+func addCollection(prefix string, puzzles [][]int, meta puzzleMeta) collectionImport {
+	result := collectionImport{Added: []string{}, Rejected: []string{}}
+	for i, vals := range puzzles {
+		id := prefix
+		if len(puzzles) > 1 {
+			id += "-" + strconv.Itoa(i+1)
+		}
+		p, e := puzzle.New(vals)
+		if e == nil {
+			e = p.IsProper()
+		}
+		if e != nil {
+			result.Rejected = append(result.Rejected, id+": "+e.Error())
+			continue
+		}
+		meta.ID = id
+		if other := addToCatalog(meta, vals); other == id {
+			result.Rejected = append(result.Rejected, id+": there is already a puzzle "+id)
+			continue
+		} else if other != "" {
+			result.Rejected = append(result.Rejected, id+": it's the same as puzzle "+other)
+			continue
+		}
+		result.Added = append(result.Added, id)
+	}
+	return result
+}
+
+// importCollection adds the collection in the request body to
+// the catalog.  The query gives the collection's format and
+// prefix.  Importing a collection is metered as one import.
+func importCollection(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, ok := puzzle.LookupTextFormat(q.Get("format"))
+	if !ok {
+		sendError(w, http.StatusBadRequest, requestError("Unknown collection format: "+q.Get("format")))
+		return
+	}
+	prefix := strings.TrimSpace(q.Get("prefix"))
+	if prefix == "" || strings.Contains(prefix, "/") {
+		sendError(w, http.StatusBadRequest, requestError("A collection prefix is required"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, nil), quotaImport) {
+		return
+	}
+	text, e := puzzle.ReadBody(r.Body, maxCollectionBytes)
+	if e != nil {
+		sendError(w, puzzle.DecodingStatus(e), e.(puzzle.Error))
+		return
+	}
+	puzzles, e := puzzle.ParseText(string(text), format)
+	if e != nil {
+		sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+		return
+	}
+	if len(puzzles) > maxCollectionPuzzles {
+		sendError(w, http.StatusRequestEntityTooLarge,
+			requestError("Collections can have at most "+strconv.Itoa(maxCollectionPuzzles)+" puzzles"))
+		return
+	}
+	meta := puzzleMeta{Author: auth.FromRequest(r).Key(), Source: prefix, Tags: q["tag"]}
+	result := addCollection(prefix, puzzles, meta)
+	log.Printf("User %v added %d puzzles from collection %q (%d rejected).",
+		auth.FromRequest(r).Key(), len(result.Added), prefix, len(result.Rejected))
+	sendJSON(w, http.StatusOK, result)
+}
+
+// loadCollections adds the collection files in the directory
+// named in the environment to the catalog.
+func loadCollections() {
+	dir := os.Getenv("SUSEN_CATALOG")
+	if dir == "" {
+		return
+	}
+	files, e := ioutil.ReadDir(dir)
+	if e != nil {
+		log.Printf("Can't read collections in %q: %v", dir, e)
+		return
+	}
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		format, ok := collectionFormats[ext]
+		if f.IsDir() || !ok {
+			continue
+		}
+		text, e := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if e != nil {
+			log.Printf("Can't read collection %q: %v", f.Name(), e)
+			continue
+		}
+		puzzles, e := puzzle.ParseText(string(text), format)
+		if e != nil {
+			log.Printf("Can't load collection %q: %v", f.Name(), e)
+			continue
+		}
+		prefix := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		result := addCollection(prefix, puzzles, puzzleMeta{Source: prefix})
+		for _, reason := range result.Rejected {
+			log.Printf("Collection %q: rejected %s", f.Name(), reason)
+		}
+		log.Printf("Loaded %d puzzles from collection %q.", len(result.Added), f.Name())
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// helperNewPuzzle returns a catalog puzzle with its first two
+// rows swapped, which is a new puzzle (not one that's the same up
+// to symmetry) that's proper exactly when the original is.
+func helperNewPuzzle(id string) []int {
+	vals, _ := lookupPuzzle(id)
+	vals = append([]int(nil), vals...)
+	n := 1
+	for n*n < len(vals)-1 {
+		n++
+	}
+	for c := 1; c <= n; c++ {
+		vals[c], vals[n+c] = vals[n+c], vals[c]
+	}
+	return vals
+}
+
+// helperFlippedPuzzle returns a catalog puzzle turned upside down
+// with its digits relabeled, which is the same puzzle up to
+// symmetry and relabeling.
+func helperFlippedPuzzle(id string) []int {
+	vals, _ := lookupPuzzle(id)
+	flipped := []int{vals[0]}
+	for i := len(vals) - 1; i > 0; i-- {
+		if v := vals[i]; v != 0 {
+			flipped = append(flipped, 10-v)
+		} else {
+			flipped = append(flipped, 0)
+		}
+	}
+	return flipped
+}
+
+// helperCollectionText returns the text of new puzzles made from
+// catalog puzzles (see helperNewPuzzle) in a text format.
+func helperCollectionText(t *testing.T, format puzzle.TextFormat, ids ...string) string {
+	var puzzles [][]int
+	for _, id := range ids {
+		puzzles = append(puzzles, helperNewPuzzle(id))
+	}
+	text, e := puzzle.WriteText(puzzles, format)
+	if e != nil {
+		t.Fatalf("Can't write %v as %s: %v", ids, format, e)
+	}
+	return text
+}
+
+// helperRemovePuzzles removes puzzles from the catalog.
+func helperRemovePuzzles(ids ...string) {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	for _, id := range ids {
+		delete(puzzleValues, id)
+		delete(catalogMeta, id)
+	}
+	catalogForms = nil
+}
+
+func TestImportCollection(t *testing.T) {
+	srv := helperUserServer(newSession("test-import-collection"))
+	defer srv.Close()
+	roles.Grant("header:sue", auth.RoleSetter)
+	defer roles.Revoke("header:sue", auth.RoleSetter)
+	defer helperRemovePuzzles("mine-1", "mine-2", "mine-3")
+
+	post := func(user, query, text string) (int, collectionImport) {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/catalog/?"+query, strings.NewReader(text))
+		req.Header.Set("X-Test-User", user)
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("Import request error: %v", e)
+		}
+		defer r.Body.Close()
+		var result collectionImport
+		if r.StatusCode == http.StatusOK {
+			if e := json.NewDecoder(r.Body).Decode(&result); e != nil {
+				t.Fatalf("Import decode error: %v", e)
+			}
+		}
+		return r.StatusCode, result
+	}
+
+	// an improper puzzle is rejected, the others are added
+	text := helperCollectionText(t, puzzle.SDMFormat, "2-star", "3-star") + "1" + strings.Repeat("0", 80) + "\n"
+	if status, _ := post("sam", "format=sdm&prefix=mine", text); status != http.StatusForbidden {
+		t.Errorf("Import by a non-setter gave status %d", status)
+	}
+	status, result := post("sue", "format=sdm&prefix=mine", text)
+	if status != http.StatusOK || !reflect.DeepEqual(result.Added, []string{"mine-1", "mine-2"}) ||
+		len(result.Rejected) != 1 || !strings.HasPrefix(result.Rejected[0], "mine-3: ") {
+		t.Fatalf("Import gave %d, %+v", status, result)
+	}
+	if vals, ok := lookupPuzzle("mine-2"); !ok || !reflect.DeepEqual(vals, helperNewPuzzle("3-star")) {
+		t.Errorf("Imported puzzle mine-2 is %v", vals)
+	}
+
+	// puzzles already in the catalog, even transformed, are rejected
+	dup, _ := puzzle.WriteText([][]int{helperNewPuzzle("3-star"), helperFlippedPuzzle("2-star")}, puzzle.SDMFormat)
+	if status, result := post("sue", "format=sdm&prefix=dup", dup); status != http.StatusOK || len(result.Added) != 0 ||
+		!reflect.DeepEqual(result.Rejected, []string{"dup-1: it's the same as puzzle mine-2",
+			"dup-2: it's the same as puzzle 2-star"}) {
+		t.Errorf("Import of duplicates gave %d, %+v", status, result)
+	}
+
+	// one puzzle is named by the prefix, which must be new
+	sdk := helperCollectionText(t, puzzle.SDKFormat, "2-star")
+	if status, result := post("sue", "format=sdk&prefix=4-star", sdk); status != http.StatusOK || len(result.Added) != 0 ||
+		!reflect.DeepEqual(result.Rejected, []string{"4-star: there is already a puzzle 4-star"}) {
+		t.Errorf("Import over an existing puzzle gave %d, %+v", status, result)
+	}
+	for _, query := range []string{"format=csv&prefix=x", "format=sdk", "format=sdk&prefix=a/b"} {
+		if status, _ := post("sue", query, sdk); status != http.StatusBadRequest {
+			t.Errorf("Import with %q gave status %d", query, status)
+		}
+	}
+	if status, _ := post("sue", "format=line&prefix=bad", "12345"); status != http.StatusBadRequest {
+		t.Errorf("Import of malformed text gave status %d", status)
+	}
+	if status, _ := post("sue", "format=line&prefix=big", strings.Repeat(" ", maxCollectionBytes+1)); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Import of a big collection gave status %d", status)
+	}
+}
+
+func TestLoadCollections(t *testing.T) {
+	dir := t.TempDir()
+	defer helperRemovePuzzles("one", "two-1", "two-2")
+	files := map[string]string{
+		"one.SDK":   helperCollectionText(t, puzzle.SDKFormat, "2-star"),
+		"two.txt":   helperCollectionText(t, puzzle.SDMFormat, "5-star", "4-star"),
+		"bad.sdm":   "not a puzzle\n",
+		"notes.md":  "# Notes\n",
+		"other.sdk": "",
+	}
+	for name, text := range files {
+		if e := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); e != nil {
+			t.Fatalf("Can't write collection file: %v", e)
+		}
+	}
+	savedEnv := os.Getenv("SUSEN_CATALOG")
+	os.Setenv("SUSEN_CATALOG", dir)
+	defer os.Setenv("SUSEN_CATALOG", savedEnv)
+	loadCollections()
+	for _, id := range []string{"one", "two-1", "two-2"} {
+		if _, ok := lookupPuzzle(id); !ok {
+			t.Errorf("Collection puzzle %q wasn't loaded", id)
+		}
+	}
+	if _, ok := lookupPuzzle("bad"); ok {
+		t.Errorf("Malformed collection was loaded")
+	}
+}
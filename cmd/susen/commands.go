@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+/*
+
+Commands
+
+Keyboard-first and assistive clients show the player a palette
+of everything they can do, so they need to know which actions
+the server has and which of them the board allows right now.
+GET /api/commands lists the session's actions, each with:
+
+- its name, which doesn't change, and a title to show
+
+- the method and path of the request that does it, and the body
+the request needs, if any ("choice" for a JSON puzzle.Choice,
+"backup" for a session backup; see backup.go)
+
+- whether it's enabled, and if it isn't, why not, in the words
+the server would use to refuse the request
+
+The list describes the board the request is for (including a
+slot's board, with the slot parameter; see slots.go), and it
+only says what the board allows: an enabled action can still be
+refused for a reason that can't be known in advance, such as an
+exhausted quota or an invalid choice.
+
+*/
+
+// A sessionCommand is an action the session can take.
+type sessionCommand struct {
+	Name    string `json:"name"`
+	Title   string `json:"title"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Body    string `json:"body,omitempty"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"` // why it isn't enabled
+}
+
+// A refusalRecorder keeps the message of an error response that
+// a board would send, without sending it.
+type refusalRecorder struct {
+	header  http.Header
+	message string
+}
+
+func (w *refusalRecorder) Header() http.Header { return w.header }
+func (w *refusalRecorder) WriteHeader(int)     {}
+func (w *refusalRecorder) Write(b []byte) (int, error) {
+	var err puzzle.Error
+	if json.Unmarshal(b, &err) != nil {
+		return len(b), nil
+	}
+	w.message = err.Message
+	if len(err.Values) == 1 {
+		if message, ok := err.Values[0].(string); ok {
+			w.message = message // without the scope
+		}
+	}
+	return len(b), nil
+}
+
+// refusal returns why the session would refuse a request to
+// change its board, or "" if it wouldn't.  It must be called
+// with the board locked.
+func (session *susenSession) refusal(method, path string) string {
+	r := &http.Request{Method: method, URL: &url.URL{Path: path}, Header: make(http.Header)}
+	if inMaintenance(r) {
+		return "The server is in maintenance; puzzles can't be changed right now"
+	}
+	w := &refusalRecorder{header: make(http.Header)}
+	if session.refuseBlitzChange(w, r) || session.refuseTurnChange(w, r) || session.refuseRaceChange(w, r) {
+		return w.message
+	}
+	return ""
+}
+
+// commands returns the session's actions.  It must be called
+// with the board locked.
+func (session *susenSession) commands() []sessionCommand {
+	solved := true
+	for _, s := range session.steps[len(session.steps)-1].Squares() {
+		if s.Aval == 0 {
+			solved = false
+			break
+		}
+	}
+	cooldown, limit := hintPolicy()
+	assisted := ""
+	if session.contest || session.unassisted {
+		assisted = "Contest and unassisted boards get no help"
+	}
+	hints := ""
+	if limit == 0 {
+		hints = "Hints are turned off"
+	}
+	nextHint := ""
+	switch {
+	case session.stats.Hints >= limit:
+		nextHint = "No hints are left for this puzzle"
+	case time.Until(session.lastHint.Add(cooldown)) > 0:
+		nextHint = "Hints are cooling down"
+	}
+	unsolved := ""
+	if solved {
+		unsolved = "The puzzle is solved"
+	}
+	unmoved := ""
+	if len(session.steps) == 1 {
+		unmoved = "There are no moves to undo"
+	}
+	unguessed := ""
+	if len(session.guesses) == 0 {
+		unguessed = "There is no guess to abandon"
+	}
+	exportable := ""
+	if session.blitz != nil && !session.blitz.over {
+		exportable = "Boards in a blitz attempt can't be exported"
+	}
+	shared := ""
+	if session.room != nil {
+		shared = "Boards shared in a room can't be replaced"
+	}
+	uncompleted := ""
+	if session.stats.Completed == nil {
+		uncompleted = "Only completed puzzles can have their replays published"
+	}
+	status := ""
+	if session.preferences().HideStatus {
+		status = "The session's preferences hide the board's status"
+	}
+	rating := ""
+	if !featureEnabled("rating") {
+		rating = "The rating feature is turned off"
+	}
+
+	// each command is disabled for the first of its reasons
+	commands := []struct {
+		sessionCommand
+		reasons []string
+	}{
+		{sessionCommand{Name: "assign", Title: "Fill in a square", Method: "POST", Path: "/api/assign/", Body: "choice"},
+			[]string{session.refusal("POST", "/api/assign/"), unsolved}},
+		{sessionCommand{Name: "guess", Title: "Guess a square", Method: "POST", Path: "/api/guess/", Body: "choice"},
+			[]string{session.refusal("POST", "/api/guess/"), unsolved}},
+		{sessionCommand{Name: "mark", Title: "Pencil in a candidate", Method: "POST", Path: "/api/mark/", Body: "choice"},
+			[]string{session.refusal("POST", "/api/mark/"), unsolved}},
+		{sessionCommand{Name: "unmark", Title: "Erase a pencil mark", Method: "POST", Path: "/api/unmark/", Body: "choice"},
+			[]string{session.refusal("POST", "/api/unmark/"), unsolved}},
+		{sessionCommand{Name: "undo", Title: "Undo the last move", Method: "GET", Path: "/api/back/"},
+			[]string{session.refusal("GET", "/api/back/"), unmoved}},
+		{sessionCommand{Name: "undo-to-certain", Title: "Undo to the last certain move", Method: "GET", Path: "/api/back/certain/"},
+			[]string{session.refusal("GET", "/api/back/certain/"), assisted, unmoved}},
+		{sessionCommand{Name: "abandon-guess", Title: "Abandon the latest guess", Method: "POST", Path: "/api/abandon/"},
+			[]string{session.refusal("POST", "/api/abandon/"), unguessed}},
+		{sessionCommand{Name: "reset", Title: "Start the puzzle over", Method: "GET", Path: "/api/reset/"},
+			[]string{session.refusal("GET", "/api/reset/"), unmoved}},
+		{sessionCommand{Name: "hint", Title: "Get a hint", Method: "GET", Path: "/api/hint/"},
+			[]string{hints, unsolved, nextHint}},
+		{sessionCommand{Name: "autofill", Title: "Fill in the singles", Method: "POST", Path: "/api/autofill"},
+			[]string{session.refusal("POST", "/api/autofill"), assisted, unsolved}},
+		{sessionCommand{Name: "explain", Title: "Explain the solution", Method: "GET", Path: "/api/explain/"},
+			[]string{hints, assisted}},
+		{sessionCommand{Name: "solutions", Title: "Show the solutions", Method: "GET", Path: "/api/solutions/"},
+			[]string{hints, assisted}},
+		{sessionCommand{Name: "conflicts", Title: "Show conflicting squares", Method: "GET", Path: "/api/conflicts/"},
+			nil},
+		{sessionCommand{Name: "units", Title: "Summarize rows, columns, and tiles", Method: "GET", Path: "/api/units"},
+			nil},
+		{sessionCommand{Name: "status", Title: "Check whether the entries are right", Method: "GET", Path: "/api/status"},
+			[]string{assisted, status}},
+		{sessionCommand{Name: "rating", Title: "Rate the puzzle", Method: "GET", Path: "/api/rating/"},
+			[]string{rating}},
+		{sessionCommand{Name: "replay", Title: "Publish a replay", Method: "POST", Path: "/api/replay"},
+			[]string{uncompleted}},
+		{sessionCommand{Name: "export", Title: "Export the board", Method: "GET", Path: "/api/export"},
+			[]string{exportable}},
+		{sessionCommand{Name: "import", Title: "Import a board", Method: "POST", Path: "/api/import", Body: "backup"},
+			[]string{session.refusal("POST", "/api/import"), shared}},
+	}
+	result := make([]sessionCommand, 0, len(commands))
+	for _, c := range commands {
+		c.Enabled = true
+		for _, reason := range c.reasons {
+			if reason != "" {
+				c.Enabled, c.Reason = false, reason
+				break
+			}
+		}
+		result = append(result, c.sessionCommand)
+	}
+	return result
+}
+
+// commandsHandler handles GET /api/commands.  It's called with
+// the board locked.
+func (session *susenSession) commandsHandler(w http.ResponseWriter, r *http.Request) {
+	session.checkBlitz()
+	sendJSON(w, http.StatusOK, session.commands())
+}
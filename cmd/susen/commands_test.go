@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCommands(t *testing.T) {
+	session := newSession("test-commands")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	commands := func() map[string]sessionCommand {
+		var list []sessionCommand
+		if status := helperUserRequest(t, srv, "", "GET", "/api/commands", nil, &list); status != http.StatusOK {
+			t.Fatalf("Commands gave status %d", status)
+		}
+		byName := make(map[string]sessionCommand)
+		for _, c := range list {
+			byName[c.Name] = c
+		}
+		return byName
+	}
+
+	cs := commands()
+	if c := cs["assign"]; !c.Enabled || c.Method != "POST" || c.Path != "/api/assign/" || c.Body != "choice" {
+		t.Errorf("Assign command is %+v", c)
+	}
+	if c := cs["undo"]; c.Enabled || c.Reason != "There are no moves to undo" {
+		t.Errorf("Undo command on a new board is %+v", c)
+	}
+	if c := cs["abandon-guess"]; c.Enabled {
+		t.Errorf("Abandon command without a guess is %+v", c)
+	}
+	if c := cs["hint"]; !c.Enabled {
+		t.Errorf("Hint command on a new board is %+v", c)
+	}
+
+	if status := helperRoomAssign(t, srv, puzzle.Choice{Index: 2, Value: 1}); status != http.StatusOK {
+		t.Fatalf("Assign gave status %d", status)
+	}
+	cs = commands()
+	if c := cs["undo"]; !c.Enabled || c.Reason != "" {
+		t.Errorf("Undo command after a move is %+v", c)
+	}
+
+	session.mutex.Lock()
+	session.contest, session.lastHint = true, time.Now()
+	session.mutex.Unlock()
+	cs = commands()
+	if c := cs["explain"]; c.Enabled || c.Reason != "Contest and unassisted boards get no help" {
+		t.Errorf("Explain command on a contest board is %+v", c)
+	}
+	if c := cs["hint"]; c.Enabled || c.Reason != "Hints are cooling down" {
+		t.Errorf("Hint command after a hint is %+v", c)
+	}
+
+	// the board's refusals are the server's
+	session.mutex.Lock()
+	session.blitz = &blitzAttempt{board: session.susenBoard, over: true}
+	session.mutex.Unlock()
+	cs = commands()
+	if c := cs["assign"]; c.Enabled || c.Reason != "The blitz attempt is over" {
+		t.Errorf("Assign command after a blitz is %+v", c)
+	}
+	if c := cs["reset"]; !c.Enabled {
+		t.Errorf("Reset command after a blitz is %+v", c)
+	}
+}
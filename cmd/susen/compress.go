@@ -0,0 +1,219 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+
+Response compression
+
+Squares for the larger geometries, and the client's scripts and
+style sheets, are mostly repetition, so responses are compressed
+for clients that accept it: with gzip if the Accept-Encoding
+header allows it, and otherwise with deflate.  Only responses
+worth compressing are compressed: text, JSON, JavaScript, and
+SVG bodies of at least compressMinimum bytes that aren't already
+encoded.  Every response that could have been compressed says
+Vary: Accept-Encoding, so caches keep the forms apart, and the
+ETags of compressed responses are made weak, since they're
+no longer the bytes the tags were made from; that doesn't stop
+the assets' conditional requests from working (see assets.go).
+
+The headers a handler sets, cookies included, are sent as they
+were set: the response is only started (and compression decided
+on) when the handler first writes its body, or finishes.  These
+aren't compressed:
+
+- WebSocket connections (see websocket.go), which are left alone
+  so they can be hijacked
+
+- event streams (see progress.go), so each event reaches the
+  client as soon as it's flushed
+
+- responses to Range requests, whose ranges are of the
+  uncompressed body
+
+*/
+
+// compressMinimum is the size of the smallest body worth
+// compressing.
+const compressMinimum = 512
+
+// compressibleTypes are the media types worth compressing,
+// besides text (other than event streams) and JSON.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// compressible tells whether a content type is worth
+// compressing.
+func compressible(contentType string) bool {
+	mediaType, _, e := mime.ParseMediaType(contentType)
+	if e != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		compressibleTypes[mediaType]
+}
+
+// acceptedEncoding returns the compression the Accept-Encoding
+// header allows, gzip if it allows both, or "" if it allows
+// neither.
+func acceptedEncoding(header string) string {
+	allowed := make(map[string]bool)
+	star := false
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		switch coding {
+		case "*":
+			star = q > 0
+		case "gzip", "x-gzip":
+			allowed["gzip"] = q > 0
+		case "deflate":
+			allowed["deflate"] = q > 0
+		}
+	}
+	for _, coding := range []string{"gzip", "deflate"} {
+		if ok, named := allowed[coding]; ok || (!named && star) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// The compressors are reused.
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// A compressor is a gzip or flate writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressResponses compresses the responses of a handler, for
+// clients that accept it.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressingWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// A compressingWriter compresses a response, if it's worth it.
+// It holds on to the status until the first write (or flush, or
+// the end of the response), when it can tell.
+type compressingWriter struct {
+	http.ResponseWriter
+	encoding   string
+	status     int  // the status to send, once it's decided
+	decided    bool // whether the response has started
+	compressor compressor
+}
+
+func (w *compressingWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	} else if status < 200 {
+		w.ResponseWriter.WriteHeader(status) // informational
+	}
+}
+
+func (w *compressingWriter) Write(b []byte) (int, error) {
+	w.decide(len(b))
+	if w.compressor != nil {
+		return w.compressor.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressingWriter) Flush() {
+	w.decide(-1)
+	if w.compressor != nil {
+		w.compressor.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide starts the response, compressing it if it's worth it,
+// given the size of the first write (-1 if it's not known).
+func (w *compressingWriter) decide(size int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	hs := w.Header()
+	if length, e := strconv.Atoi(hs.Get("Content-Length")); e == nil {
+		size = length
+	}
+	if hasBody := w.status != http.StatusNoContent && w.status != http.StatusPartialContent &&
+		w.status != http.StatusNotModified; hasBody {
+		if hs.Get("Content-Encoding") == "" && compressible(hs.Get("Content-Type")) &&
+			(size < 0 || size >= compressMinimum) {
+			hs.Set("Content-Encoding", w.encoding)
+			hs.Del("Content-Length")
+			if etag := hs.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				hs.Set("ETag", "W/"+etag)
+			}
+			if w.encoding == "gzip" {
+				w.compressor = gzipWriters.Get().(*gzip.Writer)
+			} else {
+				w.compressor = flateWriters.Get().(*flate.Writer)
+			}
+			w.compressor.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close finishes the response.
+func (w *compressingWriter) close() {
+	w.decide(0)
+	if w.compressor == nil {
+		return
+	}
+	w.compressor.Close()
+	w.compressor.Reset(nil)
+	if w.encoding == "gzip" {
+		gzipWriters.Put(w.compressor)
+	} else {
+		flateWriters.Put(w.compressor)
+	}
+	w.compressor = nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"deflate, gzip;q=0.5":     "gzip",
+		"gzip;q=0, deflate":       "deflate",
+		"br":                      "",
+		"*":                       "gzip",
+		"*, gzip;q=0":             "deflate",
+		"identity, x-gzip":        "gzip",
+		"gzip;q=0, deflate;q=0.0": "",
+	}
+	for header, expected := range cases {
+		if got := acceptedEncoding(header); got != expected {
+			t.Errorf("Accept-Encoding %q gave %q", header, got)
+		}
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	big := `{"squares":"` + strings.Repeat("1 2 3 4 5 6 7 8 9 ", 100) + `"}`
+	h := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			http.SetCookie(w, &http.Cookie{Name: "susen", Value: "cookie"})
+			w.Header().Set("ETag", `"big"`)
+			sendJSON(w, http.StatusOK, map[string]string{"squares": strings.Repeat("1 2 3 4 5 6 7 8 9 ", 100)})
+		case "/small":
+			sendJSON(w, http.StatusOK, "small")
+		case "/events":
+			s, _ := startEventStream(w)
+			s.send("progress", big)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(big))
+		}
+	}))
+	get := func(path, accept string, headers ...string) *httptest.ResponseRecorder {
+		w, r := httptest.NewRecorder(), httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+	body := func(w *httptest.ResponseRecorder) string {
+		var rd io.Reader = w.Body
+		switch w.Header().Get("Content-Encoding") {
+		case "gzip":
+			gz, e := gzip.NewReader(w.Body)
+			if e != nil {
+				t.Fatalf("Invalid gzip body: %v", e)
+			}
+			rd = gz
+		case "deflate":
+			rd = flate.NewReader(w.Body)
+		}
+		bs, e := ioutil.ReadAll(rd)
+		if e != nil {
+			t.Fatalf("Can't read body: %v", e)
+		}
+		return string(bs)
+	}
+
+	w := get("/big", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || body(w) != big || w.Body.Len() != 0 {
+		t.Errorf("Big response was encoded as %q", w.Header().Get("Content-Encoding"))
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `W/"big"` ||
+		!strings.HasPrefix(w.Header().Get("Set-Cookie"), "susen=cookie") {
+		t.Errorf("Big response has headers %v", w.Header())
+	}
+	if w := get("/big", "deflate"); w.Header().Get("Content-Encoding") != "deflate" || body(w) != big {
+		t.Errorf("Deflated response was encoded as %q", w.Header().Get("Content-Encoding"))
+	}
+	if w := get("/big", ""); w.Header().Get("Content-Encoding") != "" || body(w) != big ||
+		w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `"big"` {
+		t.Errorf("Response without Accept-Encoding has headers %v", w.Header())
+	}
+	if w := get("/big", "gzip", "Range", "bytes=0-10"); w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Range response was encoded")
+	}
+	for _, path := range []string{"/small", "/events", "/image"} {
+		if w := get(path, "gzip"); w.Header().Get("Content-Encoding") != "" || w.Code != http.StatusOK {
+			t.Errorf("%s response was encoded as %q, status %d", path, w.Header().Get("Content-Encoding"), w.Code)
+		}
+	}
+	if w := get("/events", "gzip"); !w.Flushed || !strings.Contains(w.Body.String(), "event: progress") {
+		t.Errorf("Event stream wasn't flushed: %q", w.Body.String())
+	}
+}
+
+func TestCompressAssets(t *testing.T) {
+	srv := httptest.NewServer(compressResponses(http.HandlerFunc(staticHandler)))
+	defer srv.Close()
+	get := func(etag string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+"/static/js/puzzle.js", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		r, e := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+		if e != nil {
+			t.Fatalf("Asset request failed: %v", e)
+		}
+		r.Body.Close()
+		return r
+	}
+	r := get("")
+	etag := r.Header.Get("ETag")
+	if r.StatusCode != http.StatusOK || r.Header.Get("Content-Encoding") != "gzip" || !strings.HasPrefix(etag, "W/") {
+		t.Fatalf("Asset response gave status %d, headers %v", r.StatusCode, r.Header)
+	}
+	if r := get(etag); r.StatusCode != http.StatusNotModified {
+		t.Errorf("Conditional asset request gave status %d", r.StatusCode)
+	}
+}
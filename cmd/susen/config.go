@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+
+Live configuration
+
+Part of the configuration can be changed while the server runs,
+without dropping the in-memory sessions: the log level, the
+quota and rate limits, the hint policy, the feature flags,
+the scoring rules, maintenance mode, cross-checking, and board
+pre-analysis.  Admins change it through /api/admin/config, and deployments can keep it
+in a JSON file named by SUSEN_CONFIG, which is read at startup
+and re-read on SIGHUP.  Both take the same JSON, and only the
+fields that are present are changed, for example:
+
+	{"logLevel": "info", "quotas": {"analyze": 10},
+	 "rateLimits": {"api": {"session": 300, "ip": 1500}},
+	 "hintCooldown": 60, "hintLimit": 3,
+	 "features": {"rooms": false}, "scoring": {"hint": 25},
+	 "maintenance": true,
+	 "crossCheck": 0.05, "preAnalysis": true}
+
+The log levels are "debug" (the default), which logs the details
+of handling requests, and "info", which leaves them out.  Either
+way, each request gets a line in the request log (see
+requestlog.go).
+
+The hint policy is the number of seconds a board has to wait
+between hints (15 by default), and the number of hints it can
+have for each puzzle (10 by default, and 0 means none).
+
+Feature flags turn off optional features: "rooms", "rating",
+"hints", "accounts", "discussions", and "push".  They also turn
+on "scoring", which is off by default, and whose rules are the
+points in "scoring" (see scoring.go).  In maintenance mode, the server still
+shows puzzles but refuses changes to them, so it can be brought
+down without anyone losing moves.
+
+Cross-checking is the fraction (from 0 to 1, and 0.01 by
+default) of ratings and hints whose techniques are checked
+against the brute-force solver (see puzzle/crosscheck.go).
+Discrepancies are logged, since they mean a technique is broken
+and ratings and hints can't be trusted.
+
+Pre-analysis (off by default) attaches the interesting squares
+of a board to its squares when it's started (see analysis.go).
+
+*/
+
+// A liveConfig is the changeable part of the configuration.
+type liveConfig struct {
+	LogLevel     string                  `json:"logLevel"`
+	Quotas       map[quotaKind]int       `json:"quotas"`
+	HintCooldown int                     `json:"hintCooldown"` // seconds
+	HintLimit    int                     `json:"hintLimit"`
+	Features     map[string]bool         `json:"features"`
+	Scoring      map[scoreRule]int       `json:"scoring"`
+	Maintenance  bool                    `json:"maintenance"`
+	RateLimits   map[rateClass]rateLimit `json:"rateLimits"`
+	CrossCheck   float64                 `json:"crossCheck"`
+	PreAnalysis  bool                    `json:"preAnalysis"`
+}
+
+// A configUpdate is a change to the live configuration.  Absent
+// fields are left as they are.
+type configUpdate struct {
+	LogLevel     *string                 `json:"logLevel,omitempty"`
+	Quotas       map[quotaKind]int       `json:"quotas,omitempty"`
+	HintCooldown *int                    `json:"hintCooldown,omitempty"`
+	HintLimit    *int                    `json:"hintLimit,omitempty"`
+	Features     map[string]bool         `json:"features,omitempty"`
+	Scoring      map[scoreRule]int       `json:"scoring,omitempty"`
+	Maintenance  *bool                   `json:"maintenance,omitempty"`
+	RateLimits   map[rateClass]rateLimit `json:"rateLimits,omitempty"`
+	CrossCheck   *float64                `json:"crossCheck,omitempty"`
+	PreAnalysis  *bool                   `json:"preAnalysis,omitempty"`
+}
+
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+)
+
+var (
+	configMutex  sync.RWMutex // guards the live configuration except quotas and rate limits
+	logLevel     = logLevelDebug
+	hintCooldown = 15 * time.Second
+	hintLimit    = 10
+	features     = map[string]bool{"rooms": true, "rating": true, "hints": true, "accounts": true, "discussions": true, "push": true, "scoring": false}
+	maintenance  bool
+	crossCheck   = 0.01
+	preAnalysis  bool
+
+	// discrepancyReport is where cross-check discrepancies go
+	discrepancyReport = logDiscrepancy
+)
+
+// currentConfig returns a copy of the live configuration.
+func currentConfig() liveConfig {
+	configMutex.RLock()
+	c := liveConfig{
+		LogLevel:     logLevel,
+		HintCooldown: int(hintCooldown / time.Second),
+		HintLimit:    hintLimit,
+		Features:     make(map[string]bool),
+		Scoring:      make(map[scoreRule]int),
+		Maintenance:  maintenance,
+		CrossCheck:   crossCheck,
+		PreAnalysis:  preAnalysis,
+	}
+	for name, on := range features {
+		c.Features[name] = on
+	}
+	for rule, points := range scoreRules {
+		c.Scoring[rule] = points
+	}
+	configMutex.RUnlock()
+	quotaMutex.Lock()
+	c.Quotas = make(map[quotaKind]int)
+	for kind, limit := range quotaLimits {
+		c.Quotas[kind] = limit
+	}
+	quotaMutex.Unlock()
+	rateMutex.Lock()
+	c.RateLimits = make(map[rateClass]rateLimit)
+	for class, limit := range rateLimits {
+		c.RateLimits[class] = limit
+	}
+	rateMutex.Unlock()
+	return c
+}
+
+// applyConfig makes a change to the live configuration.  The
+// change is checked first, and isn't made at all if any part of
+// it is invalid.
+func applyConfig(u configUpdate) error {
+	if u.LogLevel != nil && *u.LogLevel != logLevelDebug && *u.LogLevel != logLevelInfo {
+		return fmt.Errorf("Unknown log level %q", *u.LogLevel)
+	}
+	for kind, limit := range u.Quotas {
+		if _, ok := quotaLimits[kind]; !ok || limit < 0 {
+			return fmt.Errorf("Invalid quota %s: %d", kind, limit)
+		}
+	}
+	if e := checkRateLimits(u.RateLimits); e != nil {
+		return e
+	}
+	if e := checkScoreRules(u.Scoring); e != nil {
+		return e
+	}
+	if u.HintCooldown != nil && *u.HintCooldown < 0 {
+		return fmt.Errorf("Invalid hint cooldown: %d", *u.HintCooldown)
+	}
+	if u.HintLimit != nil && *u.HintLimit < 0 {
+		return fmt.Errorf("Invalid hint limit: %d", *u.HintLimit)
+	}
+	if u.CrossCheck != nil && (*u.CrossCheck < 0 || *u.CrossCheck > 1) {
+		return fmt.Errorf("Invalid cross-check fraction: %v", *u.CrossCheck)
+	}
+	configMutex.Lock()
+	for name := range u.Features {
+		if _, ok := features[name]; !ok {
+			configMutex.Unlock()
+			return fmt.Errorf("Unknown feature %q", name)
+		}
+	}
+	if u.LogLevel != nil {
+		logLevel = *u.LogLevel
+	}
+	if u.HintCooldown != nil {
+		hintCooldown = time.Duration(*u.HintCooldown) * time.Second
+	}
+	if u.HintLimit != nil {
+		hintLimit = *u.HintLimit
+	}
+	for name, on := range u.Features {
+		features[name] = on
+	}
+	for rule, points := range u.Scoring {
+		scoreRules[rule] = points
+	}
+	if u.Maintenance != nil {
+		maintenance = *u.Maintenance
+	}
+	if u.CrossCheck != nil {
+		crossCheck = *u.CrossCheck
+		puzzle.SetCrossCheck(crossCheck, discrepancyReport)
+	}
+	if u.PreAnalysis != nil {
+		preAnalysis = *u.PreAnalysis
+	}
+	configMutex.Unlock()
+	quotaMutex.Lock()
+	for kind, limit := range u.Quotas {
+		quotaLimits[kind] = limit
+	}
+	quotaMutex.Unlock()
+	rateMutex.Lock()
+	for class, limit := range u.RateLimits {
+		rateLimits[class] = limit
+	}
+	rateMutex.Unlock()
+	return nil
+}
+
+// startCrossCheck turns on cross-checking at the default
+// fraction, before any configuration file is loaded.
+func startCrossCheck() {
+	configMutex.RLock()
+	puzzle.SetCrossCheck(crossCheck, discrepancyReport)
+	configMutex.RUnlock()
+}
+
+// logDiscrepancy logs a result of the rater that failed its
+// cross-check.
+func logDiscrepancy(d puzzle.Discrepancy) {
+	log.Printf("Cross-check failed: %s gave %+v in %s (solution %d)", d.Technique, d.Choice, d.Encoding, d.Solution)
+}
+
+// loadConfigFile applies the configuration file named in the
+// environment, if there is one.
+func loadConfigFile() {
+	name := os.Getenv("SUSEN_CONFIG")
+	if name == "" {
+		return
+	}
+	f, e := os.Open(name)
+	if e != nil {
+		log.Printf("Can't read config file: %v", e)
+		return
+	}
+	defer f.Close()
+	var u configUpdate
+	if e = json.NewDecoder(f).Decode(&u); e == nil {
+		e = applyConfig(u)
+	}
+	if e != nil {
+		log.Printf("Ignoring config file %s: %v", name, e)
+		return
+	}
+	log.Printf("Loaded config file %s: %+v", name, currentConfig())
+}
+
+// reloadOnHangup re-reads the configuration file whenever the
+// server gets a SIGHUP.
+func reloadOnHangup() {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			log.Printf("Received SIGHUP, reloading config.")
+			loadConfigFile()
+		}
+	}()
+}
+
+// debugf logs per-request detail, which is left out at the info
+// log level.
+func debugf(format string, args ...interface{}) {
+	configMutex.RLock()
+	level := logLevel
+	configMutex.RUnlock()
+	if level == logLevelDebug {
+		log.Printf(format, args...)
+	}
+}
+
+// featureEnabled tells whether an optional feature is on.
+func featureEnabled(name string) bool {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return features[name]
+}
+
+// featureOff sends the response for a request to a feature
+// that's turned off.
+func featureOff(w http.ResponseWriter, name string) {
+	sendError(w, http.StatusNotFound, requestError("The "+name+" feature is turned off"))
+}
+
+// inMaintenance tells whether a request has to be refused
+// because of maintenance mode: it would change a puzzle, and
+// it's not an admin request (which might be ending maintenance).
+func inMaintenance(r *http.Request) bool {
+	configMutex.RLock()
+	on := maintenance
+	configMutex.RUnlock()
+	if !on || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		return false
+	}
+	return (r.Method != "GET" && r.Method != "HEAD") ||
+		strings.Contains(r.URL.Path, "/reset/") || strings.Contains(r.URL.Path, "/back/")
+}
+
+// configHandler handles the live configuration endpoint, which
+// is only routed to for admins:
+//
+// - GET /api/admin/config/ gives the live configuration
+//
+// - POST /api/admin/config/ changes it, taking a JSON
+// configUpdate in the request body
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var u configUpdate
+		e := decodeRequest(w, r, &u)
+		if e == nil {
+			e = applyConfig(u)
+		}
+		if e != nil {
+			sendError(w, requestStatus(e), requestError(e.Error()))
+			return
+		}
+		log.Printf("Config changed: %+v", currentConfig())
+	}
+	sendJSON(w, http.StatusOK, currentConfig())
+}
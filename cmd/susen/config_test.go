@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// The server's tests cross-check every rating and hint, and fail
+// if any of them has a discrepancy.
+func TestMain(m *testing.M) {
+	var mutex sync.Mutex
+	var found []puzzle.Discrepancy
+	crossCheck = 1
+	discrepancyReport = func(d puzzle.Discrepancy) {
+		mutex.Lock()
+		found = append(found, d)
+		mutex.Unlock()
+	}
+	startCrossCheck()
+	code := m.Run()
+	for _, d := range found {
+		fmt.Fprintf(os.Stderr, "Cross-check discrepancy: %+v\n", d)
+		code = 1
+	}
+	os.Exit(code)
+}
+
+func TestApplyConfig(t *testing.T) {
+	saved := currentConfig()
+	defer func() {
+		maint := saved.Maintenance
+		applyConfig(configUpdate{&saved.LogLevel, saved.Quotas, &saved.HintCooldown, &saved.HintLimit,
+			saved.Features, saved.Scoring, &maint, saved.RateLimits, &saved.CrossCheck, &saved.PreAnalysis})
+	}()
+
+	info, on, half := logLevelInfo, true, 0.5
+	if e := applyConfig(configUpdate{LogLevel: &info, Quotas: map[quotaKind]int{quotaAnalyze: 5},
+		Features: map[string]bool{"rooms": false}, Maintenance: &on, CrossCheck: &half}); e != nil {
+		t.Fatalf("Valid config update failed: %v", e)
+	}
+	c := currentConfig()
+	if c.LogLevel != logLevelInfo || c.Quotas[quotaAnalyze] != 5 || c.Features["rooms"] || !c.Maintenance || c.CrossCheck != 0.5 {
+		t.Errorf("Config after update is %+v", c)
+	}
+	if c.Quotas[quotaImport] != saved.Quotas[quotaImport] || !c.Features["hints"] {
+		t.Errorf("Config update changed absent fields: %+v", c)
+	}
+
+	bad, negative, tooMuch := "loud", -1, 1.5
+	for i, u := range []configUpdate{
+		{HintCooldown: &negative},
+		{HintLimit: &negative},
+		{CrossCheck: &tooMuch},
+		{LogLevel: &bad},
+		{Quotas: map[quotaKind]int{"mining": 5}},
+		{Quotas: map[quotaKind]int{quotaAnalyze: -1}},
+		{Scoring: map[scoreRule]int{"style": 5}},
+		{Scoring: map[scoreRule]int{scoreHint: -1}},
+		{LogLevel: &info, Features: map[string]bool{"teleport": true}},
+	} {
+		if e := applyConfig(u); e == nil {
+			t.Errorf("test %d: invalid update %+v succeeded", i+1, u)
+		}
+	}
+	if c2 := currentConfig(); c2.LogLevel != c.LogLevel || c2.Quotas[quotaAnalyze] != 5 {
+		t.Errorf("Invalid updates changed config to %+v", c2)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	saved := currentConfig()
+	defer func() { applyConfig(configUpdate{Quotas: saved.Quotas}) }()
+
+	name := filepath.Join(t.TempDir(), "susen.json")
+	if e := ioutil.WriteFile(name, []byte(`{"quotas": {"import": 7}}`), 0644); e != nil {
+		t.Fatalf("Can't write config file: %v", e)
+	}
+	savedEnv := os.Getenv("SUSEN_CONFIG")
+	os.Setenv("SUSEN_CONFIG", name)
+	defer os.Setenv("SUSEN_CONFIG", savedEnv)
+	loadConfigFile()
+	if c := currentConfig(); c.Quotas[quotaImport] != 7 {
+		t.Errorf("Config file wasn't applied: %+v", c)
+	}
+}
+
+func TestMaintenanceAndFeatures(t *testing.T) {
+	session := newSession("test-maintenance")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+
+	// admins change the config through the API
+	update := map[string]interface{}{"maintenance": true, "features": map[string]bool{"rooms": false}}
+	var c liveConfig
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/config/", update, &c); status != http.StatusOK || !c.Maintenance {
+		t.Fatalf("Config change gave %d, %+v", status, c)
+	}
+	if status := helperUserRequest(t, srv, "sam", "POST", "/api/admin/config/", update, nil); status != http.StatusForbidden {
+		t.Errorf("Non-admin config change gave status %d", status)
+	}
+
+	// in maintenance, puzzles can be looked at but not changed
+	if status := helperUserRequest(t, srv, "", "GET", "/api/", nil, nil); status != http.StatusOK {
+		t.Errorf("Squares request in maintenance gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", nil, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("Assign in maintenance gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/reset/2-star", nil, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("Reset in maintenance gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/api/room/", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Room request with rooms off gave status %d", status)
+	}
+
+	update = map[string]interface{}{"maintenance": false, "features": map[string]bool{"rooms": true}}
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/config/", update, &c); status != http.StatusOK || c.Maintenance {
+		t.Fatalf("Config change gave %d, %+v", status, c)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/api/room/", nil, nil); status != http.StatusOK {
+		t.Errorf("Room request with rooms on gave status %d", status)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+Cookie policy
+
+The attributes of the server's cookies are part of the server
+configuration (see server.go):
+
+	setting         flag               environment              default
+	cookieName      -cookie-name       SUSEN_COOKIE_NAME        susenID
+	cookieSecure    -cookie-secure     SUSEN_COOKIE_SECURE      false
+	cookieHttpOnly  -cookie-http-only  SUSEN_COOKIE_HTTP_ONLY   false
+	cookieSameSite  -cookie-same-site  SUSEN_COOKIE_SAME_SITE   (none)
+	cookieMaxAge    -cookie-max-age    SUSEN_COOKIE_MAX_AGE     1 week
+	cookieLegacy    -cookie-legacy     SUSEN_COOKIE_LEGACY      true
+
+The name and the maximum age are those of the session cookie; a
+maximum age of 0 makes it last until the browser is closed.
+Secure is "true", "false", or "auto", which makes cookies secure
+on requests that came over HTTPS (directly, or through a trusted
+proxy that says so; see proxy.go).  Browsers don't let HTTP
+responses replace secure cookies, so "auto" is only for servers
+whose users don't go back and forth between HTTP and HTTPS.  SameSite is "lax",
+"strict", "none" (which browsers only accept on secure cookies),
+or empty to leave it out.  Secure and SameSite apply to all the
+server's cookies; HttpOnly only to the session cookie, since the
+login cookie is always HttpOnly.  If the server allows
+cross-origin requests (see cors.go), cookies on HTTPS requests
+are made SameSite=None and Secure whatever the policy says.
+
+Session IDs start with the protocol the session was made over,
+which is how sessions served over HTTP and HTTPS are kept apart
+(see getCookie in main.go): the protocol is the one a trusted
+proxy forwarded the request over, or "httpx" if there isn't one.  The rest of
+the ID is random.
+
+Session cookie values are versioned: they're the format version
+(now 2), a dot, and the session ID.  The legacy cookies of
+earlier servers are just the session ID (whose rest is the time
+the session was made), and while the server still reads them,
+which is the default, a request with a legacy cookie keeps its
+session, and gets its cookie replaced with a current one.  Once
+cookieLegacy is false, legacy cookies are refused, so their
+requests start new sessions.  Turning legacy reads off only
+after the current cookies have been handed out lets a change of
+format roll out without starting everyone over at once.  Admins
+can see how many cookies of each kind the server has read, how
+many it has refused, and how many new ones it has issued, with
+GET /api/admin/cookies, to tell when it's safe.
+
+*/
+
+// A cookiePolicy is the attributes of the server's cookies.
+type cookiePolicy struct {
+	Name     string
+	Secure   string // "true", "false", or "auto"
+	HTTPOnly bool
+	SameSite http.SameSite
+	MaxAge   time.Duration
+	Legacy   bool // whether legacy session cookies are read
+}
+
+// sessionCookie is the cookie policy the server uses.
+var sessionCookie = defaultCookiePolicy()
+
+// defaultCookiePolicy returns the policy used if the
+// configuration doesn't change it.
+func defaultCookiePolicy() cookiePolicy {
+	return cookiePolicy{Name: "susenID", Secure: "false", MaxAge: cookieMaxAge * time.Second, Legacy: true}
+}
+
+// cookieNamePattern matches the names cookies can have, and
+// sessionIDPattern the session IDs that follow the protocol in
+// session cookie values.
+var (
+	cookieNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	sessionIDPattern  = regexp.MustCompile("^[0-9a-z]{3,}$")
+)
+
+// setName sets the name of the session cookie.
+func (p *cookiePolicy) setName(v string) error {
+	if !cookieNamePattern.MatchString(v) {
+		return fmt.Errorf("invalid cookie name %q", v)
+	}
+	p.Name = v
+	return nil
+}
+
+// setSecure sets when cookies are secure.
+func (p *cookiePolicy) setSecure(v string) error {
+	switch v = strings.ToLower(v); v {
+	case "true", "false", "auto":
+		p.Secure = v
+		return nil
+	}
+	return fmt.Errorf("invalid cookie security %q (should be true, false, or auto)", v)
+}
+
+// setHTTPOnly sets whether the session cookie is HttpOnly.
+func (p *cookiePolicy) setHTTPOnly(v string) (e error) {
+	if p.HTTPOnly, e = strconv.ParseBool(v); e != nil {
+		return fmt.Errorf("invalid cookie HttpOnly %q", v)
+	}
+	return nil
+}
+
+// setSameSite sets the SameSite attribute of cookies.
+func (p *cookiePolicy) setSameSite(v string) error {
+	switch strings.ToLower(v) {
+	case "", "default":
+		p.SameSite = 0
+	case "lax":
+		p.SameSite = http.SameSiteLaxMode
+	case "strict":
+		p.SameSite = http.SameSiteStrictMode
+	case "none":
+		p.SameSite = http.SameSiteNoneMode
+	default:
+		return fmt.Errorf("invalid cookie SameSite %q (should be lax, strict, or none)", v)
+	}
+	return nil
+}
+
+// setLegacy sets whether legacy session cookies are read.
+func (p *cookiePolicy) setLegacy(v string) (e error) {
+	if p.Legacy, e = strconv.ParseBool(v); e != nil {
+		return fmt.Errorf("invalid cookie legacy %q", v)
+	}
+	return nil
+}
+
+// check checks that browsers will accept the policy's cookies.
+func (p cookiePolicy) check() error {
+	if p.SameSite == http.SameSiteNoneMode && p.Secure == "false" {
+		return fmt.Errorf("SameSite=None cookies have to be secure")
+	}
+	return nil
+}
+
+// requestProtocol returns the protocol session cookies are
+// distinguished by: the one a trusted proxy forwarded the request
+// over (see proxy.go), or "httpx" if it's unknown.
+func requestProtocol(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if _, proto := forwarded(r); proto != "" {
+			return proto
+		}
+	}
+	return "httpx"
+}
+
+// requestOrigin returns the scheme and host a request was sent
+// to, for URLs that lead back to the server.
+func requestOrigin(r *http.Request) string {
+	return requestScheme(r) + "://" + r.Host
+}
+
+// secureRequest tells whether a request came over HTTPS.
+func secureRequest(r *http.Request) bool {
+	return requestScheme(r) == "https"
+}
+
+// sessionValueFor tells whether a session ID was made for
+// requests over a protocol.
+func sessionValueFor(sessionID, proto string) bool {
+	rest := strings.TrimPrefix(sessionID, proto+"-")
+	return rest != sessionID && sessionIDPattern.MatchString(rest)
+}
+
+// sessionCookieVersion is the version of the session cookie
+// format.
+const sessionCookieVersion = "2"
+
+// sessionCookieValue returns the session cookie value for a
+// session ID.
+func sessionCookieValue(sessionID string) string {
+	return sessionCookieVersion + "." + sessionID
+}
+
+// cookieSessionID returns the session ID in a session cookie
+// value, and whether the value is a legacy one.
+func cookieSessionID(value string) (string, bool) {
+	if rest := strings.TrimPrefix(value, sessionCookieVersion+"."); rest != value {
+		return rest, false
+	}
+	return value, true
+}
+
+// newSessionID returns a new random session ID for requests over
+// a protocol.
+func newSessionID(proto string) string {
+	var b [10]byte
+	if _, e := rand.Read(b[:]); e != nil {
+		log.Fatal(e)
+	}
+	return proto + "-" + strings.ToLower(base32.StdEncoding.EncodeToString(b[:]))
+}
+
+// The counts of session cookies read in each format, refused,
+// and issued.
+var cookieCounts struct {
+	current, legacy, refused, issued int64
+}
+
+// cookiesHandler handles GET /api/admin/cookies, which is only
+// routed to for admins.
+func cookiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Cookie counts can only be read"))
+		return
+	}
+	counts := map[string]int64{
+		"current": atomic.LoadInt64(&cookieCounts.current),
+		"legacy":  atomic.LoadInt64(&cookieCounts.legacy),
+		"refused": atomic.LoadInt64(&cookieCounts.refused),
+		"issued":  atomic.LoadInt64(&cookieCounts.issued),
+	}
+	sendJSON(w, http.StatusOK, map[string]interface{}{"legacy": sessionCookie.Legacy, "counts": counts})
+}
+
+// apply gives a cookie the policy's attributes for a request.
+func (p cookiePolicy) apply(c *http.Cookie, r *http.Request) *http.Cookie {
+	switch p.Secure {
+	case "true":
+		c.Secure = true
+	case "auto":
+		c.Secure = secureRequest(r)
+	}
+	if p.SameSite != 0 && (p.SameSite != http.SameSiteNoneMode || c.Secure) {
+		c.SameSite = p.SameSite
+	}
+	return crossSiteCookie(c, r)
+}
+
+// newSessionCookie returns the session cookie, with a value, for
+// a request.
+func (p cookiePolicy) newSessionCookie(value string, r *http.Request) *http.Cookie {
+	c := &http.Cookie{Name: p.Name, Value: value, Path: cookiePath, HttpOnly: p.HTTPOnly}
+	if p.MaxAge > 0 {
+		c.MaxAge = int(p.MaxAge / time.Second)
+	}
+	return p.apply(c, r)
+}
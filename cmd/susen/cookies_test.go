@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/tls"
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCookieProtocols(t *testing.T) {
+	defer func(saved cookiePolicy) { sessionCookie = saved }(sessionCookie)
+	sessionCookie = defaultCookiePolicy()
+
+	// every combination of the protocol the request says it came
+	// over and the protocol of the cookie it brings
+	for _, header := range []string{"", "http", "https"} {
+		proto := header
+		if proto == "" {
+			proto = "httpx"
+		}
+		for _, cookie := range []string{"", "httpx-abc123", "http-abc123", "https-abc123", "xhttp-abc123", "http-ab",
+			"2.httpx-abc123", "2.http-abc123", "2.https-abc123", "3.https-abc123"} {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "127.0.0.1:5000" // a proxy on the server's host
+			if header != "" {
+				r.Header.Set("X-Forwarded-Proto", header)
+			}
+			if cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookie.Name, Value: cookie})
+			}
+			w := httptest.NewRecorder()
+			sid := getCookie(w, r)
+			set := w.Result().Cookies()
+			switch cookie {
+			case "2." + proto + "-abc123":
+				if sid != proto+"-abc123" || len(set) != 0 {
+					t.Errorf("Protocol %q with cookie %q got session %q and set %v", header, cookie, sid, set)
+				}
+				continue
+			case proto + "-abc123":
+				// legacy cookies keep their session
+				if sid != cookie || len(set) != 1 || set[0].Value != "2."+cookie {
+					t.Errorf("Protocol %q with cookie %q got session %q and set %v", header, cookie, sid, set)
+				}
+				continue
+			}
+			if !strings.HasPrefix(sid, proto+"-") || len(sid) != len(proto)+17 ||
+				len(set) != 1 || set[0].Value != "2."+sid {
+				t.Errorf("Protocol %q with cookie %q got session %q and set %v", header, cookie, sid, set)
+			}
+		}
+	}
+}
+
+func TestLegacyCookies(t *testing.T) {
+	defer func(saved cookiePolicy) { sessionCookie = saved }(sessionCookie)
+	sessionCookie = defaultCookiePolicy()
+	session := newSession("test-legacy-cookies")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	roles.Grant("header:legacy-admin", auth.RoleAdmin)
+	defer roles.Revoke("header:legacy-admin", auth.RoleAdmin)
+	counts := func() map[string]int64 {
+		var result struct {
+			Counts map[string]int64 `json:"counts"`
+		}
+		if status := helperUserRequest(t, srv, "legacy-admin", "GET", "/api/admin/cookies", nil, &result); status != http.StatusOK {
+			t.Fatalf("Cookie counts gave status %d", status)
+		}
+		return result.Counts
+	}
+	read := func(value string) (string, []*http.Cookie) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie.Name, Value: value})
+		w := httptest.NewRecorder()
+		return getCookie(w, r), w.Result().Cookies()
+	}
+
+	before := counts()
+	if sid, _ := read("httpx-abc123"); sid != "httpx-abc123" {
+		t.Errorf("Legacy cookie got session %q", sid)
+	}
+	if sid, _ := read("2.httpx-abc123"); sid != "httpx-abc123" {
+		t.Errorf("Current cookie got session %q", sid)
+	}
+	// once legacy reads are cut off, legacy cookies start over
+	sessionCookie.Legacy = false
+	if sid, set := read("httpx-abc123"); sid == "httpx-abc123" || len(set) != 1 || set[0].Value != "2."+sid {
+		t.Errorf("Refused legacy cookie got session %q and set %v", sid, set)
+	}
+	after := counts()
+	for kind, n := range map[string]int64{"current": 1, "legacy": 1, "refused": 1, "issued": 1} {
+		if after[kind]-before[kind] != n {
+			t.Errorf("Count of %s cookies went from %d to %d", kind, before[kind], after[kind])
+		}
+	}
+	if e := sessionCookie.setLegacy("yes"); e == nil {
+		t.Errorf("Cookie legacy %q was accepted", "yes")
+	}
+}
+
+func TestCookiePolicy(t *testing.T) {
+	defer func(saved cookiePolicy, origins []string) {
+		sessionCookie, corsOrigins = saved, origins
+	}(sessionCookie, corsOrigins)
+	corsOrigins = nil
+	request := func(tls *tls.ConnectionState, proto string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.TLS = tls
+		if proto != "" {
+			r.Header.Set("X-Forwarded-Proto", proto)
+		}
+		return r
+	}
+	requests := map[string]*http.Request{
+		"plain": request(nil, ""),
+		"tls":   request(&tls.ConnectionState{}, ""),
+		"http":  request(nil, "http"),
+		"https": request(nil, "https"),
+	}
+	secure := map[string]bool{"plain": false, "tls": true, "http": false, "https": true}
+
+	for _, setting := range []string{"true", "false", "auto"} {
+		for _, sameSite := range []string{"", "lax", "strict", "none"} {
+			p := defaultCookiePolicy()
+			if e := p.setSecure(setting); e != nil {
+				t.Fatalf("Can't set security %q: %v", setting, e)
+			}
+			if e := p.setSameSite(sameSite); e != nil {
+				t.Fatalf("Can't set SameSite %q: %v", sameSite, e)
+			}
+			if e := p.check(); (e != nil) != (setting == "false" && sameSite == "none") {
+				t.Errorf("Policy %s/%s check gave %v", setting, sameSite, e)
+				continue
+			}
+			for name, r := range requests {
+				c := p.newSessionCookie("httpx-abc123", r)
+				want := setting == "true" || (setting == "auto" && secure[name])
+				if c.Secure != want {
+					t.Errorf("Policy %s/%s on %s request: Secure is %v", setting, sameSite, name, c.Secure)
+				}
+				if sameSite == "none" && !c.Secure {
+					if c.SameSite != 0 {
+						t.Errorf("Policy %s/%s on %s request: insecure cookie has SameSite %v",
+							setting, sameSite, name, c.SameSite)
+					}
+				} else if c.SameSite != p.SameSite {
+					t.Errorf("Policy %s/%s on %s request: SameSite is %v", setting, sameSite, name, c.SameSite)
+				}
+			}
+		}
+	}
+
+	// the session cookie's own attributes
+	p := defaultCookiePolicy()
+	if e := p.setName("play"); e != nil {
+		t.Fatalf("Can't set name: %v", e)
+	}
+	if e := p.setHTTPOnly("true"); e != nil {
+		t.Fatalf("Can't set HttpOnly: %v", e)
+	}
+	p.MaxAge = time.Hour
+	c := p.newSessionCookie("httpx-abc123", requests["plain"])
+	if c.Name != "play" || !c.HttpOnly || c.MaxAge != 3600 || c.Path != cookiePath {
+		t.Errorf("Session cookie is %v", c)
+	}
+	p.MaxAge = 0
+	if c := p.newSessionCookie("httpx-abc123", requests["plain"]); c.MaxAge != 0 {
+		t.Errorf("Browser session cookie has max age %d", c.MaxAge)
+	}
+	for _, bad := range []string{"", "my cookie", "a;b", "é"} {
+		if e := p.setName(bad); e == nil {
+			t.Errorf("Cookie name %q was accepted", bad)
+		}
+	}
+
+	// cross-origin servers override the policy over HTTPS
+	corsOrigins = []string{"https://play.example.com"}
+	c = defaultCookiePolicy().newSessionCookie("https-abc123", requests["https"])
+	if !c.Secure || c.SameSite != http.SameSiteNoneMode {
+		t.Errorf("Cross-origin session cookie is %v", c)
+	}
+
+	// the server uses the policy for sessions
+	sessionCookie = p
+	w := httptest.NewRecorder()
+	getCookie(w, requests["plain"])
+	if set := w.Result().Cookies(); len(set) != 1 || set[0].Name != "play" || !set[0].HttpOnly {
+		t.Errorf("Server set cookies %v", set)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+/*
+
+Cross-origin requests
+
+A client hosted somewhere else (a single-page app, or a mobile
+web client) can use the API directly if its origin is one of
+the server's allowed origins, set with the corsOrigins server
+setting (see server.go) as a comma-separated list such as
+"https://play.example.com,https://m.example.com".  API responses
+to those origins allow credentials, so the client's requests
+carry the session cookie, and expose the headers clients need
+(request IDs, API versions, quotas, and rate limits).
+Preflight requests (such as the ones browsers send before
+posting JSON to /api/assign/) are answered directly, without
+making a session.
+
+Browsers only send cookies on cross-site requests if the cookie
+is SameSite=None and Secure, so when there are allowed origins,
+the session and login cookies of requests over HTTPS are like
+that.  Sessions served over plain HTTP can't be used cross-site.
+
+Since those cookies also go with requests from any other site,
+and browsers send simple requests (such as a form's text/plain
+post) without asking first, requests that could change anything
+(any method but GET, HEAD, and OPTIONS), to any path, are refused
+unless they come from the server's own origin or an allowed one.
+Requests without an Origin header aren't from browsers on other
+sites, so they're taken.
+
+*/
+
+// The CORS request methods and headers allowed, the response
+// headers exposed, and how long (in seconds) browsers can cache
+// preflight responses.
+const (
+	corsMethods = "GET, POST, PUT, DELETE"
+	corsExposed = "X-Request-ID, Susen-Api-Version, Deprecation, Link, Retry-After, " +
+		"X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset, X-Board-Analysis, X-Board-Progress, " +
+		"X-Poll-Interval, X-Next-Cursor, X-Total-Count, X-Puzzle-ID, X-Puzzle-Seed, Idempotent-Replayed, X-Board-Version, Susen-Session-Holder"
+	corsMaxAge = "600"
+)
+
+// corsHeaders are the request headers clients can send.
+var corsHeaders = map[string]bool{
+	"accept": true, "content-type": true, "x-request-id": true, "idempotency-key": true, "x-board-version": true,
+}
+
+// corsOrigins are the allowed origins.
+var corsOrigins []string
+
+// parseOrigins parses a comma-separated list of origins.
+func parseOrigins(v string) []string {
+	var origins []string
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// allowedOrigin tells whether an origin is allowed.
+func allowedOrigin(origin string) bool {
+	for _, o := range corsOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeMethods are the request methods that don't change anything.
+var safeMethods = map[string]bool{"GET": true, "HEAD": true, "OPTIONS": true}
+
+// corsAllowed adds the CORS headers to API responses for allowed
+// origins, and answers their preflight requests.  Requests from
+// other origins that could change something are refused.
+func corsAllowed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && !safeMethods[r.Method] && origin != requestOrigin(r) && !allowedOrigin(origin) {
+			sendError(w, http.StatusForbidden, requestError("Origin "+origin+" can't make changes"))
+			return
+		}
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hs := w.Header()
+		hs.Add("Vary", "Origin")
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !allowedOrigin(origin) {
+			if preflight {
+				sendError(w, http.StatusForbidden, requestError("Origin "+origin+" isn't allowed"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		hs.Set("Access-Control-Allow-Origin", origin)
+		hs.Set("Access-Control-Allow-Credentials", "true")
+		if !preflight {
+			hs.Set("Access-Control-Expose-Headers", corsExposed)
+			next.ServeHTTP(w, r)
+			return
+		}
+		var allowed []string
+		for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h == "" {
+				continue
+			} else if !corsHeaders[h] {
+				sendError(w, http.StatusForbidden, requestError("Header "+h+" isn't allowed"))
+				return
+			}
+			allowed = append(allowed, h)
+		}
+		hs.Set("Access-Control-Allow-Methods", corsMethods)
+		if len(allowed) > 0 {
+			hs.Set("Access-Control-Allow-Headers", strings.Join(allowed, ", "))
+		}
+		hs.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// crossSiteCookie makes a cookie usable on cross-site requests
+// from the allowed origins, if there are any and the request
+// came over HTTPS.
+func crossSiteCookie(c *http.Cookie, r *http.Request) *http.Cookie {
+	if len(corsOrigins) > 0 && secureRequest(r) {
+		c.SameSite, c.Secure = http.SameSiteNoneMode, true
+	}
+	return c
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	defer func(saved []string) { corsOrigins = saved }(corsOrigins)
+	corsOrigins = parseOrigins(" https://play.example.com/, https://m.example.com ,")
+	if !reflect.DeepEqual(corsOrigins, []string{"https://play.example.com", "https://m.example.com"}) {
+		t.Fatalf("Parsed origins are %q", corsOrigins)
+	}
+	var served int
+	srv := httptest.NewServer(corsAllowed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		http.SetCookie(w, crossSiteCookie(&http.Cookie{Name: sessionCookie.Name, Value: "https-test"}, r))
+	})))
+	defer srv.Close()
+	do := func(method, path, origin string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("%s %s failed: %v", method, path, e)
+		}
+		r.Body.Close()
+		return r
+	}
+
+	// preflights are answered without reaching the server
+	r := do("OPTIONS", "/api/v1/assign/", "https://play.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "Content-Type, X-Request-ID",
+	})
+	hs := r.Header
+	if r.StatusCode != http.StatusNoContent || served != 0 ||
+		hs.Get("Access-Control-Allow-Origin") != "https://play.example.com" ||
+		hs.Get("Access-Control-Allow-Credentials") != "true" ||
+		!strings.Contains(hs.Get("Access-Control-Allow-Methods"), "POST") ||
+		hs.Get("Access-Control-Allow-Headers") != "content-type, x-request-id" {
+		t.Errorf("Preflight gave %d, %v", r.StatusCode, hs)
+	}
+	for _, origin := range []string{"https://evil.example.com", "http://play.example.com"} {
+		r := do("OPTIONS", "/api/assign/", origin, map[string]string{"Access-Control-Request-Method": "POST"})
+		if r.StatusCode != http.StatusForbidden || r.Header.Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Preflight from %s gave %d, %v", origin, r.StatusCode, r.Header)
+		}
+	}
+	r = do("OPTIONS", "/api/assign/", "https://m.example.com", map[string]string{
+		"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Secret",
+	})
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("Preflight with an unknown header gave %d", r.StatusCode)
+	}
+
+	// allowed origins get credentials and cross-site cookies over HTTPS
+	r = do("GET", "/api/squares/", "https://m.example.com", map[string]string{"X-Forwarded-Proto": "https"})
+	if r.Header.Get("Access-Control-Allow-Origin") != "https://m.example.com" ||
+		!strings.Contains(r.Header.Get("Access-Control-Expose-Headers"), "X-Request-ID") ||
+		!strings.Contains(r.Header.Get("Set-Cookie"), "SameSite=None") ||
+		!strings.Contains(r.Header.Get("Set-Cookie"), "Secure") {
+		t.Errorf("Cross-origin request got headers %v", r.Header)
+	}
+	r = do("GET", "/api/squares/", "https://evil.example.com", nil)
+	if r.Header.Get("Access-Control-Allow-Origin") != "" || strings.Contains(r.Header.Get("Set-Cookie"), "SameSite=None") {
+		t.Errorf("Request from another origin got headers %v", r.Header)
+	}
+	r = do("GET", "/solver/", "https://m.example.com", nil)
+	if r.Header.Get("Access-Control-Allow-Origin") != "" || served != 3 {
+		t.Errorf("Page request got headers %v, after %d served", r.Header, served)
+	}
+
+	// changes from other origins are refused, wherever they're sent
+	for _, path := range []string{"/api/assign/", "/api/import", "/solver/plain"} {
+		r = do("POST", path, "https://evil.example.com", map[string]string{"Content-Type": "text/plain"})
+		if r.StatusCode != http.StatusForbidden || served != 3 {
+			t.Errorf("Post to %s from another origin gave %d, after %d served", path, r.StatusCode, served)
+		}
+	}
+	do("POST", "/api/assign/", "https://play.example.com", nil)
+	do("DELETE", "/api/slots/a", srv.URL, nil)
+	if served != 5 {
+		t.Errorf("Changes from allowed and own origins were refused (%d served)", served)
+	}
+}
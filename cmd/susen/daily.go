@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // so zones work on hosts without zoneinfo
+)
+
+/*
+
+Daily puzzle
+
+Every day has its own puzzle, which is generated from a seed
+made from the date, so everyone gets the same puzzle that day on
+every server.  Resetting to the puzzle ID "daily" starts today's
+puzzle, under the puzzle ID "daily:<yyyy-mm-dd>", so each day's
+puzzle has its own statistics and leaderboard.  Earlier days'
+puzzles can be played by their IDs, but later days' can't (they
+start the default puzzle, like any other unknown ID).
+
+The seed version is fixed, so changes to the generator never
+change the puzzle of a day that's already been played.
+
+When "today" is depends on a time zone, never on where the
+server is: it's the deployment's zone, named by SUSEN_DAILY_ZONE
+(such as "America/New_York", defaulting to UTC), unless the
+player has chosen their own.  Players choose a zone (or go back
+to the deployment's) with the daily endpoint (see dailyHandler).
+Identified users' choices are kept in the store, so they follow
+the user to every browser and to every server sharing the store,
+and anonymous players' are kept in a cookie.  A day's puzzle
+can be played once the day has started in the player's zone,
+and its leaderboard and totals can be read once it has started
+anywhere.
+
+*/
+
+// dailyPuzzleID is the puzzle ID for today's puzzle, and the
+// puzzle IDs of particular days start with dailyIDPrefix.  The
+// puzzles of the last maxDailyCached days played are kept.
+const (
+	dailyPuzzleID   = "daily"
+	dailyIDPrefix   = "daily:"
+	dailyDateForm   = "2006-01-02"
+	dailySeedPrefix = "1:daily:"
+	maxDailyCached  = 7
+)
+
+var (
+	dailyCache = make(map[string][]int) // puzzle values by date
+	dailyMutex sync.Mutex
+)
+
+// Daily time zones: the zone of a user's choice is kept in the
+// store under dailyZoneKind, and an anonymous player's in the
+// dailyZoneCookie.  No day starts sooner than in earliestZone.
+const (
+	dailyZoneKind   = "daily-zone"
+	dailyZoneCookie = "susenDailyZone"
+)
+
+var (
+	dailyZone    = time.UTC // the deployment's zone
+	earliestZone = time.FixedZone("UTC+14", 14*60*60)
+)
+
+// loadDailyZone sets the deployment's zone from the environment.
+func loadDailyZone() {
+	name := os.Getenv("SUSEN_DAILY_ZONE")
+	if name == "" {
+		return
+	}
+	loc, e := time.LoadLocation(name)
+	if e != nil {
+		log.Fatalf("Invalid SUSEN_DAILY_ZONE: %v", e)
+	}
+	dailyZone = loc
+	log.Printf("Daily puzzles change at midnight in %v.", loc)
+}
+
+// dailyID returns the puzzle ID of the daily puzzle for the day
+// of the given time in the deployment's zone.
+func dailyID(t time.Time) string {
+	return zonedDailyID(t, dailyZone)
+}
+
+// zonedDailyID returns the puzzle ID of the daily puzzle for the
+// day of the given time in the given zone.
+func zonedDailyID(t time.Time, loc *time.Location) string {
+	return dailyIDPrefix + t.In(loc).Format(dailyDateForm)
+}
+
+// todayID returns the puzzle ID of today's daily puzzle for the
+// player making a request.
+func todayID(r *http.Request) string {
+	return zonedDailyID(time.Now(), requestZone(r))
+}
+
+// parseDailyID returns the date of a daily puzzle ID.  It's not
+// a daily puzzle ID if the date hasn't started anywhere.
+func parseDailyID(puzzleID string) (time.Time, bool) {
+	return parseZonedDailyID(puzzleID, earliestZone)
+}
+
+// parseZonedDailyID returns the date of a daily puzzle ID.  It's
+// not a daily puzzle ID if the date hasn't started in the given
+// zone.
+func parseZonedDailyID(puzzleID string, loc *time.Location) (time.Time, bool) {
+	if !strings.HasPrefix(puzzleID, dailyIDPrefix) {
+		return time.Time{}, false
+	}
+	day := puzzleID[len(dailyIDPrefix):]
+	date, e := time.Parse(dailyDateForm, day)
+	if e != nil || day > time.Now().In(loc).Format(dailyDateForm) {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// requestZone returns the zone the player making a request has
+// chosen for daily puzzles, or the deployment's zone if they
+// haven't chosen one.
+func requestZone(r *http.Request) *time.Location {
+	loc, _ := chosenZone(r)
+	return loc
+}
+
+// chosenZone returns the zone for a request's daily puzzles, and
+// whether the player chose it.
+func chosenZone(r *http.Request) (*time.Location, bool) {
+	name := ""
+	if user := auth.FromRequest(r); user != nil {
+		if _, e := store.Get(dailyZoneKind, user.Key(), &name); e != nil {
+			log.Printf("Can't read daily zone of %v: %v", user.Key(), e)
+		}
+	} else if c, e := r.Cookie(dailyZoneCookie); e == nil {
+		name = c.Value
+	}
+	if name == "" {
+		return dailyZone, false
+	}
+	loc, e := time.LoadLocation(name)
+	if e != nil {
+		return dailyZone, false
+	}
+	return loc, true
+}
+
+// A dailyInfo describes today's daily puzzle for a player.
+type dailyInfo struct {
+	PuzzleID string    `json:"puzzleID"`
+	Seed     string    `json:"seed"` // the generation seed of its puzzle
+	Zone     string    `json:"zone"`
+	Chosen   bool      `json:"chosen"` // whether the player chose the zone
+	Next     time.Time `json:"next"`   // when the next day's puzzle starts
+}
+
+// newDailyInfo returns the daily information for a zone.
+func newDailyInfo(loc *time.Location, chosen bool) dailyInfo {
+	now := time.Now().In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	id := zonedDailyID(now, loc)
+	return dailyInfo{id, dailySeed(id), loc.String(), chosen, next}
+}
+
+// dailyHandler handles the daily endpoint:
+//
+// - GET /api/daily/ gives today's daily puzzle ID, its seed (see
+// puzzle.Generate), the zone it's for, and when the next one
+// starts
+//
+// - POST /api/daily/ with {"zone": "<IANA zone name>"} chooses
+// the player's zone (the empty name goes back to the
+// deployment's), and gives the same as GET
+func dailyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		sendJSON(w, http.StatusOK, newDailyInfo(chosenZone(r)))
+	case "POST":
+		var req struct {
+			Zone string `json:"zone"`
+		}
+		if e := decodeRequest(w, r, &req); e != nil && e != io.EOF {
+			sendError(w, requestStatus(e), requestError("Invalid daily zone request: "+e.Error()))
+			return
+		}
+		loc := dailyZone
+		if req.Zone != "" {
+			var e error
+			if loc, e = time.LoadLocation(req.Zone); e != nil || req.Zone == "Local" {
+				sendError(w, http.StatusBadRequest, requestError("Unknown time zone: "+req.Zone))
+				return
+			}
+		}
+		if user := auth.FromRequest(r); user != nil {
+			if e := store.Put(dailyZoneKind, user.Key(), req.Zone); e != nil {
+				sendError(w, http.StatusInternalServerError, requestError("Can't save daily zone: "+e.Error()))
+				return
+			}
+		}
+		c := &http.Cookie{Name: dailyZoneCookie, Value: req.Zone, Path: cookiePath, MaxAge: cookieMaxAge}
+		if req.Zone == "" {
+			c.MaxAge = -1
+		}
+		http.SetCookie(w, sessionCookie.apply(c, r))
+		sendJSON(w, http.StatusOK, newDailyInfo(loc, req.Zone != ""))
+	default:
+		sendError(w, http.StatusMethodNotAllowed, requestError("Daily zones can only be read or chosen"))
+	}
+}
+
+// dailySeed returns the generation seed of a daily puzzle ID.
+func dailySeed(puzzleID string) string {
+	return dailySeedPrefix + strings.TrimPrefix(puzzleID, dailyIDPrefix)
+}
+
+// dailyValues returns the puzzle values for the daily puzzle of
+// a date, generating them the first time they're needed.  Only
+// the most recent days' values are kept.
+func dailyValues(date time.Time) []int {
+	key := date.Format(dailyDateForm)
+	dailyMutex.Lock()
+	defer dailyMutex.Unlock()
+	if vals, ok := dailyCache[key]; ok {
+		return vals
+	}
+	g, e := generatePuzzle(context.Background(), puzzle.GenerateParams{Seed: dailySeed(dailyIDPrefix + key)}, nil)
+	if e != nil {
+		log.Fatal(e)
+	}
+	if len(dailyCache) >= maxDailyCached {
+		// make room, unless this day is older than all the kept ones
+		oldest := key
+		for k := range dailyCache {
+			if k < oldest {
+				oldest = k
+			}
+		}
+		delete(dailyCache, oldest)
+	}
+	if len(dailyCache) < maxDailyCached {
+		dailyCache[key] = g.Values
+	}
+	log.Printf("Generated daily puzzle for %s from seed %q.", key, g.Seed)
+	return g.Values
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDaily(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	first, second := newSession("test-daily-1"), newSession("test-daily-2")
+	srv := httptest.NewServer(http.HandlerFunc(first.rootHandler))
+	defer srv.Close()
+
+	// everyone gets the same puzzle today
+	r, e := http.Get(srv.URL + "/reset/daily")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	today := dailyID(time.Now())
+	second.reset(dailyPuzzleID)
+	if first.puzzleID != today || second.puzzleID != today || !reflect.DeepEqual(first.values, second.values) {
+		t.Errorf("Daily puzzles are %q %v and %q %v", first.puzzleID, first.values, second.puzzleID, second.values)
+	}
+	var stats puzzleStats
+	if helperGetJSON(t, srv, "/api/stats", &stats); stats.PuzzleID != today || !stats.Daily {
+		t.Errorf("Daily stats are %+v", stats)
+	}
+
+	// other days have other puzzles, and later days can't be played
+	second.reset(dailyID(time.Now().AddDate(0, 0, -1)))
+	if reflect.DeepEqual(first.values, second.values) {
+		t.Errorf("Yesterday's daily puzzle is the same as today's")
+	}
+	second.reset(dailyID(time.Now().AddDate(0, 0, 1)))
+	if second.puzzleID != defaultPuzzleID {
+		t.Errorf("Tomorrow's daily puzzle gave puzzle %q", second.puzzleID)
+	}
+
+	// the daily leaderboard is today's
+	helperSolve(t, srv, first)
+	var lb leaderboard
+	if code := helperGetJSON(t, srv, "/api/leaderboard/daily", &lb); code != http.StatusOK || lb.Board != today || len(lb.Entries) != 1 {
+		t.Errorf("Daily leaderboard gave %d, %+v", code, lb)
+	}
+}
+
+func TestDailyZone(t *testing.T) {
+	saved, savedZone := store, dailyZone
+	store = storage.NewMemory()
+	defer func() { store, dailyZone = saved, savedZone }()
+	t.Setenv("SUSEN_DAILY_ZONE", "Asia/Tokyo")
+	loadDailyZone()
+	if dailyZone.String() != "Asia/Tokyo" {
+		t.Errorf("Deployment zone is %v", dailyZone)
+	}
+	dailyZone = time.UTC
+	session := newSession("test-daily-zone")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	c := helperBrowser(t)
+
+	var info dailyInfo
+	if status := helperBrowserRequest(t, c, srv, "GET", "/api/daily/", nil, &info); status != http.StatusOK ||
+		info.Zone != "UTC" || info.Chosen || info.PuzzleID != dailyID(time.Now()) || !info.Next.After(time.Now()) ||
+		info.Seed != "1:"+info.PuzzleID {
+		t.Errorf("Default daily info gave %d, %+v", status, info)
+	}
+
+	// a browser's zone is kept in a cookie, and picks its daily
+	// puzzle, even when it's already tomorrow in UTC
+	kiritimati, _ := time.LoadLocation("Pacific/Kiritimati")
+	today := zonedDailyID(time.Now(), kiritimati)
+	req := map[string]string{"zone": "Pacific/Kiritimati"}
+	if status := helperBrowserRequest(t, c, srv, "POST", "/api/daily/", req, &info); status != http.StatusOK ||
+		info.Zone != "Pacific/Kiritimati" || !info.Chosen || info.PuzzleID != today {
+		t.Errorf("Choosing a zone gave %d, %+v", status, info)
+	}
+	helperBrowserRequest(t, c, srv, "GET", "/reset/daily", nil, nil)
+	if session.puzzleID != today {
+		t.Errorf("Daily puzzle in a chosen zone is %q, not %q", session.puzzleID, today)
+	}
+	helperBrowserRequest(t, c, srv, "GET", "/reset/", nil, nil)
+	if session.puzzleID != today {
+		t.Errorf("Restarted daily puzzle in a chosen zone is %q, not %q", session.puzzleID, today)
+	}
+	session.resetIn(zonedDailyID(time.Now().AddDate(0, 0, 1), kiritimati), kiritimati)
+	if session.puzzleID != defaultPuzzleID {
+		t.Errorf("Tomorrow's puzzle in a chosen zone gave puzzle %q", session.puzzleID)
+	}
+	if status := helperBrowserRequest(t, c, srv, "POST", "/api/daily/", map[string]string{"zone": "Mars/Olympus"}, nil); status != http.StatusBadRequest {
+		t.Errorf("Choosing an unknown zone gave status %d", status)
+	}
+	helperBrowserRequest(t, c, srv, "POST", "/api/daily/", map[string]string{"zone": ""}, &info)
+	if helperBrowserRequest(t, c, srv, "GET", "/api/daily/", nil, &info); info.Zone != "UTC" || info.Chosen {
+		t.Errorf("Daily info after going back to the deployment's zone is %+v", info)
+	}
+
+	// a user's zone is kept in the store, so it follows them
+	helperUserRequest(t, srv, "alice", "POST", "/api/daily/", map[string]string{"zone": "America/New_York"}, nil)
+	if helperUserRequest(t, srv, "alice", "GET", "/api/daily/", nil, &info); info.Zone != "America/New_York" || !info.Chosen {
+		t.Errorf("User's daily info is %+v", info)
+	}
+	var name string
+	if found, _ := store.Get(dailyZoneKind, "header:alice", &name); !found || name != "America/New_York" {
+		t.Errorf("User's zone in the store is %q", name)
+	}
+	if helperUserRequest(t, srv, "bob", "GET", "/api/daily/", nil, &info); info.Zone != "UTC" {
+		t.Errorf("Other user's daily info is %+v", info)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Client deadlines
+
+Clients on slow or metered networks would rather give up on a
+request than wait for it indefinitely, so any request can carry
+a deadline in its Susen-Deadline header, either as a duration
+(such as 2500ms or 3s) from when the server receives it, or as
+an RFC 3339 time.  The deadline goes into the request's context,
+so it bounds finding the session, the solver's searches (see
+puzzle/cancel.go), and the report reads from the store (see
+replica.go).  A request that isn't done by its deadline gets a
+Gateway Timeout response with DeadlineExceededCondition, which
+clients can tell from the other failures (and from the Service
+Unavailable responses of requests that were canceled some other
+way).  An invalid deadline gets a Bad Request response.
+
+Operations that change a board aren't interrupted part way
+through, so a deadline doesn't leave a board half changed: it
+can only stop a request before it changes anything.
+
+*/
+
+// deadlineHeader is the request header with the client's
+// deadline.
+const deadlineHeader = "Susen-Deadline"
+
+// requestDeadline returns the deadline in a request, if there is
+// one, or false if the deadline is invalid.
+func requestDeadline(r *http.Request, now time.Time) (time.Time, bool) {
+	value := strings.TrimSpace(r.Header.Get(deadlineHeader))
+	if value == "" {
+		return time.Time{}, true
+	}
+	if d, e := time.ParseDuration(value); e == nil {
+		if d <= 0 {
+			return time.Time{}, false
+		}
+		return now.Add(d), true
+	}
+	t, e := time.Parse(time.RFC3339Nano, value)
+	return t, e == nil
+}
+
+// withDeadline puts the request's deadline, if any, in its
+// context.
+func withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := requestDeadline(r, time.Now())
+		if !ok {
+			sendError(w, http.StatusBadRequest,
+				requestError("Invalid "+deadlineHeader+" header: "+r.Header.Get(deadlineHeader)))
+			return
+		}
+		if deadline.IsZero() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// deadlineError is the error for requests that aren't done by
+// their deadlines.
+func deadlineError() puzzle.Error {
+	return puzzle.Error{
+		Scope:     puzzle.RequestScope,
+		Structure: puzzle.ScopeStructure,
+		Condition: puzzle.DeadlineExceededCondition,
+	}
+}
+
+// refuseExpired sends the deadline error, and returns true, if
+// the request's deadline has passed.
+func refuseExpired(w http.ResponseWriter, r *http.Request) bool {
+	if r.Context().Err() != context.DeadlineExceeded {
+		return false
+	}
+	sendError(w, http.StatusGatewayTimeout, deadlineError())
+	return true
+}
+
+// sendSolverError sends an error from the solver with the given
+// status, unless it's because the request's deadline passed.
+func sendSolverError(w http.ResponseWriter, status int, e error) {
+	err, ok := e.(puzzle.Error)
+	if !ok {
+		err = requestError(e.Error())
+	}
+	if err.Condition == puzzle.DeadlineExceededCondition {
+		status = http.StatusGatewayTimeout
+	}
+	sendError(w, status, err)
+}
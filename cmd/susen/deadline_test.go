@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header   string
+		expected time.Time
+		ok       bool
+	}{
+		{"", time.Time{}, true},
+		{"2500ms", now.Add(2500 * time.Millisecond), true},
+		{" 3s ", now.Add(3 * time.Second), true},
+		{"2026-03-01T12:00:05Z", now.Add(5 * time.Second), true},
+		{"0s", time.Time{}, false},
+		{"-1s", time.Time{}, false},
+		{"soon", time.Time{}, false},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/api/state/", nil)
+		if c.header != "" {
+			r.Header.Set(deadlineHeader, c.header)
+		}
+		deadline, ok := requestDeadline(r, now)
+		if ok != c.ok || (ok && !deadline.Equal(c.expected)) {
+			t.Errorf("Case %d: %q gave %v, %v", i, c.header, deadline, ok)
+		}
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	var got context.Context
+	h := withDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Context()
+		if !refuseExpired(w, r) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	serve := func(header string) int {
+		w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/api/state/", nil)
+		if header != "" {
+			r.Header.Set(deadlineHeader, header)
+		}
+		got = nil
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if status := serve(""); status != http.StatusNoContent {
+		t.Errorf("No deadline gave status %d", status)
+	} else if _, ok := got.Deadline(); ok {
+		t.Errorf("No deadline gave a context with a deadline")
+	}
+	if status := serve("1m"); status != http.StatusNoContent {
+		t.Errorf("Distant deadline gave status %d", status)
+	} else if d, ok := got.Deadline(); !ok || time.Until(d) > time.Minute {
+		t.Errorf("Distant deadline gave a context with deadline %v", d)
+	}
+	if status := serve("2000-01-01T00:00:00Z"); status != http.StatusGatewayTimeout {
+		t.Errorf("Past deadline gave status %d", status)
+	}
+	if status := serve("whenever"); status != http.StatusBadRequest || got != nil {
+		t.Errorf("Invalid deadline gave status %d", status)
+	}
+}
+
+func TestSolverDeadline(t *testing.T) {
+	session := newSession("test-solver-deadline")
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	w := httptest.NewRecorder()
+	session.mutex.Lock()
+	session.ratingHandler(w, httptest.NewRequest("GET", "/api/rating/", nil).WithContext(ctx))
+	session.mutex.Unlock()
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Rating past the deadline gave status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	sendSolverError(w, http.StatusBadRequest, puzzle.Error{Condition: puzzle.DeadlineExceededCondition})
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Deadline solver error gave status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	sendSolverError(w, http.StatusBadRequest, requestError("No"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Other solver error gave status %d", w.Code)
+	}
+}
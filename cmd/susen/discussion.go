@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Discussions
+
+Each catalog puzzle (and each day's daily puzzle) has a
+discussion thread, but only players who have completed the
+puzzle can read or post to it, so nobody stumbles on a spoiler.
+Completion is checked against the user's results, so only
+identified users can take part.  Moderators can read every
+thread without completing its puzzle, and can remove posts.
+Threads are kept in the store.
+
+*/
+
+// A discussionPost is one post in a puzzle's thread.
+type discussionPost struct {
+	ID     int       `json:"id"`
+	Key    string    `json:"key"`
+	Name   string    `json:"name"`
+	Text   string    `json:"text"`
+	Posted time.Time `json:"posted"`
+}
+
+// A discussion is the thread of a puzzle.  NextID is the ID of
+// the next post.
+type discussion struct {
+	PuzzleID string           `json:"puzzleID"`
+	Posts    []discussionPost `json:"posts"`
+	NextID   int              `json:"nextID"`
+}
+
+// discussionKind is the storage kind for discussions.  Posts can
+// be at most maxPostLength bytes, and threads keep their last
+// maxDiscussionPosts posts.
+const (
+	discussionKind     = "discussion"
+	maxPostLength      = 2000
+	maxDiscussionPosts = 500
+)
+
+// discussionMutex serializes the updates of discussions.
+var discussionMutex sync.Mutex
+
+// readDiscussion returns the thread of a puzzle.  It must be
+// called with the discussions locked.
+func readDiscussion(puzzleID string) (discussion, error) {
+	d := discussion{PuzzleID: puzzleID, NextID: 1}
+	_, e := store.Get(discussionKind, puzzleID, &d)
+	if d.Posts == nil {
+		d.Posts = []discussionPost{}
+	}
+	return d, e
+}
+
+// discussionHandler handles the discussion endpoints, which are
+// only for identified users who have completed the puzzle (and
+// for moderators):
+//
+// - GET /api/discussion/<puzzleID> gives the puzzle's thread
+//
+// - POST /api/discussion/<puzzleID> adds a post; the body gives
+// its text
+//
+// - DELETE /api/discussion/<puzzleID>/<postID> removes a post
+// (moderators only)
+//
+// All of them respond with the thread.  "daily" means today's
+// daily puzzle.
+func discussionHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.FromRequest(r)
+	if user == nil {
+		sendError(w, http.StatusUnauthorized, requestError("Discussions are only for logged-in users"))
+		return
+	}
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/discussion/"), "/"), "/", 2)
+	puzzleID := parts[0]
+	if puzzleID == dailyPuzzleID {
+		puzzleID = todayID(r)
+	}
+	if puzzleID == blitzPuzzleID || !knownPuzzleID(puzzleID) {
+		sendError(w, http.StatusNotFound, requestError("No puzzle "+puzzleID))
+		return
+	}
+	moderator := roles.Has(user, auth.RoleModerator)
+	if _, completed := readResult(user.Key(), puzzleID); !completed && !moderator {
+		sendError(w, http.StatusForbidden, requestError("Complete puzzle "+puzzleID+" to see its discussion"))
+		return
+	}
+	discussionMutex.Lock()
+	defer discussionMutex.Unlock()
+	d, e := readDiscussion(puzzleID)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't read discussion: "+e.Error()))
+		return
+	}
+	switch {
+	case r.Method == "GET" && len(parts) == 1:
+	case r.Method == "POST" && len(parts) == 1:
+		var req struct {
+			Text string `json:"text"`
+		}
+		if e := decodeRequest(w, r, &req); e != nil || strings.TrimSpace(req.Text) == "" {
+			sendError(w, requestStatus(e), requestError("A post needs some text"))
+			return
+		}
+		if len(req.Text) > maxPostLength {
+			sendError(w, http.StatusRequestEntityTooLarge,
+				requestError("Posts can be at most "+strconv.Itoa(maxPostLength)+" bytes"))
+			return
+		}
+		d.Posts = append(d.Posts, discussionPost{d.NextID, user.Key(), user.Name, req.Text, time.Now()})
+		d.NextID++
+		if len(d.Posts) > maxDiscussionPosts {
+			d.Posts = d.Posts[len(d.Posts)-maxDiscussionPosts:]
+		}
+	case r.Method == "DELETE" && len(parts) == 2:
+		if !moderator {
+			sendError(w, http.StatusForbidden, requestError("Only moderators can remove posts"))
+			return
+		}
+		id, _ := strconv.Atoi(parts[1])
+		index := -1
+		for i, post := range d.Posts {
+			if post.ID == id {
+				index = i
+			}
+		}
+		if index < 0 {
+			sendError(w, http.StatusNotFound, requestError("No post "+parts[1]))
+			return
+		}
+		d.Posts = append(d.Posts[:index], d.Posts[index+1:]...)
+		log.Printf("User %v removed post %d from discussion %q.", user.Key(), id, puzzleID)
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown discussion operation: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	if r.Method != "GET" {
+		if e := store.Put(discussionKind, puzzleID, d); e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't save discussion: "+e.Error()))
+			return
+		}
+	}
+	sendJSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscussion(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	srv := helperUserServer(newSession("test-discussion"))
+	defer srv.Close()
+	roles.Grant("header:mod", auth.RoleModerator)
+	defer roles.Revoke("header:mod", auth.RoleModerator)
+	store.Put(resultKind, resultKey("header:ann", defaultPuzzleID), puzzleResult{First: time.Now(), Completions: 1})
+	path := "/api/discussion/" + defaultPuzzleID
+
+	// only those who completed the puzzle can see its thread
+	if status := helperUserRequest(t, srv, "", "GET", path, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Anonymous discussion read gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "bob", "GET", path, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Discussion read before completion gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "bob", "POST", path, map[string]string{"text": "spoiler"}, nil); status != http.StatusForbidden {
+		t.Errorf("Discussion post before completion gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "ann", "GET", "/api/discussion/no-such-puzzle", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Discussion of unknown puzzle gave status %d", status)
+	}
+	var d discussion
+	if status := helperUserRequest(t, srv, "ann", "GET", path, nil, &d); status != http.StatusOK || len(d.Posts) != 0 {
+		t.Errorf("Empty discussion gave %d, %+v", status, d)
+	}
+	for _, text := range []string{"nice X-wing", "the corner was tricky"} {
+		d = discussion{}
+		if status := helperUserRequest(t, srv, "ann", "POST", path, map[string]string{"text": text}, &d); status != http.StatusOK {
+			t.Fatalf("Post gave status %d", status)
+		}
+	}
+	if len(d.Posts) != 2 || d.Posts[1].ID != 2 || d.Posts[1].Key != "header:ann" || d.Posts[1].Text != "the corner was tricky" {
+		t.Errorf("Discussion after posts is %+v", d)
+	}
+	for _, text := range []string{" ", strings.Repeat("x", maxPostLength+1)} {
+		if status := helperUserRequest(t, srv, "ann", "POST", path, map[string]string{"text": text}, nil); status == http.StatusOK {
+			t.Errorf("Post of %d bytes was accepted", len(text))
+		}
+	}
+
+	// moderators can read without completing, and remove posts
+	if status := helperUserRequest(t, srv, "ann", "DELETE", path+"/1", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Post removal by a player gave status %d", status)
+	}
+	d = discussion{}
+	if status := helperUserRequest(t, srv, "mod", "DELETE", path+"/1", nil, &d); status != http.StatusOK || len(d.Posts) != 1 || d.Posts[0].ID != 2 {
+		t.Errorf("Post removal gave %d, %+v", status, d)
+	}
+	if status := helperUserRequest(t, srv, "mod", "DELETE", path+"/1", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Removal of a removed post gave status %d", status)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+
+Error envelopes
+
+Every error response has the same JSON body, whichever endpoint
+it comes from: a puzzle.Error in its envelope (see
+puzzle.Error.Envelope), with
+
+- code: the response status in snake case, such as "not_found"
+or "method_not_allowed", for clients that just want to know what
+kind of failure it was
+
+- scope, structure, attribute, condition, and values: what
+failed, and why (see puzzle/error.go), for clients that want to
+know more
+
+- message: a description of the failure in the response's
+language (see locale.go), for clients to show
+
+- requestID: the ID of the request (see requestlog.go), for
+matching failures to the logs
+
+- field: the request field (query parameter or body field) at
+fault, when it's known.
+
+Handlers send their errors with sendError, which puts them in
+their envelopes, as the puzzle package's handlers do.  Error
+responses that aren't JSON, such as the plain text ones the auth
+package and the standard library send for unauthorized requests
+and missing assets, are put into envelopes by the errorEnvelopes
+middleware, with their text as the error's values.
+
+*/
+
+// errorEnvelopes puts the error responses of a handler that
+// aren't JSON into envelopes.
+func errorEnvelopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &envelopingWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.status == 0 {
+			return
+		}
+		hs := w.Header()
+		language := hs.Get("Content-Language")
+		if language == "" {
+			language = r.Header.Get("Accept-Language")
+		}
+		for _, name := range []string{"Content-Type", "Content-Length", "X-Content-Type-Options"} {
+			hs.Del(name)
+		}
+		err := requestError(strings.TrimSpace(ew.text.String()))
+		if ew.status >= 500 {
+			err.Scope = puzzle.InternalScope
+		}
+		sendJSON(w, ew.status, err.Envelope(ew.status, language, hs.Get(puzzle.RequestIDHeader)))
+	})
+}
+
+// An envelopingWriter holds back error responses that aren't
+// JSON, so they can be put into envelopes.  Other responses are
+// passed on as they're written.
+type envelopingWriter struct {
+	http.ResponseWriter
+	status  int // of a held back response
+	started bool
+	text    bytes.Buffer
+}
+
+func (w *envelopingWriter) WriteHeader(status int) {
+	if w.started || w.status != 0 {
+		return
+	}
+	if status >= 400 {
+		mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if !strings.HasSuffix(mediaType, "json") {
+			w.status = status
+			return
+		}
+	}
+	if status >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *envelopingWriter) Write(b []byte) (int, error) {
+	if !w.started && w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		return w.text.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *envelopingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.status == 0 {
+		f.Flush()
+	}
+}
+
+func (w *envelopingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.started = true
+	return hj.Hijack()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestErrorEnvelopes(t *testing.T) {
+	requestLog.SetOutput(ioutil.Discard)
+	defer requestLog.SetOutput(os.Stderr)
+	session := newSession("test-envelopes")
+	srv := httptest.NewServer(logRequests(errorEnvelopes(auth.Middleware(auth.HeaderAuthenticator{UserHeader: "X-Test-User"},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			localizeRequest(w, r)
+			session.rootHandler(w, r)
+		})))))
+	defer srv.Close()
+	static := httptest.NewServer(errorEnvelopes(http.HandlerFunc(staticHandler)))
+	defer static.Close()
+	envelope := func(srv *httptest.Server, method, path, body string) (*http.Response, puzzle.Error) {
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Accept-Language", "de")
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("%s %s request error: %v", method, path, e)
+		}
+		defer r.Body.Close()
+		var err puzzle.Error
+		if e := json.NewDecoder(r.Body).Decode(&err); e != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("%s %s gave a %q error that isn't an envelope: %v", method, path, r.Header.Get("Content-Type"), e)
+		}
+		return r, err
+	}
+
+	for i, tc := range []struct {
+		srv                 *httptest.Server
+		method, path, body  string
+		status              int
+		code, field, prefix string
+	}{
+		// sent by the auth package as text
+		{srv, "POST", "/api/batch", "[]", http.StatusUnauthorized, "unauthorized", "", "Ungültige Anfrage: Authentication required"},
+		// sent by the standard library as text
+		{static, "GET", "/static/missing.js", "", http.StatusNotFound, "not_found", "", "Ungültige Anfrage: 404 page not found"},
+		{static, "POST", "/static/css/puzzle.css", "", http.StatusMethodNotAllowed, "method_not_allowed", "", "Ungültige Anfrage: "},
+		// sent by handlers
+		{srv, "PUT", "/api/squares/", "", http.StatusMethodNotAllowed, "method_not_allowed", "", "Ungültige Anfrage: Unknown API method"},
+		{srv, "GET", "/api/puzzles?stars=none", "", http.StatusBadRequest, "bad_request", "stars", "Ungültige Anfrage: "},
+		// sent by the puzzle package
+		{srv, "POST", "/api/assign/", `{"index":1000,"value":1}`, http.StatusBadRequest, "bad_request", "index", "Ungültiges Argument: Index (1000)"},
+	} {
+		r, err := envelope(tc.srv, tc.method, tc.path, tc.body)
+		if r.StatusCode != tc.status || err.Code != tc.code || err.Field != tc.field ||
+			len(err.Message) < len(tc.prefix) || err.Message[:len(tc.prefix)] != tc.prefix {
+			t.Errorf("Case %d: %s %s gave %d: %+v", i, tc.method, tc.path, r.StatusCode, err)
+		}
+		if tc.srv == srv && (err.RequestID == "" || err.RequestID != r.Header.Get(puzzle.RequestIDHeader)) {
+			t.Errorf("Case %d: envelope has request ID %q, response %q", i, err.RequestID, r.Header.Get(puzzle.RequestIDHeader))
+		}
+	}
+	if r, _ := envelope(srv, "PUT", "/api/squares/", ""); r.Header.Get("Allow") != "GET, POST" {
+		t.Errorf("Method error allows %q", r.Header.Get("Allow"))
+	}
+
+	// successful responses are left alone
+	if r, e := http.Get(static.URL + "/static/css/puzzle.css"); e != nil || r.StatusCode != http.StatusOK ||
+		r.Header.Get("Content-Type") == "application/json" {
+		t.Errorf("Asset request gave %v, %v", r, e)
+	}
+}
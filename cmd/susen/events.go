@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+
+Session events
+
+Each session can have watchers (WebSocket connections) that are
+sent the session's events as they happen, so that every tab or
+device showing the session stays in sync without polling.
+
+*/
+
+// Event types.
+const (
+	squaresEventType = "squares" // the full set of squares (after connect, reset, undo)
+	updateEventType  = "update"  // changed squares (after assign, mark)
+	solvedEventType  = "solved"  // the puzzle is solved
+	membersEventType = "members" // a session joined or left the board's room
+	expiredEventType = "expired" // the board's blitz attempt ran out of time
+	turnEventType    = "turn"    // the turn changed in the board's turn-based room
+	raceEventType    = "race"    // the board's race started, or a player finished it
+)
+
+// A sessionEvent is what's sent to watchers.  Squares and Errors
+// have the same meaning as they do in squares and update
+// responses, and Turn is the turns as the watched session sees
+// them.
+type sessionEvent struct {
+	Type     string          `json:"type"`
+	PuzzleID string          `json:"puzzleID"`
+	Squares  []puzzle.Square `json:"squares,omitempty"`
+	Errors   []puzzle.Error  `json:"errors,omitempty"`
+	Members  int             `json:"members,omitempty"`
+	Turn     *turnStatus     `json:"turn,omitempty"`
+}
+
+// broadcast sends an event to the watchers of all the sessions
+// using the session's board.
+func (session *susenSession) broadcast(ev sessionEvent) {
+	for _, member := range session.members {
+		member.notify(ev)
+	}
+}
+
+// notify sends an event to all of a session's watchers and
+// spectators (see spectate.go), dropping any whose connections
+// have failed or fallen behind.  The event is only queued for
+// each connection (see websocket.go), so a slow client can't
+// hold up the board.
+func (session *susenSession) notify(ev sessionEvent) {
+	ev.PuzzleID = session.puzzleID
+	payload, e := json.Marshal(ev)
+	if e != nil {
+		log.Printf("Can't encode session %v event: %v", session.sessionID, e)
+		return
+	}
+	session.watchMutex.Lock()
+	defer session.watchMutex.Unlock()
+	for _, watchers := range []map[*wsConn]bool{session.watchers, session.spectators} {
+		for c := range watchers {
+			if e := c.send(payload); e != nil {
+				log.Printf("Dropping session %v watcher: %v", session.sessionID, e)
+				c.close()
+				delete(watchers, c)
+			}
+		}
+	}
+}
+
+// notifySquares sends all the current squares to the board's
+// watchers, and notes that the board changed (see poll.go).
+func (session *susenSession) notifySquares() {
+	session.changed = time.Now()
+	current := session.steps[len(session.steps)-1]
+	session.broadcast(sessionEvent{Type: squaresEventType, Squares: session.guessed(current).Squares()})
+}
+
+// notifyUpdate sends an update to the board's watchers, followed
+// by a solved event if the update solved the puzzle, and notes
+// that the board changed.  Contest puzzles are never known to be
+// solved until they are submitted.
+func (session *susenSession) notifyUpdate(update puzzle.Update) {
+	session.changed = time.Now()
+	session.broadcast(sessionEvent{Type: updateEventType, Squares: update.Squares, Errors: update.Errors})
+	if update.Solved {
+		session.broadcast(sessionEvent{Type: solvedEventType})
+	}
+}
+
+// wsHandler turns the request into a WebSocket connection that
+// watches the session, starting with the current squares.
+func (session *susenSession) wsHandler(w http.ResponseWriter, r *http.Request) {
+	c, e := upgradeWebSocket(w, r)
+	if e != nil {
+		log.Printf("WebSocket upgrade failed: %v", e)
+		return
+	}
+	session.lockBoard()
+	current := session.guessed(session.steps[len(session.steps)-1])
+	session.unlockBoard()
+	if e := c.writeJSON(sessionEvent{
+		Type:     squaresEventType,
+		PuzzleID: session.puzzleID,
+		Squares:  current.Squares(),
+	}); e != nil {
+		c.close()
+		return
+	}
+	session.watchMutex.Lock()
+	if session.watchers == nil {
+		session.watchers = make(map[*wsConn]bool)
+	}
+	session.watchers[c] = true
+	session.watchMutex.Unlock()
+	log.Printf("Session %v has a new watcher.", session.sessionID)
+
+	c.serve()
+
+	session.watchMutex.Lock()
+	delete(session.watchers, c)
+	session.watchMutex.Unlock()
+	log.Printf("Session %v watcher disconnected.", session.sessionID)
+}
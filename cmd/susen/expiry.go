@@ -0,0 +1,245 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Session expiry
+
+Sessions pile up, both in the server (every browser that ever
+visited has one) and in the store (checkpoints that were never
+restored), so admins can expire the ones matching some criteria:
+
+- idleDays: sessions that haven't had a request for more than
+that many days (checkpoints from before the server kept track of
+this are never idle)
+
+- puzzleID: sessions playing that puzzle
+
+- beforeVersion: checkpoints stored with an encoding version
+before that one (see versions.go); live sessions are always at
+the current version
+
+A session is expired if it matches all the criteria given, and
+there has to be at least one.  Sessions sharing a room's board
+are left alone, for the sake of the other members.  Expired live
+sessions are forgotten, so their browsers start over, and
+expired checkpoints are removed.
+
+Expiry is done as a background job, which admins can watch:
+
+- POST /api/admin/expiry/ starts a job with the criteria in the
+body, and responds with the job
+
+- GET /api/admin/expiry/ lists the recent jobs, and
+GET /api/admin/expiry/<id> gives one of them, with its progress
+
+*/
+
+// An expiryCriteria says which sessions to expire.
+type expiryCriteria struct {
+	IdleDays      int    `json:"idleDays,omitempty"`
+	PuzzleID      string `json:"puzzleID,omitempty"`
+	BeforeVersion int    `json:"beforeVersion,omitempty"`
+}
+
+// An expiryJob is a run of session expiry, and how far it's got.
+type expiryJob struct {
+	ID       string         `json:"id"`
+	Criteria expiryCriteria `json:"criteria"`
+	Running  bool           `json:"running"`
+	Total    int            `json:"total"` // the sessions and checkpoints to check
+	Checked  int            `json:"checked"`
+	Expired  int            `json:"expired"`
+	Failed   int            `json:"failed,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+}
+
+// maxExpiryJobs is how many finished jobs are remembered.
+const maxExpiryJobs = 20
+
+// expiryMutex guards the jobs, all of whose changes are made
+// with it held.
+var (
+	expiryMutex   sync.Mutex
+	expiryJobs    = make(map[string]*expiryJob)
+	lastExpiryJob int
+)
+
+// touch notes that the session is having a request.
+func (session *susenSession) touch() {
+	session.infoMutex.Lock()
+	session.active = time.Now()
+	session.infoMutex.Unlock()
+}
+
+// matches tells whether the criteria match a session with the
+// given last activity, puzzle, and version.
+func (c expiryCriteria) matches(active time.Time, puzzleID string, version int, now time.Time) bool {
+	if c.IdleDays > 0 && (active.IsZero() || now.Sub(active) <= time.Duration(c.IdleDays)*24*time.Hour) {
+		return false
+	}
+	if c.PuzzleID != "" && puzzleID != c.PuzzleID {
+		return false
+	}
+	return c.BeforeVersion == 0 || version < c.BeforeVersion
+}
+
+// startExpiry starts an expiry job, and returns a copy of it.
+func startExpiry(c expiryCriteria) expiryJob {
+	expiryMutex.Lock()
+	defer expiryMutex.Unlock()
+	lastExpiryJob++
+	job := &expiryJob{ID: strconv.Itoa(lastExpiryJob), Criteria: c, Running: true, Started: time.Now()}
+	expiryJobs[job.ID] = job
+	for len(expiryJobs) > maxExpiryJobs {
+		oldest := ""
+		for id, other := range expiryJobs {
+			if !other.Running && (oldest == "" || other.Started.Before(expiryJobs[oldest].Started)) {
+				oldest = id
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		delete(expiryJobs, oldest)
+	}
+	go job.run()
+	return *job
+}
+
+// run does an expiry job: the live sessions, then the stored
+// checkpoints.
+func (job *expiryJob) run() {
+	now := job.Started
+	keys := make(map[*susenSession][]string)
+	sessionMutex.RLock()
+	for key, session := range sessions {
+		keys[session] = append(keys[session], key)
+	}
+	sessionMutex.RUnlock()
+	ids, e := store.Keys(checkpointKind)
+	expiryMutex.Lock()
+	job.Total = len(keys) + len(ids)
+	if e != nil {
+		job.Error = "Can't list checkpoints: " + e.Error()
+	}
+	expiryMutex.Unlock()
+
+	for session, sessionKeys := range keys {
+		session.lockBoard()
+		puzzleID, shared := session.puzzleID, len(session.members) > 1
+		session.unlockBoard()
+		session.infoMutex.Lock()
+		active := session.active
+		session.infoMutex.Unlock()
+		expired := !shared && job.Criteria.matches(active, puzzleID, checkpointVersion, now)
+		if expired {
+			sessionMutex.Lock()
+			for _, key := range sessionKeys {
+				if sessions[key] == session {
+					delete(sessions, key)
+				}
+			}
+			sessionMutex.Unlock()
+			session.stopSpectating()
+		}
+		job.checked(expired, false)
+	}
+	for _, id := range ids {
+		c, version, found, e := loadCheckpoint(id)
+		if !found && e == nil {
+			job.checked(false, false) // restored since it was listed
+			continue
+		}
+		if e == nil && job.Criteria.matches(c.Active, c.PuzzleID, version, now) {
+			if e = store.Delete(checkpointKind, id); e == nil {
+				job.checked(true, false)
+				continue
+			}
+		}
+		if e != nil {
+			log.Printf("Can't expire checkpoint of session %v: %v", id, e)
+		}
+		job.checked(false, e != nil)
+	}
+
+	expiryMutex.Lock()
+	finished := time.Now()
+	job.Running, job.Finished = false, &finished
+	log.Printf("Expiry job %s expired %d of %d sessions and checkpoints (%d failed).", job.ID, job.Expired, job.Total, job.Failed)
+	expiryMutex.Unlock()
+}
+
+// checked counts a session or checkpoint checked by the job.
+func (job *expiryJob) checked(expired, failed bool) {
+	expiryMutex.Lock()
+	defer expiryMutex.Unlock()
+	job.Checked++
+	if expired {
+		job.Expired++
+	}
+	if failed {
+		job.Failed++
+	}
+}
+
+// expiryHandler handles the session expiry endpoints, which are
+// only routed to for admins.
+func expiryHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/expiry"), "/")
+	switch r.Method {
+	case "POST":
+		if id != "" {
+			sendError(w, http.StatusNotFound, requestError("Expiry jobs can't be changed"))
+			return
+		}
+		var c expiryCriteria
+		if e := decodeRequest(w, r, &c); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid expiry criteria: "+e.Error()))
+			return
+		}
+		if c.IdleDays < 0 || c.BeforeVersion < 0 {
+			sendError(w, http.StatusBadRequest, requestError("Expiry criteria can't be negative"))
+			return
+		}
+		if c == (expiryCriteria{}) {
+			sendError(w, http.StatusBadRequest, requestError("Expiry needs at least one criterion"))
+			return
+		}
+		job := startExpiry(c)
+		log.Printf("User %v started expiry job %s (%+v).", auth.FromRequest(r).Key(), job.ID, c)
+		sendJSON(w, http.StatusAccepted, job)
+	case "GET":
+		expiryMutex.Lock()
+		defer expiryMutex.Unlock()
+		if id != "" {
+			job, ok := expiryJobs[id]
+			if !ok {
+				sendError(w, http.StatusNotFound, requestError("No expiry job "+id))
+				return
+			}
+			sendJSON(w, http.StatusOK, *job)
+			return
+		}
+		jobs := make([]expiryJob, 0, len(expiryJobs))
+		for _, job := range expiryJobs {
+			jobs = append(jobs, *job)
+		}
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.Before(jobs[j].Started) })
+		sendJSON(w, http.StatusOK, jobs)
+	default:
+		sendError(w, http.StatusMethodNotAllowed, requestError("Expiry jobs can only be started or read"))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExpiryCriteria(t *testing.T) {
+	now := time.Now()
+	old, recent := now.Add(-72*time.Hour), now.Add(-time.Hour)
+	cases := []struct {
+		c        expiryCriteria
+		active   time.Time
+		puzzleID string
+		version  int
+		expected bool
+	}{
+		{expiryCriteria{IdleDays: 2}, old, "1-star", 1, true},
+		{expiryCriteria{IdleDays: 2}, recent, "1-star", 1, false},
+		{expiryCriteria{IdleDays: 2}, time.Time{}, "1-star", 1, false},
+		{expiryCriteria{PuzzleID: "1-star"}, recent, "1-star", 1, true},
+		{expiryCriteria{PuzzleID: "1-star"}, recent, "2-star", 1, false},
+		{expiryCriteria{BeforeVersion: 2}, recent, "1-star", 1, true},
+		{expiryCriteria{BeforeVersion: 2}, recent, "1-star", 2, false},
+		{expiryCriteria{IdleDays: 2, PuzzleID: "1-star"}, old, "2-star", 1, false},
+		{expiryCriteria{IdleDays: 2, PuzzleID: "1-star"}, old, "1-star", 1, true},
+	}
+	for i, c := range cases {
+		if got := c.c.matches(c.active, c.puzzleID, c.version, now); got != c.expected {
+			t.Errorf("Case %d: criteria %+v matched %v", i, c.c, got)
+		}
+	}
+}
+
+func TestExpiryJob(t *testing.T) {
+	saved := store
+	store = monitoredStore{storage.NewMemory()}
+	defer func() { store = saved }()
+
+	idle, busy := newSession("test-expiry-idle"), newSession("test-expiry-busy")
+	idle.active = time.Now().Add(-10 * 24 * time.Hour)
+	sessionMutex.Lock()
+	sessions["test-expiry-idle"], sessions["test-expiry-busy"] = idle, busy
+	sessionMutex.Unlock()
+	defer func() {
+		sessionMutex.Lock()
+		delete(sessions, "test-expiry-idle")
+		delete(sessions, "test-expiry-busy")
+		sessionMutex.Unlock()
+	}()
+	store.Put(checkpointKind, "test-expiry-old",
+		sessionCheckpoint{Version: checkpointVersion, PuzzleID: defaultPuzzleID, Active: idle.active})
+	store.Put(checkpointKind, "test-expiry-new",
+		sessionCheckpoint{Version: checkpointVersion, PuzzleID: defaultPuzzleID, Active: time.Now()})
+
+	srv := helperUserServer(newSession("test-expiry-admin"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+	if status := helperUserRequest(t, srv, "sam", "POST", "/api/admin/expiry/", expiryCriteria{IdleDays: 7}, nil); status != http.StatusForbidden {
+		t.Errorf("Non-admin expiry gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/expiry/", expiryCriteria{}, nil); status != http.StatusBadRequest {
+		t.Errorf("Expiry without criteria gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/expiry/", expiryCriteria{IdleDays: -1}, nil); status != http.StatusBadRequest {
+		t.Errorf("Expiry with negative criteria gave status %d", status)
+	}
+
+	// the job runs in the background, and can be watched
+	started := startExpiry(expiryCriteria{IdleDays: 7})
+	var job expiryJob
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/expiry/"+started.ID, nil, &job); status != http.StatusOK {
+			t.Fatalf("Expiry job GET gave status %d", status)
+		}
+		if !job.Running || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Running || job.Checked != job.Total || job.Expired != 2 || job.Failed != 0 || job.Finished == nil {
+		t.Errorf("Expiry job finished as %+v", job)
+	}
+	sessionMutex.RLock()
+	_, idleKept := sessions["test-expiry-idle"]
+	_, busyKept := sessions["test-expiry-busy"]
+	sessionMutex.RUnlock()
+	if idleKept || !busyKept {
+		t.Errorf("After expiry, idle session kept %v, busy session kept %v", idleKept, busyKept)
+	}
+	if ids, _ := store.Keys(checkpointKind); len(ids) != 1 || ids[0] != "test-expiry-new" {
+		t.Errorf("After expiry, checkpoints are %v", ids)
+	}
+	var jobs []expiryJob
+	if helperUserRequest(t, srv, "root", "GET", "/api/admin/expiry/", nil, &jobs); len(jobs) == 0 || jobs[len(jobs)-1].ID != started.ID {
+		t.Errorf("Expiry jobs are %+v", jobs)
+	}
+	if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/expiry/none", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Unknown expiry job gave status %d", status)
+	}
+}
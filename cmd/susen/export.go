@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+/*
+
+Export
+
+GET /export/pdf gives the board as a printable PDF document (see
+puzzle.RenderPDF).  With solution=true, the document has a
+second page with the puzzle's solution.  Solving the puzzle is
+metered as analysis, and solutions aren't given for contest,
+unassisted, or running blitz boards, since they'd give the game
+away.
+
+*/
+
+// exportFileChars are the characters that can't go in export
+// file names.
+var exportFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// exportHandler handles GET /export/pdf.  The board is only
+// locked while the request is checked, and the document is made
+// from a snapshot of it.
+func (session *susenSession) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" || r.URL.Path != "/export/pdf" {
+		sendError(w, http.StatusNotFound, requestError("Unknown export: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	session.lockBoard()
+	defer session.unlockBoard()
+	opts := puzzle.PDFOptions{Title: "Susen puzzle " + session.puzzleID, Givens: session.values}
+	if sharedPuzzleID(session.puzzleID) {
+		opts.Title = "Susen shared position"
+	}
+	withSolution, _ := strconv.ParseBool(r.URL.Query().Get("solution"))
+	if withSolution {
+		if session.contest || session.unassisted || (session.blitz != nil && !session.blitz.over) {
+			sendError(w, http.StatusForbidden, requestError("Solutions aren't given for this board"))
+			return
+		}
+		if !takeQuota(w, quotaKey(r, session), quotaAnalyze) || refuseSolverFault(w) {
+			return
+		}
+	}
+	puzzleID, sessionID := session.puzzleID, session.sessionID
+	session.unlocked(func(step puzzle.Puzzle) {
+		if withSolution {
+			p, _ := puzzle.New(opts.Givens) // the board was started with them
+			solutions := p.Solutions()
+			if len(solutions) == 0 {
+				sendError(w, http.StatusConflict, requestError("Puzzle "+puzzleID+" has no solution"))
+				return
+			}
+			opts.Solution = solutions[0].Values
+		}
+		name := exportFileChars.ReplaceAllString(puzzleID, "-")
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `inline; filename="susen-`+name+`.pdf"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if e := puzzle.RenderPDF(w, step, opts); e != nil {
+			log.Printf("Can't send PDF of session %v: %v", sessionID, e)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportPDF(t *testing.T) {
+	session := newSession("test-export-pdf")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		r, e := http.Get(srv.URL + path)
+		if e != nil {
+			t.Fatalf("Export request error: %v", e)
+		}
+		defer r.Body.Close()
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.StatusCode == http.StatusOK && r.Header.Get("Content-Type") != "application/pdf" {
+			t.Errorf("Export content type is %q", r.Header.Get("Content-Type"))
+		}
+		return r.StatusCode, string(b)
+	}
+	status, pdf := get("/export/pdf")
+	if status != http.StatusOK || !strings.HasPrefix(pdf, "%PDF-") || strings.Count(pdf, "/Type /Page ") != 1 {
+		t.Errorf("PDF export gave status %d, %d pages", status, strings.Count(pdf, "/Type /Page "))
+	}
+	if !strings.Contains(pdf, "(Susen puzzle "+session.puzzleID+") Tj") {
+		t.Errorf("PDF export doesn't have the puzzle title")
+	}
+	if status, pdf = get("/export/pdf?solution=true"); status != http.StatusOK || strings.Count(pdf, "/Type /Page ") != 2 {
+		t.Errorf("PDF export with solution gave status %d, %d pages", status, strings.Count(pdf, "/Type /Page "))
+	}
+	if status, _ := get("/export/png"); status != http.StatusNotFound {
+		t.Errorf("Unknown export gave status %d", status)
+	}
+
+	// contest boards don't get solutions
+	r, e := http.Get(srv.URL + "/reset/" + session.puzzleID + "?mode=contest")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if status, _ := get("/export/pdf?solution=true"); status != http.StatusForbidden {
+		t.Errorf("PDF export of a contest solution gave status %d", status)
+	}
+	if status, _ := get("/export/pdf"); status != http.StatusOK {
+		t.Errorf("PDF export of a contest board gave status %d", status)
+	}
+}
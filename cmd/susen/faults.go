@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+
+Fault injection
+
+To exercise the server's resilience in staging (alerts, degraded
+mode, and clients' handling of failures), admins can inject
+artificial latency and failures into the store and the solver: a
+fraction of operations fail, and every operation is slowed down
+by the given number of milliseconds.  Solver failures are sent
+as Service Unavailable responses to the requests that need the
+solver (ratings, hints, and exported solutions), and store
+failures look like failures of the store itself.
+
+Fault injection is only possible when SUSEN_FAULT_INJECTION is
+set in the environment, so it can't be turned on in production
+by mistake.  Admins set it through /api/admin/faults/, with JSON
+that replaces the injected faults, for example:
+
+	{"storeLatency": 200, "storeFailureRate": 0.1, "solverFailureRate": 0.5}
+
+and {} stops injecting faults.
+
+*/
+
+// faultConfig describes the faults to inject.
+type faultConfig struct {
+	StoreLatency      int     `json:"storeLatency"`      // milliseconds
+	StoreFailureRate  float64 `json:"storeFailureRate"`  // from 0 to 1
+	SolverLatency     int     `json:"solverLatency"`     // milliseconds
+	SolverFailureRate float64 `json:"solverFailureRate"` // from 0 to 1
+}
+
+var (
+	faultMutex sync.Mutex
+	faults     faultConfig
+	faultRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// faultInjectionAllowed tells whether faults can be injected.
+func faultInjectionAllowed() bool {
+	return os.Getenv("SUSEN_FAULT_INJECTION") != ""
+}
+
+// check checks that a fault configuration makes sense.
+func (c faultConfig) check() error {
+	if c.StoreLatency < 0 || c.SolverLatency < 0 {
+		return fmt.Errorf("Invalid fault latency")
+	}
+	if c.StoreFailureRate < 0 || c.StoreFailureRate > 1 || c.SolverFailureRate < 0 || c.SolverFailureRate > 1 {
+		return fmt.Errorf("Invalid fault failure rate")
+	}
+	return nil
+}
+
+// injectFault waits for the injected latency, then returns an
+// error if the operation should fail.
+func injectFault(what string, latency func(faultConfig) int, rate func(faultConfig) float64) error {
+	faultMutex.Lock()
+	delay := time.Duration(latency(faults)) * time.Millisecond
+	fail := rate(faults) > 0 && faultRand.Float64() < rate(faults)
+	faultMutex.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if fail {
+		return fmt.Errorf("Injected fault: %s unavailable", what)
+	}
+	return nil
+}
+
+// storeFault injects a store fault.
+func storeFault() error {
+	return injectFault("store",
+		func(c faultConfig) int { return c.StoreLatency },
+		func(c faultConfig) float64 { return c.StoreFailureRate })
+}
+
+// solverFault injects a solver fault.
+func solverFault() error {
+	return injectFault("solver",
+		func(c faultConfig) int { return c.SolverLatency },
+		func(c faultConfig) float64 { return c.SolverFailureRate })
+}
+
+// refuseSolverFault sends the response for an injected solver
+// failure, and tells whether it did.
+func refuseSolverFault(w http.ResponseWriter) bool {
+	if e := solverFault(); e != nil {
+		sendError(w, http.StatusServiceUnavailable, requestError(e.Error()))
+		return true
+	}
+	return false
+}
+
+// A faultyStore is a Store with injected faults.
+type faultyStore struct {
+	storage.Store
+}
+
+func (s faultyStore) Get(kind, key string, v interface{}) (bool, error) {
+	if e := storeFault(); e != nil {
+		return false, e
+	}
+	return s.Store.Get(kind, key, v)
+}
+
+func (s faultyStore) Put(kind, key string, v interface{}) error {
+	if e := storeFault(); e != nil {
+		return e
+	}
+	return s.Store.Put(kind, key, v)
+}
+
+func (s faultyStore) Delete(kind, key string) error {
+	if e := storeFault(); e != nil {
+		return e
+	}
+	return s.Store.Delete(kind, key)
+}
+
+func (s faultyStore) Keys(kind string) ([]string, error) {
+	if e := storeFault(); e != nil {
+		return nil, e
+	}
+	return s.Store.Keys(kind)
+}
+
+func (s faultyStore) Swap(kind, key string, old, v interface{}) (bool, error) {
+	if e := storeFault(); e != nil {
+		return false, e
+	}
+	return storage.Swap(s.Store, kind, key, old, v)
+}
+
+// faultsHandler handles the fault injection endpoint, which is
+// only routed to for admins:
+//
+// - GET /api/admin/faults/ gives the injected faults
+//
+// - POST /api/admin/faults/ replaces them, taking a JSON
+// faultConfig in the request body
+func faultsHandler(w http.ResponseWriter, r *http.Request) {
+	if !faultInjectionAllowed() {
+		sendError(w, http.StatusForbidden, requestError("Fault injection isn't allowed on this server"))
+		return
+	}
+	if r.Method == "POST" {
+		var c faultConfig
+		e := decodeRequest(w, r, &c)
+		if e == nil {
+			e = c.check()
+		}
+		if e != nil {
+			sendError(w, requestStatus(e), requestError(e.Error()))
+			return
+		}
+		faultMutex.Lock()
+		faults = c
+		faultMutex.Unlock()
+		log.Printf("Injected faults changed: %+v", c)
+	}
+	faultMutex.Lock()
+	c := faults
+	faultMutex.Unlock()
+	sendJSON(w, http.StatusOK, c)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFaultInjection(t *testing.T) {
+	srv := helperUserServer(newSession("test-faults"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+	defer func() {
+		faultMutex.Lock()
+		faults = faultConfig{}
+		faultMutex.Unlock()
+	}()
+
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/faults/", faultConfig{StoreFailureRate: 1}, nil); status != http.StatusForbidden {
+		t.Errorf("Fault injection without the environment gave status %d", status)
+	}
+	t.Setenv("SUSEN_FAULT_INJECTION", "1")
+	if status := helperUserRequest(t, srv, "sam", "GET", "/api/admin/faults/", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Non-admin fault request gave status %d", status)
+	}
+	for _, bad := range []faultConfig{{StoreLatency: -1}, {SolverFailureRate: 1.5}} {
+		if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/faults/", bad, nil); status != http.StatusBadRequest {
+			t.Errorf("Invalid faults %+v gave status %d", bad, status)
+		}
+	}
+
+	// failures
+	var got faultConfig
+	set := faultConfig{StoreFailureRate: 1, SolverFailureRate: 1}
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/faults/", set, &got); status != http.StatusOK || got != set {
+		t.Fatalf("Setting faults gave status %d, %+v", status, got)
+	}
+	failures := storeFailureEvents.count(time.Now(), time.Minute)
+	if e := store.Put("test-faults", "key", "value"); e == nil {
+		t.Errorf("Store didn't fail")
+	}
+	if n := storeFailureEvents.count(time.Now(), time.Minute); n != failures+1 {
+		t.Errorf("Store failures went from %d to %d", failures, n)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/api/rating/", nil, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("Rating with solver failures gave status %d", status)
+	}
+
+	// latency only
+	set = faultConfig{SolverLatency: 30}
+	helperUserRequest(t, srv, "root", "POST", "/api/admin/faults/", set, nil)
+	start := time.Now()
+	if status := helperUserRequest(t, srv, "", "GET", "/api/rating/", nil, nil); status != http.StatusOK {
+		t.Errorf("Rating with solver latency gave status %d", status)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Rating with solver latency took %v", elapsed)
+	}
+	if e := store.Put("test-faults", "key", "value"); e != nil {
+		t.Errorf("Store failed without faults: %v", e)
+	}
+	store.Delete("test-faults", "key")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+)
+
+/*
+
+Fill progress
+
+Responses about a board's squares say how far along it is (see
+puzzle.FillProgress): how many squares are filled, how many
+remain, and what percentage of those are forced by logic at the
+current position.  Updates carry it in their progress field.
+Squares responses are lists, so they carry it in the
+progressHeader response header instead, as they do the board's
+analysis (see analysis.go).
+
+*/
+
+// progressHeader is the response header with the fill progress
+// of a board.
+const progressHeader = "X-Board-Progress"
+
+// attachProgress adds the fill progress of a step of the board to
+// a response.
+func attachProgress(w http.ResponseWriter, step puzzle.Puzzle) {
+	bytes, e := json.Marshal(puzzle.MeasureFill(step))
+	if e != nil {
+		log.Printf("Failed to encode fill progress: %v", e)
+		return
+	}
+	w.Header().Set(progressHeader, string(bytes))
+}
+
+// A progressPuzzle gives the fill progress of a step in the
+// updates of its assignments.  The step is the board's own, not
+// a view of it.
+type progressPuzzle struct {
+	puzzle.Puzzle
+	step puzzle.Puzzle
+}
+
+// Assign adds the step's fill progress after the assignment.
+func (p progressPuzzle) Assign(choice puzzle.Choice) (puzzle.Update, error) {
+	update, e := p.Puzzle.Assign(choice)
+	if e == nil {
+		progress := puzzle.MeasureFill(p.step)
+		update.Progress = &progress
+	}
+	return update, e
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFillProgress(t *testing.T) {
+	session := newSession("test-fill-progress")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	r, e := http.Get(srv.URL + "/api/squares/")
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	r.Body.Close()
+	var before puzzle.FillProgress
+	if e := json.Unmarshal([]byte(r.Header.Get(progressHeader)), &before); e != nil {
+		t.Fatalf("Squares progress header %q: %v", r.Header.Get(progressHeader), e)
+	}
+	if before.Filled+before.Remaining != 81 || before.Filled == 0 || before.Forced <= 0 || before.Forced > 100 {
+		t.Errorf("Squares progress was %+v", before)
+	}
+
+	var choice puzzle.Choice
+	for _, s := range session.steps[0].Squares() {
+		if s.Aval == 0 && len(s.Pvals) == 1 {
+			choice = puzzle.Choice{Index: s.Index, Value: s.Pvals[0]}
+			break
+		}
+	}
+	var update puzzle.Update
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", choice, &update); status != http.StatusOK {
+		t.Fatalf("Assign of %+v gave status %d", choice, status)
+	}
+	if p := update.Progress; p == nil || p.Filled != before.Filled+1 || p.Remaining != before.Remaining-1 {
+		t.Errorf("Assign progress was %+v, before %+v", p, before)
+	}
+}
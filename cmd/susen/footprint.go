@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Session footprint
+
+Every live session is kept in memory, and each board keeps a copy
+of its puzzle for every step of its history, so long games are
+what the server's memory goes on.  To help tune expiry (see
+expiry.go) and history limits, admins can see how big the
+sessions are:
+
+- GET /api/admin/footprint/ gives the number of live sessions and
+boards, their estimated total size, histograms of board sizes
+and history lengths, and how long restoring checkpoints has
+taken (which is mostly replaying their moves)
+
+- GET /api/admin/footprint/top?n=N lists the N heaviest sessions
+(10 if there's no n), heaviest first
+
+Sizes are estimates, from the number of squares in each step of
+a board's history, and a session's size includes its slots (see
+slots.go).  Boards shared by a room are only counted once in the
+totals.
+
+*/
+
+// estimatedSquareBytes is roughly how much memory a square takes
+// in one step of a board's history, including its share of the
+// puzzle's groups.
+const estimatedSquareBytes = 128
+
+// estimatedStepBytes is roughly how much memory a step takes
+// apart from its squares.
+const estimatedStepBytes = 256
+
+// A histogramBucket counts the values up to a bound (and above
+// the previous bucket's bound).  The last bucket has no bound.
+type histogramBucket struct {
+	UpTo  int `json:"upTo,omitempty"`
+	Count int `json:"count"`
+}
+
+// histogram counts values in buckets whose bounds double from
+// the first one, up to the last one, and has a last bucket for
+// the values above that.
+func histogram(values []int, first, last int) []histogramBucket {
+	var buckets []histogramBucket
+	for bound := first; bound <= last; bound *= 2 {
+		buckets = append(buckets, histogramBucket{UpTo: bound})
+	}
+	buckets = append(buckets, histogramBucket{})
+	for _, v := range values {
+		i := 0
+		for i < len(buckets)-1 && v > buckets[i].UpTo {
+			i++
+		}
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// A replaySummary says how long restoring checkpoints has taken.
+type replaySummary struct {
+	Count   int     `json:"count"`
+	Moves   int     `json:"moves"`
+	MeanMs  float64 `json:"meanMs"`
+	MaxMs   float64 `json:"maxMs"`
+	totalMs float64
+}
+
+var (
+	replayMutex sync.Mutex
+	replays     replaySummary
+)
+
+// noteReplay records the time taken to restore a checkpoint with
+// the given number of moves.
+func noteReplay(moves int, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+	replays.Count++
+	replays.Moves += moves
+	replays.totalMs += ms
+	replays.MeanMs = replays.totalMs / float64(replays.Count)
+	if ms > replays.MaxMs {
+		replays.MaxMs = ms
+	}
+}
+
+// footprint estimates the memory taken by a board, and
+// returns it with the length of the board's history.
+func (board *susenBoard) footprint() (int, int) {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+	squares := len(board.values) - 1
+	if len(board.steps) > 0 {
+		squares = len(board.steps[0].State().Values)
+	}
+	return len(board.steps)*(squares*estimatedSquareBytes+estimatedStepBytes) + 8*len(board.values), len(board.steps)
+}
+
+// A sessionFootprint is how much memory a session takes.
+type sessionFootprint struct {
+	SessionID string `json:"sessionID"`
+	User      string `json:"user,omitempty"`
+	PuzzleID  string `json:"puzzleID"`
+	Steps     int    `json:"steps"`
+	Slots     int    `json:"slots,omitempty"`
+	Bytes     int    `json:"bytes"`
+}
+
+// A footprintReport sums up the memory taken by the live
+// sessions.
+type footprintReport struct {
+	Sessions int               `json:"sessions"`
+	Boards   int               `json:"boards"`
+	Bytes    int               `json:"bytes"`
+	Sizes    []histogramBucket `json:"sizes"`   // of boards, in bytes
+	History  []histogramBucket `json:"history"` // of boards, in steps
+	Replays  replaySummary     `json:"replays"`
+}
+
+// liveSessions returns the distinct live sessions.
+func liveSessions() []*susenSession {
+	seen := make(map[*susenSession]bool)
+	var live []*susenSession
+	sessionMutex.RLock()
+	for _, session := range sessions {
+		if !seen[session] {
+			seen[session] = true
+			live = append(live, session)
+		}
+	}
+	sessionMutex.RUnlock()
+	return live
+}
+
+// footprints measures the live sessions, and their boards (with
+// each board only once).
+func footprints() ([]sessionFootprint, map[*susenBoard][2]int) {
+	boards := make(map[*susenBoard][2]int)
+	measure := func(session *susenSession) (int, int) {
+		board := session.currentBoard()
+		if m, ok := boards[board]; ok {
+			return m[0], m[1]
+		}
+		bytes, steps := board.footprint()
+		boards[board] = [2]int{bytes, steps}
+		return bytes, steps
+	}
+	var result []sessionFootprint
+	for _, session := range liveSessions() {
+		f := sessionFootprint{SessionID: session.sessionID}
+		f.Bytes, f.Steps = measure(session)
+		session.lockBoard()
+		f.PuzzleID = session.puzzleID
+		session.unlockBoard()
+		session.infoMutex.Lock()
+		if session.user != nil {
+			f.User = session.user.Key()
+		}
+		slots := make([]*susenSession, 0, len(session.slots))
+		for _, slot := range session.slots {
+			slots = append(slots, slot)
+		}
+		session.infoMutex.Unlock()
+		for _, slot := range slots {
+			bytes, _ := measure(slot)
+			f.Bytes += bytes
+			f.Slots++
+		}
+		result = append(result, f)
+	}
+	return result, boards
+}
+
+// footprintHandler handles the session footprint endpoints,
+// which are only routed to for admins.
+func footprintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Footprints can only be read"))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/footprint"), "/")
+	sessionFootprints, boards := footprints()
+	switch path {
+	case "":
+		report := footprintReport{Sessions: len(sessionFootprints), Boards: len(boards)}
+		var sizes, lengths []int
+		for _, m := range boards {
+			report.Bytes += m[0]
+			sizes, lengths = append(sizes, m[0]), append(lengths, m[1])
+		}
+		report.Sizes = histogram(sizes, 16<<10, 16<<20)
+		report.History = histogram(lengths, 1, 1024)
+		replayMutex.Lock()
+		report.Replays = replays
+		replayMutex.Unlock()
+		sendJSON(w, http.StatusOK, report)
+	case "top":
+		n := 10
+		if s := r.URL.Query().Get("n"); s != "" {
+			var e error
+			if n, e = strconv.Atoi(s); e != nil || n < 1 {
+				sendError(w, http.StatusBadRequest, requestError("Invalid n parameter: "+s))
+				return
+			}
+		}
+		sort.Slice(sessionFootprints, func(i, j int) bool {
+			if sessionFootprints[i].Bytes != sessionFootprints[j].Bytes {
+				return sessionFootprints[i].Bytes > sessionFootprints[j].Bytes
+			}
+			return sessionFootprints[i].SessionID < sessionFootprints[j].SessionID
+		})
+		if len(sessionFootprints) > n {
+			sessionFootprints = sessionFootprints[:n]
+		}
+		if sessionFootprints == nil {
+			sessionFootprints = []sessionFootprint{}
+		}
+		sendJSON(w, http.StatusOK, sessionFootprints)
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown footprint report: "+path))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	buckets := histogram([]int{1, 2, 3, 4, 5, 100}, 1, 4)
+	expected := []histogramBucket{{1, 1}, {2, 1}, {4, 2}, {0, 2}}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("Histogram is %v", buckets)
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	light, heavy := newSession("test-footprint-light"), newSession("test-footprint-heavy")
+	for i := 0; i < 3; i++ {
+		heavy.steps = append(heavy.steps, heavy.steps[0].Copy())
+	}
+	sessionMutex.Lock()
+	sessions["test-footprint-light"], sessions["test-footprint-heavy"] = light, heavy
+	sessionMutex.Unlock()
+	defer func() {
+		sessionMutex.Lock()
+		delete(sessions, "test-footprint-light")
+		delete(sessions, "test-footprint-heavy")
+		sessionMutex.Unlock()
+	}()
+	noteReplay(4, 2*time.Millisecond)
+
+	srv := helperUserServer(newSession("test-footprint-admin"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+	if status := helperUserRequest(t, srv, "sam", "GET", "/api/admin/footprint/", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Non-admin footprint gave status %d", status)
+	}
+	var report footprintReport
+	if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/footprint/", nil, &report); status != http.StatusOK {
+		t.Fatalf("Footprint gave status %d", status)
+	}
+	if report.Sessions < 2 || report.Boards < 2 || report.Bytes == 0 || report.Replays.Count == 0 || report.Replays.MaxMs < 2 {
+		t.Errorf("Footprint report is %+v", report)
+	}
+	counted := 0
+	for _, b := range report.History {
+		counted += b.Count
+	}
+	if counted != report.Boards {
+		t.Errorf("History histogram counts %d of %d boards", counted, report.Boards)
+	}
+
+	var top []sessionFootprint
+	if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/footprint/top?n=1", nil, &top); status != http.StatusOK ||
+		len(top) != 1 || top[0].SessionID != "test-footprint-heavy" || top[0].Steps != 4 {
+		t.Errorf("Top footprint gave status %d, %+v", status, top)
+	}
+	lightBytes, _ := light.footprint()
+	if top[0].Bytes <= lightBytes {
+		t.Errorf("Heavy session takes %d bytes, light one %d", top[0].Bytes, lightBytes)
+	}
+	if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/footprint/top?n=0", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Top footprint with n=0 gave status %d", status)
+	}
+}
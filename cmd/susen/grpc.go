@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+gRPC service
+
+Clients that aren't browsers, such as mobile apps and bots, can
+use the puzzle API over gRPC (see susen.proto) instead of the
+cookie-based HTTP API.  The service listens on a port of its own
+(see server.go), and only does unary calls, without compression.
+This is the small part of the gRPC protocol that it needs: each
+request and response is a single length-prefixed message, and
+the status of the call is in the response trailers.
+
+A call's session is named by the susen-session metadata token.
+Calls without a token (or with one the server didn't make)
+start a new session, whose token comes back in the response
+metadata.  Users are identified just as they are over HTTP, from
+the same headers, which gRPC clients send as metadata.
+
+Each call is served as the corresponding API request, so all of
+the HTTP API's policies (hints, quotas, turns, blitzes and so on)
+apply to it, and every HTTP error status has a gRPC status code.
+
+*/
+
+// grpcService is the service name in gRPC paths, and
+// grpcSessionHeader is the metadata key of the session token,
+// whose sessions have IDs with grpcSessionPrefix.  Requests can
+// be at most grpcMaxMessage bytes.
+const (
+	grpcService       = "/susen.v1.Susen/"
+	grpcSessionHeader = "Susen-Session"
+	grpcSessionPrefix = "grpc-"
+	grpcMaxMessage    = 1 << 16
+)
+
+// gRPC status codes.
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// A grpcError is the failure of a call.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e grpcError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.message)
+}
+
+// grpcCode returns the gRPC status code for an HTTP status.
+func grpcCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusServiceUnavailable:
+		return grpcUnavailable
+	}
+	if status >= 500 {
+		return grpcInternal
+	}
+	return grpcFailedPrecondition
+}
+
+// grpcMethods are the service's methods, by name.  Each gets the
+// call's session and request message, and returns its response
+// message.
+var grpcMethods = map[string]func(*susenSession, *http.Request, []pbField) (pbMessage, error){
+	"GetSquares": grpcGetSquares,
+	"Assign":     grpcAssign,
+	"Back":       grpcBack,
+	"Reset":      grpcReset,
+	"Hint":       grpcHint,
+	"Solve":      grpcSolve,
+}
+
+// grpcHandler returns the handler of gRPC calls, which are
+// logged and rate-limited just like HTTP requests.
+func grpcHandler(a auth.Authenticator) http.Handler {
+	return logRequests(rateLimited(auth.Middleware(a, http.HandlerFunc(serveGRPC))))
+}
+
+// startGRPC starts the gRPC server of a configuration, if it has
+// one, and has it shut down along with the HTTP server.
+func startGRPC(conf serverConfig, srv *http.Server, a auth.Authenticator) {
+	gsrv := conf.grpcServer(grpcHandler(a))
+	if gsrv == nil {
+		return
+	}
+	srv.RegisterOnShutdown(func() {
+		if e := gsrv.Shutdown(context.Background()); e != nil {
+			log.Printf("gRPC calls didn't finish: %v", e)
+		}
+	})
+	go func() {
+		var err error
+		if conf.tls() {
+			log.Printf("Serving gRPC with TLS on %s...", gsrv.Addr)
+			err = gsrv.ListenAndServeTLS(conf.TLSCert, conf.TLSKey)
+		} else {
+			log.Printf("Serving gRPC on %s...", gsrv.Addr)
+			err = gsrv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("gRPC listener failure: ", err)
+		}
+	}()
+}
+
+// serveGRPC serves a gRPC call.  Requests that aren't gRPC calls
+// get HTTP errors, as the protocol says they should.
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		sendError(w, http.StatusUnsupportedMediaType, requestError("Not a gRPC call"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	method, ok := grpcMethods[strings.TrimPrefix(r.URL.Path, grpcService)]
+	if !ok || !strings.HasPrefix(r.URL.Path, grpcService) {
+		sendGRPC(w, nil, grpcError{grpcUnimplemented, "Unknown method " + r.URL.Path})
+		return
+	}
+	msg, err := readGRPC(r.Body)
+	if err != nil {
+		sendGRPC(w, nil, err)
+		return
+	}
+	fields, e := pbFields(msg)
+	if e != nil {
+		sendGRPC(w, nil, grpcError{grpcInvalidArgument, "Invalid request message: " + e.Error()})
+		return
+	}
+	requestEvents.add(time.Now())
+	session, err := grpcSession(w, r)
+	if err != nil {
+		sendGRPC(w, nil, err)
+		return
+	}
+	if user := auth.FromRequest(r); user != nil {
+		us, held, e := userSession(session, user)
+		if us == nil {
+			sendGRPC(w, nil, leaseError(w, held, e))
+			return
+		}
+		session = us
+		session.setUser(user)
+	}
+	noteSession(r, session.sessionID)
+	reply, e := method(session, r, fields)
+	if e != nil {
+		sendGRPC(w, nil, e)
+		return
+	}
+	sendGRPC(w, reply, nil)
+}
+
+// grpcSession returns the session named by a call's token,
+// making a new one if it doesn't have one, or the error for a
+// session this server can't claim (see leases.go).
+func grpcSession(w http.ResponseWriter, r *http.Request) (*susenSession, error) {
+	token := r.Header.Get(grpcSessionHeader)
+	if !strings.HasPrefix(token, grpcSessionPrefix) {
+		token = grpcSessionPrefix + strconv.FormatInt(int64(time.Now().Sub(startTime)), 36)
+	}
+	w.Header().Set(grpcSessionHeader, token)
+	session, held, e := claimSession(token)
+	if session == nil {
+		return nil, leaseError(w, held, e)
+	}
+	return session, nil
+}
+
+// leaseError returns the error for a call whose session this
+// server can't claim, because another server holds its lease or
+// because of an error taking it.
+func leaseError(w http.ResponseWriter, held *sessionLease, e error) grpcError {
+	if held == nil {
+		return grpcError{grpcUnavailable, "Sessions are unavailable"}
+	}
+	w.Header().Set(sessionHolderHeader, held.Holder)
+	return grpcError{grpcUnavailable, "The session is in use on another server"}
+}
+
+// readGRPC reads the request message of a call.
+func readGRPC(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, e := io.ReadFull(body, prefix[:]); e != nil {
+		return nil, grpcError{grpcInvalidArgument, "Missing request message"}
+	}
+	if prefix[0] != 0 {
+		return nil, grpcError{grpcUnimplemented, "Compressed messages aren't supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcError{grpcResourceExhausted, "Request message is too large"}
+	}
+	msg := make([]byte, size)
+	if _, e := io.ReadFull(body, msg); e != nil {
+		return nil, grpcError{grpcInvalidArgument, "Short request message"}
+	}
+	return msg, nil
+}
+
+// sendGRPC sends the response of a call: its message, if it
+// succeeded, and its status.
+func sendGRPC(w http.ResponseWriter, reply pbMessage, err error) {
+	w.WriteHeader(http.StatusOK)
+	code, message := grpcOK, ""
+	if err != nil {
+		ge, ok := err.(grpcError)
+		if !ok {
+			ge = grpcError{grpcInternal, err.Error()}
+		}
+		code, message = ge.code, ge.message
+	} else {
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(reply)))
+		w.Write(prefix[:])
+		w.Write(reply)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(message))
+	}
+}
+
+// grpcEscape percent-encodes a status message, as the protocol
+// requires.
+func grpcEscape(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// A grpcRecorder records the response to an API request made for
+// a call.
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *grpcRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *grpcRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *grpcRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// apiCall serves a call as an API request to the session, with
+// the given method, path, and JSON body (if any), decoding the
+// JSON response into out (if it isn't nil).  Error responses
+// are returned as call failures.
+func (session *susenSession) apiCall(r *http.Request, method, path string, in, out interface{}) error {
+	var body io.Reader = http.NoBody
+	if in != nil {
+		bs, e := json.Marshal(in)
+		if e != nil {
+			return grpcError{grpcInternal, "Can't encode request: " + e.Error()}
+		}
+		body = bytes.NewReader(bs)
+	}
+	req, e := http.NewRequest(method, path, body)
+	if e != nil {
+		return grpcError{grpcInternal, "Can't make request: " + e.Error()}
+	}
+	req = req.WithContext(r.Context())
+	req.RemoteAddr = r.RemoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	for _, name := range []string{puzzle.RequestIDHeader, "Accept-Language"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	rec := &grpcRecorder{header: make(http.Header)}
+	session.rootHandler(rec, req)
+	if rec.status >= 400 {
+		var err puzzle.Error
+		if json.Unmarshal(rec.body.Bytes(), &err) != nil || err.Message == "" {
+			err.Message = http.StatusText(rec.status)
+		}
+		return grpcError{grpcCode(rec.status), err.Message}
+	}
+	if out != nil {
+		if e := json.Unmarshal(rec.body.Bytes(), out); e != nil {
+			return grpcError{grpcInternal, "Can't decode response: " + e.Error()}
+		}
+	}
+	return nil
+}
+
+func grpcGetSquares(session *susenSession, r *http.Request, _ []pbField) (pbMessage, error) {
+	return session.grpcSquares(r, "/api/v1/")
+}
+
+func grpcBack(session *susenSession, r *http.Request, _ []pbField) (pbMessage, error) {
+	return session.grpcSquares(r, "/api/v1/back/")
+}
+
+func grpcAssign(session *susenSession, r *http.Request, fields []pbField) (pbMessage, error) {
+	choice := pbChoiceFrom(fields)
+	var update puzzle.Update
+	if e := session.apiCall(r, "POST", "/api/v1/assign/", choice, &update); e != nil {
+		return nil, e
+	}
+	var m pbMessage
+	for _, s := range update.Squares {
+		m.message(1, pbSquare(s))
+	}
+	m.bool(2, update.Solved)
+	for _, e := range update.Errors {
+		m.string(3, e.Error())
+	}
+	return m, nil
+}
+
+func grpcReset(session *susenSession, r *http.Request, fields []pbField) (pbMessage, error) {
+	var puzzleID, mode string
+	for _, f := range fields {
+		switch {
+		case f.number == 1 && f.wire == pbBytes:
+			puzzleID = string(f.data)
+		case f.number == 2 && f.wire == pbBytes:
+			mode = string(f.data)
+		}
+	}
+	if e := session.apiCall(r, "GET", "/reset/"+url.PathEscape(puzzleID)+"?mode="+url.QueryEscape(mode), nil, nil); e != nil {
+		return nil, e
+	}
+	return session.grpcSquares(r, "/api/v1/")
+}
+
+func grpcHint(session *susenSession, r *http.Request, _ []pbField) (pbMessage, error) {
+	var hint hintResponse
+	if e := session.apiCall(r, "GET", "/api/v1/hint/", nil, &hint); e != nil {
+		return nil, e
+	}
+	var m pbMessage
+	m.message(1, pbChoice(hint.Hint.Choice))
+	m.string(2, hint.Hint.Technique)
+	m.int(3, hint.Remaining)
+	m.double(4, hint.Cooldown)
+	return m, nil
+}
+
+func grpcSolve(session *susenSession, r *http.Request, _ []pbField) (pbMessage, error) {
+	var solutions []puzzle.Solution
+	if e := session.apiCall(r, "GET", "/api/v1/solutions/", nil, &solutions); e != nil {
+		return nil, e
+	}
+	var m pbMessage
+	for _, s := range solutions {
+		var sm pbMessage
+		sm.ints(1, s.Values)
+		for _, c := range s.Choices {
+			sm.message(2, pbChoice(c))
+		}
+		m.message(1, sm)
+	}
+	return m, nil
+}
+
+// grpcSquares returns the squares from an API request as a
+// Squares message.
+func (session *susenSession) grpcSquares(r *http.Request, path string) (pbMessage, error) {
+	var squares []puzzle.Square
+	if e := session.apiCall(r, "GET", path, nil, &squares); e != nil {
+		return nil, e
+	}
+	var m pbMessage
+	for _, s := range squares {
+		m.message(1, pbSquare(s))
+	}
+	return m, nil
+}
+
+// pbSquare returns a Square message.
+func pbSquare(s puzzle.Square) pbMessage {
+	var m pbMessage
+	m.int(1, s.Index)
+	m.int(2, s.Aval)
+	m.int(3, s.Bval)
+	m.ints(4, s.Pvals)
+	m.ints(5, s.Marks)
+	m.bool(6, s.Guess)
+	m.int(7, s.Cage)
+	m.int(8, s.Sum)
+	m.int(9, s.Row)
+	m.int(10, s.Col)
+	m.string(11, s.Source)
+	return m
+}
+
+// pbChoice returns a Choice message.
+func pbChoice(c puzzle.Choice) pbMessage {
+	var m pbMessage
+	m.int(1, c.Index)
+	m.int(2, c.Value)
+	return m
+}
+
+// pbChoiceFrom returns the choice in a Choice message.
+func pbChoiceFrom(fields []pbField) puzzle.Choice {
+	var c puzzle.Choice
+	for _, f := range fields {
+		switch {
+		case f.number == 1 && f.wire == pbVarint:
+			c.Index = f.int()
+		case f.number == 2 && f.wire == pbVarint:
+			c.Value = f.int()
+		}
+	}
+	return c
+}
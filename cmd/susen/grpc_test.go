@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// helperGRPCServer starts an unencrypted HTTP/2 server of gRPC
+// calls, and returns it with a client for it.
+func helperGRPCServer() (*httptest.Server, *http.Client) {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv := httptest.NewUnstartedServer(grpcHandler(accounts))
+	srv.Config.Protocols = &protocols
+	srv.Start()
+	return srv, &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+}
+
+// helperGRPC makes a call, returning the fields of its response
+// message, its status code, and the session token it was made
+// with.
+func helperGRPC(t *testing.T, c *http.Client, srv *httptest.Server, method, token string, msg pbMessage) ([]pbField, int, string) {
+	var body bytes.Buffer
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	body.Write(prefix[:])
+	body.Write(msg)
+	req, _ := http.NewRequest("POST", srv.URL+grpcService+method, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set(grpcSessionHeader, token)
+	}
+	r, e := c.Do(req)
+	if e != nil {
+		t.Fatalf("%s call error: %v", method, e)
+	}
+	reply, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	code, e := strconv.Atoi(r.Trailer.Get("Grpc-Status"))
+	if r.StatusCode != http.StatusOK || r.ProtoMajor != 2 || e != nil {
+		t.Fatalf("%s call gave status %d over %s, gRPC status %q", method, r.StatusCode, r.Proto, r.Trailer.Get("Grpc-Status"))
+	}
+	if code != grpcOK {
+		return nil, code, r.Header.Get(grpcSessionHeader)
+	}
+	if len(reply) < 5 || int(binary.BigEndian.Uint32(reply[1:5])) != len(reply)-5 {
+		t.Fatalf("%s call gave bad response %v", method, reply)
+	}
+	fields, e := pbFields(reply[5:])
+	if e != nil {
+		t.Fatalf("%s call gave bad message: %v", method, e)
+	}
+	return fields, code, r.Header.Get(grpcSessionHeader)
+}
+
+// helperGRPCSquares decodes a Squares message.
+func helperGRPCSquares(t *testing.T, fields []pbField) []puzzle.Square {
+	var squares []puzzle.Square
+	for _, f := range fields {
+		sfs, e := pbFields(f.data)
+		if f.number != 1 || e != nil {
+			t.Fatalf("Bad square field %+v: %v", f, e)
+		}
+		var s puzzle.Square
+		for _, sf := range sfs {
+			switch sf.number {
+			case 1:
+				s.Index = sf.int()
+			case 2:
+				s.Aval = sf.int()
+			case 4:
+				s.Pvals, _ = sf.ints()
+			}
+		}
+		squares = append(squares, s)
+	}
+	return squares
+}
+
+func TestGRPC(t *testing.T) {
+	srv, c := helperGRPCServer()
+	defer srv.Close()
+
+	// the first call starts a session
+	fields, code, token := helperGRPC(t, c, srv, "GetSquares", "", nil)
+	squares := helperGRPCSquares(t, fields)
+	if code != grpcOK || len(squares) != 81 || !bytes.HasPrefix([]byte(token), []byte(grpcSessionPrefix)) {
+		t.Fatalf("GetSquares gave status %d, %d squares, token %q", code, len(squares), token)
+	}
+	session := sessionFor(token)
+	defer func() {
+		sessionMutex.Lock()
+		delete(sessions, token)
+		sessionMutex.Unlock()
+	}()
+	empty := 0
+	for _, s := range squares {
+		if s.Aval == 0 {
+			empty = s.Index
+			break
+		}
+	}
+	if empty == 0 || len(squares[empty-1].Pvals) == 0 {
+		t.Fatalf("Puzzle has no empty square with possible values: %+v", squares)
+	}
+
+	// assignment changes the session's board, and back undoes it
+	value := session.steps[0].Solutions()[0].Values[empty-1]
+	var choice pbMessage
+	choice.int(1, empty)
+	choice.int(2, value)
+	fields, code, _ = helperGRPC(t, c, srv, "Assign", token, choice)
+	if code != grpcOK || len(fields) == 0 || len(session.steps) != 2 {
+		t.Fatalf("Assign gave status %d, fields %+v, %d steps", code, fields, len(session.steps))
+	}
+	fields, code, _ = helperGRPC(t, c, srv, "Back", token, nil)
+	if squares = helperGRPCSquares(t, fields); code != grpcOK || squares[empty-1].Aval != 0 || len(session.steps) != 1 {
+		t.Errorf("Back gave status %d, square %+v", code, squares[empty-1])
+	}
+	choice = nil
+	choice.int(1, 500)
+	choice.int(2, 1)
+	if _, code, _ = helperGRPC(t, c, srv, "Assign", token, choice); code != grpcInvalidArgument {
+		t.Errorf("Assign to a missing square gave status %d", code)
+	}
+
+	// hints and solutions come from the session's puzzle
+	fields, code, _ = helperGRPC(t, c, srv, "Hint", token, nil)
+	if code != grpcOK || len(fields) < 2 || fields[0].number != 1 || fields[1].number != 2 {
+		t.Errorf("Hint gave status %d, fields %+v", code, fields)
+	}
+	fields, code, _ = helperGRPC(t, c, srv, "Solve", token, nil)
+	if code != grpcOK || len(fields) != 1 {
+		t.Fatalf("Solve gave status %d, fields %+v", code, fields)
+	}
+	sfs, _ := pbFields(fields[0].data)
+	if values, e := sfs[0].ints(); e != nil || len(values) != 81 || values[empty-1] != value {
+		t.Errorf("Solve gave values %v, %v", values, e)
+	}
+
+	// contest boards can't be solved
+	var reset pbMessage
+	reset.string(1, defaultPuzzleID)
+	reset.string(2, "contest")
+	if _, code, _ = helperGRPC(t, c, srv, "Reset", token, reset); code != grpcOK || !session.contest {
+		t.Errorf("Contest reset gave status %d", code)
+	}
+	if _, code, _ = helperGRPC(t, c, srv, "Solve", token, nil); code != grpcPermissionDenied {
+		t.Errorf("Contest solve gave status %d", code)
+	}
+
+	// unknown methods aren't implemented, and other requests
+	// aren't calls
+	if _, code, _ = helperGRPC(t, c, srv, "Cheat", token, nil); code != grpcUnimplemented {
+		t.Errorf("Unknown method gave status %d", code)
+	}
+	r, e := c.Get(srv.URL + grpcService + "GetSquares")
+	if e != nil {
+		t.Fatalf("GET request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("GET request gave status %d", r.StatusCode)
+	}
+}
+
+func TestGRPCEscape(t *testing.T) {
+	if s := grpcEscape("100% café\n"); s != "100%25 caf%C3%A9%0A" {
+		t.Errorf("Escaped message is %q", s)
+	}
+}
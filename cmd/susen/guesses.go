@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+)
+
+/*
+
+Guesses
+
+Players who are stuck can guess: POST /api/guess/ takes a choice,
+just like /api/assign/, but it also starts a branch of the
+board's move history at the guess.  If the branch dead-ends,
+POST /api/abandon/ takes the board back to just before the guess
+(undoing the guess and every move since) in one request.  Guesses
+nest, so a player can guess again on a branch, and abandoning
+only goes back to the latest open guess.  Undoing back past a
+guess closes its branch, as does starting the board over.
+
+While a branch is open, the filled squares that are part of it
+(the ones that abandoning would empty) are flagged with "guess":
+true in the board's squares, in assignment updates, and in the
+board's events, so the page can show them differently.
+
+*/
+
+// A guessedPuzzle is a board step, shown with the squares filled
+// since a step before it flagged as guesses.
+type guessedPuzzle struct {
+	puzzle.Puzzle
+	before puzzle.Puzzle
+}
+
+// Squares flags the guessed squares.
+func (g guessedPuzzle) Squares() []puzzle.Square {
+	return g.flag(g.Puzzle.Squares())
+}
+
+// Assign flags the guessed squares in the update.
+func (g guessedPuzzle) Assign(choice puzzle.Choice) (puzzle.Update, error) {
+	update, e := g.Puzzle.Assign(choice)
+	update.Squares = g.flag(update.Squares)
+	return update, e
+}
+
+// flag flags the assigned squares that weren't assigned before.
+func (g guessedPuzzle) flag(squares []puzzle.Square) []puzzle.Square {
+	if g.before == nil {
+		return squares
+	}
+	before := g.before.State().Values
+	for i, s := range squares {
+		if s.Aval != 0 && s.Index <= len(before) && before[s.Index-1] == 0 {
+			squares[i].Guess = true
+		}
+	}
+	return squares
+}
+
+// guessed shows a step of the board with its guesses flagged.
+func (board *susenBoard) guessed(step puzzle.Puzzle) puzzle.Puzzle {
+	if len(board.guesses) == 0 {
+		return step
+	}
+	return guessedPuzzle{step, board.steps[board.guesses[len(board.guesses)-1]-1]}
+}
+
+// closeGuesses closes the branches of guesses that have been
+// undone.
+func (board *susenBoard) closeGuesses() {
+	for len(board.guesses) > 0 && board.guesses[len(board.guesses)-1] >= len(board.steps) {
+		board.guesses = board.guesses[:len(board.guesses)-1]
+	}
+}
+
+// abandonGuess takes the board back to just before its latest
+// open guess, telling whether there was one.  Each step taken
+// back counts as an undo.
+func (session *susenSession) abandonGuess() bool {
+	if len(session.guesses) == 0 {
+		return false
+	}
+	for point := session.guesses[len(session.guesses)-1]; len(session.steps) > point; {
+		session.undoStep()
+	}
+	return true
+}
+
+// abandonHandler handles POST /api/abandon/, responding with the
+// board's squares.
+func (session *susenSession) abandonHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Guesses can only be abandoned with a POST"))
+		return
+	}
+	if !session.abandonGuess() {
+		sendError(w, http.StatusConflict, requestError("There is no guess to abandon"))
+		return
+	}
+	session.notifySquares()
+	session.moved()
+	session.recordAction(undoAction)
+	last := session.steps[len(session.steps)-1]
+	attachProgress(w, last)
+	puzzle.SquaresHandler(session.shown(last), w, r)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGuesses(t *testing.T) {
+	session := newSession("test-guesses")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	post := func(path string, body interface{}) (int, puzzle.Update) {
+		bs, _ := json.Marshal(body)
+		r, e := http.Post(srv.URL+path, "application/json", bytes.NewReader(bs))
+		if e != nil {
+			t.Fatalf("Request error: %v", e)
+		}
+		defer r.Body.Close()
+		var update puzzle.Update
+		json.NewDecoder(r.Body).Decode(&update)
+		return r.StatusCode, update
+	}
+	guesses := func() (count int) {
+		var squares []puzzle.Square
+		helperGetJSON(t, srv, "/api/", &squares)
+		for _, s := range squares {
+			if s.Guess {
+				count++
+			}
+		}
+		return
+	}
+
+	if status, _ := post("/api/abandon/", nil); status != http.StatusConflict {
+		t.Errorf("Abandon without a guess gave status %d", status)
+	}
+	helperRoomAssign(t, srv, helperTurnChoice(session))
+	if guesses() != 0 {
+		t.Errorf("Squares are flagged without a guess")
+	}
+
+	// guesses and the moves after them are flagged
+	status, update := post("/api/guess/", helperTurnChoice(session))
+	if status != http.StatusOK || len(session.guesses) != 1 || len(update.Squares) == 0 || !update.Squares[0].Guess {
+		t.Fatalf("Guess gave %d, %+v", status, update)
+	}
+	if status, update = post("/api/assign/", helperTurnChoice(session)); status != http.StatusOK || !update.Squares[0].Guess {
+		t.Errorf("Assign after a guess gave %d, %+v", status, update)
+	}
+	if n := guesses(); n != 2 {
+		t.Errorf("%d squares are flagged after a guess and a move", n)
+	}
+
+	// guesses nest, and abandoning goes back to the latest one
+	post("/api/guess/", helperTurnChoice(session))
+	if n := guesses(); n != 1 {
+		t.Errorf("%d squares are flagged after a second guess", n)
+	}
+	if status, _ = post("/api/abandon/", nil); status != http.StatusOK || len(session.steps) != 4 || len(session.guesses) != 1 {
+		t.Errorf("Abandon gave %d, left %d steps and %d guesses", status, len(session.steps), len(session.guesses))
+	}
+	if n := guesses(); n != 2 {
+		t.Errorf("%d squares are flagged after abandoning the second guess", n)
+	}
+	if status, _ = post("/api/abandon/", nil); status != http.StatusOK || len(session.steps) != 2 || len(session.guesses) != 0 {
+		t.Errorf("Abandon gave %d, left %d steps and %d guesses", status, len(session.steps), len(session.guesses))
+	}
+	if session.stats.Undos != 3 || guesses() != 0 {
+		t.Errorf("After abandoning, there are %d undos and %d flagged squares", session.stats.Undos, guesses())
+	}
+
+	// undoing a guess closes its branch, as does a failed guess
+	post("/api/guess/", helperTurnChoice(session))
+	var squares []puzzle.Square
+	helperGetJSON(t, srv, "/api/back/", &squares)
+	if len(session.guesses) != 0 {
+		t.Errorf("Undoing a guess left %d guesses", len(session.guesses))
+	}
+	if status, _ = post("/api/guess/", puzzle.Choice{Index: 1, Value: 1}); status != http.StatusBadRequest || len(session.guesses) != 0 {
+		t.Errorf("Failed guess gave %d, left %d guesses", status, len(session.guesses))
+	}
+	if status := helperGetJSON(t, srv, "/api/abandon/", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET of abandon gave status %d", status)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+/*
+
+Health and readiness
+
+Load balancers and process managers probe two endpoints, which
+are served outside the session machinery (so probes don't make
+sessions, count as requests, or get logged):
+
+- GET /healthz answers 200 whenever the process is serving.
+
+- GET /readyz answers 200 when the server can take players: its
+puzzle catalog is loaded and its store can be read.  Otherwise,
+and from the moment the server starts shutting down, it answers
+503, so probes stop sending it traffic.  Either way, the body
+gives the result of each check, for example:
+
+	{"ready":false,"checks":{"catalog":"ok","store":"store is down","shutdown":"shutting down"}}
+
+To give probes time to notice before the server stops
+listening, set the server's drain time (see server.go).
+
+*/
+
+// draining is set (to 1) once the server starts shutting down.
+var draining int32
+
+// healthzHandler handles GET /healthz.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	sendProbe(w, http.StatusOK, map[string]interface{}{"alive": true})
+}
+
+// readyzHandler handles GET /readyz.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"catalog": "ok", "store": "ok"}
+	ready := true
+	if len(catalogIDs()) == 0 {
+		checks["catalog"], ready = "no puzzles loaded", false
+	}
+	if _, e := store.Get("readyz", "probe", &struct{}{}); e != nil {
+		checks["store"], ready = e.Error(), false
+	}
+	if atomic.LoadInt32(&draining) != 0 {
+		checks["shutdown"], ready = "shutting down", false
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	sendProbe(w, status, map[string]interface{}{"ready": ready, "checks": checks})
+}
+
+// sendProbe sends a probe response.  Unlike sendJSON, it doesn't
+// count failures as server errors, since a server that isn't
+// ready says so on purpose (and often, while it shuts down).
+func sendProbe(w http.ResponseWriter, status int, obj interface{}) {
+	bytes, _ := json.Marshal(obj)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
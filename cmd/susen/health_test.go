@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	defer atomic.StoreInt32(&draining, 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	probe := func(path string) (int, map[string]interface{}) {
+		r, e := http.Get(srv.URL + path)
+		if e != nil {
+			t.Fatalf("Probe of %s failed: %v", path, e)
+		}
+		defer r.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		return r.StatusCode, body
+	}
+
+	if status, body := probe("/healthz"); status != http.StatusOK || body["alive"] != true {
+		t.Errorf("Health probe gave %d, %v", status, body)
+	}
+	if status, body := probe("/readyz"); status != http.StatusOK || body["ready"] != true {
+		t.Errorf("Readiness probe gave %d, %v", status, body)
+	}
+
+	// a failing store makes the server unready, but still alive
+	store = failingStore{}
+	if status, body := probe("/readyz"); status != http.StatusServiceUnavailable ||
+		body["checks"].(map[string]interface{})["store"] != "store is down" {
+		t.Errorf("Readiness probe with a failing store gave %d, %v", status, body)
+	}
+	if status, _ := probe("/healthz"); status != http.StatusOK {
+		t.Errorf("Health probe with a failing store gave %d", status)
+	}
+	store = storage.NewMemory()
+
+	// so does shutting down
+	atomic.StoreInt32(&draining, 1)
+	if status, body := probe("/readyz"); status != http.StatusServiceUnavailable ||
+		body["checks"].(map[string]interface{})["shutdown"] == nil {
+		t.Errorf("Readiness probe while shutting down gave %d, %v", status, body)
+	}
+}
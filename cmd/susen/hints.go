@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+)
+
+/*
+
+Tutorial hints
+
+The server keeps a count of each kind of action a session has
+taken, and uses those counts to decide which onboarding prompts
+the UI should show.  Each prompt introduces one feature, and is
+offered until the session has used that feature, so the UI
+discloses features progressively just by showing whatever the
+server sends.
+
+*/
+
+// A sessionAction is a kind of action counted for tutorial
+// hints.
+type sessionAction int
+
+// Constants for the kinds of counted actions.
+const (
+	assignAction sessionAction = iota
+	undoAction
+	markAction
+	resetAction
+	maxAction
+)
+
+// A uiHint is an onboarding prompt.  The ID is stable, so
+// clients can localize the message or remember that it was
+// dismissed.
+type uiHint struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// uiHintRules give the hints in the order they should be shown,
+// each with the test for whether it's still useful.
+var uiHintRules = []struct {
+	hint uiHint
+	show func(counts []int) bool
+}{
+	{
+		uiHint{"assign", "Try tapping a square, then typing the number you think goes there."},
+		func(counts []int) bool { return counts[assignAction] == 0 },
+	},
+	{
+		uiHint{"undo", "You can undo your last move with the ← button."},
+		func(counts []int) bool { return counts[assignAction] > 0 && counts[undoAction] == 0 },
+	},
+	{
+		uiHint{"mark", "Not sure yet? Pencil in the numbers a square might be."},
+		func(counts []int) bool { return counts[assignAction] >= 3 && counts[markAction] == 0 },
+	},
+	{
+		uiHint{"reset", "Want a different challenge? You can pick another puzzle at any time."},
+		func(counts []int) bool { return counts[assignAction] >= 10 && counts[resetAction] == 0 },
+	},
+}
+
+// recordAction counts an action taken by the session.
+func (session *susenSession) recordAction(action sessionAction) {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if session.actions == nil {
+		session.actions = make([]int, maxAction)
+	}
+	session.actions[action]++
+}
+
+// uiHints returns the hints that apply to the session's history
+// (none, if the hints feature is turned off).
+func (session *susenSession) uiHints() []uiHint {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	counts := session.actions
+	if counts == nil {
+		counts = make([]int, maxAction)
+	}
+	hints := []uiHint{}
+	if !featureEnabled("hints") {
+		return hints
+	}
+	for _, rule := range uiHintRules {
+		if rule.show(counts) {
+			hints = append(hints, rule.hint)
+		}
+	}
+	return hints
+}
+
+// uiHintsHandler returns the session's tutorial hints.
+func (session *susenSession) uiHintsHandler(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, session.uiHints())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Move history
+
+The board keeps a timeline of the moves made in its current play
+of the puzzle, which replay views and post-game analysis use.
+GET /api/history gives it:
+
+	{"puzzleID":"1-star","started":"2026-10-15T09:30:00Z","moves":[
+	 {"index":3,"value":7,"time":"2026-10-15T09:30:12Z","kind":"entry","step":1},
+	 {"index":5,"value":2,"time":"2026-10-15T09:31:40Z","kind":"hint","step":2,
+	  "undone":true,"undoneAt":"2026-10-15T09:32:05Z"},...]}
+
+Each move is a square's assignment, with when it was made, the
+step of the board's history it made (see main.go; a batch or an
+autofill makes several moves in one step), and its kind:
+
+- entry: the player filled in the square
+
+- hint: the player filled in the square of the board's latest
+hint (see suggest.go)
+
+- autofill: the server filled in the square, in an autofill (see
+autofill.go) or from an imported game (see appimport.go)
+
+- guess: the player guessed the square's value, starting a branch
+(see guesses.go); the moves after the guess are entries, hints,
+and autofills as usual
+
+A move that was taken back, by an undo or by abandoning a guess,
+stays in the history, marked as undone, with when it was.
+Starting the puzzle over starts a new history.
+
+*/
+
+// The kinds of moves.
+const (
+	entryMove    = "entry"
+	hintMove     = "hint"
+	autofillMove = "autofill"
+	guessMove    = "guess"
+)
+
+// A historyMove is a move in a board's history.
+type historyMove struct {
+	Index    int        `json:"index"`
+	Value    int        `json:"value"`
+	Time     time.Time  `json:"time"`
+	Kind     string     `json:"kind"`
+	Step     int        `json:"step"`
+	Undone   bool       `json:"undone"`
+	UndoneAt *time.Time `json:"undoneAt,omitempty"`
+}
+
+// historyResponse is the response to history requests.
+type historyResponse struct {
+	PuzzleID string        `json:"puzzleID"`
+	Started  time.Time     `json:"started"`
+	Moves    []historyMove `json:"moves"`
+}
+
+// recordMove adds the filling of a square, which has just been
+// counted as assigned (see stats.go), to the board's history.
+// Like all board statistics operations, it must be called with
+// the board locked.
+func (board *susenBoard) recordMove(index, value int) {
+	step := len(board.steps) - 1
+	kind := entryMove
+	switch {
+	case index <= len(board.stats.sources) && board.stats.sources[index-1] == hintSource:
+		kind = hintMove
+	case len(board.guesses) > 0 && board.guesses[len(board.guesses)-1] == step:
+		kind = guessMove
+	}
+	board.stats.history = append(board.stats.history,
+		historyMove{Index: index, Value: value, Time: time.Now(), Kind: kind, Step: step})
+}
+
+// undoMoves marks the moves of steps the board no longer has as
+// undone.  It must be called with the board locked.
+func (board *susenBoard) undoMoves() {
+	now := time.Now()
+	for i := len(board.stats.history) - 1; i >= 0; i-- {
+		move := &board.stats.history[i]
+		if move.Step < len(board.steps) {
+			break
+		}
+		if !move.Undone {
+			move.Undone, move.UndoneAt = true, &now
+		}
+	}
+}
+
+// historyHandler handles GET /api/history.  It's called with the
+// board locked.
+func (session *susenSession) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.Trim(r.URL.Path, "/") != "api/history" {
+		sendError(w, http.StatusNotFound, requestError("Unknown history operation: "+r.URL.Path))
+		return
+	}
+	moves := session.stats.history
+	if moves == nil {
+		moves = []historyMove{}
+	}
+	sendJSON(w, http.StatusOK, historyResponse{session.puzzleID, session.stats.Started, moves})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	session := newSession("test-history")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	history := func() historyResponse {
+		var h historyResponse
+		if status := helperGetJSON(t, srv, "/api/history", &h); status != http.StatusOK {
+			t.Fatalf("History gave status %d", status)
+		}
+		return h
+	}
+	if h := history(); h.PuzzleID != session.puzzleID || h.Moves == nil || len(h.Moves) != 0 {
+		t.Fatalf("Starting history is %+v", h)
+	}
+	solution := session.steps[0].Solutions()[0].Values
+	empty := func(skip int) puzzle.Choice {
+		for _, s := range session.steps[len(session.steps)-1].Squares() {
+			if s.Aval == 0 && s.Index != skip {
+				return puzzle.Choice{Index: s.Index, Value: solution[s.Index-1]}
+			}
+		}
+		t.Fatalf("No empty squares")
+		return puzzle.Choice{}
+	}
+
+	// an entry, the hinted square, and a guess that's abandoned
+	entry := empty(0)
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", entry, nil); status != http.StatusOK {
+		t.Fatalf("Assign gave status %d", status)
+	}
+	_, _, hr := helperHint(t, srv)
+	hinted := puzzle.Choice{Index: hr.Hint.Choice.Index, Value: solution[hr.Hint.Choice.Index-1]}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", hinted, nil); status != http.StatusOK {
+		t.Fatalf("Assign of hinted square gave status %d", status)
+	}
+	guess := empty(0)
+	if status := helperUserRequest(t, srv, "", "POST", "/api/guess/", guess, nil); status != http.StatusOK {
+		t.Fatalf("Guess gave status %d", status)
+	}
+	after := empty(0)
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", after, nil); status != http.StatusOK {
+		t.Fatalf("Assign after guess gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/abandon/", nil, nil); status != http.StatusOK {
+		t.Fatalf("Abandon gave status %d", status)
+	}
+	var af autofillResponse
+	if status := helperUserRequest(t, srv, "", "POST", "/api/autofill", nil, &af); status != http.StatusOK {
+		t.Fatalf("Autofill gave status %d", status)
+	}
+
+	h := history()
+	expected := []historyMove{
+		{Index: entry.Index, Value: entry.Value, Kind: entryMove, Step: 1},
+		{Index: hinted.Index, Value: hinted.Value, Kind: hintMove, Step: 2},
+		{Index: guess.Index, Value: guess.Value, Kind: guessMove, Step: 3, Undone: true},
+		{Index: after.Index, Value: after.Value, Kind: entryMove, Step: 4, Undone: true},
+	}
+	if len(h.Moves) != len(expected)+len(af.Assignments) {
+		t.Fatalf("History has %d moves, expected %d", len(h.Moves), len(expected)+len(af.Assignments))
+	}
+	for i, move := range h.Moves {
+		want := historyMove{Kind: autofillMove, Step: 3}
+		if i < len(expected) {
+			want = expected[i]
+		} else {
+			want.Index, want.Value = af.Assignments[i-len(expected)].Index, af.Assignments[i-len(expected)].Value
+		}
+		if move.Index != want.Index || move.Value != want.Value || move.Kind != want.Kind || move.Step != want.Step ||
+			move.Undone != want.Undone || (move.UndoneAt != nil) != want.Undone || move.Time.IsZero() {
+			t.Errorf("Move %d is %+v, expected %+v", i, move, want)
+		}
+	}
+
+	// the history outlasts the server
+	c, _ := session.checkpoint()
+	restored, e := c.restore("test-history-restored")
+	if e != nil {
+		t.Fatalf("Restore failed: %v", e)
+	}
+	if len(restored.stats.history) != len(h.Moves) {
+		t.Errorf("Restored history has %d moves", len(restored.stats.history))
+	}
+
+	// and starting over starts a new one
+	helperUserRequest(t, srv, "", "GET", "/api/reset/", nil, nil)
+	if h := history(); len(h.Moves) != 0 {
+		t.Errorf("History after reset has %d moves", len(h.Moves))
+	}
+}
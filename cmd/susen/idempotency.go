@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Idempotency keys
+
+Clients on flaky networks retry requests whose responses they
+didn't get, which for an assignment that did get made gives a
+spurious DuplicateAssignmentCondition error.  So assignment
+requests (POST /api/assign/, /api/assign-symbol/,
+/api/assign-batch/, and /api/guess/) can carry an
+Idempotency-Key header, whose value is any string of at most
+maxIdempotencyKeyLength characters that the client doesn't reuse
+for other requests.  Each session remembers the responses to its
+last maxIdempotencyKeys keyed requests, and a request with a
+remembered key gets the original response back, with an
+Idempotent-Replayed: true header, without being made again.  A
+key reused for a request with a different path or body is an
+error (422), as is an over-long key (400).  A keyed request's
+body is read before it's handled, so it's held to the limit of
+the body it's decoded from (MaxChoicesBytes for a batch, and
+MaxChoiceBytes otherwise), and refused (413) if it's bigger.
+
+Server errors (5xx) aren't remembered, so their requests can be
+retried with the same key.
+
+*/
+
+// The idempotency headers.
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeys is how many keyed requests a session
+// remembers.
+const maxIdempotencyKeys = 64
+
+// maxIdempotencyKeyLength is how long keys can be.
+const maxIdempotencyKeyLength = 255
+
+// A savedResponse is the response to a keyed request.
+type savedResponse struct {
+	request [sha256.Size]byte // the digest of the request's path and body
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+// idempotentPath tells whether requests to a path can be keyed.
+func idempotentPath(path string) bool {
+	for _, prefix := range []string{"/api/assign", "/api/guess"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotentLimit is the biggest body a keyed request to a path
+// can have.
+func idempotentLimit(path string) int {
+	if strings.HasPrefix(path, "/api/assign-batch") {
+		return puzzle.MaxChoicesBytes
+	}
+	return puzzle.MaxChoiceBytes
+}
+
+// A responseSaver passes a response on, saving a copy.
+type responseSaver struct {
+	http.ResponseWriter
+	saved savedResponse
+}
+
+func (w *responseSaver) WriteHeader(status int) {
+	if w.saved.status == 0 {
+		w.saved.status = status
+		w.saved.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseSaver) Write(b []byte) (int, error) {
+	if w.saved.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.saved.body = append(w.saved.body, b...)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent handles the request with the handler, unless it has
+// a key the session remembers, in which case it replays the
+// response.  It's called with the board locked, so retries of a
+// request are handled one after the other.
+func (session *susenSession) idempotent(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" || r.Method != "POST" || !idempotentPath(r.URL.Path) {
+		handler(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		sendError(w, http.StatusBadRequest, fieldError(idempotencyKeyHeader,
+			"Idempotency keys can be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters"))
+		return
+	}
+	body, e := puzzle.ReadBody(r.Body, idempotentLimit(r.URL.Path))
+	if e != nil {
+		sendError(w, puzzle.DecodingStatus(e), e.(puzzle.Error))
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	digest := sha256.Sum256(append([]byte(r.URL.Path+"\n"), body...))
+
+	session.infoMutex.Lock()
+	saved, ok := session.replays[key]
+	session.infoMutex.Unlock()
+	if ok {
+		if saved.request != digest {
+			sendError(w, http.StatusUnprocessableEntity,
+				fieldError(idempotencyKeyHeader, "Idempotency key "+key+" was used for a different request"))
+			return
+		}
+		hs := w.Header()
+		for name, values := range saved.header {
+			if name != http.CanonicalHeaderKey(puzzle.RequestIDHeader) {
+				hs[name] = values
+			}
+		}
+		hs.Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(saved.status)
+		w.Write(saved.body)
+		return
+	}
+
+	saver := &responseSaver{ResponseWriter: w}
+	handler(saver, r)
+	if saver.saved.status == 0 || saver.saved.status >= 500 {
+		return
+	}
+	saver.saved.request = digest
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if session.replays == nil {
+		session.replays = make(map[string]*savedResponse)
+	}
+	if len(session.replayKeys) == maxIdempotencyKeys {
+		delete(session.replays, session.replayKeys[0])
+		session.replayKeys = session.replayKeys[1:]
+	}
+	session.replays[key] = &saver.saved
+	session.replayKeys = append(session.replayKeys, key)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIdempotency(t *testing.T) {
+	session := newSession("test-idempotency")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	var choices []puzzle.Choice
+	for _, sq := range session.steps[0].Squares() {
+		if sq.Aval == 0 && len(sq.Pvals) > 0 {
+			choices = append(choices, puzzle.Choice{Index: sq.Index, Value: sq.Pvals[0]})
+		}
+	}
+	assign := func(key string, choice puzzle.Choice) (*http.Response, string) {
+		bs, _ := json.Marshal(choice)
+		req, _ := http.NewRequest("POST", srv.URL+"/api/assign/", bytes.NewReader(bs))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("Assign request error: %v", e)
+		}
+		defer r.Body.Close()
+		body, _ := ioutil.ReadAll(r.Body)
+		return r, string(body)
+	}
+
+	// a retried assignment gets the original response, and isn't
+	// made again
+	r1, body1 := assign("retry-1", choices[0])
+	steps := len(session.steps)
+	r2, body2 := assign("retry-1", choices[0])
+	if r1.StatusCode != http.StatusOK || r2.StatusCode != http.StatusOK || body1 != body2 ||
+		r2.Header.Get(idempotencyReplayedHeader) != "true" || r1.Header.Get(idempotencyReplayedHeader) != "" ||
+		r2.Header.Get("Content-Type") != r1.Header.Get("Content-Type") || len(session.steps) != steps {
+		t.Errorf("Retried assignment gave %d, %d (%q), with %d steps, not %d",
+			r1.StatusCode, r2.StatusCode, r2.Header.Get(idempotencyReplayedHeader), len(session.steps), steps)
+	}
+	// errors are replayed too
+	r1, body1 = assign("retry-2", puzzle.Choice{Index: 1000, Value: 1})
+	r2, body2 = assign("retry-2", puzzle.Choice{Index: 1000, Value: 1})
+	if r1.StatusCode != http.StatusBadRequest || r2.StatusCode != http.StatusBadRequest || body1 != body2 {
+		t.Errorf("Retried error gave %d, %d", r1.StatusCode, r2.StatusCode)
+	}
+
+	// keys can't be reused for other requests, or be too long
+	if r, _ := assign("retry-1", choices[1]); r.StatusCode != http.StatusUnprocessableEntity || len(session.steps) != steps {
+		t.Errorf("Reused key gave %d", r.StatusCode)
+	}
+	if r, _ := assign(strings.Repeat("k", maxIdempotencyKeyLength+1), choices[1]); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Long key gave %d", r.StatusCode)
+	}
+
+	// keyed bodies are held to their path's limit
+	big := func(path string, size int) int {
+		req, _ := http.NewRequest("POST", srv.URL+path, bytes.NewReader(bytes.Repeat([]byte(" "), size)))
+		req.Header.Set(idempotencyKeyHeader, "big-"+path)
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("Big request error: %v", e)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+	if status := big("/api/assign/", puzzle.MaxChoiceBytes+1); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Big keyed assignment gave %d", status)
+	}
+	if status := big("/api/assign-batch/", puzzle.MaxChoiceBytes+1); status == http.StatusRequestEntityTooLarge {
+		t.Errorf("Keyed batch was refused at the single choice limit")
+	}
+	if status := big("/api/assign-batch/", puzzle.MaxChoicesBytes+1); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Big keyed batch gave %d", status)
+	}
+	if len(session.steps) != steps {
+		t.Errorf("Big requests made %d steps", len(session.steps)-steps)
+	}
+
+	// only the latest keys are remembered
+	session.replays, session.replayKeys = nil, nil
+	for i := 0; i <= maxIdempotencyKeys; i++ {
+		assign("key-"+strconv.Itoa(i), puzzle.Choice{Index: 1000, Value: 1})
+	}
+	if len(session.replays) != maxIdempotencyKeys || session.replays["key-0"] != nil {
+		t.Errorf("Session remembers %d keys", len(session.replays))
+	}
+}
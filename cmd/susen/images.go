@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+/*
+
+Images
+
+GET /api/image.svg gives the board as an SVG image (see
+puzzle.RenderSVG), for printing, posting, and clients that don't
+run the solver page.  The query can ask for candidates to be
+pencilled in: marks=true for the board's pencil marks, and
+possibles=true for the possible values of the other empty
+squares (which contest and unassisted boards don't reveal).
+The size parameter is the square size in pixels.
+
+Once the board's puzzle is completed, GET /api/share-image gives
+a share card for it (see puzzle.ShareCard), with the puzzle's
+difficulty and the solve time but none of its values, as PNG or
+(with format=svg) as SVG.  Rating the puzzle for the card is
+metered as analysis.
+
+*/
+
+// maxImageSquareSize is the largest square size of images.
+const maxImageSquareSize = 200
+
+// imageHandler handles GET /api/image.svg.
+func (session *susenSession) imageHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := puzzle.SVGOptions{Givens: session.values}
+	opts.Marks, _ = strconv.ParseBool(q.Get("marks"))
+	opts.Possibles, _ = strconv.ParseBool(q.Get("possibles"))
+	if s := q.Get("size"); s != "" {
+		size, e := strconv.Atoi(s)
+		if e != nil || size <= 0 || size > maxImageSquareSize {
+			sendError(w, http.StatusBadRequest,
+				requestError("The size must be a number of pixels from 1 to "+strconv.Itoa(maxImageSquareSize)))
+			return
+		}
+		opts.SquareSize = size
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	if e := puzzle.RenderSVG(w, session.steps[len(session.steps)-1], opts); e != nil {
+		log.Printf("Can't send image of session %v: %v", session.sessionID, e)
+	}
+}
+
+// shareImageHandler handles GET /api/share-image.
+func (session *susenSession) shareImageHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "png" && format != "svg" {
+		sendError(w, http.StatusBadRequest, requestError("Share images are png or svg, not "+format))
+		return
+	}
+	if session.stats.Completed == nil || session.stats.Expired {
+		sendError(w, http.StatusConflict, requestError("Complete the puzzle to share it"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) {
+		return
+	}
+	card := puzzle.NewShareCard(session.steps[len(session.steps)-1], session.values,
+		session.shareTitle(), session.shareStars(), session.solveTime())
+	w.Header().Set("Cache-Control", "no-cache")
+	var e error
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		e = puzzle.RenderCardSVG(w, card)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		e = puzzle.RenderCardPNG(w, card)
+	}
+	if e != nil {
+		log.Printf("Can't send share image of session %v: %v", session.sessionID, e)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImage(t *testing.T) {
+	session := newSession("test-image")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	p, _ := puzzle.New(session.values)
+	for i, s := range p.Squares() {
+		if s.Aval == 0 {
+			helperRoomAssign(t, srv, puzzle.Choice{Index: i + 1, Value: p.Solutions()[0].Values[i]})
+			break
+		}
+	}
+
+	get := func(query string) (int, string) {
+		r, e := http.Get(srv.URL + "/api/image.svg" + query)
+		if e != nil {
+			t.Fatalf("Image request error: %v", e)
+		}
+		defer r.Body.Close()
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.StatusCode == http.StatusOK && r.Header.Get("Content-Type") != "image/svg+xml" {
+			t.Errorf("Image content type is %q", r.Header.Get("Content-Type"))
+		}
+		return r.StatusCode, string(b)
+	}
+	status, svg := get("")
+	if status != http.StatusOK || !strings.HasPrefix(svg, "<svg") || strings.Count(svg, `font-weight="normal"`) != 1 {
+		t.Errorf("Image gave %d, %q", status, svg)
+	}
+	if strings.Contains(svg, `fill="#555"`) {
+		t.Errorf("Image without candidates has candidates")
+	}
+	if status, svg = get("?possibles=true&size=20"); status != http.StatusOK || !strings.Contains(svg, `fill="#555"`) ||
+		!strings.Contains(svg, `width="184"`) {
+		t.Errorf("Image with possibles gave status %d, size %q", status, svg[:80])
+	}
+	for _, query := range []string{"?size=0", "?size=big", "?size=1000"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("Image with %q gave status %d", query, status)
+		}
+	}
+}
+
+func TestShareImage(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-share-image")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	get := func(query string) (int, string, []byte) {
+		r, e := http.Get(srv.URL + "/api/share-image" + query)
+		if e != nil {
+			t.Fatalf("Share image request error: %v", e)
+		}
+		defer r.Body.Close()
+		b, _ := ioutil.ReadAll(r.Body)
+		return r.StatusCode, r.Header.Get("Content-Type"), b
+	}
+	if status, _, _ := get(""); status != http.StatusConflict {
+		t.Errorf("Share image of an unfinished puzzle gave status %d", status)
+	}
+	helperSolve(t, srv, session)
+	status, ctype, b := get("")
+	if status != http.StatusOK || ctype != "image/png" {
+		t.Fatalf("Share image gave status %d, type %q", status, ctype)
+	}
+	if _, e := png.Decode(bytes.NewReader(b)); e != nil {
+		t.Errorf("Share image isn't a PNG: %v", e)
+	}
+	status, ctype, b = get("?format=svg")
+	if svg := string(b); status != http.StatusOK || ctype != "image/svg+xml" ||
+		!strings.Contains(svg, "Susen "+session.puzzleID) || !strings.Contains(svg, "★") {
+		t.Errorf("SVG share image gave status %d, %q", status, svg)
+	}
+	// no values are revealed
+	if n := strings.Count(string(b), "<text"); n != 3 {
+		t.Errorf("SVG share image has %d texts", n)
+	}
+	if status, _, _ := get("?format=gif"); status != http.StatusBadRequest {
+		t.Errorf("Share image as gif gave status %d", status)
+	}
+}
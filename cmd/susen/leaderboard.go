@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Leaderboards
+
+Each puzzle has a leaderboard of its fastest solves, which is
+kept in the store (so it uses the same backend as accounts and
+statistics).  A solve is entered automatically when the server
+knows it's correct: when the last square of an ordinary board is
+filled without errors, or when a contest or unassisted board
+submits a valid solution.
+
+Players are entered under their user key if they are identified,
+and under their session otherwise, and each player only has
+their best solve on a board.  Solves are ranked by how many of
+their squares were assisted (see sources.go), then by time, then
+by number of moves.  (Blitz attempts that ran out of time rank after
+all the solves, by how many squares they got right.)  The keys are kept in the store, but never
+shown, since session keys are the same as session cookies.
+
+A leaderboard can also be ranked by score (see scoring.go), with
+?rank=score: the entries are the same players' best solves, but
+scored solves come first, highest score first.
+
+*/
+
+// A leaderboardEntry is one player's best solve on a leaderboard.
+type leaderboardEntry struct {
+	Key        string    `json:"key,omitempty"` // never sent to clients
+	Name       string    `json:"name"`
+	SolveTime  float64   `json:"solveTime"` // seconds
+	Moves      int       `json:"moves"`
+	Undos      int       `json:"undos"`
+	Hints      int       `json:"hints,omitempty"`
+	Assisted   int       `json:"assisted,omitempty"` // squares filled by hints and the server (see sources.go)
+	Completed  time.Time `json:"completed"`
+	Unassisted bool      `json:"unassisted,omitempty"`
+	Expired    bool      `json:"expired,omitempty"` // a blitz attempt that ran out of time
+	Filled     int       `json:"filled,omitempty"`  // squares right in an expired attempt
+	Score      *int      `json:"score,omitempty"`   // nil for unscored solves (see scoring.go)
+}
+
+// A leaderboard is the ranked entries for a board, which is
+// named by puzzle ID.
+type leaderboard struct {
+	Board   string             `json:"board"`
+	Entries []leaderboardEntry `json:"entries"`
+}
+
+// leaderboardKind is the storage kind for leaderboards, and
+// maxLeaderboardEntries is how many entries each one keeps.
+const (
+	leaderboardKind       = "leaderboard"
+	maxLeaderboardEntries = 50
+)
+
+// leaderboardMutex serializes the updates of leaderboards.
+var leaderboardMutex sync.Mutex
+
+// player returns the key and display name under which the
+// session's results are recorded.  Slot sessions record theirs
+// as their owner's (see slots.go).
+func (session *susenSession) player() (string, string) {
+	if session.owner != nil {
+		return session.owner.player()
+	}
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if session.user != nil {
+		return session.user.Key(), session.user.Name
+	}
+	return "session:" + session.sessionID, "anonymous"
+}
+
+// ranksBefore tells whether one entry ranks ahead of another.
+func (e leaderboardEntry) ranksBefore(other leaderboardEntry) bool {
+	if e.Expired != other.Expired {
+		return other.Expired
+	}
+	if e.Expired && e.Filled != other.Filled {
+		return e.Filled > other.Filled
+	}
+	if e.Assisted != other.Assisted {
+		return e.Assisted < other.Assisted
+	}
+	if e.SolveTime != other.SolveTime {
+		return e.SolveTime < other.SolveTime
+	}
+	return e.Moves < other.Moves
+}
+
+// addLeaderboardEntry enters a solve on a board's leaderboard,
+// unless the player already has a better one there.
+func addLeaderboardEntry(board string, entry leaderboardEntry) {
+	leaderboardMutex.Lock()
+	defer leaderboardMutex.Unlock()
+	lb := leaderboard{Board: board}
+	if _, e := store.Get(leaderboardKind, board, &lb); e != nil {
+		log.Printf("Can't read leaderboard %q: %v", board, e)
+		return
+	}
+	for i, old := range lb.Entries {
+		if old.Key == entry.Key {
+			if !entry.ranksBefore(old) {
+				return
+			}
+			lb.Entries = append(lb.Entries[:i], lb.Entries[i+1:]...)
+			break
+		}
+	}
+	lb.Entries = append(lb.Entries, entry)
+	sort.SliceStable(lb.Entries, func(i, j int) bool {
+		return lb.Entries[i].ranksBefore(lb.Entries[j])
+	})
+	if len(lb.Entries) > maxLeaderboardEntries {
+		lb.Entries = lb.Entries[:maxLeaderboardEntries]
+	}
+	if e := store.Put(leaderboardKind, board, lb); e != nil {
+		log.Printf("Can't save leaderboard %q: %v", board, e)
+	}
+}
+
+// readLeaderboard returns a board's leaderboard, without the
+// entries' keys.
+func readLeaderboard(ctx context.Context, board string) (leaderboard, error) {
+	lb := leaderboard{Board: board}
+	leaderboardMutex.Lock()
+	_, e := reportGet(ctx, leaderboardKind, board, &lb)
+	leaderboardMutex.Unlock()
+	if lb.Entries == nil {
+		lb.Entries = []leaderboardEntry{}
+	}
+	for i := range lb.Entries {
+		lb.Entries[i].Key = ""
+	}
+	return lb, e
+}
+
+// leaderboardHandler handles GET /api/leaderboard/<puzzleID>,
+// which gives the puzzle's leaderboard ("daily" means today's
+// daily puzzle), a page of entries at a time (see paging.go),
+// with display forms for the player's locale (see locale.go).
+// With ?rank=score, it's ranked by score instead of time.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Leaderboards can only be read"))
+		return
+	}
+	puzzleID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/leaderboard/"), "/")
+	if puzzleID == dailyPuzzleID {
+		puzzleID = todayID(r)
+	}
+	if !knownPuzzleID(puzzleID) {
+		sendError(w, http.StatusNotFound, requestError("No puzzle "+puzzleID))
+		return
+	}
+	rank := r.URL.Query().Get("rank")
+	if rank != "" && rank != "time" && rank != "score" {
+		sendError(w, http.StatusBadRequest, fieldError("rank", "Leaderboards are ranked by time or score"))
+		return
+	}
+	lb, e := readLeaderboard(r.Context(), puzzleID)
+	if e != nil {
+		if refuseExpired(w, r) {
+			return
+		}
+		sendError(w, http.StatusInternalServerError, requestError("Can't read leaderboard: "+e.Error()))
+		return
+	}
+	if rank == "score" {
+		rankByScore(lb)
+	}
+	lo, hi, ok := pageOf(w, r, len(lb.Entries), rankKey)
+	if !ok {
+		return
+	}
+	lb.Entries = lb.Entries[lo:hi]
+	llb := newLocalizer(w, r).leaderboard(lb)
+	llb.First = lo + 1
+	sendJSON(w, http.StatusOK, llb)
+}
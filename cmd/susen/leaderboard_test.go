@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// helperSolve fills in the empty squares of a session's puzzle
+// with its solution.
+func helperSolve(t *testing.T, srv *httptest.Server, session *susenSession) {
+	p, e := puzzle.New(session.values)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	solution := p.Solutions()[0].Values
+	for i, s := range p.Squares() {
+		if s.Aval == 0 {
+			if status := helperRoomAssign(t, srv, puzzle.Choice{Index: i + 1, Value: solution[i]}); status != http.StatusOK {
+				t.Fatalf("Assign to square %d gave status %d", i+1, status)
+			}
+		}
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-leaderboard")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var lb leaderboard
+	if status := helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb); status != http.StatusOK || len(lb.Entries) != 0 {
+		t.Errorf("Empty leaderboard gave %d, %+v", status, lb)
+	}
+	if status := helperGetJSON(t, srv, "/api/leaderboard/no-such-puzzle", &lb); status != http.StatusNotFound {
+		t.Errorf("Leaderboard for unknown puzzle gave status %d", status)
+	}
+
+	// solving the puzzle enters it, without the session key
+	helperSolve(t, srv, session)
+	helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb)
+	if len(lb.Entries) != 1 || lb.Entries[0].Name != "anonymous" || lb.Entries[0].Key != "" ||
+		lb.Entries[0].Moves != session.stats.Assignments || lb.Entries[0].SolveTime != session.stats.SolveTime {
+		t.Fatalf("Leaderboard after solve is %+v", lb)
+	}
+
+	// entries are ranked by time then moves, one per player
+	for _, entry := range []leaderboardEntry{
+		{Key: "test:b", Name: "b", SolveTime: 1e6, Moves: 60},
+		{Key: "test:a", Name: "a", SolveTime: 1e6, Moves: 50},
+		{Key: "test:b", Name: "b", SolveTime: 2e6, Moves: 40},
+		{Key: "test:c", Name: "c", SolveTime: 3e6},
+		{Key: "test:c", Name: "c", SolveTime: 0.5e6},
+	} {
+		addLeaderboardEntry(defaultPuzzleID, entry)
+	}
+	helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb)
+	var names []string
+	for _, entry := range lb.Entries {
+		names = append(names, entry.Name)
+	}
+	if len(names) != 4 || names[0] != "anonymous" || names[1] != "c" || names[2] != "a" || names[3] != "b" ||
+		lb.Entries[3].Moves != 60 {
+		t.Errorf("Leaderboard is %+v", lb)
+	}
+}
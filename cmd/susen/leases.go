@@ -0,0 +1,346 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Session leases
+
+A lone server keeps its sessions in memory, but a deployment
+with several servers (such as more than one Heroku dyno sharing
+a SQL store) can get a session's requests at any of them, and
+two servers must never change the same session at once.  So,
+given a session lease time (the sessionLease setting in
+server.go), a server only serves a session while it holds the
+session's lease: a record in the store naming the server, and
+when the lease runs out.
+
+- A server takes a session's lease when it gets a request for
+the session and no other server holds it (or the holder's lease
+has run out).  It then restores the session from its checkpoint
+(see shutdown.go), if there is one, since the last holder may
+have changed it.
+
+- A request renews its session's lease once a quarter of it has
+gone, and checkpoints the session, so a server taking over from
+one that died loses at most a lease's worth of play.
+
+- A session that goes without requests until half its lease has
+gone is checkpointed, its lease is released, and the server
+forgets it, so whichever server gets its next request can take
+it at once.  Requests get at least a quarter of the lease to
+finish before that can happen, so the lease should be well over
+the write timeout.
+
+- A request for a session whose lease another server holds gets
+a 503 response whose Retry-After is the time the lease has left,
+and whose Susen-Session-Holder header names the holder.  The
+holder is the session's affinity: with Heroku's session affinity
+(or a balancer that routes on the header), a browser's requests
+keep going to the server that holds its session, so this only
+happens as servers come and go.
+
+Leases are taken, renewed, and released by swapping their
+records (see storage/swap.go), so two servers can't both take
+one.  With leases, a server doesn't restore every checkpoint at
+startup, as a lone server does, since sessions are restored as
+they're taken; at shutdown, it checkpoints its sessions and
+releases their leases.  Sessions are leased by session ID.  A
+user's session (see accounts.go) is keyed by the user in the
+session table, but the store records which session it is (the
+first one the user was seen with), so a user's requests claim
+that session's lease, whichever browser or server they come
+from, and a server that doesn't have the session takes it over
+like any other.
+
+*/
+
+// leaseKind is the storage kind for session leases, which are
+// keyed by session ID.
+const leaseKind = "session-lease"
+
+// userSessionKind is the storage kind for the IDs of users'
+// sessions, which are keyed by user key.
+const userSessionKind = "user-session"
+
+// minSessionLease is the shortest session lease, which leaves
+// requests a second to finish.
+const minSessionLease = 4 * time.Second
+
+// sessionHolderHeader is the response header naming the server
+// that holds the lease of a refused request's session.
+const sessionHolderHeader = "Susen-Session-Holder"
+
+// A sessionLease is a server's lease on a session.
+type sessionLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaseMutex guards the leases this server holds, and is held
+// while they're taken, renewed, and released.
+var (
+	leaseTime   time.Duration // zero if sessions aren't leased
+	leaseHolder = leaseHolderName()
+	leaseMutex  sync.Mutex
+	leases      = make(map[string]sessionLease) // by session ID
+)
+
+// leaseHolderName returns this server's name in its leases: its
+// dyno (or host) name, and when it started, since a restarted
+// dyno keeps its name.
+func leaseHolderName() string {
+	name := os.Getenv("DYNO")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return name + "/" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// startLeasing has the server lease its sessions for the given
+// time, releasing idle ones in the background.
+func startLeasing(lease time.Duration) {
+	leaseTime = lease
+	log.Printf("Leasing sessions for %v as %s.", lease, leaseHolder)
+	go func() {
+		for now := range time.Tick(lease / 4) {
+			if n := releaseIdleLeases(now); n > 0 {
+				debugf("Released %d idle session leases.", n)
+			}
+		}
+	}()
+}
+
+// claimSession returns the session with the given ID, starting
+// it if there isn't one, once this server holds its lease
+// (taking or renewing the lease if need be).  If another server
+// holds the lease, that lease is returned instead of the session.
+func claimSession(sessionID string) (*susenSession, *sessionLease, error) {
+	if leaseTime == 0 {
+		return sessionFor(sessionID), nil, nil
+	}
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+	now := time.Now()
+	held, holding := leases[sessionID]
+	if holding && held.Expires.Sub(now) > leaseTime*3/4 {
+		return sessionFor(sessionID), nil, nil
+	}
+	var current sessionLease
+	found, e := store.Get(leaseKind, sessionID, &current)
+	if e != nil {
+		return nil, nil, e
+	}
+	if found && current.Holder != leaseHolder && now.Before(current.Expires) {
+		loseLease(sessionID) // it ran out, and was taken
+		return nil, &current, nil
+	}
+	var old interface{}
+	if found {
+		old = current
+	}
+	next := sessionLease{Holder: leaseHolder, Expires: now.Add(leaseTime)}
+	if ok, e := storage.Swap(store, leaseKind, sessionID, old, next); e != nil {
+		return nil, nil, e
+	} else if !ok {
+		// another server got there first
+		loseLease(sessionID)
+		if _, e := store.Get(leaseKind, sessionID, &current); e != nil {
+			return nil, nil, e
+		}
+		return nil, &current, nil
+	}
+	leases[sessionID] = next
+	if holding && found && current.Holder == leaseHolder && current.Expires.Equal(held.Expires) {
+		// nobody has had it since this server did
+		if session := leasedSession(sessionID); session != nil {
+			if _, e := saveCheckpoint(session, sessionKeys(session)); e != nil {
+				log.Printf("Failed to checkpoint session %v: %v", sessionID, e)
+			}
+		}
+		return sessionFor(sessionID), nil, nil
+	}
+	if e := takeSession(sessionID); e != nil {
+		return nil, nil, e
+	}
+	return sessionFor(sessionID), nil, nil
+}
+
+// claimUserSession returns the session of the user with the
+// given key in the session table, once this server holds its
+// lease, as claimSession does.  If the user doesn't have a
+// session yet, the given one becomes theirs.
+func claimUserSession(key string, session *susenSession) (*susenSession, *sessionLease, error) {
+	sessionMutex.Lock()
+	us, ok := sessions[key]
+	if !ok && leaseTime == 0 {
+		sessions[key], us = session, session
+	}
+	sessionMutex.Unlock()
+	if leaseTime == 0 {
+		return us, nil, nil
+	}
+	sessionID := session.sessionID
+	if ok {
+		sessionID = us.sessionID
+	} else {
+		userKey := strings.TrimPrefix(key, "user:")
+		if ok, e := storage.Swap(store, userSessionKind, userKey, nil, sessionID); e != nil {
+			return nil, nil, e
+		} else if !ok {
+			// the user already has one
+			if _, e := store.Get(userSessionKind, userKey, &sessionID); e != nil {
+				return nil, nil, e
+			}
+		}
+	}
+	us, held, e := claimSession(sessionID)
+	if us == nil {
+		return nil, held, e
+	}
+	sessionMutex.Lock()
+	sessions[key] = us
+	sessionMutex.Unlock()
+	return us, nil, nil
+}
+
+// takeSession replaces this server's copy of a session whose
+// lease it has just taken with the session's checkpoint, if it
+// has one.
+func takeSession(sessionID string) error {
+	c, _, found, e := loadCheckpoint(sessionID)
+	if e != nil {
+		return e
+	}
+	forgetSession(sessionID)
+	if !found {
+		return nil
+	}
+	session, e := c.restore(sessionID)
+	if e != nil {
+		log.Printf("Can't restore session %v: %v", sessionID, e)
+		return nil // it starts over
+	}
+	sessionMutex.Lock()
+	for _, key := range append(c.Keys, sessionID) {
+		sessions[key] = session
+	}
+	sessionMutex.Unlock()
+	return nil
+}
+
+// leasedSession returns this server's copy of a session, if it
+// has one.
+func leasedSession(sessionID string) *susenSession {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return sessions[sessionID]
+}
+
+// sessionKeys returns a session's keys in the session table.
+func sessionKeys(session *susenSession) []string {
+	var keys []string
+	sessionMutex.RLock()
+	for key, s := range sessions {
+		if s == session {
+			keys = append(keys, key)
+		}
+	}
+	sessionMutex.RUnlock()
+	return keys
+}
+
+// forgetSession removes a session from the session table, under
+// all its keys.
+func forgetSession(sessionID string) {
+	session := leasedSession(sessionID)
+	if session == nil {
+		return
+	}
+	sessionMutex.Lock()
+	for key, s := range sessions {
+		if s == session {
+			delete(sessions, key)
+		}
+	}
+	sessionMutex.Unlock()
+	session.stopSpectating()
+}
+
+// loseLease forgets a session whose lease another server has
+// taken.
+func loseLease(sessionID string) {
+	if _, ok := leases[sessionID]; ok {
+		delete(leases, sessionID)
+		forgetSession(sessionID)
+		log.Printf("Lost the lease of session %v.", sessionID)
+	}
+}
+
+// releaseIdleLeases checkpoints the sessions whose leases are
+// half gone, releases their leases, and forgets them, returning
+// how many were released.  Sessions that can't be checkpointed
+// keep their leases, for the next try.
+func releaseIdleLeases(now time.Time) int {
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+	released := 0
+	for sessionID, held := range leases {
+		if held.Expires.Sub(now) > leaseTime/2 {
+			continue
+		}
+		if session := leasedSession(sessionID); session != nil {
+			if _, e := saveCheckpoint(session, sessionKeys(session)); e != nil {
+				log.Printf("Failed to checkpoint session %v: %v", sessionID, e)
+				continue
+			}
+		}
+		forgetSession(sessionID)
+		releaseLease(sessionID, held)
+		released++
+	}
+	return released
+}
+
+// releaseLeases releases all the leases this server holds, once
+// their sessions have been checkpointed.
+func releaseLeases() {
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+	for sessionID, held := range leases {
+		releaseLease(sessionID, held)
+	}
+}
+
+// releaseLease releases one of this server's leases, unless
+// another server has already taken it.
+func releaseLease(sessionID string, held sessionLease) {
+	delete(leases, sessionID)
+	if _, e := storage.Swap(store, leaseKind, sessionID, held, nil); e != nil {
+		log.Printf("Can't release the lease of session %v: %v", sessionID, e)
+	}
+}
+
+// refuseLeased sends the response to a request whose session
+// couldn't be claimed, because another server holds its lease or
+// the lease couldn't be checked.
+func refuseLeased(w http.ResponseWriter, held *sessionLease, e error) {
+	if e != nil {
+		log.Printf("Can't check a session lease: %v", e)
+		sendError(w, http.StatusServiceUnavailable, requestError("Sessions are unavailable"))
+		return
+	}
+	secs := int(time.Until(held.Expires)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	w.Header().Set(sessionHolderHeader, held.Holder)
+	sendError(w, http.StatusServiceUnavailable, requestError("The session is in use on another server"))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionLeases(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	leaseTime = time.Minute
+	defer func() { store, leaseTime, leases = saved, 0, make(map[string]sessionLease) }()
+	const id = "test-session-lease"
+	var lease sessionLease
+
+	// the first request takes the lease
+	session, held, e := claimSession(id)
+	if session == nil || held != nil || e != nil {
+		t.Fatalf("First claim gave %v, %+v, %v", session, held, e)
+	}
+	if found, _ := store.Get(leaseKind, id, &lease); !found || lease.Holder != leaseHolder {
+		t.Fatalf("Lease after the first claim is %v, %+v", found, lease)
+	}
+	if again, _, _ := claimSession(id); again != session {
+		t.Errorf("Second claim gave a different session")
+	}
+	session.reset(defaultPuzzleID)
+
+	// renewing a lease checkpoints its session
+	aged := sessionLease{Holder: leaseHolder, Expires: time.Now().Add(leaseTime / 2)}
+	store.Put(leaseKind, id, aged)
+	leases[id] = aged
+	if again, _, e := claimSession(id); again != session || e != nil {
+		t.Fatalf("Renewing claim gave %v, %v", again, e)
+	}
+	c, _, found, _ := loadCheckpoint(id)
+	if !found || c.PuzzleID != defaultPuzzleID {
+		t.Errorf("Checkpoint after renewal is %v, %+v", found, c)
+	}
+	if store.Get(leaseKind, id, &lease); !lease.Expires.After(aged.Expires) {
+		t.Errorf("Renewed lease is %+v", lease)
+	}
+
+	// another server that takes over a lapsed lease has the session
+	leases[id] = sessionLease{Holder: leaseHolder, Expires: time.Now().Add(-time.Second)}
+	other := sessionLease{Holder: "web.2/test", Expires: time.Now().Add(leaseTime)}
+	store.Put(leaseKind, id, other)
+	lost, held, e := claimSession(id)
+	if lost != nil || held == nil || held.Holder != other.Holder || e != nil {
+		t.Fatalf("Claim of a taken session gave %v, %+v, %v", lost, held, e)
+	}
+	if leasedSession(id) != nil {
+		t.Errorf("Session whose lease was lost is still here")
+	}
+	w := httptest.NewRecorder()
+	refuseLeased(w, held, nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get(sessionHolderHeader) != other.Holder ||
+		w.Header().Get("Retry-After") == "" {
+		t.Errorf("Refusal gave %d, %v", w.Code, w.Header())
+	}
+
+	// and when it lets the session go, taking it gets its changes
+	c.PuzzleID = "changed-elsewhere"
+	store.Put(checkpointKind, id, c)
+	store.Delete(leaseKind, id)
+	session, held, e = claimSession(id)
+	if session == nil || held != nil || e != nil || session.puzzleID != "changed-elsewhere" {
+		t.Fatalf("Claim of a released session gave %v, %+v, %v", session, held, e)
+	}
+
+	// idle sessions are checkpointed and released
+	if n := releaseIdleLeases(time.Now()); n != 0 {
+		t.Errorf("Released %d fresh leases", n)
+	}
+	if n := releaseIdleLeases(time.Now().Add(leaseTime)); n != 1 {
+		t.Errorf("Released %d idle leases", n)
+	}
+	if found, _ := store.Get(leaseKind, id, &lease); found || leasedSession(id) != nil {
+		t.Errorf("Released lease is still there: %v, %+v", found, lease)
+	}
+	if c, _, found, _ := loadCheckpoint(id); !found || c.PuzzleID != "changed-elsewhere" {
+		t.Errorf("Checkpoint after release is %v, %+v", found, c)
+	}
+
+	// shutdown releases them all
+	claimSession(id)
+	releaseLeases()
+	if found, _ := store.Get(leaseKind, id, &lease); found || len(leases) != 0 {
+		t.Errorf("Leases after shutdown are %v, %+v", found, leases)
+	}
+	forgetSession(id)
+}
+
+func TestUserSessionLeases(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	leaseTime = time.Minute
+	defer func() { store, leaseTime, leases = saved, 0, make(map[string]sessionLease) }()
+	const idA, idB = "test-user-lease-a", "test-user-lease-b"
+	defer forgetSession(idA)
+	defer forgetSession(idB)
+	user := &auth.User{ID: "lea", Name: "Lea", Source: "header"}
+
+	// the first session a user is seen with is theirs, and leased
+	a, _, _ := claimSession(idA)
+	if us, held, e := userSession(a, user); us != a || held != nil || e != nil {
+		t.Fatalf("First user session gave %v, %+v, %v", us, held, e)
+	}
+	var owner string
+	if found, _ := store.Get(userSessionKind, user.Key(), &owner); !found || owner != idA {
+		t.Errorf("User session recorded as %v, %q", found, owner)
+	}
+	b, _, _ := claimSession(idB)
+	if us, _, _ := userSession(b, user); us != a {
+		t.Errorf("User's other browser got session %v", us)
+	}
+
+	// when another server holds the user's session, requests
+	// from their other browsers are refused
+	forgetSession(idA)
+	delete(leases, idA)
+	other := sessionLease{Holder: "web.2/test", Expires: time.Now().Add(leaseTime)}
+	store.Put(leaseKind, idA, other)
+	if us, held, e := userSession(b, user); us != nil || held == nil || held.Holder != other.Holder || e != nil {
+		t.Fatalf("User session held elsewhere gave %v, %+v, %v", us, held, e)
+	}
+
+	// and once it lets the session go, this server takes it over
+	store.Delete(leaseKind, idA)
+	us, held, e := userSession(b, user)
+	if us == nil || us.sessionID != idA || held != nil || e != nil {
+		t.Fatalf("Released user session gave %v, %+v, %v", us, held, e)
+	}
+	if _, ok := leases[idA]; !ok {
+		t.Errorf("User session was taken without its lease")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Listeners
+
+The server always listens on its address and port, with TLS if
+it has a certificate and key (see server.go).  The listen
+setting gives it more listeners, so it can listen on IPv4 and
+IPv6 addresses at once, or on a Unix domain socket for a reverse
+proxy on the same host.  The setting is a comma-separated list
+of listeners, each an address (host:port, with IPv6 hosts in
+brackets) or unix: and a socket path, followed by any of these
+options, each after a semicolon:
+
+	tls             use TLS, with the server's certificate and key
+	notls           don't use TLS
+	cert=<file>     use TLS, with this certificate
+	key=<file>      and this key
+
+For example, with a certificate and key configured, this serves
+TLS on the IPv4 and IPv6 loopback addresses, and plain HTTP to
+a proxy on a socket:
+
+	address: 127.0.0.1
+	port: 8443
+	listen: "[::1]:8443, unix:/run/susen/susen.sock"
+
+Addresses use TLS if the server does, unless they say notls, and
+sockets don't, unless they say tls (or have their own
+certificate).  A stale socket file from an earlier run is
+replaced, but other files aren't, and the socket is removed when
+the server shuts down.  All the listeners serve the same
+handlers, and stop together when the server shuts down.
+
+*/
+
+// A listenerConfig is one of the places the server listens.
+type listenerConfig struct {
+	Network string // tcp or unix
+	Address string
+	TLS     string // on, off, or empty for the default
+	TLSCert string // if it isn't the server's
+	TLSKey  string
+}
+
+// String gives the listener as it's written in the setting.
+func (l listenerConfig) String() string {
+	if l.Network == "unix" {
+		return "unix:" + l.Address
+	}
+	return l.Address
+}
+
+// parseListeners parses the listen setting.
+func parseListeners(v string) ([]listenerConfig, error) {
+	var result []listenerConfig
+	for _, spec := range strings.Split(v, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ";")
+		l := listenerConfig{Network: "tcp", Address: strings.TrimSpace(parts[0])}
+		if strings.HasPrefix(l.Address, "unix:") {
+			l.Network, l.Address = "unix", strings.TrimPrefix(l.Address, "unix:")
+			if l.Address == "" {
+				return nil, fmt.Errorf("listener %q has no socket path", spec)
+			}
+		} else if host, port, e := net.SplitHostPort(l.Address); e != nil {
+			return nil, fmt.Errorf("invalid listener address %q", l.Address)
+		} else if _, e := parsePort(port, false); e != nil {
+			return nil, e
+		} else if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid listener address %q", l.Address)
+		}
+		for _, option := range parts[1:] {
+			option = strings.TrimSpace(option)
+			switch {
+			case option == "tls":
+				l.TLS = "on"
+			case option == "notls":
+				l.TLS = "off"
+			case strings.HasPrefix(option, "cert="):
+				l.TLS, l.TLSCert = "on", strings.TrimPrefix(option, "cert=")
+			case strings.HasPrefix(option, "key="):
+				l.TLS, l.TLSKey = "on", strings.TrimPrefix(option, "key=")
+			default:
+				return nil, fmt.Errorf("listener %q has unknown option %q", l, option)
+			}
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
+
+// listeners returns all the configuration's listeners, starting
+// with its address and port, with their TLS settings filled in.
+func (c serverConfig) listeners() []listenerConfig {
+	all := append([]listenerConfig{{Network: "tcp", Address: net.JoinHostPort(c.Address, strconv.Itoa(c.Port))}},
+		c.Listen...)
+	for i := range all {
+		l := &all[i]
+		if l.TLS == "" {
+			l.TLS = "off"
+			if l.Network == "tcp" && c.tls() {
+				l.TLS = "on"
+			}
+		}
+		if l.TLS == "off" {
+			l.TLSCert, l.TLSKey = "", ""
+		} else if l.TLSCert == "" && l.TLSKey == "" {
+			l.TLSCert, l.TLSKey = c.TLSCert, c.TLSKey
+		}
+	}
+	return all
+}
+
+// checkListeners checks that the configuration's listeners can
+// be used.
+func (c serverConfig) checkListeners() error {
+	seen := make(map[string]bool)
+	for _, l := range c.listeners() {
+		if seen[l.String()] {
+			return fmt.Errorf("listener %q is configured twice", l)
+		}
+		seen[l.String()] = true
+		if l.TLS != "on" {
+			continue
+		}
+		if l.TLSCert == "" || l.TLSKey == "" {
+			return fmt.Errorf("listener %q needs both a certificate and a key file for TLS", l)
+		}
+		for _, file := range []string{l.TLSCert, l.TLSKey} {
+			if _, e := os.Stat(file); e != nil {
+				return fmt.Errorf("listener %q can't use TLS file: %v", l, e)
+			}
+		}
+	}
+	return nil
+}
+
+// listen opens a listener.
+func (l listenerConfig) listen() (net.Listener, error) {
+	if l.Network == "unix" {
+		if info, e := os.Lstat(l.Address); e == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("%s exists and isn't a socket", l.Address)
+			}
+			os.Remove(l.Address) // left by an earlier run
+		}
+	}
+	ln, e := net.Listen(l.Network, l.Address)
+	if e != nil || l.TLS != "on" {
+		return ln, e
+	}
+	cert, e := tls.LoadX509KeyPair(l.TLSCert, l.TLSKey)
+	if e != nil {
+		ln.Close()
+		return nil, e
+	}
+	return tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}), nil
+}
+
+// openListeners opens all the configuration's listeners, or
+// none of them if any can't be opened.
+func (c serverConfig) openListeners() ([]net.Listener, error) {
+	var opened []net.Listener
+	for _, l := range c.listeners() {
+		ln, e := l.listen()
+		if e != nil {
+			for _, ln := range opened {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("can't listen on %s: %v", l, e)
+		}
+		if l.TLS == "on" {
+			log.Printf("Listening with TLS on %s...", l)
+		} else {
+			log.Printf("Listening on %s...", l)
+		}
+		opened = append(opened, ln)
+	}
+	return opened, nil
+}
+
+// serveListeners serves on all the listeners until the server is
+// shut down, and returns http.ErrServerClosed, or until one of
+// them fails, and returns its error.
+func serveListeners(srv *http.Server, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) { errs <- srv.Serve(ln) }(ln)
+	}
+	for range listeners {
+		if e := <-errs; e != http.ErrServerClosed {
+			return e
+		}
+	}
+	return http.ErrServerClosed
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// helperCertificate writes a self-signed certificate for
+// 127.0.0.1 and its key to files in dir.
+func helperCertificate(t *testing.T, dir string) (string, string) {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatalf("Can't make a key: %v", e)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, e := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if e != nil {
+		t.Fatalf("Can't make a certificate: %v", e)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	cert, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return cert, keyFile
+}
+
+func TestParseListeners(t *testing.T) {
+	ls, e := parseListeners(" [::1]:8443;notls, unix:/run/susen.sock;tls ,0.0.0.0:9000;cert=c.pem;key=k.pem,")
+	expected := []listenerConfig{
+		{Network: "tcp", Address: "[::1]:8443", TLS: "off"},
+		{Network: "unix", Address: "/run/susen.sock", TLS: "on"},
+		{Network: "tcp", Address: "0.0.0.0:9000", TLS: "on", TLSCert: "c.pem", TLSKey: "k.pem"},
+	}
+	if e != nil || len(ls) != len(expected) {
+		t.Fatalf("Parsed listeners are %+v, %v", ls, e)
+	}
+	for i := range expected {
+		if ls[i] != expected[i] {
+			t.Errorf("Listener %d is %+v", i, ls[i])
+		}
+	}
+	for _, bad := range []string{"8443", "::1:8443", "[::1]:0", "unix:", "localhost:80;fast"} {
+		if _, e := parseListeners(bad); e == nil {
+			t.Errorf("Listener %q was accepted", bad)
+		}
+	}
+}
+
+func TestListenerConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := helperCertificate(t, dir)
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+	flags := []string{"-tls-cert", cert, "-tls-key", key, "-address", "127.0.0.1"}
+
+	c, e := loadServerConfig(append(flags, "-listen", "[::1]:8080, unix:"+dir+"/s.sock"), env(nil))
+	if e != nil {
+		t.Fatalf("Config with listeners failed: %v", e)
+	}
+	ls := c.listeners()
+	if len(ls) != 3 || ls[0].Address != "127.0.0.1:8080" || ls[0].TLS != "on" || ls[1].TLS != "on" ||
+		ls[1].TLSCert != cert || ls[2].TLS != "off" || ls[2].TLSCert != "" {
+		t.Errorf("Listeners are %+v", ls)
+	}
+	if _, e := loadServerConfig(append(flags, "-listen", "127.0.0.1:8080"), env(nil)); e == nil {
+		t.Errorf("Config listening twice on an address was accepted")
+	}
+	if _, e := loadServerConfig([]string{"-listen", "unix:/tmp/s.sock;tls"}, env(nil)); e == nil {
+		t.Errorf("Config with TLS but no certificate was accepted")
+	}
+	if _, e := loadServerConfig([]string{"-listen", "[::1]:8443;cert=" + cert}, env(nil)); e == nil {
+		t.Errorf("Config with a certificate but no key was accepted")
+	}
+	if _, e := loadServerConfig(nil, env(map[string]string{"SUSEN_LISTEN": "[::1]:8443;cert=none.pem;key=" + key})); e == nil {
+		t.Errorf("Config with a missing certificate was accepted")
+	}
+}
+
+func TestServeListeners(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := helperCertificate(t, dir)
+	socket := filepath.Join(dir, "susen.sock")
+	c := serverConfig{Address: "127.0.0.1", Port: 0, Listen: []listenerConfig{
+		{Network: "tcp", Address: "127.0.0.1:0", TLSCert: cert, TLSKey: key, TLS: "on"},
+		{Network: "unix", Address: socket},
+	}}
+	listeners, e := c.openListeners()
+	if e != nil {
+		t.Fatalf("Can't open listeners: %v", e)
+	}
+	srv := c.server(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("X-Test-TLS", "yes")
+		}
+	}))
+	served := make(chan error)
+	go func() { served <- serveListeners(srv, listeners) }()
+
+	plain := &http.Client{}
+	secure := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	unix := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	for i, c := range []struct {
+		client *http.Client
+		url    string
+		tls    bool
+	}{
+		{plain, "http://" + listeners[0].Addr().String() + "/", false},
+		{secure, "https://" + listeners[1].Addr().String() + "/", true},
+		{unix, "http://susen/", false},
+	} {
+		r, e := c.client.Get(c.url)
+		if e != nil {
+			t.Errorf("Listener %d request failed: %v", i, e)
+			continue
+		}
+		r.Body.Close()
+		if (r.Header.Get("X-Test-TLS") == "yes") != c.tls {
+			t.Errorf("Listener %d TLS is %q", i, r.Header.Get("X-Test-TLS"))
+		}
+	}
+
+	srv.Shutdown(context.Background())
+	if e := <-served; e != http.ErrServerClosed {
+		t.Errorf("Serving ended with %v", e)
+	}
+
+	// a stale socket is replaced, but other files aren't
+	ioutil.WriteFile(filepath.Join(dir, "file.sock"), nil, 0600)
+	if _, e := (listenerConfig{Network: "unix", Address: filepath.Join(dir, "file.sock")}).listen(); e == nil {
+		t.Errorf("Listening on a file succeeded")
+	}
+	stale, _ := net.Listen("unix", socket)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if ln, e := (listenerConfig{Network: "unix", Address: socket}).listen(); e != nil {
+		t.Errorf("Listening on a stale socket failed: %v", e)
+	} else {
+		ln.Close()
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Locales
+
+Statistics and leaderboard responses carry, along with their
+raw fields (seconds, RFC 3339 times, plain numbers), a "display"
+object with the same times, durations, dates, and numbers
+formatted for the player's locale, so that clients don't each
+have to format them.  Dates and times are shown in the player's
+daily zone (see daily.go), and the response's "locale" field
+says which locale was used.
+
+The locale is the one the player chose with the locale
+endpoint (see localeHandler), if they did, and otherwise the
+best match for the request's Accept-Language header among the
+supported locales (see localeFormats), defaulting to
+defaultLocale.  Like daily zones, identified users' choices are
+kept in the store, and anonymous players' in a cookie.
+
+The locale also chooses the language of error messages and of
+the technique names in hints and explanations (see
+puzzle.MessageCatalog), for locales whose languages have
+catalogs; the others get English.  It's settled once for each
+request (see localizeRequest), and every response says what it
+is in its Content-Language header.  Error codes and technique
+identifiers are the same in every locale.
+
+*/
+
+// A localeFormat says how a locale writes dates, times of day,
+// and numbers.  The date and time are time layouts.
+type localeFormat struct {
+	date, time     string
+	decimal, group string // separators
+}
+
+// localeFormats are the supported locales, by language tag.
+// Languages without their own region use the format of the
+// first region (see localeDefaults).
+var localeFormats = map[string]localeFormat{
+	"en-US": {"1/2/2006", "3:04 PM", ".", ","},
+	"en-GB": {"02/01/2006", "15:04", ".", ","},
+	"de":    {"02.01.2006", "15:04", ",", "."},
+	"es":    {"2/1/2006", "15:04", ",", "."},
+	"fr":    {"02/01/2006", "15:04", ",", "\u202f"}, // narrow no-break space
+	"ja":    {"2006/01/02", "15:04", ".", ","},
+}
+
+// localeDefaults are the locales for languages that only have
+// regional ones, and defaultLocale is the locale of requests
+// that don't match any.
+var localeDefaults = map[string]string{"en": "en-US"}
+
+const defaultLocale = "en-US"
+
+// The locale of a user's choice is kept in the store under
+// localeKind, and an anonymous player's in the localeCookie.
+const (
+	localeKind   = "user-locale"
+	localeCookie = "susenLocale"
+)
+
+// matchLocale returns the supported locale for a language tag:
+// the one with the same tag, or else the one for its language.
+func matchLocale(tag string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	for _, try := range []string{tag, strings.SplitN(tag, "-", 2)[0]} {
+		for name := range localeFormats {
+			if strings.EqualFold(name, try) {
+				return name, true
+			}
+		}
+		if name, ok := localeDefaults[strings.ToLower(try)]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// acceptedLocale returns the supported locale that best matches
+// an Accept-Language header, in the order of its quality values.
+func acceptedLocale(header string) (string, bool) {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		w := weighted{strings.TrimSpace(fields[0]), 1}
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if q, e := strconv.ParseFloat(param[2:], 64); e == nil {
+					w.q = q
+				}
+			}
+		}
+		if w.tag != "" && w.tag != "*" && w.q > 0 {
+			tags = append(tags, w)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, w := range tags {
+		if name, ok := matchLocale(w.tag); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// requestLocale returns the locale for a request, and whether
+// the player chose it.
+func requestLocale(r *http.Request) (string, bool) {
+	name := ""
+	if user := auth.FromRequest(r); user != nil {
+		if _, e := store.Get(localeKind, user.Key(), &name); e != nil {
+			log.Printf("Can't read locale of %v: %v", user.Key(), e)
+		}
+	} else if c, e := r.Cookie(localeCookie); e == nil {
+		name = c.Value
+	}
+	if name, ok := matchLocale(name); ok {
+		return name, true
+	}
+	if name, ok := acceptedLocale(r.Header.Get("Accept-Language")); ok {
+		return name, false
+	}
+	return defaultLocale, false
+}
+
+// varyLanguage notes in a response that it varies with the
+// request's languages.
+func varyLanguage(w http.ResponseWriter) {
+	for _, v := range w.Header().Values("Vary") {
+		if v == "Accept-Language" {
+			return
+		}
+	}
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+// localizeRequest settles the locale of a request.  It's given
+// in the response's Content-Language header, where sendError
+// finds it, and it replaces the request's Accept-Language header,
+// where the puzzle package's handlers (and later calls of
+// requestLocale) find it.
+func localizeRequest(w http.ResponseWriter, r *http.Request) {
+	name, _ := requestLocale(r)
+	r.Header.Set("Accept-Language", name)
+	w.Header().Set("Content-Language", name)
+	varyLanguage(w)
+}
+
+// A localizer formats values for a request's locale and zone.
+type localizer struct {
+	locale string
+	format localeFormat
+	zone   *time.Location
+}
+
+// newLocalizer returns the localizer for a request, and notes in
+// the response that it varies with the request's languages.
+func newLocalizer(w http.ResponseWriter, r *http.Request) localizer {
+	varyLanguage(w)
+	name, _ := requestLocale(r)
+	return localizer{name, localeFormats[name], requestZone(r)}
+}
+
+// dateTime formats a time as a date and time of day.
+func (l localizer) dateTime(t time.Time) string {
+	t = t.In(l.zone)
+	return t.Format(l.format.date) + " " + t.Format(l.format.time)
+}
+
+// duration formats a number of seconds as minutes and seconds
+// (or hours, minutes, and seconds), to a tenth of a second.
+func (l localizer) duration(seconds float64) string {
+	tenths := int64(math.Round(seconds * 10))
+	s, m := (tenths/10)%60, (tenths/600)%60
+	var text string
+	if h := tenths / 36000; h > 0 {
+		text = fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	} else {
+		text = fmt.Sprintf("%d:%02d", m, s)
+	}
+	return text + l.format.decimal + strconv.FormatInt(tenths%10, 10)
+}
+
+// number formats a count, with its digits grouped by thousands.
+func (l localizer) number(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + l.format.group + digits[i:]
+	}
+	return sign + digits
+}
+
+// localizedStats are a board's statistics, with their display
+// forms.
+type localizedStats struct {
+	puzzleStats
+	Locale  string `json:"locale"`
+	Display struct {
+		Started   string `json:"started"`
+		Completed string `json:"completed,omitempty"`
+		SolveTime string `json:"solveTime,omitempty"`
+	} `json:"display"`
+}
+
+func (l localizer) stats(stats puzzleStats) localizedStats {
+	ls := localizedStats{puzzleStats: stats, Locale: l.locale}
+	ls.Display.Started = l.dateTime(stats.Started)
+	if stats.Completed != nil {
+		ls.Display.Completed = l.dateTime(*stats.Completed)
+		ls.Display.SolveTime = l.duration(stats.SolveTime)
+	}
+	return ls
+}
+
+// localizedTotals are a puzzle's totals, with their display
+// forms.
+type localizedTotals struct {
+	puzzleTotals
+	Locale  string `json:"locale"`
+	Display struct {
+		Completions string `json:"completions"`
+		MeanTime    string `json:"meanTime"`
+		BestTime    string `json:"bestTime"`
+	} `json:"display"`
+}
+
+func (l localizer) totals(totals puzzleTotals) localizedTotals {
+	lt := localizedTotals{puzzleTotals: totals, Locale: l.locale}
+	lt.Display.Completions = l.number(totals.Completions)
+	lt.Display.MeanTime = l.duration(totals.MeanTime)
+	lt.Display.BestTime = l.duration(totals.BestTime)
+	return lt
+}
+
+// A localizedLeaderboard is a leaderboard whose entries have
+// their display forms.
+type localizedLeaderboard struct {
+	Board   string           `json:"board"`
+	Locale  string           `json:"locale"`
+	First   int              `json:"first"` // the rank of the first entry on the page
+	Entries []localizedEntry `json:"entries"`
+}
+
+type localizedEntry struct {
+	leaderboardEntry
+	Display struct {
+		SolveTime string `json:"solveTime"`
+		Completed string `json:"completed"`
+	} `json:"display"`
+}
+
+func (l localizer) leaderboard(lb leaderboard) localizedLeaderboard {
+	llb := localizedLeaderboard{Board: lb.Board, Locale: l.locale, Entries: make([]localizedEntry, len(lb.Entries))}
+	for i, entry := range lb.Entries {
+		le := localizedEntry{leaderboardEntry: entry}
+		le.Display.SolveTime = l.duration(entry.SolveTime)
+		le.Display.Completed = l.dateTime(entry.Completed)
+		llb.Entries[i] = le
+	}
+	return llb
+}
+
+// A localeInfo describes a player's locale.
+type localeInfo struct {
+	Locale  string   `json:"locale"`
+	Chosen  bool     `json:"chosen"` // whether the player chose it
+	Locales []string `json:"locales"`
+}
+
+// newLocaleInfo returns the locale information for a locale.
+func newLocaleInfo(name string, chosen bool) localeInfo {
+	info := localeInfo{Locale: name, Chosen: chosen}
+	for name := range localeFormats {
+		info.Locales = append(info.Locales, name)
+	}
+	sort.Strings(info.Locales)
+	return info
+}
+
+// localeHandler handles the locale endpoint:
+//
+// - GET /api/locale/ gives the player's locale, whether they
+// chose it, and the supported locales
+//
+// - POST /api/locale/ with {"locale": "<language tag>"} chooses
+// the player's locale (the empty tag goes back to the request's
+// languages), and gives the same as GET
+func localeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		varyLanguage(w)
+		sendJSON(w, http.StatusOK, newLocaleInfo(requestLocale(r)))
+	case "POST":
+		var req struct {
+			Locale string `json:"locale"`
+		}
+		if e := decodeRequest(w, r, &req); e != nil && e != io.EOF {
+			sendError(w, requestStatus(e), requestError("Invalid locale request: "+e.Error()))
+			return
+		}
+		name := ""
+		if req.Locale != "" {
+			var ok bool
+			if name, ok = matchLocale(req.Locale); !ok {
+				sendError(w, http.StatusBadRequest, requestError("Unsupported locale: "+req.Locale))
+				return
+			}
+		}
+		if user := auth.FromRequest(r); user != nil {
+			if e := store.Put(localeKind, user.Key(), name); e != nil {
+				sendError(w, http.StatusInternalServerError, requestError("Can't save locale: "+e.Error()))
+				return
+			}
+		}
+		c := &http.Cookie{Name: localeCookie, Value: name, Path: cookiePath, MaxAge: cookieMaxAge}
+		if name == "" {
+			c.MaxAge = -1
+			name, _ = acceptedLocale(r.Header.Get("Accept-Language"))
+			if name == "" {
+				name = defaultLocale
+			}
+		}
+		http.SetCookie(w, sessionCookie.apply(c, r))
+		sendJSON(w, http.StatusOK, newLocaleInfo(name, req.Locale != ""))
+	default:
+		sendError(w, http.StatusMethodNotAllowed, requestError("Locales can only be read or chosen"))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperLocaleRequest makes a request with the given languages
+// (and cookies), returning the response, whose body is decoded
+// into out.
+func helperLocaleRequest(t *testing.T, srv *httptest.Server, method, path, languages string, body, out interface{}, cookies ...*http.Cookie) *http.Response {
+	var bs []byte
+	if body != nil {
+		bs, _ = json.Marshal(body)
+	}
+	req, e := http.NewRequest(method, srv.URL+path, bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Failed to make %s %s request: %v", method, path, e)
+	}
+	if languages != "" {
+		req.Header.Set("Accept-Language", languages)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	r, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatalf("%s %s request error: %v", method, path, e)
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusOK && out != nil {
+		if e := json.NewDecoder(r.Body).Decode(out); e != nil {
+			t.Fatalf("%s %s decode error: %v", method, path, e)
+		}
+	}
+	return r
+}
+
+func TestLocaleFormats(t *testing.T) {
+	for i, tc := range []struct {
+		header, locale string
+	}{
+		{"de-AT,de;q=0.9,en;q=0.8", "de"},
+		{"en-GB", "en-GB"},
+		{"en-AU,fr;q=0.5", "en-US"},
+		{"xx,fr;q=0.2,ja;q=0.7", "ja"},
+		{"FR-ca", "fr"},
+		{"de;q=0,es", "es"},
+		{"*", ""},
+		{"", ""},
+	} {
+		if name, _ := acceptedLocale(tc.header); name != tc.locale {
+			t.Errorf("test %d: %q matched locale %q", i+1, tc.header, name)
+		}
+	}
+
+	utc, _ := time.LoadLocation("UTC")
+	when := time.Date(2024, 3, 7, 15, 4, 0, 0, utc)
+	for i, tc := range []struct {
+		locale, dateTime, short, long, number string
+	}{
+		{"en-US", "3/7/2024 3:04 PM", "2:05.3", "1:02:03.5", "1,234,567"},
+		{"en-GB", "07/03/2024 15:04", "2:05.3", "1:02:03.5", "1,234,567"},
+		{"de", "07.03.2024 15:04", "2:05,3", "1:02:03,5", "1.234.567"},
+		{"fr", "07/03/2024 15:04", "2:05,3", "1:02:03,5", "1\u202f234\u202f567"},
+		{"ja", "2024/03/07 15:04", "2:05.3", "1:02:03.5", "1,234,567"},
+	} {
+		l := localizer{tc.locale, localeFormats[tc.locale], utc}
+		if s := l.dateTime(when); s != tc.dateTime {
+			t.Errorf("test %d: %s date and time is %q", i+1, tc.locale, s)
+		}
+		if s := l.duration(125.3); s != tc.short {
+			t.Errorf("test %d: %s short duration is %q", i+1, tc.locale, s)
+		}
+		if s := l.duration(3723.46); s != tc.long {
+			t.Errorf("test %d: %s long duration is %q", i+1, tc.locale, s)
+		}
+		if s := l.number(1234567); s != tc.number {
+			t.Errorf("test %d: %s number is %q", i+1, tc.locale, s)
+		}
+	}
+	if s := (localizer{"de", localeFormats["de"], utc}).number(-999); s != "-999" {
+		t.Errorf("Negative number is %q", s)
+	}
+}
+
+func TestLocale(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-locale")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	helperSolve(t, srv, session)
+
+	// responses are localized for the request's languages, and
+	// keep their raw fields
+	var stats localizedStats
+	r := helperLocaleRequest(t, srv, "GET", "/api/stats", "de-DE,en;q=0.5", nil, &stats)
+	if stats.Locale != "de" || stats.SolveTime != session.stats.SolveTime || stats.Completed == nil ||
+		stats.Display.SolveTime == "" || stats.Display.Completed == "" || r.Header.Get("Vary") != "Accept-Language" {
+		t.Errorf("German stats are %+v, varying with %q", stats, r.Header.Get("Vary"))
+	}
+	var totals localizedTotals
+	helperLocaleRequest(t, srv, "GET", "/api/stats/"+defaultPuzzleID, "", nil, &totals)
+	if totals.Locale != defaultLocale || totals.Completions != 1 || totals.Display.Completions != "1" ||
+		totals.Display.BestTime != (localizer{format: localeFormats[defaultLocale]}).duration(totals.BestTime) {
+		t.Errorf("Default totals are %+v", totals)
+	}
+	var lb localizedLeaderboard
+	helperLocaleRequest(t, srv, "GET", "/api/leaderboard/"+defaultPuzzleID, "ja", nil, &lb)
+	if lb.Locale != "ja" || len(lb.Entries) != 1 || lb.Entries[0].Name != "anonymous" ||
+		lb.Entries[0].Display.SolveTime == "" || lb.Entries[0].Display.Completed == "" {
+		t.Errorf("Japanese leaderboard is %+v", lb)
+	}
+
+	// anonymous players' choices are kept in a cookie
+	var info localeInfo
+	r = helperLocaleRequest(t, srv, "POST", "/api/locale/", "de", map[string]string{"locale": "fr-FR"}, &info)
+	var cookie *http.Cookie
+	for _, c := range r.Cookies() {
+		if c.Name == localeCookie {
+			cookie = c
+		}
+	}
+	if r.StatusCode != http.StatusOK || info.Locale != "fr" || !info.Chosen || cookie == nil || cookie.Value != "fr" {
+		t.Fatalf("Choosing French gave status %d, %+v, cookie %v", r.StatusCode, info, cookie)
+	}
+	helperLocaleRequest(t, srv, "GET", "/api/stats", "de", nil, &stats, cookie)
+	if stats.Locale != "fr" {
+		t.Errorf("Stats with a French cookie are in %q", stats.Locale)
+	}
+	if r := helperLocaleRequest(t, srv, "POST", "/api/locale/", "", map[string]string{"locale": "tlh"}, nil); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Choosing an unsupported locale gave status %d", r.StatusCode)
+	}
+	r = helperLocaleRequest(t, srv, "POST", "/api/locale/", "es", map[string]string{"locale": ""}, &info, cookie)
+	if info.Locale != "es" || info.Chosen || len(r.Cookies()) == 0 || r.Cookies()[0].MaxAge >= 0 {
+		t.Errorf("Clearing the locale gave %+v, cookies %v", info, r.Cookies())
+	}
+
+	// users' choices are kept in the store
+	if status := helperUserRequest(t, srv, "lou", "POST", "/api/locale/", map[string]string{"locale": "en-gb"}, &info); status != http.StatusOK ||
+		info.Locale != "en-GB" || !info.Chosen {
+		t.Fatalf("User choosing British English gave status %d, %+v", status, info)
+	}
+	if helperUserRequest(t, srv, "lou", "GET", "/api/locale/", nil, &info); info.Locale != "en-GB" || !info.Chosen || len(info.Locales) != len(localeFormats) {
+		t.Errorf("User's locale is %+v", info)
+	}
+	if helperUserRequest(t, srv, "", "GET", "/api/locale/", nil, &info); info.Locale != defaultLocale || info.Chosen {
+		t.Errorf("Anonymous locale is %+v", info)
+	}
+}
+
+func TestLocalizedMessages(t *testing.T) {
+	session := newSession("test-localized-messages")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		localizeRequest(w, r)
+		session.rootHandler(w, r)
+	}))
+	defer srv.Close()
+
+	// errors are in the request's language
+	req, _ := http.NewRequest("POST", srv.URL+"/api/autofill/bogus", nil)
+	req.Header.Set("Accept-Language", "de-DE,en;q=0.5")
+	r, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatalf("Autofill request error: %v", e)
+	}
+	var err puzzle.Error
+	json.NewDecoder(r.Body).Decode(&err)
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound || r.Header.Get("Content-Language") != "de" ||
+		!strings.HasPrefix(err.Message, "Ungültige Anfrage: ") || err.Scope != puzzle.RequestScope {
+		t.Errorf("German error gave %d in %q: %+v", r.StatusCode, r.Header.Get("Content-Language"), err)
+	}
+
+	// and so are technique names, next to their codes
+	var hint hintResponse
+	helperLocaleRequest(t, srv, "GET", "/api/hint/", "fr", nil, &hint)
+	if hint.Hint.Technique == "" || hint.TechniqueName != puzzle.TechniqueName(hint.Hint.Technique, "fr") {
+		t.Errorf("French hint is %+v", hint)
+	}
+	var steps []explainedStep
+	helperLocaleRequest(t, srv, "GET", "/api/explain/", "es", nil, &steps)
+	if len(steps) == 0 || steps[0].TechniqueName != puzzle.TechniqueName(steps[0].Technique, "es") {
+		t.Errorf("Spanish explanation is %+v", steps)
+	}
+}
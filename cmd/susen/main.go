@@ -1,28 +1,120 @@
 package main
 
 import (
+	"crypto/rand"
+	"github.com/ancientHacker/susen.go/auth"
 	"github.com/ancientHacker/susen.go/client"
 	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	cookieName   = "susenID"
 	cookiePath   = "/"
 	cookieMaxAge = 3600 * 24 * 7 // 1 week
 )
 
+// A susenSession is one browser's view of the game: it has a
+// board, which is where the puzzle and its step history are
+// kept, and the session's watchers.  Usually the board belongs
+// to the session alone, but sessions in a room share the room's
+// board.
 type susenSession struct {
-	sessionID string
-	puzzleID  string
-	steps     []puzzle.Puzzle
+	sessionID  string
+	boardMutex sync.RWMutex // held while the board is locked, and while the session moves to another (see lockBoard)
+	*susenBoard
+
+	watchMutex sync.Mutex
+	watchers   map[*wsConn]bool
+	spectators map[*wsConn]bool // (see spectate.go)
+
+	infoMutex     sync.Mutex
+	user          *auth.User                // the identified user, if any
+	actions       []int                     // counts by sessionAction, for tutorial hints
+	merges        []mergeOffer              // browser sessions to offer merging into a user's session
+	lastMerge     int                       // the ID of the last merge offer
+	slots         map[string]*susenSession  // the session's other puzzle slots, by name
+	prefs         *sessionPrefs             // the session's preferences, or nil for the defaults (see prefs.go)
+	active        time.Time                 // when the session last had a request (see expiry.go)
+	replays       map[string]*savedResponse // responses to keyed requests, by key (see idempotency.go)
+	replayKeys    []string                  // the keys of replays, oldest first
+	spectateToken string                    // the token the session is spectated with, if any (see spectate.go)
+	attempts      []archivedAttempt         // the session's put-away attempts, oldest first (see attempts.go)
+
+	owner    *susenSession // for slot sessions, the session whose slot it is
+	slotName string
+}
+
+// A susenBoard is a puzzle and its step history, shared by its
+// member sessions.  Changes to the board are interlocked, so
+// simultaneous changes from different members are applied one
+// after the other.
+type susenBoard struct {
+	version     int64 // goes up with every change to the steps, read atomically (see version.go)
+	mutex       sync.Mutex
+	puzzleID    string
+	contest     bool  // contest boards get no help until they submit
+	unassisted  bool  // unassisted boards get no help at all
+	relaxed     bool  // relaxed boards accept entries that break the rules
+	values      []int // the puzzle's starting values
+	steps       []puzzle.Puzzle
+	stats       puzzleStats         // statistics on the play of the puzzle
+	blitz       *blitzAttempt       // the board's blitz attempt, if it's in one
+	lastHint    time.Time           // when the board last got a move hint
+	room        *susenRoom          // the board's room, if it's shared
+	race        *susenRace          // the board's race, if it's in one
+	handicapped bool                // the board was given race handicap squares
+	imported    bool                // the board was imported from a backup (see backup.go)
+	certifiable bool                // the board was started here, and never replaced (see archive.go)
+	guesses     []int               // the step counts before the board's open guesses, latest last
+	symbols     *puzzle.SymbolTable // the board's symbols, if it has them (see symbols.go)
+	analysis    *puzzle.Analysis    // the board's starting analysis, if it has one (see analysis.go)
+	members     []*susenSession     // the sessions using the board
+	changed     time.Time           // when the board's squares last changed (see poll.go)
+}
+
+// lockBoard locks the session's board.  The session stays on
+// that board until unlockBoard, even while the board is unlocked
+// for slow work (see unlocked), since moving a session to
+// another board (see rooms.go) waits for its locks to be
+// released, and is done with its old board locked.  So the
+// board's fields can be used through the session while it's
+// locked, but not otherwise: use currentBoard for that.
+func (session *susenSession) lockBoard() {
+	session.boardMutex.RLock()
+	session.mutex.Lock()
+}
+
+// unlockBoard unlocks the session's board.
+func (session *susenSession) unlockBoard() {
+	session.mutex.Unlock()
+	session.boardMutex.RUnlock()
+}
+
+// currentBoard returns the session's board, without locking it.
+// The session can move to another board as soon as it returns.
+func (session *susenSession) currentBoard() *susenBoard {
+	session.boardMutex.RLock()
+	defer session.boardMutex.RUnlock()
+	return session.susenBoard
+}
+
+// newSession creates a session with its own board, set up with
+// the default puzzle.
+func newSession(sessionID string) *susenSession {
+	session := &susenSession{sessionID: sessionID, active: time.Now()}
+	session.susenBoard = &susenBoard{members: []*susenSession{session}}
+	session.reset(defaultPuzzleID)
+	return session
 }
 
 var (
@@ -93,6 +185,17 @@ var (
 			0, 0, 0, 0, 0, 0, 5, 6, 0,
 			0, 2, 0, 0, 0, 0, 0, 0, 4,
 		},
+		"killer": []int{puzzle.KillerGeometryCode,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0,
+		},
 	}
 	defaultPuzzleID = "1-star"
 	startTime       = time.Now()
@@ -128,101 +231,721 @@ var (
 // different source protocols get different sessions, even if
 // they try submitting an existing cookie from the other tab.
 func getCookie(w http.ResponseWriter, r *http.Request) string {
-	proto := "httpx" // absent other indicators, protocol is unknown
-
-	// Issue #1: Heroku-transported protocols are specified in a header
-	if herokuProtocol := r.Header.Get("X-Forwarded-Proto"); herokuProtocol != "" {
-		proto = herokuProtocol
-	}
+	proto := requestProtocol(r)
 
-	// check for an existing cookie whose value matches the protocol
-	if sc, e := r.Cookie(cookieName); e == nil {
-		if m, e := regexp.MatchString(proto+"-[0-9a-z]{3,}", sc.Value); e == nil && m {
-			return sc.Value
+	// check for an existing cookie whose session matches the
+	// protocol, replacing it if it's a legacy one that's still
+	// read (see cookies.go)
+	if sc, e := r.Cookie(sessionCookie.Name); e == nil {
+		sid, legacy := cookieSessionID(sc.Value)
+		switch {
+		case !sessionValueFor(sid, proto):
+		case !legacy:
+			atomic.AddInt64(&cookieCounts.current, 1)
+			return sid
+		case sessionCookie.Legacy:
+			atomic.AddInt64(&cookieCounts.legacy, 1)
+			http.SetCookie(w, sessionCookie.newSessionCookie(sessionCookieValue(sid), r))
+			return sid
+		default:
+			atomic.AddInt64(&cookieCounts.refused, 1)
 		}
 	}
 
 	// no session cookie or not a valid session cookie,
 	// start a new session with a new cookie
-	sid := proto + "-" + strconv.FormatInt(int64(time.Now().Sub(startTime)), 36)
-	sc := &http.Cookie{Name: cookieName, Value: sid, Path: cookiePath, MaxAge: cookieMaxAge}
-	http.SetCookie(w, sc)
+	sid := newSessionID(proto)
+	atomic.AddInt64(&cookieCounts.issued, 1)
+	http.SetCookie(w, sessionCookie.newSessionCookie(sessionCookieValue(sid), r))
 	return sid
 }
 
+// authenticator returns the configured Authenticator.  A
+// deployment behind a login proxy sets SUSEN_AUTH_HEADER to the
+// header carrying the user's ID (and optionally
+// SUSEN_AUTH_NAME_HEADER to the one carrying the display name).
+// Users can also log in to their accounts; anyone else is
+// anonymous.
+func authenticator() auth.Authenticator {
+	if header := os.Getenv("SUSEN_AUTH_HEADER"); header != "" {
+		log.Printf("Authenticating users from header %s.", header)
+		return auth.Chain(auth.HeaderAuthenticator{
+			UserHeader: header,
+			NameHeader: os.Getenv("SUSEN_AUTH_NAME_HEADER"),
+		}, accounts)
+	}
+	return accounts
+}
+
+// setUser links the session to an identified user.
+func (session *susenSession) setUser(user *auth.User) {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if session.user == nil || session.user.Key() != user.Key() {
+		log.Printf("Session %v is user %v (%s).", session.sessionID, user.Key(), user.Name)
+	}
+	session.user = user
+}
+
 // since session selection can happen concurrently from
-// simultaneous goroutines, it has to be interlocked
+// simultaneous goroutines, it has to be interlocked; it returns
+// nil, having refused the request, if the session can't be
+// claimed (see leases.go)
 func sessionSelect(w http.ResponseWriter, r *http.Request) *susenSession {
-	sessionID := getCookie(w, r)
-	// look up the session for the cookie
+	session, held, e := claimSession(getCookie(w, r))
+	if session == nil {
+		refuseLeased(w, held, e)
+	}
+	return session
+}
+
+// sessionFor returns the session with the given ID, starting it
+// if there isn't one.  Sessions are started outside the registry
+// lock, since starting one can be slow, so the registry is checked
+// again before a new one is added: concurrent first requests for
+// an ID all get the same session.
+func sessionFor(sessionID string) *susenSession {
 	sessionMutex.RLock()
 	session, ok := sessions[sessionID]
 	sessionMutex.RUnlock()
-	if ok && session != nil && len(session.steps) > 0 {
+	if ok {
 		return session
 	}
-	// initialize and save the new session
-	session = &susenSession{sessionID: sessionID}
-	session.reset(defaultPuzzleID)
+	session = newSession(sessionID)
 	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if existing, ok := sessions[sessionID]; ok {
+		return existing
+	}
 	sessions[sessionID] = session
-	sessionMutex.Unlock()
 	return session
 }
 
-func (session *susenSession) reset(puzzleID string) {
-	vals, ok := puzzleValues[puzzleID]
-	if ok {
-		session.puzzleID = puzzleID
-	} else {
-		session.puzzleID, vals = defaultPuzzleID, puzzleValues[defaultPuzzleID]
+// reset starts the session's board over with the puzzle of the
+// given ID, or the position of the given encoding (or else the
+// default puzzle, if there's no such puzzle).
+// Improper puzzles (ones without exactly one solution) can still
+// be played, but the returned error warns about them.
+func (session *susenSession) reset(puzzleID string) error {
+	return session.resetIn(puzzleID, dailyZone)
+}
+
+// resetIn is reset for a player whose daily puzzles are in the
+// given zone (see daily.go).
+func (session *susenSession) resetIn(puzzleID string, loc *time.Location) error {
+	if puzzleID == blitzPuzzleID {
+		return session.startBlitz()
+	}
+	if puzzleID == dailyPuzzleID {
+		puzzleID = zonedDailyID(time.Now(), loc)
+	}
+	if puzzleID == session.puzzleID {
+		// a day's puzzle can always be started over
+		loc = earliestZone
+	}
+	if date, ok := parseZonedDailyID(puzzleID, loc); ok {
+		return session.start(puzzleID, dailyValues(date))
+	}
+	if _, ok := parseSeedID(puzzleID); ok && puzzleID == session.puzzleID {
+		// a generated puzzle is started over, not generated again
+		return session.start(puzzleID, session.values)
 	}
-	p, e := puzzle.New(vals)
+	if vals, ok := seedValues(puzzleID); ok {
+		return session.start(puzzleID, vals)
+	}
+	vals, ok := lookupPuzzle(puzzleID)
+	if !ok && sharedPuzzleID(puzzleID) && puzzleID == session.puzzleID {
+		// starting a shared position over
+		return session.start(puzzleID, session.values)
+	}
+	if !ok {
+		if shared, e := session.startShared(puzzleID); shared {
+			return e
+		}
+		puzzleID = defaultPuzzleID
+		vals, _ = lookupPuzzle(puzzleID)
+	}
+	return session.start(puzzleID, vals)
+}
+
+// start starts the session's board over with the given puzzle
+// values, under the given puzzle ID.  Any blitz attempt on the
+// board is abandoned.  Only rooms are unassisted (see rooms.go),
+// so boards outside them get help again.
+func (session *susenSession) start(puzzleID string, vals []int) error {
+	session.stopBlitz()
+	if session.room == nil {
+		session.unassisted = false
+	}
+	newPuzzle := puzzle.New
+	if session.contest || session.unassisted {
+		newPuzzle = puzzle.NewContest
+	} else if session.relaxed {
+		newPuzzle = puzzle.NewRelaxed
+	}
+	p, e := newPuzzle(vals)
 	if e != nil {
 		log.Fatal(e)
 	}
-	session.steps = []puzzle.Puzzle{p}
-	log.Printf("Initialized session %v from puzzle %q.", session.sessionID, session.puzzleID)
+	session.puzzleID, session.values, session.steps = puzzleID, vals, []puzzle.Puzzle{p}
+	session.changedSteps()
+	session.handicapped, session.imported, session.guesses = false, false, nil
+	session.certifiable = true
+	session.keepSymbols()
+	session.preAnalyze()
+	session.startStats()
+	log.Printf("Initialized session %v from puzzle %q (fingerprint %s).",
+		session.sessionID, session.puzzleID, puzzle.Fingerprint(vals))
+	e = p.IsProper()
+	if e != nil {
+		log.Printf("Warning: puzzle %q is improper: %v", puzzleID, e)
+	}
+	return e
+}
+
+// warnImproper adds a warning about an improper puzzle to a
+// response.
+func warnImproper(w http.ResponseWriter, e error) {
+	if e != nil {
+		w.Header().Add("Warning", "199 susen "+strconv.Quote(e.Error()))
+	}
+}
+
+// snapshot returns a copy of the board's current step, which
+// can be used (for rendering or solving, say) while the board
+// changes.  It must be called with the board locked.
+func (board *susenBoard) snapshot() puzzle.Puzzle {
+	return board.steps[len(board.steps)-1].Copy()
+}
+
+// unlocked calls f with a snapshot of the board's current step,
+// and with the board unlocked while it runs, so that slow work
+// on the step doesn't hold up the board's other requests.  It
+// must be called with the board locked, and returns with it
+// locked again.
+func (board *susenBoard) unlocked(f func(step puzzle.Puzzle)) {
+	step := board.snapshot()
+	board.mutex.Unlock()
+	defer board.mutex.Lock()
+	f(step)
 }
 
 func (session *susenSession) addStep(next puzzle.Puzzle) {
 	session.steps = append(session.steps, next)
-	log.Printf("Added session %v step %d.", session.sessionID, len(session.steps))
+	session.changedSteps()
+	debugf("Added session %v step %d.", session.sessionID, len(session.steps))
 }
 
 func (session *susenSession) undoStep() {
 	if len(session.steps) > 1 {
+		// pencil marks aren't part of the step history, so they
+		// survive the undo
+		carryMarks(session.steps[len(session.steps)-1], session.steps[len(session.steps)-2])
 		session.steps[len(session.steps)-1] = nil // release current step
 		session.steps = session.steps[:len(session.steps)-1]
-		log.Printf("Reverted session %v to step %d.", session.sessionID, len(session.steps))
+		session.changedSteps()
+		session.closeGuesses()
+		session.countUndo()
+		session.undoMoves()
+		debugf("Reverted session %v to step %d.", session.sessionID, len(session.steps))
 	} else {
-		log.Printf("No steps to undo in session %v.", session.sessionID)
+		debugf("No steps to undo in session %v.", session.sessionID)
+	}
+}
+
+// undoToCertain takes the board back to its last certain step:
+// the one before the first step with an entry that's known to be
+// wrong.  Entries are known to be wrong if they don't match the
+// solution or, for puzzles without just one solution, if they
+// leave the puzzle with errors.  It returns the number of steps
+// taken back, each of which counts as an undo.  Since it reveals
+// mistakes, it's only for boards that get help.
+func (session *susenSession) undoToCertain() int {
+	start, _ := puzzle.New(session.values) // the board was started with them
+	var solution []int
+	if start.IsProper() == nil {
+		solution = start.Solutions()[0].Values
+	}
+	wrong := func(p puzzle.Puzzle) bool {
+		state := p.State()
+		if solution == nil {
+			return len(state.Errors) > 0
+		}
+		for i, v := range state.Values {
+			if v != 0 && v != solution[i] {
+				return true
+			}
+		}
+		return false
+	}
+	for k := 1; k < len(session.steps); k++ {
+		if wrong(session.steps[k]) {
+			undone := len(session.steps) - k
+			for len(session.steps) > k {
+				session.undoStep()
+			}
+			debugf("Took session %v back %d steps to its last certain step.", session.sessionID, undone)
+			return undone
+		}
+	}
+	return 0
+}
+
+// carryMarks makes the pencil marks in the empty squares of one
+// puzzle match the marks in the same squares of another.
+// Squares assigned in the source puzzle keep their marks, since
+// the source doesn't report marks for assigned squares.
+func carryMarks(from, to puzzle.Puzzle) {
+	fss, tss := from.Squares(), to.Squares()
+	for i := range tss {
+		if i >= len(fss) || tss[i].Aval != 0 || fss[i].Aval != 0 {
+			continue
+		}
+		for _, v := range tss[i].Marks {
+			to.UnmarkCandidate(puzzle.Choice{Index: tss[i].Index, Value: v})
+		}
+		for _, v := range fss[i].Marks {
+			to.MarkCandidate(puzzle.Choice{Index: tss[i].Index, Value: v})
+		}
 	}
 }
 
 func (session *susenSession) apiHandler(w http.ResponseWriter, r *http.Request) {
+	session.lockBoard()
+	defer session.unlockBoard()
+	if session.refuseBlitzChange(w, r) || session.refuseTurnChange(w, r) || session.refuseRaceChange(w, r) {
+		return
+	}
+	held := &heldResponse{ResponseWriter: w}
+	defer held.release()
+	session.idempotent(held, r, session.boardAPIHandler)
+}
+
+// boardAPIHandler handles the board's API requests.  It's called
+// with the board locked.
+func (session *susenSession) boardAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if session.refuseStaleVersion(w, r) {
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/abandon/puzzle") {
+		session.abandonPuzzleHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/abandon") {
+		session.abandonHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/timer") {
+		session.timerHandler(w, r)
+		return
+	}
 	if strings.Contains(r.URL.Path, "/reset/") {
-		session.reset(session.puzzleID)
+		session.archiveAttempt()
+		warnImproper(w, session.reset(session.puzzleID))
+		session.notifySquares()
+		session.moved()
+		session.recordAction(resetAction)
 	}
-	if strings.Contains(r.URL.Path, "/back/") {
+	if strings.Contains(r.URL.Path, "/back/certain/") {
+		if session.contest || session.unassisted {
+			sendError(w, http.StatusForbidden,
+				requestError("Contest and unassisted boards can't be taken back to their last certain step"))
+			return
+		}
+		session.undoToCertain()
+		session.notifySquares()
+		session.moved()
+		session.recordAction(undoAction)
+	} else if strings.Contains(r.URL.Path, "/back/") {
 		session.undoStep()
+		session.notifySquares()
+		session.moved()
+		session.recordAction(undoAction)
 	}
 	switch method := r.Method; method {
 	case "GET":
-		puzzle.SquaresHandler(session.steps[len(session.steps)-1], w, r)
-		log.Printf("Returned current state.")
+		if strings.HasPrefix(r.URL.Path, "/api/history") {
+			session.historyHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/commands") {
+			session.commandsHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/stats") {
+			session.statsHandler(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/ui-hints") {
+			session.uiHintsHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/hint") {
+			session.hintHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/explain") {
+			session.explainHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/solutions") {
+			session.solutionsHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/blitz") {
+			session.blitzHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/image.svg") {
+			session.imageHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/share-text") {
+			session.shareTextHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/share-image") {
+			session.shareImageHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/share") {
+			session.shareHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/symbols") {
+			session.symbolsHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/generate") {
+			session.generateHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/conflicts") {
+			puzzle.ConflictsHandler(session.steps[len(session.steps)-1], w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/units") {
+			puzzle.UnitsHandler(session.steps[len(session.steps)-1], w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/status") {
+			session.statusHandler(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/rating/") {
+			if !featureEnabled("rating") {
+				featureOff(w, "rating")
+				return
+			}
+			session.ratingHandler(w, r)
+			return
+		}
+		if len(session.steps) == 1 {
+			session.attachAnalysis(w)
+		}
+		last := session.steps[len(session.steps)-1]
+		attachProgress(w, last)
+		session.attachPollInterval(w)
+		puzzle.SquaresHandler(session.shown(last), w, r)
+		debugf("Returned current state.")
 	case "POST":
+		if strings.Contains(r.URL.Path, "/verify/") {
+			verifyHandler(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/submit/") {
+			session.submitHandler(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/mark/") || strings.Contains(r.URL.Path, "/unmark/") {
+			session.markHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/replay") {
+			session.publishReplay(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/autofill") {
+			session.autofillHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/check") {
+			session.checkHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/assign-batch") {
+			session.assignBatchHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/symbols") {
+			session.symbolsHandler(w, r)
+			return
+		}
+		// a guess starts a branch at the step it makes
+		guess := strings.HasPrefix(r.URL.Path, "/api/guess")
 		next := session.steps[len(session.steps)-1].Copy()
-		_, e := puzzle.AssignHandler(next, w, r)
+		if guess {
+			session.guesses = append(session.guesses, len(session.steps))
+		}
+		var update puzzle.Update
+		var e error
+		session.attachPollInterval(w)
+		if strings.HasPrefix(r.URL.Path, "/api/assign-symbol") {
+			update, e = puzzle.AssignSymbolHandler(session.shown(next), session.symbols, w, r)
+		} else {
+			update, e = puzzle.AssignHandler(session.shown(next), w, r)
+		}
 		if e != nil {
-			log.Printf("Assign failed, returned error, no session change.")
+			debugf("Assign failed, returned error, no session change.")
+			session.closeGuesses()
 		} else {
-			log.Printf("Assign succeeded, returned update.")
+			debugf("Assign succeeded, returned update.")
 			session.addStep(next)
+			session.countAssign(update)
+			session.notifyUpdate(update)
+			session.pushRoomMove()
+			session.moved()
+			session.recordAction(assignAction)
 		}
 	default:
 		log.Printf("%s unexpected; no action taken.", method)
+		w.Header().Set("Allow", "GET, POST")
+		sendError(w, http.StatusMethodNotAllowed, requestError("Unknown API method: "+method+" "+r.URL.Path))
+	}
+}
+
+// markHandler changes pencil marks in place on the current
+// step, since marks are notes rather than undoable moves.
+func (session *susenSession) markHandler(w http.ResponseWriter, r *http.Request) {
+	current := session.steps[len(session.steps)-1]
+	var update puzzle.Update
+	var e error
+	session.attachPollInterval(w)
+	if strings.Contains(r.URL.Path, "/unmark/") {
+		update, e = puzzle.UnmarkHandler(current, w, r)
+	} else {
+		update, e = puzzle.MarkHandler(current, w, r)
+	}
+	if e != nil {
+		debugf("Mark change failed, returned error, no session change.")
+	} else {
+		debugf("Mark change succeeded, returned update.")
+		session.broadcast(sessionEvent{Type: updateEventType, Squares: update.Squares})
+		session.recordAction(markAction)
+	}
+}
+
+// assignBatchHandler assigns a posted array of choices as a
+// single step: either all of them are assigned, or the board is
+// unchanged and the first choice's error is returned.  Each
+// choice counts as an assignment, but the batch is one move.
+func (session *susenSession) assignBatchHandler(w http.ResponseWriter, r *http.Request) {
+	next := session.steps[len(session.steps)-1].Copy()
+	session.attachPollInterval(w)
+	updates, e := puzzle.AssignBatchHandler(session.shown(next), w, r)
+	if e != nil {
+		debugf("Batch assign failed, returned error, no session change.")
+		return
+	}
+	debugf("Batch assign of %d choices succeeded, returned update.", len(updates))
+	session.addStep(next)
+	for _, update := range updates {
+		session.countAssign(update)
+	}
+	session.notifyUpdate(puzzle.MergeUpdates(updates))
+	session.pushRoomMove()
+	session.moved()
+	session.recordAction(assignAction)
+}
+
+// lookupFingerprint finds the values of the known puzzle with
+// the given fingerprint.
+func lookupFingerprint(fingerprint string) ([]int, bool) {
+	catalogMutex.RLock()
+	defer catalogMutex.RUnlock()
+	for _, vals := range puzzleValues {
+		if puzzle.Fingerprint(vals) == fingerprint {
+			return vals, true
+		}
+	}
+	return nil, false
+}
+
+// verifyHandler checks claimed solutions to known puzzles.  It
+// doesn't depend on (or change) the session's puzzle, so
+// external contest systems can use it.
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	v, e := puzzle.VerifyHandler(lookupFingerprint, w, r)
+	if e != nil {
+		log.Printf("Verify failed, returned error.")
+	} else {
+		log.Printf("Verified claim for puzzle %s: valid = %v.", v.Fingerprint, v.Valid)
+	}
+}
+
+// submitHandler verifies the session's current puzzle values as
+// a solution to the session's puzzle.  Unassisted boards are
+// told whether they've solved the puzzle, but not what's wrong
+// if they haven't, and their results are marked as unassisted.
+func (session *susenSession) submitHandler(w http.ResponseWriter, r *http.Request) {
+	vals := session.values
+	var v puzzle.Verification
+	var e error
+	if session.unassisted {
+		state := session.steps[len(session.steps)-1].State()
+		v, e = puzzle.Verify(vals, append([]int{state.Geometry}, state.Values...))
+		if e != nil {
+			err, ok := e.(puzzle.Error)
+			if !ok {
+				err = requestError(e.Error())
+			}
+			sendError(w, http.StatusBadRequest, err)
+		} else {
+			v.Errors, v.Unassisted = nil, true
+			sendJSON(w, http.StatusOK, v)
+		}
+	} else {
+		v, e = puzzle.SubmitHandler(vals, session.steps[len(session.steps)-1], w, r)
+	}
+	if e != nil {
+		log.Printf("Submit failed, returned error.")
+	} else {
+		log.Printf("Session %v submitted puzzle %q: valid = %v, unassisted = %v.",
+			session.sessionID, session.puzzleID, v.Valid, v.Unassisted)
+		if v.Valid {
+			session.complete()
+			session.broadcast(sessionEvent{Type: solvedEventType})
+		}
+	}
+}
+
+// ratingHandler rates the difficulty of solving the session's
+// puzzle from where it is now, with the board unlocked while it's
+// rated.  Rating is metered as analysis.
+// Contest and unassisted boards can't be rated, since that
+// would give away how close they are to a solution.  The
+// rating is made with the profile given by the query parameters
+// (see ratingProfile).  It's called with the board locked.
+func (session *susenSession) ratingHandler(w http.ResponseWriter, r *http.Request) {
+	profile, e := ratingProfile(r.URL.Query())
+	if e != nil {
+		sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+		return
+	}
+	if session.contest || session.unassisted {
+		sendError(w, http.StatusForbidden, requestError("Contest and unassisted boards can't be rated"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) || refuseSolverFault(w) {
+		return
+	}
+	var rating puzzle.Rating
+	session.unlocked(func(step puzzle.Puzzle) {
+		rating, e = puzzle.RateContext(r.Context(), step, profile)
+	})
+	if e != nil {
+		sendSolverError(w, http.StatusBadRequest, e)
+		return
+	}
+	log.Printf("Rated session %v puzzle %q at %d stars (%s).",
+		session.sessionID, session.puzzleID, rating.Stars, profile.Name)
+	sendJSON(w, http.StatusOK, rating)
+}
+
+// ratingProfile returns the rating profile named by the profile
+// query parameter (the standard one if there isn't one), less
+// the techniques in the comma-separated disable parameter.
+func ratingProfile(q url.Values) (puzzle.RatingProfile, error) {
+	profile, e := puzzle.FindRatingProfile(q.Get("profile"))
+	if e != nil {
+		return profile, e
+	}
+	if disable := q.Get("disable"); disable != "" {
+		return profile.Without(strings.Split(disable, ",")...)
+	}
+	return profile, nil
+}
+
+// A ratedPuzzle is a generated puzzle with its rating.
+type ratedPuzzle struct {
+	puzzle.Generated
+	Rating puzzle.Rating `json:"rating"`
+}
+
+// generateHandler generates a puzzle from the seed, sidelen,
+// and givens query parameters (see puzzle.GenerateParams), with
+// the session's preferred side length if there's no sidelen.  The
+// same parameters always give the same puzzle, so a puzzle can
+// be passed around as its seed.  If there's no seed, a random
+// one is used, and it's returned with the puzzle.  Generation is
+// metered.  If there's a profile or disable parameter, the
+// puzzle is returned with its rating under that profile (see
+// ratingProfile).
+func (session *susenSession) generateHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	profile, e := ratingProfile(q)
+	if e != nil {
+		sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+		return
+	}
+	params, ok := generateParams(w, q, session.preferences().SideLength)
+	if !ok || !takeQuota(w, quotaKey(r, session), quotaGenerate) {
+		return
+	}
+	g, e := generatePuzzle(r.Context(), params, nil)
+	if e != nil {
+		sendSolverError(w, http.StatusBadRequest, e)
+		return
+	}
+	log.Printf("Generated puzzle from seed %q for session %v.", g.Seed, session.sessionID)
+	if q.Get("profile") == "" && q.Get("disable") == "" {
+		sendJSON(w, http.StatusOK, g)
+		return
+	}
+	p, _ := puzzle.New(g.Values)
+	rating, e := puzzle.RateContext(r.Context(), p, profile)
+	if e != nil {
+		sendSolverError(w, http.StatusInternalServerError, e)
+		return
+	}
+	sendJSON(w, http.StatusOK, ratedPuzzle{g, rating})
+}
+
+// generateParams returns the generation parameters in a query,
+// with the given side length if it doesn't have one, or false
+// (having sent the error response) if they're invalid.
+func generateParams(w http.ResponseWriter, q url.Values, sidelen int) (puzzle.GenerateParams, bool) {
+	params := puzzle.GenerateParams{Seed: q.Get("seed"), SideLength: sidelen}
+	for name, field := range map[string]*int{"sidelen": &params.SideLength, "givens": &params.Givens} {
+		if s := q.Get(name); s != "" {
+			n, e := puzzle.ParseInt(name, s)
+			if e != nil {
+				sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+				return params, false
+			}
+			*field = n
+		}
+	}
+	if params.Seed == "" {
+		params.Seed = randomSeed()
+	}
+	return params, true
+}
+
+// seedSource is where the seeds of puzzles generated without one
+// come from: crypto/rand, unless the server's seed setting (see
+// server.go) names a stream (see puzzle.NewSeedSource), so that
+// servers with the same setting make the same puzzles in the same
+// order.  Tests can replace it.
+var seedSource io.Reader = rand.Reader
+
+// randomSeed returns a new generation seed from the seedSource.
+func randomSeed() string {
+	seed, e := puzzle.NewSeed(seedSource)
+	if e != nil {
+		log.Fatal(e)
 	}
+	return seed
 }
 
 func (session *susenSession) solverHandler(w http.ResponseWriter, r *http.Request) {
@@ -236,49 +959,287 @@ func (session *susenSession) solverHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (session *susenSession) rootHandler(w http.ResponseWriter, r *http.Request) {
+	session.touch()
+	if !apiVersion(w, r) {
+		return
+	}
+	if inMaintenance(r) {
+		w.Header().Set("Retry-After", "600")
+		sendError(w, http.StatusServiceUnavailable,
+			requestError("The server is in maintenance; puzzles can't be changed right now"))
+		return
+	}
+	if name := r.URL.Query().Get("slot"); name != "" && session.owner == nil && slottedPath(r.URL.Path) {
+		slot, e := session.slot(name)
+		if e != nil {
+			sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+			return
+		}
+		slot.rootHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/spectate/") {
+		// spectators watch other sessions
+		spectateHandler(w, r)
+		return
+	}
+	w = &versionWriter{ResponseWriter: w, board: session.currentBoard()}
 	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/slots/"):
+		session.slotsHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/prefs"):
+		session.prefsHandler(w, r)
+		return
 	case strings.HasPrefix(r.URL.Path, "/reset/"):
-		if len(r.URL.Path) > len("/reset/") {
-			session.reset(r.URL.Path[len("/reset/"):])
-		} else {
-			session.reset(session.puzzleID)
+		session.lockBoard()
+		if session.refuseTurnChange(w, r) || session.refuseRaceChange(w, r) {
+			session.unlockBoard()
+			return
+		}
+		var chosen randomChoice
+		puzzleID := r.URL.Path[len("/reset/"):]
+		if puzzleID == randomPuzzleID {
+			var ok bool
+			if chosen, ok = session.chooseRandom(w, r); !ok {
+				session.unlockBoard()
+				return
+			}
+		} else if params, ok := parseSeedID(puzzleID); ok && puzzleID != session.puzzleID {
+			if chosen, ok = session.chooseSeed(w, r, params); !ok {
+				session.unlockBoard()
+				return
+			}
+		}
+		// the mode is chosen when the puzzle is chosen, and
+		// persists across API resets; unassisted rooms stay
+		// that way whatever the mode
+		mode := r.URL.Query().Get("mode")
+		if mode == "" && session.preferences().Relaxed {
+			mode = "relaxed"
+		}
+		session.archiveAttempt()
+		session.contest, session.relaxed = mode == "contest", mode == "relaxed"
+		switch {
+		case chosen.values != nil:
+			warnImproper(w, session.start(chosen.puzzleID, chosen.values))
+		case puzzleID != "":
+			warnImproper(w, session.resetIn(puzzleID, requestZone(r)))
+		default:
+			warnImproper(w, session.reset(session.puzzleID))
+		}
+		session.notifySquares()
+		session.moved()
+		session.unlockBoard()
+		session.recordAction(resetAction)
+	case strings.HasPrefix(r.URL.Path, "/api/room/"):
+		if !featureEnabled("rooms") {
+			featureOff(w, "rooms")
+			return
+		}
+		session.roomHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/race/"):
+		if !featureEnabled("rooms") {
+			featureOff(w, "rooms")
+			return
+		}
+		session.raceHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/tournaments/"):
+		if !featureEnabled("rooms") {
+			featureOff(w, "rooms")
+			return
+		}
+		session.tournamentHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/account/"):
+		session.accountHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/auth/"):
+		session.oauthHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/recovery-code"), strings.HasPrefix(r.URL.Path, "/recover/"):
+		session.recoveryHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/merge/"):
+		session.mergeHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/catalog/"):
+		catalogHandler(w, r)
+		return
+	case r.URL.Path == "/api/puzzles" || strings.HasPrefix(r.URL.Path, "/api/puzzles/"):
+		puzzlesHandler(w, r)
+		return
+	case r.URL.Path == "/api/batch":
+		auth.RequireRole(roles, auth.RoleSetter, http.HandlerFunc(batchHandler)).ServeHTTP(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/provenance/"):
+		provenanceHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/daily/"):
+		dailyHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/locale/"):
+		localeHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/leaderboard/"):
+		leaderboardHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/archive/attempts"):
+		session.attemptsHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/archive/"):
+		archiveHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/class/"):
+		classHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/discussion/"):
+		if !featureEnabled("discussions") {
+			featureOff(w, "discussions")
+			return
 		}
+		discussionHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/push/"):
+		if !featureEnabled("push") {
+			featureOff(w, "push")
+			return
+		}
+		pushHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/mod/"):
+		auth.RequireRole(roles, auth.RoleModerator, http.HandlerFunc(modHandler)).ServeHTTP(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/admin/"):
+		auth.RequireRole(roles, auth.RoleAdmin, http.HandlerFunc(adminHandler)).ServeHTTP(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/import/"):
+		session.appImportHandler(w, r)
+		return
+	case r.URL.Path == "/api/export" || r.URL.Path == "/api/import":
+		session.backupHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/spectate/"):
+		session.spectateTokenHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/api/progress/"):
+		session.progressHandler(w, r)
+		return
 	case strings.HasPrefix(r.URL.Path, "/api/"):
 		session.apiHandler(w, r)
 		return
+	case strings.HasPrefix(r.URL.Path, "/replay/"):
+		replayHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/export/"):
+		session.exportHandler(w, r)
+		return
+	case strings.HasPrefix(r.URL.Path, "/ws"):
+		session.wsHandler(w, r)
+		return
+	case r.URL.Path == plainSolverPath:
+		session.plainSolverHandler(w, r)
+		return
 	case strings.HasPrefix(r.URL.Path, "/solver/"):
 		session.solverHandler(w, r)
 		return
 	}
-	http.Redirect(w, r, "/solver/", http.StatusFound)
+	http.Redirect(w, r, "/solver/"+session.slotQuery(), http.StatusFound)
 }
 
-func main() {
-	http.Handle("/static/", http.StripPrefix("/", http.FileServer(http.Dir("."))))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+// susenHandler identifies the user making each request, finds
+// their session, and has the session handle the request.  Every
+// request is logged (see requestlog.go), can have a deadline
+// (see deadline.go), has its errors sent in envelopes (see
+// envelope.go), and has its response compressed if the client
+// accepts it (see compress.go).
+func susenHandler(a auth.Authenticator) http.Handler {
+	return logRequests(compressResponses(errorEnvelopes(corsAllowed(rateLimited(withDeadline(auth.Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/favicon.ico" {
-			log.Printf("Received site icon request.")
-			http.ServeFile(w, r, "static/img/susen.ico")
+			debugf("Received site icon request.")
+			serveAsset(w, r, "img/susen.ico")
 			return
 		}
-		log.Printf("Handling %s %s...", r.Method, r.URL.Path)
+		requestEvents.add(time.Now())
 		session := sessionSelect(w, r)
+		if session == nil {
+			return
+		}
+		if user := auth.FromRequest(r); user != nil {
+			us, held, e := userSession(session, user)
+			if us == nil {
+				refuseLeased(w, held, e)
+				return
+			}
+			session = us
+			session.setUser(user)
+		}
+		noteSession(r, session.sessionID)
+		localizeRequest(w, r)
+		if refuseExpired(w, r) {
+			return
+		}
 		session.rootHandler(w, r)
-	})
+	}))))))))
+}
 
-	// Heroku environment port sensing
-	port := os.Getenv("PORT")
-	if port == "" {
-		// running locally in dev mode
-		port = "localhost:8080"
-	} else {
-		// running as a true server
-		port = ":" + port
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	conf, err := loadServerConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal("Invalid server configuration: ", err)
+	}
+	staticDir = conf.StaticDir
+	corsOrigins = conf.CORSOrigins
+	trustedProxies = conf.Proxies
+	sessionCookie = conf.Cookies
+	if conf.Seed != "" {
+		seedSource = puzzle.NewSeedSource(conf.Seed)
+	}
+	if tmpl, e := fs.Sub(staticAssets(), "tmpl"); e == nil {
+		client.SetDefaultTemplateFS(tmpl)
 	}
+	openStore()
+	openReplica()
+	loadDailyZone()
+	grantAdmins()
+	loadCollections()
+	startCrossCheck()
+	loadConfigFile()
+	reloadOnHangup()
+	loadAlerts()
+	pushDaily()
+	if report := runSelfTest(); report.Failed {
+		log.Fatal("Self-test failed, not starting.")
+	} else if report.Degraded {
+		log.Printf("Self-test found problems, starting in degraded mode.")
+	}
+	if conf.SessionLease > 0 {
+		startLeasing(conf.SessionLease)
+	} else if n := restoreSessions(); n > 0 {
+		log.Printf("Restored %d checkpointed sessions.", n)
+	}
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.Handle("/static/", compressResponses(errorEnvelopes(http.HandlerFunc(staticHandler))))
+	a := authenticator()
+	http.Handle("/", susenHandler(a))
 
-	log.Printf("Listening on %s...", port)
-	err := http.ListenAndServe(port, nil)
+	srv := conf.server(nil)
+	startGRPC(conf, srv, a)
+	done := shutdownOnSignal(srv, conf.DrainTime)
+	listeners, err := conf.openListeners()
 	if err != nil {
 		log.Fatal("Listener failure: ", err)
 	}
+	err = serveListeners(srv, listeners)
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal("Listener failure: ", err)
+	}
+	<-done
+	log.Printf("Shut down.")
 }
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,7 +26,6 @@ type sessionClient struct {
 	id       int           // which client this is
 	client   *http.Client  // the http client, with cookies
 	puzzleID string        // the puzzle this client works on
-	interval int           // the interval, in msec, between calls
 	vals     []int         // the expected values of the puzzle
 	choice   puzzle.Choice // the first choice to try in this puzzle
 }
@@ -65,11 +67,9 @@ func TestSessionSelect(t *testing.T) {
 			}
 		}
 	}
-	// helper - prevent redirects in a known way
-	redirectCount := 0
+	// helper - prevent redirects, returning the redirect response
 	redirectFn := func(*http.Request, []*http.Request) error {
-		redirectCount++
-		return fmt.Errorf("%d", redirectCount)
+		return http.ErrUseLastResponse
 	}
 	// helper - make a call setting the current session puzzle, return false on error
 	setPuzzle := func(c *sessionClient, puzzleID string) bool {
@@ -77,10 +77,11 @@ func TestSessionSelect(t *testing.T) {
 		t.Logf("Client %d: getting %s", c.id, target)
 		logCookies(c, target)
 		r, e := c.client.Get(target)
-		if e != nil && e.(*url.Error).Err.Error() != fmt.Sprintf("%d", redirectCount) {
+		if e != nil {
 			t.Errorf("client %d: Request error: %v", c.id, e)
 			return false
 		}
+		r.Body.Close()
 		t.Logf("client %d: %q\n", c.id, r.Status)
 		t.Logf("client %d: %v\n", c.id, r.Header)
 		if r.StatusCode != http.StatusFound {
@@ -173,13 +174,6 @@ func TestSessionSelect(t *testing.T) {
 		}
 		return true
 	}
-	// helper - sleep interval milliseconds
-	sleep := func(c *sessionClient) {
-		sleeptime := time.Duration(c.interval) * time.Millisecond
-		t.Logf("Client %d sleeps %s", c.id, sleeptime)
-		time.Sleep(sleeptime)
-	}
-
 	// make clients
 	clients := make([]*sessionClient, clientCount)
 	for i := 0; i < clientCount; i++ {
@@ -196,7 +190,6 @@ func TestSessionSelect(t *testing.T) {
 			id:       i + 1,
 			client:   &http.Client{Jar: jar, CheckRedirect: redirectFn},
 			puzzleID: puzzleID,
-			interval: (i*17)%100 + 100,
 			vals:     puzzleVals,
 			choice:   firstAssigned(puzzleVals),
 		}
@@ -204,24 +197,26 @@ func TestSessionSelect(t *testing.T) {
 	}
 
 	// each client makes runCount sets of 3 calls: reset then assign then back
-	// after runCount sets, the client reports back, and we wait for all clients
+	// after runCount sets, the client reports back, and we wait for all clients;
+	// the clients don't wait between calls, so their requests overlap (run
+	// with -race to check the session locking)
+	sessionMutex.RLock()
+	existing := len(sessions)
+	sessionMutex.RUnlock()
 	ch := make(chan int, clientCount)
 	start := time.Now()
 	for i := 0; i < clientCount; i++ {
 		go func(client *sessionClient) {
 			for i := 0; i < runCount; i++ {
-				sleep(client)
 				if !setPuzzle(client, client.puzzleID) {
 					break
 				}
 				if !getSquares(client, "/") {
 					break
 				}
-				sleep(client)
 				if !getUpdate(client) {
 					break
 				}
-				sleep(client)
 				if !getSquares(client, fmt.Sprintf("/back/")) {
 					break
 				}
@@ -234,8 +229,168 @@ func TestSessionSelect(t *testing.T) {
 		diff := time.Now().Sub(start)
 		t.Logf("Client %d finished in %v\n", id, diff)
 	}
-	if len(sessions) != clientCount {
-		t.Errorf("At end of run, there were %d sessions: %v", len(sessions), sessions)
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	if len(sessions)-existing != clientCount {
+		t.Errorf("The run added %d sessions: %v", len(sessions)-existing, sessions)
+	}
+}
+
+func TestSessionFor(t *testing.T) {
+	// concurrent first requests for a session all get the same one
+	found := make(chan *susenSession, clientCount)
+	for i := 0; i < clientCount; i++ {
+		go func() { found <- sessionFor("test-session-for") }()
+	}
+	first := <-found
+	for i := 1; i < clientCount; i++ {
+		if session := <-found; session != first {
+			t.Errorf("Concurrent requests got different sessions")
+		}
+	}
+	sessionMutex.Lock()
+	if sessions["test-session-for"] != first {
+		t.Errorf("The registry has a different session")
+	}
+	delete(sessions, "test-session-for")
+	sessionMutex.Unlock()
+}
+
+func TestUnlocked(t *testing.T) {
+	session := newSession("test-unlocked")
+	session.mutex.Lock()
+	session.unlocked(func(step puzzle.Puzzle) {
+		// the board can be changed while the snapshot is used
+		if !session.mutex.TryLock() {
+			t.Fatalf("The board is locked during unlocked work")
+		}
+		session.addStep(session.snapshot())
+		if _, e := session.steps[len(session.steps)-1].Assign(puzzle.Choice{Index: 2, Value: 1}); e != nil {
+			t.Errorf("Assign failed: %v", e)
+		}
+		session.mutex.Unlock()
+		if s := step.Squares()[1]; s.Aval != 0 {
+			t.Errorf("The snapshot changed with the board: %+v", s)
+		}
+	})
+	if session.mutex.TryLock() {
+		t.Errorf("The board isn't locked after unlocked work")
+	}
+	session.mutex.Unlock()
+}
+
+func TestSharedSession(t *testing.T) {
+	// many clients work on one session's board at once, through
+	// all kinds of requests, without waiting for each other (run
+	// with -race to check the session locking)
+	session := newSession("test-shared-session")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	choice := func(i int) string {
+		vals := puzzleValues[defaultPuzzleID]
+		for index := 1 + i%len(vals[1:]); ; index = index%len(vals[1:]) + 1 {
+			if vals[index] == 0 {
+				return fmt.Sprintf(`{"index": %d, "value": %d}`, index, 1+i%9)
+			}
+		}
+	}
+	requests := []func(i int) (*http.Response, error){
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/api/squares/") },
+		func(i int) (*http.Response, error) {
+			return http.Post(srv.URL+"/api/assign/", "application/json", strings.NewReader(choice(i)))
+		},
+		func(i int) (*http.Response, error) {
+			return http.Post(srv.URL+"/api/mark/", "application/json", strings.NewReader(choice(i)))
+		},
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/api/back/") },
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/api/state/") },
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/api/commands") },
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/api/history") },
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/api/prefs") },
+		func(i int) (*http.Response, error) { return http.Get(srv.URL + "/reset/" + defaultPuzzleID) },
+	}
+	errs := make(chan error, clientCount)
+	for c := 0; c < clientCount; c++ {
+		go func(c int) {
+			for i := 0; i < runCount*len(requests); i++ {
+				r, e := requests[(c+i)%len(requests)](c + i)
+				if e != nil {
+					errs <- e
+					return
+				}
+				r.Body.Close()
+				if r.StatusCode >= http.StatusInternalServerError {
+					errs <- fmt.Errorf("%s %s gave %d", r.Request.Method, r.Request.URL.Path, r.StatusCode)
+					return
+				}
+			}
+			errs <- nil
+		}(c)
+	}
+	for c := 0; c < clientCount; c++ {
+		if e := <-errs; e != nil {
+			t.Errorf("Client request failed: %v", e)
+		}
+	}
+}
+
+func TestSessionMovesBoards(t *testing.T) {
+	// a session joins and leaves a room while its clients make
+	// moves, which always go to the board the session is on (run
+	// with -race to check the board locking)
+	host, session := newSession("test-moves-host"), newSession("test-moves-guest")
+	room := host.createRoom(false, 0)
+	defer host.leaveRoom()
+	srv := helperUserServer(session)
+	defer srv.Close()
+	stop, stopped := make(chan bool), make(chan bool)
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			session.joinRoom(room.code)
+			session.leaveRoom()
+		}
+	}()
+	errs := make(chan error, clientCount)
+	for c := 0; c < clientCount; c++ {
+		go func(c int) {
+			for i := 0; i < runCount; i++ {
+				body := fmt.Sprintf(`{"index": %d, "value": %d}`, 3+(c+i)%2, 1+(c+i)%9)
+				r, e := http.Post(srv.URL+"/api/assign/", "application/json", strings.NewReader(body))
+				if e == nil {
+					r.Body.Close()
+					if r.StatusCode >= http.StatusInternalServerError {
+						e = fmt.Errorf("Assign gave %d", r.StatusCode)
+					}
+				}
+				if e == nil {
+					r, e = http.Get(srv.URL + "/api/back/")
+				}
+				if e != nil {
+					errs <- e
+					return
+				}
+				r.Body.Close()
+			}
+			errs <- nil
+		}(c)
+	}
+	for c := 0; c < clientCount; c++ {
+		if e := <-errs; e != nil {
+			t.Errorf("Client request failed: %v", e)
+		}
+	}
+	close(stop)
+	<-stopped
+	session.lockBoard()
+	defer session.unlockBoard()
+	if session.room != nil || len(session.members) != 1 || session.members[0] != session {
+		t.Errorf("Session ended up on board with room %v and members %v", session.room, session.members)
 	}
 }
 
@@ -311,3 +466,423 @@ func TestIssue1(t *testing.T) {
 		}
 	}
 }
+
+func TestUndoKeepsMarks(t *testing.T) {
+	session := newSession("test-marks")
+	session.reset("1-star")
+	p := session.steps[0]
+	if _, e := p.MarkCandidate(puzzle.Choice{Index: 2, Value: 6}); e != nil {
+		t.Fatalf("Failed to mark square 2: %v", e)
+	}
+	next := p.Copy()
+	if _, e := next.Assign(puzzle.Choice{Index: 2, Value: 6}); e != nil {
+		t.Fatalf("Failed to assign square 2: %v", e)
+	}
+	session.addStep(next)
+	if _, e := next.MarkCandidate(puzzle.Choice{Index: 3, Value: 1}); e != nil {
+		t.Fatalf("Failed to mark square 3: %v", e)
+	}
+	session.undoStep()
+	ss := session.steps[len(session.steps)-1].Squares()
+	if len(ss[1].Marks) != 1 || ss[1].Marks[0] != 6 {
+		t.Errorf("Square 2 marks after undo are %v, expected [6]", ss[1].Marks)
+	}
+	if len(ss[2].Marks) != 1 || ss[2].Marks[0] != 1 {
+		t.Errorf("Square 3 marks after undo are %v, expected [1]", ss[2].Marks)
+	}
+}
+
+func TestVerifyRoute(t *testing.T) {
+	session := newSession("test-verify")
+	session.reset("2-star")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	vals := puzzleValues["1-star"]
+	p, e := puzzle.New(vals)
+	if e != nil {
+		t.Fatalf("Failed to create 1-star puzzle: %v", e)
+	}
+	solution := append([]int{vals[0]}, p.Solutions()[0].Values...)
+	bs, e := json.Marshal(puzzle.Claim{Fingerprint: puzzle.Fingerprint(vals), Values: solution})
+	if e != nil {
+		t.Fatalf("Failed to encode claim: %v", e)
+	}
+	r, e := http.Post(srv.URL+"/api/verify/", "application/json", bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Request error: %v", e)
+	}
+	var v puzzle.Verification
+	e = json.NewDecoder(r.Body).Decode(&v)
+	r.Body.Close()
+	if e != nil || !v.Valid {
+		t.Errorf("Verification of 1-star solution was %+v (%v)", v, e)
+	}
+}
+
+func TestRelaxedMode(t *testing.T) {
+	session := newSession("test-relaxed")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	helperUserRequest(t, srv, "", "GET", "/reset/1-star?mode=relaxed", nil, nil)
+	if !session.relaxed || session.contest {
+		t.Fatalf("Session is not in relaxed mode after reset")
+	}
+
+	// square 2 shares a row with the given 4 in square 1
+	var update puzzle.Update
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", puzzle.Choice{Index: 2, Value: 4}, &update); status != http.StatusOK {
+		t.Fatalf("Duplicate assignment gave status %d", status)
+	}
+	if len(update.Conflicts) == 0 || update.Conflicts[0].Kind != puzzle.ConflictDuplicate {
+		t.Errorf("Duplicate assignment gave update %+v", update)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", puzzle.Choice{Index: 2, Value: 6}, &update); status != http.StatusOK {
+		t.Errorf("Reassignment gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", puzzle.Choice{Index: 1, Value: 6}, nil); status != http.StatusBadRequest {
+		t.Errorf("Assignment to a given gave status %d", status)
+	}
+	if len(session.steps) != 3 {
+		t.Errorf("Relaxed board has %d steps, expected 3", len(session.steps))
+	}
+
+	// the mode survives checkpoints
+	c, ok := session.checkpoint()
+	if !ok {
+		t.Fatalf("Relaxed session couldn't be checkpointed")
+	}
+	restored, e := c.restore("test-relaxed-restored")
+	if e != nil || !restored.relaxed || restored.steps[2].State().Values[1] != 6 {
+		t.Errorf("Restored relaxed session gave %v", e)
+	}
+}
+
+func TestKillerPuzzle(t *testing.T) {
+	session := newSession("test-killer")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	helperUserRequest(t, srv, "", "GET", "/reset/killer", nil, nil)
+	if session.puzzleID != "killer" {
+		t.Fatalf("Session has puzzle %q after reset", session.puzzleID)
+	}
+	var squares []puzzle.Square
+	if status := helperUserRequest(t, srv, "", "GET", "/api/squares/", nil, &squares); status != http.StatusOK {
+		t.Fatalf("Squares request gave status %d", status)
+	}
+	if len(squares) != 81 || squares[0].Cage != 1 || squares[0].Sum != 24 {
+		t.Errorf("Killer squares start %+v", squares[:1])
+	}
+	var update puzzle.Update
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign/", puzzle.Choice{Index: 1, Value: 9}, &update); status != http.StatusOK {
+		t.Fatalf("Assignment gave status %d", status)
+	}
+	if len(update.Errors) != 0 || update.Squares[0].Cage != 1 {
+		t.Errorf("Assignment gave update %+v", update)
+	}
+}
+
+func TestContestMode(t *testing.T) {
+	session := newSession("test-contest")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	r, e := client.Get(srv.URL + "/reset/1-star?mode=contest")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if !session.contest {
+		t.Fatalf("Session is not in contest mode after reset")
+	}
+	r, e = client.Get(srv.URL + "/api/")
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	var squares []puzzle.Square
+	e = json.NewDecoder(r.Body).Decode(&squares)
+	r.Body.Close()
+	if e != nil {
+		t.Fatalf("Squares decode error: %v", e)
+	}
+	for _, s := range squares {
+		if s.Pvals != nil || s.Bval != 0 {
+			t.Fatalf("Contest squares revealed data: %+v", s)
+		}
+	}
+	r, e = client.Post(srv.URL+"/api/submit/", "application/json", nil)
+	if e != nil {
+		t.Fatalf("Submit request error: %v", e)
+	}
+	var v puzzle.Verification
+	e = json.NewDecoder(r.Body).Decode(&v)
+	r.Body.Close()
+	if e != nil || v.Valid || v.Fingerprint != puzzle.Fingerprint(puzzleValues["1-star"]) {
+		t.Errorf("Submission of unsolved puzzle gave %+v, %v", v, e)
+	}
+	r, e = client.Get(srv.URL + "/reset/1-star")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if session.contest {
+		t.Errorf("Session is still in contest mode after normal reset")
+	}
+}
+
+func TestUIHints(t *testing.T) {
+	session := newSession("test-ui-hints")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	hintIDs := func() []string {
+		r, e := http.Get(srv.URL + "/api/ui-hints")
+		if e != nil {
+			t.Fatalf("Hints request error: %v", e)
+		}
+		defer r.Body.Close()
+		var hints []uiHint
+		if e := json.NewDecoder(r.Body).Decode(&hints); e != nil {
+			t.Fatalf("Hints decode error: %v", e)
+		}
+		ids := []string{}
+		for _, h := range hints {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	if ids := hintIDs(); !reflect.DeepEqual(ids, []string{"assign"}) {
+		t.Errorf("New session got hints %v", ids)
+	}
+	squares := session.steps[0].Squares()
+	for _, s := range squares {
+		if s.Aval == 0 && s.Bval != 0 {
+			body, _ := json.Marshal(puzzle.Choice{Index: s.Index, Value: s.Bval})
+			r, e := http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(body))
+			if e != nil {
+				t.Fatalf("Assign request error: %v", e)
+			}
+			r.Body.Close()
+			break
+		}
+	}
+	if ids := hintIDs(); !reflect.DeepEqual(ids, []string{"undo"}) {
+		t.Errorf("Session with one assign got hints %v", ids)
+	}
+	r, e := http.Get(srv.URL + "/api/back/")
+	if e != nil {
+		t.Fatalf("Undo request error: %v", e)
+	}
+	r.Body.Close()
+	if ids := hintIDs(); len(ids) != 0 {
+		t.Errorf("Session with assign and undo got hints %v", ids)
+	}
+}
+
+func TestImproperReset(t *testing.T) {
+	session := newSession("test-improper")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for id, vals := range puzzleValues {
+		p, _ := puzzle.New(vals)
+		r, e := client.Get(srv.URL + "/reset/" + id)
+		if e != nil {
+			t.Fatalf("Reset request error: %v", e)
+		}
+		r.Body.Close()
+		if session.puzzleID != id {
+			t.Errorf("Reset to %q gave puzzle %q", id, session.puzzleID)
+		}
+		warning := r.Header.Get("Warning")
+		if proper := p.IsProper() == nil; proper != (warning == "") {
+			t.Errorf("Reset to %q (proper = %v) gave warning %q", id, proper, warning)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	session := newSession("test-generate")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var first, second puzzle.Generated
+	if status := helperGetJSON(t, srv, "/api/generate?seed=shared&givens=30", &first); status != http.StatusOK {
+		t.Fatalf("Generate gave status %d", status)
+	}
+	if first.Seed != fmt.Sprintf("%d:shared", puzzle.SeedVersion) || first.Givens != 30 {
+		t.Errorf("Generated params are %+v", first.GenerateParams)
+	}
+	helperGetJSON(t, srv, "/api/generate?seed="+url.QueryEscape(first.Seed)+"&givens=30", &second)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Same seed gave %v and %v", first.Values, second.Values)
+	}
+	var random puzzle.Generated
+	if status := helperGetJSON(t, srv, "/api/generate", &random); status != http.StatusOK || random.Seed == "" {
+		t.Errorf("Generate without a seed gave %d, %+v", status, random.GenerateParams)
+	}
+	// random seeds come from the seed source
+	defer func(source io.Reader) { seedSource = source }(seedSource)
+	var streamed [2]puzzle.Generated
+	for i := range streamed {
+		seedSource = puzzle.NewSeedSource("test-generate")
+		helperGetJSON(t, srv, "/api/generate", &streamed[i])
+	}
+	if streamed[0].Seed == "" || !reflect.DeepEqual(streamed[0], streamed[1]) {
+		t.Errorf("The same seed source gave %+v and %+v", streamed[0].GenerateParams, streamed[1].GenerateParams)
+	}
+	for _, query := range []string{"seed=99:future", "sidelen=6", "givens=lots"} {
+		if status := helperGetJSON(t, srv, "/api/generate?"+query, &random); status != http.StatusBadRequest {
+			t.Errorf("Generate with %s gave status %d", query, status)
+		}
+	}
+
+	// generating with a rating profile rates the puzzle
+	var rated ratedPuzzle
+	if status := helperGetJSON(t, srv, "/api/generate?seed=shared&givens=30&profile=singles", &rated); status != http.StatusOK {
+		t.Fatalf("Generate with a profile gave status %d", status)
+	}
+	if !reflect.DeepEqual(rated.Generated, first) || rated.Rating.Stars == 0 {
+		t.Errorf("Generate with a profile gave %+v", rated)
+	}
+	for _, query := range []string{"profile=easy", "disable=guess"} {
+		if status := helperGetJSON(t, srv, "/api/generate?"+query, &random); status != http.StatusBadRequest {
+			t.Errorf("Generate with %s gave status %d", query, status)
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	session := newSession("test-conflicts")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var conflicts []puzzle.Conflict
+	if status := helperGetJSON(t, srv, "/api/conflicts", &conflicts); status != http.StatusOK || conflicts == nil || len(conflicts) != 0 {
+		t.Errorf("Conflicts of a new board gave %d, %+v", status, conflicts)
+	}
+}
+
+func TestRatingProfiles(t *testing.T) {
+	session := newSession("test-rating-profiles")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var standard, singles, custom puzzle.Rating
+	helperGetJSON(t, srv, "/api/rating/", &standard)
+	helperGetJSON(t, srv, "/api/rating/?profile=singles", &singles)
+	helperGetJSON(t, srv, "/api/rating/?profile=no-subsets&disable="+url.QueryEscape(puzzle.TechniqueLocked), &custom)
+	if standard.Stars == 0 || singles.Stars < standard.Stars || custom.Stars != singles.Stars {
+		t.Errorf("Ratings were %+v, %+v, and %+v", standard, singles, custom)
+	}
+	for _, tc := range singles.Techniques {
+		if tc.Technique != puzzle.TechniqueHiddenSingle && tc.Technique != puzzle.TechniqueNakedSingle &&
+			tc.Technique != puzzle.TechniqueGuess {
+			t.Errorf("Singles rating used %s", tc.Technique)
+		}
+	}
+	if status := helperGetJSON(t, srv, "/api/rating/?disable=x-wing", &custom); status != http.StatusBadRequest {
+		t.Errorf("Rating with an unknown technique gave status %d", status)
+	}
+}
+
+func TestUndoToCertain(t *testing.T) {
+	session := newSession("test-undo-certain")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	p, _ := puzzle.New(session.values)
+	solution := p.Solutions()[0].Values
+	var empty []int
+	for i, v := range session.values[1:] {
+		if v == 0 {
+			empty = append(empty, i)
+		}
+	}
+	get := func(path string) int {
+		r, e := http.Get(srv.URL + path)
+		if e != nil {
+			t.Fatalf("Request error: %v", e)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+
+	// right, wrong, right (if the wrong one doesn't block it): the
+	// last certain step is after the first
+	helperRoomAssign(t, srv, puzzle.Choice{Index: empty[0] + 1, Value: solution[empty[0]]})
+	helperRoomAssign(t, srv, puzzle.Choice{Index: empty[1] + 1, Value: solution[empty[1]]%9 + 1})
+	helperRoomAssign(t, srv, puzzle.Choice{Index: empty[2] + 1, Value: solution[empty[2]]})
+	before := len(session.steps)
+	if status := get("/api/back/certain/"); status != http.StatusOK || len(session.steps) != 2 {
+		t.Errorf("Undo to certain gave status %d, left %d steps", status, len(session.steps))
+	}
+	if session.stats.Undos != before-2 {
+		t.Errorf("Undo to certain counted %d undos", session.stats.Undos)
+	}
+	if get("/api/back/certain/"); len(session.steps) != 2 {
+		t.Errorf("Undo to certain with no mistakes left %d steps", len(session.steps))
+	}
+
+	// contest boards don't reveal mistakes
+	get("/reset/" + session.puzzleID + "?mode=contest")
+	helperRoomAssign(t, srv, puzzle.Choice{Index: empty[1] + 1, Value: solution[empty[1]]%9 + 1})
+	if status := get("/api/back/certain/"); status != http.StatusForbidden || len(session.steps) != 2 {
+		t.Errorf("Undo to certain on a contest board gave status %d, left %d steps", status, len(session.steps))
+	}
+}
+
+func TestAssignBatch(t *testing.T) {
+	session := newSession("test-assign-batch")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	p, _ := puzzle.New(session.values)
+	solution := p.Solutions()[0].Values
+	var right []puzzle.Choice
+	for i, v := range session.values[1:] {
+		if v == 0 && len(right) < 3 {
+			right = append(right, puzzle.Choice{Index: i + 1, Value: solution[i]})
+		}
+	}
+	post := func(choices []puzzle.Choice) (int, puzzle.Update) {
+		bs, _ := json.Marshal(choices)
+		r, e := http.Post(srv.URL+"/api/assign-batch/", "application/json", bytes.NewReader(bs))
+		if e != nil {
+			t.Fatalf("Request error: %v", e)
+		}
+		defer r.Body.Close()
+		var update puzzle.Update
+		json.NewDecoder(r.Body).Decode(&update)
+		return r.StatusCode, update
+	}
+
+	// a batch with a bad choice changes nothing
+	bad := append(append([]puzzle.Choice{}, right[:2]...), puzzle.Choice{Index: right[0].Index, Value: 1})
+	if status, _ := post(bad); status != http.StatusBadRequest || len(session.steps) != 1 {
+		t.Errorf("Bad batch gave status %d, left %d steps", status, len(session.steps))
+	}
+	if session.stats.Assignments != 0 {
+		t.Errorf("Bad batch counted %d assignments", session.stats.Assignments)
+	}
+
+	// a good batch is a single step
+	status, update := post(right)
+	if status != http.StatusOK || len(session.steps) != 2 || session.stats.Assignments != len(right) {
+		t.Errorf("Good batch gave status %d, left %d steps and %d assignments",
+			status, len(session.steps), session.stats.Assignments)
+	}
+	for _, choice := range right {
+		if session.steps[1].State().Values[choice.Index-1] != choice.Value {
+			t.Errorf("Batch didn't assign %+v", choice)
+		}
+	}
+	if len(update.Squares) < len(right) {
+		t.Errorf("Batch update has only %d squares", len(update.Squares))
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Session merges
+
+An identified user has one session, but a browser that played
+anonymously before logging in has its own session too.  Rather
+than silently dropping the browser's play in favor of the
+user's, the server offers to merge them: the user session keeps
+a merge offer for each browser session with moves on its board,
+and the user decides what to do with each one.
+
+Merging combines the two sessions' histories (their counts of
+actions, and, when both boards are on the same puzzle, their
+statistics) and keeps the more advanced of the two boards: a
+completed board beats an incomplete one, and otherwise the one
+with more squares filled wins, with ties going to the user's
+board.  The user's board is always kept while it's shared in a
+room or in a running blitz attempt, since other players (or the
+clock) depend on it, and a browser board in a running blitz
+attempt isn't moved.  Dismissing an offer drops the browser's
+session without merging it.
+
+*/
+
+// A mergeOffer is a browser session that can be merged into a
+// user session.
+type mergeOffer struct {
+	id      int
+	session *susenSession
+}
+
+// boardSummary describes how far along a board is.
+type boardSummary struct {
+	PuzzleID  string `json:"puzzleID"`
+	Filled    int    `json:"filled"` // squares filled beyond the givens
+	Steps     int    `json:"steps"`
+	Completed bool   `json:"completed,omitempty"`
+}
+
+// mergeOfferInfo describes a merge offer.
+type mergeOfferInfo struct {
+	ID    int          `json:"id"`
+	Board boardSummary `json:"board"`
+}
+
+// mergeInfo is the response to merge requests: the user's board
+// and the merge offers still open.
+type mergeInfo struct {
+	Board  boardSummary     `json:"board"`
+	Offers []mergeOfferInfo `json:"offers"`
+}
+
+// worthMerging tells whether a browser session has anything to
+// merge, which is when there have been moves on its board.
+func (session *susenSession) worthMerging() bool {
+	session.lockBoard()
+	defer session.unlockBoard()
+	return len(session.steps) > 1
+}
+
+// offerMerge adds a merge offer for a browser session to a user
+// session, unless it's already offered.
+func (session *susenSession) offerMerge(browser *susenSession) {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	for _, offer := range session.merges {
+		if offer.session == browser {
+			return
+		}
+	}
+	session.lastMerge++
+	session.merges = append(session.merges, mergeOffer{id: session.lastMerge, session: browser})
+	log.Printf("Session %v can be merged into session %v.", browser.sessionID, session.sessionID)
+}
+
+// takeMerge removes a merge offer from the session, returning
+// the offered session (or nil, if there's no such offer).
+func (session *susenSession) takeMerge(id int) *susenSession {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	for i, offer := range session.merges {
+		if offer.id == id {
+			session.merges = append(session.merges[:i], session.merges[i+1:]...)
+			return offer.session
+		}
+	}
+	return nil
+}
+
+// summary describes the board's progress.  It must be called
+// with the board locked.
+func (board *susenBoard) summary() boardSummary {
+	s := boardSummary{PuzzleID: board.puzzleID, Steps: len(board.steps),
+		Completed: board.stats.Completed != nil}
+	for i, v := range board.steps[len(board.steps)-1].State().Values {
+		if v != 0 && board.values[i+1] == 0 {
+			s.Filled++
+		}
+	}
+	return s
+}
+
+// advancedOver tells whether a board summary is further along
+// than another.
+func (s boardSummary) advancedOver(other boardSummary) bool {
+	if s.Completed != other.Completed {
+		return s.Completed
+	}
+	return s.Filled > other.Filled
+}
+
+// mergeInfo describes the session's board and merge offers.
+func (session *susenSession) mergeInfo() mergeInfo {
+	session.lockBoard()
+	info := mergeInfo{Board: session.summary(), Offers: []mergeOfferInfo{}}
+	session.unlockBoard()
+	session.infoMutex.Lock()
+	offers := append([]mergeOffer{}, session.merges...)
+	session.infoMutex.Unlock()
+	for _, offer := range offers {
+		offer.session.lockBoard()
+		info.Offers = append(info.Offers, mergeOfferInfo{ID: offer.id, Board: offer.session.summary()})
+		offer.session.unlockBoard()
+	}
+	return info
+}
+
+// merge merges a browser session into the session, which then
+// has the more advanced of their boards and both of their
+// histories.  The browser session is dropped.
+func (session *susenSession) merge(browser *susenSession) {
+	// take a copy of the browser's board, so only one board is
+	// locked at a time
+	browser.lockBoard()
+	theirs := browser.summary()
+	movable := browser.blitz == nil || browser.blitz.over
+	moved := susenBoard{
+		puzzleID:   browser.puzzleID,
+		contest:    browser.contest,
+		unassisted: browser.unassisted,
+		relaxed:    browser.relaxed,
+		values:     browser.values,
+		steps:      make([]puzzle.Puzzle, len(browser.steps)),
+		stats:      browser.stats,
+		lastHint:   browser.lastHint,
+	}
+	for i, step := range browser.steps {
+		moved.steps[i] = step.Copy()
+	}
+	moved.stats.tries = append([]int(nil), browser.stats.tries...)
+	moved.stats.fillers = append([]string(nil), browser.stats.fillers...)
+	moved.stats.names = make(map[string]string)
+	for key, name := range browser.stats.names {
+		moved.stats.names[key] = name
+	}
+	browser.unlockBoard()
+
+	session.lockBoard()
+	ours := session.summary()
+	samePuzzle := moved.puzzleID == session.puzzleID && puzzle.Fingerprint(moved.values) == puzzle.Fingerprint(session.values)
+	fixed := session.room != nil || (session.blitz != nil && !session.blitz.over)
+	kept := "user"
+	if movable && !fixed && theirs.advancedOver(ours) {
+		kept = "browser"
+		if samePuzzle {
+			moved.stats = combineStats(moved.stats, session.stats)
+		}
+		session.stopBlitz()
+		session.puzzleID, session.contest, session.unassisted = moved.puzzleID, moved.contest, moved.unassisted
+		session.relaxed, session.certifiable = moved.relaxed, false
+		session.values, session.steps, session.stats, session.lastHint = moved.values, moved.steps, moved.stats, moved.lastHint
+		session.notifySquares()
+	} else if samePuzzle {
+		session.stats = combineStats(session.stats, moved.stats)
+	}
+	session.unlockBoard()
+
+	browser.infoMutex.Lock()
+	actions := append([]int(nil), browser.actions...)
+	browser.infoMutex.Unlock()
+	session.infoMutex.Lock()
+	if session.actions == nil {
+		session.actions = make([]int, maxAction)
+	}
+	for i, n := range actions {
+		session.actions[i] += n
+	}
+	session.infoMutex.Unlock()
+
+	session.drop(browser)
+	log.Printf("Session %v merged into session %v, keeping the %s board.",
+		browser.sessionID, session.sessionID, kept)
+}
+
+// combineStats combines the statistics of two plays of the same
+// puzzle into those of the kept play.
+func combineStats(kept, other puzzleStats) puzzleStats {
+	// completed plays keep their times, which have already been
+	// entered on the leaderboard
+	if kept.Completed == nil && other.Started.Before(kept.Started) {
+		kept.Started = other.Started
+	}
+	kept.Assignments += other.Assignments
+	kept.Undos += other.Undos
+	kept.Hints += other.Hints
+	for i := range kept.tries {
+		if i < len(other.tries) {
+			kept.tries[i] += other.tries[i]
+		}
+	}
+	for i := range kept.fillers {
+		if kept.fillers[i] == "" && i < len(other.fillers) && other.fillers[i] != "" {
+			if kept.names == nil {
+				kept.names = make(map[string]string)
+			}
+			kept.fillers[i], kept.names[other.fillers[i]] = other.fillers[i], other.names[other.fillers[i]]
+		}
+	}
+	return kept
+}
+
+// drop drops a browser session that's been merged into (or
+// dismissed by) the session: it leaves any room it's in, and
+// its browser gets a new session if it's used anonymously again.
+func (session *susenSession) drop(browser *susenSession) {
+	if browser == session {
+		return
+	}
+	browser.leaveRoom()
+	sessionMutex.Lock()
+	if sessions[browser.sessionID] == browser {
+		delete(sessions, browser.sessionID)
+	}
+	sessionMutex.Unlock()
+}
+
+// mergeHandler handles the merge endpoints:
+//
+// - GET /api/merge/ gives the user's board and merge offers
+//
+// - POST /api/merge/<id> merges the offered session
+//
+// - DELETE /api/merge/<id> dismisses the offer, dropping the
+// offered session
+//
+// All of them respond with the user's merge information, and
+// they're only for identified users.
+func (session *susenSession) mergeHandler(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled("accounts") {
+		featureOff(w, "accounts")
+		return
+	}
+	if auth.FromRequest(r) == nil {
+		sendError(w, http.StatusForbidden, requestError("Only identified users have sessions to merge"))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/merge/"), "/")
+	if r.Method == "POST" || r.Method == "DELETE" {
+		id, e := strconv.Atoi(path)
+		browser := session.takeMerge(id)
+		if e != nil || browser == nil {
+			sendError(w, http.StatusNotFound, requestError("No merge offer "+path))
+			return
+		}
+		if r.Method == "POST" {
+			session.merge(browser)
+		} else {
+			session.drop(browser)
+			log.Printf("Session %v dismissed merging session %v.", session.sessionID, browser.sessionID)
+		}
+	}
+	sendJSON(w, http.StatusOK, session.mergeInfo())
+}
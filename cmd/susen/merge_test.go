@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// helperBrowserRequest makes a request from a browser, returning
+// the status.  If the request succeeds, the response is decoded
+// into out.
+func helperBrowserRequest(t *testing.T, c *http.Client, srv *httptest.Server, method, path string, body, out interface{}) int {
+	var bs []byte
+	if body != nil {
+		bs, _ = json.Marshal(body)
+	}
+	req, e := http.NewRequest(method, srv.URL+path, bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Failed to make %s %s request: %v", method, path, e)
+	}
+	r, e := c.Do(req)
+	if e != nil {
+		t.Fatalf("%s %s request error: %v", method, path, e)
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusOK && out != nil {
+		if e := json.NewDecoder(r.Body).Decode(out); e != nil {
+			t.Fatalf("%s %s decode error: %v", method, path, e)
+		}
+	}
+	return r.StatusCode
+}
+
+func TestSessionMerge(t *testing.T) {
+	// like TestAccountSessions, this test makes sessions through
+	// the real session selection, so it cleans them up afterwards
+	existing := make(map[string]bool)
+	sessionMutex.RLock()
+	for key := range sessions {
+		existing[key] = true
+	}
+	sessionMutex.RUnlock()
+	defer func() {
+		sessionMutex.Lock()
+		for key := range sessions {
+			if !existing[key] {
+				delete(sessions, key)
+			}
+		}
+		sessionMutex.Unlock()
+	}()
+
+	srv := httptest.NewServer(susenHandler(accounts))
+	defer srv.Close()
+	creds := &accountRequest{"test-merger", "password1"}
+	p, _ := puzzle.New(puzzleValues[defaultPuzzleID])
+	solution := p.Solutions()[0].Values
+	var empty []int
+	for i, v := range puzzleValues[defaultPuzzleID][1:] {
+		if v == 0 {
+			empty = append(empty, i+1)
+		}
+	}
+	play := func(c *http.Client, moves int) {
+		for _, index := range empty[:moves] {
+			choice := puzzle.Choice{Index: index, Value: solution[index-1]}
+			if status := helperBrowserRequest(t, c, srv, "POST", "/api/assign/", choice, nil); status != http.StatusOK {
+				t.Fatalf("Assign gave status %d", status)
+			}
+		}
+		// each browser fills different squares
+		empty = empty[moves:]
+	}
+
+	// the first browser registers and makes a move
+	first, second, third, fourth := helperBrowser(t), helperBrowser(t), helperBrowser(t), helperBrowser(t)
+	helperBrowserRequest(t, first, srv, "GET", "/reset/"+defaultPuzzleID, nil, nil)
+	if status, _ := helperAccountRequest(t, first, srv, "register", creds); status != http.StatusOK {
+		t.Fatalf("Register gave status %d", status)
+	}
+	play(first, 1)
+	if status := helperBrowserRequest(t, third, srv, "GET", "/api/merge/", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Anonymous merge request gave status %d", status)
+	}
+
+	// the second browser plays further before logging in, and
+	// the third logs in without playing
+	helperBrowserRequest(t, second, srv, "GET", "/reset/"+defaultPuzzleID, nil, nil)
+	play(second, 3)
+	helperAccountRequest(t, second, srv, "login", creds)
+	helperBrowserRequest(t, third, srv, "GET", "/reset/"+defaultPuzzleID, nil, nil)
+	helperAccountRequest(t, third, srv, "login", creds)
+	var info mergeInfo
+	helperBrowserRequest(t, first, srv, "GET", "/api/merge/", nil, &info)
+	if info.Board.Filled != 1 || len(info.Offers) != 1 || info.Offers[0].Board.Filled != 3 {
+		t.Fatalf("Merge info before merging is %+v", info)
+	}
+	browser := helperBrowserSession(t, second, srv)
+
+	// merging keeps the second browser's board, with both
+	// browsers' assignments
+	if status := helperBrowserRequest(t, third, srv, "POST", "/api/merge/99", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Merge of unknown offer gave status %d", status)
+	}
+	path := "/api/merge/" + strconv.Itoa(info.Offers[0].ID)
+	if status := helperBrowserRequest(t, third, srv, "POST", path, nil, &info); status != http.StatusOK {
+		t.Fatalf("Merge gave status %d", status)
+	}
+	if info.Board.Filled != 3 || info.Board.Steps != 4 || len(info.Offers) != 0 {
+		t.Errorf("Merge info after merging is %+v", info)
+	}
+	sessionMutex.RLock()
+	shared, dropped := sessions["user:account:test-merger"], sessions[browser.sessionID] != browser
+	sessionMutex.RUnlock()
+	if shared.stats.Assignments != 4 || !dropped {
+		t.Errorf("Merged session has %d assignments, dropped = %v", shared.stats.Assignments, dropped)
+	}
+
+	// a less advanced board can be merged or dismissed, and
+	// doesn't replace the user's board either way
+	play(fourth, 1)
+	helperAccountRequest(t, fourth, srv, "login", creds)
+	helperBrowserRequest(t, fourth, srv, "GET", "/api/merge/", nil, &info)
+	if len(info.Offers) != 1 {
+		t.Fatalf("Merge info with a new browser is %+v", info)
+	}
+	path = "/api/merge/" + strconv.Itoa(info.Offers[0].ID)
+	if status := helperBrowserRequest(t, fourth, srv, "DELETE", path, nil, &info); status != http.StatusOK {
+		t.Fatalf("Dismissal gave status %d", status)
+	}
+	if info.Board.Filled != 3 || len(info.Offers) != 0 || shared.stats.Assignments != 4 {
+		t.Errorf("Merge info after dismissal is %+v", info)
+	}
+}
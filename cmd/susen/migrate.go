@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ancientHacker/susen.go/storage"
+	"io"
+)
+
+/*
+
+Schema migrations
+
+	susen migrate status [-store dsn]
+	susen migrate up [-store dsn] [-to version]
+	susen migrate down [-store dsn] [-to version]
+
+The migrate command manages the schema of a SQL store (see
+storage.Migrations) from the migrations built into the server,
+so deployments don't need a separate migration tool.  The store
+is the one named by -store, or else the server's (see
+accounts.go).  status
+lists the migrations and whether each is applied; up applies
+them all, or those up to -to; and down undoes the last one, or
+all those after -to (so -to 0 undoes them all).
+
+*/
+
+// migrateCommand runs the migrate command, returning the exit
+// status.
+func migrateCommand(args []string, out, errOut io.Writer) int {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "up" && args[0] != "down") {
+		fmt.Fprintf(errOut, "usage: susen migrate status|up|down [-store dsn] [-to version]\n")
+		return 2
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	dsn := flags.String("store", storeDSN(), "data source name of the SQL store")
+	to := flags.Int("to", -1, "version to migrate to")
+	if e := flags.Parse(args[1:]); e != nil || flags.NArg() > 0 {
+		return 2
+	}
+	m, e := storage.OpenMigrator(*dsn)
+	if e != nil {
+		fmt.Fprintf(errOut, "susen migrate: %v\n", e)
+		return 1
+	}
+	defer m.Close()
+	status, e := m.Status()
+	if e != nil {
+		fmt.Fprintf(errOut, "susen migrate: %v\n", e)
+		return 1
+	}
+	var done []storage.Migration
+	switch args[0] {
+	case "status":
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%04d %s: %s\n", s.Version, s.Name, state)
+		}
+		return 0
+	case "up":
+		if *to < 0 {
+			*to = 0
+		}
+		done, e = m.Up(*to)
+	case "down":
+		if *to < 0 {
+			*to = previousVersion(status)
+		}
+		done, e = m.Down(*to)
+	}
+	for _, migration := range done {
+		fmt.Fprintf(out, "%s %04d %s\n", args[0], migration.Version, migration.Name)
+	}
+	if e != nil {
+		fmt.Fprintf(errOut, "susen migrate: %v\n", e)
+		return 1
+	}
+	return 0
+}
+
+// previousVersion returns the version before the last applied
+// migration, which is what down goes back to by default.
+func previousVersion(status []storage.MigrationStatus) int {
+	last := -1
+	for i, s := range status {
+		if s.Applied {
+			last = i
+		}
+	}
+	if last <= 0 {
+		return 0
+	}
+	return status[last-1].Version
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ancientHacker/susen.go/storage"
+	"strings"
+	"testing"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	for _, args := range [][]string{nil, {"sideways"}, {"up", "-to", "x"}, {"status", "extra"}} {
+		if status := migrateCommand(args, &out, &errOut); status != 2 {
+			t.Errorf("migrate %v gave status %d", args, status)
+		}
+	}
+	// the SQL drivers aren't linked into the test binary
+	errOut.Reset()
+	if status := migrateCommand([]string{"status", "-store", "sqlite:/tmp/susen.db"}, &out, &errOut); status != 1 ||
+		!strings.Contains(errOut.String(), "no sqlite database driver") {
+		t.Errorf("migrate status without a driver gave %d, %q", status, errOut.String())
+	}
+	errOut.Reset()
+	if status := migrateCommand([]string{"up", "-store", "memory:"}, &out, &errOut); status != 1 ||
+		!strings.Contains(errOut.String(), "Not a SQL store") {
+		t.Errorf("migrate up of a memory store gave %d, %q", status, errOut.String())
+	}
+	st := func(applied ...bool) []storage.MigrationStatus {
+		var status []storage.MigrationStatus
+		for i, a := range applied {
+			status = append(status, storage.MigrationStatus{Migration: storage.Migration{Version: i + 1}, Applied: a})
+		}
+		return status
+	}
+	if v := previousVersion(st(true, true, false)); v != 1 {
+		t.Errorf("Down from version 2 goes to %d", v)
+	}
+	if v := previousVersion(st(true, false)); v != 0 {
+		t.Errorf("Down from version 1 goes to %d", v)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Failed migrations wrote %q", out.String())
+	}
+}
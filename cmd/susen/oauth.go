@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/ancientHacker/susen.go/auth"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+
+OAuth sign-in
+
+Players can sign in to accounts through GitHub or Google instead
+of with passwords, once the deployment has registered the server
+with them: SUSEN_OAUTH_GITHUB and SUSEN_OAUTH_GOOGLE give the
+client ID and secret, separated by a colon, and the callback URL
+to register is the server's /auth/<provider>/callback.
+
+- GET /auth/ lists the providers players can sign in through
+
+- GET /auth/<provider> sends the browser to the provider to sign
+in, with ?return=<path> to come back to a page other than the
+solver (only paths on this server are taken: see localPath)
+
+- GET /auth/<provider>/callback is where the provider sends the
+browser back.  It logs the browser in to the account linked to
+the identity (making one if need be, or linking the identity to
+the account the browser is already logged in to), and sends it
+on to the page it came from.
+
+As with password logins, a browser session that was played
+anonymously is offered for merging into the account's session
+(see merge.go).  The sign-in's state is checked against the
+cookie set when it began, which has to come back on the
+provider's redirect, so it's never SameSite=Strict.  (See
+auth/oauth.go for the rest of the checks.)  Turning off the
+accounts feature turns off sign-in too.
+
+*/
+
+var oauth = auth.NewOAuth(accounts)
+
+// oauthProviders returns the providers configured in the
+// environment (looked up with getenv).
+func oauthProviders(getenv func(string) string) []auth.OAuthProvider {
+	var providers []auth.OAuthProvider
+	for _, name := range []string{"github", "google"} {
+		credentials := getenv("SUSEN_OAUTH_" + strings.ToUpper(name))
+		colon := strings.Index(credentials, ":")
+		if colon < 1 {
+			if credentials != "" {
+				log.Printf("Ignoring %s sign-in credentials without a client ID and secret.", name)
+			}
+			continue
+		}
+		p := auth.OAuthProviders[name]
+		p.ClientID, p.ClientSecret = credentials[:colon], credentials[colon+1:]
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// oauthHandler handles the sign-in endpoints.
+func (session *susenSession) oauthHandler(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled("accounts") {
+		featureOff(w, "accounts")
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/auth/"), "/")
+	if path == "" {
+		sendJSON(w, http.StatusOK, oauth.Providers())
+		return
+	}
+	provider := strings.TrimSuffix(path, "/callback")
+	if provider == path {
+		back := r.URL.Query().Get("return")
+		if !localPath(back) {
+			back = "/solver/"
+		}
+		to, state, e := oauth.Begin(provider, requestOrigin(r)+"/auth/"+provider+"/callback", back)
+		if e == auth.ErrUnknownProvider {
+			sendOAuthError(w, e)
+			return
+		} else if e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't start signing in: "+e.Error()))
+			return
+		}
+		c := sessionCookie.apply(&http.Cookie{
+			Name:     auth.OAuthStateCookie,
+			Value:    state,
+			Path:     "/auth/",
+			MaxAge:   int(auth.OAuthStateLifetime.Seconds()),
+			HttpOnly: true,
+		}, r)
+		if c.SameSite == http.SameSiteStrictMode {
+			c.SameSite = http.SameSiteLaxMode
+		}
+		http.SetCookie(w, c)
+		http.Redirect(w, r, to, http.StatusFound)
+		return
+	}
+
+	q := r.URL.Query()
+	if refusal := q.Get("error"); refusal != "" {
+		sendError(w, http.StatusUnauthorized, requestError("Sign-in was refused: "+refusal))
+		return
+	}
+	http.SetCookie(w, sessionCookie.apply(&http.Cookie{Name: auth.OAuthStateCookie, Path: "/auth/", MaxAge: -1}, r))
+	c, e := r.Cookie(auth.OAuthStateCookie)
+	if e != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(q.Get("state"))) != 1 {
+		sendOAuthError(w, auth.ErrOAuthState)
+		return
+	}
+	user, token, back, e := oauth.Complete(provider, q.Get("state"), q.Get("code"), auth.FromRequest(r))
+	if e != nil {
+		sendOAuthError(w, e)
+		return
+	}
+	setLoginCookie(w, r, token)
+	if us, _, _ := userSession(session, user); us != nil {
+		us.setUser(user)
+	}
+	log.Printf("Session %v signed in through %s as %v.", session.sessionID, provider, user.Key())
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+// localPath tells whether a return path leads to a page on this
+// server: it has to be an absolute path, with no scheme or host,
+// and no backslashes (which browsers take as slashes, so that
+// "/\evil.example" goes to another site).
+func localPath(back string) bool {
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") || strings.Contains(back, "\\") {
+		return false
+	}
+	u, e := url.Parse(back)
+	return e == nil && u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// sendOAuthError sends the response to a sign-in that failed.
+func sendOAuthError(w http.ResponseWriter, e error) {
+	status := http.StatusBadGateway // the provider failed
+	switch e {
+	case auth.ErrUnknownProvider:
+		status = http.StatusNotFound
+	case auth.ErrOAuthState, auth.ErrOAuthNonce, auth.ErrOAuthIdentity:
+		status = http.StatusUnauthorized
+	case auth.ErrIdentityLinked:
+		status = http.StatusConflict
+	}
+	log.Printf("Sign-in failed: %v", e)
+	sendError(w, status, requestError(e.Error()))
+}
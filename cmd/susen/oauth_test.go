@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOAuthSignIn(t *testing.T) {
+	// this test makes sessions through the real session
+	// selection, so it has to clean them up afterwards
+	existing := make(map[string]bool)
+	sessionMutex.RLock()
+	for key := range sessions {
+		existing[key] = true
+	}
+	sessionMutex.RUnlock()
+	defer func() {
+		sessionMutex.Lock()
+		for key := range sessions {
+			if !existing[key] {
+				delete(sessions, key)
+			}
+		}
+		sessionMutex.Unlock()
+	}()
+
+	// the provider signs everyone in as the same user
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/authorize":
+			back := r.URL.Query().Get("redirect_uri") + "?" + url.Values{"code": {"c"}, "state": {r.URL.Query().Get("state")}}.Encode()
+			http.Redirect(w, r, back, http.StatusFound)
+		case "/token":
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "at"})
+		case "/user":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 4242, "login": "Hub Player"})
+		}
+	}))
+	defer provider.Close()
+	github := oauthProviders(func(string) string { return "client:secret" })[0]
+	github.AuthURL, github.TokenURL, github.UserURL = provider.URL+"/authorize", provider.URL+"/token", provider.URL+"/user"
+	saved := oauth
+	oauth = auth.NewOAuth(accounts, github)
+	defer func() { oauth = saved }()
+	srv := httptest.NewServer(susenHandler(accounts))
+	defer srv.Close()
+
+	var names []string
+	if status := helperGetJSON(t, srv, "/auth/", &names); status != http.StatusOK || len(names) != 1 || names[0] != "github" {
+		t.Errorf("Provider list gave %d, %v", status, names)
+	}
+	if status := helperGetJSON(t, srv, "/auth/myspace", nil); status != http.StatusNotFound {
+		t.Errorf("Sign-in with an unknown provider gave status %d", status)
+	}
+
+	// the browser goes to the provider and back, and ends up
+	// logged in where it started
+	browser := helperBrowser(t)
+	r, e := browser.Get(srv.URL + "/auth/github?return=/api/account/")
+	if e != nil {
+		t.Fatalf("Sign-in request error: %v", e)
+	}
+	var info accountInfo
+	json.NewDecoder(r.Body).Decode(&info)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || info.User == nil || info.User.Key() != "account:hub-player" {
+		t.Fatalf("Sign-in ended with %d, %+v", r.StatusCode, info)
+	}
+	if _, info := helperAccountRequest(t, browser, srv, "", nil); info.User == nil || info.User.ID != "hub-player" {
+		t.Errorf("Signed-in browser is user %+v", info.User)
+	}
+
+	// a callback with a state the browser didn't start fails
+	_, state, _ := oauth.Begin("github", srv.URL+"/auth/github/callback", "/solver/")
+	if status := helperGetJSON(t, srv, "/auth/github/callback?code=c&state="+state, nil); status != http.StatusUnauthorized {
+		t.Errorf("Callback without the state cookie gave status %d", status)
+	}
+	if status := helperGetJSON(t, srv, "/auth/github/callback?error=access_denied", nil); status != http.StatusUnauthorized {
+		t.Errorf("Refused sign-in gave status %d", status)
+	}
+	if providers := oauthProviders(func(string) string { return "no-secret" }); len(providers) != 0 {
+		t.Errorf("Providers without credentials are %+v", providers)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for back, local := range map[string]bool{
+		"/solver/":                 true,
+		"/api/account/?x=1#top":    true,
+		"":                         false,
+		"solver/":                  false,
+		"//evil.example":           false,
+		"/\\evil.example":          false,
+		"/\\/evil.example":         false,
+		"/path\\..\\x":             false,
+		"https://evil.example/":    false,
+		"/\t/evil.example":         false,
+		"/ok\nLocation: elsewhere": false,
+	} {
+		if localPath(back) != local {
+			t.Errorf("localPath(%q) is %v", back, !local)
+		}
+	}
+}
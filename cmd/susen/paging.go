@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+/*
+
+Pagination
+
+The list endpoints (the catalog, the solve archive, leaderboards,
+and the admin list of sessions) send their lists a page at a
+time, so that big deployments don't send unbounded responses.
+The shape of each response is the same as it always was, with
+the page in place of the whole list:
+
+- ?limit=<n> asks for pages of n items; the default is
+defaultPageSize, and pages are never bigger than maxPageSize
+
+- if there are more items, the X-Next-Cursor header gives a
+cursor, and ?cursor=<cursor> asks for the page after it
+
+- ?total=true asks for the number of items in the whole list,
+which comes in the X-Total-Count header
+
+Cursors are opaque to clients.  Each names the last item of its
+page by its sort key, so lists that are sorted by key (such as
+the catalog) don't skip or repeat items when they change between
+pages.  Ranked lists use the rank as the key.
+
+*/
+
+// The page sizes, and the pagination headers.
+const (
+	defaultPageSize   = 50
+	maxPageSize       = 200
+	nextCursorHeader  = "X-Next-Cursor"
+	totalCountHeader  = "X-Total-Count"
+	cursorKeyPrefix   = "k:" // so cursors can change form later
+	rankKeyDigitCount = 9
+)
+
+// pageOf works out which items of a list of n items, sorted by
+// the given keys, a request asks for, and sets the pagination
+// headers of its response.  It returns the bounds of the page,
+// or false (having sent the response) if the request's paging
+// is invalid.
+func pageOf(w http.ResponseWriter, r *http.Request, n int, key func(i int) string) (int, int, bool) {
+	q := r.URL.Query()
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		var e error
+		if limit, e = strconv.Atoi(v); e != nil || limit < 1 {
+			sendError(w, http.StatusBadRequest, requestError("Invalid page size: "+v))
+			return 0, 0, false
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+	lo := 0
+	if v := q.Get("cursor"); v != "" {
+		after, ok := decodeCursor(v)
+		if !ok {
+			sendError(w, http.StatusBadRequest, requestError("Invalid cursor: "+v))
+			return 0, 0, false
+		}
+		lo = sort.Search(n, func(i int) bool { return key(i) > after })
+	}
+	hi := lo + limit
+	if hi >= n {
+		hi = n
+	} else {
+		w.Header().Set(nextCursorHeader, encodeCursor(key(hi-1)))
+	}
+	if total, _ := strconv.ParseBool(q.Get("total")); total {
+		w.Header().Set(totalCountHeader, strconv.Itoa(n))
+	}
+	return lo, hi, true
+}
+
+// rankKey is the sort key of the item at a position in a ranked
+// list.
+func rankKey(i int) string {
+	return fmt.Sprintf("%0*d", rankKeyDigitCount, i)
+}
+
+// pageOfKeys returns the page of a sorted list of keys that a
+// request asks for (see pageOf).
+func pageOfKeys(w http.ResponseWriter, r *http.Request, keys []string) ([]string, bool) {
+	lo, hi, ok := pageOf(w, r, len(keys), func(i int) string { return keys[i] })
+	if !ok {
+		return nil, false
+	}
+	return keys[lo:hi], true
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorKeyPrefix + key))
+}
+
+func decodeCursor(cursor string) (string, bool) {
+	b, e := base64.RawURLEncoding.DecodeString(cursor)
+	if e != nil || len(b) < len(cursorKeyPrefix) || string(b[:len(cursorKeyPrefix)]) != cursorKeyPrefix {
+		return "", false
+	}
+	return string(b[len(cursorKeyPrefix):]), true
+}
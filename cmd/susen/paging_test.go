@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// helperPage gets a page of a list as a user, returning the
+// status and the next cursor and total count headers.
+func helperPage(t *testing.T, srv *httptest.Server, user, path string, out interface{}) (int, string, string) {
+	req, _ := http.NewRequest("GET", srv.URL+path, nil)
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	r, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatalf("Page request error: %v", e)
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(out); e != nil {
+			t.Fatalf("Page decode error: %v", e)
+		}
+	}
+	return r.StatusCode, r.Header.Get(nextCursorHeader), r.Header.Get(totalCountHeader)
+}
+
+func TestPaging(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	srv := helperUserServer(newSession("test-paging"))
+	defer srv.Close()
+
+	// following the cursors gets the whole catalog
+	var all []string
+	cursor, pages := "", 0
+	for {
+		var ids []string
+		status, next, total := helperPage(t, srv, "", "/api/catalog/?limit=2&total=true&cursor="+cursor, &ids)
+		if status != http.StatusOK || len(ids) == 0 || len(ids) > 2 || total != strconv.Itoa(len(puzzleValues)) {
+			t.Fatalf("Catalog page gave %d, %v, total %q", status, ids, total)
+		}
+		all, cursor, pages = append(all, ids...), next, pages+1
+		if cursor == "" {
+			break
+		}
+	}
+	if !reflect.DeepEqual(all, catalogIDs()) || pages != (len(puzzleValues)+1)/2 {
+		t.Errorf("Catalog pages were %v in %d pages", all, pages)
+	}
+	var ids []string
+	if status, next, total := helperPage(t, srv, "", "/api/catalog/", &ids); status != http.StatusOK ||
+		len(ids) != len(puzzleValues) || next != "" || total != "" {
+		t.Errorf("Unpaged catalog gave %d, %v, %q, %q", status, ids, next, total)
+	}
+	for _, query := range []string{"limit=0", "limit=many", "cursor=%21%21", "cursor=" + encodeCursor("x")[1:]} {
+		if status, _, _ := helperPage(t, srv, "", "/api/catalog/?"+query, &ids); status != http.StatusBadRequest {
+			t.Errorf("Catalog page with %s gave status %d", query, status)
+		}
+	}
+
+	// leaderboard pages say where they start
+	for i := 1; i <= 5; i++ {
+		addLeaderboardEntry(defaultPuzzleID, leaderboardEntry{Key: "test:" + strconv.Itoa(i), Name: "p", SolveTime: float64(i)})
+	}
+	var lb localizedLeaderboard
+	_, next, _ := helperPage(t, srv, "", "/api/leaderboard/"+defaultPuzzleID+"?limit=2", &lb)
+	if lb.First != 1 || len(lb.Entries) != 2 || next == "" {
+		t.Fatalf("First leaderboard page is %+v, next %q", lb, next)
+	}
+	helperPage(t, srv, "", "/api/leaderboard/"+defaultPuzzleID+"?limit=2&cursor="+next, &lb)
+	if lb.First != 3 || len(lb.Entries) != 2 || lb.Entries[0].SolveTime != 3 {
+		t.Errorf("Second leaderboard page is %+v", lb)
+	}
+
+	// admins can page through the sessions
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+	sessionMutex.Lock()
+	sessions["test-paging-a"], sessions["test-paging-b"] = newSession("test-paging-a"), newSession("test-paging-b")
+	sessionMutex.Unlock()
+	defer func() {
+		sessionMutex.Lock()
+		delete(sessions, "test-paging-a")
+		delete(sessions, "test-paging-b")
+		sessionMutex.Unlock()
+	}()
+	var infos []sessionInfo
+	if status, _, _ := helperPage(t, srv, "sam", "/api/admin/sessions/", &infos); status != http.StatusForbidden {
+		t.Errorf("Player session listing gave status %d", status)
+	}
+	status, _, total := helperPage(t, srv, "root", "/api/admin/sessions/?total=1&limit="+strconv.Itoa(maxPageSize), &infos)
+	if n, _ := strconv.Atoi(total); status != http.StatusOK || n < 2 || len(infos) != n {
+		t.Fatalf("Session listing gave %d, %+v, total %q", status, infos, total)
+	}
+	found := 0
+	for _, info := range infos {
+		if info.Key == "test-paging-a" || info.Key == "test-paging-b" {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("Session listing %+v is missing test sessions", infos)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/client"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Plain solver page
+
+The solver page needs JavaScript, so /solver/plain is a fallback
+for browsers without it (and for screen readers): a page that's
+rendered entirely by the server, whose board is a form.
+
+- GET /solver/plain shows the session's board, with an input
+for each empty square, each labeled with its row and column
+
+- POST /solver/plain with action=assign assigns the values
+filled in (c<index>=<value>) as one move; with action=undo, it
+takes back the last move; and with action=reset, it starts the
+puzzle named by puzzle=<puzzleID>
+
+Posts are made as API requests to the session (just as gRPC
+calls are; see grpc.go), so they follow all the same rules, and
+are redirected back to the page when they succeed.  When they
+fail, the page is shown again with the error.  Posts from other
+origins are refused, since the form relies on the session
+cookie.
+
+*/
+
+// plainSolverPath is the path of the plain solver page.
+const plainSolverPath = "/solver/plain"
+
+// plainPuzzleIDs are the puzzles the plain solver page can
+// start, in order.
+var plainPuzzleIDs = []string{"1-star", "2-star", "3-star", "4-star", "5-star", "6-star"}
+
+// plainSolverHandler handles the plain solver page.
+func (session *susenSession) plainSolverHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		session.sendPlainPage(w, http.StatusOK, "")
+	case "POST":
+		if origin := r.Header.Get("Origin"); origin != "" && origin != requestOrigin(r) {
+			sendError(w, http.StatusForbidden, requestError("The plain solver only takes posts from its own page"))
+			return
+		}
+		if message := session.plainPost(r); message != "" {
+			session.sendPlainPage(w, http.StatusBadRequest, message)
+			return
+		}
+		http.Redirect(w, r, plainSolverPath+session.slotQuery(), http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		sendError(w, http.StatusMethodNotAllowed, requestError("The plain solver only takes GET and POST"))
+	}
+}
+
+// plainPost makes the change posted by the plain solver page's
+// form, and returns why it failed (or nothing if it didn't).
+func (session *susenSession) plainPost(r *http.Request) string {
+	if e := r.ParseForm(); e != nil {
+		return "Invalid form: " + e.Error()
+	}
+	var e error
+	switch r.PostForm.Get("action") {
+	case "assign":
+		choices, message := plainChoices(r.PostForm)
+		if message != "" {
+			return message
+		}
+		e = session.apiCall(r, "POST", "/api/v1/assign-batch", choices, nil)
+	case "undo":
+		e = session.apiCall(r, "GET", "/api/v1/back/", nil, nil)
+	case "reset":
+		puzzleID := r.PostForm.Get("puzzle")
+		if i := sort.SearchStrings(plainPuzzleIDs, puzzleID); i == len(plainPuzzleIDs) || plainPuzzleIDs[i] != puzzleID {
+			return fmt.Sprintf("Unknown puzzle %q", puzzleID)
+		}
+		e = session.apiCall(r, "GET", "/reset/"+url.PathEscape(puzzleID), nil, nil)
+	default:
+		return "Unknown action"
+	}
+	if e != nil {
+		if ge, ok := e.(grpcError); ok {
+			return ge.message
+		}
+		return e.Error()
+	}
+	return ""
+}
+
+// plainChoices returns the choices filled in on the plain solver
+// page's form, in square order, or why they're invalid.
+func plainChoices(form url.Values) ([]puzzle.Choice, string) {
+	var choices []puzzle.Choice
+	for name, values := range form {
+		if !strings.HasPrefix(name, "c") || len(values) == 0 {
+			continue
+		}
+		index, e := strconv.Atoi(name[1:])
+		if e != nil {
+			continue
+		}
+		s := strings.TrimSpace(values[0])
+		if s == "" {
+			continue
+		}
+		value, e := strconv.Atoi(s)
+		if e != nil {
+			return nil, fmt.Sprintf("%q isn't a value (in square %d)", s, index)
+		}
+		choices = append(choices, puzzle.Choice{Index: index, Value: value})
+	}
+	if len(choices) == 0 {
+		return nil, "Fill in a square to assign it"
+	}
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Index < choices[j].Index })
+	return choices, ""
+}
+
+// sendPlainPage sends the plain solver page for the session's
+// board, with a message (if any) about the last post.
+func (session *susenSession) sendPlainPage(w http.ResponseWriter, status int, message string) {
+	session.lockBoard()
+	state := session.steps[len(session.steps)-1].State()
+	puzzleID := session.puzzleID
+	session.unlockBoard()
+	if message != "" {
+		log.Printf("Plain solver post for session %v failed: %s", session.sessionID, message)
+	}
+	body := client.PlainSolverPage(plainSolverPath+session.slotQuery(), puzzleID, state, message, plainPuzzleIDs)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
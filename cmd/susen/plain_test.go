@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/client"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// helperPlainPost posts the plain solver page's form, and returns
+// the status and body of the page it ends up on.
+func helperPlainPost(t *testing.T, srv *httptest.Server, form url.Values) (int, string) {
+	r, e := http.PostForm(srv.URL+plainSolverPath, form)
+	if e != nil {
+		t.Fatalf("Plain solver post error: %v", e)
+	}
+	defer r.Body.Close()
+	body, _ := ioutil.ReadAll(r.Body)
+	return r.StatusCode, string(body)
+}
+
+func TestPlainSolver(t *testing.T) {
+	if tmpl, e := fs.Sub(staticAssets(), "tmpl"); e == nil {
+		client.SetDefaultTemplateFS(tmpl)
+	}
+	session := newSession("test-plain")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	p, e := puzzle.New(session.values)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	solution := p.Solutions()[0].Values
+	var empty []int
+	for i, s := range p.Squares() {
+		if s.Aval == 0 {
+			empty = append(empty, i+1)
+		}
+	}
+
+	// the page has an input for each empty square
+	r, e := http.Get(srv.URL + plainSolverPath)
+	if e != nil {
+		t.Fatalf("Plain solver request error: %v", e)
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || strings.Count(string(body), "<input") != len(empty) {
+		t.Fatalf("Plain solver page gave %d:\n%s", r.StatusCode, body)
+	}
+
+	// filled-in squares are assigned as one move
+	form := url.Values{"action": {"assign"}}
+	for _, index := range empty[:2] {
+		form.Set("c"+strconv.Itoa(index), strconv.Itoa(solution[index-1]))
+	}
+	if status, page := helperPlainPost(t, srv, form); status != http.StatusOK ||
+		strings.Count(page, "<input") != len(empty)-2 {
+		t.Fatalf("Assign gave %d:\n%s", status, page)
+	}
+	if len(session.steps) != 2 || session.stats.Assignments != 2 {
+		t.Errorf("Assign left %d steps, %d assignments", len(session.steps), session.stats.Assignments)
+	}
+
+	// bad values and failed moves show the page again, with why
+	form = url.Values{"action": {"assign"}, "c" + strconv.Itoa(empty[2]): {"x"}}
+	if status, page := helperPlainPost(t, srv, form); status != http.StatusBadRequest || !strings.Contains(page, "isn&#39;t a value") {
+		t.Errorf("Assign of a bad value gave %d:\n%s", status, page)
+	}
+	form = url.Values{"action": {"assign"}, "c" + strconv.Itoa(empty[0]): {"1"}}
+	if status, page := helperPlainPost(t, srv, form); status != http.StatusBadRequest || !strings.Contains(page, `role="alert"`) {
+		t.Errorf("Assign to a filled square gave %d:\n%s", status, page)
+	}
+	if len(session.steps) != 2 {
+		t.Errorf("Failed assigns left %d steps", len(session.steps))
+	}
+
+	// undo takes back the move, and reset starts another puzzle
+	if status, _ := helperPlainPost(t, srv, url.Values{"action": {"undo"}}); status != http.StatusOK || len(session.steps) != 1 {
+		t.Errorf("Undo gave %d, left %d steps", status, len(session.steps))
+	}
+	if status, _ := helperPlainPost(t, srv, url.Values{"action": {"reset"}, "puzzle": {"2-star"}}); status != http.StatusOK ||
+		session.puzzleID != "2-star" {
+		t.Errorf("Reset gave %d, puzzle %q", status, session.puzzleID)
+	}
+	if status, _ := helperPlainPost(t, srv, url.Values{"action": {"reset"}, "puzzle": {"7-star"}}); status != http.StatusBadRequest {
+		t.Errorf("Reset to an unknown puzzle gave %d", status)
+	}
+
+	// posts from other sites are refused
+	req, _ := http.NewRequest("POST", srv.URL+plainSolverPath, strings.NewReader("action=undo"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	if r, e := http.DefaultClient.Do(req); e != nil || r.StatusCode != http.StatusForbidden {
+		t.Errorf("Cross-site post gave %v, %v", r, e)
+	} else {
+		r.Body.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+
+Polling intervals
+
+Clients that can't keep a WebSocket open (see events.go) poll
+for their board's squares instead.  So they don't have to guess
+how often, squares and update responses suggest when to poll
+next, as a number of seconds in the pollHeader response header.
+The suggestion follows the board and the server:
+
+- a board shared in a room (see rooms.go) that changed in the
+last pollBusyWindow suggests pollFastest, so partners see each
+other's moves promptly
+
+- any other board suggests pollInterval, backing off as it stays
+unchanged, to a tenth of how long it's been quiet, but never
+more than pollSlowest
+
+- while the server is taking more than pollLoadRate requests a
+minute, every suggestion is stretched in proportion, again to no
+more than pollSlowest
+
+Clients are free to poll sooner (after a move of their own, say),
+but the suggestion is what keeps the idle ones from adding up.
+
+*/
+
+// pollHeader is the response header with the suggested number
+// of seconds until the next poll.
+const pollHeader = "X-Poll-Interval"
+
+// The polling intervals, and the load at which they stretch.
+var (
+	pollFastest    = time.Second
+	pollInterval   = 5 * time.Second
+	pollSlowest    = time.Minute
+	pollBusyWindow = 30 * time.Second
+	pollLoadRate   = 6000 // requests a minute
+)
+
+// pollSuggestion returns how long the board's pollers should
+// wait, at the given time and with the given number of requests
+// in the last minute.  It must be called with the board locked.
+func (board *susenBoard) pollSuggestion(now time.Time, requests int) time.Duration {
+	quiet := now.Sub(board.changed)
+	if board.changed.IsZero() {
+		quiet = 0
+	}
+	interval := pollInterval
+	switch {
+	case board.room != nil && len(board.members) > 1 && quiet < pollBusyWindow:
+		interval = pollFastest
+	case quiet/10 > interval:
+		interval = quiet / 10
+	}
+	if requests > pollLoadRate {
+		interval = interval * time.Duration(requests) / time.Duration(pollLoadRate)
+	}
+	if interval > pollSlowest {
+		interval = pollSlowest
+	}
+	return interval
+}
+
+// attachPollInterval adds the board's suggested polling interval
+// to a response.  It must be called with the board locked.
+func (board *susenBoard) attachPollInterval(w http.ResponseWriter) {
+	now := time.Now()
+	interval := board.pollSuggestion(now, requestEvents.count(now, time.Minute))
+	w.Header().Set(pollHeader, strconv.Itoa(int((interval+time.Second-1)/time.Second)))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPollSuggestion(t *testing.T) {
+	session := newSession("test-poll")
+	now := time.Now()
+	cases := []struct {
+		changed  time.Duration // before now
+		room     bool
+		requests int
+		expect   time.Duration
+	}{
+		{0, false, 0, pollInterval},
+		{10 * time.Second, false, 0, pollInterval},
+		{5 * time.Minute, false, 0, 30 * time.Second},
+		{time.Hour, false, 0, pollSlowest},
+		{10 * time.Second, true, 0, pollFastest},
+		{5 * time.Minute, true, 0, 30 * time.Second},
+		{10 * time.Second, false, 2 * pollLoadRate, 2 * pollInterval},
+		{10 * time.Second, true, 3 * pollLoadRate, 3 * pollFastest},
+		{time.Hour, true, 3 * pollLoadRate, pollSlowest},
+	}
+	for i, c := range cases {
+		session.changed = now.Add(-c.changed)
+		session.room, session.members = nil, []*susenSession{session}
+		if c.room {
+			session.room = &susenRoom{code: "TEST", board: session.susenBoard}
+			session.members = append(session.members, newSession("test-poll-partner"))
+		}
+		if interval := session.pollSuggestion(now, c.requests); interval != c.expect {
+			t.Errorf("Case %d: suggestion was %v, expected %v", i, interval, c.expect)
+		}
+	}
+}
+
+func TestPollHeader(t *testing.T) {
+	session := newSession("test-poll-header")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	session.changed = time.Now().Add(-10 * time.Minute)
+	r, e := http.Get(srv.URL + "/api/squares/")
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	r.Body.Close()
+	if h := r.Header.Get(pollHeader); h != "60" {
+		t.Errorf("Quiet board's poll interval was %q", h)
+	}
+	var choice puzzle.Choice
+	for _, s := range session.steps[0].Squares() {
+		if s.Aval == 0 {
+			choice = puzzle.Choice{Index: s.Index, Value: s.Pvals[0]}
+			break
+		}
+	}
+	body, _ := json.Marshal(choice)
+	r, e = http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(body))
+	if e != nil {
+		t.Fatalf("Assign request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || r.Header.Get(pollHeader) != "60" {
+		t.Errorf("Assign gave %d, poll interval %q", r.StatusCode, r.Header.Get(pollHeader))
+	}
+	r, e = http.Get(srv.URL + "/api/squares/")
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	r.Body.Close()
+	if h := r.Header.Get(pollHeader); h != "5" {
+		t.Errorf("Changed board's poll interval was %q", h)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// The Postgres driver, for SQL stores (see storage.NewSQL), is
+// only linked into binaries built with the postgres tag.
+import _ "github.com/lib/pq"
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*
+
+Preferences
+
+Each session has preferences, kept with it (in its checkpoints,
+so they outlast the server), that shape the server's responses to
+it:
+
+- relaxed: puzzles started without a mode (see /reset/) are
+started in relaxed mode, where entries can break the rules
+
+- autoMarks: squares come with their possible values, which
+clients show as automatic pencil marks; without it, responses
+only have the marks the player made
+
+- hintDetail: how much a hint gives away (see suggest.go): "full"
+gives the square, its value, the technique, and any chain;
+"answer" leaves out the chain; "technique" gives the square and
+the technique, but not the value; and "square" only gives the
+square to look at
+
+- sidelen: the side length of generated puzzles (see
+generateHandler), when the request doesn't give one
+
+- hideStatus: the board's status, which says whether its entries
+are still right (see status.go), isn't given, for players who'd
+rather find out for themselves
+
+GET /api/prefs gives the session's preferences, and PUT
+/api/prefs changes them: the body is a JSON object with the
+preferences to change, and the response is all of them.  A slot
+(see slots.go) has the preferences of its session.  Preferences
+only apply to what happens after they're changed: a board that's
+already started keeps its mode, for instance.  They shape the
+session's own responses, not the events its board's watchers get
+(see events.go), which are shared with any other members of the
+board.
+
+*/
+
+// The hint details.
+const (
+	fullHints      = "full"
+	answerHints    = "answer"
+	techniqueHints = "technique"
+	squareHints    = "square"
+)
+
+// sessionPrefs are a session's preferences.
+type sessionPrefs struct {
+	Relaxed    bool   `json:"relaxed"`
+	AutoMarks  bool   `json:"autoMarks"`
+	HintDetail string `json:"hintDetail"`
+	SideLength int    `json:"sidelen"`
+	HideStatus bool   `json:"hideStatus"`
+}
+
+// defaultPrefs are the preferences of sessions that haven't set
+// any.
+var defaultPrefs = sessionPrefs{AutoMarks: true, HintDetail: fullHints, SideLength: 9}
+
+// check returns an error if the preferences aren't valid.
+func (p sessionPrefs) check() error {
+	switch p.HintDetail {
+	case fullHints, answerHints, techniqueHints, squareHints:
+	default:
+		return requestError("Invalid hint detail: " + p.HintDetail)
+	}
+	if p.SideLength != 4 && p.SideLength != 9 {
+		return requestError("Generated puzzles can only have side length 4 or 9")
+	}
+	return nil
+}
+
+// preferences returns the session's preferences.
+func (session *susenSession) preferences() sessionPrefs {
+	if session.owner != nil {
+		return session.owner.preferences()
+	}
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if session.prefs == nil {
+		return defaultPrefs
+	}
+	return *session.prefs
+}
+
+// shapeHint trims a hint to the detail the preferences ask for.
+func (p sessionPrefs) shapeHint(hint puzzle.Hint) puzzle.Hint {
+	switch p.HintDetail {
+	case answerHints:
+		hint.Chain = nil
+	case techniqueHints:
+		hint.Chain, hint.Choice.Value = nil, 0
+	case squareHints:
+		hint = puzzle.Hint{Choice: puzzle.Choice{Index: hint.Choice.Index}}
+	}
+	return hint
+}
+
+// An unmarkedPuzzle leaves the possible values out of the
+// squares it gives.
+type unmarkedPuzzle struct {
+	puzzle.Puzzle
+}
+
+// Squares leaves out the possible values.
+func (u unmarkedPuzzle) Squares() []puzzle.Square {
+	return unmark(u.Puzzle.Squares())
+}
+
+// Assign leaves the possible values out of the update.
+func (u unmarkedPuzzle) Assign(choice puzzle.Choice) (puzzle.Update, error) {
+	update, e := u.Puzzle.Assign(choice)
+	update.Squares = unmark(update.Squares)
+	return update, e
+}
+
+// unmark removes the possible values from squares.
+func unmark(squares []puzzle.Square) []puzzle.Square {
+	for i := range squares {
+		squares[i].Pvals = nil
+	}
+	return squares
+}
+
+// shown shows a step of the board to the session: with its
+// guesses flagged (see guesses.go), the sources of its squares
+// (see sources.go), its fill progress in updates (see
+// fillprogress.go), and as the session's preferences have it.
+func (session *susenSession) shown(step puzzle.Puzzle) puzzle.Puzzle {
+	view := puzzle.Puzzle(progressPuzzle{sourcedPuzzle{session.guessed(step), session}, step})
+	if !session.preferences().AutoMarks {
+		return unmarkedPuzzle{view}
+	}
+	return view
+}
+
+// prefsHandler handles the preferences endpoints.
+func (session *susenSession) prefsHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.Trim(r.URL.Path, "/") != "api/prefs" {
+		sendError(w, http.StatusNotFound, requestError("Unknown preferences operation: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		prefs := session.preferences()
+		if e := decodeRequest(w, r, &prefs); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid preferences: "+e.Error()))
+			return
+		}
+		if e := prefs.check(); e != nil {
+			sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+			return
+		}
+		session.infoMutex.Lock()
+		session.prefs = &prefs
+		session.infoMutex.Unlock()
+		log.Printf("Session %v changed its preferences to %+v.", session.sessionID, prefs)
+	default:
+		sendError(w, http.StatusMethodNotAllowed, requestError("Preferences can only be read or replaced"))
+		return
+	}
+	sendJSON(w, http.StatusOK, session.preferences())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrefs(t *testing.T) {
+	session := newSession("test-prefs")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var prefs sessionPrefs
+	if status := helperGetJSON(t, srv, "/api/prefs", &prefs); status != http.StatusOK || prefs != defaultPrefs {
+		t.Fatalf("Default preferences gave %d, %+v", status, prefs)
+	}
+	for _, body := range []interface{}{"relaxed", map[string]interface{}{"hintDetail": "all"},
+		map[string]interface{}{"sidelen": 16}} {
+		if status := helperUserRequest(t, srv, "", "PUT", "/api/prefs", body, nil); status != http.StatusBadRequest {
+			t.Errorf("Invalid preferences %v gave status %d", body, status)
+		}
+	}
+	big := map[string]interface{}{"hintDetail": strings.Repeat("x", maxRequestBytes)}
+	if status := helperUserRequest(t, srv, "", "PUT", "/api/prefs", big, nil); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Big preferences gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "DELETE", "/api/prefs", nil, nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("Delete of preferences gave status %d", status)
+	}
+
+	// a partial change keeps the other preferences
+	body := map[string]interface{}{"relaxed": true, "autoMarks": false, "hintDetail": "technique", "sidelen": 4}
+	if status := helperUserRequest(t, srv, "", "PUT", "/api/prefs", body, &prefs); status != http.StatusOK ||
+		prefs != (sessionPrefs{true, false, techniqueHints, 4, false}) {
+		t.Fatalf("Change of preferences gave %d, %+v", status, prefs)
+	}
+	if status := helperUserRequest(t, srv, "", "PUT", "/api/prefs", map[string]interface{}{"sidelen": 9}, &prefs); status != http.StatusOK ||
+		prefs != (sessionPrefs{true, false, techniqueHints, 9, false}) {
+		t.Fatalf("Partial change of preferences gave %d, %+v", status, prefs)
+	}
+
+	// responses follow them
+	r, e := http.Get(srv.URL + "/reset/")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if !session.relaxed {
+		t.Errorf("Reset without a mode didn't relax the board")
+	}
+	var squares []puzzle.Square
+	helperGetJSON(t, srv, "/api/squares/", &squares)
+	for _, s := range squares {
+		if s.Pvals != nil {
+			t.Fatalf("Square %d has possible values %v", s.Index, s.Pvals)
+		}
+	}
+	var hint hintResponse
+	if status := helperGetJSON(t, srv, "/api/hint/", &hint); status != http.StatusOK ||
+		hint.Hint.Choice.Index == 0 || hint.Hint.Choice.Value != 0 || hint.Hint.Technique == "" {
+		t.Errorf("Technique hint gave %d, %+v", status, hint)
+	}
+	var g puzzle.Generated
+	helperUserRequest(t, srv, "", "PUT", "/api/prefs", map[string]interface{}{"sidelen": 4}, &prefs)
+	if status := helperGetJSON(t, srv, "/api/generate?givens=8", &g); status != http.StatusOK || g.SideLength != 4 {
+		t.Errorf("Generate gave %d, %+v", status, g.GenerateParams)
+	}
+
+	// slots share them, and they're kept in checkpoints
+	var slotPrefs sessionPrefs
+	if helperGetJSON(t, srv, "/api/prefs?slot=work", &slotPrefs); slotPrefs != prefs {
+		t.Errorf("Slot preferences are %+v, not %+v", slotPrefs, prefs)
+	}
+	c, _ := session.checkpoint()
+	var restored *susenSession
+	restored, e = c.restore("test-prefs-restored")
+	if e != nil || restored.preferences() != prefs {
+		t.Errorf("Restored preferences are %+v (%v)", restored.preferences(), e)
+	}
+}
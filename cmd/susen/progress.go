@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Search progress streams
+
+Solving a big puzzle, or generating one, can take seconds, so
+clients can watch it happen instead of waiting on a request that
+may time out.  These endpoints are server-sent event streams:
+
+- GET /api/progress/solutions/ solves the board's puzzle as it is
+now, under the same rules as GET /api/solutions/ (see suggest.go)
+
+- GET /api/progress/generate/ generates a puzzle, from the same
+query parameters as GET /api/generate/ (and metered the same way)
+
+Each stream sends progress events (see puzzle.Progress), at most
+every progressEventInterval, and then a result event with the
+final progress and the solutions or generated puzzle, or an
+error event with the Error that stopped it.  When the client
+goes away, the search stops.  (Servers with a write timeout cut
+off streams that go on longer than it.)
+
+*/
+
+// The event stream's event names, and how often it sends
+// progress.
+const (
+	progressEvent         = "progress"
+	resultEvent           = "result"
+	errorEvent            = "error"
+	progressEventInterval = 100 * time.Millisecond
+)
+
+// A progressResult is the result of a watched search.
+type progressResult struct {
+	Progress  puzzle.Progress   `json:"progress"`
+	Solutions []puzzle.Solution `json:"solutions,omitempty"`
+	Puzzle    *puzzle.Generated `json:"puzzle,omitempty"`
+}
+
+// An eventStream sends server-sent events.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// startEventStream starts the event stream response to a
+// request, or returns false (having sent an error response) if
+// the response can't be streamed.
+func startEventStream(w http.ResponseWriter) (*eventStream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, http.StatusInternalServerError, requestError("Responses can't be streamed"))
+		return nil, false
+	}
+	hs := w.Header()
+	hs.Set("Content-Type", "text/event-stream")
+	hs.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventStream{w, flusher}, true
+}
+
+// send sends an event, with its data as JSON.
+func (s *eventStream) send(event string, data interface{}) error {
+	bs, e := json.Marshal(data)
+	if e != nil {
+		return e
+	}
+	if _, e := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, bs); e != nil {
+		return e
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// fail sends the error that stopped a search.
+func (s *eventStream) fail(e error) {
+	err, ok := e.(puzzle.Error)
+	if !ok {
+		err = requestError(e.Error())
+	}
+	err.Message = err.Error()
+	s.send(errorEvent, err)
+}
+
+// watcher returns a search watcher that sends the search's
+// progress on the stream, and stops the search when the client
+// goes away.  The final progress is kept in last.
+func (s *eventStream) watcher(r *http.Request, last *puzzle.Progress) func(puzzle.Progress) bool {
+	var sent time.Time
+	return func(p puzzle.Progress) bool {
+		*last = p
+		if r.Context().Err() != nil {
+			return false
+		}
+		if time.Since(sent) >= progressEventInterval {
+			sent = time.Now()
+			if e := s.send(progressEvent, p); e != nil {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// progressHandler handles the progress stream endpoints.  The
+// board is only locked while the search is set up, so the board
+// can still be played while it's searched.
+func (session *susenSession) progressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Progress streams can only be read"))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/progress/"), "/")
+	var search func(watch func(puzzle.Progress) bool) (progressResult, error)
+	switch path {
+	case "solutions":
+		session.lockBoard()
+		if session.refuseSolutions(w, r) {
+			session.unlockBoard()
+			return
+		}
+		p := session.steps[len(session.steps)-1].Copy()
+		session.unlockBoard()
+		search = func(watch func(puzzle.Progress) bool) (progressResult, error) {
+			solutions, e := puzzle.SolveWatched(p, watch)
+			return progressResult{Solutions: solutions}, e
+		}
+	case "generate":
+		params, ok := generateParams(w, r.URL.Query(), session.preferences().SideLength)
+		if !ok || !takeQuota(w, quotaKey(r, session), quotaGenerate) {
+			return
+		}
+		search = func(watch func(puzzle.Progress) bool) (progressResult, error) {
+			g, e := generatePuzzle(r.Context(), params, watch)
+			return progressResult{Puzzle: &g}, e
+		}
+	default:
+		sendError(w, http.StatusNotFound, requestError("No progress stream for "+path))
+		return
+	}
+	stream, ok := startEventStream(w)
+	if !ok {
+		return
+	}
+	var last puzzle.Progress
+	result, e := search(stream.watcher(r, &last))
+	if e != nil {
+		log.Printf("Stopped %s search for session %v: %v", path, session.sessionID, e)
+		stream.fail(e)
+		return
+	}
+	result.Progress = last
+	stream.send(resultEvent, result)
+}
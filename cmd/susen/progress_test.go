@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// helperEvents reads an event stream, returning its status and
+// its events' names and data.
+func helperEvents(t *testing.T, srv *httptest.Server, path string) (int, []string, []string) {
+	r, e := http.Get(srv.URL + path)
+	if e != nil {
+		t.Fatalf("Stream request error: %v", e)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return r.StatusCode, nil, nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Stream has content type %q", ct)
+	}
+	var names, data []string
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			names = append(names, line[len("event: "):])
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, line[len("data: "):])
+		}
+	}
+	if len(names) != len(data) {
+		t.Fatalf("Stream has %d events with %d data", len(names), len(data))
+	}
+	return r.StatusCode, names, data
+}
+
+func TestProgressStreams(t *testing.T) {
+	session := newSession("test-progress")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	// the stream ends with the result
+	status, names, data := helperEvents(t, srv, "/api/progress/solutions/")
+	if status != http.StatusOK || len(names) == 0 || names[len(names)-1] != resultEvent {
+		t.Fatalf("Solutions stream gave %d, events %v", status, names)
+	}
+	var result progressResult
+	if e := json.Unmarshal([]byte(data[len(data)-1]), &result); e != nil {
+		t.Fatalf("Can't decode result %s: %v", data[len(data)-1], e)
+	}
+	if !reflect.DeepEqual(result.Solutions, session.steps[0].Solutions()) || result.Progress.Solutions != 1 {
+		t.Errorf("Solutions result is %+v", result)
+	}
+	if _, limit := hintPolicy(); session.stats.Hints != limit {
+		t.Errorf("Streamed solutions used %d hints", session.stats.Hints)
+	}
+
+	status, names, data = helperEvents(t, srv, "/api/progress/generate/?seed=golden")
+	if status != http.StatusOK || names[len(names)-1] != resultEvent {
+		t.Fatalf("Generate stream gave %d, events %v", status, names)
+	}
+	result = progressResult{}
+	json.Unmarshal([]byte(data[len(data)-1]), &result)
+	g, _ := puzzle.Generate(puzzle.GenerateParams{Seed: "golden"})
+	if result.Puzzle == nil || !reflect.DeepEqual(*result.Puzzle, g) || result.Progress.Tried != 81 {
+		t.Errorf("Generate result is %+v", result)
+	}
+	for _, name := range names[:len(names)-1] {
+		if name != progressEvent {
+			t.Errorf("Generate stream has event %q before its result", name)
+		}
+	}
+
+	// errors come before the stream starts
+	if status, _, _ := helperEvents(t, srv, "/api/progress/generate/?sidelen=many"); status != http.StatusBadRequest {
+		t.Errorf("Bad generate stream gave status %d", status)
+	}
+	if status, _, _ := helperEvents(t, srv, "/api/progress/rating/"); status != http.StatusNotFound {
+		t.Errorf("Unknown stream gave status %d", status)
+	}
+	http.Get(srv.URL + "/reset/" + defaultPuzzleID + "?mode=contest")
+	if status, _, _ := helperEvents(t, srv, "/api/progress/solutions/"); status != http.StatusForbidden {
+		t.Errorf("Contest solutions stream gave status %d", status)
+	}
+	r, e := http.Post(srv.URL+"/api/progress/solutions/", "application/json", nil)
+	if e != nil {
+		t.Fatalf("Post request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Post to stream gave status %d", r.StatusCode)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/*
+
+Protocol buffers
+
+This is the small part of the protocol buffer wire format that
+the gRPC service needs for the messages in susen.proto: varint,
+64-bit, and length-delimited fields, with repeated numbers
+packed.  As in proto3, fields with zero values aren't written.
+Readers skip fields they don't know, and accept repeated numbers
+whether they're packed or not.
+
+*/
+
+// Protocol buffer wire types.
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// A pbMessage is an encoded message, built by appending fields.
+type pbMessage []byte
+
+func (m *pbMessage) tag(field, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|uint64(wire))
+}
+
+// int appends a number field.
+func (m *pbMessage) int(field, v int) {
+	if v != 0 {
+		m.tag(field, pbVarint)
+		*m = binary.AppendUvarint(*m, uint64(v))
+	}
+}
+
+// bool appends a boolean field.
+func (m *pbMessage) bool(field int, v bool) {
+	if v {
+		m.int(field, 1)
+	}
+}
+
+// double appends a floating-point field.
+func (m *pbMessage) double(field int, v float64) {
+	if v != 0 {
+		m.tag(field, pbFixed64)
+		*m = binary.LittleEndian.AppendUint64(*m, math.Float64bits(v))
+	}
+}
+
+// string appends a string field.
+func (m *pbMessage) string(field int, s string) {
+	if s != "" {
+		m.bytes(field, []byte(s))
+	}
+}
+
+// message appends a message field, even if it's empty, since
+// it may be one of a repeated field's entries.
+func (m *pbMessage) message(field int, sub pbMessage) {
+	m.bytes(field, sub)
+}
+
+// ints appends a packed repeated number field.
+func (m *pbMessage) ints(field int, vs []int) {
+	if len(vs) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	m.bytes(field, packed)
+}
+
+func (m *pbMessage) bytes(field int, b []byte) {
+	m.tag(field, pbBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+// A pbField is a field read from a message.  Varint and fixed
+// fields have a value, and length-delimited ones have data.
+type pbField struct {
+	number, wire int
+	value        uint64
+	data         []byte
+}
+
+// pbFields reads the fields of a message, in order.
+func pbFields(b []byte) ([]pbField, error) {
+	var fields []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("bad field key")
+		}
+		b = b[n:]
+		f := pbField{number: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case pbVarint:
+			if f.value, n = binary.Uvarint(b); n <= 0 {
+				return nil, fmt.Errorf("bad varint in field %d", f.number)
+			}
+			b = b[n:]
+		case pbFixed64, pbFixed32:
+			size := 8
+			if f.wire == pbFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, fmt.Errorf("short fixed field %d", f.number)
+			}
+			if size == 8 {
+				f.value = binary.LittleEndian.Uint64(b)
+			} else {
+				f.value = uint64(binary.LittleEndian.Uint32(b))
+			}
+			b = b[size:]
+		case pbBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return nil, fmt.Errorf("bad length of field %d", f.number)
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unknown wire type %d of field %d", f.wire, f.number)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// int returns a number field's value.
+func (f pbField) int() int {
+	return int(int32(f.value))
+}
+
+// ints returns the numbers in a repeated number field, packed or
+// not.
+func (f pbField) ints() ([]int, error) {
+	if f.wire == pbVarint {
+		return []int{f.int()}, nil
+	}
+	var vs []int
+	for b := f.data; len(b) > 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("bad packed number in field %d", f.number)
+		}
+		vs, b = append(vs, int(int32(v))), b[n:]
+	}
+	return vs, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestProtobuf(t *testing.T) {
+	var sub pbMessage
+	sub.int(1, 7)
+	var m pbMessage
+	m.int(1, 300)
+	m.int(2, 0) // not written
+	m.bool(3, true)
+	m.string(4, "solo")
+	m.ints(5, []int{1, 2, 300})
+	m.double(6, 1.5)
+	m.message(7, sub)
+	m.message(7, nil)
+	if m[0] != 1<<3|pbVarint || m[1] != 0xac || m[2] != 0x02 {
+		t.Errorf("Encoding starts %v", m[:3])
+	}
+	fields, e := pbFields(m)
+	if e != nil || len(fields) != 7 {
+		t.Fatalf("Decoded %+v, %v", fields, e)
+	}
+	if fields[0].int() != 300 || fields[1].number != 3 || fields[1].int() != 1 || string(fields[2].data) != "solo" {
+		t.Errorf("Decoded scalars %+v", fields[:3])
+	}
+	if vs, e := fields[3].ints(); e != nil || !reflect.DeepEqual(vs, []int{1, 2, 300}) {
+		t.Errorf("Decoded packed numbers %v, %v", vs, e)
+	}
+	if math.Float64frombits(fields[4].value) != 1.5 || fields[4].wire != pbFixed64 {
+		t.Errorf("Decoded double %+v", fields[4])
+	}
+	if subs, e := pbFields(fields[5].data); e != nil || len(subs) != 1 || subs[0].int() != 7 || len(fields[6].data) != 0 {
+		t.Errorf("Decoded messages %+v, %+v (%v)", fields[5], fields[6], e)
+	}
+	if vs, _ := fields[0].ints(); !reflect.DeepEqual(vs, []int{300}) {
+		t.Errorf("Unpacked numbers decoded as %v", vs)
+	}
+
+	for _, bad := range []pbMessage{{0x80}, {1 << 3, 0x80}, {2<<3 | pbBytes, 5, 'a'}, {1<<3 | 3}, {1<<3 | pbFixed64, 1}} {
+		if _, e := pbFields(bad); e == nil {
+			t.Errorf("Bad message %v was decoded", bad)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+
+Puzzle provenance
+
+The server signs the puzzles in its catalog, so that mirrors of
+the catalog and clients that run the puzzle package themselves
+(such as the WASM client) can check that a puzzle really came
+from this server and hasn't been changed on the way.  Each
+signature is a puzzle.Provenance: the puzzle's ID, fingerprint,
+and geometry, and the server's name as issuer, signed with the
+server's Ed25519 key.
+
+- GET /api/provenance/ gives the server's public key and issuer
+name, for checking signatures offline
+
+- GET /api/provenance/<id> gives the signed provenance of a
+catalog puzzle
+
+- POST /api/provenance/verify checks a posted provenance (and,
+if they're posted too, the puzzle's values) against the
+server's key
+
+The key is kept in the store, and is generated the first time
+it's needed, unless SUSEN_PROVENANCE_KEY gives it (as the
+base64url encoding of its 32-byte seed).  Either way, it
+mustn't change, or every signature handed out so far stops
+checking.  SUSEN_PROVENANCE_ISSUER names the server in its
+signatures; it's "susen" if not given.
+
+*/
+
+// provenanceKind is the storage kind of the signing key.
+const provenanceKind = "provenance-key"
+
+var (
+	provenanceMutex  sync.Mutex
+	provenanceKey    ed25519.PrivateKey
+	provenanceIssuer = os.Getenv("SUSEN_PROVENANCE_ISSUER")
+)
+
+// provenanceKeyInfo is the response to key requests.
+type provenanceKeyInfo struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"`
+	Issuer    string `json:"issuer"`
+}
+
+// A provenanceClaim is a posted provenance to check, optionally
+// with the values of the puzzle it's claimed for.
+type provenanceClaim struct {
+	Provenance puzzle.Provenance `json:"provenance"`
+	Values     []int             `json:"values,omitempty"`
+}
+
+// A provenanceCheck is the result of checking a claim.
+type provenanceCheck struct {
+	Valid bool          `json:"valid"`
+	Error *puzzle.Error `json:"error,omitempty"`
+}
+
+// signingKey returns the server's signing key, loading or
+// making it the first time.
+func signingKey() (ed25519.PrivateKey, error) {
+	provenanceMutex.Lock()
+	defer provenanceMutex.Unlock()
+	if provenanceKey != nil {
+		return provenanceKey, nil
+	}
+	encoded := os.Getenv("SUSEN_PROVENANCE_KEY")
+	if encoded == "" {
+		if _, e := store.Get(provenanceKind, "key", &encoded); e != nil {
+			return nil, e
+		}
+	}
+	if encoded == "" {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, e := rand.Read(seed); e != nil {
+			return nil, e
+		}
+		encoded = b64.EncodeToString(seed)
+		if e := store.Put(provenanceKind, "key", encoded); e != nil {
+			return nil, e
+		}
+		log.Printf("Generated a new provenance signing key.")
+	}
+	seed, e := decodeB64(encoded)
+	if e != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("Invalid provenance key")
+	}
+	provenanceKey = ed25519.NewKeyFromSeed(seed)
+	return provenanceKey, nil
+}
+
+// issuerName is the server's name in its signatures.
+func issuerName() string {
+	if provenanceIssuer == "" {
+		return "susen"
+	}
+	return provenanceIssuer
+}
+
+// provenanceHandler handles the provenance endpoints.
+func provenanceHandler(w http.ResponseWriter, r *http.Request) {
+	key, e := signingKey()
+	if e != nil {
+		log.Printf("Couldn't load the provenance key: %v", e)
+		sendError(w, http.StatusServiceUnavailable, requestError("Puzzle signing is unavailable"))
+		return
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/provenance/"), "/")
+	switch {
+	case r.Method == "POST" && id == "verify":
+		var claim provenanceClaim
+		if e := decodeRequest(w, r, &claim); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid provenance: "+e.Error()))
+			return
+		}
+		if e := claim.Provenance.Verify(pub, claim.Values); e != nil {
+			err := e.(puzzle.Error)
+			sendJSON(w, http.StatusOK, provenanceCheck{Error: &err})
+			return
+		}
+		sendJSON(w, http.StatusOK, provenanceCheck{Valid: true})
+	case r.Method != "GET":
+		sendError(w, http.StatusMethodNotAllowed, requestError("Provenance can only be fetched or verified"))
+	case id == "":
+		sendJSON(w, http.StatusOK, provenanceKeyInfo{"Ed25519", b64.EncodeToString(pub), issuerName()})
+	default:
+		vals, ok := lookupPuzzle(id)
+		if !ok {
+			sendError(w, http.StatusNotFound, requestError("No catalog puzzle "+id))
+			return
+		}
+		sendJSON(w, http.StatusOK, puzzle.SignProvenance(key, issuerName(), id, vals))
+	}
+}
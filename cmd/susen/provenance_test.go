@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	provenanceMutex.Lock()
+	provenanceKey = nil
+	provenanceMutex.Unlock()
+	defer func() { store = saved }()
+	session := newSession("test-provenance")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var info provenanceKeyInfo
+	if status := helperGetJSON(t, srv, "/api/provenance/", &info); status != http.StatusOK ||
+		info.Algorithm != "Ed25519" || info.Issuer != issuerName() {
+		t.Fatalf("Key info was %d, %+v", status, info)
+	}
+	pub, e := decodeB64(info.PublicKey)
+	if e != nil {
+		t.Fatalf("Public key didn't decode: %v", e)
+	}
+	var pv puzzle.Provenance
+	if status := helperGetJSON(t, srv, "/api/provenance/"+defaultPuzzleID, &pv); status != http.StatusOK {
+		t.Fatalf("Provenance request gave status %d", status)
+	}
+	vals, _ := lookupPuzzle(defaultPuzzleID)
+	if e := pv.Verify(ed25519.PublicKey(pub), vals); e != nil || pv.PuzzleID != defaultPuzzleID {
+		t.Errorf("Provenance %+v didn't verify offline: %v", pv, e)
+	}
+	if status := helperGetJSON(t, srv, "/api/provenance/no-such-puzzle", nil); status != http.StatusNotFound {
+		t.Errorf("Provenance of unknown puzzle gave status %d", status)
+	}
+
+	// the key persists, and the server checks claims
+	provenanceMutex.Lock()
+	provenanceKey = nil
+	provenanceMutex.Unlock()
+	if helperGetJSON(t, srv, "/api/provenance/", &info); b64.EncodeToString(pub) != info.PublicKey {
+		t.Errorf("Provenance key changed on reload")
+	}
+	changed := append([]int(nil), vals...)
+	for i := 1; i < len(changed); i++ {
+		if changed[i] == 0 {
+			changed[i] = 1
+			break
+		}
+	}
+	verify := func(claim provenanceClaim) provenanceCheck {
+		bs, _ := json.Marshal(claim)
+		r, e := http.Post(srv.URL+"/api/provenance/verify", "application/json", bytes.NewReader(bs))
+		if e != nil {
+			t.Fatalf("Verify request error: %v", e)
+		}
+		defer r.Body.Close()
+		var check provenanceCheck
+		json.NewDecoder(r.Body).Decode(&check)
+		return check
+	}
+	if check := verify(provenanceClaim{pv, vals}); !check.Valid {
+		t.Errorf("Verify of the catalog puzzle gave %+v", check)
+	}
+	if check := verify(provenanceClaim{pv, changed}); check.Valid || check.Error == nil {
+		t.Errorf("Verify of a changed puzzle gave %+v", check)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/*
+
+Trusted proxies
+
+Behind a reverse proxy, every request comes from the proxy's
+address, and the proxy says where the request really came from
+in its headers: the standard Forwarded header, or the older
+X-Forwarded-For and X-Forwarded-Proto.  Anyone can send those
+headers, though, so the server only believes them when they come
+from a proxy it trusts, which are given by the trustedProxies
+server setting (see server.go): a comma-separated list of
+addresses and CIDR blocks, for example
+
+	trustedProxies: 10.0.0.0/8, 192.168.1.7
+
+By default, only proxies on the server's own host (loopback
+addresses) are trusted.  "any" trusts whatever connects to the
+server, but only that one hop, which is the only choice on hosts
+like Heroku, whose routers have no fixed addresses; it's the
+default there, and when SUSEN_TRUST_FORWARDED is set.  "none"
+trusts no proxies.
+
+A request from a trusted proxy:
+
+- comes from the client address the proxy forwarded it for,
+which is used for rate limiting (see ratelimit.go): the
+forwarded addresses are followed back from the proxy until one
+isn't a trusted proxy (addresses earlier than that were given by
+the client, so they can't be believed)
+
+- was made with the scheme the proxy says it was (http or
+https), which decides how session cookies are kept apart and
+whether cookies are secure (see cookies.go), and the origin of
+links back to the server
+
+The Forwarded header is used if there is one, and otherwise the
+X-Forwarded headers.
+
+*/
+
+// A proxyTrust says which proxies are trusted.
+type proxyTrust struct {
+	anyPeer bool         // whatever connects is trusted (for one hop)
+	nets    []*net.IPNet // the trusted proxies' addresses
+}
+
+// trustedProxies are the proxies the server trusts.
+var trustedProxies = defaultProxyTrust(os.Getenv)
+
+// defaultProxyTrust returns the proxies trusted by default in an
+// environment (looked up with getenv).
+func defaultProxyTrust(getenv func(string) string) proxyTrust {
+	if getenv("SUSEN_TRUST_FORWARDED") != "" || getenv("DYNO") != "" {
+		return proxyTrust{anyPeer: true}
+	}
+	t, _ := parseProxyTrust("127.0.0.0/8, ::1")
+	return t
+}
+
+// parseProxyTrust parses the trustedProxies setting.
+func parseProxyTrust(v string) (proxyTrust, error) {
+	var t proxyTrust
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "" || strings.EqualFold(entry, "none"):
+			continue
+		case strings.EqualFold(entry, "any"):
+			t.anyPeer = true
+			continue
+		case !strings.Contains(entry, "/"):
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				t.nets = append(t.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		default:
+			if _, n, e := net.ParseCIDR(entry); e == nil {
+				t.nets = append(t.nets, n)
+				continue
+			}
+		}
+		return proxyTrust{}, fmt.Errorf("invalid proxy address %q", entry)
+	}
+	return t, nil
+}
+
+// trusts tells whether an address is a trusted proxy's.  Whether
+// any peer is trusted isn't considered.
+func (t proxyTrust) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the address of whatever sent a request.
+func peerIP(r *http.Request) string {
+	host, _, e := net.SplitHostPort(r.RemoteAddr)
+	if e != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fromTrustedProxy tells whether a request was sent by a trusted
+// proxy.
+func fromTrustedProxy(r *http.Request) bool {
+	return trustedProxies.anyPeer || trustedProxies.trusts(peerIP(r))
+}
+
+// forwarded returns the client addresses a request was forwarded
+// for, nearest the client first, and the scheme the nearest proxy
+// says it was made with, from its Forwarded header or (if it has
+// none) its X-Forwarded headers.
+func forwarded(r *http.Request) ([]string, string) {
+	var fors []string
+	var proto string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				eq := strings.Index(pair, "=")
+				if eq < 0 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(pair[eq+1:]), `"`)
+				switch strings.ToLower(strings.TrimSpace(pair[:eq])) {
+				case "for":
+					fors = append(fors, forwardedAddress(value))
+				case "proto":
+					proto = value
+				}
+			}
+		}
+		return fors, strings.ToLower(proto)
+	}
+	for _, addr := range strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			fors = append(fors, forwardedAddress(addr))
+		}
+	}
+	protos := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")
+	return fors, strings.ToLower(strings.TrimSpace(protos[len(protos)-1]))
+}
+
+// forwardedAddress returns the address in a forwarded node, which
+// may have a port, and brackets around an IPv6 address.
+func forwardedAddress(node string) string {
+	if host, _, e := net.SplitHostPort(node); e == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// clientIP returns the address of the client making a request.
+func clientIP(r *http.Request) string {
+	addr := peerIP(r)
+	if !fromTrustedProxy(r) {
+		return addr
+	}
+	fors, _ := forwarded(r)
+	for i := len(fors) - 1; i >= 0; i-- {
+		if net.ParseIP(fors[i]) == nil {
+			break // "unknown" or obfuscated
+		}
+		addr = fors[i]
+		if !trustedProxies.trusts(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// requestScheme returns the scheme a request was made with.
+func requestScheme(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if _, proto := forwarded(r); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
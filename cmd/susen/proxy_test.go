@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(saved proxyTrust) { trustedProxies = saved }(trustedProxies)
+	r := httptest.NewRequest("GET", "/api/squares/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+	for setting, want := range map[string]string{
+		"none":                     "10.0.0.1",
+		"127.0.0.1":                "10.0.0.1",
+		"any":                      "5.6.7.8",
+		"10.0.0.0/8":               "5.6.7.8",
+		"10.0.0.0/8, 5.6.7.0/24":   "1.2.3.4",
+		"any, 5.6.7.8, 1.2.3.4":    "1.2.3.4",
+		"10.0.0.1, 192.168.0.0/16": "5.6.7.8",
+	} {
+		var e error
+		if trustedProxies, e = parseProxyTrust(setting); e != nil {
+			t.Fatalf("Can't parse %q: %v", setting, e)
+		}
+		if ip := clientIP(r); ip != want {
+			t.Errorf("Client IP trusting %q is %q, not %q", setting, ip, want)
+		}
+	}
+
+	// Forwarded takes precedence, and can have ports and IPv6
+	trustedProxies, _ = parseProxyTrust("10.0.0.0/8")
+	r.Header.Set("Forwarded", `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`)
+	if ip := clientIP(r); ip != "2001:db8:cafe::17" {
+		t.Errorf("Forwarded client IP is %q", ip)
+	}
+	r.Header.Set("Forwarded", "for=unknown")
+	if ip := clientIP(r); ip != "10.0.0.1" {
+		t.Errorf("Unknown forwarded client IP is %q", ip)
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "localhost", "10.0.0.1/"} {
+		if _, e := parseProxyTrust(bad); e == nil {
+			t.Errorf("Proxy setting %q was accepted", bad)
+		}
+	}
+	if trust := defaultProxyTrust(func(name string) string {
+		if name == "DYNO" {
+			return "web.1"
+		}
+		return ""
+	}); !trust.anyPeer {
+		t.Errorf("Heroku's proxies aren't trusted")
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	defer func(saved proxyTrust) { trustedProxies = saved }(trustedProxies)
+	trustedProxies, _ = parseProxyTrust("10.0.0.0/8")
+	for _, tc := range []struct {
+		peer, header, value string
+		tls                 bool
+		scheme, protocol    string
+	}{
+		{"10.0.0.1:5000", "", "", false, "http", "httpx"},
+		{"10.0.0.1:5000", "", "", true, "https", "httpx"},
+		{"10.0.0.1:5000", "X-Forwarded-Proto", "https", false, "https", "https"},
+		{"10.0.0.1:5000", "X-Forwarded-Proto", "http", true, "http", "http"},
+		{"10.0.0.1:5000", "Forwarded", "for=1.2.3.4;proto=https", false, "https", "https"},
+		{"1.2.3.4:5000", "X-Forwarded-Proto", "https", false, "http", "httpx"},
+		{"1.2.3.4:5000", "Forwarded", "proto=http", true, "https", "httpx"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.peer
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		if tc.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if scheme, protocol := requestScheme(r), requestProtocol(r); scheme != tc.scheme || protocol != tc.protocol {
+			t.Errorf("Request %+v has scheme %q and protocol %q", tc, scheme, protocol)
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Push notifications
+
+Identified users can have notifications pushed to their browsers
+(with Web Push, RFC 8030), even when no Susen page is open: one
+when each day's puzzle is out (at midnight in their zone; see
+daily.go), and one when someone else moves on the board of a
+room they're in while they aren't watching it.  (Rooms don't
+take turns, so that's the co-op "your turn": the board has
+changed since you looked.)  Room notifications are sent at most
+once every pushRoomCooldown for each user and room.
+
+A browser subscribes with its push service, using the server's
+public key from GET /api/push/ (see pushHandler), and posts the
+subscription to /api/push/subscriptions.  Users choose which
+notifications they want with /api/push/prefs; both kinds are on
+until they say otherwise.  Subscriptions and preferences are
+kept in the store, as is the server's VAPID key pair (RFC 8292),
+which push services use to check that notifications come from
+the server the browser subscribed to.  The key is generated the
+first time it's needed, unless SUSEN_VAPID_KEY gives it (as the
+base64url encoding of the private key's 32 bytes); either way,
+it mustn't change, or every subscription stops working.
+SUSEN_VAPID_SUBJECT should give a contact URL (such as
+"mailto:admin@example.com") for the push services.
+
+Notifications are JSON, encrypted for the subscribing browser
+(RFC 8291):
+
+	{"type": "daily", "title": "...", "body": "...", "url": "/reset/daily"}
+
+and the page's service worker shows them.  Subscriptions the
+push service says are gone are dropped.
+
+*/
+
+// Storage kinds for push subscriptions (by user key) and the
+// VAPID key.
+const (
+	pushKind  = "push-subscriptions"
+	vapidKind = "push-vapid"
+)
+
+// Push timing: how often users are checked for the day's puzzle
+// notification, how long notifications wait at the push service
+// for an offline browser, and how often a user hears about one
+// room.
+const (
+	pushDailyInterval = 15 * time.Minute
+	pushTTL           = 12 * time.Hour
+	pushRoomCooldown  = 10 * time.Minute
+)
+
+// pushKeys are a subscription's encryption keys, base64url
+// encoded.
+type pushKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// A pushSubscription is a browser's subscription with its push
+// service, as given by PushSubscription.toJSON() in the browser.
+type pushSubscription struct {
+	Endpoint string   `json:"endpoint"`
+	Keys     pushKeys `json:"keys"`
+}
+
+// pushPrefs are the notifications a user wants.
+type pushPrefs struct {
+	Daily bool `json:"daily"`
+	Room  bool `json:"room"`
+}
+
+// A pushRecord is a user's push subscriptions and preferences.
+type pushRecord struct {
+	Subscriptions []pushSubscription `json:"subscriptions"`
+	Prefs         pushPrefs          `json:"prefs"`
+	LastDaily     string             `json:"lastDaily,omitempty"` // the last daily puzzle notified
+}
+
+// A pushMessage is the payload of a notification.
+type pushMessage struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+}
+
+// pushInfo is the response to push requests.
+type pushInfo struct {
+	PublicKey     string    `json:"publicKey"`
+	Subscriptions int       `json:"subscriptions"`
+	Prefs         pushPrefs `json:"prefs"`
+}
+
+var (
+	pushMutex     sync.Mutex // serializes the updates of push records
+	vapidMutex    sync.Mutex
+	vapidKey      *ecdsa.PrivateKey
+	vapidSubject  = os.Getenv("SUSEN_VAPID_SUBJECT")
+	pushClient    = &http.Client{Timeout: 10 * time.Second}
+	roomPushes    = make(map[string]time.Time) // last room notification, by user and room
+	roomPushMutex sync.Mutex
+)
+
+// b64 is the base64 encoding Web Push uses.
+var b64 = base64.RawURLEncoding
+
+// decodeB64 decodes base64url, with or without padding.
+func decodeB64(s string) ([]byte, error) {
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
+
+// vapidPrivateKey returns the server's VAPID key, loading or
+// making it the first time.
+func vapidPrivateKey() (*ecdsa.PrivateKey, error) {
+	vapidMutex.Lock()
+	defer vapidMutex.Unlock()
+	if vapidKey != nil {
+		return vapidKey, nil
+	}
+	encoded := os.Getenv("SUSEN_VAPID_KEY")
+	if encoded == "" {
+		if _, e := store.Get(vapidKind, "key", &encoded); e != nil {
+			return nil, e
+		}
+	}
+	if encoded == "" {
+		k, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if e != nil {
+			return nil, e
+		}
+		encoded = b64.EncodeToString(k.D.FillBytes(make([]byte, 32)))
+		if e := store.Put(vapidKind, "key", encoded); e != nil {
+			return nil, e
+		}
+		log.Printf("Generated a new VAPID key for push notifications.")
+	}
+	d, e := decodeB64(encoded)
+	if e != nil || len(d) != 32 {
+		return nil, fmt.Errorf("Invalid VAPID key")
+	}
+	k := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	k.PublicKey.Curve = elliptic.P256()
+	k.PublicKey.X, k.PublicKey.Y = k.PublicKey.Curve.ScalarBaseMult(d)
+	vapidKey = k
+	return k, nil
+}
+
+// vapidPublicKey returns the server's public key, as browsers
+// need it to subscribe.
+func vapidPublicKey(k *ecdsa.PrivateKey) ([]byte, error) {
+	pub, e := k.PublicKey.ECDH()
+	if e != nil {
+		return nil, e
+	}
+	return pub.Bytes(), nil
+}
+
+// vapidAuthorization returns the Authorization header for a push
+// to an endpoint: a signed JWT, and the public key to check it.
+func vapidAuthorization(endpoint string) (string, error) {
+	k, e := vapidPrivateKey()
+	if e != nil {
+		return "", e
+	}
+	u, e := url.Parse(endpoint)
+	if e != nil {
+		return "", e
+	}
+	subject := vapidSubject
+	if subject == "" {
+		subject = "mailto:webmaster@localhost"
+	}
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(pushTTL).Unix(),
+		"sub": subject,
+	})
+	unsigned := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + b64.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, e := ecdsa.Sign(rand.Reader, k, digest[:])
+	if e != nil {
+		return "", e
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	pub, e := vapidPublicKey(k)
+	if e != nil {
+		return "", e
+	}
+	return "vapid t=" + unsigned + "." + b64.EncodeToString(sig) + ", k=" + b64.EncodeToString(pub), nil
+}
+
+// hkdf is the HKDF-SHA256 of RFC 5869, for outputs of up to 32
+// bytes.
+func hkdf(salt, secret, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// encryptPush encrypts a payload for a subscription, returning
+// the aes128gcm content coding of RFC 8188 and 8291.
+func encryptPush(sub pushSubscription, payload []byte) ([]byte, error) {
+	uaBytes, e := decodeB64(sub.Keys.P256dh)
+	if e != nil {
+		return nil, fmt.Errorf("Invalid subscription key: %v", e)
+	}
+	authSecret, e := decodeB64(sub.Keys.Auth)
+	if e != nil || len(authSecret) == 0 {
+		return nil, fmt.Errorf("Invalid subscription secret")
+	}
+	uaPublic, e := ecdh.P256().NewPublicKey(uaBytes)
+	if e != nil {
+		return nil, fmt.Errorf("Invalid subscription key: %v", e)
+	}
+	asPrivate, e := ecdh.P256().GenerateKey(rand.Reader)
+	if e != nil {
+		return nil, e
+	}
+	shared, e := asPrivate.ECDH(uaPublic)
+	if e != nil {
+		return nil, e
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaBytes...), asPublic...)
+	ikm := hkdf(authSecret, shared, keyInfo, 32)
+	salt := make([]byte, 16)
+	if _, e := rand.Read(salt); e != nil {
+		return nil, e
+	}
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	block, e := aes.NewCipher(cek)
+	if e != nil {
+		return nil, e
+	}
+	gcm, e := cipher.NewGCM(block)
+	if e != nil {
+		return nil, e
+	}
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(4096))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(gcm.Seal(nil, nonce, append(payload, 2), nil))
+	return body.Bytes(), nil
+}
+
+// sendPush sends a notification to a subscription.  It returns
+// false if the subscription is gone, and should be dropped.
+func sendPush(sub pushSubscription, msg pushMessage) bool {
+	payload, _ := json.Marshal(msg)
+	body, e := encryptPush(sub, payload)
+	if e != nil {
+		log.Printf("Can't encrypt push to %s: %v", sub.Endpoint, e)
+		return false
+	}
+	authorization, e := vapidAuthorization(sub.Endpoint)
+	if e != nil {
+		log.Printf("Can't sign push to %s: %v", sub.Endpoint, e)
+		return true
+	}
+	req, e := http.NewRequest("POST", sub.Endpoint, bytes.NewReader(body))
+	if e != nil {
+		log.Printf("Can't push to %s: %v", sub.Endpoint, e)
+		return false
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(pushTTL/time.Second)))
+	req.Header.Set("Urgency", "normal")
+	r, e := pushClient.Do(req)
+	if e != nil {
+		log.Printf("Push to %s failed: %v", sub.Endpoint, e)
+		return true
+	}
+	io.Copy(io.Discard, r.Body)
+	r.Body.Close()
+	switch {
+	case r.StatusCode == http.StatusNotFound || r.StatusCode == http.StatusGone:
+		return false
+	case r.StatusCode >= 300:
+		log.Printf("Push to %s got status %d.", sub.Endpoint, r.StatusCode)
+	}
+	return true
+}
+
+// pushToUser sends a notification to all of a user's
+// subscriptions, if they want that kind, dropping the
+// subscriptions that are gone.
+func pushToUser(key string, msg pushMessage) {
+	pushMutex.Lock()
+	var rec pushRecord
+	found, e := store.Get(pushKind, key, &rec)
+	pushMutex.Unlock()
+	if e != nil || !found {
+		return
+	}
+	if (msg.Type == "daily" && !rec.Prefs.Daily) || (msg.Type == "room" && !rec.Prefs.Room) {
+		return
+	}
+	var gone []string
+	for _, sub := range rec.Subscriptions {
+		if !sendPush(sub, msg) {
+			gone = append(gone, sub.Endpoint)
+		}
+	}
+	if len(gone) > 0 {
+		updatePushRecord(key, func(rec *pushRecord) {
+			for _, endpoint := range gone {
+				rec.remove(endpoint)
+			}
+		})
+		log.Printf("Dropped %d push subscriptions of %v.", len(gone), key)
+	}
+}
+
+// remove removes a subscription from a record.
+func (rec *pushRecord) remove(endpoint string) {
+	for i, sub := range rec.Subscriptions {
+		if sub.Endpoint == endpoint {
+			rec.Subscriptions = append(rec.Subscriptions[:i], rec.Subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// updatePushRecord changes a user's push record.  New records
+// want every kind of notification.
+func updatePushRecord(key string, change func(rec *pushRecord)) (pushRecord, error) {
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+	rec := pushRecord{Prefs: pushPrefs{Daily: true, Room: true}}
+	if _, e := store.Get(pushKind, key, &rec); e != nil {
+		return rec, e
+	}
+	change(&rec)
+	return rec, store.Put(pushKind, key, rec)
+}
+
+// notifyDaily tells every subscribed user whose day has started
+// since they were last told that the day's puzzle is out.
+func notifyDaily() {
+	keys, e := store.Keys(pushKind)
+	if e != nil {
+		log.Printf("Can't list push subscriptions: %v", e)
+		return
+	}
+	for _, key := range keys {
+		loc := dailyZone
+		var name string
+		if _, e := store.Get(dailyZoneKind, key, &name); e == nil && name != "" {
+			if l, e := time.LoadLocation(name); e == nil {
+				loc = l
+			}
+		}
+		today := zonedDailyID(time.Now(), loc)
+		notify := false
+		updatePushRecord(key, func(rec *pushRecord) {
+			notify = rec.LastDaily != today && rec.Prefs.Daily && len(rec.Subscriptions) > 0
+			if notify {
+				rec.LastDaily = today
+			}
+		})
+		if notify {
+			pushToUser(key, pushMessage{
+				Type:  "daily",
+				Title: "Today's Susen puzzle is out",
+				Body:  "The daily puzzle for " + today[len(dailyIDPrefix):] + " is ready to play.",
+				URL:   "/reset/daily",
+			})
+		}
+	}
+}
+
+// pushDaily checks for daily notifications every
+// pushDailyInterval.
+func pushDaily() {
+	go func() {
+		for range time.Tick(pushDailyInterval) {
+			if featureEnabled("push") {
+				notifyDaily()
+			}
+		}
+	}()
+}
+
+// pushRoomMove tells the other identified members of the
+// session's room who aren't watching it that the board has
+// changed.  It must be called with the board locked.
+func (session *susenSession) pushRoomMove() {
+	if session.room == nil || !featureEnabled("push") {
+		return
+	}
+	code := session.room.code
+	_, mover := session.player()
+	now := time.Now()
+	for _, member := range session.members {
+		if member == session {
+			continue
+		}
+		member.infoMutex.Lock()
+		user := member.user
+		member.infoMutex.Unlock()
+		member.watchMutex.Lock()
+		watched := len(member.watchers) > 0
+		member.watchMutex.Unlock()
+		if user == nil || watched {
+			continue
+		}
+		key := user.Key()
+		roomPushMutex.Lock()
+		last, ok := roomPushes[key+" "+code]
+		due := !ok || now.Sub(last) >= pushRoomCooldown
+		if due {
+			roomPushes[key+" "+code] = now
+		}
+		roomPushMutex.Unlock()
+		if due {
+			go pushToUser(key, pushMessage{
+				Type:  "room",
+				Title: "Your move in room " + code,
+				Body:  mover + " has moved on your shared board.",
+				URL:   "/solver/",
+			})
+		}
+	}
+}
+
+// pushHandler handles the push endpoints, for identified users:
+//
+// - GET /api/push/ gives the server's public key (base64url), the
+// number of the user's subscriptions, and their preferences
+//
+// - POST /api/push/subscriptions adds a subscription (as given
+// by PushSubscription.toJSON()), and DELETE removes one (only
+// its endpoint is needed)
+//
+// - PUT /api/push/prefs sets the user's preferences
+//
+// All of them respond like GET /api/push/.
+func pushHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.FromRequest(r)
+	if user == nil {
+		sendError(w, http.StatusForbidden, requestError("Only identified users can have notifications"))
+		return
+	}
+	k, e := vapidPrivateKey()
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't load the push key: "+e.Error()))
+		return
+	}
+	pub, e := vapidPublicKey(k)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't load the push key: "+e.Error()))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/push"), "/")
+	var change func(rec *pushRecord)
+	switch {
+	case path == "" && r.Method == "GET":
+		rec := pushRecord{Prefs: pushPrefs{Daily: true, Room: true}}
+		pushMutex.Lock()
+		_, e := store.Get(pushKind, user.Key(), &rec)
+		pushMutex.Unlock()
+		if e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't read push subscriptions: "+e.Error()))
+			return
+		}
+		sendJSON(w, http.StatusOK, pushInfo{b64.EncodeToString(pub), len(rec.Subscriptions), rec.Prefs})
+		return
+	case path == "subscriptions" && (r.Method == "POST" || r.Method == "DELETE"):
+		var sub pushSubscription
+		if e := decodeRequest(w, r, &sub); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid subscription: "+e.Error()))
+			return
+		}
+		endpoint, e := url.Parse(sub.Endpoint)
+		if e != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+			sendError(w, http.StatusBadRequest, requestError("Invalid subscription endpoint: "+sub.Endpoint))
+			return
+		}
+		if r.Method == "DELETE" {
+			change = func(rec *pushRecord) { rec.remove(sub.Endpoint) }
+			break
+		}
+		if _, e := encryptPush(sub, nil); e != nil {
+			sendError(w, http.StatusBadRequest, requestError(e.Error()))
+			return
+		}
+		today := todayID(r)
+		change = func(rec *pushRecord) {
+			rec.remove(sub.Endpoint)
+			rec.Subscriptions = append(rec.Subscriptions, sub)
+			if rec.LastDaily == "" {
+				// today's puzzle is already out
+				rec.LastDaily = today
+			}
+		}
+	case path == "prefs" && r.Method == "PUT":
+		var prefs pushPrefs
+		if e := decodeRequest(w, r, &prefs); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid preferences: "+e.Error()))
+			return
+		}
+		change = func(rec *pushRecord) { rec.Prefs = prefs }
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown push request: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	rec, e := updatePushRecord(user.Key(), change)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't save push subscriptions: "+e.Error()))
+		return
+	}
+	sendJSON(w, http.StatusOK, pushInfo{b64.EncodeToString(pub), len(rec.Subscriptions), rec.Prefs})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A helperPushBrowser is a browser's side of a push subscription.
+type helperPushBrowser struct {
+	key    *ecdh.PrivateKey
+	secret []byte
+}
+
+func newHelperPushBrowser(t *testing.T) helperPushBrowser {
+	key, e := ecdh.P256().GenerateKey(rand.Reader)
+	if e != nil {
+		t.Fatalf("Can't make browser key: %v", e)
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return helperPushBrowser{key, secret}
+}
+
+func (b helperPushBrowser) subscription(endpoint string) pushSubscription {
+	return pushSubscription{endpoint, pushKeys{b64.EncodeToString(b.key.PublicKey().Bytes()), b64.EncodeToString(b.secret)}}
+}
+
+// decrypt decrypts a pushed message, as the browser would.
+func (b helperPushBrowser) decrypt(t *testing.T, body []byte) pushMessage {
+	salt, idlen := body[:16], int(body[20])
+	asPublic, e := ecdh.P256().NewPublicKey(body[21 : 21+idlen])
+	if e != nil {
+		t.Fatalf("Push has an invalid key: %v", e)
+	}
+	shared, _ := b.key.ECDH(asPublic)
+	info := append(append([]byte("WebPush: info\x00"), b.key.PublicKey().Bytes()...), asPublic.Bytes()...)
+	ikm := hkdf(b.secret, shared, info, 32)
+	block, _ := aes.NewCipher(hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	gcm, _ := cipher.NewGCM(block)
+	plain, e := gcm.Open(nil, hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12), body[21+idlen:], nil)
+	if e != nil || len(plain) == 0 || plain[len(plain)-1] != 2 {
+		t.Fatalf("Can't decrypt push: %v", e)
+	}
+	var msg pushMessage
+	if e := json.Unmarshal(plain[:len(plain)-1], &msg); e != nil {
+		t.Fatalf("Push isn't JSON: %q", plain)
+	}
+	return msg
+}
+
+// helperCheckVAPID checks a push's VAPID authorization.
+func helperCheckVAPID(t *testing.T, authorization string) {
+	parts := strings.Split(strings.TrimPrefix(authorization, "vapid t="), ", k=")
+	jwt := strings.Split(parts[0], ".")
+	if len(parts) != 2 || len(jwt) != 3 {
+		t.Fatalf("Push authorization is %q", authorization)
+	}
+	k, _ := vapidPrivateKey()
+	pub, _ := vapidPublicKey(k)
+	sig, _ := decodeB64(jwt[2])
+	digest := sha256.Sum256([]byte(jwt[0] + "." + jwt[1]))
+	if parts[1] != b64.EncodeToString(pub) || len(sig) != 64 ||
+		!ecdsa.Verify(&k.PublicKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Errorf("Push authorization doesn't verify: %q", authorization)
+	}
+}
+
+func TestPush(t *testing.T) {
+	savedStore, savedClient := store, pushClient
+	store = storage.NewMemory()
+	vapidMutex.Lock()
+	vapidKey = nil
+	vapidMutex.Unlock()
+	defer func() { store, pushClient = savedStore, savedClient }()
+
+	// the push service keeps what's pushed, and says the "gone"
+	// subscription is gone
+	pushed := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	service := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/gone") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		pushed <- r
+		bodies <- body
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer service.Close()
+	pushClient = service.Client()
+	browser := newHelperPushBrowser(t)
+
+	session := newSession("test-push")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	if status := helperUserRequest(t, srv, "", "GET", "/api/push/", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Anonymous push request gave %d", status)
+	}
+	var info pushInfo
+	if status := helperUserRequest(t, srv, "alice", "GET", "/api/push/", nil, &info); status != http.StatusOK ||
+		len(info.PublicKey) != 87 || info.Subscriptions != 0 || !info.Prefs.Daily || !info.Prefs.Room {
+		t.Errorf("Push info gave %d, %+v", status, info)
+	}
+	bad := browser.subscription("http://push.example.com/1")
+	if status := helperUserRequest(t, srv, "alice", "POST", "/api/push/subscriptions", bad, nil); status != http.StatusBadRequest {
+		t.Errorf("Subscription without HTTPS gave %d", status)
+	}
+	bad = pushSubscription{service.URL + "/1", pushKeys{"bogus", "bogus"}}
+	if status := helperUserRequest(t, srv, "alice", "POST", "/api/push/subscriptions", bad, nil); status != http.StatusBadRequest {
+		t.Errorf("Subscription with bad keys gave %d", status)
+	}
+	for _, path := range []string{"/1", "/gone"} {
+		helperUserRequest(t, srv, "alice", "POST", "/api/push/subscriptions", browser.subscription(service.URL+path), &info)
+	}
+	if info.Subscriptions != 2 {
+		t.Errorf("Push info after subscribing is %+v", info)
+	}
+
+	// subscribers hear about the next day's puzzle, but not today's
+	notifyDaily()
+	select {
+	case <-pushed:
+		t.Fatalf("Subscriber was told about today's puzzle")
+	default:
+	}
+	updatePushRecord("header:alice", func(rec *pushRecord) { rec.LastDaily = "" })
+	notifyDaily()
+	r, body := <-pushed, <-bodies
+	if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+		t.Errorf("Push has headers %v", r.Header)
+	}
+	helperCheckVAPID(t, r.Header.Get("Authorization"))
+	if msg := browser.decrypt(t, body); msg.Type != "daily" || msg.URL != "/reset/daily" ||
+		!strings.Contains(msg.Body, dailyID(time.Now())[len(dailyIDPrefix):]) {
+		t.Errorf("Daily push is %+v", msg)
+	}
+	if helperUserRequest(t, srv, "alice", "GET", "/api/push/", nil, &info); info.Subscriptions != 1 {
+		t.Errorf("Gone subscription wasn't dropped: %+v", info)
+	}
+	notifyDaily()
+	select {
+	case <-pushed:
+		t.Errorf("Subscriber was told about the daily puzzle twice")
+	default:
+	}
+
+	// room members who aren't watching hear about moves, unless
+	// they don't want to
+	mover, other := newSession("test-push-mover"), newSession("test-push-other")
+	mover.setUser(&auth.User{ID: "bob", Name: "Bob", Source: "header"})
+	other.setUser(&auth.User{ID: "alice", Name: "Alice", Source: "header"})
+	room := mover.createRoom(false, 0)
+	other.joinRoom(room.code)
+	defer other.leaveRoom()
+	defer mover.leaveRoom()
+	mover.mutex.Lock()
+	mover.pushRoomMove()
+	mover.pushRoomMove()
+	mover.mutex.Unlock()
+	select {
+	case <-pushed:
+		if msg := browser.decrypt(t, <-bodies); msg.Type != "room" || !strings.Contains(msg.Body, "Bob") {
+			t.Errorf("Room push is %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Room member wasn't told about a move")
+	}
+	helperUserRequest(t, srv, "alice", "PUT", "/api/push/prefs", pushPrefs{Daily: true}, &info)
+	if info.Prefs.Room {
+		t.Errorf("Preferences after turning off room pushes are %+v", info.Prefs)
+	}
+	pushToUser("header:alice", pushMessage{Type: "room"})
+	time.Sleep(50 * time.Millisecond)
+	if len(pushed) != 0 {
+		t.Errorf("Room push was sent again, or after it was turned off")
+	}
+	helperUserRequest(t, srv, "alice", "DELETE", "/api/push/subscriptions", pushSubscription{Endpoint: service.URL + "/1"}, &info)
+	if info.Subscriptions != 0 {
+		t.Errorf("Push info after unsubscribing is %+v", info)
+	}
+}
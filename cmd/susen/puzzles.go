@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"strings"
+)
+
+/*
+
+Puzzle metadata
+
+Every puzzle a board can be started on by ID has a title, an
+author, a source, tags, and a star rating (see puzzle.Rate):
+
+- GET /api/puzzles lists the catalog puzzles' metadata, in ID
+order, a page at a time (see paging.go).  Query parameters
+filter the list: author=<author> and source=<source> match
+exactly, tag=<tag> matches puzzles with the tag (and can be
+repeated, to match puzzles with all of them), title=<text>
+matches titles containing the text in any case, stars=<n>
+matches ratings, and geometry=<side length> matches side lengths.
+
+- GET /api/puzzles/<id> gives the metadata of one puzzle: a
+catalog puzzle, a generated puzzle (see random.go), or a daily
+puzzle (see daily.go).
+
+Setters give a puzzle's metadata when they add it to the catalog
+(see admin.go): the body can be an object with the puzzle's
+values along with its title, author, source, and tags, rather
+than just the values.  It can also be a Sudoku puzzle's grid as
+pasted from a spreadsheet or a newspaper (see puzzle.ParseText),
+in which case errors in the grid give their line and column.  Puzzles without a title are titled by
+their ID, and added puzzles without an author are credited to
+the setter who added them.  Puzzles added one at a time have
+source "upload" unless they say otherwise, and collections (see
+collections.go) have their prefix as their source, with any tags
+given in tag parameters.  The built-in puzzles have source
+"built-in", generated puzzles "generated", and daily puzzles
+"daily", and those also have their source as a tag.  Ratings are
+always worked out, never given, and are kept for catalog
+puzzles.
+
+*/
+
+// A puzzleMeta is the metadata of a puzzle.
+type puzzleMeta struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Author     string   `json:"author,omitempty"`
+	Source     string   `json:"source"`
+	Tags       []string `json:"tags"`
+	Stars      int      `json:"stars"` // 0 if it can't be rated
+	SideLength int      `json:"sideLength"`
+}
+
+// The sources of puzzles that don't name their own.
+const (
+	builtinSource   = "built-in"
+	uploadSource    = "upload"
+	generatedSource = "generated"
+	dailySource     = "daily"
+)
+
+// catalogMeta has the metadata given for catalog puzzles, by
+// ID.  The built-in puzzles have none.  It's guarded by
+// catalogMutex.
+var catalogMeta = make(map[string]puzzleMeta)
+
+// A catalogUpload is a puzzle being added to the catalog, with
+// its metadata.
+type catalogUpload struct {
+	Values []int    `json:"values"`
+	Title  string   `json:"title"`
+	Author string   `json:"author"`
+	Source string   `json:"source"`
+	Tags   []string `json:"tags"`
+}
+
+// decodeUpload decodes the body of a request to add a puzzle:
+// either its values, or a catalogUpload, or its Sudoku grid as
+// text (see puzzle.ParseText).  Values are decoded by
+// puzzle.DecodeGeoAndValues, so they're checked the same way as
+// everywhere else.
+func decodeUpload(body []byte) (catalogUpload, error) {
+	var upload catalogUpload
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		var raw struct {
+			catalogUpload
+			Values json.RawMessage `json:"values"`
+		}
+		if e := json.Unmarshal(trimmed, &raw); e != nil {
+			return upload, e
+		}
+		upload = raw.catalogUpload
+		values, e := puzzle.DecodeGeoAndValues(bytes.NewReader(raw.Values))
+		upload.Values = values
+		return upload, e
+	case len(trimmed) > 0 && trimmed[0] != '[':
+		puzzles, e := puzzle.ParseText(string(body), puzzle.GridFormat)
+		if e == nil {
+			upload.Values = puzzles[0]
+		}
+		return upload, e
+	}
+	values, e := puzzle.DecodeGeoAndValues(bytes.NewReader(body))
+	upload.Values = values
+	return upload, e
+}
+
+// meta returns the metadata the upload gives a puzzle with the
+// given ID, added by the given user.
+func (upload catalogUpload) meta(id, user string) puzzleMeta {
+	meta := puzzleMeta{ID: id, Title: upload.Title, Author: upload.Author, Source: upload.Source, Tags: upload.Tags}
+	if meta.Author == "" {
+		meta.Author = user
+	}
+	if meta.Source == "" {
+		meta.Source = uploadSource
+	}
+	return meta
+}
+
+// completeMeta fills in a puzzle's metadata from its values:
+// its rating and side length, and the defaults of what wasn't
+// given.  Ratings of catalog puzzles are kept.
+func completeMeta(meta puzzleMeta, vals []int, catalog bool) puzzleMeta {
+	if meta.Title == "" {
+		meta.Title = meta.ID
+	}
+	if meta.Tags == nil {
+		meta.Tags = []string{}
+	}
+	meta.Stars = starsOf(vals, catalog)
+	if p, e := puzzle.New(vals); e == nil {
+		meta.SideLength = p.State().SideLenth
+	}
+	return meta
+}
+
+// catalogPuzzleMeta returns the metadata of a catalog puzzle.
+func catalogPuzzleMeta(id string) (puzzleMeta, bool) {
+	catalogMutex.RLock()
+	vals, ok := puzzleValues[id]
+	meta, given := catalogMeta[id]
+	catalogMutex.RUnlock()
+	if !ok {
+		return puzzleMeta{}, false
+	}
+	if !given {
+		meta = puzzleMeta{ID: id, Source: builtinSource, Tags: []string{builtinSource}}
+	}
+	return completeMeta(meta, vals, true), true
+}
+
+// lookupPuzzleMeta returns the metadata of the puzzle with the
+// given ID, or false if there's no such puzzle.
+func lookupPuzzleMeta(id string) (puzzleMeta, bool) {
+	if meta, ok := catalogPuzzleMeta(id); ok {
+		return meta, true
+	}
+	if vals, ok := seedValues(id); ok {
+		params, _ := parseSeedID(id)
+		meta := puzzleMeta{ID: id, Title: "Generated puzzle " + params.Seed, Source: generatedSource,
+			Tags: []string{generatedSource}}
+		return completeMeta(meta, vals, false), true
+	}
+	if date, ok := parseDailyID(id); ok {
+		meta := puzzleMeta{ID: id, Title: "Daily puzzle for " + date.Format(dailyDateForm), Source: dailySource,
+			Tags: []string{dailySource, generatedSource}}
+		return completeMeta(meta, dailyValues(date), false), true
+	}
+	return puzzleMeta{}, false
+}
+
+// matches tells whether the metadata matches a request's
+// filters.
+func (meta puzzleMeta) matches(q map[string][]string, stars, sidelen int) bool {
+	first := func(name string) string {
+		if vs := q[name]; len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	if author := first("author"); author != "" && meta.Author != author {
+		return false
+	}
+	if source := first("source"); source != "" && meta.Source != source {
+		return false
+	}
+	if title := first("title"); title != "" && !strings.Contains(strings.ToLower(meta.Title), strings.ToLower(title)) {
+		return false
+	}
+	if (stars != 0 && meta.Stars != stars) || (sidelen != 0 && meta.SideLength != sidelen) {
+		return false
+	}
+	for _, tag := range q["tag"] {
+		found := false
+		for _, t := range meta.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// puzzlesHandler handles the puzzle metadata endpoints.
+func puzzlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusNotFound, requestError("Unknown puzzles operation: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/puzzles"), "/"); id != "" {
+		meta, ok := lookupPuzzleMeta(id)
+		if !ok {
+			sendError(w, http.StatusNotFound, requestError("No puzzle "+id))
+			return
+		}
+		sendJSON(w, http.StatusOK, meta)
+		return
+	}
+	q := r.URL.Query()
+	filters := map[string]int{"stars": 0, "geometry": 0}
+	for name := range filters {
+		if s := q.Get(name); s != "" {
+			n, e := puzzle.ParseInt(name, s)
+			if e == nil && n < 1 {
+				e = fieldError(name, "Invalid "+name+" parameter: "+s)
+			}
+			if e != nil {
+				sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+				return
+			}
+			filters[name] = n
+		}
+	}
+	list := []puzzleMeta{}
+	for _, id := range catalogIDs() {
+		if meta, ok := catalogPuzzleMeta(id); ok && meta.matches(q, filters["stars"], filters["geometry"]) {
+			list = append(list, meta)
+		}
+	}
+	lo, hi, ok := pageOf(w, r, len(list), func(i int) string { return list[i].ID })
+	if ok {
+		sendJSON(w, http.StatusOK, list[lo:hi])
+	}
+}
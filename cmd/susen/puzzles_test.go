@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPuzzleMeta(t *testing.T) {
+	srv := helperUserServer(newSession("test-puzzle-meta"))
+	defer srv.Close()
+	roles.Grant("header:setter", auth.RoleSetter)
+	defer roles.Revoke("header:setter", auth.RoleSetter)
+	defer helperRemovePuzzles("test-meta", "test-plain")
+
+	var list []puzzleMeta
+	if status := helperUserRequest(t, srv, "", "GET", "/api/puzzles?limit=200", nil, &list); status != http.StatusOK ||
+		len(list) != len(catalogIDs()) {
+		t.Fatalf("Puzzle listing gave %d, %d puzzles", status, len(list))
+	}
+	for _, meta := range list {
+		if meta.ID == "1-star" && (meta.Title != "1-star" || meta.Source != builtinSource ||
+			meta.Stars != starsOf(puzzleValues["1-star"], true) || meta.SideLength != 9) {
+			t.Errorf("Built-in puzzle has metadata %+v", meta)
+		}
+	}
+
+	// uploads can give metadata, or not
+	upload := catalogUpload{Values: helperNewPuzzle("1-star"), Title: "Morning Coffee", Tags: []string{"easy", "classic"}}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/test-meta", upload, nil); status != http.StatusOK {
+		t.Fatalf("Add with metadata gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "setter", "POST", "/api/catalog/test-plain", helperNewPuzzle("2-star"), nil); status != http.StatusOK {
+		t.Fatalf("Add without metadata gave status %d", status)
+	}
+	var meta puzzleMeta
+	if status := helperUserRequest(t, srv, "", "GET", "/api/puzzles/test-meta", nil, &meta); status != http.StatusOK {
+		t.Fatalf("Puzzle metadata request gave status %d", status)
+	}
+	want := puzzleMeta{ID: "test-meta", Title: "Morning Coffee", Author: "header:setter", Source: uploadSource,
+		Tags: upload.Tags, Stars: starsOf(upload.Values, false), SideLength: 9}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("Uploaded puzzle has metadata %+v, not %+v", meta, want)
+	}
+	helperUserRequest(t, srv, "", "GET", "/api/puzzles/test-plain", nil, &meta)
+	if meta.Title != "test-plain" || meta.Author != "header:setter" || meta.Source != uploadSource {
+		t.Errorf("Plain upload has metadata %+v", meta)
+	}
+
+	// filters
+	for query, ids := range map[string][]string{
+		"tag=easy&tag=classic":                             {"test-meta"},
+		"tag=easy&tag=hard":                                nil,
+		"title=coffee&source=upload":                       {"test-meta"},
+		"author=header:setter":                             {"test-meta", "test-plain"},
+		"source=upload&geometry=4":                         nil,
+		"title=COFFEE&stars=" + strconv.Itoa(want.Stars):   {"test-meta"},
+		"title=coffee&stars=" + strconv.Itoa(want.Stars+1): nil,
+	} {
+		helperUserRequest(t, srv, "", "GET", "/api/puzzles?"+query, nil, &list)
+		var got []string
+		for _, meta := range list {
+			got = append(got, meta.ID)
+		}
+		if !reflect.DeepEqual(got, ids) {
+			t.Errorf("Puzzles with %s are %v, not %v", query, got, ids)
+		}
+	}
+	for _, query := range []string{"stars=none", "geometry=0"} {
+		if status := helperUserRequest(t, srv, "", "GET", "/api/puzzles?"+query, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("Listing with %s gave status %d", query, status)
+		}
+	}
+
+	// generated and daily puzzles have metadata too
+	seedID := seedPuzzleID(puzzle.GenerateParams{Seed: "1:meta", SideLength: 4})
+	if status := helperUserRequest(t, srv, "", "GET", "/api/puzzles/"+seedID, nil, &meta); status != http.StatusOK ||
+		meta.Source != generatedSource || meta.SideLength != 4 {
+		t.Errorf("Generated puzzle metadata gave %d, %+v", status, meta)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/api/puzzles/"+dailyID(time.Now()), nil, &meta); status != http.StatusOK ||
+		meta.Source != dailySource || meta.Stars == 0 {
+		t.Errorf("Daily puzzle metadata gave %d, %+v", status, meta)
+	}
+	if status := helperUserRequest(t, srv, "", "GET", "/api/puzzles/no-such-puzzle", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Unknown puzzle metadata gave status %d", status)
+	}
+}
+
+func TestGridUpload(t *testing.T) {
+	srv := helperUserServer(newSession("test-grid-upload"))
+	defer srv.Close()
+	roles.Grant("header:setter", auth.RoleSetter)
+	defer roles.Revoke("header:setter", auth.RoleSetter)
+	defer helperRemovePuzzles("test-grid")
+
+	post := func(text string) (int, puzzle.Error) {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/catalog/test-grid", strings.NewReader(text))
+		req.Header.Set("X-Test-User", "setter")
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("Upload request error: %v", e)
+		}
+		defer r.Body.Close()
+		var err puzzle.Error
+		json.NewDecoder(r.Body).Decode(&err)
+		return r.StatusCode, err
+	}
+
+	// malformed grids say where the problem is
+	grid := helperCollectionText(t, puzzle.GridFormat, "3-star")
+	bad := strings.Replace(grid, "\n", "\n,x", 1)
+	if status, err := post(bad); status != http.StatusBadRequest || !strings.Contains(err.Message, "Line 2, column 2") {
+		t.Errorf("Upload of a bad grid gave %d, %+v", status, err)
+	}
+
+	// spreadsheet grids are added like any other upload
+	if status, _ := post(strings.Replace(grid, ",", "\t", -1)); status != http.StatusOK {
+		t.Fatalf("Upload of a grid gave status %d", status)
+	}
+	if vals, ok := lookupPuzzle("test-grid"); !ok || !reflect.DeepEqual(vals, helperNewPuzzle("3-star")) {
+		t.Errorf("Grid upload added %v, %v", vals, ok)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Quotas
+
+Some operations (generating puzzles, analyzing them, importing
+them) take a lot more CPU than playing does, so each user gets a
+quota of them per hour, and gets a 429 response when it's used
+up.  Users are counted by their user key when they're
+identified, and by session otherwise.  Every response to a
+metered operation says how much of the quota is left, in the
+X-Quota-Limit, X-Quota-Remaining, and X-Quota-Reset (seconds
+until the quota is refilled) headers.
+
+The hourly limits can be changed with SUSEN_QUOTA_GENERATE,
+SUSEN_QUOTA_ANALYZE, and SUSEN_QUOTA_IMPORT.
+
+*/
+
+// A quotaKind is a class of metered operations.
+type quotaKind string
+
+// The metered operation kinds.
+const (
+	quotaGenerate quotaKind = "generate"
+	quotaAnalyze  quotaKind = "analyze"
+	quotaImport   quotaKind = "import"
+)
+
+// quotaWindow is the period over which quotas are counted, and
+// maxQuotaUsages is how many counts are kept before those from
+// ended windows are dropped.
+const (
+	quotaWindow    = time.Hour
+	maxQuotaUsages = 1000
+)
+
+// A quotaUsage is one user's count of one kind of operation in
+// the current window.
+type quotaUsage struct {
+	start time.Time
+	used  int
+}
+
+var (
+	quotaLimits = map[quotaKind]int{
+		quotaGenerate: quotaLimitFromEnv(quotaGenerate, 30),
+		quotaAnalyze:  quotaLimitFromEnv(quotaAnalyze, 120),
+		quotaImport:   quotaLimitFromEnv(quotaImport, 30),
+	}
+	quotaUsages = make(map[string]*quotaUsage)
+	quotaMutex  sync.Mutex
+)
+
+// quotaLimitFromEnv returns the hourly limit for a kind of
+// operation, as set in the environment or else the default.
+func quotaLimitFromEnv(kind quotaKind, def int) int {
+	name := "SUSEN_QUOTA_" + strings.ToUpper(string(kind))
+	if s := os.Getenv(name); s != "" {
+		if n, e := strconv.Atoi(s); e == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Ignoring invalid %s value %q.", name, s)
+	}
+	return def
+}
+
+// quotaKey is the key under which a request's usage is counted:
+// the user, if identified, otherwise the session (if any).
+func quotaKey(r *http.Request, session *susenSession) string {
+	if user := auth.FromRequest(r); user != nil {
+		return user.Key()
+	}
+	if session != nil {
+		return "session:" + session.sessionID
+	}
+	return "anonymous"
+}
+
+// takeQuota uses up one operation of the given kind from the
+// key's quota, and adds the quota headers to the response.  If
+// the quota is exhausted, it sends a 429 response and returns
+// false, in which case the caller must not do the operation.
+func takeQuota(w http.ResponseWriter, key string, kind quotaKind) bool {
+	now := time.Now()
+	quotaMutex.Lock()
+	limit := quotaLimits[kind]
+	usage := quotaUsages[string(kind)+" "+key]
+	if usage == nil || now.Sub(usage.start) >= quotaWindow {
+		if len(quotaUsages) >= maxQuotaUsages {
+			// forget windows that have ended
+			for k, u := range quotaUsages {
+				if now.Sub(u.start) >= quotaWindow {
+					delete(quotaUsages, k)
+				}
+			}
+		}
+		usage = &quotaUsage{start: now}
+		quotaUsages[string(kind)+" "+key] = usage
+	}
+	ok := usage.used < limit
+	if ok {
+		usage.used++
+	}
+	remaining, reset := limit-usage.used, usage.start.Add(quotaWindow).Sub(now)
+	quotaMutex.Unlock()
+
+	resetSecs := strconv.Itoa(int(reset/time.Second) + 1)
+	hs := w.Header()
+	hs.Set("X-Quota-Limit", strconv.Itoa(limit))
+	hs.Set("X-Quota-Remaining", strconv.Itoa(remaining))
+	hs.Set("X-Quota-Reset", resetSecs)
+	if !ok {
+		log.Printf("%v has used up its %s quota.", key, kind)
+		hs.Set("Retry-After", resetSecs)
+		sendError(w, http.StatusTooManyRequests,
+			requestError(fmt.Sprintf("Quota of %d %s operations per hour exhausted", limit, kind)))
+	}
+	return ok
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestTakeQuota(t *testing.T) {
+	saved := quotaLimits[quotaAnalyze]
+	quotaLimits[quotaAnalyze] = 2
+	defer func() { quotaLimits[quotaAnalyze] = saved }()
+
+	for i := 1; i <= 3; i++ {
+		w := httptest.NewRecorder()
+		ok := takeQuota(w, "test:quota", quotaAnalyze)
+		remaining, _ := strconv.Atoi(w.Header().Get("X-Quota-Remaining"))
+		if i <= 2 && (!ok || remaining != 2-i) {
+			t.Errorf("use %d: got %v with %d remaining", i, ok, remaining)
+		}
+		if i == 3 && (ok || w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "") {
+			t.Errorf("use %d: got %v, status %d, headers %v", i, ok, w.Code, w.Header())
+		}
+		if w.Header().Get("X-Quota-Limit") != "2" {
+			t.Errorf("use %d: limit header is %q", i, w.Header().Get("X-Quota-Limit"))
+		}
+	}
+	// other kinds and other keys have their own quotas
+	if !takeQuota(httptest.NewRecorder(), "test:quota", quotaImport) {
+		t.Errorf("Import quota used up by analysis")
+	}
+	if !takeQuota(httptest.NewRecorder(), "test:quota-other", quotaAnalyze) {
+		t.Errorf("Analysis quota shared between keys")
+	}
+}
+
+func TestRatingQuota(t *testing.T) {
+	saved := quotaLimits[quotaAnalyze]
+	quotaLimits[quotaAnalyze] = 1
+	defer func() { quotaLimits[quotaAnalyze] = saved }()
+	session := newSession("test-rating-quota")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	// contest and unassisted boards are refused without using
+	// the quota
+	for _, flag := range []*bool{&session.contest, &session.unassisted} {
+		*flag = true
+		r, e := http.Get(srv.URL + "/api/rating/")
+		*flag = false
+		if e != nil {
+			t.Fatalf("Rating request error: %v", e)
+		}
+		r.Body.Close()
+		if r.StatusCode != http.StatusForbidden || r.Header.Get("X-Quota-Remaining") != "" {
+			t.Errorf("Rating of a contest or unassisted board gave status %d, %v", r.StatusCode, r.Header)
+		}
+	}
+
+	r, e := http.Get(srv.URL + "/api/rating/")
+	if e != nil {
+		t.Fatalf("Rating request error: %v", e)
+	}
+	var rating puzzle.Rating
+	e = json.NewDecoder(r.Body).Decode(&rating)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || e != nil || rating.Stars != 1 {
+		t.Errorf("Rating gave %d, %+v, %v", r.StatusCode, rating, e)
+	}
+	r, e = http.Get(srv.URL + "/api/rating/")
+	if e != nil {
+		t.Fatalf("Rating request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Rating over quota gave status %d", r.StatusCode)
+	}
+}
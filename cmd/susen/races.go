@@ -0,0 +1,431 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Races
+
+A race is like a room (see rooms.go), but its players each solve
+the same puzzle on their own boards, to see who finishes first.  The session that
+creates a race hosts it: the race is on the host's current
+puzzle, and the host can give players handicaps before starting
+it.  A handicap is a head start (the player's board starts that
+many seconds before the others'), and a number of squares that
+are filled in for the player before they start.  The squares
+are chosen by the engine: they're the ones a person would find
+first (see puzzle.Suggest), so players with the same number of
+squares get the same help, and each square saves the player a
+step of the solve.  Boards with handicap squares don't count on
+the puzzle's leaderboard.
+
+Handicaps are enforced by the server, which starts each
+player's board on the race puzzle at the player's own start
+time: until then, the player's board takes no changes, and once
+the race is on, a player's board can't be started over except
+by leaving the race.  Players' finishing times are measured from
+the race's start, so head starts count.  The race's players get
+a race event when it starts and whenever a player finishes.
+
+- POST /api/race/create/ makes a race on the session's puzzle
+
+- POST /api/race/join/<code> puts the session in a race that
+hasn't started
+
+- POST /api/race/handicap/<n> sets the handicap of the race's
+player n (from 0), taking a JSON raceHandicap; only the host can
+set them, and only before the race starts
+
+- POST /api/race/start/ starts the race (for the host)
+
+- POST /api/race/leave/ takes the session out of its race
+
+- GET /api/race/ describes the session's race
+
+All of them respond with the race as the session sees it.
+Tournaments (see tournaments.go) are races with no host, so
+their players can't set handicaps or start them.
+
+*/
+
+// The largest head start and number of handicap squares.
+const (
+	maxHeadStart       = 10 * time.Minute
+	maxHandicapSquares = 30
+)
+
+// A raceHandicap is a player's advantage in a race.
+type raceHandicap struct {
+	HeadStart int `json:"headStart"` // seconds
+	Squares   int `json:"squares"`   // squares filled in by the engine
+}
+
+// A susenRace is a race and its players, guarded by raceMutex.
+// Its puzzle and handicaps can't change once it's started.
+type susenRace struct {
+	code     string
+	puzzleID string
+	values   []int
+	players  []*racePlayer // the host is first
+	start    time.Time     // when the race starts, zero until it's started
+
+	tournament *susenTournament // the tournament run as the race, if any
+}
+
+// A racePlayer is a session in a race.
+type racePlayer struct {
+	session  *susenSession
+	handicap raceHandicap
+	start    time.Time  // when the player's board starts
+	finished *time.Time // when the player finished
+}
+
+// A raceInfo is a race as given to one of its players.
+type raceInfo struct {
+	Race     string           `json:"race"`
+	PuzzleID string           `json:"puzzleID"`
+	You      int              `json:"you"`  // the player's position
+	Host     bool             `json:"host"` // whether the player is the host
+	Start    *time.Time       `json:"start,omitempty"`
+	Players  []racePlayerInfo `json:"players"`
+}
+
+// A racePlayerInfo is a player as described in a raceInfo.
+type racePlayerInfo struct {
+	Name      string       `json:"name"`
+	Handicap  raceHandicap `json:"handicap"`
+	Start     *time.Time   `json:"start,omitempty"`
+	Finished  *time.Time   `json:"finished,omitempty"`
+	SolveTime float64      `json:"solveTime,omitempty"` // seconds from the race's start
+	Place     int          `json:"place,omitempty"`
+}
+
+var (
+	races     = make(map[string]*susenRace)
+	raceMutex sync.Mutex // guards the race registry and races; taken after board locks
+)
+
+// handicapValues returns the starting values of a puzzle with a
+// number of squares filled in by the engine.  Puzzles the engine
+// can't hint get fewer squares.
+func handicapValues(values []int, squares int) []int {
+	vals := append([]int(nil), values...)
+	p, e := puzzle.New(vals)
+	for i := 0; e == nil && i < squares; i++ {
+		var hint puzzle.Hint
+		if hint, e = puzzle.Suggest(p); e == nil {
+			_, e = p.Assign(hint.Choice)
+			vals[hint.Choice.Index] = hint.Choice.Value
+		}
+	}
+	return vals
+}
+
+// createRace makes a race on the session's puzzle, hosted by the
+// session.  It must be called with the board locked.
+func (session *susenSession) createRace() *susenRace {
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	var race *susenRace
+	for {
+		// race codes are drawn from the same space as room codes
+		roomMutex.Lock()
+		code := newRoomCode()
+		roomMutex.Unlock()
+		if _, ok := races[code]; !ok {
+			race = &susenRace{code: code, puzzleID: session.puzzleID, values: session.values}
+			break
+		}
+	}
+	race.players = []*racePlayer{{session: session}}
+	races[race.code] = race
+	session.race = race
+	log.Printf("Session %v created race %v on puzzle %q.", session.sessionID, race.code, race.puzzleID)
+	return race
+}
+
+// joinRace puts the session in the race with the given code,
+// returning the status of the response if it can't.  It must be
+// called with the board locked.
+func (session *susenSession) joinRace(code string) (int, error) {
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	race, ok := races[code]
+	if !ok {
+		return http.StatusNotFound, requestError("No race with code " + code)
+	}
+	if !race.start.IsZero() {
+		return http.StatusConflict, requestError("Race " + code + " has already started")
+	}
+	race.players = append(race.players, &racePlayer{session: session})
+	session.race = race
+	log.Printf("Session %v joined race %v.", session.sessionID, code)
+	return http.StatusOK, nil
+}
+
+// leaveRace takes the session out of its race, if it's in one.
+// The race is removed when its last player leaves, unless it's a
+// tournament's (see tournaments.go).  It must be
+// called with the board locked.
+func (session *susenSession) leaveRace() {
+	race := session.race
+	if race == nil {
+		return
+	}
+	session.race = nil
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	for i, p := range race.players {
+		if p.session == session {
+			race.players = append(race.players[:i], race.players[i+1:]...)
+			break
+		}
+	}
+	if len(race.players) == 0 && race.tournament == nil {
+		delete(races, race.code)
+		log.Printf("Race %v removed.", race.code)
+	}
+	log.Printf("Session %v left race %v.", session.sessionID, race.code)
+}
+
+// begin starts a race that hasn't started, starting each
+// player's board at the player's start time.
+func (race *susenRace) begin() {
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	lead := time.Duration(0)
+	for _, p := range race.players {
+		if d := time.Duration(p.handicap.HeadStart) * time.Second; d > lead {
+			lead = d
+		}
+	}
+	now := time.Now()
+	race.start = now.Add(lead)
+	log.Printf("Race %v starts at %v.", race.code, race.start)
+	for _, p := range race.players {
+		p := p
+		p.start = race.start.Add(-time.Duration(p.handicap.HeadStart) * time.Second)
+		vals := handicapValues(race.values, p.handicap.Squares)
+		time.AfterFunc(p.start.Sub(now), func() { race.startBoard(p, vals) })
+	}
+}
+
+// startBoard starts a player's board on the race's puzzle, unless
+// the player has left the race.
+func (race *susenRace) startBoard(p *racePlayer, vals []int) {
+	session := p.session
+	session.lockBoard()
+	defer session.unlockBoard()
+	if session.race != race {
+		return
+	}
+	session.start(race.puzzleID, vals)
+	session.stats.Started = p.start
+	session.handicapped = p.handicap.Squares > 0
+	session.notifySquares()
+	session.notify(sessionEvent{Type: raceEventType})
+	log.Printf("Session %v started race %v with handicap %+v.", session.sessionID, race.code, p.handicap)
+}
+
+// finish records that a player finished the race, ending its
+// tournament if everyone has.  It must be called with the
+// player's board locked.
+func (race *susenRace) finish(session *susenSession, at time.Time) {
+	raceMutex.Lock()
+	var members []*susenSession
+	for _, p := range race.players {
+		if p.session == session && p.finished == nil && !p.start.IsZero() {
+			p.finished = &at
+			log.Printf("Session %v finished race %v in %v.", session.sessionID, race.code, at.Sub(race.start))
+		}
+		if p.session != session {
+			members = append(members, p.session)
+		}
+	}
+	over := race.tournament != nil && race.tournament.finishedAll()
+	raceMutex.Unlock()
+	session.notify(sessionEvent{Type: raceEventType})
+	for _, member := range members {
+		member.notify(sessionEvent{Type: raceEventType})
+	}
+	if over {
+		go race.tournament.end()
+	}
+}
+
+// refuseRaceChange sends an error response, and returns true, if
+// the request would change a race board before the player's start,
+// or start it over after.
+func (session *susenSession) refuseRaceChange(w http.ResponseWriter, r *http.Request) bool {
+	race := session.race
+	if race == nil {
+		return false
+	}
+	raceMutex.Lock()
+	var start time.Time
+	for _, p := range race.players {
+		if p.session == session {
+			start = p.start
+		}
+	}
+	raceMutex.Unlock()
+	if start.IsZero() {
+		return false
+	}
+	if wait := time.Until(start); wait > 0 {
+		if r.Method == "GET" && !strings.Contains(r.URL.Path, "/reset/") && !strings.Contains(r.URL.Path, "/back/") {
+			return false
+		}
+		sendError(w, http.StatusConflict, requestError(
+			"Your race starts in "+strconv.Itoa(int(wait.Seconds()+1))+" seconds"))
+		return true
+	}
+	if strings.Contains(r.URL.Path, "/reset/") {
+		sendError(w, http.StatusConflict, requestError("Race boards can't be started over; leave the race first"))
+		return true
+	}
+	return false
+}
+
+// info describes the race to one of its players.  It must be
+// called with the races locked.
+func (race *susenRace) info(session *susenSession) raceInfo {
+	info := raceInfo{Race: race.code, PuzzleID: race.puzzleID, You: -1, Players: []racePlayerInfo{}}
+	if !race.start.IsZero() {
+		start := race.start
+		info.Start = &start
+	} else if race.tournament != nil {
+		// tournament puzzles are hidden until they start
+		info.PuzzleID = ""
+	}
+	var finished []int
+	for i, p := range race.players {
+		_, name := p.session.player()
+		pi := racePlayerInfo{Name: name, Handicap: p.handicap}
+		if !p.start.IsZero() {
+			start := p.start
+			pi.Start = &start
+		}
+		if p.finished != nil {
+			pi.Finished = p.finished
+			pi.SolveTime = p.finished.Sub(race.start).Seconds()
+			finished = append(finished, i)
+		}
+		if p.session == session {
+			info.You, info.Host = i, i == 0 && race.tournament == nil
+		}
+		info.Players = append(info.Players, pi)
+	}
+	sort.SliceStable(finished, func(a, b int) bool {
+		return race.players[finished[a]].finished.Before(*race.players[finished[b]].finished)
+	})
+	for place, i := range finished {
+		info.Players[i].Place = place + 1
+	}
+	return info
+}
+
+// raceHandler handles the race endpoints (see above).
+func (session *susenSession) raceHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/race/"), "/")
+	session.lockBoard()
+	defer session.unlockBoard()
+	if r.Method == "POST" {
+		status, e := http.StatusOK, error(nil)
+		switch {
+		case path == "create" || strings.HasPrefix(path, "join/"):
+			if session.room != nil {
+				status, e = http.StatusConflict, requestError("Rooms can't race; leave the room first")
+			} else if path == "create" {
+				if session.race == nil {
+					session.createRace()
+				}
+			} else if code := strings.ToUpper(path[len("join/"):]); session.race == nil || session.race.code != code {
+				session.leaveRace()
+				status, e = session.joinRace(code)
+			}
+		case path == "leave":
+			session.leaveRace()
+			sendJSON(w, http.StatusOK, raceInfo{You: -1, Players: []racePlayerInfo{}})
+			return
+		case strings.HasPrefix(path, "handicap/"):
+			status, e = session.setHandicap(w, r, path[len("handicap/"):])
+		case path == "start":
+			if status, e = session.hostRace(); e == nil {
+				session.race.begin()
+			}
+		default:
+			status, e = http.StatusNotFound, requestError("Unknown race operation: "+path)
+		}
+		if e != nil {
+			sendError(w, status, e.(puzzle.Error))
+			return
+		}
+	}
+	if session.race == nil {
+		sendError(w, http.StatusNotFound, requestError("The session isn't in a race"))
+		return
+	}
+	raceMutex.Lock()
+	info := session.race.info(session)
+	raceMutex.Unlock()
+	sendJSON(w, http.StatusOK, info)
+}
+
+// hostRace checks that the session hosts a race that hasn't
+// started, returning the status of the response if it doesn't.
+func (session *susenSession) hostRace() (int, error) {
+	race := session.race
+	if race == nil {
+		return http.StatusNotFound, requestError("The session isn't in a race")
+	}
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	if race.tournament != nil {
+		return http.StatusForbidden, requestError("Tournaments start on schedule, with no handicaps")
+	}
+	if race.players[0].session != session {
+		return http.StatusForbidden, requestError("Only the host can do that")
+	}
+	if !race.start.IsZero() {
+		return http.StatusConflict, requestError("The race has already started")
+	}
+	return http.StatusOK, nil
+}
+
+// setHandicap sets the handicap of the race's player at the given
+// position, as given in the request body.
+func (session *susenSession) setHandicap(w http.ResponseWriter, r *http.Request, position string) (int, error) {
+	if status, e := session.hostRace(); e != nil {
+		return status, e
+	}
+	var h raceHandicap
+	if e := decodeRequest(w, r, &h); e != nil {
+		return requestStatus(e), requestError("Invalid handicap: " + e.Error())
+	}
+	if h.HeadStart < 0 || time.Duration(h.HeadStart)*time.Second > maxHeadStart ||
+		h.Squares < 0 || h.Squares > maxHandicapSquares {
+		return http.StatusBadRequest, requestError("Handicaps can be up to " + maxHeadStart.String() +
+			" and " + strconv.Itoa(maxHandicapSquares) + " squares")
+	}
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	n, e := puzzle.ParseInt("position", position)
+	if e != nil {
+		return http.StatusBadRequest, e
+	}
+	if n < 0 || n >= len(session.race.players) {
+		return http.StatusNotFound, requestError("No race player " + position)
+	}
+	session.race.players[n].handicap = h
+	return http.StatusOK, nil
+}
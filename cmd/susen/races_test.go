@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func helperRaceRequest(t *testing.T, srv *httptest.Server, op string, body interface{}) (int, raceInfo) {
+	bs, _ := json.Marshal(body)
+	r, e := http.Post(srv.URL+"/api/race/"+op, "application/json", bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Race %q request error: %v", op, e)
+	}
+	defer r.Body.Close()
+	var info raceInfo
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(&info); e != nil {
+			t.Fatalf("Race %q decode error: %v", op, e)
+		}
+	}
+	return r.StatusCode, info
+}
+
+// helperRaceStarted waits for a session's race board to start.
+func helperRaceStarted(t *testing.T, session *susenSession) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		started := false
+		session.mutex.Lock()
+		if race := session.race; race != nil {
+			raceMutex.Lock()
+			for _, p := range race.players {
+				if p.session == session {
+					started = !p.start.IsZero() && session.stats.Started.Equal(p.start)
+				}
+			}
+			raceMutex.Unlock()
+		}
+		session.mutex.Unlock()
+		if started {
+			return
+		}
+	}
+	t.Fatalf("Session %v's race board didn't start", session.sessionID)
+}
+
+func TestHandicapValues(t *testing.T) {
+	vals, _ := lookupPuzzle(defaultPuzzleID)
+	few, more := handicapValues(vals, 3), handicapValues(vals, 5)
+	added := 0
+	for i := range vals {
+		if vals[i] != 0 {
+			if few[i] != vals[i] || more[i] != vals[i] {
+				t.Fatalf("Handicap changed given square %d", i)
+			}
+			continue
+		}
+		if few[i] != 0 && few[i] != more[i] {
+			t.Errorf("Handicaps of 3 and 5 squares differ at square %d", i)
+		}
+		if more[i] != 0 {
+			added++
+		}
+	}
+	if added != 5 {
+		t.Errorf("Handicap of 5 squares filled %d", added)
+	}
+	if p, e := puzzle.New(more); e != nil || p.IsProper() != nil {
+		t.Errorf("Handicapped puzzle isn't proper: %v", e)
+	}
+}
+
+func TestRaces(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	host, guest := newSession("test-race-host"), newSession("test-race-guest")
+	guest.reset("2-star")
+	hsrv := httptest.NewServer(http.HandlerFunc(host.rootHandler))
+	defer hsrv.Close()
+	gsrv := httptest.NewServer(http.HandlerFunc(guest.rootHandler))
+	defer gsrv.Close()
+
+	if status, _ := helperRaceRequest(t, gsrv, "join/NOSUCHRC", nil); status != http.StatusNotFound {
+		t.Errorf("Join of unknown race gave status %d", status)
+	}
+	status, info := helperRaceRequest(t, hsrv, "create/", nil)
+	if status != http.StatusOK || info.Race == "" || !info.Host || info.PuzzleID != defaultPuzzleID {
+		t.Fatalf("Create gave %d, %+v", status, info)
+	}
+	defer helperRaceRequest(t, hsrv, "leave/", nil)
+	if status, joined := helperRaceRequest(t, gsrv, "join/"+info.Race, nil); status != http.StatusOK ||
+		joined.You != 1 || joined.Host || len(joined.Players) != 2 {
+		t.Fatalf("Join gave %d, %+v", status, joined)
+	}
+	defer helperRaceRequest(t, gsrv, "leave/", nil)
+
+	// only the host sets handicaps, and only reasonable ones
+	handicap := raceHandicap{HeadStart: 1, Squares: 5}
+	if status, _ := helperRaceRequest(t, gsrv, "handicap/1", handicap); status != http.StatusForbidden {
+		t.Errorf("Guest setting a handicap gave status %d", status)
+	}
+	if status, _ := helperRaceRequest(t, hsrv, "handicap/1", raceHandicap{Squares: 100}); status != http.StatusBadRequest {
+		t.Errorf("Huge handicap gave status %d", status)
+	}
+	if status, _ := helperRaceRequest(t, hsrv, "handicap/2", handicap); status != http.StatusNotFound {
+		t.Errorf("Handicap of a missing player gave status %d", status)
+	}
+	if status, set := helperRaceRequest(t, hsrv, "handicap/1", handicap); status != http.StatusOK ||
+		set.Players[1].Handicap != handicap {
+		t.Errorf("Handicap gave %d, %+v", status, set)
+	}
+
+	// the guest's head start and squares are enforced
+	if status, started := helperRaceRequest(t, hsrv, "start/", nil); status != http.StatusOK || started.Start == nil {
+		t.Fatalf("Start gave %d, %+v", status, started)
+	}
+	if status, _ := helperRaceRequest(t, hsrv, "start/", nil); status != http.StatusConflict {
+		t.Errorf("Second start gave status %d", status)
+	}
+	helperRaceStarted(t, guest)
+	if status := helperRoomAssign(t, hsrv, helperTurnChoice(host)); status != http.StatusConflict {
+		t.Errorf("Host assign during the guest's head start gave status %d", status)
+	}
+	vals, _ := lookupPuzzle(defaultPuzzleID)
+	if want := handicapValues(vals, handicap.Squares); !equalValues(guest.values, want) || !guest.handicapped {
+		t.Errorf("Guest's race values are %v, expected %v", guest.values, want)
+	}
+	if r, e := http.Get(gsrv.URL + "/api/reset/"); e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	} else if r.Body.Close(); r.StatusCode != http.StatusConflict {
+		t.Errorf("Guest reset of a race board gave status %d", r.StatusCode)
+	}
+	helperSolve(t, gsrv, guest)
+	helperRaceStarted(t, host)
+	helperSolve(t, hsrv, host)
+
+	var final raceInfo
+	helperGetJSON(t, hsrv, "/api/race/", &final)
+	if final.Players[1].Place != 1 || final.Players[0].Place != 2 || final.Players[0].SolveTime <= 0 {
+		t.Errorf("Race result is %+v", final.Players)
+	}
+	var lb leaderboard
+	if helperGetJSON(t, hsrv, "/api/leaderboard/"+defaultPuzzleID, &lb); len(lb.Entries) != 1 {
+		t.Errorf("Leaderboard after the race is %+v", lb.Entries)
+	}
+	if status, _ := helperRaceRequest(t, gsrv, "leave/", nil); status != http.StatusOK || guest.race != nil {
+		t.Errorf("Leave gave status %d", status)
+	}
+	if status := helperGetJSON(t, gsrv, "/api/race/", nil); status != http.StatusNotFound {
+		t.Errorf("Race info after leaving gave status %d", status)
+	}
+}
+
+// equalValues tells whether two sets of puzzle values are the same.
+func equalValues(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+
+Random puzzles
+
+Resetting to the puzzle ID "random" starts a puzzle chosen at
+random: /reset/random?stars=<n>&geometry=<side length> picks a
+catalog puzzle with the given star rating (see puzzle.Rate) and
+side length, and if the catalog has none, generates one, trying
+up to randomTries seeds (which is metered as generation).  Both
+parameters are optional: without stars any rating will do, and
+without geometry the session's preferred side length (see
+prefs.go) is used.  The choices are made with the seed source
+(see randomSeed), so they're as reproducible as it is.
+
+Generated puzzles are started under the puzzle ID
+"seed:<side length>:<seed>", and resetting to that ID starts the
+same puzzle again, so they can be shared and retried like
+catalog puzzles.  Resetting to a generated puzzle generates it
+again (which is metered as generation, and stops if the request
+does), unless it's the board's puzzle, which is just started
+over.  Like other resets, the response redirects to
+the solver; it says what was chosen in its X-Puzzle-ID header,
+and for generated puzzles, their seed in its X-Puzzle-Seed
+header.  If no puzzle can be found, the response is Not Found,
+and the board is left as it was.
+
+*/
+
+// randomPuzzleID is the puzzle ID that starts a random puzzle,
+// and seedIDPrefix starts the puzzle IDs of generated ones.
+const (
+	randomPuzzleID = "random"
+	seedIDPrefix   = "seed:"
+)
+
+// randomTries is how many puzzles are generated, at most, to
+// find one with the requested rating.
+var randomTries = 20
+
+// The headers that say which puzzle was chosen.
+const (
+	puzzleIDHeader   = "X-Puzzle-ID"
+	puzzleSeedHeader = "X-Puzzle-Seed"
+)
+
+var (
+	catalogStars      = make(map[string]int) // star ratings by fingerprint
+	catalogStarsMutex sync.Mutex
+)
+
+// seedPuzzleID returns the puzzle ID of a generated puzzle.
+func seedPuzzleID(params puzzle.GenerateParams) string {
+	return seedIDPrefix + strconv.Itoa(params.SideLength) + ":" + params.Seed
+}
+
+// parseSeedID returns the generation parameters of a generated
+// puzzle's ID, or false if it isn't one.
+func parseSeedID(puzzleID string) (puzzle.GenerateParams, bool) {
+	if !strings.HasPrefix(puzzleID, seedIDPrefix) {
+		return puzzle.GenerateParams{}, false
+	}
+	fields := strings.SplitN(puzzleID[len(seedIDPrefix):], ":", 2)
+	if len(fields) != 2 || fields[1] == "" {
+		return puzzle.GenerateParams{}, false
+	}
+	sidelen, e := strconv.Atoi(fields[0])
+	if e != nil || sidelen <= 0 {
+		return puzzle.GenerateParams{}, false
+	}
+	return puzzle.GenerateParams{Seed: fields[1], SideLength: sidelen}, true
+}
+
+// seedValues returns the values of a generated puzzle's ID, or
+// false if it isn't one, or its puzzle can't be generated.
+func seedValues(puzzleID string) ([]int, bool) {
+	params, ok := parseSeedID(puzzleID)
+	if !ok {
+		return nil, false
+	}
+	g, e := generatePuzzle(context.Background(), params, nil)
+	if e != nil {
+		return nil, false
+	}
+	return g.Values, true
+}
+
+// randomIndex returns a number in [0, n) from the seed source.
+func randomIndex(n int) int {
+	var b [8]byte
+	if _, e := io.ReadFull(seedSource, b[:]); e != nil {
+		log.Fatal(e)
+	}
+	return int(binary.BigEndian.Uint64(b[:]) % uint64(n))
+}
+
+// starsOf returns the star rating of a puzzle, or 0 if it can't
+// be rated.  Catalog puzzles' ratings are kept.
+func starsOf(vals []int, keep bool) int {
+	fingerprint := puzzle.Fingerprint(vals)
+	catalogStarsMutex.Lock()
+	stars, ok := catalogStars[fingerprint]
+	catalogStarsMutex.Unlock()
+	if ok {
+		return stars
+	}
+	if p, e := puzzle.New(vals); e == nil && p.IsProper() == nil {
+		if rating, e := puzzle.Rate(p); e == nil {
+			stars = rating.Stars
+		}
+	}
+	if keep {
+		catalogStarsMutex.Lock()
+		catalogStars[fingerprint] = stars
+		catalogStarsMutex.Unlock()
+	}
+	return stars
+}
+
+// sideLength returns the side length of a Sudoku puzzle's
+// values, or 0 if they aren't a Sudoku puzzle's.
+func sideLength(vals []int) int {
+	if len(vals) == 0 || vals[0] != puzzle.SudokuGeometryCode {
+		return 0
+	}
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return 0
+	}
+	return p.State().SideLenth
+}
+
+// A randomChoice is a puzzle chosen at random.
+type randomChoice struct {
+	puzzleID string
+	seed     string // for generated puzzles
+	values   []int
+}
+
+// chooseRandom chooses a random puzzle for a request, adding its
+// ID (and seed) to the response headers, or sends the error
+// response and returns false if there's none to be had.
+func (session *susenSession) chooseRandom(w http.ResponseWriter, r *http.Request) (randomChoice, bool) {
+	q := r.URL.Query()
+	stars, sidelen := 0, session.preferences().SideLength
+	for name, field := range map[string]*int{"stars": &stars, "geometry": &sidelen} {
+		if s := q.Get(name); s != "" {
+			n, e := puzzle.ParseInt(name, s)
+			if e == nil && n < 1 {
+				e = fieldError(name, "Invalid "+name+" parameter: "+s)
+			}
+			if e != nil {
+				sendError(w, http.StatusBadRequest, e.(puzzle.Error))
+				return randomChoice{}, false
+			}
+			*field = n
+		}
+	}
+
+	var matches []string
+	for _, id := range catalogIDs() {
+		vals, ok := lookupPuzzle(id)
+		if ok && sideLength(vals) == sidelen && (stars == 0 || starsOf(vals, true) == stars) {
+			matches = append(matches, id)
+		}
+	}
+	var choice randomChoice
+	if len(matches) > 0 {
+		choice.puzzleID = matches[randomIndex(len(matches))]
+		choice.values, _ = lookupPuzzle(choice.puzzleID)
+	} else {
+		if !takeQuota(w, quotaKey(r, session), quotaGenerate) {
+			return choice, false
+		}
+		for try := 0; try < randomTries && choice.values == nil; try++ {
+			g, e := generatePuzzle(r.Context(), puzzle.GenerateParams{Seed: randomSeed(), SideLength: sidelen}, nil)
+			if e != nil {
+				sendSolverError(w, http.StatusBadRequest, e)
+				return choice, false
+			}
+			if stars == 0 || starsOf(g.Values, false) == stars {
+				choice = randomChoice{seedPuzzleID(g.GenerateParams), g.Seed, g.Values}
+			}
+		}
+	}
+	if choice.values == nil {
+		sendError(w, http.StatusNotFound,
+			requestError("No "+strconv.Itoa(stars)+"-star puzzle with side length "+strconv.Itoa(sidelen)+" was found"))
+		return choice, false
+	}
+	w.Header().Set(puzzleIDHeader, choice.puzzleID)
+	if choice.seed != "" {
+		w.Header().Set(puzzleSeedHeader, choice.seed)
+	}
+	log.Printf("Chose puzzle %q at random for session %v.", choice.puzzleID, session.sessionID)
+	return choice, true
+}
+
+// chooseSeed generates the puzzle with a seed ID for a request,
+// or sends the error response and returns false if it can't.
+func (session *susenSession) chooseSeed(w http.ResponseWriter, r *http.Request, params puzzle.GenerateParams) (randomChoice, bool) {
+	if !takeQuota(w, quotaKey(r, session), quotaGenerate) {
+		return randomChoice{}, false
+	}
+	g, e := generatePuzzle(r.Context(), params, nil)
+	if e != nil {
+		sendSolverError(w, http.StatusBadRequest, e)
+		return randomChoice{}, false
+	}
+	return randomChoice{seedPuzzleID(params), g.Seed, g.Values}, true
+}
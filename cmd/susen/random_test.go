@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRandomPuzzle(t *testing.T) {
+	defer func(source io.Reader) { seedSource = source }(seedSource)
+	seedSource = puzzle.NewSeedSource("test-random")
+	session := newSession("test-random")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	reset := func(query string) *http.Response {
+		r, e := client.Get(srv.URL + "/reset/random" + query)
+		if e != nil {
+			t.Fatalf("Random reset request error: %v", e)
+		}
+		r.Body.Close()
+		return r
+	}
+
+	// catalog puzzles are chosen by rating
+	stars := starsOf(puzzleValues["3-star"], true)
+	r := reset("?stars=" + strconv.Itoa(stars) + "&geometry=9")
+	id := r.Header.Get(puzzleIDHeader)
+	if r.StatusCode != http.StatusFound || session.puzzleID != id || r.Header.Get(puzzleSeedHeader) != "" {
+		t.Fatalf("Random catalog reset gave %d, %q (board has %q)", r.StatusCode, id, session.puzzleID)
+	}
+	if vals, ok := lookupPuzzle(id); !ok || starsOf(vals, true) != stars {
+		t.Errorf("Random catalog reset chose %q, which doesn't have %d stars", id, stars)
+	}
+
+	// puzzles the catalog doesn't have are generated, and can be
+	// started again by their IDs
+	r = reset("?geometry=4")
+	id, seed := r.Header.Get(puzzleIDHeader), r.Header.Get(puzzleSeedHeader)
+	if r.StatusCode != http.StatusFound || !strings.HasPrefix(id, seedIDPrefix+"4:") || seed == "" ||
+		session.puzzleID != id || !strings.HasSuffix(id, seed) {
+		t.Fatalf("Random generated reset gave %d, %q, %q", r.StatusCode, id, seed)
+	}
+	generated := session.values
+	session.reset(defaultPuzzleID)
+	if r := reset(""); r.StatusCode != http.StatusFound {
+		t.Errorf("Random reset without parameters gave %d", r.StatusCode)
+	}
+	if r, e := client.Get(srv.URL + "/reset/" + id); e != nil || r.StatusCode != http.StatusFound {
+		t.Fatalf("Reset to %q gave %v, %v", id, r, e)
+	}
+	if session.puzzleID != id || !reflect.DeepEqual(session.values, generated) {
+		t.Errorf("Reset to %q gave puzzle %q, %v", id, session.puzzleID, session.values)
+	}
+	if !generatedPuzzleID(id) {
+		t.Errorf("Generated puzzle ID %q isn't known to be generated", id)
+	}
+
+	// generating a puzzle by its ID is metered, but starting the
+	// board's puzzle over isn't
+	saved := quotaLimits[quotaGenerate]
+	quotaLimits[quotaGenerate] = 0
+	if r, e := client.Get(srv.URL + "/reset/" + id); e != nil || r.StatusCode != http.StatusFound {
+		t.Errorf("Reset to the board's puzzle %q without quota gave %v, %v", id, r, e)
+	}
+	if r, e := client.Get(srv.URL + "/reset/" + seedIDPrefix + "4:other"); e != nil ||
+		r.StatusCode != http.StatusTooManyRequests || session.puzzleID != id {
+		t.Errorf("Reset to a new seed without quota gave %v, %v, board %q", r, e, session.puzzleID)
+	}
+	quotaLimits[quotaGenerate] = saved
+
+	// a rating that can't be found leaves the board alone
+	defer func(tries int) { randomTries = tries }(randomTries)
+	randomTries = 2
+	if r := reset("?stars=10&geometry=4"); r.StatusCode != http.StatusNotFound || session.puzzleID != id {
+		t.Errorf("Random reset for an unfound rating gave %d, board %q", r.StatusCode, session.puzzleID)
+	}
+	for _, query := range []string{"?stars=many", "?geometry=0"} {
+		if r := reset(query); r.StatusCode != http.StatusBadRequest {
+			t.Errorf("Random reset with %s gave %d", query, r.StatusCode)
+		}
+	}
+}
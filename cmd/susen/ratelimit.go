@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Rate limits
+
+Every session lives in the server's memory, so a client that
+hammers the API (or makes a new session with every request) can
+slow down everyone.  API requests are therefore rate limited,
+both by session cookie and by client IP address, before they
+get anywhere near the sessions.  Requests are in one of two
+classes, each with its own limits: "moves" (assigning, taking
+back, and resetting, which change boards and notify watchers) and
+"api" (all other API requests).  Limits are in requests per
+minute, with bursts of up to a minute's worth allowed, and 0
+means no limit; they're part of the live configuration (see
+config.go), for example:
+
+	{"rateLimits": {"moves": {"session": 120, "ip": 600}}}
+
+A request over a limit gets a 429 response, whose Retry-After
+header says how many seconds until it would be allowed.  Pages,
+static assets, and WebSocket messages aren't rate limited.
+
+Behind a proxy, every request comes from the proxy's address, so
+requests from trusted proxies are limited by the client address
+they were forwarded for (see proxy.go).
+
+*/
+
+// A rateClass is a class of rate-limited requests.
+type rateClass string
+
+// The rate-limited request classes.
+const (
+	rateMoves rateClass = "moves"
+	rateAPI   rateClass = "api"
+)
+
+// A rateLimit is the per-minute limits of a class of requests.
+type rateLimit struct {
+	Session int `json:"session"`
+	IP      int `json:"ip"`
+}
+
+// maxRateBuckets is how many buckets are kept before the full
+// ones are dropped.
+const maxRateBuckets = 10000
+
+// A rateBucket holds the requests a client can make right now,
+// as of when it was last used.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+	limit  int
+}
+
+var (
+	rateLimits = map[rateClass]rateLimit{
+		rateMoves: {Session: 240, IP: 1200},
+		rateAPI:   {Session: 600, IP: 3000},
+	}
+	rateBuckets = make(map[string]*rateBucket)
+	rateMutex   sync.Mutex // guards the limits and buckets
+)
+
+// requestRateClass returns the class of a request's path, or ""
+// if it isn't rate limited.
+func requestRateClass(path string) rateClass {
+	if strings.HasPrefix(path, "/reset/") {
+		return rateMoves
+	}
+	if !strings.HasPrefix(path, "/api/") {
+		return ""
+	}
+	if strings.Contains(path, "/assign") || strings.Contains(path, "/back/") || strings.Contains(path, "/reset/") {
+		return rateMoves
+	}
+	return rateAPI
+}
+
+// takeRate takes one request from a bucket, returning how long
+// until it would be allowed if it isn't now.  It must be called
+// with the buckets locked.
+func takeRate(key string, limit int, now time.Time) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	perSecond := float64(limit) / 60
+	b := rateBuckets[key]
+	if b == nil || b.limit != limit {
+		if len(rateBuckets) >= maxRateBuckets {
+			// forget buckets that have filled up again
+			for k, old := range rateBuckets {
+				if old.tokens+now.Sub(old.last).Seconds()*float64(old.limit)/60 >= float64(old.limit) {
+					delete(rateBuckets, k)
+				}
+			}
+		}
+		b = &rateBucket{tokens: float64(limit), last: now, limit: limit}
+		rateBuckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// rateLimited refuses requests that are over their rate limits,
+// and passes the others on.
+func rateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if rest := strings.TrimPrefix(path, "/api/v"); rest != path {
+			// versioned API paths are classed like unversioned ones
+			if slash := strings.Index(rest, "/"); slash > 0 {
+				path = "/api" + rest[slash:]
+			}
+		}
+		class := requestRateClass(path)
+		if class == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip, session := clientIP(r), ""
+		if c, e := r.Cookie(sessionCookie.Name); e == nil {
+			session, _ = cookieSessionID(c.Value)
+		}
+		now := time.Now()
+		rateMutex.Lock()
+		limit := rateLimits[class]
+		wait, by := takeRate(string(class)+" ip:"+ip, limit.IP, now), "address"
+		if wait == 0 && session != "" {
+			wait, by = takeRate(string(class)+" session:"+session, limit.Session, now), "session"
+		}
+		rateMutex.Unlock()
+		if wait > 0 {
+			debugf("Rate limited %s request from %s (session %q).", class, ip, session)
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			sendError(w, http.StatusTooManyRequests, requestError(fmt.Sprintf(
+				"Too many %s requests from this %s; try again in %d seconds", class, by, secs)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkRateLimits checks a change to the rate limits.
+func checkRateLimits(limits map[rateClass]rateLimit) error {
+	rateMutex.Lock()
+	defer rateMutex.Unlock()
+	for class, limit := range limits {
+		if _, ok := rateLimits[class]; !ok || limit.Session < 0 || limit.IP < 0 {
+			return fmt.Errorf("Invalid rate limit %s: %+v", class, limit)
+		}
+	}
+	return nil
+}
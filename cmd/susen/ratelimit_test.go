@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimits(t *testing.T) {
+	saved := currentConfig().RateLimits
+	defer func() {
+		applyConfig(configUpdate{RateLimits: saved})
+		rateMutex.Lock()
+		rateBuckets = make(map[string]*rateBucket)
+		rateMutex.Unlock()
+	}()
+	if e := applyConfig(configUpdate{RateLimits: map[rateClass]rateLimit{"pages": {1, 1}}}); e == nil {
+		t.Errorf("Unknown rate class was accepted")
+	}
+	if e := applyConfig(configUpdate{RateLimits: map[rateClass]rateLimit{rateMoves: {-1, 1}}}); e == nil {
+		t.Errorf("Negative rate limit was accepted")
+	}
+	if e := applyConfig(configUpdate{RateLimits: map[rateClass]rateLimit{
+		rateMoves: {Session: 3, IP: 5}, rateAPI: {Session: 0, IP: 0},
+	}}); e != nil {
+		t.Fatalf("Can't set rate limits: %v", e)
+	}
+	var served int
+	srv := httptest.NewServer(rateLimited(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	})))
+	defer srv.Close()
+	get := func(path, cookie string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookie.Name, Value: cookie})
+		}
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("Request for %s failed: %v", path, e)
+		}
+		return r
+	}
+
+	// a session gets its burst of moves, then has to wait
+	for i := 0; i < 3; i++ {
+		get("/api/v1/assign/", "test-rate-1").Body.Close()
+	}
+	r := get("/reset/1-star", "test-rate-1")
+	var err puzzle.Error
+	json.NewDecoder(r.Body).Decode(&err)
+	r.Body.Close()
+	if r.StatusCode != http.StatusTooManyRequests || r.Header.Get("Retry-After") != "20" ||
+		!strings.Contains(err.Message, "session") || served != 3 {
+		t.Errorf("Fourth move gave %d (retry after %q), %+v, after %d served",
+			r.StatusCode, r.Header.Get("Retry-After"), err, served)
+	}
+
+	// other sessions from the same address share its limit
+	if r := get("/api/back/", "test-rate-2"); r.StatusCode != http.StatusOK {
+		t.Errorf("Other session's move gave %d", r.StatusCode)
+	}
+	get("/api/back/", "").Body.Close()
+	if r := get("/api/assign/", "test-rate-3"); r.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Move over the address limit gave %d", r.StatusCode)
+	}
+
+	// unlimited classes and unlimited paths get through
+	for _, path := range []string{"/api/squares/", "/api/v1/squares/", "/", "/solver/"} {
+		if r := get(path, "test-rate-1"); r.StatusCode != http.StatusOK {
+			t.Errorf("Request for %s gave %d", path, r.StatusCode)
+		}
+	}
+
+	// buckets refill over time
+	rateMutex.Lock()
+	if wait := takeRate("test", 60, time.Unix(0, 0)); wait != 0 {
+		t.Errorf("New bucket had to wait %v", wait)
+	}
+	rateBuckets["test"].tokens = 0
+	wait := takeRate("test", 60, time.Unix(0, 0).Add(500*time.Millisecond))
+	rateMutex.Unlock()
+	if wait != 500*time.Millisecond {
+		t.Errorf("Empty bucket waits %v", wait)
+	}
+}
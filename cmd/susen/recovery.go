@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/client"
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Session recovery
+
+Anonymous sessions live in a browser's cookie, so a player
+without an account can't take their board to another device.
+Instead, they can get a recovery code for their session, and
+redeem it on the other device, whose browser then adopts the
+session: both browsers share it from then on, and the other
+device's own session is dropped.
+
+- POST /api/recovery-code gives a new code for the session, with
+a link that redeems it, and when it expires
+
+- POST /api/recovery-code/redeem with {"code": <code>} in the
+body adopts the code's session, and responds with a summary of
+its board
+
+- GET /recover/<code> (the link) asks whether to adopt the code's
+session: it's a page whose button redeems the code as above, and
+then goes to the solver.  Following the link doesn't use the
+code, so link previewers and prefetchers can't use it up, and a
+link from someone else can't swap a player's session for theirs
+without the player saying so.
+
+Codes are random, can be used only once, and last an hour; only
+their hashes are kept in the store.  They're written in groups
+of four letters and digits, but dashes, spaces, and case don't
+matter when they're redeemed.  Signed-in players' sessions
+follow their accounts instead, so they can't get codes or
+redeem them, and a code can only be redeemed over the protocol
+(HTTP or HTTPS) its session was made over (see cookies.go).
+
+*/
+
+// recoveryKind is the storage kind for recovery codes, which are
+// keyed by the hash of the code.
+const recoveryKind = "recovery-code"
+
+// recoveryLifetime is how long a recovery code lasts.
+const recoveryLifetime = time.Hour
+
+// A recoveryRecord is the stored form of a recovery code.
+type recoveryRecord struct {
+	SessionID string    `json:"sessionID"`
+	Expires   time.Time `json:"expires"`
+}
+
+// recoveryCode is the response to a request for a recovery code.
+type recoveryCode struct {
+	Code    string    `json:"code"`
+	Link    string    `json:"link"`
+	Expires time.Time `json:"expires"`
+}
+
+// recoveryRequest is the body of a redeem request.
+type recoveryRequest struct {
+	Code string `json:"code"`
+}
+
+// newRecoveryCode returns a random recovery code, in groups of
+// four.
+func newRecoveryCode() (string, error) {
+	var b [10]byte
+	if _, e := rand.Read(b[:]); e != nil {
+		return "", e
+	}
+	plain := base32.StdEncoding.EncodeToString(b[:])
+	var groups []string
+	for i := 0; i < len(plain); i += 4 {
+		groups = append(groups, plain[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// recoveryKey returns the storage key of a recovery code, as the
+// player typed it.
+func recoveryKey(code string) string {
+	plain := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// recoveryHandler handles the recovery endpoints.
+func (session *susenSession) recoveryHandler(w http.ResponseWriter, r *http.Request) {
+	if auth.FromRequest(r) != nil {
+		sendError(w, http.StatusConflict, requestError("Signed-in players' sessions follow their accounts"))
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/recover/") {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET")
+			sendError(w, http.StatusMethodNotAllowed, requestError("Recovery links are redeemed from their page"))
+			return
+		}
+		code := strings.TrimPrefix(r.URL.Path, "/recover/")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(client.RecoveryPage(code, "/api/recovery-code/redeem", "/solver/")))
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		sendError(w, http.StatusMethodNotAllowed, requestError("Recovery codes are made and redeemed with POST"))
+		return
+	}
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/recovery-code"), "/") {
+	case "":
+		code, e := newRecoveryCode()
+		if e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't make a recovery code: "+e.Error()))
+			return
+		}
+		rec := recoveryRecord{SessionID: session.sessionID, Expires: time.Now().Add(recoveryLifetime)}
+		if e := store.Put(recoveryKind, recoveryKey(code), rec); e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't save the recovery code: "+e.Error()))
+			return
+		}
+		log.Printf("Session %v got a recovery code.", session.sessionID)
+		sendJSON(w, http.StatusOK, recoveryCode{Code: code, Link: requestOrigin(r) + "/recover/" + code, Expires: rec.Expires})
+	case "redeem":
+		var req recoveryRequest
+		if e := decodeRequest(w, r, &req); e != nil {
+			sendError(w, requestStatus(e), requestError("Invalid recovery request: "+e.Error()))
+			return
+		}
+		if adopted := session.redeemRecoveryCode(w, r, req.Code); adopted != nil {
+			adopted.lockBoard()
+			summary := adopted.summary()
+			adopted.unlockBoard()
+			sendJSON(w, http.StatusOK, summary)
+		}
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown recovery operation"))
+	}
+}
+
+// redeemRecoveryCode has the browser making the request adopt the
+// session of a recovery code, and returns that session.  If the
+// code can't be redeemed, the error has been sent, and the
+// result is nil.
+func (session *susenSession) redeemRecoveryCode(w http.ResponseWriter, r *http.Request, code string) *susenSession {
+	key := recoveryKey(code)
+	var rec recoveryRecord
+	found, e := store.Get(recoveryKind, key, &rec)
+	if e == nil && found {
+		// each code can only be used once, even by
+		// simultaneous requests
+		found, e = storage.Swap(store, recoveryKind, key, rec, nil)
+	}
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't check the recovery code: "+e.Error()))
+		return nil
+	}
+	if !found || time.Now().After(rec.Expires) {
+		sendError(w, http.StatusNotFound, fieldError("code", "Unknown, used, or expired recovery code"))
+		return nil
+	}
+	if !sessionValueFor(rec.SessionID, requestProtocol(r)) {
+		sendError(w, http.StatusConflict, fieldError("code", "The recovery code is for a session on another protocol"))
+		return nil
+	}
+	adopted, held, e := claimSession(rec.SessionID)
+	if adopted == nil {
+		refuseLeased(w, held, e)
+		return nil
+	}
+	if adopted != session {
+		sessionMutex.Lock()
+		for key, s := range sessions {
+			if s == session && key != rec.SessionID {
+				delete(sessions, key)
+			}
+		}
+		sessionMutex.Unlock()
+	}
+	http.SetCookie(w, sessionCookie.newSessionCookie(sessionCookieValue(rec.SessionID), r))
+	log.Printf("Session %v was adopted by the browser of session %v.", rec.SessionID, session.sessionID)
+	return adopted
+}
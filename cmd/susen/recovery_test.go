@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/client"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionRecovery(t *testing.T) {
+	// this test makes sessions through the real session
+	// selection, so it has to clean them up afterwards
+	existing := make(map[string]bool)
+	sessionMutex.RLock()
+	for key := range sessions {
+		existing[key] = true
+	}
+	sessionMutex.RUnlock()
+	defer func() {
+		sessionMutex.Lock()
+		for key := range sessions {
+			if !existing[key] {
+				delete(sessions, key)
+			}
+		}
+		sessionMutex.Unlock()
+	}()
+	if tmpl, e := fs.Sub(staticAssets(), "tmpl"); e == nil {
+		client.SetDefaultTemplateFS(tmpl)
+	}
+	srv := httptest.NewServer(susenHandler(accounts))
+	defer srv.Close()
+	phone, laptop, tablet := helperBrowser(t), helperBrowser(t), helperBrowser(t)
+	redeem := func(c *http.Client, code string) (int, boardSummary) {
+		body, _ := json.Marshal(recoveryRequest{code})
+		r, e := c.Post(srv.URL+"/api/recovery-code/redeem", "application/json", bytes.NewReader(body))
+		if e != nil {
+			t.Fatalf("Redeem request error: %v", e)
+		}
+		defer r.Body.Close()
+		var summary boardSummary
+		json.NewDecoder(r.Body).Decode(&summary)
+		return r.StatusCode, summary
+	}
+
+	// the phone plays, and gets a code
+	if r, e := phone.Get(srv.URL + "/reset/3-star"); e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	} else {
+		r.Body.Close()
+	}
+	var code recoveryCode
+	r, e := phone.Post(srv.URL+"/api/recovery-code", "application/json", nil)
+	if e != nil {
+		t.Fatalf("Recovery code request error: %v", e)
+	}
+	json.NewDecoder(r.Body).Decode(&code)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || len(code.Code) != 19 || !strings.HasSuffix(code.Link, "/recover/"+code.Code) {
+		t.Fatalf("Recovery code request gave %d, %+v", r.StatusCode, code)
+	}
+
+	// the laptop redeems it (however it's typed), once
+	typed := strings.ToLower(strings.Replace(code.Code, "-", " ", -1))
+	if status, summary := redeem(laptop, typed); status != http.StatusOK || summary.PuzzleID != "3-star" {
+		t.Fatalf("Redeem gave %d, %+v", status, summary)
+	}
+	if helperBrowserSession(t, laptop, srv) != helperBrowserSession(t, phone, srv) {
+		t.Errorf("Laptop didn't adopt the phone's session")
+	}
+	if status, _ := redeem(tablet, code.Code); status != http.StatusNotFound {
+		t.Errorf("Second redeem gave status %d", status)
+	}
+
+	// links only ask, so following one doesn't use the code
+	r, _ = phone.Post(srv.URL+"/api/recovery-code", "application/json", nil)
+	json.NewDecoder(r.Body).Decode(&code)
+	r.Body.Close()
+	before := helperBrowserSession(t, tablet, srv)
+	for i := 0; i < 2; i++ {
+		r, e := tablet.Get(code.Link)
+		if e != nil {
+			t.Fatalf("Recovery link request error: %v", e)
+		}
+		page, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if r.StatusCode != http.StatusOK || !strings.Contains(string(page), code.Code) ||
+			!strings.Contains(string(page), "/api/recovery-code/redeem") {
+			t.Fatalf("Recovery link gave %d: %s", r.StatusCode, page)
+		}
+	}
+	if helperBrowserSession(t, tablet, srv) != before {
+		t.Errorf("Following the link changed the tablet's session")
+	}
+	if status, _ := redeem(tablet, code.Code); status != http.StatusOK {
+		t.Errorf("Redeem after following the link gave status %d", status)
+	}
+	if helperBrowserSession(t, tablet, srv) != helperBrowserSession(t, phone, srv) {
+		t.Errorf("Tablet didn't adopt the phone's session")
+	}
+	if r, _ := tablet.Get(srv.URL + "/api/recovery-code"); r.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET of a recovery code gave status %d", r.StatusCode)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Replays
+
+Once a board's puzzle is completed, its player can publish a
+replay of the solve, which anyone with its link can watch: POST
+/api/replay publishes the board's replay, and responds with it.
+A replay has the puzzle's starting values (with its geometry
+code first, like puzzle.New takes them), the board's whole move
+history (see history.go), undone moves and all, and the names of
+the players who filled its squares (as on leaderboards), and
+clients render it step by step.  Publishing the same solve again
+gives back the replay already published.
+
+GET /replay/<id> gives a replay.  Replays are kept in the store,
+under IDs of their own that are random and have nothing to do
+with sessions, so they can be passed around without giving
+anything away, and they outlast the boards and sessions they came
+from.  A published replay never changes.
+
+*/
+
+// replayKind is the storage kind for replays, which are keyed by
+// their IDs.
+const replayKind = "replay"
+
+// A replayRecord is a published replay.
+type replayRecord struct {
+	ID        string        `json:"id"`
+	PuzzleID  string        `json:"puzzleID"`
+	Values    []int         `json:"values"` // the geometry code and starting values
+	Moves     []historyMove `json:"moves"`
+	Players   []string      `json:"players"` // in order of their first entry
+	Started   time.Time     `json:"started"`
+	Completed time.Time     `json:"completed"`
+	SolveTime float64       `json:"solveTime"` // seconds
+	Published time.Time     `json:"published"`
+}
+
+// newReplayID returns a new random replay ID.
+func newReplayID() string {
+	var b [10]byte
+	if _, e := rand.Read(b[:]); e != nil {
+		log.Fatal(e)
+	}
+	return strings.ToLower(base32.StdEncoding.EncodeToString(b[:]))
+}
+
+// publishReplay handles POST /api/replay.  It's called with the
+// board locked.
+func (session *susenSession) publishReplay(w http.ResponseWriter, r *http.Request) {
+	if strings.Trim(r.URL.Path, "/") != "api/replay" {
+		sendError(w, http.StatusNotFound, requestError("Unknown replay operation: "+r.URL.Path))
+		return
+	}
+	if session.stats.Completed == nil {
+		sendError(w, http.StatusConflict, requestError("Only completed puzzles can have their replays published"))
+		return
+	}
+	var rec replayRecord
+	if id := session.stats.Replay; id != "" {
+		if found, e := store.Get(replayKind, id, &rec); e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't read the replay: "+e.Error()))
+			return
+		} else if found {
+			sendJSON(w, http.StatusOK, rec)
+			return
+		}
+	}
+	rec = replayRecord{
+		ID:        newReplayID(),
+		PuzzleID:  session.puzzleID,
+		Values:    session.values,
+		Moves:     session.stats.history,
+		Players:   []string{},
+		Started:   session.stats.Started,
+		Completed: *session.stats.Completed,
+		SolveTime: session.stats.SolveTime,
+		Published: time.Now(),
+	}
+	if rec.Moves == nil {
+		rec.Moves = []historyMove{}
+	}
+	seen := make(map[string]bool)
+	for _, key := range session.stats.fillers {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			rec.Players = append(rec.Players, session.stats.names[key])
+		}
+	}
+	if e := store.Put(replayKind, rec.ID, rec); e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't publish the replay: "+e.Error()))
+		return
+	}
+	session.stats.Replay = rec.ID
+	log.Printf("Session %v published replay %s of puzzle %q.", session.sessionID, rec.ID, rec.PuzzleID)
+	sendJSON(w, http.StatusOK, rec)
+}
+
+// replayHandler handles GET /replay/<id>.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Replays can only be read"))
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/replay/"), "/")
+	var rec replayRecord
+	found, e := store.Get(replayKind, id, &rec)
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't read the replay: "+e.Error()))
+		return
+	}
+	if !found || id == "" {
+		sendError(w, http.StatusNotFound, requestError("No replay "+id))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400") // replays don't change
+	sendJSON(w, http.StatusOK, rec)
+}
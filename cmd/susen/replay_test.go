@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-replay")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	if status := helperUserRequest(t, srv, "", "POST", "/api/replay", nil, nil); status != http.StatusConflict {
+		t.Errorf("Replay of unsolved puzzle gave status %d", status)
+	}
+	helperSolve(t, srv, session)
+	var rec replayRecord
+	if status := helperUserRequest(t, srv, "", "POST", "/api/replay", nil, &rec); status != http.StatusOK {
+		t.Fatalf("Replay publish gave status %d", status)
+	}
+	if rec.ID == "" || rec.PuzzleID != session.puzzleID || !reflect.DeepEqual(rec.Values, session.values) ||
+		len(rec.Moves) != session.stats.Assignments || !reflect.DeepEqual(rec.Players, []string{"anonymous"}) {
+		t.Errorf("Replay is %+v", rec)
+	}
+	var again replayRecord
+	if status := helperUserRequest(t, srv, "", "POST", "/api/replay", nil, &again); status != http.StatusOK || again.ID != rec.ID {
+		t.Errorf("Second publish gave %d, replay %q", status, again.ID)
+	}
+
+	// anyone can get it, from any session
+	other := newSession("test-replay-viewer")
+	viewer := httptest.NewServer(http.HandlerFunc(other.rootHandler))
+	defer viewer.Close()
+	var got replayRecord
+	if status := helperGetJSON(t, viewer, "/replay/"+rec.ID, &got); status != http.StatusOK ||
+		!reflect.DeepEqual(got.Moves, rec.Moves) || !got.Published.Equal(rec.Published) {
+		t.Errorf("Replay read gave %d, %+v", status, got)
+	}
+	if status := helperGetJSON(t, viewer, "/replay/nosuchreplay", &got); status != http.StatusNotFound {
+		t.Errorf("Unknown replay gave status %d", status)
+	}
+}
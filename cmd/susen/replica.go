@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"os"
+)
+
+/*
+
+Read replicas
+
+Reports (leaderboards, puzzle totals, and class dashboards) can
+read a lot of records, so they can be sent to a read replica of
+the store, to keep them from slowing down play.  If
+SUSEN_STORE_REPLICA names a store (see storage.New), reports read
+from it; everything else, including the reads that go with a
+write (such as adding a solve to a leaderboard), uses the
+primary store named by SUSEN_STORE.  Replicas lag their
+primaries, so checks that must see a player's latest results
+(such as whether they can post to a puzzle's discussion) use the
+primary, too.  If a read from the replica fails, the report
+reads from the primary instead.
+
+*/
+
+// replica is the store reports read from, or nil if they use
+// the primary.
+var replica storage.Store
+
+// openReplica switches to the replica configured in the
+// environment, if any.
+func openReplica() {
+	dsn := os.Getenv("SUSEN_STORE_REPLICA")
+	if dsn == "" {
+		replica = nil
+		return
+	}
+	s, e := storage.New(dsn)
+	if e != nil {
+		log.Fatalf("Can't open store replica: %v", e)
+	}
+	replica = monitoredStore{faultyStore{s}}
+}
+
+// reportGet reads a record for a report, from the replica if
+// there is one, unless the context is done.
+func reportGet(ctx context.Context, kind, key string, v interface{}) (bool, error) {
+	if replica != nil {
+		found, e := storage.WithContext(ctx, replica).Get(kind, key, v)
+		if e == nil {
+			return found, nil
+		}
+		if ctx.Err() != nil {
+			return false, e
+		}
+		log.Printf("Can't read %s %q from the replica, using the primary: %v", kind, key, e)
+	}
+	return storage.WithContext(ctx, store).Get(kind, key, v)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplica(t *testing.T) {
+	savedStore, savedReplica := store, replica
+	defer func() { store, replica = savedStore, savedReplica }()
+	t.Setenv("SUSEN_STORE_REPLICA", "memory:")
+	openReplica()
+	if replica == nil {
+		t.Fatalf("Replica wasn't opened")
+	}
+	store = storage.NewMemory()
+	replica = storage.NewMemory()
+	session := newSession("test-replica")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	// reports read the replica, and writes go to the primary
+	replica.Put(totalsKind, defaultPuzzleID, puzzleTotals{PuzzleID: defaultPuzzleID, Completions: 7})
+	var totals puzzleTotals
+	if status := helperGetJSON(t, srv, "/api/stats/"+defaultPuzzleID, &totals); status != http.StatusOK || totals.Completions != 7 {
+		t.Errorf("Totals from the replica gave %d, %+v", status, totals)
+	}
+	addLeaderboardEntry(defaultPuzzleID, leaderboardEntry{Key: "test:a", Name: "a", SolveTime: 1e6})
+	var lb leaderboard
+	if helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb); len(lb.Entries) != 0 {
+		t.Errorf("Leaderboard read the primary: %+v", lb)
+	}
+	if found, _ := store.Get(leaderboardKind, defaultPuzzleID, &lb); !found || len(lb.Entries) != 1 {
+		t.Errorf("Leaderboard entry wasn't written to the primary: %+v", lb)
+	}
+
+	// when the replica fails, reports read the primary
+	replica = failingStore{}
+	if status := helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb); status != http.StatusOK || len(lb.Entries) != 1 {
+		t.Errorf("Leaderboard with a failing replica gave %d, %+v", status, lb)
+	}
+	if check := selfTestChecks(); check[len(check)-1].name != "store replica" || check[len(check)-1].run() == nil {
+		t.Errorf("Self-test didn't find the failing replica")
+	}
+
+	// without a replica, reports read the primary
+	t.Setenv("SUSEN_STORE_REPLICA", "")
+	openReplica()
+	if replica != nil {
+		t.Errorf("Replica is %v without a configuration", replica)
+	}
+	if helperGetJSON(t, srv, "/api/stats/"+defaultPuzzleID, &totals); totals.Completions != 0 {
+		t.Errorf("Totals without a replica are %+v", totals)
+	}
+}
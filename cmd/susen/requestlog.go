@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+/*
+
+Request logging
+
+Every request gets an ID, which is sent back in the X-Request-ID
+header and in any error responses (puzzle.Error's RequestID), so
+a player's report of a failure can be matched to the server's
+logs.  Requests that come with an X-Request-ID (as they do from
+Heroku's router) keep it.  When a request is done, it's logged
+as one line of JSON, with its ID, method, path, session, status,
+response size, and latency in milliseconds, for example:
+
+	{"time":"2026-10-14T12:00:00.123Z","requestID":"5c3b...","method":"GET",
+	 "path":"/api/v1/squares/","session":"https-1a2b3c","status":200,
+	 "bytes":4512,"millis":1.25}
+
+These lines are logged whatever the log level, on the standard
+error without the usual log prefix, so log tools can parse them.
+
+*/
+
+// A requestRecord is the log line for a request.
+type requestRecord struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"requestID"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Session   string  `json:"session,omitempty"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Millis    float64 `json:"millis"`
+}
+
+// requestLog is where request records go.
+var requestLog = log.New(os.Stderr, "", 0)
+
+// requestIDChars are the characters of request IDs that are
+// kept from incoming requests.
+var requestIDChars = regexp.MustCompile(`^[A-Za-z0-9+/=_.-]{8,200}$`)
+
+// requestRecordKey is the context key of a request's record.
+type requestRecordKey struct{}
+
+// A recordingWriter records the status and size of a response.
+// It can be hijacked, for WebSocket connections, and flushed, for
+// event streams.
+type recordingWriter struct {
+	http.ResponseWriter
+	record *requestRecord
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.record.Status == 0 {
+		w.record.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.record.Status == 0 {
+		w.record.Status = http.StatusOK
+	}
+	n, e := w.ResponseWriter.Write(b)
+	w.record.Bytes += n
+	return n, e
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.record.Status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() string {
+	var b [12]byte
+	if _, e := rand.Read(b[:]); e != nil {
+		log.Fatal(e)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// logRequests gives each request an ID, and logs it when it's
+// done.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(puzzle.RequestIDHeader)
+		if !requestIDChars.MatchString(id) {
+			id = newRequestID()
+			r.Header.Set(puzzle.RequestIDHeader, id)
+		}
+		w.Header().Set(puzzle.RequestIDHeader, id)
+		record := &requestRecord{RequestID: id, Method: r.Method, Path: r.URL.Path}
+		r = r.WithContext(context.WithValue(r.Context(), requestRecordKey{}, record))
+		next.ServeHTTP(&recordingWriter{w, record}, r)
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		record.Time = start.UTC().Format(time.RFC3339Nano)
+		record.Millis = float64(time.Since(start)) / float64(time.Millisecond)
+		line, _ := json.Marshal(record)
+		requestLog.Print(string(line))
+	})
+}
+
+// noteSession records the session handling a request, for the
+// request's log line.
+func noteSession(r *http.Request, sessionID string) {
+	if record, ok := r.Context().Value(requestRecordKey{}).(*requestRecord); ok {
+		record.Session = sessionID
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a buffer that's safe for concurrent use.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+func TestRequestLog(t *testing.T) {
+	// like TestAccountSessions, this test makes sessions through
+	// the real session selection, so it cleans them up afterwards
+	existing := make(map[string]bool)
+	sessionMutex.RLock()
+	for key := range sessions {
+		existing[key] = true
+	}
+	sessionMutex.RUnlock()
+	defer func() {
+		sessionMutex.Lock()
+		for key := range sessions {
+			if !existing[key] {
+				delete(sessions, key)
+			}
+		}
+		sessionMutex.Unlock()
+	}()
+	var logged syncBuffer
+	requestLog.SetOutput(&logged)
+	defer requestLog.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(susenHandler(accounts))
+	defer srv.Close()
+	c := helperBrowser(t)
+	r, e := c.Get(srv.URL + "/api/v1/squares/")
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	r.Body.Close()
+	id := r.Header.Get(puzzle.RequestIDHeader)
+	if id == "" {
+		t.Fatalf("Response has no request ID")
+	}
+
+	// incoming request IDs are kept, and go into error responses
+	req, _ := http.NewRequest("POST", srv.URL+"/api/account/bogus", nil)
+	req.Header.Set(puzzle.RequestIDHeader, "router-request-1234")
+	r, e = c.Do(req)
+	if e != nil {
+		t.Fatalf("Account request error: %v", e)
+	}
+	var err puzzle.Error
+	json.NewDecoder(r.Body).Decode(&err)
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound || err.RequestID != "router-request-1234" ||
+		r.Header.Get(puzzle.RequestIDHeader) != "router-request-1234" {
+		t.Errorf("Error response %d has ID %q", r.StatusCode, err.RequestID)
+	}
+
+	// WebSocket connections still work through the logging
+	helperDialWebSocket(t, srv, "/ws").conn.Close()
+
+	lines := logged.lines()
+	if len(lines) < 2 {
+		t.Fatalf("Request log is %q", lines)
+	}
+	var first, second requestRecord
+	if e := json.Unmarshal([]byte(lines[0]), &first); e != nil {
+		t.Fatalf("Request log line isn't JSON: %q", lines[0])
+	}
+	json.Unmarshal([]byte(lines[1]), &second)
+	if first.RequestID != id || first.Method != "GET" || first.Path != "/api/v1/squares/" ||
+		first.Status != http.StatusOK || first.Bytes == 0 || first.Session == "" || first.Time == "" {
+		t.Errorf("First request record is %+v", first)
+	}
+	if second.RequestID != "router-request-1234" || second.Status != http.StatusNotFound ||
+		second.Session != first.Session {
+		t.Errorf("Second request record is %+v", second)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+
+Responses for server-level (non-puzzle) requests
+
+*/
+
+// sendJSON sends the JSON encoding of a value with the given
+// status.  It's the server's equivalent of the puzzle package's
+// handler responses.
+func sendJSON(w http.ResponseWriter, status int, obj interface{}) {
+	bytes, e := json.Marshal(obj)
+	if e != nil {
+		log.Printf("Failed to encode %T response: %v", obj, e)
+		status = http.StatusInternalServerError
+		bytes = []byte(`{"scope":6,"message":"Internal logic error: JSON Encode error"}`)
+	}
+	if status >= 500 {
+		serverErrorEvents.add(time.Now())
+	}
+	hs := w.Header()
+	hs.Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// sendError sends a puzzle Error with the given status, in its
+// envelope (see envelope.go): with its code, its message in the
+// response's language (see locale.go), so clients can show it,
+// and the ID of the request (see requestlog.go).
+func sendError(w http.ResponseWriter, status int, err puzzle.Error) {
+	hs := w.Header()
+	sendJSON(w, status, err.Envelope(status, hs.Get("Content-Language"), hs.Get(puzzle.RequestIDHeader)))
+}
+
+// maxRequestBytes is the biggest body a server-level JSON request
+// can have: enough for the puzzle values some of them carry.
+const maxRequestBytes = puzzle.MaxPuzzleBytes
+
+// decodeRequest decodes the JSON body of a server-level request
+// into a value, reading no more than maxRequestBytes of it.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(v)
+}
+
+// requestStatus returns the status for an error from decoding a
+// server-level request: 413 for a body that's too big, and 400
+// otherwise.
+func requestStatus(e error) int {
+	var big *http.MaxBytesError
+	if errors.As(e, &big) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// requestError returns a request-scope Error with the given
+// message, for problems with requests that aren't about puzzles.
+func requestError(message string) puzzle.Error {
+	return puzzle.Error{
+		Scope:     puzzle.RequestScope,
+		Structure: puzzle.ScopeStructure,
+		Condition: puzzle.GeneralCondition,
+		Values:    puzzle.ErrorData{message},
+	}
+}
+
+// fieldError returns a requestError about the named field of a
+// request (a query parameter or a field of its body).
+func fieldError(field, message string) puzzle.Error {
+	err := requestError(message)
+	err.Field = field
+	return err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Rooms
+
+A room is a board shared by several sessions, so that players in
+different browsers can solve the same puzzle together.  Rooms are
+named by short codes which players pass around to each other.
+A room exists as long as it has members.
+
+A room can be created unassisted, for ranked games: then every
+member plays without possible values, hints, or validation, and
+submitted results say so.  Since nobody can be unassisted after
+getting help, an unassisted room starts its puzzle over.  Members
+who leave finish the room's puzzle unassisted, but get help again
+with the next one they start.  A room can also be created to take
+turns (see turns.go).
+
+*/
+
+// A susenRoom is a shared board and its code.
+type susenRoom struct {
+	code  string
+	board *susenBoard
+	turns *roomTurns // the room's turns, if it takes them
+}
+
+// roomInfo is the response to room requests.
+type roomInfo struct {
+	Room       string      `json:"room,omitempty"`
+	PuzzleID   string      `json:"puzzleID"`
+	Members    int         `json:"members"`
+	Unassisted bool        `json:"unassisted,omitempty"`
+	Turns      *turnStatus `json:"turns,omitempty"`
+}
+
+var (
+	rooms     = make(map[string]*susenRoom)
+	roomMutex sync.RWMutex
+)
+
+// newRoomCode makes a random code that isn't used by any
+// existing room.  Must be called with the room registry locked.
+func newRoomCode() string {
+	for {
+		var b [5]byte
+		if _, e := rand.Read(b[:]); e != nil {
+			log.Fatal(e)
+		}
+		code := base32.StdEncoding.EncodeToString(b[:])
+		if _, ok := rooms[code]; !ok {
+			return code
+		}
+	}
+}
+
+// createRoom shares the session's board in a new room, returning
+// the room, which takes turns of the given time if it isn't 0.
+// If the session is already in a room, that room is returned
+// (and its settings are unchanged).
+func (session *susenSession) createRoom(unassisted bool, turnTime time.Duration) *susenRoom {
+	session.lockBoard()
+	defer session.unlockBoard()
+	if session.room != nil {
+		return session.room
+	}
+	roomMutex.Lock()
+	room := &susenRoom{code: newRoomCode(), board: session.susenBoard}
+	rooms[room.code] = room
+	roomMutex.Unlock()
+	session.room = room
+	if unassisted {
+		session.unassisted = true
+		session.reset(session.puzzleID)
+		session.notifySquares()
+	}
+	if turnTime > 0 {
+		session.startTurns(turnTime)
+	}
+	log.Printf("Session %v created room %v (unassisted = %v, turn time = %v).",
+		session.sessionID, room.code, unassisted, turnTime)
+	return room
+}
+
+// joinRoom moves the session to the board of the room with the
+// given code, leaving the session's current board.  Returns
+// false if there is no such room.
+func (session *susenSession) joinRoom(code string) bool {
+	roomMutex.RLock()
+	room, ok := rooms[code]
+	roomMutex.RUnlock()
+	if !ok {
+		return false
+	}
+	session.boardMutex.Lock()
+	defer session.boardMutex.Unlock()
+	if session.susenBoard == room.board {
+		return true
+	}
+	session.leave()
+	room.board.mutex.Lock()
+	session.susenBoard = room.board
+	session.members = append(session.members, session)
+	log.Printf("Session %v joined room %v.", session.sessionID, code)
+	session.broadcast(sessionEvent{Type: membersEventType, Members: len(session.members)})
+	session.notifySquares()
+	if room.turns != nil {
+		room.turns.add(session)
+	}
+	room.board.mutex.Unlock()
+	return true
+}
+
+// leaveRoom gives the session a private copy of its room's
+// board, so it can keep going on its own from where the room
+// is.  The room is removed when its last member leaves.  It's
+// not an error to leave when the session isn't in a room.
+func (session *susenSession) leaveRoom() {
+	session.boardMutex.Lock()
+	defer session.boardMutex.Unlock()
+	session.leave()
+}
+
+// leave is leaveRoom for a session that's moving boards (see
+// lockBoard).
+func (session *susenSession) leave() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	room := session.room
+	if room == nil {
+		return
+	}
+	for i, member := range session.members {
+		if member == session {
+			session.members = append(session.members[:i], session.members[i+1:]...)
+			break
+		}
+	}
+	if room.turns != nil {
+		room.turns.remove(session, turnLeave)
+	}
+	if len(session.members) == 0 {
+		roomMutex.Lock()
+		delete(rooms, room.code)
+		roomMutex.Unlock()
+		log.Printf("Room %v removed.", room.code)
+	} else {
+		session.broadcast(sessionEvent{Type: membersEventType, Members: len(session.members)})
+		if session.blitz != nil && session.blitz.player == session {
+			session.blitz.player = session.members[0]
+		}
+	}
+	board := &susenBoard{
+		puzzleID:   session.puzzleID,
+		contest:    session.contest,
+		unassisted: session.unassisted,
+		relaxed:    session.relaxed,
+		values:     session.values,
+		steps:      make([]puzzle.Puzzle, len(session.steps)),
+		stats:      session.stats,
+		lastHint:   session.lastHint,
+		members:    []*susenSession{session},
+	}
+	for i, step := range session.steps {
+		board.steps[i] = step.Copy()
+	}
+	if session.blitz != nil {
+		board.blitz = session.blitz.continueOn(board, session)
+	}
+	session.susenBoard = board
+	log.Printf("Session %v left room %v.", session.sessionID, room.code)
+}
+
+// roomInfo describes the session's room (if any).
+func (session *susenSession) roomInfo() roomInfo {
+	session.lockBoard()
+	defer session.unlockBoard()
+	info := roomInfo{
+		PuzzleID:   session.puzzleID,
+		Members:    len(session.members),
+		Unassisted: session.unassisted,
+	}
+	if session.room != nil {
+		info.Room = session.room.code
+	}
+	if t := session.turns(); t != nil {
+		info.Turns = t.status(session, "")
+	}
+	return info
+}
+
+// roomHandler handles the room endpoints:
+//
+// - POST /api/room/create/ shares the session's board in a new
+// room, which is unassisted if the query has mode=unassisted, and
+// takes turns if it has turns=<seconds per turn>
+//
+// - POST /api/room/join/<code> moves the session into a room
+//
+// - POST /api/room/leave/ leaves the session's room
+//
+// - GET /api/room/ describes the session's room
+//
+// All of them respond with the session's room information.  The
+// room's turns have their own endpoints (see turnHandler).
+func (session *susenSession) roomHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/room/")
+	if strings.HasPrefix(path, "turn") {
+		session.turnHandler(w, r, strings.Trim(path[len("turn"):], "/"))
+		return
+	}
+	if r.Method == "POST" {
+		switch {
+		case strings.HasPrefix(path, "create"):
+			turnTime, ok := parseTurnTime(r.URL.Query().Get("turns"))
+			if !ok {
+				sendError(w, http.StatusBadRequest, requestError(
+					"Turns have to be between "+minTurnTime.String()+" and "+maxTurnTime.String()))
+				return
+			}
+			session.createRoom(r.URL.Query().Get("mode") == "unassisted", turnTime)
+		case strings.HasPrefix(path, "join/"):
+			code := strings.ToUpper(strings.Trim(path[len("join/"):], "/"))
+			if !session.joinRoom(code) {
+				sendError(w, http.StatusNotFound, requestError("No room with code "+code))
+				return
+			}
+		case strings.HasPrefix(path, "leave"):
+			session.leaveRoom()
+		default:
+			sendError(w, http.StatusNotFound, requestError("Unknown room operation: "+path))
+			return
+		}
+	}
+	sendJSON(w, http.StatusOK, session.roomInfo())
+}
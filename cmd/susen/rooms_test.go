@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func helperRoomRequest(t *testing.T, srv *httptest.Server, op string) (int, roomInfo) {
+	r, e := http.Post(srv.URL+"/api/room/"+op, "application/json", nil)
+	if e != nil {
+		t.Fatalf("Room %q request error: %v", op, e)
+	}
+	defer r.Body.Close()
+	var info roomInfo
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(&info); e != nil {
+			t.Fatalf("Room %q decode error: %v", op, e)
+		}
+	}
+	return r.StatusCode, info
+}
+
+func helperRoomAssign(t *testing.T, srv *httptest.Server, choice puzzle.Choice) int {
+	body, _ := json.Marshal(choice)
+	r, e := http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(body))
+	if e != nil {
+		t.Fatalf("Assign request error: %v", e)
+	}
+	r.Body.Close()
+	return r.StatusCode
+}
+
+func TestRooms(t *testing.T) {
+	host, guest := newSession("test-room-host"), newSession("test-room-guest")
+	hsrv := httptest.NewServer(http.HandlerFunc(host.rootHandler))
+	defer hsrv.Close()
+	gsrv := httptest.NewServer(http.HandlerFunc(guest.rootHandler))
+	defer gsrv.Close()
+
+	if status, _ := helperRoomRequest(t, gsrv, "join/NOSUCHRM"); status != http.StatusNotFound {
+		t.Errorf("Join of unknown room gave status %d", status)
+	}
+	status, info := helperRoomRequest(t, hsrv, "create/")
+	if status != http.StatusOK || info.Room == "" || info.Members != 1 {
+		t.Fatalf("Create gave %d, %+v", status, info)
+	}
+	if _, again := helperRoomRequest(t, hsrv, "create/"); again.Room != info.Room {
+		t.Errorf("Second create made room %q, expected %q", again.Room, info.Room)
+	}
+	status, joined := helperRoomRequest(t, gsrv, "join/"+info.Room)
+	if status != http.StatusOK || joined.Room != info.Room || joined.Members != 2 {
+		t.Fatalf("Join gave %d, %+v", status, joined)
+	}
+	if host.susenBoard != guest.susenBoard {
+		t.Fatalf("Host and guest don't share a board after join")
+	}
+
+	// both players go for the same square: the first one wins
+	index := 0
+	for i, s := range host.steps[0].Squares() {
+		if s.Aval == 0 {
+			index = i + 1
+			break
+		}
+	}
+	bind := host.steps[0].Squares()[index-1].Pvals
+	if status := helperRoomAssign(t, hsrv, puzzle.Choice{Index: index, Value: bind[0]}); status != http.StatusOK {
+		t.Fatalf("Host assign gave status %d", status)
+	}
+	if status := helperRoomAssign(t, gsrv, puzzle.Choice{Index: index, Value: bind[0]}); status == http.StatusOK {
+		t.Errorf("Guest assign to the same square succeeded")
+	}
+	if len(guest.steps) != 2 {
+		t.Errorf("Guest sees %d steps, expected 2", len(guest.steps))
+	}
+
+	status, left := helperRoomRequest(t, gsrv, "leave/")
+	if status != http.StatusOK || left.Room != "" || left.Members != 1 {
+		t.Errorf("Leave gave %d, %+v", status, left)
+	}
+	if host.susenBoard == guest.susenBoard || len(guest.steps) != 2 {
+		t.Errorf("Guest didn't keep a private copy of the board")
+	}
+	if _, info := helperRoomRequest(t, hsrv, "leave/"); info.Room != "" {
+		t.Errorf("Host still in room %q after leaving", info.Room)
+	}
+	roomMutex.RLock()
+	_, ok := rooms[info.Room]
+	roomMutex.RUnlock()
+	if ok {
+		t.Errorf("Room %q still registered after everyone left", info.Room)
+	}
+}
+
+func TestUnassistedRoom(t *testing.T) {
+	host, guest := newSession("test-unassisted-host"), newSession("test-unassisted-guest")
+	hsrv := httptest.NewServer(http.HandlerFunc(host.rootHandler))
+	defer hsrv.Close()
+	gsrv := httptest.NewServer(http.HandlerFunc(guest.rootHandler))
+	defer gsrv.Close()
+
+	status, info := helperRoomRequest(t, hsrv, "create/?mode=unassisted")
+	if status != http.StatusOK || !info.Unassisted {
+		t.Fatalf("Create unassisted gave %d, %+v", status, info)
+	}
+	if _, joined := helperRoomRequest(t, gsrv, "join/"+info.Room); !joined.Unassisted {
+		t.Errorf("Guest joined an assisted room: %+v", joined)
+	}
+	r, e := http.Get(gsrv.URL + "/api/")
+	if e != nil {
+		t.Fatalf("Squares request error: %v", e)
+	}
+	var squares []puzzle.Square
+	e = json.NewDecoder(r.Body).Decode(&squares)
+	r.Body.Close()
+	if e != nil {
+		t.Fatalf("Squares decode error: %v", e)
+	}
+	for _, s := range squares {
+		if s.Pvals != nil || s.Bval != 0 {
+			t.Fatalf("Unassisted squares revealed data: %+v", s)
+		}
+	}
+
+	// a reset without a mode doesn't turn assistance back on
+	r, e = http.Get(gsrv.URL + "/reset/2-star")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if !host.unassisted || host.steps[0].Solutions() != nil {
+		t.Errorf("Reset of unassisted room gave an assisted puzzle")
+	}
+
+	r, e = http.Post(hsrv.URL+"/api/submit/", "application/json", nil)
+	if e != nil {
+		t.Fatalf("Submit request error: %v", e)
+	}
+	var v puzzle.Verification
+	e = json.NewDecoder(r.Body).Decode(&v)
+	r.Body.Close()
+	if e != nil || v.Valid || !v.Unassisted || v.Errors != nil {
+		t.Errorf("Unassisted submission gave %+v, %v", v, e)
+	}
+	helperRoomRequest(t, gsrv, "leave/")
+	helperRoomRequest(t, hsrv, "leave/")
+	if !guest.unassisted {
+		t.Errorf("Guest got assistance back by leaving the room")
+	}
+
+	// but starting another puzzle does
+	r, e = http.Get(gsrv.URL + "/reset/3-star?mode=contest")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if guest.unassisted || !guest.contest {
+		t.Errorf("Guest's next puzzle has unassisted %v, contest %v", guest.unassisted, guest.contest)
+	}
+	helperGetJSON(t, gsrv, "/api/reset/", &squares)
+	if guest.unassisted || !guest.contest {
+		t.Errorf("Guest's API reset has unassisted %v, contest %v", guest.unassisted, guest.contest)
+	}
+}
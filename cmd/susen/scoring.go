@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+/*
+
+Scoring
+
+With the "scoring" feature on (it's off by default; see
+config.go), each board starts its puzzle with a score, and each
+help the player takes costs points: each hint (and each hint an
+explanation or the solutions use up), each square the server
+autofills, and each undo.  The score never goes below zero.  It's
+part of the board's statistics (see stats.go), and of the solve's
+leaderboard entry when the puzzle is completed, so leaderboards
+can be ranked by score as well as by time (see leaderboard.go).
+
+The rules are part of the live configuration, as "scoring":
+
+	{"scoring": {"start": 1000, "hint": 50, "autofill": 10, "undo": 5}}
+
+Changes to the rules only apply to later charges, so a board's
+score is what the rules were as it was played.  Boards started
+with scoring off have no score.
+
+*/
+
+// A scoreRule is one of the scoring rules: the starting score,
+// or the cost of one kind of help.
+type scoreRule string
+
+// The scoring rules.
+const (
+	scoreStart    scoreRule = "start"
+	scoreHint     scoreRule = "hint"
+	scoreAutofill scoreRule = "autofill"
+	scoreUndo     scoreRule = "undo"
+)
+
+// scoreRules are the points for each rule, which are guarded by
+// configMutex.
+var scoreRules = map[scoreRule]int{scoreStart: 1000, scoreHint: 50, scoreAutofill: 10, scoreUndo: 5}
+
+// checkScoreRules checks a change to the scoring rules.
+func checkScoreRules(rules map[scoreRule]int) error {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	for rule, points := range rules {
+		if _, ok := scoreRules[rule]; !ok || points < 0 {
+			return fmt.Errorf("Invalid scoring rule %s: %d", rule, points)
+		}
+	}
+	return nil
+}
+
+// scorePoints returns the points for a scoring rule.
+func scorePoints(rule scoreRule) int {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return scoreRules[rule]
+}
+
+// startScore gives the board its starting score, if scoring is
+// on.
+func (board *susenBoard) startScore() {
+	if featureEnabled("scoring") {
+		score := scorePoints(scoreStart)
+		board.stats.Score = &score
+	}
+}
+
+// chargeScore takes the cost of some help (of which there are n)
+// from the board's score, if it has one.
+func (board *susenBoard) chargeScore(rule scoreRule, n int) {
+	if board.stats.Score == nil || n <= 0 {
+		return
+	}
+	score := *board.stats.Score - n*scorePoints(rule)
+	if score < 0 {
+		score = 0
+	}
+	// a new value, since copies of the statistics share the old one
+	board.stats.Score = &score
+	debugf("Charged puzzle %q %d %s(s), leaving a score of %d.", board.puzzleID, n, rule, score)
+}
+
+// scoreRanksBefore tells whether one entry ranks ahead of another
+// by score: scored entries come first, highest score first, and
+// then by the usual ranking.
+func (e leaderboardEntry) scoreRanksBefore(other leaderboardEntry) bool {
+	if (e.Score == nil) != (other.Score == nil) {
+		return other.Score == nil
+	}
+	if e.Score != nil && *e.Score != *other.Score {
+		return *e.Score > *other.Score
+	}
+	return e.ranksBefore(other)
+}
+
+// rankByScore re-ranks a leaderboard's entries by score.
+func rankByScore(lb leaderboard) {
+	sort.SliceStable(lb.Entries, func(i, j int) bool {
+		return lb.Entries[i].scoreRanksBefore(lb.Entries[j])
+	})
+}
+
+// logScore logs the score of a completed board, if it has one.
+func (board *susenBoard) logScore() {
+	if board.stats.Score != nil {
+		log.Printf("Puzzle %q scored %d.", board.puzzleID, *board.stats.Score)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScoring(t *testing.T) {
+	saved, savedConfig := store, currentConfig()
+	store = storage.NewMemory()
+	defer func() {
+		store = saved
+		applyConfig(configUpdate{Features: savedConfig.Features, Scoring: savedConfig.Scoring})
+	}()
+	session := newSession("test-scoring")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	// boards started with scoring off have no score
+	if session.stats.Score != nil {
+		t.Fatalf("Unscored board has score %d", *session.stats.Score)
+	}
+	if e := applyConfig(configUpdate{Features: map[string]bool{"scoring": true},
+		Scoring: map[scoreRule]int{scoreStart: 1000, scoreAutofill: 3, scoreUndo: 7}}); e != nil {
+		t.Fatalf("Scoring config failed: %v", e)
+	}
+	helperUserRequest(t, srv, "", "GET", "/api/reset/", nil, nil)
+	if session.stats.Score == nil || *session.stats.Score != 1000 {
+		t.Fatalf("Scored board starts with %v", session.stats.Score)
+	}
+
+	// autofills and undos cost points, and completion enters the score
+	var af autofillResponse
+	helperUserRequest(t, srv, "", "POST", "/api/autofill?steps=each", nil, &af)
+	helperUserRequest(t, srv, "", "GET", "/api/back/", nil, nil)
+	if *session.stats.Score != 1000-3*len(af.Assignments)-7 {
+		t.Errorf("Score after autofill and undo is %d", *session.stats.Score)
+	}
+	helperUserRequest(t, srv, "", "GET", "/api/reset/", nil, nil)
+	helperUserRequest(t, srv, "", "POST", "/api/autofill", nil, nil)
+	want := 1000 - 3*len(af.Assignments)
+	if session.stats.Completed == nil || *session.stats.Score != want {
+		t.Fatalf("Score after help is %d, want %d", *session.stats.Score, want)
+	}
+	var lb leaderboard
+	helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb)
+	if len(lb.Entries) != 1 || lb.Entries[0].Score == nil || *lb.Entries[0].Score != want {
+		t.Fatalf("Leaderboard after scored solve is %+v, want a score of %d", lb, want)
+	}
+
+	// the score never goes below zero
+	session.chargeScore(scoreUndo, 1000)
+	if *session.stats.Score != 0 {
+		t.Errorf("Overcharged score is %d", *session.stats.Score)
+	}
+
+	// leaderboards rank by score, with unscored solves last
+	low, high := 10, 2000
+	for _, entry := range []leaderboardEntry{
+		{Key: "test:fast", Name: "fast", SolveTime: 1, Score: &low},
+		{Key: "test:slow", Name: "slow", SolveTime: 1e6, Score: &high},
+		{Key: "test:unscored", Name: "unscored", SolveTime: 2},
+	} {
+		addLeaderboardEntry(defaultPuzzleID, entry)
+	}
+	helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID+"?rank=score", &lb)
+	var names []string
+	for _, entry := range lb.Entries {
+		names = append(names, entry.Name)
+	}
+	if len(names) != 4 || names[0] != "slow" || names[1] != "anonymous" || names[2] != "fast" || names[3] != "unscored" {
+		t.Errorf("Leaderboard by score is %v", names)
+	}
+	helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb)
+	if lb.Entries[0].Name != "fast" {
+		t.Errorf("Leaderboard by time starts with %q", lb.Entries[0].Name)
+	}
+	if status := helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID+"?rank=style", &lb); status != http.StatusBadRequest {
+		t.Errorf("Leaderboard with unknown ranking gave status %d", status)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/client"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Startup self-test
+
+Before it starts listening, the server checks that its parts
+work: that it can solve each catalog puzzle, that each storage
+backend can keep a record, and that it can render pages and
+images.  The
+checks that players can't do without are critical, and the
+server won't start if any of them fail.  If only non-critical
+checks fail, the server starts in degraded mode.  Either way,
+the report is logged as JSON, and admins can get it from
+/api/admin/selftest/.
+
+*/
+
+// A selfTestResult is the outcome of one self-test check.
+type selfTestResult struct {
+	Name     string  `json:"name"`
+	Critical bool    `json:"critical"`
+	OK       bool    `json:"ok"`
+	Error    string  `json:"error,omitempty"`
+	Millis   float64 `json:"millis"`
+}
+
+// A selfTestReport is the outcome of the whole self-test.
+// Failed means a critical check failed; Degraded means a
+// non-critical one did.
+type selfTestReport struct {
+	Started  time.Time        `json:"started"`
+	Results  []selfTestResult `json:"results"`
+	Failed   bool             `json:"failed"`
+	Degraded bool             `json:"degraded"`
+}
+
+var (
+	lastSelfTest  selfTestReport
+	selfTestMutex sync.RWMutex
+)
+
+// A selfTestCheck is a check to run, which returns an error if
+// the check fails.
+type selfTestCheck struct {
+	name     string
+	critical bool
+	run      func() error
+}
+
+// selfTestChecks returns the checks to run: solving every
+// catalog puzzle, round-tripping a record through the memory
+// backend and the configured store, reading the replica (if
+// any), and rendering a page and an image.
+func selfTestChecks() []selfTestCheck {
+	var checks []selfTestCheck
+	for _, id := range catalogIDs() {
+		id := id
+		checks = append(checks, selfTestCheck{"solve " + id, true, func() error {
+			vals, _ := lookupPuzzle(id)
+			return selfTestSolve(vals)
+		}})
+	}
+	checks = append(checks,
+		selfTestCheck{"store memory", true, func() error { return selfTestStore(storage.NewMemory()) }},
+		selfTestCheck{"store configured", true, func() error { return selfTestStore(store) }},
+		selfTestCheck{"render solver page", false, selfTestRender},
+		selfTestCheck{"render image", false, selfTestImage},
+	)
+	if r := replica; r != nil {
+		// replicas are read-only, so all that can be checked is
+		// that they can be read
+		checks = append(checks, selfTestCheck{"store replica", false, func() error {
+			_, e := r.Keys(leaderboardKind)
+			return e
+		}})
+	}
+	return checks
+}
+
+// runSelfTest runs the self-test checks, logs the report, and
+// keeps it for the admin endpoint.
+func runSelfTest() selfTestReport {
+	report := selfTestReport{Started: time.Now(), Results: []selfTestResult{}}
+	for _, check := range selfTestChecks() {
+		start := time.Now()
+		e := selfTestRun(check.run)
+		result := selfTestResult{
+			Name:     check.name,
+			Critical: check.critical,
+			OK:       e == nil,
+			Millis:   float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if e != nil {
+			result.Error = e.Error()
+			if check.critical {
+				report.Failed = true
+			} else {
+				report.Degraded = true
+			}
+		}
+		report.Results = append(report.Results, result)
+	}
+	if bytes, e := json.Marshal(report); e == nil {
+		log.Printf("Self-test report: %s", bytes)
+	}
+	selfTestMutex.Lock()
+	lastSelfTest = report
+	selfTestMutex.Unlock()
+	return report
+}
+
+// selfTestRun runs a check, turning a panic into a failure.
+func selfTestRun(run func() error) (e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run()
+}
+
+// selfTestSolve checks that a puzzle can be solved, and that its
+// solution verifies.
+func selfTestSolve(vals []int) error {
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return e
+	}
+	solutions := p.Solutions()
+	if len(solutions) == 0 {
+		return fmt.Errorf("no solution found")
+	}
+	v, e := puzzle.Verify(vals, append([]int{vals[0]}, solutions[0].Values...))
+	if e != nil {
+		return e
+	}
+	if !v.Valid {
+		return fmt.Errorf("solution doesn't verify: %v", v.Errors)
+	}
+	return nil
+}
+
+// selfTestStore checks that a store can write, read, and delete
+// a record.
+func selfTestStore(s storage.Store) error {
+	const kind, key = "selftest", "probe"
+	put := selfTestReport{Started: time.Now().UTC().Round(time.Millisecond)}
+	if e := s.Put(kind, key, put); e != nil {
+		return e
+	}
+	var got selfTestReport
+	if ok, e := s.Get(kind, key, &got); e != nil {
+		return e
+	} else if !ok || !got.Started.Equal(put.Started) {
+		return fmt.Errorf("read back %v, wrote %v", got.Started, put.Started)
+	}
+	if e := s.Delete(kind, key); e != nil {
+		return e
+	}
+	if ok, e := s.Get(kind, key, &got); e != nil || ok {
+		return fmt.Errorf("record still there after delete (%v)", e)
+	}
+	return nil
+}
+
+// selfTestRender checks that the solver page renders.
+func selfTestRender() error {
+	vals, _ := lookupPuzzle(defaultPuzzleID)
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return e
+	}
+	page := client.SolverPage("selftest", defaultPuzzleID, p.State())
+	if !strings.Contains(page, `sessionID="selftest"`) {
+		return fmt.Errorf("solver page didn't render")
+	}
+	return nil
+}
+
+// selfTestImage checks that a puzzle image renders.
+func selfTestImage() error {
+	vals, _ := lookupPuzzle(defaultPuzzleID)
+	p, e := puzzle.New(vals)
+	if e != nil {
+		return e
+	}
+	var b strings.Builder
+	if e := puzzle.RenderSVG(&b, p, puzzle.SVGOptions{Givens: vals, Possibles: true}); e != nil {
+		return e
+	}
+	if !strings.HasSuffix(b.String(), "</svg>\n") {
+		return fmt.Errorf("image didn't render")
+	}
+	return nil
+}
+
+// selfTestHandler gives the last self-test report, and is only
+// routed to for admins.  POST runs the self-test again.
+func selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		sendJSON(w, http.StatusOK, runSelfTest())
+		return
+	}
+	selfTestMutex.RLock()
+	report := lastSelfTest
+	selfTestMutex.RUnlock()
+	sendJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// failingStore is a store whose every operation fails.
+type failingStore struct{}
+
+func (failingStore) Get(kind, key string, v interface{}) (bool, error) {
+	return false, fmt.Errorf("store is down")
+}
+func (failingStore) Put(kind, key string, v interface{}) error { return fmt.Errorf("store is down") }
+func (failingStore) Delete(kind, key string) error             { return fmt.Errorf("store is down") }
+func (failingStore) Keys(kind string) ([]string, error)        { return nil, fmt.Errorf("store is down") }
+
+func TestSelfTest(t *testing.T) {
+	report := runSelfTest()
+	if report.Failed {
+		t.Errorf("Self-test failed: %+v", report)
+	}
+	solved, rendered := 0, false
+	for _, result := range report.Results {
+		if strings.HasPrefix(result.Name, "solve ") && result.OK {
+			solved++
+		}
+		rendered = rendered || (result.Name == "render image" && result.OK)
+	}
+	if !rendered {
+		t.Errorf("Self-test didn't render an image: %+v", report)
+	}
+	if solved != len(catalogIDs()) {
+		t.Errorf("Self-test solved %d of %d puzzles: %+v", solved, len(catalogIDs()), report)
+	}
+
+	saved := store
+	store = failingStore{}
+	report = runSelfTest()
+	store = saved
+	if !report.Failed {
+		t.Errorf("Self-test with failing store didn't fail: %+v", report)
+	}
+}
+
+func TestSelfTestEndpoint(t *testing.T) {
+	srv := helperUserServer(newSession("test-selftest"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+
+	var report selfTestReport
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/selftest/", nil, &report); status != http.StatusOK || len(report.Results) == 0 {
+		t.Fatalf("Self-test run gave %d, %+v", status, report)
+	}
+	var last selfTestReport
+	helperUserRequest(t, srv, "root", "GET", "/api/admin/selftest/", nil, &last)
+	if !last.Started.Equal(report.Started) {
+		t.Errorf("Last self-test started %v, expected %v", last.Started, report.Started)
+	}
+	if status := helperUserRequest(t, srv, "sam", "GET", "/api/admin/selftest/", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Non-admin self-test request gave status %d", status)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Server configuration
+
+The server's own settings can't change while it runs: where it
+listens, whether it uses TLS, its timeouts, and where its static
+assets are.  Each setting can come from a configuration file,
+from the environment, or from a command-line flag, and later
+ones of those override earlier ones:
+
+	setting         flag              environment            default
+	address         -address          SUSEN_ADDRESS          localhost (all interfaces if PORT is set)
+	port            -port             PORT                   8080
+	listen          -listen           SUSEN_LISTEN           (none: just the address and port)
+	grpcPort        -grpc-port        SUSEN_GRPC_PORT        0 (no gRPC service)
+	tlsCert         -tls-cert         SUSEN_TLS_CERT         (none)
+	tlsKey          -tls-key          SUSEN_TLS_KEY          (none)
+	readTimeout     -read-timeout     SUSEN_READ_TIMEOUT     0 (none)
+	writeTimeout    -write-timeout    SUSEN_WRITE_TIMEOUT    0 (none)
+	drainTime       -drain-time       SUSEN_DRAIN_TIME       0 (none)
+	staticDir       -static-dir       SUSEN_STATIC_DIR       (none: the embedded assets)
+	corsOrigins     -cors-origins     SUSEN_CORS_ORIGINS     (none)
+	seed            -seed             SUSEN_SEED             (none: unpredictable seeds)
+	sessionLease    -session-lease    SUSEN_SESSION_LEASE    0 (none: the only server)
+	trustedProxies  -trusted-proxies  SUSEN_TRUSTED_PROXIES  loopback (any on Heroku; see proxy.go)
+
+and the cookie settings in cookies.go.
+
+The configuration file is named by the -config flag (or
+SUSEN_SERVER_CONFIG).  It's JSON if its name ends in .json, and
+otherwise YAML, of which only "setting: value" lines (and
+comments) are understood, for example:
+
+	# serve TLS on all interfaces
+	address: ""
+	port: 8443
+	tlsCert: /etc/susen/cert.pem
+	tlsKey: /etc/susen/key.pem
+
+Timeouts are durations such as "30s", or numbers of seconds.
+The drain time, which is given the same way, is how long the
+server keeps serving after it's told to shut down, while it
+tells readiness probes it isn't ready (see health.go).
+The server serves TLS if it has both a certificate and a key
+file, and doesn't start if it only has one of them, or if any
+other setting is invalid.  The gRPC service (see grpc.go), if
+there's a port for it, listens on the same address, and uses TLS
+if the server does.  The listen setting gives more places to
+listen, each with its own TLS settings (see listeners.go).
+The seed setting makes the seeds of puzzles generated without
+one (such as blitz puzzles) predictable, so servers with the
+same seed make the same puzzles in the same order; it's for
+tests and staging, since players could predict them too.
+Deployments with more than one server (such as several Heroku
+dynos) set the session lease, a duration, so that each session is
+served by one of them at a time (see leases.go).
+(The settings that can change while
+the server runs are in config.go.)
+
+*/
+
+// A serverConfig is the server's own configuration.
+type serverConfig struct {
+	Address      string
+	Port         int
+	Listen       []listenerConfig
+	GRPCPort     int
+	TLSCert      string
+	TLSKey       string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	DrainTime    time.Duration
+	StaticDir    string
+	CORSOrigins  []string
+	Seed         string
+	SessionLease time.Duration
+	Proxies      proxyTrust
+	Cookies      cookiePolicy
+}
+
+// A serverSetting is one of the settings in a serverConfig, with
+// its names in configuration files, the environment, and flags.
+type serverSetting struct {
+	name, env, flag, usage string
+	set                    func(c *serverConfig, value string) error
+}
+
+var serverSettings = []serverSetting{
+	{"address", "SUSEN_ADDRESS", "address", "address to listen on (empty for all interfaces)",
+		func(c *serverConfig, v string) error { c.Address = v; return nil }},
+	{"port", "PORT", "port", "port to listen on",
+		func(c *serverConfig, v string) (e error) { c.Port, e = parsePort(v, false); return }},
+	{"listen", "SUSEN_LISTEN", "listen", "comma-separated more addresses or unix: sockets to listen on (see listeners.go)",
+		func(c *serverConfig, v string) (e error) { c.Listen, e = parseListeners(v); return }},
+	{"grpcPort", "SUSEN_GRPC_PORT", "grpc-port", "port to serve gRPC on (0 for none)",
+		func(c *serverConfig, v string) (e error) { c.GRPCPort, e = parsePort(v, true); return }},
+	{"tlsCert", "SUSEN_TLS_CERT", "tls-cert", "TLS certificate file",
+		func(c *serverConfig, v string) error { c.TLSCert = v; return nil }},
+	{"tlsKey", "SUSEN_TLS_KEY", "tls-key", "TLS key file",
+		func(c *serverConfig, v string) error { c.TLSKey = v; return nil }},
+	{"readTimeout", "SUSEN_READ_TIMEOUT", "read-timeout", "timeout for reading requests (0 for none)",
+		func(c *serverConfig, v string) (e error) { c.ReadTimeout, e = parseTimeout(v); return }},
+	{"writeTimeout", "SUSEN_WRITE_TIMEOUT", "write-timeout", "timeout for writing responses (0 for none)",
+		func(c *serverConfig, v string) (e error) { c.WriteTimeout, e = parseTimeout(v); return }},
+	{"drainTime", "SUSEN_DRAIN_TIME", "drain-time", "time to serve while not ready before shutting down",
+		func(c *serverConfig, v string) (e error) { c.DrainTime, e = parseTimeout(v); return }},
+	{"staticDir", "SUSEN_STATIC_DIR", "static-dir", "directory of static assets to serve instead of the embedded ones",
+		func(c *serverConfig, v string) error { c.StaticDir = v; return nil }},
+	{"corsOrigins", "SUSEN_CORS_ORIGINS", "cors-origins", "comma-separated origins allowed to use the API (see cors.go)",
+		func(c *serverConfig, v string) error {
+			c.CORSOrigins = parseOrigins(v)
+			for _, o := range c.CORSOrigins {
+				if u, e := url.Parse(o); e != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+					return fmt.Errorf("invalid origin %q", o)
+				}
+			}
+			return nil
+		}},
+	{"seed", "SUSEN_SEED", "seed", "text of the stream that seeds puzzles generated without a seed (see generateHandler)",
+		func(c *serverConfig, v string) error { c.Seed = v; return nil }},
+	{"sessionLease", "SUSEN_SESSION_LEASE", "session-lease", "how long a server's lease on a session lasts (0 for the only server, see leases.go)",
+		func(c *serverConfig, v string) (e error) { c.SessionLease, e = parseTimeout(v); return }},
+	{"trustedProxies", "SUSEN_TRUSTED_PROXIES", "trusted-proxies", "comma-separated addresses and CIDR blocks of trusted proxies, any, or none (see proxy.go)",
+		func(c *serverConfig, v string) (e error) { c.Proxies, e = parseProxyTrust(v); return }},
+	{"cookieName", "SUSEN_COOKIE_NAME", "cookie-name", "name of the session cookie",
+		func(c *serverConfig, v string) error { return c.Cookies.setName(v) }},
+	{"cookieSecure", "SUSEN_COOKIE_SECURE", "cookie-secure", "whether cookies are secure (true, false, or auto)",
+		func(c *serverConfig, v string) error { return c.Cookies.setSecure(v) }},
+	{"cookieHttpOnly", "SUSEN_COOKIE_HTTP_ONLY", "cookie-http-only", "whether the session cookie is HttpOnly",
+		func(c *serverConfig, v string) error { return c.Cookies.setHTTPOnly(v) }},
+	{"cookieSameSite", "SUSEN_COOKIE_SAME_SITE", "cookie-same-site", "SameSite of cookies (lax, strict, or none)",
+		func(c *serverConfig, v string) error { return c.Cookies.setSameSite(v) }},
+	{"cookieMaxAge", "SUSEN_COOKIE_MAX_AGE", "cookie-max-age", "lifetime of the session cookie (0 for the browser session)",
+		func(c *serverConfig, v string) (e error) { c.Cookies.MaxAge, e = parseTimeout(v); return }},
+	{"cookieLegacy", "SUSEN_COOKIE_LEGACY", "cookie-legacy", "whether legacy session cookies are still read (see cookies.go)",
+		func(c *serverConfig, v string) error { return c.Cookies.setLegacy(v) }},
+}
+
+// parsePort parses a port number, which can be 0 if none is
+// allowed.
+func parsePort(v string, none bool) (int, error) {
+	n, e := strconv.Atoi(v)
+	if e != nil || n < 0 || n > 65535 || (n == 0 && !none) {
+		return 0, fmt.Errorf("invalid port %q", v)
+	}
+	return n, nil
+}
+
+// parseTimeout parses a timeout, given as a duration or a number
+// of seconds.
+func parseTimeout(v string) (time.Duration, error) {
+	d, e := time.ParseDuration(v)
+	if e != nil {
+		n, ne := strconv.Atoi(v)
+		if ne != nil {
+			return 0, fmt.Errorf("invalid timeout %q", v)
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", v)
+	}
+	return d, nil
+}
+
+// loadServerConfig works out the server configuration from the
+// command-line arguments, the environment (looked up with
+// getenv), and any configuration file, and checks it.
+func loadServerConfig(args []string, getenv func(string) string) (serverConfig, error) {
+	c := serverConfig{Address: "localhost", Port: 8080, Proxies: defaultProxyTrust(getenv), Cookies: defaultCookiePolicy()}
+	if getenv("PORT") != "" {
+		c.Address = ""
+	}
+	flags := flag.NewFlagSet("susen", flag.ContinueOnError)
+	configFile := flags.String("config", getenv("SUSEN_SERVER_CONFIG"), "server configuration file (JSON or YAML)")
+	values := make([]*string, len(serverSettings))
+	for i, s := range serverSettings {
+		values[i] = flags.String(s.flag, "", s.usage)
+	}
+	if e := flags.Parse(args); e != nil {
+		return c, e
+	}
+	if flags.NArg() > 0 {
+		return c, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if *configFile != "" {
+		file, e := readServerConfigFile(*configFile)
+		if e != nil {
+			return c, e
+		}
+		for _, s := range serverSettings {
+			if v, ok := file[s.name]; ok {
+				if e := s.set(&c, v); e != nil {
+					return c, fmt.Errorf("%s: %s: %v", *configFile, s.name, e)
+				}
+				delete(file, s.name)
+			}
+		}
+		for name := range file {
+			return c, fmt.Errorf("%s: unknown setting %q", *configFile, name)
+		}
+	}
+	for _, s := range serverSettings {
+		if v := getenv(s.env); v != "" {
+			if e := s.set(&c, v); e != nil {
+				return c, fmt.Errorf("%s: %v", s.env, e)
+			}
+		}
+	}
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for i, s := range serverSettings {
+		if set[s.flag] {
+			if e := s.set(&c, *values[i]); e != nil {
+				return c, fmt.Errorf("-%s: %v", s.flag, e)
+			}
+		}
+	}
+	return c, c.check()
+}
+
+// readServerConfigFile returns the settings in a configuration
+// file, by name.
+func readServerConfigFile(name string) (map[string]string, error) {
+	text, e := ioutil.ReadFile(name)
+	if e != nil {
+		return nil, e
+	}
+	settings := make(map[string]string)
+	if strings.HasSuffix(strings.ToLower(name), ".json") {
+		var values map[string]interface{}
+		if e := json.Unmarshal(text, &values); e != nil {
+			return nil, fmt.Errorf("%s: %v", name, e)
+		}
+		for k, v := range values {
+			settings[k] = fmt.Sprint(v)
+		}
+		return settings, nil
+	}
+	for i, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("%s: line %d isn't a setting: %q", name, i+1, line)
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		if unquoted, e := strconv.Unquote(value); e == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		} else if hash := strings.Index(value, " #"); hash >= 0 {
+			value = strings.TrimSpace(value[:hash])
+		}
+		settings[strings.TrimSpace(line[:colon])] = value
+	}
+	return settings, nil
+}
+
+// check checks that a configuration can be used.
+func (c serverConfig) check() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key file")
+	}
+	for _, file := range []string{c.TLSCert, c.TLSKey} {
+		if file == "" {
+			continue
+		}
+		if _, e := os.Stat(file); e != nil {
+			return fmt.Errorf("can't use TLS file: %v", e)
+		}
+	}
+	if c.GRPCPort == c.Port {
+		return fmt.Errorf("gRPC needs a port of its own")
+	}
+	if e := c.checkListeners(); e != nil {
+		return e
+	}
+	if info, e := os.Stat(c.StaticDir); c.StaticDir != "" && (e != nil || !info.IsDir()) {
+		return fmt.Errorf("static asset directory %q isn't a directory", c.StaticDir)
+	}
+	if c.SessionLease > 0 && c.SessionLease < minSessionLease {
+		return fmt.Errorf("the session lease can't be less than %v", minSessionLease)
+	}
+	return c.Cookies.check()
+}
+
+// tls tells whether the configuration is for TLS.
+func (c serverConfig) tls() bool {
+	return c.TLSCert != ""
+}
+
+// server returns the HTTP server for a configuration.
+func (c serverConfig) server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         net.JoinHostPort(c.Address, strconv.Itoa(c.Port)),
+		Handler:      handler,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+	}
+}
+
+// grpcServer returns the gRPC server for a configuration, or nil
+// if it has no gRPC port.  It only speaks HTTP/2, which is
+// unencrypted unless the configuration is for TLS.
+func (c serverConfig) grpcServer(handler http.Handler) *http.Server {
+	if c.GRPCPort == 0 {
+		return nil
+	}
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(!c.tls())
+	return &http.Server{
+		Addr:      net.JoinHostPort(c.Address, strconv.Itoa(c.GRPCPort)),
+		Handler:   handler,
+		Protocols: &protocols,
+	}
+}
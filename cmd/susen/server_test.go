@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if e := ioutil.WriteFile(path, []byte(text), 0644); e != nil {
+			t.Fatalf("Can't write %s: %v", name, e)
+		}
+		return path
+	}
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+	flags := []string{"-static-dir", dir}
+
+	c, e := loadServerConfig(flags, env(nil))
+	if e != nil || c.server(nil).Addr != "localhost:8080" || c.tls() || c.ReadTimeout != 0 {
+		t.Errorf("Default config is %+v, %v", c, e)
+	}
+	c, e = loadServerConfig(flags, env(map[string]string{"PORT": "5000"}))
+	if e != nil || c.server(nil).Addr != ":5000" {
+		t.Errorf("Config with PORT is %+v, %v", c, e)
+	}
+
+	// the file is overridden by the environment, which is
+	// overridden by flags
+	cert, key := write("cert.pem", "cert"), write("key.pem", "key")
+	yaml := write("susen.yaml", "# a comment\n---\naddress: \"\"\nport: 8443  # TLS\n"+
+		"tlsCert: "+cert+"\ntlsKey: '"+key+"'\nreadTimeout: 30\nwriteTimeout: 1m\n")
+	c, e = loadServerConfig(append(flags, "-config", yaml, "-read-timeout", "5s"),
+		env(map[string]string{"SUSEN_READ_TIMEOUT": "10", "SUSEN_ADDRESS": "127.0.0.1"}))
+	if e != nil || c.server(nil).Addr != "127.0.0.1:8443" || !c.tls() || c.TLSKey != key ||
+		c.ReadTimeout != 5*time.Second || c.WriteTimeout != time.Minute {
+		t.Errorf("Config from YAML is %+v, %v", c, e)
+	}
+	json := write("susen.json", `{"port": 9000, "writeTimeout": "2s", "drainTime": 5}`)
+	c, e = loadServerConfig(flags, env(map[string]string{"SUSEN_SERVER_CONFIG": json}))
+	if e != nil || c.Port != 9000 || c.WriteTimeout != 2*time.Second || c.DrainTime != 5*time.Second {
+		t.Errorf("Config from JSON is %+v, %v", c, e)
+	}
+	if c.grpcServer(nil) != nil {
+		t.Errorf("Config without a gRPC port has a gRPC server")
+	}
+	c, e = loadServerConfig(flags, env(map[string]string{"SUSEN_SEED": "staging", "SUSEN_SESSION_LEASE": "1m"}))
+	if e != nil || c.Seed != "staging" || c.SessionLease != time.Minute {
+		t.Errorf("Config with a seed and session lease is %+v, %v", c, e)
+	}
+	c, e = loadServerConfig(append(flags, "-grpc-port", "9090"), env(nil))
+	if e != nil || c.grpcServer(nil) == nil || c.grpcServer(nil).Addr != "localhost:9090" ||
+		!c.grpcServer(nil).Protocols.UnencryptedHTTP2() {
+		t.Errorf("Config with a gRPC port is %+v, %v", c, e)
+	}
+
+	c, e = loadServerConfig(append(flags, "-cookie-name", "play", "-cookie-max-age", "0"),
+		env(map[string]string{"SUSEN_COOKIE_SECURE": "AUTO", "SUSEN_COOKIE_SAME_SITE": "none"}))
+	if e != nil || c.Cookies.Name != "play" || c.Cookies.Secure != "auto" ||
+		c.Cookies.SameSite != http.SameSiteNoneMode || c.Cookies.MaxAge != 0 {
+		t.Errorf("Config with cookie settings is %+v, %v", c, e)
+	}
+	if c, e = loadServerConfig(flags, env(nil)); e != nil || c.Cookies != defaultCookiePolicy() {
+		t.Errorf("Default cookie policy is %+v, %v", c.Cookies, e)
+	}
+
+	for _, args := range [][]string{
+		{"-port", "70000"},
+		{"-port", "0"},
+		{"-grpc-port", "8080"},
+		{"-read-timeout", "soon"},
+		{"-session-lease", "1s"},
+		{"-tls-cert", cert},
+		{"-tls-cert", cert, "-tls-key", filepath.Join(dir, "missing.pem")},
+		{"-static-dir", filepath.Join(dir, "missing")},
+		{"-cors-origins", "play.example.com"},
+		{"-cookie-name", "my cookie"},
+		{"-cookie-secure", "sometimes"},
+		{"-cookie-http-only", "maybe"},
+		{"-cookie-same-site", "loose"},
+		{"-cookie-same-site", "none", "-cookie-secure", "false"},
+		{"-cookie-max-age", "-1h"},
+		{"-config", write("bad.yaml", "colour: blue\n")},
+		{"-config", write("worse.yml", "just words\n")},
+		{"-config", write("bad.json", `{"port": "x"}`)},
+		{"extra"},
+	} {
+		if _, e := loadServerConfig(append(append([]string{}, flags...), args...), env(nil)); e == nil {
+			t.Errorf("Config with %v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Sharing
+
+GET /api/share/ gives the position encoding of the board's
+current values (see puzzle.FromEncoding), and a URL that starts
+a board on exactly that position: resetting to a position
+encoding starts its values as a new puzzle, under the puzzle ID
+"shared:<fingerprint>".  Shared positions are for showing and
+trying, not racing, so their completions aren't entered on
+leaderboards or in totals.  The response also has the board's
+record: its puzzle and the moves made on it, in the compact text
+form of puzzle.Record, for clients that keep or pass along whole
+games rather than positions.
+
+Once the board's puzzle is completed, GET /api/share-text gives a
+spoiler-free summary of the solve for pasting into chats: a line
+with the puzzle, its difficulty, and the solve time, and then
+the grid as emoji squares, one for each square: black for
+givens, green for squares that were right the first time, and
+yellow for squares that had to be corrected.  (Share images are
+in images.go.)  Rating the puzzle is metered as analysis.
+
+*/
+
+// sharedIDPrefix starts the puzzle IDs of shared positions.
+const sharedIDPrefix = "shared:"
+
+// A shareInfo is the response to share requests.
+type shareInfo struct {
+	Encoding string        `json:"encoding"`
+	URL      string        `json:"url"`
+	Record   puzzle.Record `json:"record"`
+}
+
+// sharedPuzzleID tells whether a puzzle ID is a shared
+// position's.
+func sharedPuzzleID(puzzleID string) bool {
+	return strings.HasPrefix(puzzleID, sharedIDPrefix)
+}
+
+// startShared starts the session's board on the position with
+// the given encoding.  It tells whether the encoding was valid.
+func (session *susenSession) startShared(encoding string) (bool, error) {
+	vals, e := puzzle.DecodeValues(encoding)
+	if e != nil {
+		return false, nil
+	}
+	if _, e := puzzle.New(vals); e != nil {
+		return false, nil
+	}
+	return true, session.start(sharedIDPrefix+puzzle.Fingerprint(vals), vals)
+}
+
+// shareHandler handles GET /api/share/, which gives the board's
+// position encoding and the URL that opens it (in the board's
+// mode).
+func (session *susenSession) shareHandler(w http.ResponseWriter, r *http.Request) {
+	encoding := session.steps[len(session.steps)-1].Encoding()
+	url := requestOrigin(r) + "/reset/" + encoding
+	if session.contest {
+		url += "?mode=contest"
+	} else if session.relaxed {
+		url += "?mode=relaxed"
+	}
+	record := puzzle.Record{Values: session.values, Moves: []puzzle.Choice{}}
+	for i := 1; i < len(session.steps); i++ {
+		record.Moves = append(record.Moves, stepMoves(session.steps[i-1], session.steps[i])...)
+	}
+	sendJSON(w, http.StatusOK, shareInfo{Encoding: encoding, URL: url, Record: record})
+}
+
+// Share text emoji squares.
+const (
+	shareGiven     = "⬛"
+	shareFirstTry  = "🟩"
+	shareCorrected = "🟨"
+)
+
+// shareTitle returns the name of the board's puzzle in share
+// images and text.
+func (session *susenSession) shareTitle() string {
+	if date, ok := parseDailyID(session.puzzleID); ok {
+		return "Susen daily " + date.Format(dailyDateForm)
+	}
+	if sharedPuzzleID(session.puzzleID) {
+		return "Susen shared position"
+	}
+	return "Susen " + session.puzzleID
+}
+
+// shareStars returns the star rating of the board's puzzle, or 0
+// if it can't be rated.
+func (session *susenSession) shareStars() int {
+	p, _ := puzzle.New(session.values) // the board was started with them
+	if rating, e := puzzle.Rate(p); e == nil {
+		return rating.Stars
+	}
+	return 0
+}
+
+// solveTime returns the board's solve time.
+func (board *susenBoard) solveTime() time.Duration {
+	return time.Duration(board.stats.SolveTime * float64(time.Second))
+}
+
+// shareText returns the share text of the board's completed
+// puzzle.
+func (session *susenSession) shareText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\n", session.shareTitle(), strings.Repeat("★", session.shareStars()),
+		session.solveTime().Round(time.Second))
+	sidelen := session.steps[0].State().SideLenth
+	for i, v := range session.values[1:] {
+		switch {
+		case v != 0:
+			b.WriteString(shareGiven)
+		case i < len(session.stats.tries) && session.stats.tries[i] > 1:
+			b.WriteString(shareCorrected)
+		default:
+			b.WriteString(shareFirstTry)
+		}
+		if (i+1)%sidelen == 0 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// shareTextHandler handles GET /api/share-text.
+func (session *susenSession) shareTextHandler(w http.ResponseWriter, r *http.Request) {
+	if session.stats.Completed == nil || session.stats.Expired {
+		sendError(w, http.StatusConflict, requestError("Complete the puzzle to share it"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(session.shareText()))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShare(t *testing.T) {
+	from, to := newSession("test-share-from"), newSession("test-share-to")
+	fsrv := httptest.NewServer(http.HandlerFunc(from.rootHandler))
+	defer fsrv.Close()
+	tsrv := httptest.NewServer(http.HandlerFunc(to.rootHandler))
+	defer tsrv.Close()
+
+	p, _ := puzzle.New(from.values)
+	solution := p.Solutions()[0].Values
+	for i, s := range p.Squares() {
+		if s.Aval == 0 {
+			helperRoomAssign(t, fsrv, puzzle.Choice{Index: i + 1, Value: solution[i]})
+			break
+		}
+	}
+	var info shareInfo
+	if status := helperGetJSON(t, fsrv, "/api/share/", &info); status != http.StatusOK {
+		t.Fatalf("Share gave status %d", status)
+	}
+	if !strings.HasPrefix(info.URL, fsrv.URL+"/reset/") || !strings.HasSuffix(info.URL, info.Encoding) {
+		t.Errorf("Share gave %+v", info)
+	}
+	if played, e := info.Record.Puzzle(puzzle.New); e != nil ||
+		!reflect.DeepEqual(played.State(), from.steps[len(from.steps)-1].State()) {
+		t.Errorf("Shared record %+v doesn't give the board (%v)", info.Record, e)
+	}
+
+	// opening the shared URL on another server gives the same position
+	r, e := http.Get(tsrv.URL + strings.TrimPrefix(info.URL, fsrv.URL))
+	if e != nil {
+		t.Fatalf("Shared reset error: %v", e)
+	}
+	r.Body.Close()
+	want := from.steps[len(from.steps)-1].State().Values
+	check := func(when string) {
+		if got := to.steps[len(to.steps)-1].State().Values; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: shared board has %v, not %v", when, got, want)
+		}
+		if !sharedPuzzleID(to.puzzleID) {
+			t.Errorf("%s: shared board has puzzle ID %q", when, to.puzzleID)
+		}
+	}
+	check("opened")
+	to.mutex.Lock()
+	to.reset(to.puzzleID)
+	to.mutex.Unlock()
+	check("started over")
+
+	// contest boards share contest URLs
+	from.contest = true
+	if helperGetJSON(t, fsrv, "/api/share/", &info); !strings.HasSuffix(info.URL, "?mode=contest") {
+		t.Errorf("Contest share URL is %q", info.URL)
+	}
+	from.contest = false
+}
+
+func TestShareText(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-share-text")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	get := func() (int, string) {
+		r, e := http.Get(srv.URL + "/api/share-text")
+		if e != nil {
+			t.Fatalf("Share text request error: %v", e)
+		}
+		defer r.Body.Close()
+		b, _ := ioutil.ReadAll(r.Body)
+		return r.StatusCode, string(b)
+	}
+	if status, _ := get(); status != http.StatusConflict {
+		t.Errorf("Share text of an unfinished puzzle gave status %d", status)
+	}
+
+	// get the first empty square wrong, then take it back
+	p, _ := puzzle.New(session.values)
+	solution := p.Solutions()[0].Values
+	givens, wrong := 0, -1
+	for i, v := range session.values[1:] {
+		if v != 0 {
+			givens++
+		} else if wrong < 0 {
+			wrong = i
+		}
+	}
+	helperRoomAssign(t, srv, puzzle.Choice{Index: wrong + 1, Value: solution[wrong]%9 + 1})
+	r, e := http.Get(srv.URL + "/api/back/")
+	if e != nil {
+		t.Fatalf("Undo request error: %v", e)
+	}
+	r.Body.Close()
+	helperSolve(t, srv, session)
+
+	status, text := get()
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if status != http.StatusOK || len(lines) != 10 || !strings.HasPrefix(lines[0], "Susen "+session.puzzleID+" ★") {
+		t.Fatalf("Share text gave status %d, %q", status, text)
+	}
+	grid := strings.Join(lines[1:], "")
+	if strings.Count(grid, shareGiven) != givens || strings.Count(grid, shareCorrected) != 1 ||
+		strings.Count(grid, shareFirstTry) != 80-givens {
+		t.Errorf("Share text grid is wrong:\n%s", text)
+	}
+	if []rune(grid)[wrong] != []rune(shareCorrected)[0] {
+		t.Errorf("Corrected square %d isn't marked:\n%s", wrong+1, text)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+
+Graceful shutdown
+
+On SIGTERM or SIGINT (Heroku sends SIGTERM on every deploy), the
+server reports that it isn't ready (see health.go), keeps
+serving for its drain time (see server.go), then stops accepting
+connections, lets the requests in flight finish, and then checkpoints every session to the store, so
+that players pick up where they were when the next server
+starts.  (That needs a persistent store: see SUSEN_STORE in
+accounts.go.)  At startup, the server restores the checkpointed
+sessions and removes their checkpoints, first bringing those
+written by older releases up to date (see versions.go).  Servers
+that lease their sessions (see leases.go) release their leases
+after checkpointing, and restore sessions as they take them.
+
+A checkpoint has a session's puzzle, the moves that were made on
+it, its statistics and history, its user, and the same for each
+of its puzzle slots (see slots.go), so restored boards can still
+be taken back move by move.  Some things don't outlast the
+server: pencil marks, rooms (whose members each get
+a private copy of the room's board), watchers, and boards in a
+running blitz attempt, since the clock doesn't stop.
+
+*/
+
+// shutdownTimeout is how long requests in flight get to finish.
+// It leaves time for checkpointing within the 30 seconds Heroku
+// allows between SIGTERM and SIGKILL (if there's no drain time).
+const shutdownTimeout = 20 * time.Second
+
+// checkpointKind is the storage kind for session checkpoints,
+// which are keyed by session ID.
+const checkpointKind = "session-checkpoint"
+
+// A sessionCheckpoint is the saved state of a session, under each
+// of its keys in the session table.
+type sessionCheckpoint struct {
+	Keys        []string                     `json:"keys"`
+	PuzzleID    string                       `json:"puzzleID"`
+	Contest     bool                         `json:"contest,omitempty"`
+	Unassisted  bool                         `json:"unassisted,omitempty"`
+	Relaxed     bool                         `json:"relaxed,omitempty"`
+	Imported    bool                         `json:"imported,omitempty"`    // see backup.go
+	Certifiable bool                         `json:"certifiable,omitempty"` // see archive.go
+	Version     int                          `json:"version,omitempty"`     // see versions.go
+	Values      []int                        `json:"values"`
+	Moves       []puzzle.Choice              `json:"moves"`
+	Sizes       []int                        `json:"sizes,omitempty"`   // moves in each step
+	Guesses     []int                        `json:"guesses,omitempty"` // see guesses.go
+	Symbols     []string                     `json:"symbols,omitempty"` // see symbols.go
+	Stats       puzzleStats                  `json:"stats"`
+	Tries       []int                        `json:"tries"`
+	Fillers     []string                     `json:"fillers,omitempty"` // see archive.go
+	Sources     []string                     `json:"sources,omitempty"` // see sources.go
+	History     []historyMove                `json:"history,omitempty"` // see history.go
+	Names       map[string]string            `json:"names,omitempty"`
+	Actions     []int                        `json:"actions,omitempty"`
+	User        *auth.User                   `json:"user,omitempty"`
+	Active      time.Time                    `json:"active"`             // zero in checkpoints from before expiry.go
+	Slots       map[string]sessionCheckpoint `json:"slots,omitempty"`    // by name (see slots.go)
+	Prefs       *sessionPrefs                `json:"prefs,omitempty"`    // see prefs.go
+	Attempts    []archivedAttempt            `json:"attempts,omitempty"` // see attempts.go
+}
+
+// checkpoint returns the session's checkpoint, and false if the
+// session can't be checkpointed.
+func (session *susenSession) checkpoint() (sessionCheckpoint, bool) {
+	session.lockBoard()
+	c, ok := session.boardCheckpoint()
+	session.unlockBoard()
+	if !ok {
+		return sessionCheckpoint{}, false
+	}
+	session.infoMutex.Lock()
+	c.Actions, c.User, c.Active, c.Prefs = session.actions, session.user, session.active, session.prefs
+	c.Attempts = session.attempts
+	slots := make(map[string]*susenSession)
+	for name, slot := range session.slots {
+		slots[name] = slot
+	}
+	session.infoMutex.Unlock()
+	for name, slot := range slots {
+		if sc, ok := slot.checkpoint(); ok {
+			if c.Slots == nil {
+				c.Slots = make(map[string]sessionCheckpoint)
+			}
+			c.Slots[name] = sc
+		}
+	}
+	return c, true
+}
+
+// boardCheckpoint returns the checkpoint of the board alone,
+// without anything of its sessions', and false if the board can't
+// be checkpointed.  It must be called with the board locked.
+func (board *susenBoard) boardCheckpoint() (sessionCheckpoint, bool) {
+	if board.blitz != nil && !board.blitz.over {
+		return sessionCheckpoint{}, false
+	}
+	c := sessionCheckpoint{
+		PuzzleID:    board.puzzleID,
+		Contest:     board.contest,
+		Unassisted:  board.unassisted,
+		Relaxed:     board.relaxed,
+		Imported:    board.imported,
+		Certifiable: board.certifiable,
+		Values:      board.values,
+		Moves:       []puzzle.Choice{},
+		Stats:       board.stats,
+		Tries:       board.stats.tries,
+		Fillers:     board.stats.fillers,
+		Sources:     board.stats.sources,
+		History:     board.stats.history,
+		Names:       board.stats.names,
+		Guesses:     board.guesses,
+	}
+	if board.symbols != nil {
+		c.Symbols = board.symbols.Symbols()
+	}
+	for i := 1; i < len(board.steps); i++ {
+		moves := stepMoves(board.steps[i-1], board.steps[i])
+		if len(moves) == 0 {
+			return sessionCheckpoint{}, false
+		}
+		c.Moves = append(c.Moves, moves...)
+		c.Sizes = append(c.Sizes, len(moves))
+	}
+	return c, true
+}
+
+// stepMoves returns the assignments that took one step to the
+// next: just one, unless the step was a batch assignment.
+func stepMoves(from, to puzzle.Puzzle) []puzzle.Choice {
+	var moves []puzzle.Choice
+	before, after := from.State().Values, to.State().Values
+	for i, v := range after {
+		if v != before[i] {
+			moves = append(moves, puzzle.Choice{Index: i + 1, Value: v})
+		}
+	}
+	return moves
+}
+
+// restore makes the session a checkpoint was taken of.
+func (c sessionCheckpoint) restore(sessionID string) (*susenSession, error) {
+	session := &susenSession{sessionID: sessionID, user: c.User, actions: c.Actions, active: c.Active, prefs: c.Prefs,
+		attempts: c.Attempts}
+	if session.active.IsZero() {
+		session.active = time.Now()
+	}
+	newPuzzle := puzzle.New
+	if c.Contest || c.Unassisted {
+		newPuzzle = puzzle.NewContest
+	} else if c.Relaxed {
+		newPuzzle = puzzle.NewRelaxed
+	}
+	p, e := newPuzzle(c.Values)
+	if e != nil {
+		return nil, e
+	}
+	board := &susenBoard{
+		puzzleID:    c.PuzzleID,
+		contest:     c.Contest,
+		unassisted:  c.Unassisted,
+		relaxed:     c.Relaxed,
+		imported:    c.Imported,
+		certifiable: c.Certifiable,
+		values:      c.Values,
+		steps:       []puzzle.Puzzle{p},
+		stats:       c.Stats,
+		members:     []*susenSession{session},
+	}
+	board.stats.tries = c.Tries
+	board.stats.fillers, board.stats.names = c.Fillers, c.Names
+	board.stats.sources, board.stats.history = c.Sources, c.History
+	if len(board.stats.sources) != len(c.Values)-1 {
+		board.stats.sources = make([]string, len(c.Values)-1) // from before sources.go
+	}
+	replayStart, moves := time.Now(), c.Moves
+	for _, size := range c.Sizes {
+		if size < 1 || size > len(moves) {
+			return nil, fmt.Errorf("Checkpoint steps don't match its moves")
+		}
+		next := board.steps[len(board.steps)-1].Copy()
+		if _, e := puzzle.AssignAll(next, moves[:size]); e != nil {
+			return nil, e
+		}
+		board.steps, moves = append(board.steps, next), moves[size:]
+	}
+	if len(moves) > 0 {
+		return nil, fmt.Errorf("Checkpoint steps don't match its moves")
+	}
+	noteReplay(len(c.Moves), time.Since(replayStart)) // see footprint.go
+	board.guesses = c.Guesses
+	if len(c.Symbols) > 0 {
+		if board.symbols, e = puzzle.NewSymbolTable(c.Symbols); e != nil {
+			return nil, e
+		}
+	}
+	board.closeGuesses()
+	session.susenBoard = board
+	for name, sc := range c.Slots {
+		slot, e := sc.restore(sessionID + "/" + name)
+		if e != nil {
+			return nil, e
+		}
+		slot.owner, slot.slotName = session, name
+		if session.slots == nil {
+			session.slots = make(map[string]*susenSession)
+		}
+		session.slots[name] = slot
+	}
+	return session, nil
+}
+
+// checkpointSessions saves every session to the store, returning
+// the number saved.
+func checkpointSessions() int {
+	keys := make(map[*susenSession][]string)
+	sessionMutex.RLock()
+	for key, session := range sessions {
+		keys[session] = append(keys[session], key)
+	}
+	sessionMutex.RUnlock()
+	saved := 0
+	for session, sessionKeys := range keys {
+		ok, e := saveCheckpoint(session, sessionKeys)
+		if e != nil {
+			log.Printf("Failed to checkpoint session %v: %v", session.sessionID, e)
+			continue
+		}
+		if !ok {
+			log.Printf("Not checkpointing session %v.", session.sessionID)
+			continue
+		}
+		saved++
+	}
+	return saved
+}
+
+// saveCheckpoint saves a session to the store under its keys in
+// the session table, returning false if it can't be
+// checkpointed.
+func saveCheckpoint(session *susenSession, keys []string) (bool, error) {
+	c, ok := session.checkpoint()
+	if !ok {
+		return false, nil
+	}
+	c.Keys, c.Version = keys, checkpointVersion
+	return true, store.Put(checkpointKind, session.sessionID, c)
+}
+
+// restoreSessions restores the checkpointed sessions, and removes
+// their checkpoints.  It returns the number restored.
+func restoreSessions() int {
+	ids, e := store.Keys(checkpointKind)
+	if e != nil {
+		log.Printf("Can't list session checkpoints: %v", e)
+		return 0
+	}
+	restored := 0
+	for _, id := range ids {
+		c, _, found, e := loadCheckpoint(id)
+		if e != nil || !found {
+			log.Printf("Can't read checkpoint of session %v: %v", id, e)
+			continue
+		}
+		if session, e := c.restore(id); e != nil {
+			log.Printf("Can't restore session %v: %v", id, e)
+		} else {
+			sessionMutex.Lock()
+			for _, key := range c.Keys {
+				sessions[key] = session
+			}
+			sessionMutex.Unlock()
+			restored++
+		}
+		if e := store.Delete(checkpointKind, id); e != nil {
+			log.Printf("Can't remove checkpoint of session %v: %v", id, e)
+		}
+	}
+	return restored
+}
+
+// shutdownOnSignal shuts the server down gracefully when it gets
+// SIGTERM or SIGINT, after serving for the drain time while not
+// ready.  The returned channel is closed once the
+// sessions have been checkpointed, and the server can exit.
+func shutdownOnSignal(srv *http.Server, drain time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down.", sig)
+		atomic.StoreInt32(&draining, 1)
+		if drain > 0 {
+			log.Printf("Draining for %v.", drain)
+			time.Sleep(drain)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if e := srv.Shutdown(ctx); e != nil {
+			log.Printf("Requests didn't finish: %v", e)
+		}
+		cancel()
+		log.Printf("Checkpointed %d sessions.", checkpointSessions())
+		releaseLeases() // see leases.go
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCheckpointSessions(t *testing.T) {
+	saved := store
+	store = monitoredStore{storage.NewMemory()}
+	defer func() { store = saved }()
+	sessionMutex.Lock()
+	savedSessions := sessions
+	sessions = make(map[string]*susenSession)
+	sessionMutex.Unlock()
+	defer func() {
+		sessionMutex.Lock()
+		sessions = savedSessions
+		sessionMutex.Unlock()
+	}()
+
+	// a user's session, under two keys, with some moves; a contest
+	// session; and a session in a blitz attempt
+	player, contest, blitz := newSession("test-checkpoint"), newSession("test-checkpoint-contest"), newSession("test-checkpoint-blitz")
+	player.setUser(&auth.User{ID: "sam", Name: "Sam", Source: "header"})
+	srv := httptest.NewServer(http.HandlerFunc(player.rootHandler))
+	defer srv.Close()
+	p, _ := puzzle.New(puzzleValues[defaultPuzzleID])
+	solution := p.Solutions()[0].Values
+	moves := 0
+	for i, v := range puzzleValues[defaultPuzzleID][1:] {
+		if v == 0 && moves < 3 {
+			helperRoomAssign(t, srv, puzzle.Choice{Index: i + 1, Value: solution[i]})
+			moves++
+		}
+	}
+	player.recordAction(resetAction)
+	contest.contest = true
+	contest.reset("2-star")
+	blitz.startBlitz()
+	defer blitz.stopBlitz()
+	sessionMutex.Lock()
+	sessions[player.sessionID], sessions["user:header:sam"] = player, player
+	sessions[contest.sessionID], sessions[blitz.sessionID] = contest, blitz
+	sessionMutex.Unlock()
+
+	if n := checkpointSessions(); n != 2 {
+		t.Errorf("Checkpointed %d sessions", n)
+	}
+	sessionMutex.Lock()
+	sessions = make(map[string]*susenSession)
+	sessionMutex.Unlock()
+	if n := restoreSessions(); n != 2 {
+		t.Fatalf("Restored %d sessions", n)
+	}
+	if ids, _ := store.Keys(checkpointKind); len(ids) != 0 {
+		t.Errorf("Checkpoints left after restoring: %v", ids)
+	}
+
+	sessionMutex.RLock()
+	restored, byUser, restoredContest := sessions[player.sessionID], sessions["user:header:sam"], sessions[contest.sessionID]
+	_, blitzRestored := sessions[blitz.sessionID]
+	sessionMutex.RUnlock()
+	if restored == nil || restored != byUser || blitzRestored {
+		t.Fatalf("Restored sessions are %v", sessions)
+	}
+	if len(restored.steps) != moves+1 || restored.puzzleID != defaultPuzzleID ||
+		!reflect.DeepEqual(restored.steps[moves].State().Values, player.steps[moves].State().Values) {
+		t.Errorf("Restored board is %+v", restored.susenBoard)
+	}
+	if restored.stats.Assignments != moves || restored.stats.tries == nil ||
+		restored.user.Key() != "header:sam" || restored.actions[resetAction] != 1 {
+		t.Errorf("Restored session has stats %+v, user %v, actions %v", restored.stats, restored.user, restored.actions)
+	}
+	restored.undoStep()
+	if len(restored.steps) != moves {
+		t.Errorf("Restored board has %d steps after undo", len(restored.steps))
+	}
+
+	// batch assignments are restored as single steps
+	batch := player.steps[moves].Copy()
+	var choices []puzzle.Choice
+	for i, v := range player.steps[moves].State().Values {
+		if v == 0 && len(choices) < 2 {
+			choices = append(choices, puzzle.Choice{Index: i + 1, Value: solution[i]})
+		}
+	}
+	puzzle.AssignAll(batch, choices)
+	player.addStep(batch)
+	c, _ := player.checkpoint()
+	if len(c.Moves) != moves+2 || !reflect.DeepEqual(c.Sizes, []int{1, 1, 1, 2}) {
+		t.Errorf("Checkpoint of a batch step has moves %v, sizes %v", c.Moves, c.Sizes)
+	}
+	if batched, e := c.restore("test-checkpoint-batch"); e != nil || len(batched.steps) != moves+2 ||
+		!reflect.DeepEqual(batched.steps[moves+1].State().Values, batch.State().Values) {
+		t.Errorf("Restore of a batch step gave error %v", e)
+	}
+	c.Sizes = []int{1, 1}
+	if _, e := c.restore("test-checkpoint-mismatch"); e == nil {
+		t.Errorf("Restore with mismatched sizes succeeded")
+	}
+	if restoredContest == nil || !restoredContest.contest || restoredContest.puzzleID != "2-star" ||
+		len(restoredContest.steps) != 1 {
+		t.Errorf("Restored contest session is %+v", restoredContest)
+	}
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	saved := store
+	store = monitoredStore{storage.NewMemory()}
+	defer func() { store = saved }()
+	l, e := net.Listen("tcp", "localhost:0")
+	if e != nil {
+		t.Fatalf("Can't listen: %v", e)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	defer atomic.StoreInt32(&draining, 0)
+	done := shutdownOnSignal(srv, 200*time.Millisecond)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	time.Sleep(10 * time.Millisecond)
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+
+	// while draining, the server still serves, but isn't ready
+	time.Sleep(50 * time.Millisecond)
+	if r, e := http.Get("http://" + l.Addr().String() + "/"); e != nil {
+		t.Errorf("Draining server didn't serve: %v", e)
+	} else {
+		r.Body.Close()
+	}
+	if atomic.LoadInt32(&draining) == 0 {
+		t.Errorf("Server isn't draining after SIGTERM")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server didn't shut down")
+	}
+	if e := <-served; e != http.ErrServerClosed {
+		t.Errorf("Server stopped with %v", e)
+	}
+}
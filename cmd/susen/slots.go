@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Puzzle slots
+
+A session can keep several puzzles going at once, each in a named
+slot, so a player can have the daily puzzle, a custom puzzle, and
+a 6-star on the go together.  Requests choose a slot with the
+slot query parameter (as in POST /api/assign/?slot=work), and
+requests without one use the session's main slot.  Each slot is a
+board of its own, with its own history, statistics, watchers
+(/ws?slot=work), and room or race, but what's done in a slot is
+credited to the session's player.  The solver page for a slot is
+/solver/?slot=work.
+
+Slots are made the first time they're used, and a session can
+have up to maxSlots of them besides its main one.  GET
+/api/slots/ lists the session's slots, with a summary of each
+board, and DELETE /api/slots/<name> removes one.
+
+*/
+
+// mainSlot is the name of the session's own board, and maxSlots
+// is how many other slots a session can have.
+const (
+	mainSlot = "main"
+	maxSlots = 8
+)
+
+// slotNamePattern matches slot names.
+var slotNamePattern = regexp.MustCompile("^[A-Za-z0-9_-]{1,20}$")
+
+// A slotInfo describes one of a session's slots.
+type slotInfo struct {
+	Slot string `json:"slot"`
+	boardSummary
+}
+
+// slottedPath tells whether requests for a path are handled by
+// the board of the request's slot, rather than by the session.
+func slottedPath(path string) bool {
+	for _, prefix := range []string{"/api/account/", "/api/merge/", "/api/slots/", "/api/catalog/", "/api/puzzles", "/api/batch", "/api/provenance/",
+		"/api/daily/", "/api/leaderboard/", "/api/class/", "/api/discussion/", "/api/push/",
+		"/api/mod/", "/api/admin/", "/api/import/", "/api/prefs", "/replay/", "/spectate/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// slot returns the session for the slot with the given name,
+// making it if it's new.  The main slot is the session itself.
+func (session *susenSession) slot(name string) (*susenSession, error) {
+	if name == "" || name == mainSlot {
+		return session, nil
+	}
+	if !slotNamePattern.MatchString(name) {
+		return nil, requestError("Invalid slot name: " + name)
+	}
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	slot, ok := session.slots[name]
+	if !ok {
+		if len(session.slots) >= maxSlots {
+			return nil, requestError("Sessions can only have " + mainSlot + " and " +
+				strconv.Itoa(maxSlots) + " other slots")
+		}
+		slot = newSession(session.sessionID + "/" + name)
+		slot.owner, slot.slotName = session, name
+		if session.slots == nil {
+			session.slots = make(map[string]*susenSession)
+		}
+		session.slots[name] = slot
+		log.Printf("Session %v made slot %q.", session.sessionID, name)
+	}
+	if session.user != nil {
+		slot.setUser(session.user)
+	}
+	return slot, nil
+}
+
+// slotQuery returns the query that selects the session's slot
+// (for slot sessions only).
+func (session *susenSession) slotQuery() string {
+	if session.owner == nil {
+		return ""
+	}
+	return "?slot=" + url.QueryEscape(session.slotName)
+}
+
+// slotInfos describes the session's slots, main first.
+func (session *susenSession) slotInfos() []slotInfo {
+	session.infoMutex.Lock()
+	slots := map[string]*susenSession{}
+	for name, slot := range session.slots {
+		slots[name] = slot
+	}
+	session.infoMutex.Unlock()
+	names := []string{}
+	for name := range slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	slots[mainSlot] = session
+	infos := []slotInfo{}
+	for _, name := range append([]string{mainSlot}, names...) {
+		slot := slots[name]
+		slot.lockBoard()
+		infos = append(infos, slotInfo{Slot: name, boardSummary: slot.summary()})
+		slot.unlockBoard()
+	}
+	return infos
+}
+
+// removeSlot removes one of the session's slots, telling whether
+// there was one by that name.  The slot's board leaves its room
+// or race.
+func (session *susenSession) removeSlot(name string) bool {
+	session.infoMutex.Lock()
+	slot, ok := session.slots[name]
+	delete(session.slots, name)
+	session.infoMutex.Unlock()
+	if !ok {
+		return false
+	}
+	slot.leaveRoom()
+	slot.lockBoard()
+	slot.leaveRace()
+	slot.stopBlitz()
+	slot.unlockBoard()
+	log.Printf("Session %v removed slot %q.", session.sessionID, name)
+	return true
+}
+
+// slotsHandler handles the slot endpoints:
+//
+// - GET /api/slots/ lists the session's slots
+//
+// - DELETE /api/slots/<name> removes a slot
+//
+// Both respond with the list of slots.
+func (session *susenSession) slotsHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/slots/"), "/")
+	switch r.Method {
+	case "GET":
+	case "DELETE":
+		if name == mainSlot {
+			sendError(w, http.StatusBadRequest, requestError("The main slot can't be removed"))
+			return
+		}
+		if !session.removeSlot(name) {
+			sendError(w, http.StatusNotFound, requestError("No slot named "+name))
+			return
+		}
+	default:
+		sendError(w, http.StatusMethodNotAllowed, requestError("Slots can only be listed or removed"))
+		return
+	}
+	sendJSON(w, http.StatusOK, session.slotInfos())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSlots(t *testing.T) {
+	session := newSession("test-slots")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	// each slot has its own board and history
+	r, e := http.Get(srv.URL + "/reset/2-star?slot=work")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	if r.Request.URL.RawQuery != "slot=work" {
+		t.Errorf("Reset of a slot went to %v", r.Request.URL)
+	}
+	work, _ := session.slot("work")
+	if work.puzzleID != "2-star" || session.puzzleID != defaultPuzzleID {
+		t.Fatalf("After reset, work is %q and main is %q", work.puzzleID, session.puzzleID)
+	}
+	bs, _ := json.Marshal(helperTurnChoice(work))
+	if r, e = http.Post(srv.URL+"/api/assign/?slot=work", "application/json", bytes.NewReader(bs)); e != nil {
+		t.Fatalf("Assign request error: %v", e)
+	}
+	r.Body.Close()
+	if len(work.steps) != 2 || len(session.steps) != 1 {
+		t.Errorf("After assign, work has %d steps and main has %d", len(work.steps), len(session.steps))
+	}
+	var squares []puzzle.Square
+	helperGetJSON(t, srv, "/api/back/?slot=work", &squares)
+	if len(work.steps) != 1 || work.stats.Undos != 1 {
+		t.Errorf("After undo, work has %d steps and %d undos", len(work.steps), work.stats.Undos)
+	}
+	if key, _ := work.player(); key != "session:test-slots" {
+		t.Errorf("Slot player is %q", key)
+	}
+
+	// slots are listed, limited, and removable
+	var infos []slotInfo
+	if status := helperGetJSON(t, srv, "/api/slots/", &infos); status != http.StatusOK ||
+		len(infos) != 2 || infos[0].Slot != mainSlot || infos[1].Slot != "work" || infos[1].PuzzleID != "2-star" {
+		t.Errorf("Slots are %d, %+v", status, infos)
+	}
+	if status := helperGetJSON(t, srv, "/api/?slot=no/good", nil); status != http.StatusBadRequest {
+		t.Errorf("Invalid slot name gave status %d", status)
+	}
+	for i := 1; i < maxSlots; i++ {
+		helperGetJSON(t, srv, "/api/?slot=extra"+strconv.Itoa(i), &squares)
+	}
+	if status := helperGetJSON(t, srv, "/api/?slot=toomany", nil); status != http.StatusBadRequest {
+		t.Errorf("Slot past the limit gave status %d", status)
+	}
+	req, _ := http.NewRequest("DELETE", srv.URL+"/api/slots/extra1", nil)
+	if r, e = http.DefaultClient.Do(req); e != nil {
+		t.Fatalf("Delete request error: %v", e)
+	}
+	json.NewDecoder(r.Body).Decode(&infos)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || len(infos) != maxSlots {
+		t.Errorf("Delete gave %d, %+v", r.StatusCode, infos)
+	}
+	req, _ = http.NewRequest("DELETE", srv.URL+"/api/slots/"+mainSlot, nil)
+	if r, e = http.DefaultClient.Do(req); e != nil {
+		t.Fatalf("Delete request error: %v", e)
+	}
+	if r.Body.Close(); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Delete of the main slot gave status %d", r.StatusCode)
+	}
+
+	// slots are checkpointed with their session
+	c, ok := session.checkpoint()
+	if !ok || len(c.Slots) != maxSlots-1 || c.Slots["work"].PuzzleID != "2-star" {
+		t.Fatalf("Checkpoint has slots %+v", c.Slots)
+	}
+	restored, e := c.restore("test-slots")
+	if e != nil {
+		t.Fatalf("Restore failed: %v", e)
+	}
+	if slot, _ := restored.slot("work"); slot.owner != restored || slot.puzzleID != "2-star" {
+		t.Errorf("Restored work slot is %q, owned by %v", slot.puzzleID, slot.owner)
+	}
+}
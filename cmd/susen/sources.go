@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+)
+
+/*
+
+Square sources
+
+The board keeps track of where the value of each filled square
+came from, and the squares in responses say so in their source
+field (the squares in events to the board's watchers don't, since
+they're shared by its members; see events.go):
+
+- given: it's one of the puzzle's clues
+
+- player: the player filled it in
+
+- hint: the player filled in the square of the board's latest
+hint (see suggest.go), before filling any other
+
+- auto: the server filled it in for the player, as it does with
+the entries of games imported from other apps (see appimport.go)
+
+- partner: another member of the board's room filled it in (see
+rooms.go); the record says "player", since whether a filler is
+a partner depends on who's asking
+
+Squares filled by hints and the server are the board's assisted
+squares.  They count against solves on leaderboards (see
+leaderboard.go), and the solve archive (see archive.go) records
+the source of every square.
+
+*/
+
+// The square sources.
+const (
+	givenSource   = "given"
+	playerSource  = "player"
+	hintSource    = "hint"
+	autoSource    = "auto"
+	partnerSource = "partner"
+)
+
+// fillSource returns the source of the session filling a square
+// now.  Like all board statistics operations, it must be called
+// with the board locked.
+func (session *susenSession) fillSource(index int) string {
+	if index == session.stats.hinted {
+		return hintSource
+	}
+	return playerSource
+}
+
+// autoFilled records that the server filled a square, which has
+// just been counted as assigned (see stats.go), for the player.
+// It must be called with the board locked.
+func (board *susenBoard) autoFilled(index int) {
+	if index <= len(board.stats.sources) {
+		board.stats.sources[index-1] = autoSource
+	}
+	if n := len(board.stats.history); n > 0 && board.stats.history[n-1].Index == index {
+		board.stats.history[n-1].Kind = autofillMove
+	}
+}
+
+// recordedSource returns the recorded source of a filled
+// square.  Squares filled before sources were recorded count as
+// the player's.  It must be called with the board locked.
+func (board *susenBoard) recordedSource(index int) string {
+	if index < len(board.values) && board.values[index] != 0 {
+		return givenSource
+	}
+	if index <= len(board.stats.sources) && board.stats.sources[index-1] != "" {
+		return board.stats.sources[index-1]
+	}
+	return playerSource
+}
+
+// squareSource returns the source of a filled square, as the
+// session sees it.  It must be called with the board locked.
+func (session *susenSession) squareSource(index int) string {
+	source := session.recordedSource(index)
+	if source == playerSource && index <= len(session.stats.fillers) {
+		if filler := session.stats.fillers[index-1]; filler != "" {
+			if key, _ := session.player(); filler != key {
+				return partnerSource
+			}
+		}
+	}
+	return source
+}
+
+// assistedSquares counts the board's squares filled by hints and
+// the server.  It must be called with the board locked.
+func (board *susenBoard) assistedSquares() int {
+	assisted := 0
+	for i, source := range board.stats.sources {
+		if (source == hintSource || source == autoSource) && board.values[i+1] == 0 {
+			assisted++
+		}
+	}
+	return assisted
+}
+
+// A sourcedPuzzle gives the sources of its filled squares, as a
+// session sees them.
+type sourcedPuzzle struct {
+	puzzle.Puzzle
+	session *susenSession
+}
+
+// Squares gives the sources of the filled squares.
+func (p sourcedPuzzle) Squares() []puzzle.Square {
+	return p.source(p.Puzzle.Squares(), 0)
+}
+
+// Assign gives the sources of the filled squares in the update,
+// including the one being assigned, which isn't recorded yet.
+func (p sourcedPuzzle) Assign(choice puzzle.Choice) (puzzle.Update, error) {
+	update, e := p.Puzzle.Assign(choice)
+	update.Squares = p.source(update.Squares, choice.Index)
+	return update, e
+}
+
+// source sets the sources of the filled squares, where the
+// square with the given index (if any) is being filled now.
+func (p sourcedPuzzle) source(squares []puzzle.Square, filling int) []puzzle.Square {
+	for i, s := range squares {
+		switch {
+		case s.Aval == 0:
+		case s.Index == filling:
+			squares[i].Source = p.session.fillSource(s.Index)
+		default:
+			squares[i].Source = p.session.squareSource(s.Index)
+		}
+	}
+	return squares
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSquareSources(t *testing.T) {
+	host, guest := newSession("test-sources-host"), newSession("test-sources-guest")
+	hsrv := httptest.NewServer(http.HandlerFunc(host.rootHandler))
+	defer hsrv.Close()
+	gsrv := httptest.NewServer(http.HandlerFunc(guest.rootHandler))
+	defer gsrv.Close()
+	_, info := helperRoomRequest(t, hsrv, "create/")
+	if status, _ := helperRoomRequest(t, gsrv, "join/"+info.Room); status != http.StatusOK {
+		t.Fatalf("Join gave status %d", status)
+	}
+
+	// the host fills in a hint, and the guest another square
+	var hint hintResponse
+	if status := helperGetJSON(t, hsrv, "/api/hint/", &hint); status != http.StatusOK {
+		t.Fatalf("Hint gave status %d", status)
+	}
+	if status := helperRoomAssign(t, hsrv, hint.Hint.Choice); status != http.StatusOK {
+		t.Fatalf("Assign of hint gave status %d", status)
+	}
+	p, _ := puzzle.New(host.values)
+	solution := p.Solutions()[0].Values
+	other := 0
+	for i, v := range host.values[1:] {
+		if v == 0 && i+1 != hint.Hint.Choice.Index {
+			other = i + 1
+			break
+		}
+	}
+	if status := helperRoomAssign(t, gsrv, puzzle.Choice{Index: other, Value: solution[other-1]}); status != http.StatusOK {
+		t.Fatalf("Guest assign gave status %d", status)
+	}
+
+	sources := func(srv *httptest.Server) map[int]string {
+		var squares []puzzle.Square
+		helperGetJSON(t, srv, "/api/squares/", &squares)
+		result := make(map[int]string)
+		for _, s := range squares {
+			if (s.Aval == 0) != (s.Source == "") {
+				t.Errorf("Square %d with value %d has source %q", s.Index, s.Aval, s.Source)
+			}
+			result[s.Index] = s.Source
+		}
+		return result
+	}
+	hs, gs := sources(hsrv), sources(gsrv)
+	if hs[hint.Hint.Choice.Index] != hintSource || gs[hint.Hint.Choice.Index] != hintSource {
+		t.Errorf("Hinted square has sources %q and %q", hs[hint.Hint.Choice.Index], gs[hint.Hint.Choice.Index])
+	}
+	if hs[other] != partnerSource || gs[other] != playerSource {
+		t.Errorf("Guest's square has sources %q and %q", hs[other], gs[other])
+	}
+	if hs[1] != givenSource {
+		t.Errorf("Given square has source %q", hs[1])
+	}
+	if assisted := host.assistedSquares(); assisted != 1 {
+		t.Errorf("Board has %d assisted squares", assisted)
+	}
+
+	// assisted solves rank after the others
+	fast, slow := leaderboardEntry{SolveTime: 60, Assisted: 1}, leaderboardEntry{SolveTime: 120}
+	if fast.ranksBefore(slow) || !slow.ranksBefore(fast) {
+		t.Errorf("Assisted solve ranks before unassisted one")
+	}
+}
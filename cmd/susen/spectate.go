@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/*
+
+Spectators
+
+A session can be watched live by spectators, who see its board
+change as its players play (for teaching, say, or to follow a
+tournament game) but can't change it themselves.  The session
+gives out a spectate token, which is all spectators need:
+
+- POST /api/spectate/ makes the session's token, if it doesn't
+have one, and gives it (with the path spectators watch at, and
+how many spectators there are)
+
+- GET /api/spectate/ gives the session's token, if it has one
+
+- DELETE /api/spectate/ revokes the session's token, and
+disconnects its spectators.
+
+Spectators watch at /spectate/<token>: over a WebSocket
+connection, they're sent the same events as the session's own
+watchers (see events.go), starting with the current squares, and
+a GET without the upgrade gives the current squares, for
+spectators that poll.  Spectators can't change the board: any
+other request to /spectate/<token> is refused (403), as is any
+message a spectator sends over its connection, which is answered
+with a "refused" event carrying the error.  Spectators don't
+count as the session's own watchers (see push.go).
+
+Tokens are only kept in memory, so they don't outlive the
+session, or the server.
+
+*/
+
+// refusedEventType is the type of the events that answer
+// spectators' attempts to change the board.
+const refusedEventType = "refused"
+
+// spectateInfo is the response to spectate token requests.
+type spectateInfo struct {
+	Token      string `json:"token"`
+	Path       string `json:"path"`
+	Spectators int    `json:"spectators"`
+}
+
+var (
+	spectated     = make(map[string]*susenSession) // by token
+	spectateMutex sync.RWMutex
+)
+
+// newSpectateToken makes a random token that isn't used by any
+// other session.  Must be called with the tokens locked.
+func newSpectateToken() string {
+	for {
+		var b [18]byte
+		if _, e := rand.Read(b[:]); e != nil {
+			log.Fatal(e)
+		}
+		token := base64.RawURLEncoding.EncodeToString(b[:])
+		if _, ok := spectated[token]; !ok {
+			return token
+		}
+	}
+}
+
+// spectateInfo returns the session's token information, or false
+// if it doesn't have a token.
+func (session *susenSession) spectateInfo() (spectateInfo, bool) {
+	session.infoMutex.Lock()
+	token := session.spectateToken
+	session.infoMutex.Unlock()
+	if token == "" {
+		return spectateInfo{}, false
+	}
+	session.watchMutex.Lock()
+	count := len(session.spectators)
+	session.watchMutex.Unlock()
+	return spectateInfo{Token: token, Path: "/spectate/" + token, Spectators: count}, true
+}
+
+// startSpectating gives the session a spectate token, if it
+// doesn't have one.
+func (session *susenSession) startSpectating() {
+	spectateMutex.Lock()
+	defer spectateMutex.Unlock()
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	if session.spectateToken == "" {
+		session.spectateToken = newSpectateToken()
+		spectated[session.spectateToken] = session
+		log.Printf("Session %v can be spectated.", session.sessionID)
+	}
+}
+
+// stopSpectating revokes the session's spectate token, if it has
+// one, and disconnects its spectators.
+func (session *susenSession) stopSpectating() {
+	spectateMutex.Lock()
+	session.infoMutex.Lock()
+	if session.spectateToken != "" {
+		delete(spectated, session.spectateToken)
+		session.spectateToken = ""
+		log.Printf("Session %v can no longer be spectated.", session.sessionID)
+	}
+	session.infoMutex.Unlock()
+	spectateMutex.Unlock()
+	session.watchMutex.Lock()
+	for c := range session.spectators {
+		c.close()
+	}
+	session.spectators = nil
+	session.watchMutex.Unlock()
+}
+
+// spectateTokenHandler handles the /api/spectate/ endpoints.
+func (session *susenSession) spectateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		session.startSpectating()
+	case "DELETE":
+		session.stopSpectating()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "GET":
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown spectate operation: "+r.Method+" "+r.URL.Path))
+		return
+	}
+	info, ok := session.spectateInfo()
+	if !ok {
+		sendError(w, http.StatusNotFound, requestError("The session has no spectate token"))
+		return
+	}
+	sendJSON(w, http.StatusOK, info)
+}
+
+// spectatorError is the error for spectators' attempts to change
+// the board.
+func spectatorError() puzzle.Error {
+	return requestError("Spectators can't change the board")
+}
+
+// spectateHandler handles requests to /spectate/<token>.
+func spectateHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/spectate/"), "/")
+	token := strings.SplitN(path, "/", 2)[0]
+	spectateMutex.RLock()
+	session, ok := spectated[token]
+	spectateMutex.RUnlock()
+	if !ok {
+		sendError(w, http.StatusNotFound, requestError("No session to spectate with token "+token))
+		return
+	}
+	if r.Method != "GET" || path != token {
+		sendError(w, http.StatusForbidden, spectatorError())
+		return
+	}
+	if r.Header.Get("Upgrade") == "" {
+		session.lockBoard()
+		current := session.guessed(session.steps[len(session.steps)-1])
+		session.unlockBoard()
+		puzzle.SquaresHandler(current, w, r)
+		return
+	}
+	session.spectate(w, r, token)
+}
+
+// spectate turns the request into a WebSocket connection that
+// spectates the session with the given token, starting with the
+// current squares.
+func (session *susenSession) spectate(w http.ResponseWriter, r *http.Request, token string) {
+	c, e := upgradeWebSocket(w, r)
+	if e != nil {
+		log.Printf("Spectator WebSocket upgrade failed: %v", e)
+		return
+	}
+	c.onText = func([]byte) {
+		session.lockBoard()
+		puzzleID := session.puzzleID
+		session.unlockBoard()
+		err := spectatorError().Envelope(http.StatusForbidden, r.Header.Get("Accept-Language"), "")
+		c.writeJSON(sessionEvent{Type: refusedEventType, PuzzleID: puzzleID, Errors: []puzzle.Error{err}})
+	}
+	session.lockBoard()
+	puzzleID, current := session.puzzleID, session.guessed(session.steps[len(session.steps)-1])
+	session.unlockBoard()
+	if e := c.writeJSON(sessionEvent{Type: squaresEventType, PuzzleID: puzzleID, Squares: current.Squares()}); e != nil {
+		c.close()
+		return
+	}
+	session.watchMutex.Lock()
+	session.infoMutex.Lock()
+	revoked := session.spectateToken != token // while connecting
+	session.infoMutex.Unlock()
+	if revoked {
+		session.watchMutex.Unlock()
+		c.close()
+		return
+	}
+	if session.spectators == nil {
+		session.spectators = make(map[*wsConn]bool)
+	}
+	session.spectators[c] = true
+	session.watchMutex.Unlock()
+	log.Printf("Session %v has a new spectator.", session.sessionID)
+
+	c.serve()
+
+	session.watchMutex.Lock()
+	delete(session.spectators, c)
+	session.watchMutex.Unlock()
+	log.Printf("Session %v spectator disconnected.", session.sessionID)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpectators(t *testing.T) {
+	session := newSession("test-spectated")
+	session.reset("1-star")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	spectator := newSession("test-spectator")
+	ssrv := httptest.NewServer(http.HandlerFunc(spectator.rootHandler))
+	defer ssrv.Close()
+
+	var info spectateInfo
+	if status := helperGetJSON(t, srv, "/api/spectate/", &info); status != http.StatusNotFound {
+		t.Errorf("Token before spectating gave %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/spectate/", nil, &info); status != http.StatusOK ||
+		info.Token == "" || info.Path != "/spectate/"+info.Token || info.Spectators != 0 {
+		t.Fatalf("Starting spectating gave %d, %+v", status, info)
+	}
+
+	// spectators get the board's events
+	c := helperDialWebSocket(t, ssrv, info.Path)
+	defer c.conn.Close()
+	if ev := c.readEvent(t); ev.Type != squaresEventType || len(ev.Squares) != 81 || ev.PuzzleID != "1-star" {
+		t.Fatalf("Initial spectator event was %+v", ev)
+	}
+	bs, _ := json.Marshal(puzzle.Choice{Index: 2, Value: 6})
+	r, e := http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Assign request error: %v", e)
+	}
+	r.Body.Close()
+	if ev := c.readEvent(t); ev.Type != updateEventType || len(ev.Squares) == 0 || ev.Squares[0].Index != 2 {
+		t.Errorf("Spectator's assign event was %+v", ev)
+	}
+	var current []puzzle.Square
+	if status := helperGetJSON(t, ssrv, info.Path, &current); status != http.StatusOK || current[1].Aval != 6 {
+		t.Errorf("Spectator's squares gave %d", status)
+	}
+	if helperGetJSON(t, srv, "/api/spectate/", &info); info.Spectators != 1 {
+		t.Errorf("Spectate info is %+v", info)
+	}
+
+	// but can't change it
+	c.writeFrame(wsTextFrame, bs)
+	if ev := c.readEvent(t); ev.Type != refusedEventType || len(ev.Errors) != 1 || ev.Errors[0].Code != "forbidden" {
+		t.Errorf("Spectator's message got %+v", ev)
+	}
+	for _, path := range []string{info.Path, info.Path + "/api/assign/"} {
+		if status := helperUserRequest(t, ssrv, "", "POST", path, puzzle.Choice{Index: 3, Value: 1}, nil); status != http.StatusForbidden {
+			t.Errorf("Spectator's POST to %s gave %d", path, status)
+		}
+	}
+	if session.steps[len(session.steps)-1].State().Values[2] != 0 {
+		t.Errorf("Spectator changed the board")
+	}
+
+	// revoking the token disconnects its spectators
+	if status := helperUserRequest(t, srv, "", "DELETE", "/api/spectate/", nil, nil); status != http.StatusNoContent {
+		t.Errorf("Revoking the token gave %d", status)
+	}
+	if _, e := c.br.ReadByte(); e == nil {
+		t.Errorf("Spectator is still connected")
+	}
+	if status := helperGetJSON(t, ssrv, info.Path, &current); status != http.StatusNotFound {
+		t.Errorf("Revoked token gave %d", status)
+	}
+}
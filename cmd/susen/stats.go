@@ -0,0 +1,230 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Statistics
+
+Each board keeps statistics on the play of its puzzle: when it
+was started, how many assignments and undos there have been,
+when (and, by its clock, how quickly) it was completed (see
+timer.go), and its score if it's scored (see scoring.go).
+Completion is noticed by the server: for ordinary boards, it's
+when the last empty square is filled without errors; for
+contest and unassisted boards (whose errors aren't known until
+they submit), it's when a submission is valid.
+
+Completions are also added to the totals for their puzzle,
+which are kept in the store so they outlast the server, and
+entered on the puzzle's leaderboard in the name of the session
+that completed it (and, for identified users, in their results).
+Completions of daily puzzles also count toward the player's
+streak (see streaks.go).
+
+*/
+
+// puzzleStats are the statistics for one play of a puzzle.
+type puzzleStats struct {
+	PuzzleID    string            `json:"puzzleID"`
+	Started     time.Time         `json:"started"`
+	Assignments int               `json:"assignments"`
+	Undos       int               `json:"undos"`
+	Hints       int               `json:"hints"`
+	Completed   *time.Time        `json:"completed,omitempty"`
+	SolveTime   float64           `json:"solveTime,omitempty"` // seconds the clock ran until completion
+	Paused      *time.Time        `json:"paused,omitempty"`    // when the clock was stopped, if it is (see timer.go)
+	IdleTime    float64           `json:"idleTime,omitempty"`  // seconds the clock was stopped before that
+	Expired     bool              `json:"expired,omitempty"`   // a blitz attempt ran out of time
+	Daily       bool              `json:"daily,omitempty"`     // the puzzle is a daily puzzle
+	Replay      string            `json:"replay,omitempty"`    // the ID of the solve's published replay (see replay.go)
+	Score       *int              `json:"score,omitempty"`     // nil if the board isn't scored (see scoring.go)
+	tries       []int             // assignments to each square (by index from 0), for share text
+	fillers     []string          // the player who filled each square (by index from 0), for the archive
+	sources     []string          // the source of each filled square (by index from 0; see sources.go)
+	hinted      int               // the square of the latest hint, until a square is filled
+	names       map[string]string // the names of the fillers, by key
+	history     []historyMove     // the moves, in order (see history.go)
+}
+
+// puzzleTotals are the statistics for all completed plays of a
+// puzzle.
+type puzzleTotals struct {
+	PuzzleID    string  `json:"puzzleID"`
+	Completions int     `json:"completions"`
+	MeanTime    float64 `json:"meanTime"` // seconds
+	BestTime    float64 `json:"bestTime"` // seconds
+}
+
+// totalsKind is the storage kind for puzzle totals.
+const totalsKind = "puzzle-totals"
+
+// totalsMutex serializes the updates of puzzle totals.
+var totalsMutex sync.Mutex
+
+// startStats starts the board's statistics over for a newly
+// started puzzle.  Like all board statistics operations, it
+// must be called with the board locked.
+func (board *susenBoard) startStats() {
+	_, daily := parseDailyID(board.puzzleID)
+	board.stats = puzzleStats{PuzzleID: board.puzzleID, Started: time.Now(), Daily: daily,
+		tries: make([]int, len(board.values)-1), fillers: make([]string, len(board.values)-1),
+		sources: make([]string, len(board.values)-1)}
+	board.startScore()
+}
+
+// countAssign counts an assignment (and a try at the assigned
+// square), and completes the puzzle if that assignment solved it.
+// (Contest and unassisted puzzles never say they are solved, so
+// they're completed on submit.)  Squares only take assignments
+// when they're empty, so a square that's tried more than once
+// was corrected.
+func (session *susenSession) countAssign(update puzzle.Update) {
+	session.resumeTimer()
+	session.stats.Assignments++
+	for _, s := range update.Squares {
+		if s.Aval != 0 && s.Index <= len(session.stats.tries) {
+			session.stats.tries[s.Index-1]++
+			session.fillSquare(s.Index)
+			session.recordMove(s.Index, s.Aval)
+			break
+		}
+	}
+	if update.Solved {
+		session.complete()
+	}
+}
+
+// countUndo counts an undo, and charges the board's score for
+// it.
+func (board *susenBoard) countUndo() {
+	board.resumeTimer()
+	board.stats.Undos++
+	board.chargeScore(scoreUndo, 1)
+}
+
+// complete marks the board's puzzle as completed by the session
+// (unless it already was), archives the solve, adds the
+// completion to the puzzle's totals, enters it on the puzzle's
+// leaderboard, and records it
+// in the user's results (and, for daily puzzles, the player's
+// streak), and finishes the board's race.  Shared positions,
+// handicapped race boards, and imported boards (see backup.go)
+// only have their board's statistics.
+func (session *susenSession) complete() {
+	board := session.susenBoard
+	if board.stats.Completed != nil {
+		return
+	}
+	if board.blitz != nil {
+		board.blitz.finish()
+	}
+	now := time.Now()
+	board.stats.Completed = &now
+	board.stats.SolveTime = board.elapsed(now).Seconds()
+	log.Printf("Puzzle %q completed in %.1fs (%d assignments, %d undos).",
+		board.puzzleID, board.stats.SolveTime, board.stats.Assignments, board.stats.Undos)
+	board.logScore()
+	if board.race != nil {
+		board.race.finish(session, now)
+	}
+	board.archiveSolve()
+	if sharedPuzzleID(board.puzzleID) || board.handicapped || board.imported {
+		return
+	}
+	addCompletion(board.puzzleID, board.stats.SolveTime)
+	key, name := session.player()
+	entry := leaderboardEntry{
+		Key:        key,
+		Name:       name,
+		SolveTime:  board.stats.SolveTime,
+		Moves:      board.stats.Assignments,
+		Undos:      board.stats.Undos,
+		Hints:      board.stats.Hints,
+		Assisted:   board.assistedSquares(),
+		Completed:  now,
+		Unassisted: board.unassisted,
+		Score:      board.stats.Score,
+	}
+	addLeaderboardEntry(board.puzzleID, entry)
+	session.recordResult(board.puzzleID, entry)
+	if date, ok := parseDailyID(board.puzzleID); ok {
+		recordStreak(key, date)
+	}
+}
+
+// addCompletion adds a completion time to a puzzle's totals.
+func addCompletion(puzzleID string, seconds float64) {
+	totalsMutex.Lock()
+	defer totalsMutex.Unlock()
+	totals := puzzleTotals{PuzzleID: puzzleID}
+	if _, e := store.Get(totalsKind, puzzleID, &totals); e != nil {
+		log.Printf("Can't read totals for puzzle %q: %v", puzzleID, e)
+		return
+	}
+	if totals.Completions == 0 || seconds < totals.BestTime {
+		totals.BestTime = seconds
+	}
+	totals.MeanTime = (totals.MeanTime*float64(totals.Completions) + seconds) / float64(totals.Completions+1)
+	totals.Completions++
+	if e := store.Put(totalsKind, puzzleID, totals); e != nil {
+		log.Printf("Can't save totals for puzzle %q: %v", puzzleID, e)
+	}
+}
+
+// statsHandler handles the statistics endpoints:
+//
+// - GET /api/stats gives the statistics for the session's board
+//
+// - GET /api/stats/streak gives the session's daily streak (see
+// streaks.go)
+//
+// - GET /api/stats/imported gives the user's statistics from other
+// apps (see appimport.go)
+//
+// - GET /api/stats/<puzzleID> gives the totals for a puzzle
+// ("daily" means today's daily puzzle)
+//
+// Statistics and totals come with their display forms for the
+// player's locale (see locale.go).
+func (session *susenSession) statsHandler(w http.ResponseWriter, r *http.Request) {
+	puzzleID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/stats"), "/")
+	if puzzleID == "" {
+		sendJSON(w, http.StatusOK, newLocalizer(w, r).stats(session.stats))
+		return
+	}
+	if puzzleID == "streak" {
+		session.streakHandler(w, r)
+		return
+	}
+	if puzzleID == "imported" {
+		session.importedStatsHandler(w, r)
+		return
+	}
+	if puzzleID == dailyPuzzleID {
+		puzzleID = todayID(r)
+	}
+	if !knownPuzzleID(puzzleID) {
+		sendError(w, http.StatusNotFound, requestError("No puzzle "+puzzleID))
+		return
+	}
+	totals := puzzleTotals{PuzzleID: puzzleID}
+	totalsMutex.Lock()
+	_, e := reportGet(r.Context(), totalsKind, puzzleID, &totals)
+	totalsMutex.Unlock()
+	if e != nil {
+		if refuseExpired(w, r) {
+			return
+		}
+		sendError(w, http.StatusInternalServerError, requestError("Can't read puzzle totals: "+e.Error()))
+		return
+	}
+	sendJSON(w, http.StatusOK, newLocalizer(w, r).totals(totals))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func helperGetJSON(t *testing.T, srv *httptest.Server, path string, out interface{}) int {
+	r, e := http.Get(srv.URL + path)
+	if e != nil {
+		t.Fatalf("GET %s request error: %v", path, e)
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(out); e != nil {
+			t.Fatalf("GET %s decode error: %v", path, e)
+		}
+	}
+	return r.StatusCode
+}
+
+func TestStats(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-stats")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	p, e := puzzle.New(puzzleValues[defaultPuzzleID])
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	solution := p.Solutions()[0].Values
+	var empty []int
+	for i, s := range p.Squares() {
+		if s.Aval == 0 {
+			empty = append(empty, i)
+		}
+	}
+
+	// make a move and take it back, then solve the puzzle
+	helperRoomAssign(t, srv, puzzle.Choice{Index: empty[0] + 1, Value: solution[empty[0]]})
+	r, e := http.Get(srv.URL + "/api/back/")
+	if e != nil {
+		t.Fatalf("Undo request error: %v", e)
+	}
+	r.Body.Close()
+	var stats puzzleStats
+	helperGetJSON(t, srv, "/api/stats", &stats)
+	if stats.PuzzleID != defaultPuzzleID || stats.Assignments != 1 || stats.Undos != 1 || stats.Completed != nil {
+		t.Errorf("Stats after undo are %+v", stats)
+	}
+	last := empty[len(empty)-1]
+	for _, i := range empty[:len(empty)-1] {
+		if status := helperRoomAssign(t, srv, puzzle.Choice{Index: i + 1, Value: solution[i]}); status != http.StatusOK {
+			t.Fatalf("Assign to square %d gave status %d", i+1, status)
+		}
+	}
+	body, _ := json.Marshal(puzzle.Choice{Index: last + 1, Value: solution[last]})
+	r, e = http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(body))
+	if e != nil {
+		t.Fatalf("Assign request error: %v", e)
+	}
+	var update puzzle.Update
+	e = json.NewDecoder(r.Body).Decode(&update)
+	r.Body.Close()
+	if e != nil || !update.Solved {
+		t.Errorf("Solving assignment gave update %+v, %v", update, e)
+	}
+	helperGetJSON(t, srv, "/api/stats", &stats)
+	if stats.Assignments != len(empty)+1 || stats.Completed == nil || stats.SolveTime <= 0 {
+		t.Errorf("Stats after solving are %+v", stats)
+	}
+
+	var totals puzzleTotals
+	if status := helperGetJSON(t, srv, "/api/stats/"+defaultPuzzleID, &totals); status != http.StatusOK ||
+		totals.Completions != 1 || totals.BestTime != stats.SolveTime {
+		t.Errorf("Puzzle totals are %d, %+v", status, totals)
+	}
+	if status := helperGetJSON(t, srv, "/api/stats/no-such-puzzle", &totals); status != http.StatusNotFound {
+		t.Errorf("Totals for unknown puzzle gave status %d", status)
+	}
+
+	// starting over starts the statistics over
+	r, e = http.Get(srv.URL + "/reset/2-star")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	stats = puzzleStats{}
+	helperGetJSON(t, srv, "/api/stats", &stats)
+	if stats.PuzzleID != "2-star" || stats.Assignments != 0 || stats.Completed != nil {
+		t.Errorf("Stats after reset are %+v", stats)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"strconv"
+)
+
+/*
+
+Board status
+
+GET /api/status tells players whether they're still on track:
+whether every entry on the board agrees with the solution of the
+puzzle it was started with, so that a wrong entry that breaks no
+rules (yet) doesn't go unnoticed until the puzzle can't be
+finished.  The response says how many entries are wrong, and
+with ?squares=true, which squares they're in.
+
+Only puzzles with exactly one solution have entries that can be
+judged, so boards with other puzzles get a 409.  Since the status
+says whether entries are right, contest and unassisted boards
+can't have it, and neither can sessions whose players would
+rather not know (with the hideStatus preference; see prefs.go).
+It's metered as analysis (see quota.go), and the board is
+unlocked while the puzzle is solved.
+
+*/
+
+// A boardStatus says whether a board's entries agree with its
+// puzzle's solution.
+type boardStatus struct {
+	OnTrack bool  `json:"onTrack"`
+	Wrong   int   `json:"wrong"`             // the entries that don't agree
+	Squares []int `json:"squares,omitempty"` // their indexes, if asked for
+}
+
+// statusHandler handles GET /api/status.
+func (session *susenSession) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if session.contest || session.unassisted {
+		sendError(w, http.StatusForbidden, requestError("Contest and unassisted boards get no status"))
+		return
+	}
+	if session.preferences().HideStatus {
+		sendError(w, http.StatusForbidden, requestError("The session's preferences hide the board's status"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) || refuseSolverFault(w) {
+		return
+	}
+	withSquares, _ := strconv.ParseBool(r.URL.Query().Get("squares"))
+	values := session.values
+	var status boardStatus
+	var e error
+	session.unlocked(func(step puzzle.Puzzle) {
+		status, e = boardStatusOf(values, step, withSquares)
+	})
+	if e != nil {
+		err, ok := e.(puzzle.Error)
+		if !ok {
+			err = requestError(e.Error())
+		}
+		sendError(w, http.StatusConflict, err)
+		return
+	}
+	debugf("Session %v is on track: %v (%d wrong).", session.sessionID, status.OnTrack, status.Wrong)
+	sendJSON(w, http.StatusOK, status)
+}
+
+// boardStatusOf returns the status of a board step, given the
+// values its puzzle was started with, and whether to list the
+// squares with wrong entries.
+func boardStatusOf(values []int, step puzzle.Puzzle, withSquares bool) (boardStatus, error) {
+	start, e := puzzle.New(values)
+	if e == nil {
+		e = start.IsProper()
+	}
+	if e != nil {
+		return boardStatus{}, e
+	}
+	solution := start.Solutions()[0].Values
+	status := boardStatus{}
+	for i, v := range step.State().Values {
+		if v != 0 && i < len(solution) && v != solution[i] {
+			status.Wrong++
+			if withSquares {
+				status.Squares = append(status.Squares, i+1)
+			}
+		}
+	}
+	status.OnTrack = status.Wrong == 0
+	return status, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBoardStatus(t *testing.T) {
+	session := newSession("test-status")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	p, e := puzzle.New(session.values)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	solution := p.Solutions()[0].Values
+	var wrong puzzle.Choice
+	for _, s := range p.Squares() {
+		for _, v := range s.Pvals {
+			if s.Aval == 0 && v != solution[s.Index-1] && wrong.Index == 0 {
+				wrong = puzzle.Choice{Index: s.Index, Value: v} // legal, for now
+			}
+		}
+	}
+	if wrong.Index == 0 {
+		t.Fatalf("Puzzle has no wrong but legal choice")
+	}
+
+	var status boardStatus
+	if code := helperUserRequest(t, srv, "", "GET", "/api/status", nil, &status); code != http.StatusOK ||
+		!reflect.DeepEqual(status, boardStatus{OnTrack: true}) {
+		t.Fatalf("Status of the new board was %d, %+v", code, status)
+	}
+	if code := helperRoomAssign(t, srv, wrong); code != http.StatusOK {
+		t.Fatalf("Assignment of %+v gave status %d", wrong, code)
+	}
+	if code := helperUserRequest(t, srv, "", "GET", "/api/status", nil, &status); code != http.StatusOK ||
+		!reflect.DeepEqual(status, boardStatus{Wrong: 1}) {
+		t.Errorf("Status after a wrong entry was %d, %+v", code, status)
+	}
+	if code := helperUserRequest(t, srv, "", "GET", "/api/status?squares=true", nil, &status); code != http.StatusOK ||
+		!reflect.DeepEqual(status, boardStatus{Wrong: 1, Squares: []int{wrong.Index}}) {
+		t.Errorf("Status with squares was %d, %+v", code, status)
+	}
+
+	// players can hide it, and contest boards don't get it
+	prefs := map[string]interface{}{"hideStatus": true}
+	if code := helperUserRequest(t, srv, "", "PUT", "/api/prefs", prefs, nil); code != http.StatusOK {
+		t.Fatalf("Change of preferences gave status %d", code)
+	}
+	if code := helperUserRequest(t, srv, "", "GET", "/api/status", nil, nil); code != http.StatusForbidden {
+		t.Errorf("Hidden status gave status %d", code)
+	}
+	prefs["hideStatus"] = false
+	helperUserRequest(t, srv, "", "PUT", "/api/prefs", prefs, nil)
+	session.contest = true
+	defer func() { session.contest = false }()
+	if code := helperUserRequest(t, srv, "", "GET", "/api/status", nil, nil); code != http.StatusForbidden {
+		t.Errorf("Status of a contest board gave status %d", code)
+	}
+}
+
+func TestBoardStatusImproper(t *testing.T) {
+	values := []int{puzzle.SudokuGeometryCode, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	p, _ := puzzle.New(values)
+	if _, e := boardStatusOf(values, p, false); e == nil {
+		t.Errorf("Status of an improper puzzle succeeded")
+	}
+}
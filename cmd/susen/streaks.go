@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+
+Daily streaks
+
+Each player has a streak: the number of days in a row they've
+solved the daily puzzle on its day.  (A day's puzzle counts if
+it's solved while it's still that day somewhere, so players in
+every zone can keep streaks.)  Players earn a streak freeze for
+every streakFreezeEvery days of a streak, and can hold up to
+maxStreakFreezes of them.  A freeze is used up automatically for
+each day the player misses, so the streak carries on as if they
+hadn't; frozen days keep the streak going, but don't add to
+it.  Once a player misses a day with no freezes left, the
+streak starts over with their next solve.
+
+Streaks are kept in the store, under the player's key (see
+player in leaderboard.go), and GET /api/stats/streak gives the
+session's, as of the player's today (see daily.go).
+
+*/
+
+// streakKind is the storage kind for streaks.
+const streakKind = "daily-streak"
+
+// Players earn a freeze every streakFreezeEvery days of a
+// streak, and hold at most maxStreakFreezes.
+const (
+	streakFreezeEvery = 7
+	maxStreakFreezes  = 2
+)
+
+// latestZone is the zone in which days end last.
+var latestZone = time.FixedZone("UTC-12", -12*60*60)
+
+// A dailyStreak is a player's streak of daily puzzles.
+type dailyStreak struct {
+	Current int      `json:"current"`
+	Longest int      `json:"longest"`
+	LastDay string   `json:"lastDay,omitempty"` // the last day (yyyy-mm-dd) the streak covers
+	Freezes int      `json:"freezes"`
+	Frozen  []string `json:"frozen,omitempty"` // the days of the current streak covered by freezes
+}
+
+// streakMutex serializes the updates of streaks.
+var streakMutex sync.Mutex
+
+// nextDay returns the day after a day (both yyyy-mm-dd).
+func nextDay(day string) string {
+	date, _ := time.Parse(dailyDateForm, day)
+	return date.AddDate(0, 0, 1).Format(dailyDateForm)
+}
+
+// catchUp brings the streak up to the day before the given one,
+// using freezes for the days that were missed, or ending the
+// streak (and keeping the freezes) if there aren't enough of
+// them.
+func (s *dailyStreak) catchUp(day string) {
+	if s.Current == 0 || s.LastDay == "" {
+		return
+	}
+	var missed []string
+	for d := nextDay(s.LastDay); d < day; d = nextDay(d) {
+		if len(missed) == s.Freezes {
+			s.Current, s.Frozen = 0, nil
+			return
+		}
+		missed = append(missed, d)
+	}
+	if len(missed) > 0 {
+		s.Freezes -= len(missed)
+		s.Frozen = append(s.Frozen, missed...)
+		s.LastDay = missed[len(missed)-1]
+	}
+}
+
+// solve adds the solve of a day's puzzle to the streak.  Days
+// before the streak's last day don't change it.
+func (s *dailyStreak) solve(day string) {
+	if day <= s.LastDay {
+		return
+	}
+	s.catchUp(day)
+	if s.Current == 0 {
+		s.Frozen = nil
+	}
+	s.Current++
+	s.LastDay = day
+	if s.Current > s.Longest {
+		s.Longest = s.Current
+	}
+	if s.Current%streakFreezeEvery == 0 && s.Freezes < maxStreakFreezes {
+		s.Freezes++
+		log.Printf("Streak of %d days earned a freeze (now %d).", s.Current, s.Freezes)
+	}
+}
+
+// recordStreak adds the solve of a daily puzzle to the player's
+// streak, if it was solved on its day.
+func recordStreak(key string, date time.Time) {
+	day, now := date.Format(dailyDateForm), time.Now()
+	if day < now.In(latestZone).Format(dailyDateForm) || day > now.In(earliestZone).Format(dailyDateForm) {
+		return
+	}
+	streakMutex.Lock()
+	defer streakMutex.Unlock()
+	var s dailyStreak
+	if _, e := store.Get(streakKind, key, &s); e != nil {
+		log.Printf("Can't read streak %q: %v", key, e)
+		return
+	}
+	s.solve(day)
+	if e := store.Put(streakKind, key, s); e != nil {
+		log.Printf("Can't save streak %q: %v", key, e)
+	}
+}
+
+// streakHandler handles GET /api/stats/streak, which gives the
+// session's streak, with the freezes needed to carry it up to
+// today already used.
+func (session *susenSession) streakHandler(w http.ResponseWriter, r *http.Request) {
+	key, _ := session.player()
+	var s dailyStreak
+	streakMutex.Lock()
+	_, e := store.Get(streakKind, key, &s)
+	streakMutex.Unlock()
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't read streak: "+e.Error()))
+		return
+	}
+	s.catchUp(todayID(r)[len(dailyIDPrefix):])
+	if s.Frozen == nil {
+		s.Frozen = []string{}
+	}
+	sendJSON(w, http.StatusOK, s)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreakFreezes(t *testing.T) {
+	day := func(n int) string {
+		return time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n).Format(dailyDateForm)
+	}
+	var s dailyStreak
+	for n := 0; n < 2*streakFreezeEvery; n++ {
+		s.solve(day(n))
+	}
+	if s.Current != 2*streakFreezeEvery || s.Freezes != 2 || s.LastDay != day(2*streakFreezeEvery-1) {
+		t.Fatalf("Streak after two weeks is %+v", s)
+	}
+	s.solve(day(3))
+	if s.Current != 2*streakFreezeEvery {
+		t.Errorf("Solving an earlier day changed the streak to %+v", s)
+	}
+
+	// missed days use up freezes, and don't add to the streak
+	s.solve(day(2*streakFreezeEvery + 1))
+	if s.Current != 2*streakFreezeEvery+1 || s.Freezes != 1 ||
+		!reflect.DeepEqual(s.Frozen, []string{day(2 * streakFreezeEvery)}) {
+		t.Errorf("Streak after a missed day is %+v", s)
+	}
+	view := s
+	view.catchUp(day(2*streakFreezeEvery + 3))
+	if view.Current != s.Current || view.Freezes != 0 || len(view.Frozen) != 2 {
+		t.Errorf("Streak as of a day later is %+v", view)
+	}
+
+	// without enough freezes, the streak starts over
+	s.solve(day(2*streakFreezeEvery + 4))
+	if s.Current != 1 || s.Longest != 2*streakFreezeEvery+1 || s.Freezes != 1 || s.Frozen != nil {
+		t.Errorf("Streak after two missed days is %+v", s)
+	}
+}
+
+func TestStreakAPI(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-streak")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var s dailyStreak
+	if status := helperGetJSON(t, srv, "/api/stats/streak", &s); status != http.StatusOK || s.Current != 0 {
+		t.Errorf("New streak gave %d, %+v", status, s)
+	}
+	// old days' puzzles don't count, and today's does
+	session.reset(dailyID(time.Now().AddDate(0, 0, -3)))
+	helperSolve(t, srv, session)
+	session.reset(dailyPuzzleID)
+	helperSolve(t, srv, session)
+	today := dailyID(time.Now())[len(dailyIDPrefix):]
+	if helperGetJSON(t, srv, "/api/stats/streak", &s); s.Current != 1 || s.Longest != 1 || s.LastDay != today {
+		t.Errorf("Streak after today's puzzle is %+v", s)
+	}
+}
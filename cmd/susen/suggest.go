@@ -0,0 +1,186 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+
+Move hints
+
+Players who are stuck can ask for a hint: the next square to
+fill, and the technique that finds it.  The hint policy, which is
+part of the live configuration, limits how often a board can get
+a hint and how many it can get for each puzzle, so hints can't
+be used to solve a puzzle outright.  Stricter games can turn
+hints down or off.  Hints are counted in the board's statistics
+and on leaderboards.
+
+Every hint response says how many hints are left for the puzzle
+in the X-Hints-Remaining header.  When none are left the
+response is a 403, and while the board is waiting out the
+cooldown it is a 429 with a Retry-After header.
+
+Learners can also ask for an explanation: every step of the
+logical solution from where the board is (see puzzle.Explain).
+Hints and the steps of explanations name their techniques in the
+player's language, as well as giving their stable identifiers.
+That gives the whole solution away, so a board that asks for one
+uses up its hints for the puzzle, and boards that can't have
+hints can't have explanations.  The same goes for asking for the
+puzzle's solutions outright.
+
+*/
+
+// A hintResponse is a hint, plus how many more hints the board
+// can get for the puzzle and how many seconds it has to wait for
+// the next one.  The hint's technique is also named in the
+// request's language (see locale.go).
+type hintResponse struct {
+	Hint          puzzle.Hint `json:"hint"`
+	TechniqueName string      `json:"techniqueName,omitempty"`
+	Remaining     int         `json:"remaining"`
+	Cooldown      float64     `json:"cooldown"`
+}
+
+// An explainedStep is a step of an explanation, with its
+// technique named in the request's language.
+type explainedStep struct {
+	puzzle.Step
+	TechniqueName string `json:"techniqueName"`
+}
+
+// techniqueName returns the name of a technique in a request's
+// language, or "" if there's no technique.
+func techniqueName(technique string, r *http.Request) string {
+	if technique == "" {
+		return ""
+	}
+	return puzzle.TechniqueName(technique, r.Header.Get("Accept-Language"))
+}
+
+// hintPolicy returns the live hint cooldown and limit.
+func hintPolicy() (time.Duration, int) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return hintCooldown, hintLimit
+}
+
+// hintHandler handles GET /api/hint/, which gives a hint for the
+// board's puzzle, if the hint policy allows it.  Contest and
+// unassisted boards don't get hints, since their puzzles aren't
+// revealed.  The hint has the detail the session's preferences
+// ask for (see prefs.go).
+func (session *susenSession) hintHandler(w http.ResponseWriter, r *http.Request) {
+	cooldown, limit := hintPolicy()
+	remaining := limit - session.stats.Hints
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-Hints-Remaining", strconv.Itoa(remaining))
+	if remaining == 0 {
+		sendError(w, http.StatusForbidden, requestError("No hints are left for this puzzle"))
+		return
+	}
+	if wait := time.Until(session.lastHint.Add(cooldown)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		sendError(w, http.StatusTooManyRequests, requestError("Hints are cooling down"))
+		return
+	}
+	if refuseSolverFault(w) {
+		return
+	}
+	hint, e := puzzle.Suggest(session.steps[len(session.steps)-1])
+	if e != nil {
+		sendSolverError(w, http.StatusBadRequest, e)
+		return
+	}
+	session.stats.Hints++
+	session.chargeScore(scoreHint, 1)
+	session.stats.hinted = hint.Choice.Index
+	session.lastHint = time.Now()
+	remaining--
+	w.Header().Set("X-Hints-Remaining", strconv.Itoa(remaining))
+	log.Printf("Gave session %v a hint for puzzle %q (%d left).", session.sessionID, session.puzzleID, remaining)
+	hint = session.preferences().shapeHint(hint)
+	sendJSON(w, http.StatusOK, hintResponse{hint, techniqueName(hint.Technique, r), remaining, cooldown.Seconds()})
+}
+
+// explainHandler handles GET /api/explain/, which gives the steps
+// of solving the board's puzzle from where it is now, and uses up
+// the board's hints.  Boards can't have one when hints are
+// turned off (with a limit of 0).  Explanation is metered as
+// analysis, and the board is unlocked while it's explained.
+// Contest and unassisted boards can't be explained, since their
+// puzzles aren't revealed.
+func (session *susenSession) explainHandler(w http.ResponseWriter, r *http.Request) {
+	_, limit := hintPolicy()
+	if limit == 0 {
+		sendError(w, http.StatusForbidden, requestError("Hints are turned off"))
+		return
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) || refuseSolverFault(w) {
+		return
+	}
+	var steps []puzzle.Step
+	var e error
+	session.unlocked(func(step puzzle.Puzzle) {
+		steps, e = puzzle.ExplainContext(r.Context(), step)
+	})
+	if e != nil {
+		sendSolverError(w, http.StatusBadRequest, e)
+		return
+	}
+	if session.stats.Hints < limit {
+		session.chargeScore(scoreHint, limit-session.stats.Hints)
+		session.stats.Hints = limit
+	}
+	explained := make([]explainedStep, len(steps))
+	for i, step := range steps {
+		explained[i] = explainedStep{step, techniqueName(step.Technique, r)}
+	}
+	log.Printf("Explained puzzle %q to session %v in %d steps.", session.puzzleID, session.sessionID, len(steps))
+	sendJSON(w, http.StatusOK, explained)
+}
+
+// solutionsHandler handles GET /api/solutions/, which gives the
+// solutions of the board's puzzle, and uses up the board's hints
+// just as an explanation does.  Solving is metered as analysis,
+// and the board is unlocked while it's solved.
+func (session *susenSession) solutionsHandler(w http.ResponseWriter, r *http.Request) {
+	if session.refuseSolutions(w, r) {
+		return
+	}
+	session.unlocked(func(step puzzle.Puzzle) {
+		puzzle.SolutionsHandler(step, w, r)
+	})
+}
+
+// refuseSolutions tells whether the board can't be given its
+// puzzle's solutions, having sent the error response if so.  If
+// it can, its hints are used up.
+func (session *susenSession) refuseSolutions(w http.ResponseWriter, r *http.Request) bool {
+	_, limit := hintPolicy()
+	if limit == 0 {
+		sendError(w, http.StatusForbidden, requestError("Hints are turned off"))
+		return true
+	}
+	if session.contest || session.unassisted {
+		sendError(w, http.StatusForbidden, requestError("Contest and unassisted boards can't be solved"))
+		return true
+	}
+	if !takeQuota(w, quotaKey(r, session), quotaAnalyze) || refuseSolverFault(w) {
+		return true
+	}
+	if session.stats.Hints < limit {
+		session.chargeScore(scoreHint, limit-session.stats.Hints)
+		session.stats.Hints = limit
+	}
+	log.Printf("Solved puzzle %q for session %v.", session.puzzleID, session.sessionID)
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// helperHint asks for a hint, returning the status, the hints
+// remaining header, and (if successful) the response.
+func helperHint(t *testing.T, srv *httptest.Server) (int, string, hintResponse) {
+	r, e := http.Get(srv.URL + "/api/hint/")
+	if e != nil {
+		t.Fatalf("Hint request error: %v", e)
+	}
+	defer r.Body.Close()
+	var hr hintResponse
+	if r.StatusCode == http.StatusOK {
+		if e := json.NewDecoder(r.Body).Decode(&hr); e != nil {
+			t.Fatalf("Hint decode error: %v", e)
+		}
+	}
+	return r.StatusCode, r.Header.Get("X-Hints-Remaining"), hr
+}
+
+func TestHintPolicy(t *testing.T) {
+	saved := currentConfig()
+	defer func() { applyConfig(configUpdate{HintCooldown: &saved.HintCooldown, HintLimit: &saved.HintLimit}) }()
+	cooldown, limit := 0, 2
+	applyConfig(configUpdate{HintCooldown: &cooldown, HintLimit: &limit})
+	session := newSession("test-hints")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	status, remaining, hr := helperHint(t, srv)
+	if status != http.StatusOK || remaining != "1" || hr.Remaining != 1 || hr.Hint.Choice.Index == 0 {
+		t.Fatalf("First hint gave %d, %q, %+v", status, remaining, hr)
+	}
+	if status := helperRoomAssign(t, srv, hr.Hint.Choice); status != http.StatusOK {
+		t.Errorf("Assigning the hint gave status %d", status)
+	}
+	helperHint(t, srv)
+	if status, remaining, _ := helperHint(t, srv); status != http.StatusForbidden || remaining != "0" {
+		t.Errorf("Hint over the limit gave %d, %q", status, remaining)
+	}
+	if session.stats.Hints != 2 {
+		t.Errorf("Board stats count %d hints", session.stats.Hints)
+	}
+
+	// starting over gives more hints, but not during the cooldown
+	cooldown = 60
+	applyConfig(configUpdate{HintCooldown: &cooldown})
+	r, e := http.Get(srv.URL + "/api/reset/")
+	if e != nil {
+		t.Fatalf("Reset request error: %v", e)
+	}
+	r.Body.Close()
+	r, e = http.Get(srv.URL + "/api/hint/")
+	if e != nil {
+		t.Fatalf("Hint request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusTooManyRequests || r.Header.Get("Retry-After") == "" || r.Header.Get("X-Hints-Remaining") != "2" {
+		t.Errorf("Hint during cooldown gave %d, %v", r.StatusCode, r.Header)
+	}
+}
+
+func TestExplainHandler(t *testing.T) {
+	session := newSession("test-explain")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	r, e := http.Get(srv.URL + "/api/explain/")
+	if e != nil {
+		t.Fatalf("Explain request error: %v", e)
+	}
+	defer r.Body.Close()
+	var steps []puzzle.Step
+	if e := json.NewDecoder(r.Body).Decode(&steps); e != nil || r.StatusCode != http.StatusOK {
+		t.Fatalf("Explain gave %d, %v", r.StatusCode, e)
+	}
+	if len(steps) == 0 || len(steps[0].Placed)+len(steps[0].Removed) == 0 {
+		t.Errorf("Explain gave steps %+v", steps)
+	}
+	if _, limit := hintPolicy(); session.stats.Hints != limit {
+		t.Errorf("Explaining left %d hints used, expected %d", session.stats.Hints, limit)
+	}
+	if status, _, _ := helperHint(t, srv); status != http.StatusForbidden {
+		t.Errorf("Hint after explanation gave %d", status)
+	}
+
+	// boards without hints can't have explanations either
+	saved := currentConfig()
+	defer func() { applyConfig(configUpdate{HintLimit: &saved.HintLimit}) }()
+	none := 0
+	applyConfig(configUpdate{HintLimit: &none})
+	r2, e := http.Get(srv.URL + "/api/explain/")
+	if e != nil {
+		t.Fatalf("Explain request error: %v", e)
+	}
+	r2.Body.Close()
+	if r2.StatusCode != http.StatusForbidden {
+		t.Errorf("Explain without hints gave %d", r2.StatusCode)
+	}
+}
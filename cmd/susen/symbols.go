@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+)
+
+/*
+
+Symbols
+
+Boards can show their values as symbols (say the letters of a
+word, for wordoku) instead of digits.  GET /api/symbols/ gives
+the board's symbols, in value order, and POST /api/symbols/
+takes a list of them, one for each value, to use from then on;
+an empty list goes back to digits.  POST /api/assign-symbol/
+takes a choice of a square index and a symbol, instead of a
+value, and is otherwise just like /api/assign/.
+
+Entries are checked against the board's symbols on the server,
+after normalizing case and accents (see puzzle.SymbolTable), so
+clients can send what players type, and get back an error that
+says what was wrong with it.  The symbols stay with the board
+until a puzzle of another size is started on it.
+
+*/
+
+// symbolsHandler handles GET and POST /api/symbols/, responding
+// with the board's symbols.
+func (session *susenSession) symbolsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var symbols []string
+		if e := decodeRequest(w, r, &symbols); e != nil {
+			sendError(w, requestStatus(e), requestError("Can't decode the symbols: "+e.Error()))
+			return
+		}
+		if len(symbols) == 0 {
+			session.symbols = nil
+		} else {
+			if n := session.steps[0].State().SideLenth; len(symbols) != n {
+				sendError(w, http.StatusBadRequest, requestError("The puzzle needs one symbol for each of its values"))
+				return
+			}
+			table, e := puzzle.NewSymbolTable(symbols)
+			if e != nil {
+				err := e.(puzzle.Error)
+				err.Message = err.Error()
+				sendError(w, http.StatusBadRequest, err)
+				return
+			}
+			session.symbols = table
+		}
+	}
+	symbols := []string{}
+	if session.symbols != nil {
+		symbols = session.symbols.Symbols()
+	}
+	sendJSON(w, http.StatusOK, symbols)
+}
+
+// keepSymbols drops the board's symbols if they don't fit the
+// side length of its puzzle.
+func (board *susenBoard) keepSymbols() {
+	if board.symbols != nil && len(board.symbols.Symbols()) != board.steps[0].State().SideLenth {
+		board.symbols = nil
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSymbols(t *testing.T) {
+	session := newSession("test-symbols")
+	srv := helperUserServer(session)
+	defer srv.Close()
+	word := []string{"S", "U", "D", "O", "K", "É", "W", "R", "T"}
+
+	var symbols []string
+	if status := helperUserRequest(t, srv, "", "GET", "/api/symbols/", nil, &symbols); status != http.StatusOK || len(symbols) != 0 {
+		t.Errorf("New board has symbols %v, status %d", symbols, status)
+	}
+	for i, bad := range [][]string{word[:8], {"S", "U", "D", "O", "K", "É", "W", "R", "s"}, {"S", "U", "D", "O", "K", "É", "W", "R", "TT"}} {
+		if status := helperUserRequest(t, srv, "", "POST", "/api/symbols/", bad, nil); status != http.StatusBadRequest {
+			t.Errorf("test %d: Setting symbols %v gave status %d", i+1, bad, status)
+		}
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/symbols/", word, &symbols); status != http.StatusOK ||
+		!reflect.DeepEqual(symbols, word) {
+		t.Fatalf("Setting symbols gave %v, status %d", symbols, status)
+	}
+
+	// entries are matched to the symbols
+	index := 0
+	for i, v := range puzzleValues[defaultPuzzleID][1:] {
+		if v == 0 {
+			index = i + 1
+			break
+		}
+	}
+	solution := session.steps[0].Solutions()[0].Values
+	var update puzzle.Update
+	choice := puzzle.SymbolChoice{Index: index, Symbol: " é"}
+	if solution[index-1] != 6 {
+		choice.Symbol = word[solution[index-1]-1]
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign-symbol/", choice, &update); status != http.StatusOK ||
+		len(session.steps) != 2 || session.steps[1].State().Values[index-1] != solution[index-1] {
+		t.Errorf("Assigning %+v gave status %d, update %+v", choice, status, update)
+	}
+	choice.Symbol = "Q"
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign-symbol/", choice, nil); status != http.StatusBadRequest ||
+		len(session.steps) != 2 {
+		t.Errorf("Assigning an unknown symbol gave status %d", status)
+	}
+
+	// symbols are checkpointed, and go with a puzzle of another size
+	c, _ := session.checkpoint()
+	if restored, e := c.restore("test-symbols-restored"); e != nil || restored.symbols == nil ||
+		!reflect.DeepEqual(restored.symbols.Symbols(), word) {
+		t.Errorf("Restored session has symbols %v, %v", restored.symbols, e)
+	}
+	session.reset("2-star")
+	if session.symbols == nil {
+		t.Errorf("Symbols were dropped for a puzzle of the same size")
+	}
+	session.start("test-symbols-small", append([]int{puzzle.SudokuGeometryCode}, make([]int, 16)...))
+	if session.symbols != nil {
+		t.Errorf("Symbols were kept for a puzzle of another size")
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/assign-symbol/", choice, nil); status != http.StatusBadRequest {
+		t.Errorf("Assigning a symbol without symbols gave status %d", status)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+Timer
+
+A board's clock starts when its puzzle does, and its solve time
+(in its statistics, and on leaderboards) is the time the clock
+ran until the puzzle was completed.  Players can stop the clock
+when they step away:
+
+- GET /api/timer gives the state of the board's clock
+
+- POST /api/timer/start starts the clock over, for a board that
+hasn't had any moves yet (say, one that was opened and left)
+
+- POST /api/timer/pause stops the clock
+
+- POST /api/timer/resume starts it again
+
+The server keeps the clock, so its time is the one that counts.
+The clock's state is part of the board's statistics, so it's
+kept in checkpoints with the rest of the session (see
+shutdown.go).  A move made while the clock is stopped starts it
+again, so pausing can't hide play.  Completed boards, and blitz
+and race boards (whose clocks are shared deadlines), can't be
+paused.
+
+*/
+
+// A timerStatus is the state of a board's clock.
+type timerStatus struct {
+	Started time.Time  `json:"started"`
+	Paused  *time.Time `json:"paused,omitempty"` // when the clock was stopped, if it is
+	Elapsed float64    `json:"elapsed"`          // seconds the clock has run
+	Idle    float64    `json:"idle,omitempty"`   // seconds the clock has been stopped
+	Running bool       `json:"running"`
+}
+
+// elapsed returns how long the board's clock has run, as of the
+// given time.  It must be called with the board locked.
+func (board *susenBoard) elapsed(now time.Time) time.Duration {
+	return now.Sub(board.stats.Started) - board.idle(now)
+}
+
+// idle returns how long the board's clock has been stopped, as of
+// the given time.
+func (board *susenBoard) idle(now time.Time) time.Duration {
+	idle := time.Duration(board.stats.IdleTime * float64(time.Second))
+	if board.stats.Paused != nil {
+		idle += now.Sub(*board.stats.Paused)
+	}
+	return idle
+}
+
+// resumeTimer starts the board's clock again if it's stopped.
+func (board *susenBoard) resumeTimer() {
+	if board.stats.Paused == nil {
+		return
+	}
+	board.stats.IdleTime = board.idle(time.Now()).Seconds()
+	board.stats.Paused = nil
+}
+
+// timerStatus returns the state of the board's clock.
+func (board *susenBoard) timerStatus() timerStatus {
+	now := time.Now()
+	if board.stats.Completed != nil {
+		now = *board.stats.Completed
+	}
+	return timerStatus{
+		Started: board.stats.Started,
+		Paused:  board.stats.Paused,
+		Elapsed: board.elapsed(now).Seconds(),
+		Idle:    board.idle(now).Seconds(),
+		Running: board.stats.Paused == nil && board.stats.Completed == nil,
+	}
+}
+
+// timerHandler handles the timer endpoints.
+func (session *susenSession) timerHandler(w http.ResponseWriter, r *http.Request) {
+	op := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/timer"), "/")
+	if op == "" {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			sendError(w, http.StatusMethodNotAllowed, requestError("The timer can only be read"))
+			return
+		}
+		sendJSON(w, http.StatusOK, session.timerStatus())
+		return
+	}
+	if op != "start" && op != "pause" && op != "resume" {
+		sendError(w, http.StatusNotFound, requestError("Unknown timer operation"))
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		sendError(w, http.StatusMethodNotAllowed, requestError("The timer is changed with POST"))
+		return
+	}
+	switch {
+	case session.stats.Completed != nil:
+		sendError(w, http.StatusConflict, requestError("The puzzle is already completed"))
+		return
+	case session.blitz != nil || session.race != nil:
+		sendError(w, http.StatusConflict, requestError("Blitz and race boards keep their own time"))
+		return
+	}
+	switch op {
+	case "start":
+		if session.stats.Assignments > 0 || session.stats.Undos > 0 {
+			sendError(w, http.StatusConflict, requestError("The board has already been played"))
+			return
+		}
+		session.stats.Started = time.Now()
+		session.stats.Paused, session.stats.IdleTime = nil, 0
+	case "pause":
+		if session.stats.Paused == nil {
+			now := time.Now()
+			session.stats.Paused = &now
+		}
+	case "resume":
+		session.resumeTimer()
+	}
+	log.Printf("Timer %s for session %v on puzzle %q.", op, session.sessionID, session.puzzleID)
+	sendJSON(w, http.StatusOK, session.timerStatus())
+}
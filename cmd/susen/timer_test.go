@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	session := newSession("test-timer")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var ts timerStatus
+	if status := helperGetJSON(t, srv, "/api/timer", &ts); status != http.StatusOK || !ts.Running || ts.Paused != nil {
+		t.Fatalf("New board's timer gave %d, %+v", status, ts)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/timer/start", nil, &ts); status != http.StatusOK {
+		t.Errorf("Start of an unplayed board gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/timer/rewind", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Unknown timer operation gave status %d", status)
+	}
+
+	// a pause doesn't count, and is kept in checkpoints
+	if status := helperUserRequest(t, srv, "", "POST", "/api/timer/pause", nil, &ts); status != http.StatusOK ||
+		ts.Running || ts.Paused == nil {
+		t.Fatalf("Pause gave %d, %+v", status, ts)
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	session.stats.Started, session.stats.Paused = hourAgo.Add(-time.Minute), &hourAgo
+	c, ok := session.checkpoint()
+	restored, e := c.restore("test-timer-restored")
+	if !ok || e != nil || restored.stats.Paused == nil || !restored.stats.Paused.Equal(hourAgo) {
+		t.Fatalf("Restored timer is %+v (%v, %v)", restored.stats, ok, e)
+	}
+	helperUserRequest(t, srv, "", "POST", "/api/timer/resume", nil, &ts)
+	if !ts.Running || ts.Idle < 3599 || ts.Elapsed < 59 || ts.Elapsed > 61 {
+		t.Errorf("Resumed timer is %+v", ts)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/timer/start", nil, nil); status != http.StatusOK {
+		t.Errorf("Restart of an unplayed board gave status %d", status)
+	}
+
+	// moves start a stopped clock, and the solve time is the clock's
+	session.stats.Started = time.Now().Add(-2 * time.Hour)
+	helperUserRequest(t, srv, "", "POST", "/api/timer/pause", nil, nil)
+	session.stats.Paused = &hourAgo
+	helperUserRequest(t, srv, "", "POST", "/api/autofill", nil, nil)
+	if session.stats.Completed == nil || session.stats.Paused != nil ||
+		session.stats.SolveTime < 3599 || session.stats.SolveTime > 3601 {
+		t.Fatalf("Solve after a pause took %vs, stats %+v", session.stats.SolveTime, session.stats)
+	}
+	var lb leaderboard
+	helperGetJSON(t, srv, "/api/leaderboard/"+defaultPuzzleID, &lb)
+	if len(lb.Entries) != 1 || lb.Entries[0].SolveTime != session.stats.SolveTime {
+		t.Errorf("Leaderboard after a paused solve is %+v", lb)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/timer/pause", nil, nil); status != http.StatusConflict {
+		t.Errorf("Pause of a completed board gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "", "POST", "/api/timer/start", nil, nil); status != http.StatusConflict {
+		t.Errorf("Start of a played board gave status %d", status)
+	}
+}
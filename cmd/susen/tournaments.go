@@ -0,0 +1,420 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Tournaments
+
+A tournament is a race (see races.go) that's run on a schedule
+by the server rather than by a host.  A setter announces it with
+a puzzle and a start time, and sessions register for it until it
+starts.  The puzzle is kept hidden until then: at the start time
+the server starts every registered player's board on it at once,
+with no handicaps.  The tournament ends when every player has
+finished, or when its time is up, whichever comes first; players
+who haven't finished by then did not finish (DNF).
+
+While it's on, the tournament's standings are live: players who
+have finished are placed by their times, and those still solving
+by how many squares they've filled.  Registered players get race
+events (see events.go) when it starts, whenever a player
+finishes, and when it ends.  The final results are kept in the
+store.
+
+- POST /api/tournaments/ announces a tournament (for setters),
+taking a JSON tournamentRequest
+
+- GET /api/tournaments/ lists the tournaments that haven't ended
+
+- GET /api/tournaments/<code> gives a tournament's standings
+
+- POST /api/tournaments/<code>/register puts the session in a
+tournament that hasn't started
+
+- POST /api/tournaments/<code>/withdraw takes the session out of
+a tournament that hasn't started (players can leave a tournament
+that's on as they leave any race)
+
+- GET /api/tournaments/<code>/results gives a tournament's final
+results, once it's ended.
+
+*/
+
+// The limits on when tournaments start and how long they last.
+const (
+	maxTournamentLead         = 7 * 24 * time.Hour
+	maxTournamentDuration     = 24 * time.Hour
+	defaultTournamentDuration = time.Hour
+)
+
+// tournamentKind is the storage kind for tournament results.
+const tournamentKind = "tournament-result"
+
+// The statuses of tournament players.
+const (
+	registeredStatus = "registered"
+	solvingStatus    = "solving"
+	finishedStatus   = "finished"
+	dnfStatus        = "dnf"
+)
+
+// A tournamentRequest announces a tournament.
+type tournamentRequest struct {
+	Title    string `json:"title"`
+	PuzzleID string `json:"puzzleID"`
+	StartIn  int    `json:"startIn"`  // seconds from now
+	Duration int    `json:"duration"` // seconds, or 0 for the default
+}
+
+// A susenTournament is a scheduled race.  Like its race, it's
+// guarded by raceMutex.
+type susenTournament struct {
+	race      *susenRace
+	title     string
+	organizer string
+	starts    time.Time
+	duration  time.Duration
+	results   *tournamentStandings // once it's ended
+}
+
+// A tournamentStanding is a player's place in a tournament.
+type tournamentStanding struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	Place     int        `json:"place,omitempty"`
+	Filled    int        `json:"filled"` // squares filled so far
+	Finished  *time.Time `json:"finished,omitempty"`
+	SolveTime float64    `json:"solveTime,omitempty"` // seconds from the start
+}
+
+// tournamentStandings describe a tournament, as it stands or as it
+// ended.  The puzzle is only given once the tournament has
+// started, and the player's own position (You) only to registered
+// players.
+type tournamentStandings struct {
+	Code      string               `json:"code"`
+	Title     string               `json:"title"`
+	Organizer string               `json:"organizer"`
+	PuzzleID  string               `json:"puzzleID,omitempty"`
+	Starts    time.Time            `json:"starts"`
+	StartsIn  float64              `json:"startsIn,omitempty"` // seconds, until it starts
+	Ends      time.Time            `json:"ends"`
+	Squares   int                  `json:"squares"` // to be filled by each player
+	Ended     bool                 `json:"ended"`
+	You       *int                 `json:"you,omitempty"`
+	Players   []tournamentStanding `json:"players"`
+}
+
+// tournaments are all the tournaments announced since the server
+// started, by code, guarded by raceMutex.
+var tournaments = make(map[string]*susenTournament)
+
+// tournamentValues returns the values of a puzzle that
+// tournaments can be run on.
+func tournamentValues(puzzleID string) ([]int, bool) {
+	if date, ok := parseDailyID(puzzleID); ok {
+		return dailyValues(date), true
+	}
+	if vals, ok := seedValues(puzzleID); ok {
+		return vals, true
+	}
+	return lookupPuzzle(puzzleID)
+}
+
+// announceTournament makes a tournament and schedules its start
+// and end.
+func announceTournament(req tournamentRequest, organizer string, vals []int) *susenTournament {
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+	var race *susenRace
+	for {
+		// tournament codes are race codes
+		roomMutex.Lock()
+		code := newRoomCode()
+		roomMutex.Unlock()
+		if _, ok := races[code]; !ok {
+			race = &susenRace{code: code, puzzleID: req.PuzzleID, values: vals}
+			break
+		}
+	}
+	t := &susenTournament{race: race, title: req.Title, organizer: organizer,
+		starts: time.Now().Add(time.Duration(req.StartIn) * time.Second), duration: defaultTournamentDuration}
+	if req.Duration > 0 {
+		t.duration = time.Duration(req.Duration) * time.Second
+	}
+	if t.title == "" {
+		t.title = "Tournament " + race.code
+	}
+	race.tournament = t
+	races[race.code] = race
+	tournaments[race.code] = t
+	time.AfterFunc(time.Until(t.starts), race.begin)
+	time.AfterFunc(time.Until(t.starts.Add(t.duration)), t.end)
+	log.Printf("%v announced tournament %v on puzzle %q, starting at %v.", organizer, race.code, req.PuzzleID, t.starts)
+	return t
+}
+
+// standings gives the tournament as it stands, as the session
+// sees it.  Players' boards are locked in turn to see how far
+// they've got, so it must be called with no board locked.
+func (t *susenTournament) standings(session *susenSession) tournamentStandings {
+	raceMutex.Lock()
+	if t.results != nil {
+		s := *t.results
+		raceMutex.Unlock()
+		return s
+	}
+	race := t.race
+	s := tournamentStandings{Code: race.code, Title: t.title, Organizer: t.organizer, Starts: t.starts,
+		Ends: t.starts.Add(t.duration), Players: []tournamentStanding{}}
+	started := !race.start.IsZero()
+	if started {
+		s.PuzzleID = race.puzzleID
+	} else if wait := time.Until(t.starts); wait > 0 {
+		s.StartsIn = wait.Seconds()
+	}
+	for _, v := range race.values[1:] {
+		if v == 0 {
+			s.Squares++
+		}
+	}
+	players := append([]*racePlayer(nil), race.players...)
+	for i, p := range players {
+		_, name := p.session.player()
+		st := tournamentStanding{Name: name, Status: registeredStatus}
+		if p.finished != nil {
+			st.Status, st.Finished, st.Filled = finishedStatus, p.finished, s.Squares
+			st.SolveTime = p.finished.Sub(race.start).Seconds()
+		} else if !p.start.IsZero() {
+			st.Status = solvingStatus
+		}
+		if p.session == session {
+			you := i
+			s.You = &you
+		}
+		s.Players = append(s.Players, st)
+	}
+	raceMutex.Unlock()
+
+	for i, p := range players {
+		if s.Players[i].Status != solvingStatus {
+			continue
+		}
+		p.session.lockBoard()
+		if p.session.race == race && p.session.puzzleID == race.puzzleID {
+			s.Players[i].Filled = p.session.summary().Filled
+		}
+		p.session.unlockBoard()
+	}
+	if started {
+		s.place()
+	}
+	return s
+}
+
+// place gives the players their places: finishers by their times,
+// and then the others by how many squares they've filled.
+func (s *tournamentStandings) place() {
+	order := make([]int, len(s.Players))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		pa, pb := s.Players[order[a]], s.Players[order[b]]
+		if (pa.Finished != nil) != (pb.Finished != nil) {
+			return pa.Finished != nil
+		}
+		if pa.Finished != nil {
+			return pa.Finished.Before(*pb.Finished)
+		}
+		return pa.Filled > pb.Filled
+	})
+	for place, i := range order {
+		s.Players[i].Place = place + 1
+	}
+}
+
+// finishedAll tells whether all of the tournament's players have
+// finished.  It must be called with the races locked.
+func (t *susenTournament) finishedAll() bool {
+	for _, p := range t.race.players {
+		if p.finished == nil {
+			return false
+		}
+	}
+	return !t.race.start.IsZero()
+}
+
+// end ends the tournament, if it hasn't ended: it records the
+// results, and takes the players' boards out of its race.  It must
+// be called with no board locked.
+func (t *susenTournament) end() {
+	results := t.standings(nil)
+	raceMutex.Lock()
+	if t.results != nil {
+		raceMutex.Unlock()
+		return
+	}
+	results.Ended, results.StartsIn, results.You = true, 0, nil
+	results.PuzzleID = t.race.puzzleID
+	for i := range results.Players {
+		if results.Players[i].Status != finishedStatus {
+			results.Players[i].Status = dnfStatus
+		}
+	}
+	t.results = &results
+	delete(races, t.race.code)
+	players := append([]*racePlayer(nil), t.race.players...)
+	raceMutex.Unlock()
+
+	if e := store.Put(tournamentKind, results.Code, results); e != nil {
+		log.Printf("Can't store results of tournament %v: %v", results.Code, e)
+	}
+	for _, p := range players {
+		p.session.lockBoard()
+		if p.session.race == t.race {
+			p.session.race = nil
+		}
+		p.session.unlockBoard()
+		p.session.notify(sessionEvent{Type: raceEventType})
+	}
+	log.Printf("Tournament %v ended.", results.Code)
+}
+
+// tournamentHandler handles the tournament endpoints (see above).
+func (session *susenSession) tournamentHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tournaments/"), "/"), "/", 2)
+	if parts[0] == "" {
+		if r.Method == "POST" {
+			auth.RequireRole(roles, auth.RoleSetter, http.HandlerFunc(createTournament)).ServeHTTP(w, r)
+		} else {
+			listTournaments(w)
+		}
+		return
+	}
+	code := strings.ToUpper(parts[0])
+	op := ""
+	if len(parts) > 1 {
+		op = parts[1]
+	}
+	raceMutex.Lock()
+	t, ok := tournaments[code]
+	raceMutex.Unlock()
+	if !ok && op == "results" && r.Method == "GET" {
+		var results tournamentStandings
+		if found, e := store.Get(tournamentKind, code, &results); e != nil {
+			sendError(w, http.StatusInternalServerError, requestError("Can't read tournament results: "+e.Error()))
+			return
+		} else if found {
+			sendJSON(w, http.StatusOK, results)
+			return
+		}
+	}
+	if !ok {
+		sendError(w, http.StatusNotFound, requestError("No tournament with code "+code))
+		return
+	}
+	switch {
+	case op == "" && r.Method == "GET":
+	case op == "results" && r.Method == "GET":
+		raceMutex.Lock()
+		results := t.results
+		raceMutex.Unlock()
+		if results == nil {
+			sendError(w, http.StatusConflict, requestError("Tournament "+code+" hasn't ended"))
+			return
+		}
+		sendJSON(w, http.StatusOK, *results)
+		return
+	case op == "register" && r.Method == "POST":
+		session.lockBoard()
+		status, e := http.StatusOK, error(nil)
+		if session.room != nil {
+			status, e = http.StatusConflict, requestError("Rooms can't race; leave the room first")
+		} else if session.race != t.race {
+			session.leaveRace()
+			status, e = session.joinRace(code)
+		}
+		session.unlockBoard()
+		if e != nil {
+			sendError(w, status, e.(puzzle.Error))
+			return
+		}
+	case op == "withdraw" && r.Method == "POST":
+		session.lockBoard()
+		status, e := http.StatusOK, error(nil)
+		if session.race == t.race {
+			raceMutex.Lock()
+			started := !t.race.start.IsZero()
+			raceMutex.Unlock()
+			if started {
+				status, e = http.StatusConflict, requestError("Tournament "+code+" has already started; leave the race instead")
+			} else {
+				session.leaveRace()
+			}
+		}
+		session.unlockBoard()
+		if e != nil {
+			sendError(w, status, e.(puzzle.Error))
+			return
+		}
+	default:
+		sendError(w, http.StatusNotFound, requestError("Unknown tournament operation: "+r.Method+" "+op))
+		return
+	}
+	sendJSON(w, http.StatusOK, t.standings(session))
+}
+
+// createTournament announces a tournament, as requested by a
+// setter.
+func createTournament(w http.ResponseWriter, r *http.Request) {
+	var req tournamentRequest
+	if e := decodeRequest(w, r, &req); e != nil {
+		sendError(w, requestStatus(e), requestError("Invalid tournament: "+e.Error()))
+		return
+	}
+	vals, ok := tournamentValues(req.PuzzleID)
+	if !ok {
+		sendError(w, http.StatusBadRequest, fieldError("puzzleID", "No puzzle with ID "+strconv.Quote(req.PuzzleID)))
+		return
+	}
+	if req.StartIn < 0 || time.Duration(req.StartIn)*time.Second > maxTournamentLead {
+		sendError(w, http.StatusBadRequest, fieldError("startIn", "Tournaments can start up to "+maxTournamentLead.String()+" from now"))
+		return
+	}
+	if req.Duration < 0 || time.Duration(req.Duration)*time.Second > maxTournamentDuration {
+		sendError(w, http.StatusBadRequest, fieldError("duration", "Tournaments can last up to "+maxTournamentDuration.String()))
+		return
+	}
+	t := announceTournament(req, auth.FromRequest(r).Name, vals)
+	sendJSON(w, http.StatusOK, t.standings(nil))
+}
+
+// listTournaments lists the tournaments that haven't ended, in
+// the order they start.
+func listTournaments(w http.ResponseWriter) {
+	raceMutex.Lock()
+	var current []*susenTournament
+	for _, t := range tournaments {
+		if t.results == nil {
+			current = append(current, t)
+		}
+	}
+	raceMutex.Unlock()
+	sort.Slice(current, func(i, j int) bool { return current[i].starts.Before(current[j].starts) })
+	list := []tournamentStandings{}
+	for _, t := range current {
+		list = append(list, t.standings(nil))
+	}
+	sendJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTournaments(t *testing.T) {
+	saved := store
+	store = storage.NewMemory()
+	defer func() { store = saved }()
+	roles.Grant("header:setter", auth.RoleSetter)
+	defer roles.Revoke("header:setter", auth.RoleSetter)
+	first, second := newSession("test-tournament-first"), newSession("test-tournament-second")
+	first.reset("2-star")
+	fsrv, ssrv := helperUserServer(first), helperUserServer(second)
+	defer fsrv.Close()
+	defer ssrv.Close()
+
+	// only setters announce tournaments, on puzzles that exist
+	req := tournamentRequest{Title: "Friday night", PuzzleID: defaultPuzzleID, StartIn: 1, Duration: 60}
+	if status := helperUserRequest(t, fsrv, "player", "POST", "/api/tournaments/", req, nil); status != http.StatusForbidden {
+		t.Errorf("Announcement by a player gave status %d", status)
+	}
+	bad := tournamentRequest{PuzzleID: "no-such-puzzle"}
+	if status := helperUserRequest(t, fsrv, "setter", "POST", "/api/tournaments/", bad, nil); status != http.StatusBadRequest {
+		t.Errorf("Announcement on a missing puzzle gave status %d", status)
+	}
+	var announced tournamentStandings
+	if status := helperUserRequest(t, fsrv, "setter", "POST", "/api/tournaments/", req, &announced); status != http.StatusOK ||
+		announced.Code == "" || announced.PuzzleID != "" || announced.StartsIn <= 0 || announced.Organizer != "setter" {
+		t.Fatalf("Announcement gave %d, %+v", status, announced)
+	}
+	path := "/api/tournaments/" + announced.Code
+	var list []tournamentStandings
+	if status := helperGetJSON(t, fsrv, "/api/tournaments/", &list); status != http.StatusOK || len(list) != 1 {
+		t.Errorf("Tournament list gave %d, %+v", status, list)
+	}
+
+	// sessions register, and can't see the puzzle or start the race
+	var registered tournamentStandings
+	for _, srv := range []string{fsrv.URL, ssrv.URL} {
+		if r, e := http.Post(srv+path+"/register", "application/json", nil); e != nil {
+			t.Fatalf("Register request error: %v", e)
+		} else if r.Body.Close(); r.StatusCode != http.StatusOK {
+			t.Fatalf("Register gave status %d", r.StatusCode)
+		}
+	}
+	if status := helperUserRequest(t, ssrv, "", "GET", path, nil, &registered); status != http.StatusOK ||
+		len(registered.Players) != 2 || registered.You == nil || *registered.You != 1 {
+		t.Fatalf("Standings after registering gave %d, %+v", status, registered)
+	}
+	var info raceInfo
+	if helperGetJSON(t, fsrv, "/api/race/", &info); info.PuzzleID != "" || info.Host {
+		t.Errorf("Tournament race before the start is %+v", info)
+	}
+	if status, _ := helperRaceRequest(t, fsrv, "start/", nil); status != http.StatusForbidden {
+		t.Errorf("Starting a tournament gave status %d", status)
+	}
+	if status := helperUserRequest(t, fsrv, "", "GET", path+"/results", nil, nil); status != http.StatusConflict {
+		t.Errorf("Results before the end gave status %d", status)
+	}
+
+	// the puzzle is revealed to both at once
+	helperRaceStarted(t, first)
+	helperRaceStarted(t, second)
+	if first.puzzleID != defaultPuzzleID || second.puzzleID != defaultPuzzleID ||
+		!first.stats.Started.Equal(second.stats.Started) {
+		t.Errorf("Tournament boards started on %q at %v and %q at %v",
+			first.puzzleID, first.stats.Started, second.puzzleID, second.stats.Started)
+	}
+	if r, e := http.Post(ssrv.URL+path+"/withdraw", "application/json", nil); e != nil {
+		t.Fatalf("Withdraw request error: %v", e)
+	} else if r.Body.Close(); r.StatusCode != http.StatusConflict {
+		t.Errorf("Withdraw after the start gave status %d", r.StatusCode)
+	}
+
+	// standings are live
+	helperSolve(t, ssrv, second)
+	var live tournamentStandings
+	if helperGetJSON(t, fsrv, path, &live); live.PuzzleID != defaultPuzzleID || live.Ended ||
+		live.Players[1].Status != finishedStatus || live.Players[1].Place != 1 ||
+		live.Players[0].Status != solvingStatus || live.Players[0].Place != 2 {
+		t.Errorf("Live standings are %+v", live)
+	}
+
+	// it ends when everyone finishes
+	helperSolve(t, fsrv, first)
+	var results tournamentStandings
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if helperGetJSON(t, fsrv, path+"/results", &results) == http.StatusOK {
+			break
+		}
+	}
+	if !results.Ended || len(results.Players) != 2 || results.Players[0].Place != 2 || results.Players[0].SolveTime <= 0 {
+		t.Fatalf("Results are %+v", results)
+	}
+	first.mutex.Lock()
+	inRace := first.race != nil
+	first.mutex.Unlock()
+	if inRace {
+		t.Errorf("Player is still in the tournament's race after it ended")
+	}
+	if helperGetJSON(t, fsrv, "/api/tournaments/", &list); len(list) != 0 {
+		t.Errorf("Tournament list after the end is %+v", list)
+	}
+
+	// results outlive the server's memory of the tournament
+	raceMutex.Lock()
+	delete(tournaments, announced.Code)
+	raceMutex.Unlock()
+	var stored tournamentStandings
+	if status := helperGetJSON(t, fsrv, path+"/results", &stored); status != http.StatusOK || stored.Players[1].Place != 1 {
+		t.Errorf("Stored results gave %d, %+v", status, stored)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Turn-based rooms
+
+A room can be created to take turns: then its members make the
+board's moves (assignments, take-backs, and resets) one at a
+time, in the order they joined, and each has a limited time for
+their turn, which the server keeps.  Pencil marks are notes, so
+anyone can make them at any time.  Making a move passes the turn
+on, and so does skipping it (POST /api/room/turn/skip) or running
+out of time.  A player who runs out of time maxTurnTimeouts turns
+in a row forfeits, and players can forfeit themselves (POST
+/api/room/turn/forfeit): players who've forfeited stay in the room
+to watch, but don't get turns any more.  Players who leave the
+room leave the turns, and players who join it get turns after
+everyone else.
+
+Every change of turn is sent to the members' watchers as a turn
+event, saying whose turn it is, until when, and why it changed.
+The clock stops once the puzzle is solved, and then anyone can
+reset the board, which starts it again.
+
+*/
+
+// The limits of the time for a turn, and the number of turns in a
+// row a player can run out of time on before forfeiting.
+const (
+	minTurnTime     = 10 * time.Second
+	maxTurnTime     = time.Hour
+	maxTurnTimeouts = 3
+)
+
+// Reasons for the turn to change.
+const (
+	turnStart   = "start"   // the room started taking turns
+	turnMove    = "move"    // the player made a move
+	turnSkip    = "skip"    // the player skipped their turn
+	turnTimeout = "timeout" // the player ran out of time
+	turnForfeit = "forfeit" // the player forfeited
+	turnLeave   = "leave"   // the player left the room
+	turnJoin    = "join"    // a player joined the room
+)
+
+// A roomTurns is the turn order of a turn-based room.  Like the
+// room's board, it must only be used with the board locked.
+type roomTurns struct {
+	board    *susenBoard
+	turnTime time.Duration
+	players  []*susenSession // the players who take turns, in order
+	current  int             // the index of the player whose turn it is
+	number   int             // counts the turns, so late timers can tell they're stale
+	deadline time.Time
+	timer    *time.Timer
+	timeouts map[*susenSession]int // turns run out in a row, by player
+}
+
+// A turnStatus is the state of a room's turns, as given to one of
+// its members.
+type turnStatus struct {
+	Players   []string   `json:"players"` // the players' display names, in turn order
+	Current   int        `json:"current"` // the index of the player whose turn it is, or -1
+	Yours     bool       `json:"yours"`
+	Playing   bool       `json:"playing"` // whether the member takes turns
+	TurnTime  float64    `json:"turnTime"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	Remaining float64    `json:"remaining,omitempty"` // seconds
+	Reason    string     `json:"reason,omitempty"`    // why the turn changed, in turn events
+}
+
+// parseTurnTime parses the seconds of a turn given when creating a
+// room, telling whether they're valid.  An empty value means the
+// room doesn't take turns.
+func parseTurnTime(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, true
+	}
+	n, e := strconv.Atoi(v)
+	d := time.Duration(n) * time.Second
+	return d, e == nil && d >= minTurnTime && d <= maxTurnTime
+}
+
+// startTurns makes the board's room take turns, starting with
+// its current members.  It must be called with the board locked.
+func (board *susenBoard) startTurns(turnTime time.Duration) {
+	t := &roomTurns{
+		board:    board,
+		turnTime: turnTime,
+		players:  append([]*susenSession{}, board.members...),
+		timeouts: make(map[*susenSession]int),
+	}
+	board.room.turns = t
+	t.begin(turnStart)
+}
+
+// turns returns the turns of the board's room, if it takes them.
+func (board *susenBoard) turns() *roomTurns {
+	if board.room == nil {
+		return nil
+	}
+	return board.room.turns
+}
+
+// begin starts the current player's turn (if anyone has one),
+// and tells the members why the turn changed.
+func (t *roomTurns) begin(reason string) {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.number++
+	t.deadline = time.Time{}
+	if len(t.players) > 0 && t.board.stats.Completed == nil {
+		number := t.number
+		t.deadline = time.Now().Add(t.turnTime)
+		t.timer = time.AfterFunc(t.turnTime, func() { t.timeUp(number) })
+	}
+	for _, member := range t.board.members {
+		member.notify(sessionEvent{Type: turnEventType, Turn: t.status(member, reason)})
+	}
+}
+
+// player returns the player whose turn it is, if anyone's.
+func (t *roomTurns) player() *susenSession {
+	if len(t.players) == 0 {
+		return nil
+	}
+	return t.players[t.current]
+}
+
+// pass gives the turn to the next player.
+func (t *roomTurns) pass(reason string) {
+	if len(t.players) > 0 {
+		t.current = (t.current + 1) % len(t.players)
+	}
+	t.begin(reason)
+}
+
+// remove takes a player out of the turns, passing the turn on if
+// it was theirs.
+func (t *roomTurns) remove(player *susenSession, reason string) {
+	for i, p := range t.players {
+		if p != player {
+			continue
+		}
+		t.players = append(t.players[:i], t.players[i+1:]...)
+		delete(t.timeouts, player)
+		if i < t.current {
+			t.current--
+		}
+		if t.current >= len(t.players) {
+			t.current = 0
+		}
+		t.begin(reason)
+		return
+	}
+}
+
+// add gives a player who joined the room turns after everyone
+// else's.
+func (t *roomTurns) add(player *susenSession) {
+	t.players = append(t.players, player)
+	if len(t.players) == 1 {
+		t.current = 0
+	}
+	t.begin(turnJoin)
+}
+
+// timeUp is called when a turn runs out, to pass it on (and
+// maybe have its player forfeit) unless it's already over.
+func (t *roomTurns) timeUp(number int) {
+	t.board.mutex.Lock()
+	defer t.board.mutex.Unlock()
+	if t.number != number || t.board.turns() != t || t.board.stats.Completed != nil {
+		return
+	}
+	player := t.player()
+	t.timeouts[player]++
+	if t.timeouts[player] >= maxTurnTimeouts {
+		log.Printf("Session %v forfeited after running out of time %d turns in a row.",
+			player.sessionID, t.timeouts[player])
+		t.remove(player, turnForfeit)
+		return
+	}
+	t.pass(turnTimeout)
+}
+
+// status describes the turns to a member of the room.
+func (t *roomTurns) status(member *susenSession, reason string) *turnStatus {
+	s := &turnStatus{Players: []string{}, Current: -1, TurnTime: t.turnTime.Seconds(), Reason: reason}
+	for _, p := range t.players {
+		_, name := p.player()
+		s.Players = append(s.Players, name)
+		s.Playing = s.Playing || p == member
+	}
+	if player := t.player(); player != nil {
+		s.Current = t.current
+		s.Yours = player == member
+	}
+	if !t.deadline.IsZero() {
+		deadline := t.deadline
+		s.Deadline = &deadline
+		s.Remaining = time.Until(t.deadline).Seconds()
+	}
+	return s
+}
+
+// turnChange tells whether a request would make a move on a
+// board.
+func turnChange(r *http.Request) bool {
+	if strings.Contains(r.URL.Path, "/reset/") || strings.Contains(r.URL.Path, "/back/") {
+		return true
+	}
+	if r.Method != "POST" {
+		return false
+	}
+	for _, note := range []string{"/mark/", "/unmark/", "/verify/", "/submit/"} {
+		if strings.Contains(r.URL.Path, note) {
+			return false
+		}
+	}
+	return true
+}
+
+// refuseTurnChange sends an error response, and returns true, if
+// the request would make a move on a turn-based board when it
+// isn't the session's turn.
+func (session *susenSession) refuseTurnChange(w http.ResponseWriter, r *http.Request) bool {
+	t := session.turns()
+	if t == nil || !turnChange(r) || t.player() == session || session.stats.Completed != nil {
+		return false
+	}
+	if t.player() == nil {
+		sendError(w, http.StatusConflict, requestError("Everyone in the room has forfeited"))
+	} else {
+		sendError(w, http.StatusConflict, requestError("It isn't your turn"))
+	}
+	return true
+}
+
+// moved passes the turn on after the session made a move, if its
+// board takes turns.  Moves by other players (on solved boards)
+// start the current turn over.
+func (session *susenSession) moved() {
+	t := session.turns()
+	if t == nil {
+		return
+	}
+	if t.player() != session {
+		t.begin(turnStart)
+		return
+	}
+	delete(t.timeouts, session)
+	t.pass(turnMove)
+}
+
+// turnHandler handles the turn endpoints of a room:
+//
+// - POST /api/room/turn/skip passes the session's turn on
+//
+// - POST /api/room/turn/forfeit takes the session out of the
+// turns
+//
+// - GET /api/room/turn/ describes the turns
+//
+// All of them respond with the turns as the session sees them.
+func (session *susenSession) turnHandler(w http.ResponseWriter, r *http.Request, op string) {
+	session.lockBoard()
+	defer session.unlockBoard()
+	t := session.turns()
+	if t == nil {
+		sendError(w, http.StatusNotFound, requestError("The room doesn't take turns"))
+		return
+	}
+	if r.Method == "POST" {
+		switch op {
+		case "skip":
+			if t.player() != session {
+				sendError(w, http.StatusConflict, requestError("It isn't your turn"))
+				return
+			}
+			delete(t.timeouts, session)
+			t.pass(turnSkip)
+		case "forfeit":
+			log.Printf("Session %v forfeited in room %v.", session.sessionID, session.room.code)
+			t.remove(session, turnForfeit)
+		default:
+			sendError(w, http.StatusNotFound, requestError("Unknown turn operation: "+op))
+			return
+		}
+	}
+	sendJSON(w, http.StatusOK, t.status(session, ""))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// helperTurnChoice returns a possible assignment on the board.
+func helperTurnChoice(session *susenSession) puzzle.Choice {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	for _, s := range session.steps[len(session.steps)-1].Squares() {
+		if s.Aval == 0 && len(s.Pvals) > 0 {
+			return puzzle.Choice{Index: s.Index, Value: s.Pvals[0]}
+		}
+	}
+	return puzzle.Choice{}
+}
+
+func TestTurns(t *testing.T) {
+	host, guest, late := newSession("test-turns-host"), newSession("test-turns-guest"), newSession("test-turns-late")
+	hsrv := httptest.NewServer(http.HandlerFunc(host.rootHandler))
+	defer hsrv.Close()
+	gsrv := httptest.NewServer(http.HandlerFunc(guest.rootHandler))
+	defer gsrv.Close()
+	lsrv := httptest.NewServer(http.HandlerFunc(late.rootHandler))
+	defer lsrv.Close()
+
+	if status, _ := helperRoomRequest(t, hsrv, "create/?turns=1"); status != http.StatusBadRequest {
+		t.Errorf("Create with too short turns gave status %d", status)
+	}
+	status, info := helperRoomRequest(t, hsrv, "create/?turns=30")
+	if status != http.StatusOK || info.Turns == nil || !info.Turns.Yours || info.Turns.TurnTime != 30 {
+		t.Fatalf("Create with turns gave %d, %+v", status, info)
+	}
+	defer helperRoomRequest(t, hsrv, "leave/")
+	if _, joined := helperRoomRequest(t, gsrv, "join/"+info.Room); joined.Turns == nil ||
+		joined.Turns.Yours || !joined.Turns.Playing || len(joined.Turns.Players) != 2 {
+		t.Fatalf("Joined turns are %+v", joined.Turns)
+	}
+	defer helperRoomRequest(t, gsrv, "leave/")
+
+	// the guest's watcher hears about the turns
+	ws := helperDialWebSocket(t, gsrv, "/ws")
+	defer ws.conn.Close()
+	ws.readEvent(t)
+
+	// only the player whose turn it is can move, and moving
+	// passes the turn
+	if status := helperRoomAssign(t, gsrv, helperTurnChoice(guest)); status != http.StatusConflict {
+		t.Errorf("Out-of-turn assign gave status %d", status)
+	}
+	if status, _ := helperRoomRequest(t, gsrv, "turn/skip"); status != http.StatusConflict {
+		t.Errorf("Out-of-turn skip gave status %d", status)
+	}
+	if r, e := http.Post(gsrv.URL+"/api/mark/", "application/json", nil); e != nil {
+		t.Fatalf("Mark request error: %v", e)
+	} else if r.Body.Close(); r.StatusCode == http.StatusConflict {
+		t.Errorf("Out-of-turn mark was refused")
+	}
+	if status := helperRoomAssign(t, hsrv, helperTurnChoice(host)); status != http.StatusOK {
+		t.Fatalf("Host assign gave status %d", status)
+	}
+	for {
+		ev := ws.readEvent(t)
+		if ev.Type == turnEventType {
+			if ev.Turn == nil || !ev.Turn.Yours || ev.Turn.Reason != turnMove || ev.Turn.Deadline == nil {
+				t.Errorf("Turn event after the host's move is %+v", ev.Turn)
+			}
+			break
+		}
+	}
+	if status, _ := helperRoomRequest(t, hsrv, "turn/skip"); status != http.StatusConflict {
+		t.Errorf("Host skip out of turn gave status %d", status)
+	}
+	if status, _ := helperRoomRequest(t, gsrv, "turn/skip"); status != http.StatusOK {
+		t.Errorf("Guest skip gave status %d", status)
+	}
+
+	// late joiners go last
+	if _, joined := helperRoomRequest(t, lsrv, "join/"+info.Room); joined.Turns == nil ||
+		len(joined.Turns.Players) != 3 || joined.Turns.Current != 0 {
+		t.Fatalf("Late joiner's turns are %+v", joined.Turns)
+	}
+
+	// running out of time passes the turn, and doing it too often
+	// forfeits
+	host.mutex.Lock()
+	turns := host.turns()
+	for i := 0; i < maxTurnTimeouts; i++ {
+		if turns.player() != host {
+			t.Fatalf("Turn %d went to %v", i, turns.player().sessionID)
+		}
+		host.mutex.Unlock()
+		turns.timeUp(turns.number)
+		host.mutex.Lock()
+		if i < maxTurnTimeouts-1 {
+			// the others skip back to the host
+			turns.pass(turnSkip)
+			turns.pass(turnSkip)
+		}
+	}
+	if len(turns.players) != 2 || turns.player() != guest {
+		t.Errorf("After timeouts, players are %v and it's %v's turn", turns.players, turns.player().sessionID)
+	}
+	host.mutex.Unlock()
+	if helperGetJSON(t, hsrv, "/api/room/", &info); info.Turns == nil || info.Turns.Playing {
+		t.Errorf("Forfeited host still plays: %+v", info.Turns)
+	}
+	if status := helperRoomAssign(t, hsrv, helperTurnChoice(host)); status != http.StatusConflict {
+		t.Errorf("Forfeited host's assign gave status %d", status)
+	}
+
+	// players who leave or forfeit are taken out of the turns
+	helperRoomRequest(t, gsrv, "leave/")
+	if helperGetJSON(t, lsrv, "/api/room/", &info); info.Turns == nil || !info.Turns.Yours || len(info.Turns.Players) != 1 {
+		t.Errorf("After the guest left, turns are %+v", info.Turns)
+	}
+	if status, _ := helperRoomRequest(t, lsrv, "turn/forfeit"); status != http.StatusOK {
+		t.Errorf("Forfeit gave status %d", status)
+	}
+	if status := helperRoomAssign(t, lsrv, helperTurnChoice(late)); status != http.StatusConflict {
+		t.Errorf("Assign after everyone forfeited gave status %d", status)
+	}
+	helperRoomRequest(t, lsrv, "leave/")
+	if status := helperGetJSON(t, gsrv, "/api/room/turn/", nil); status != http.StatusNotFound {
+		t.Errorf("Turns of a room that doesn't take them gave status %d", status)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	session := newSession("test-units")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	var units []puzzle.Unit
+	if status := helperUserRequest(t, srv, "", "GET", "/api/units", nil, &units); status != http.StatusOK {
+		t.Fatalf("Units gave status %d", status)
+	}
+	if len(units) != 27 {
+		t.Fatalf("Got %d units, expected 27", len(units))
+	}
+	empty := 0
+	for _, s := range session.steps[len(session.steps)-1].Squares() {
+		if s.Aval == 0 {
+			empty++
+		}
+	}
+	counted := 0
+	for _, u := range units {
+		if len(u.Placed)+len(u.Missing) != 9 {
+			t.Errorf("Unit %v has %d placed and %d missing values", u.Group, len(u.Placed), len(u.Missing))
+		}
+		counted += len(u.Empty)
+	}
+	if counted != 3*empty {
+		t.Errorf("Units have %d empty squares, expected %d", counted, 3*empty)
+	}
+}
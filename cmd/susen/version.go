@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+/*
+
+Board versions
+
+Each board has a version, which goes up by one whenever its steps
+change: when a move is made or undone, and when the board is
+started over (with the same puzzle or another).  Every response
+to a session's requests gives its board's version, as it is when
+the response is sent, in the X-Board-Version header, and clients
+can send it back in the same header with requests that change
+the board (or any other board API request).  Requests that carry
+it are refused (409) if the board's version isn't the one they
+expect, so a client whose board was changed behind its back
+(from another tab or device, or by a partner in a room) finds
+out, rather than making moves on a board it hasn't seen.
+
+Board API responses are held until their requests are done, so
+that they give the version after the request's change: the
+puzzle package's handlers send their responses before the
+session adds the steps they made.
+
+Retries of keyed requests (see idempotency.go) get their original
+responses back, whatever the board's version is now.
+
+*/
+
+// boardVersionHeader is the header with the board's version.
+const boardVersionHeader = "X-Board-Version"
+
+// changedSteps notes that the board's steps changed.  It must be
+// called with the board locked.
+func (board *susenBoard) changedSteps() {
+	atomic.AddInt64(&board.version, 1)
+}
+
+// currentVersion returns the board's version.  It can be called
+// whether or not the board is locked.
+func (board *susenBoard) currentVersion() int64 {
+	return atomic.LoadInt64(&board.version)
+}
+
+// refuseStaleVersion refuses a request that expects a version of
+// the board other than its current one.  It must be called with
+// the board locked.
+func (board *susenBoard) refuseStaleVersion(w http.ResponseWriter, r *http.Request) bool {
+	expected := r.Header.Get(boardVersionHeader)
+	if expected == "" {
+		return false
+	}
+	v, e := strconv.ParseInt(expected, 10, 64)
+	if e != nil || v < 0 {
+		sendError(w, http.StatusBadRequest, fieldError(boardVersionHeader, "Invalid board version: "+expected))
+		return true
+	}
+	if current := board.currentVersion(); v != current {
+		sendError(w, http.StatusConflict, fieldError(boardVersionHeader,
+			"The board has changed: it's at version "+strconv.FormatInt(current, 10)+", not "+expected))
+		return true
+	}
+	return false
+}
+
+// A heldResponse holds a response until it's released.
+type heldResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *heldResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *heldResponse) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// release sends the held response.
+func (w *heldResponse) release() {
+	if w.status == 0 {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// A versionWriter gives a board's version in a response, as it is
+// when the response is sent.  It can be hijacked, for WebSocket
+// connections, and flushed, for event streams.
+type versionWriter struct {
+	http.ResponseWriter
+	board   *susenBoard
+	started bool
+}
+
+func (w *versionWriter) start() {
+	if !w.started {
+		w.started = true
+		w.Header().Set(boardVersionHeader, strconv.FormatInt(w.board.currentVersion(), 10))
+	}
+}
+
+func (w *versionWriter) WriteHeader(status int) {
+	w.start()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *versionWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *versionWriter) Flush() {
+	w.start()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *versionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.started = true
+	return hj.Hijack()
+}
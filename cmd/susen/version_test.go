@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestBoardVersion(t *testing.T) {
+	session := newSession("test-board-version")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+	var choices []puzzle.Choice
+	for _, sq := range session.steps[0].Squares() {
+		if sq.Aval == 0 && len(sq.Pvals) > 0 {
+			choices = append(choices, puzzle.Choice{Index: sq.Index, Value: sq.Pvals[0]})
+		}
+	}
+	request := func(method, path, version string, body interface{}) (int, string) {
+		bs, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(bs))
+		if version != "" {
+			req.Header.Set(boardVersionHeader, version)
+		}
+		r, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatalf("%s %s request error: %v", method, path, e)
+		}
+		r.Body.Close()
+		return r.StatusCode, r.Header.Get(boardVersionHeader)
+	}
+
+	// every response has the version, which moves make go up
+	_, v0 := request("GET", "/api/squares/", "", nil)
+	if _, v := request("GET", "/api/stats", "", nil); v == "" || v != v0 {
+		t.Fatalf("Versions are %q and %q", v0, v)
+	}
+	status, v1 := request("POST", "/api/assign/", v0, choices[0])
+	if n0, _ := strconv.Atoi(v0); status != http.StatusOK || v1 != strconv.Itoa(n0+1) {
+		t.Fatalf("Assignment at version %s gave %d, version %q", v0, status, v1)
+	}
+
+	// moves expecting an old version are refused
+	steps := len(session.steps)
+	if status, v := request("POST", "/api/assign/", v0, choices[1]); status != http.StatusConflict || v != v1 ||
+		len(session.steps) != steps {
+		t.Errorf("Stale assignment gave %d, version %q", status, v)
+	}
+	if status, _ := request("GET", "/api/back/", v0, nil); status != http.StatusConflict || len(session.steps) != steps {
+		t.Errorf("Stale undo gave %d", status)
+	}
+	if status, _ := request("POST", "/api/assign/", "latest", choices[1]); status != http.StatusBadRequest {
+		t.Errorf("Invalid version gave %d", status)
+	}
+
+	// undos and resets change the version too
+	if _, v := request("GET", "/api/back/", v1, nil); v == v1 || len(session.steps) != steps-1 {
+		t.Errorf("Undo left version %q", v)
+	}
+	before := session.currentVersion()
+	session.reset(session.puzzleID)
+	if session.currentVersion() <= before {
+		t.Errorf("Reset left version %d", session.currentVersion())
+	}
+}
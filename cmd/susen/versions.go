@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/auth"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*
+
+Checkpoint versions
+
+Session checkpoints (see shutdown.go) outlast the server that
+wrote them, so a release that changes their encoding has to be
+able to read the ones written by the releases before it.  Each
+checkpoint is tagged with the version of its encoding, and when
+the version changes, a migration is added that brings a
+checkpoint of the previous version up to date.  Checkpoints
+from before the tags have version 1.
+
+Migrations are lazy: a checkpoint is read as plain JSON, each
+migration from its version on is applied in turn, and only then
+is it decoded, so migrations can rename and restructure fields
+that the current encoding no longer has.  A checkpoint's slots
+are part of its record, and are migrated with it.  Admins can
+also migrate all the stored checkpoints at once (see
+checkpointsHandler), say before a release that drops an old
+migration.
+
+*/
+
+// checkpointVersion is the version of the checkpoint encoding,
+// which is one more than the number of migrations.
+var checkpointVersion = len(checkpointMigrations) + 1
+
+// checkpointMigrations bring checkpoints up to date: the first
+// migrates a checkpoint of version 1 to version 2, and so on.
+var checkpointMigrations = []func(c map[string]interface{}) error{
+	migrateStepSizes,
+}
+
+// migrateStepSizes adds the step sizes to a version 1 checkpoint
+// (and its slots), which only had them if some step had several
+// moves.
+func migrateStepSizes(c map[string]interface{}) error {
+	if _, ok := c["sizes"]; !ok {
+		moves, _ := c["moves"].([]interface{})
+		sizes := make([]interface{}, len(moves))
+		for i := range sizes {
+			sizes[i] = 1
+		}
+		c["sizes"] = sizes
+	}
+	slots, _ := c["slots"].(map[string]interface{})
+	for name, slot := range slots {
+		sc, ok := slot.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Slot %q isn't a checkpoint", name)
+		}
+		if e := migrateStepSizes(sc); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// upgradeCheckpoint decodes the plain JSON of a checkpoint,
+// migrating it first if it's from an older version.  It returns
+// the version the checkpoint had.
+func upgradeCheckpoint(raw map[string]interface{}) (sessionCheckpoint, int, error) {
+	version := 1
+	if v, ok := raw["version"].(float64); ok {
+		version = int(v)
+	}
+	if version < 1 || version > checkpointVersion {
+		return sessionCheckpoint{}, version,
+			fmt.Errorf("Checkpoint version %d isn't from 1 to %d", version, checkpointVersion)
+	}
+	for v := version; v < checkpointVersion; v++ {
+		if e := checkpointMigrations[v-1](raw); e != nil {
+			return sessionCheckpoint{}, version, fmt.Errorf("Can't migrate checkpoint from version %d: %v", v, e)
+		}
+	}
+	raw["version"] = checkpointVersion
+	var c sessionCheckpoint
+	bytes, e := json.Marshal(raw)
+	if e == nil {
+		e = json.Unmarshal(bytes, &c)
+	}
+	return c, version, e
+}
+
+// loadCheckpoint reads the stored checkpoint of a session,
+// bringing it up to date.  It returns the version the checkpoint
+// was stored with, and whether there was one.
+func loadCheckpoint(id string) (sessionCheckpoint, int, bool, error) {
+	var raw map[string]interface{}
+	found, e := store.Get(checkpointKind, id, &raw)
+	if e != nil || !found {
+		return sessionCheckpoint{}, 0, found, e
+	}
+	c, version, e := upgradeCheckpoint(raw)
+	return c, version, true, e
+}
+
+// A checkpointMigration reports on migrating the stored
+// checkpoints: how many there were, how many were rewritten at
+// the current version, and which couldn't be.
+type checkpointMigration struct {
+	Version  int      `json:"version"`
+	Checked  int      `json:"checked"`
+	Migrated int      `json:"migrated"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// migrateCheckpoints brings all the stored checkpoints up to date.
+func migrateCheckpoints() (checkpointMigration, error) {
+	report := checkpointMigration{Version: checkpointVersion}
+	ids, e := store.Keys(checkpointKind)
+	if e != nil {
+		return report, e
+	}
+	for _, id := range ids {
+		c, version, found, e := loadCheckpoint(id)
+		if !found && e == nil {
+			continue // restored since it was listed
+		}
+		report.Checked++
+		if e == nil && version < checkpointVersion {
+			if e = store.Put(checkpointKind, id, c); e == nil {
+				report.Migrated++
+			}
+		}
+		if e != nil {
+			log.Printf("Can't migrate checkpoint of session %v: %v", id, e)
+			report.Failed = append(report.Failed, id)
+		}
+	}
+	return report, nil
+}
+
+// checkpointsHandler handles the checkpoint migration endpoint,
+// which is only routed to for admins:
+//
+// - POST /api/admin/checkpoints/migrate rewrites every stored
+// checkpoint at the current version, and reports on it
+func checkpointsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/checkpoints"), "/")
+	if path != "migrate" {
+		sendError(w, http.StatusNotFound, requestError("Unknown checkpoint operation: "+path))
+		return
+	}
+	if r.Method != "POST" {
+		sendError(w, http.StatusMethodNotAllowed, requestError("Checkpoint migration must be a POST"))
+		return
+	}
+	report, e := migrateCheckpoints()
+	if e != nil {
+		sendError(w, http.StatusInternalServerError, requestError("Can't list checkpoints: "+e.Error()))
+		return
+	}
+	log.Printf("User %v migrated %d of %d checkpoints to version %d.",
+		auth.FromRequest(r).Key(), report.Migrated, report.Checked, report.Version)
+	sendJSON(w, http.StatusOK, report)
+}
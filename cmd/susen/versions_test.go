@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ancientHacker/susen.go/auth"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"github.com/ancientHacker/susen.go/storage"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointVersions(t *testing.T) {
+	saved := store
+	store = monitoredStore{storage.NewMemory()}
+	defer func() { store = saved }()
+
+	// a version 1 checkpoint, with a slot, has no step sizes
+	values := puzzleValues[defaultPuzzleID]
+	p, _ := puzzle.New(values)
+	solution := p.Solutions()[0].Values
+	var moves []puzzle.Choice
+	for i, v := range values[1:] {
+		if v == 0 && len(moves) < 2 {
+			moves = append(moves, puzzle.Choice{Index: i + 1, Value: solution[i]})
+		}
+	}
+	slot := sessionCheckpoint{PuzzleID: defaultPuzzleID, Values: values, Moves: moves[:1], Tries: []int{}}
+	old := sessionCheckpoint{Keys: []string{"test-versions"}, PuzzleID: defaultPuzzleID, Values: values,
+		Moves: moves, Tries: []int{}, Slots: map[string]sessionCheckpoint{"other": slot}}
+	var raw map[string]interface{}
+	bytes, _ := json.Marshal(old)
+	json.Unmarshal(bytes, &raw)
+	if _, ok := raw["sizes"]; ok {
+		t.Fatalf("Version 1 checkpoint has sizes: %v", raw)
+	}
+	store.Put(checkpointKind, "test-versions", raw)
+	store.Put(checkpointKind, "test-versions-future", map[string]interface{}{"version": checkpointVersion + 1})
+
+	// it's migrated when it's loaded
+	c, version, found, e := loadCheckpoint("test-versions")
+	if e != nil || !found || version != 1 || c.Version != checkpointVersion {
+		t.Fatalf("Loaded checkpoint version %d (now %d), %v, %v", version, c.Version, found, e)
+	}
+	if !reflect.DeepEqual(c.Sizes, []int{1, 1}) || !reflect.DeepEqual(c.Slots["other"].Sizes, []int{1}) {
+		t.Errorf("Migrated checkpoint has sizes %v, slot sizes %v", c.Sizes, c.Slots["other"].Sizes)
+	}
+	session, e := c.restore("test-versions")
+	if e != nil || len(session.steps) != 3 || len(session.slots["other"].steps) != 2 {
+		t.Errorf("Restoring migrated checkpoint gave %v", e)
+	}
+	if _, _, _, e := loadCheckpoint("test-versions-future"); e == nil {
+		t.Errorf("Checkpoint from a later version was loaded")
+	}
+
+	// admins can migrate the stored checkpoints
+	srv := helperUserServer(newSession("test-versions-admin"))
+	defer srv.Close()
+	roles.Grant("header:root", auth.RoleAdmin)
+	defer roles.Revoke("header:root", auth.RoleAdmin)
+	if status := helperUserRequest(t, srv, "sam", "POST", "/api/admin/checkpoints/migrate", nil, nil); status != http.StatusForbidden {
+		t.Errorf("Non-admin migration gave status %d", status)
+	}
+	if status := helperUserRequest(t, srv, "root", "GET", "/api/admin/checkpoints/migrate", nil, nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("Migration GET gave status %d", status)
+	}
+	var report checkpointMigration
+	expected := checkpointMigration{checkpointVersion, 2, 1, []string{"test-versions-future"}}
+	if status := helperUserRequest(t, srv, "root", "POST", "/api/admin/checkpoints/migrate", nil, &report); status != http.StatusOK ||
+		!reflect.DeepEqual(report, expected) {
+		t.Errorf("Migration gave status %d, report %+v", status, report)
+	}
+	if _, version, _, _ := loadCheckpoint("test-versions"); version != checkpointVersion {
+		t.Errorf("Stored checkpoint has version %d after migration", version)
+	}
+	expected.Migrated = 0
+	if helperUserRequest(t, srv, "root", "POST", "/api/admin/checkpoints/migrate", nil, &report); !reflect.DeepEqual(report, expected) {
+		t.Errorf("Second migration gave report %+v", report)
+	}
+}
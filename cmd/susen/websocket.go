@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+WebSocket connections
+
+This is the small part of RFC 6455 that the server needs for
+pushing session events to browsers: the opening handshake,
+unfragmented server-to-client messages, and enough of the
+client-to-server framing to answer pings and notice closes.
+
+Connections are only taken from the server's own pages and the
+allowed origins (see cors.go), since they carry the session
+cookie just as API requests do.  Events are queued for each
+connection and written by a goroutine of its own, with a
+deadline, so that sending them never waits on a client (which
+would hold up everyone else on the board); a client that falls
+wsQueueSize messages behind, or takes longer than
+wsWriteTimeout to take one, is dropped.
+
+*/
+
+// wsGUID is the fixed key suffix used in the opening handshake.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes.
+const (
+	wsTextFrame  = 0x1
+	wsCloseFrame = 0x8
+	wsPingFrame  = 0x9
+	wsPongFrame  = 0xA
+)
+
+// wsMaxPayload limits the size of client frames, which should
+// only ever be control frames.
+const wsMaxPayload = 4096
+
+// wsWriteTimeout limits how long a write to a client can take,
+// and wsQueueSize is how many messages can wait to be sent to
+// one.
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsQueueSize    = 16
+)
+
+// A wsConn is a server-side WebSocket connection.  Writes are
+// interlocked so that events can be sent from any goroutine.
+type wsConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	mutex  sync.Mutex
+	closed bool
+	queue  chan []byte          // text messages waiting to be written (see send)
+	done   chan struct{}        // closed when the connection is
+	onText func(payload []byte) // called with client text messages, if set
+}
+
+// newWSConn makes a connection that's done its handshake, and
+// starts writing its queued messages.
+func newWSConn(conn net.Conn, rw *bufio.ReadWriter) *wsConn {
+	c := &wsConn{conn: conn, rw: rw, queue: make(chan []byte, wsQueueSize), done: make(chan struct{})}
+	go c.drain()
+	return c
+}
+
+// upgradeWebSocket does the opening handshake for a WebSocket
+// request, taking over the connection from the HTTP server.  If
+// the request isn't a valid WebSocket request, the client gets a
+// 400 response and the caller gets an error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, fmt.Errorf("Not a WebSocket request")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != requestOrigin(r) && !allowedOrigin(origin) {
+		http.Error(w, "WebSocket connections from "+origin+" aren't allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("WebSocket request from origin %s", origin)
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("Response writer can't be hijacked")
+	}
+	conn, rw, e := hj.Hijack()
+	if e != nil {
+		return nil, e
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if e := rw.Flush(); e != nil {
+		conn.Close()
+		return nil, e
+	}
+	return newWSConn(conn, rw), nil
+}
+
+// writeFrame sends a single unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n < 1<<16:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if _, e := c.rw.Write(header); e != nil {
+		return e
+	}
+	if _, e := c.rw.Write(payload); e != nil {
+		return e
+	}
+	return c.rw.Flush()
+}
+
+// writeJSON sends the JSON encoding of a value as a text message.
+func (c *wsConn) writeJSON(v interface{}) error {
+	bytes, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	return c.writeFrame(wsTextFrame, bytes)
+}
+
+// send queues a text message, without waiting for it to be
+// written.  If the client has fallen too far behind, it's closed
+// instead.
+func (c *wsConn) send(payload []byte) error {
+	select {
+	case <-c.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case c.queue <- payload:
+		return nil
+	default:
+		c.close()
+		return fmt.Errorf("WebSocket client is %d messages behind", wsQueueSize)
+	}
+}
+
+// drain writes the queued messages until the connection is
+// closed, closing it if a write fails.
+func (c *wsConn) drain() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case payload := <-c.queue:
+			if e := c.writeFrame(wsTextFrame, payload); e != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads a single (masked) client frame, returning its
+// opcode and unmasked payload.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, e := io.ReadFull(c.rw, header[:]); e != nil {
+		return 0, nil, e
+	}
+	opcode, masked := header[0]&0x0F, header[1]&0x80 != 0
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, e := io.ReadFull(c.rw, ext[:]); e != nil {
+			return 0, nil, e
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, e := io.ReadFull(c.rw, ext[:]); e != nil {
+			return 0, nil, e
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxPayload {
+		return 0, nil, fmt.Errorf("WebSocket frame too large (%d bytes)", n)
+	}
+	var mask [4]byte
+	if masked {
+		if _, e := io.ReadFull(c.rw, mask[:]); e != nil {
+			return 0, nil, e
+		}
+	}
+	payload := make([]byte, n)
+	if _, e := io.ReadFull(c.rw, payload); e != nil {
+		return 0, nil, e
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// serve reads client frames until the client closes the
+// connection (or it fails), answering pings along the way.
+// Client text messages are passed to onText, if it's set, and
+// otherwise ignored: all session changes come in through the
+// HTTP API.
+func (c *wsConn) serve() {
+	defer c.close()
+	for {
+		opcode, payload, e := c.readFrame()
+		if e != nil {
+			return
+		}
+		switch opcode {
+		case wsPingFrame:
+			c.writeFrame(wsPongFrame, payload)
+		case wsCloseFrame:
+			c.writeFrame(wsCloseFrame, nil)
+			return
+		case wsTextFrame:
+			if c.onText != nil {
+				c.onText(payload)
+			}
+		}
+	}
+}
+
+// close shuts down the connection; it's safe to call repeatedly.
+func (c *wsConn) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ancientHacker/susen.go/puzzle"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+
+helpers
+
+*/
+
+// a minimal test client for WebSocket connections
+type wsTestClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dial a WebSocket server at a test server's path.
+func helperDialWebSocket(t *testing.T, srv *httptest.Server, path string) *wsTestClient {
+	conn, e := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if e != nil {
+		t.Fatalf("Dial failed: %v", e)
+	}
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n", path)
+	br := bufio.NewReader(conn)
+	r, e := http.ReadResponse(br, nil)
+	if e != nil {
+		t.Fatalf("Handshake response error: %v", e)
+	}
+	if r.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Handshake status was %v", r.Status)
+	}
+	// this is the accept value given for this key in RFC 6455
+	if a := r.Header.Get("Sec-WebSocket-Accept"); a != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Handshake accept was %q", a)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsTestClient{conn, br}
+}
+
+// read a frame from the server
+func (c *wsTestClient) readFrame(t *testing.T) (byte, []byte) {
+	var header [2]byte
+	if _, e := io.ReadFull(c.br, header[:]); e != nil {
+		t.Fatalf("Frame header read failed: %v", e)
+	}
+	n := int(header[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, n)
+	if _, e := io.ReadFull(c.br, payload); e != nil {
+		t.Fatalf("Frame payload read failed: %v", e)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// read a session event from the server
+func (c *wsTestClient) readEvent(t *testing.T) sessionEvent {
+	opcode, payload := c.readFrame(t)
+	if opcode != wsTextFrame {
+		t.Fatalf("Expected text frame, got opcode %x", opcode)
+	}
+	var ev sessionEvent
+	if e := json.Unmarshal(payload, &ev); e != nil {
+		t.Fatalf("Event decode failed: %v", e)
+	}
+	return ev
+}
+
+// write a masked frame to the server
+func (c *wsTestClient) writeFrame(opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+/*
+
+tests
+
+*/
+
+func TestWebSocketFraming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, e := upgradeWebSocket(w, r)
+		if e != nil {
+			return
+		}
+		c.writeJSON(strings.Repeat("x", 200)) // needs an extended length
+		c.serve()
+	}))
+	defer srv.Close()
+
+	// non-upgrade requests are refused
+	r, e := http.Get(srv.URL)
+	if e != nil {
+		t.Fatalf("Request error: %v", e)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Non-upgrade request got status %v", r.Status)
+	}
+
+	c := helperDialWebSocket(t, srv, "/")
+	defer c.conn.Close()
+	if opcode, payload := c.readFrame(t); opcode != wsTextFrame || len(payload) != 202 {
+		t.Errorf("First frame was opcode %x with %d bytes", opcode, len(payload))
+	}
+	c.writeFrame(wsPingFrame, []byte("hello"))
+	if opcode, payload := c.readFrame(t); opcode != wsPongFrame || !bytes.Equal(payload, []byte("hello")) {
+		t.Errorf("Ping got opcode %x with payload %q", opcode, payload)
+	}
+	c.writeFrame(wsCloseFrame, nil)
+	if opcode, _ := c.readFrame(t); opcode != wsCloseFrame {
+		t.Errorf("Close got opcode %x", opcode)
+	}
+}
+
+func TestWebSocketOrigins(t *testing.T) {
+	defer func(saved []string) { corsOrigins = saved }(corsOrigins)
+	corsOrigins = []string{"https://play.example.com"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, e := upgradeWebSocket(w, r); e == nil {
+			c.close()
+		}
+	}))
+	defer srv.Close()
+	handshake := func(origin string) int {
+		conn, e := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+		if e != nil {
+			t.Fatalf("Dial failed: %v", e)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nOrigin: %s\r\nUpgrade: websocket\r\n"+
+			"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+			"Sec-WebSocket-Version: 13\r\n\r\n", strings.TrimPrefix(srv.URL, "http://"), origin)
+		r, e := http.ReadResponse(bufio.NewReader(conn), nil)
+		if e != nil {
+			t.Fatalf("Handshake response error: %v", e)
+		}
+		return r.StatusCode
+	}
+	for origin, status := range map[string]int{
+		srv.URL:                    http.StatusSwitchingProtocols,
+		"https://play.example.com": http.StatusSwitchingProtocols,
+		"https://evil.example.com": http.StatusForbidden,
+	} {
+		if got := handshake(origin); got != status {
+			t.Errorf("Handshake from %s gave status %d", origin, got)
+		}
+	}
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newWSConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)))
+
+	// a client that never reads is dropped once its queue is full,
+	// without holding up the sender
+	start := time.Now()
+	var e error
+	for i := 0; i <= wsQueueSize+1 && e == nil; i++ {
+		e = c.send([]byte("{}"))
+	}
+	if e == nil || time.Since(start) > time.Second {
+		t.Errorf("Sends to a stalled client gave %v after %v", e, time.Since(start))
+	}
+	if e := c.send([]byte("{}")); e != io.ErrClosedPipe {
+		t.Errorf("Send to a dropped client gave %v", e)
+	}
+}
+
+func TestSessionWatchers(t *testing.T) {
+	session := newSession("test-watchers")
+	session.reset("1-star")
+	srv := httptest.NewServer(http.HandlerFunc(session.rootHandler))
+	defer srv.Close()
+
+	c := helperDialWebSocket(t, srv, "/ws")
+	defer c.conn.Close()
+	if ev := c.readEvent(t); ev.Type != squaresEventType || len(ev.Squares) != 81 {
+		t.Fatalf("Initial event was %+v", ev)
+	}
+
+	// an assignment is pushed as an update
+	bs, _ := json.Marshal(puzzle.Choice{Index: 2, Value: 6})
+	r, e := http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(bs))
+	if e != nil {
+		t.Fatalf("Assign request error: %v", e)
+	}
+	r.Body.Close()
+	if ev := c.readEvent(t); ev.Type != updateEventType || len(ev.Squares) == 0 || ev.Squares[0].Index != 2 {
+		t.Errorf("Assign event was %+v", ev)
+	}
+
+	// an undo is pushed as the full set of squares
+	r, e = http.Get(srv.URL + "/api/back/")
+	if e != nil {
+		t.Fatalf("Back request error: %v", e)
+	}
+	r.Body.Close()
+	if ev := c.readEvent(t); ev.Type != squaresEventType || ev.Squares[1].Aval != 0 {
+		t.Errorf("Back event was %+v", ev)
+	}
+
+	// filling in the solution produces a solved event
+	soln := session.steps[0].Solutions()[0].Values
+	for i, v := range session.steps[0].State().Values {
+		if v != 0 {
+			continue
+		}
+		bs, _ := json.Marshal(puzzle.Choice{Index: i + 1, Value: soln[i]})
+		r, e := http.Post(srv.URL+"/api/assign/", "application/json", bytes.NewReader(bs))
+		if e != nil {
+			t.Fatalf("Assign request error: %v", e)
+		}
+		r.Body.Close()
+		if ev := c.readEvent(t); ev.Type != updateEventType {
+			t.Fatalf("Assign event was %+v", ev)
+		}
+	}
+	if ev := c.readEvent(t); ev.Type != solvedEventType {
+		t.Errorf("Final event was %+v", ev)
+	}
+}
@@ -0,0 +1,79 @@
+package puzzle
+
+import (
+	"sort"
+)
+
+/*
+
+Board analysis
+
+An Analysis picks out the squares and groups of a puzzle that a
+player is likely to look at first, so that clients can highlight
+them without working them out: the bivalue squares (empty
+squares with only two possible values), and the most
+constrained groups (the ones with the fewest empty squares,
+not counting the full ones).  It only uses what the puzzle
+already knows about its squares, so it's cheap enough to do
+whenever a puzzle is started.
+
+*/
+
+// An Analysis gives the interesting squares and groups of a
+// puzzle.  The bivalue squares are in index order, and the most
+// constrained groups in order of how many empty squares they
+// have, and then of their index in the puzzle's geometry.
+type Analysis struct {
+	Bivalue     []int           `json:"bivalue"`
+	Constrained []GroupAnalysis `json:"constrained"`
+}
+
+// A GroupAnalysis gives the number of empty squares in a group.
+type GroupAnalysis struct {
+	Group GroupID `json:"group"`
+	Empty int     `json:"empty"`
+}
+
+// maxConstrainedGroups is how many of the most constrained
+// groups an Analysis gives.
+const maxConstrainedGroups = 3
+
+// Analyze returns the analysis of a puzzle.  It's an error if the
+// puzzle's contents aren't available, as for Rate.
+func Analyze(p Puzzle) (Analysis, error) {
+	if rp, ok := p.(*relaxedPuzzle); ok {
+		p = rp.puzzle
+	}
+	puz, ok := p.(*puzzle)
+	if !ok {
+		return Analysis{}, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle contents are not available for analysis"},
+		}
+	}
+	a := Analysis{Bivalue: []int{}, Constrained: []GroupAnalysis{}}
+	for i := 1; i <= puz.mapping.scount; i++ {
+		if s := puz.squares[i]; s.aval == 0 && s.pvals.len() == 2 {
+			a.Bivalue = append(a.Bivalue, i)
+		}
+	}
+	for gi := 1; gi <= puz.mapping.gcount; gi++ {
+		gd := puz.mapping.gdescs[gi]
+		empty := 0
+		for _, i := range gd.indices {
+			if puz.squares[i].aval == 0 {
+				empty++
+			}
+		}
+		if empty > 0 {
+			a.Constrained = append(a.Constrained, GroupAnalysis{gd.id, empty})
+		}
+	}
+	sort.SliceStable(a.Constrained, func(i, j int) bool { return a.Constrained[i].Empty < a.Constrained[j].Empty })
+	if len(a.Constrained) > maxConstrainedGroups {
+		a.Constrained = a.Constrained[:maxConstrainedGroups]
+	}
+	return a, nil
+}
@@ -0,0 +1,44 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	values := []int{SudokuGeometryCode,
+		1, 2, 3, 0,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	expected := Analysis{
+		Bivalue: []int{5, 6},
+		Constrained: []GroupAnalysis{
+			{GroupID{GtypeRow, 1}, 1},
+			{GroupID{GtypeTile, 1}, 2},
+			{GroupID{GtypeCol, 1}, 3},
+		},
+	}
+	for _, newPuzzle := range []func([]int) (Puzzle, error){New, NewRelaxed} {
+		p, e := newPuzzle(values)
+		if e != nil {
+			t.Fatalf("Failed to create puzzle: %v", e)
+		}
+		if a, e := Analyze(p); e != nil || !reflect.DeepEqual(a, expected) {
+			t.Errorf("Analysis was %+v, %v", a, e)
+		}
+	}
+
+	// full groups aren't constrained, and solved puzzles have
+	// nothing to analyze
+	p, _ := New(append([]int{SudokuGeometryCode}, oneStarValues...))
+	p, _ = New(append([]int{SudokuGeometryCode}, p.Solutions()[0].Values...))
+	if a, e := Analyze(p); e != nil || len(a.Bivalue) != 0 || len(a.Constrained) != 0 || a.Constrained == nil {
+		t.Errorf("Solved puzzle analysis was %+v, %v", a, e)
+	}
+	contest, _ := NewContest(values)
+	if _, e := Analyze(contest); e == nil {
+		t.Errorf("Contest puzzle was analyzed")
+	}
+}
@@ -0,0 +1,91 @@
+package puzzle
+
+import (
+	"context"
+)
+
+/*
+
+Cancellation
+
+A pathological puzzle can keep the solver searching for a very
+long time, so servers need to be able to give up on it when the
+client goes away or a deadline passes.  Each of the long-running
+operations has a form that takes a context, and stops when the
+context is done, returning an Error that gives the context's
+reason, or has DeadlineExceededCondition if its deadline passed.
+The context is checked as often as a watcher would be told how
+the search is going (see progress.go), and between the rater's
+steps when rating or explaining.
+
+*/
+
+// contextWatcher is a search watcher that stops the search when
+// the context is done.
+func contextWatcher(ctx context.Context) func(Progress) bool {
+	return func(Progress) bool {
+		return ctx.Err() == nil
+	}
+}
+
+// canceledError is returned by operations whose contexts are
+// done.  Those whose deadlines passed get a condition of their
+// own, so clients can tell they ran out of time.
+func canceledError(ctx context.Context) Error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return Error{
+			Scope:     RequestScope,
+			Structure: ScopeStructure,
+			Condition: DeadlineExceededCondition,
+		}
+	}
+	return Error{
+		Scope:     RequestScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{"The search was canceled: " + ctx.Err().Error()},
+	}
+}
+
+// SolveContext finds all the solutions of a puzzle, as its
+// Solutions method does, stopping when the context is done.
+// It's an error if the puzzle's contents aren't available.
+func SolveContext(ctx context.Context, p Puzzle) ([]Solution, error) {
+	solutions, e := SolveWatched(p, contextWatcher(ctx))
+	if e != nil && ctx.Err() != nil {
+		return solutions, canceledError(ctx)
+	}
+	return solutions, e
+}
+
+// GenerateContext generates a puzzle, as Generate does, stopping
+// when the context is done.
+func GenerateContext(ctx context.Context, params GenerateParams) (Generated, error) {
+	g, e := GenerateWatched(params, contextWatcher(ctx))
+	if e != nil && ctx.Err() != nil {
+		return g, canceledError(ctx)
+	}
+	return g, e
+}
+
+// RateContext rates a puzzle, as RateWith does, stopping when
+// the context is done.
+func RateContext(ctx context.Context, p Puzzle, profile RatingProfile) (Rating, error) {
+	s := &searcher{watch: contextWatcher(ctx)}
+	rating, e := rateWith(p, profile, s, nil)
+	if s.stopped {
+		return rating, canceledError(ctx)
+	}
+	return rating, e
+}
+
+// ExplainContext explains a puzzle, as Explain does, stopping
+// when the context is done.
+func ExplainContext(ctx context.Context, p Puzzle) ([]Step, error) {
+	s := &searcher{watch: contextWatcher(ctx)}
+	steps, e := explain(p, s)
+	if s.stopped {
+		return steps, canceledError(ctx)
+	}
+	return steps, e
+}
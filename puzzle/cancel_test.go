@@ -0,0 +1,57 @@
+package puzzle
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContextOperations(t *testing.T) {
+	p, _ := New(goldenSeedValues)
+	ctx := context.Background()
+	solutions, e := SolveContext(ctx, p)
+	if e != nil || !reflect.DeepEqual(solutions, p.Solutions()) {
+		t.Errorf("Solving with a context gave %v, %v", solutions, e)
+	}
+	g, e := GenerateContext(ctx, GenerateParams{Seed: "golden"})
+	if e != nil || !reflect.DeepEqual(g.Values, goldenSeedValues) {
+		t.Errorf("Generating with a context gave %v, %v", g.Values, e)
+	}
+	rating, e := RateContext(ctx, p, RatingProfiles[0])
+	if expected, _ := Rate(p); e != nil || !reflect.DeepEqual(rating, expected) {
+		t.Errorf("Rating with a context gave %+v, %v", rating, e)
+	}
+	steps, e := ExplainContext(ctx, p)
+	if expected, _ := Explain(p); e != nil || !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Explaining with a context gave %d steps, %v", len(steps), e)
+	}
+}
+
+func TestCanceledOperations(t *testing.T) {
+	// an empty 9x9 puzzle has more solutions than anyone can wait for
+	empty, _ := New(append([]int{SudokuGeometryCode}, make([]int, 81)...))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, e := SolveContext(ctx, empty); e == nil {
+		t.Errorf("Solving past the deadline succeeded")
+	} else if err, ok := e.(Error); !ok || err.Condition != DeadlineExceededCondition {
+		t.Errorf("Solving past the deadline gave %v", e)
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Solve returned before the deadline")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, e := GenerateContext(ctx, GenerateParams{Seed: "golden"}); e == nil {
+		t.Errorf("Canceled generation succeeded")
+	}
+	p, _ := New(goldenSeedValues)
+	if _, e := RateContext(ctx, p, RatingProfiles[0]); e == nil {
+		t.Errorf("Canceled rating succeeded")
+	}
+	if _, e := ExplainContext(ctx, p); e == nil {
+		t.Errorf("Canceled explanation succeeded")
+	}
+}
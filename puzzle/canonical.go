@@ -0,0 +1,156 @@
+package puzzle
+
+/*
+
+Canonical forms
+
+*/
+
+// A gridSymmetry maps a row and column of a grid with the given
+// side length to the row and column they're moved to.
+type gridSymmetry func(r, c, n int) (int, int)
+
+// squareSymmetries are the rotations and reflections of a grid.
+// They all preserve the rows, columns, and square tiles of a
+// Sudoku puzzle, and the diagonals and hyper regions of its
+// variants.
+var squareSymmetries = []gridSymmetry{
+	func(r, c, n int) (int, int) { return r, c },
+	func(r, c, n int) (int, int) { return c, n - 1 - r },
+	func(r, c, n int) (int, int) { return n - 1 - r, n - 1 - c },
+	func(r, c, n int) (int, int) { return n - 1 - c, r },
+	func(r, c, n int) (int, int) { return r, n - 1 - c },
+	func(r, c, n int) (int, int) { return n - 1 - r, c },
+	func(r, c, n int) (int, int) { return c, r },
+	func(r, c, n int) (int, int) { return n - 1 - c, n - 1 - r },
+}
+
+// rectangleSymmetries are the rotations and reflections that
+// don't turn the tiles of rectangular puzzles on their side.
+var rectangleSymmetries = []gridSymmetry{
+	squareSymmetries[0], squareSymmetries[2], squareSymmetries[4], squareSymmetries[5],
+}
+
+// Canonical returns the canonical form of the puzzle with the
+// given geometry code and cell values (in the same form passed
+// to New).  Puzzles that differ only by a symmetry of their
+// geometry (a rotation or reflection that keeps their groups
+// intact) and a relabeling of their digits have the same
+// canonical form, which is the one of those puzzles whose values
+// come first in lexicographic order once its digits are
+// relabeled in order of appearance.  The symmetries of composite
+// puzzles (such as Samurai puzzles) move their whole layout.
+// Geometries registered by other modules are only canonicalized
+// by relabeling, and killer
+// puzzles (whose sums would change) are their own canonical
+// forms.  Canonical returns the same errors as New.
+func Canonical(geoAndValues []int) ([]int, error) {
+	p, e := New(geoAndValues)
+	if e != nil {
+		return nil, e
+	}
+	if killerMapping(geoAndValues[0]) != nil {
+		return append([]int(nil), geoAndValues...), nil
+	}
+	n, digits := p.State().SideLenth, p.State().SideLenth
+	var symmetries []gridSymmetry
+	switch geoAndValues[0] {
+	case SudokuGeometryCode, XSudokuGeometryCode, HyperSudokuGeometryCode, HyperXSudokuGeometryCode,
+		SamuraiGeometryCode:
+		symmetries = squareSymmetries
+	case DudokuGeometryCode:
+		symmetries = rectangleSymmetries
+	default:
+		symmetries = squareSymmetries[:1]
+	}
+	vals := geoAndValues[1:]
+	layout := compositeLayout(geoAndValues[0])
+	if layout != nil {
+		vals, n = layout.expand(vals), layout.side
+	}
+	var best []int
+	for _, symmetry := range symmetries {
+		moved := make([]int, len(vals))
+		for r := 0; r < n; r++ {
+			for c := 0; c < n; c++ {
+				mr, mc := symmetry(r, c, n)
+				moved[mr*n+mc] = vals[r*n+c]
+			}
+		}
+		relabel(moved, digits)
+		if best == nil || lexLess(moved, best) {
+			best = moved
+		}
+	}
+	if layout != nil {
+		best = layout.compress(best)
+	}
+	return append([]int{geoAndValues[0]}, best...), nil
+}
+
+// CanonicalFingerprint returns the fingerprint of a puzzle's
+// canonical form, so that puzzles which are the same up to
+// symmetry and relabeling have the same canonical fingerprint.
+// It returns the same errors as New.
+func CanonicalFingerprint(geoAndValues []int) (string, error) {
+	canonical, e := Canonical(geoAndValues)
+	if e != nil {
+		return "", e
+	}
+	return Fingerprint(canonical), nil
+}
+
+// Equivalent tells whether two puzzles (each given by its
+// geometry code and values, as passed to New) are the same up to
+// symmetry and relabeling of their digits, that is, whether they
+// have the same canonical form.  It returns the same errors as
+// New, for whichever puzzle is invalid.
+func Equivalent(a, b []int) (bool, error) {
+	ca, e := Canonical(a)
+	if e != nil {
+		return false, e
+	}
+	cb, e := Canonical(b)
+	if e != nil {
+		return false, e
+	}
+	if len(ca) != len(cb) {
+		return false, nil
+	}
+	for i := range ca {
+		if ca[i] != cb[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// relabel renumbers the digits (1 through n) in values in place,
+// in order of their first appearance.
+func relabel(values []int, n int) {
+	labels, next := make([]int, n+1), 1
+	for i, v := range values {
+		if v == 0 {
+			continue
+		}
+		if labels[v] == 0 {
+			labels[v], next = next, next+1
+		}
+		values[i] = labels[v]
+	}
+}
+
+// lexLess tells whether a comes before b in lexicographic order.
+// Empty squares come after all the digits, so that puzzles whose
+// givens come earliest in the grid are first.
+func lexLess(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] == 0 || b[i] == 0 {
+				return b[i] == 0
+			}
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+// transformValues applies a symmetry and a digit relabeling to
+// the values of a grid with the given side length.
+func transformValues(vals []int, n int, symmetry gridSymmetry, labels []int) []int {
+	result := make([]int, len(vals))
+	for r := 0; r < n; r++ {
+		for c := 0; c < n; c++ {
+			mr, mc := symmetry(r, c, n)
+			result[mr*n+mc] = labels[vals[r*n+c]]
+		}
+	}
+	return result
+}
+
+func TestCanonical(t *testing.T) {
+	labels := []int{0, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	base := append([]int{SudokuGeometryCode}, oneStarValues...)
+	canonical, e := Canonical(base)
+	if e != nil {
+		t.Fatalf("Canonical failed: %v", e)
+	}
+	if p, e := New(canonical); e != nil || p.IsProper() != nil {
+		t.Errorf("Canonical form isn't a proper puzzle: %v", e)
+	}
+	fingerprint, _ := CanonicalFingerprint(base)
+	for i, symmetry := range squareSymmetries {
+		moved := append([]int{SudokuGeometryCode}, transformValues(oneStarValues, 9, symmetry, labels)...)
+		if c, e := Canonical(moved); e != nil || !reflect.DeepEqual(c, canonical) {
+			t.Errorf("Symmetry %d: canonical form was %v, expected %v", i, c, canonical)
+		}
+		if f, _ := CanonicalFingerprint(moved); f != fingerprint {
+			t.Errorf("Symmetry %d: canonical fingerprint was %s, expected %s", i, f, fingerprint)
+		}
+	}
+	if f, _ := CanonicalFingerprint(append([]int{SudokuGeometryCode}, sixStarValues...)); f == fingerprint {
+		t.Errorf("Different puzzles have the same canonical fingerprint")
+	}
+	if _, e := Canonical([]int{SudokuGeometryCode, 1, 2, 3}); e == nil {
+		t.Errorf("Canonical of an invalid puzzle succeeded")
+	}
+
+	// rectangular puzzles keep their tiles upright
+	rect := make([]int, 36)
+	rect[0], rect[4], rect[13] = 1, 2, 3
+	canonical, _ = Canonical(append([]int{DudokuGeometryCode}, rect...))
+	for i, symmetry := range rectangleSymmetries {
+		moved := append([]int{DudokuGeometryCode}, transformValues(rect, 6, symmetry, []int{0, 3, 1, 2, 4, 5, 6})...)
+		if c, e := Canonical(moved); e != nil || !reflect.DeepEqual(c, canonical) {
+			t.Errorf("Rectangle symmetry %d: canonical form was %v, expected %v", i, c, canonical)
+		}
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	base := append([]int{SudokuGeometryCode}, oneStarValues...)
+	moved := append([]int{SudokuGeometryCode},
+		transformValues(oneStarValues, 9, squareSymmetries[3], []int{0, 2, 3, 4, 5, 6, 7, 8, 9, 1})...)
+	if same, e := Equivalent(base, moved); e != nil || !same {
+		t.Errorf("Equivalent of a moved puzzle gave %v, %v", same, e)
+	}
+	if same, e := Equivalent(base, append([]int{SudokuGeometryCode}, sixStarValues...)); e != nil || same {
+		t.Errorf("Equivalent of different puzzles gave %v, %v", same, e)
+	}
+	if _, e := Equivalent(base, []int{SudokuGeometryCode, 1, 2, 3}); e == nil {
+		t.Errorf("Equivalent of an invalid puzzle succeeded")
+	}
+}
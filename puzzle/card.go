@@ -0,0 +1,234 @@
+package puzzle
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+
+Share cards
+
+A share card is a small image for posting when a puzzle's done:
+it shows how hard the puzzle was (in stars) and how long it took,
+over a pattern of the puzzle's grid that only shows which squares
+were givens, so it can be posted without spoiling the puzzle for
+anyone else.  Cards render as SVG, or as PNG for places that
+don't take SVG; PNG cards don't have the title, since they draw
+their text with a tiny built-in font of digits.
+
+*/
+
+// A ShareCard is the content of a share image.
+type ShareCard struct {
+	Title     string        // SVG only
+	Stars     int           // difficulty; 0 if not known
+	SolveTime time.Duration // 0 if not known
+	layout    layout
+}
+
+// NewShareCard returns the card for a puzzle with the given
+// givens (as for SVGOptions).  Its values don't appear on the
+// card.
+func NewShareCard(p Puzzle, givens []int, title string, stars int, solveTime time.Duration) ShareCard {
+	return ShareCard{title, stars, solveTime, newLayout(p, givens, false, false)}
+}
+
+// Share card dimensions, in pixels: the size of pattern squares,
+// the gap between squares (and the extra gap between tiles), the
+// margin around the card, and the height of the band with the
+// difficulty and time.
+const (
+	cardSquare  = 24
+	cardGap     = 2
+	cardTileGap = 4
+	cardMargin  = 16
+	cardBand    = 44
+)
+
+// Share card colors.
+var (
+	cardBackground = color.RGBA{0xfa, 0xfa, 0xf7, 0xff}
+	cardGiven      = color.RGBA{0x3a, 0x3a, 0x3a, 0xff}
+	cardFilled     = color.RGBA{0x5c, 0xb8, 0x5c, 0xff}
+	cardEmpty      = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	cardText       = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	cardStar       = color.RGBA{0xf0, 0xb4, 0x00, 0xff}
+)
+
+// squareBox returns the position of a pattern square, relative
+// to the top left corner of the pattern.
+func (c ShareCard) squareBox(i int) (x, y int) {
+	l := c.layout
+	col, row := i%l.sidelen, i/l.sidelen
+	x, y = col*(cardSquare+cardGap), row*(cardSquare+cardGap)
+	for j := 0; j < col; j++ {
+		if l.thickRight(j) {
+			x += cardTileGap
+		}
+	}
+	for j := 0; j < row; j++ {
+		if l.thickBelow(j * l.sidelen) {
+			y += cardTileGap
+		}
+	}
+	return x, y
+}
+
+// patternSide returns the width (and height) of the pattern.
+func (c ShareCard) patternSide() int {
+	l := c.layout
+	side := l.sidelen*(cardSquare+cardGap) - cardGap
+	for j := 0; j+1 < l.sidelen; j++ {
+		if l.thickRight(j) {
+			side += cardTileGap
+		}
+	}
+	return side
+}
+
+// squareColor returns the color of a pattern square.
+func (c ShareCard) squareColor(i int) color.RGBA {
+	switch {
+	case c.layout.givens[i]:
+		return cardGiven
+	case c.layout.values[i] != 0:
+		return cardFilled
+	}
+	return cardEmpty
+}
+
+// timeText returns the card's solve time as [h:]mm:ss, or "" if
+// it's not known.
+func (c ShareCard) timeText() string {
+	if c.SolveTime <= 0 {
+		return ""
+	}
+	s := int(c.SolveTime.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// stars returns the card's star count, limited to the rating
+// range.
+func (c ShareCard) stars() int {
+	switch {
+	case c.Stars < 0:
+		return 0
+	case c.Stars > len(techniques):
+		return len(techniques)
+	}
+	return c.Stars
+}
+
+// hexColor returns the SVG notation of a color.
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// RenderCardSVG writes a share card as an SVG image.
+func RenderCardSVG(w io.Writer, c ShareCard) error {
+	side := c.patternSide()
+	titleBand := 0
+	if c.Title != "" {
+		titleBand = cardBand * 3 / 4
+	}
+	width, height := side+2*cardMargin, side+2*cardMargin+titleBand+cardBand
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" rx="8" fill="%s"/>`+"\n", width, height, hexColor(cardBackground))
+	fmt.Fprintf(&b, `<g font-family="sans-serif" font-weight="bold" dominant-baseline="central">`+"\n")
+	if c.Title != "" {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="18" fill="%s">%s</text>`+"\n",
+			cardMargin, cardMargin+titleBand/2, hexColor(cardText), html.EscapeString(c.Title))
+	}
+	band := cardMargin + titleBand + side + cardMargin + cardBand/2
+	if n := c.stars(); n > 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="22" fill="%s">%s</text>`+"\n",
+			cardMargin, band, hexColor(cardStar), strings.Repeat("★", n))
+	}
+	if t := c.timeText(); t != "" {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="22" text-anchor="end" fill="%s">%s</text>`+"\n",
+			width-cardMargin, band, hexColor(cardText), t)
+	}
+	fmt.Fprintf(&b, "</g>\n<g>\n")
+	for i := range c.layout.values {
+		x, y := c.squareBox(i)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="3" fill="%s"/>`+"\n",
+			cardMargin+x, cardMargin+titleBand+y, cardSquare, cardSquare, hexColor(c.squareColor(i)))
+	}
+	fmt.Fprintf(&b, "</g>\n</svg>\n")
+	_, e := io.WriteString(w, b.String())
+	return e
+}
+
+// cardGlyphs are the 5x7 bitmaps of the characters PNG cards can
+// draw, a row to a string.
+var cardGlyphs = map[rune][7]string{
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	':': {".....", "..#..", "..#..", ".....", "..#..", "..#..", "....."},
+	'*': {"..#..", "..#..", "#####", ".###.", ".#.#.", "#...#", "....."},
+}
+
+// cardGlyphScale is the size of glyph pixels in PNG cards.
+const cardGlyphScale = 3
+
+// drawText draws text in the glyph font with its top left corner
+// at (x, y).
+func drawText(img *image.RGBA, text string, x, y int, c color.RGBA) {
+	for _, r := range text {
+		for row, bits := range cardGlyphs[r] {
+			for col, bit := range bits {
+				if bit == '#' {
+					fillRect(img, x+col*cardGlyphScale, y+row*cardGlyphScale, cardGlyphScale, cardGlyphScale, c)
+				}
+			}
+		}
+		x += 6 * cardGlyphScale
+	}
+}
+
+// fillRect fills a rectangle of an image with a color.
+func fillRect(img *image.RGBA, x, y, width, height int, c color.RGBA) {
+	for i := x; i < x+width; i++ {
+		for j := y; j < y+height; j++ {
+			img.SetRGBA(i, j, c)
+		}
+	}
+}
+
+// RenderCardPNG writes a share card as a PNG image.
+func RenderCardPNG(w io.Writer, c ShareCard) error {
+	side := c.patternSide()
+	width, height := side+2*cardMargin, side+2*cardMargin+cardBand
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, 0, 0, width, height, cardBackground)
+	for i := range c.layout.values {
+		x, y := c.squareBox(i)
+		fillRect(img, cardMargin+x, cardMargin+y, cardSquare, cardSquare, c.squareColor(i))
+	}
+	top := cardMargin + side + cardMargin + (cardBand-7*cardGlyphScale)/2
+	drawText(img, strings.Repeat("*", c.stars()), cardMargin, top, cardStar)
+	if t := c.timeText(); t != "" {
+		drawText(img, t, width-cardMargin-len(t)*6*cardGlyphScale+cardGlyphScale, top, cardText)
+	}
+	return png.Encode(w, img)
+}
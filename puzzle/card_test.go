@@ -0,0 +1,81 @@
+package puzzle
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareCard(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(givens)
+	solution := p.Solutions()[0].Values
+	for i, v := range oneStarValues {
+		if v == 0 {
+			p.Assign(Choice{Index: i + 1, Value: solution[i]})
+		}
+	}
+	filled := 0
+	for _, v := range oneStarValues {
+		if v != 0 {
+			filled++
+		}
+	}
+
+	card := NewShareCard(p, givens, "Daily <2026-10-14>", 2, 754*time.Second+400*time.Millisecond)
+	var b strings.Builder
+	if e := RenderCardSVG(&b, card); e != nil {
+		t.Fatalf("RenderCardSVG failed: %v", e)
+	}
+	svg := b.String()
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		if _, e := d.Token(); e != nil {
+			if e != io.EOF {
+				t.Fatalf("Share card isn't XML: %v", e)
+			}
+			break
+		}
+	}
+	if !strings.Contains(svg, "Daily &lt;2026-10-14&gt;") || !strings.Contains(svg, ">★★<") ||
+		!strings.Contains(svg, ">12:34<") {
+		t.Errorf("Share card text is wrong: %s", svg)
+	}
+	// the pattern shows givens and filled squares, not values
+	if n := strings.Count(svg, `fill="#3a3a3a"`); n != filled {
+		t.Errorf("Share card has %d givens, not %d", n, filled)
+	}
+	if n := strings.Count(svg, `fill="#5cb85c"`); n != len(solution)-filled {
+		t.Errorf("Share card has %d filled squares, not %d", n, len(solution)-filled)
+	}
+	// 9 squares, 8 gaps, 2 tile gaps, 2 margins
+	if !strings.Contains(svg, `width="272"`) {
+		t.Errorf("Share card starts %q", svg[:80])
+	}
+
+	var pb bytes.Buffer
+	if e := RenderCardPNG(&pb, card); e != nil {
+		t.Fatalf("RenderCardPNG failed: %v", e)
+	}
+	img, e := png.Decode(&pb)
+	if e != nil {
+		t.Fatalf("Share card isn't a PNG: %v", e)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 272 || bounds.Dy() != 272+cardBand {
+		t.Errorf("PNG share card is %v", bounds)
+	}
+	if c := img.At(cardMargin+1, cardMargin+1); c != cardGiven && c != cardFilled {
+		t.Errorf("PNG share card corner square is %v", c)
+	}
+
+	if got := (ShareCard{SolveTime: 2*time.Hour + 5*time.Second}).timeText(); got != "2:00:05" {
+		t.Errorf("Long solve time is %q", got)
+	}
+	if got := (ShareCard{Stars: 10}).stars(); got != len(techniques) {
+		t.Errorf("Stars are limited to %d, not %d", got, len(techniques))
+	}
+}
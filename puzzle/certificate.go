@@ -0,0 +1,113 @@
+package puzzle
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+
+Solve certificates
+
+A Certificate says that a puzzle was solved: which puzzle (by
+its fingerprint, as in a Provenance), who solved it, when, how
+fast, and how hard the puzzle was, signed by the server that saw
+it happen.  Anyone with the server's public key can check it,
+so a certificate can be shown off, or handed in to a contest.
+It's signed with the same key as provenances, but over a
+different context, so one can't pass for the other.
+
+A certificate can also be printed (see RenderCertificatePDF),
+with the solved grid, the details, and the signature, so a
+printed copy can be checked by typing it in.
+
+*/
+
+// A Certificate is a signed statement of a solve.  Completed is
+// in UTC, and SolveTime is in seconds.
+type Certificate struct {
+	PuzzleID    string    `json:"puzzleID"`
+	SolveID     string    `json:"solveID"`
+	Fingerprint string    `json:"fingerprint"`
+	Solvers     []string  `json:"solvers"`
+	Stars       int       `json:"stars"`
+	SolveTime   float64   `json:"solveTime"`
+	Completed   time.Time `json:"completed"`
+	Issuer      string    `json:"issuer"`
+	Signature   string    `json:"signature,omitempty"`
+}
+
+// certificateContext is prepended to the signed fields, as
+// provenanceContext is for provenances.
+const certificateContext = "susen.go solve certificate v1\n"
+
+// signedBytes returns the bytes a Certificate's signature is
+// over: the context, then the JSON encoding of the Certificate
+// without its signature.
+func (c Certificate) signedBytes() []byte {
+	c.Signature = ""
+	bytes, _ := json.Marshal(c) // strings, numbers, and times always encode
+	return append([]byte(certificateContext), bytes...)
+}
+
+// SignCertificate returns the Certificate, with its completion
+// time in UTC (to the second), signed by its issuer with the
+// given key.
+func SignCertificate(key ed25519.PrivateKey, c Certificate) Certificate {
+	c.Completed = c.Completed.UTC().Truncate(time.Second)
+	c.Signature = base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, c.signedBytes()))
+	return c
+}
+
+// Verify checks that a Certificate was signed with the private
+// key matching the given public key, and (unless geoAndValues is
+// empty) that it's for the puzzle with the given geometry code
+// and cell values.  It returns nil if so, and an Error saying
+// what's wrong if not.
+func (c Certificate) Verify(key ed25519.PublicKey, geoAndValues []int) error {
+	sig, e := base64.RawURLEncoding.DecodeString(c.Signature)
+	if e != nil || len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, c.signedBytes(), sig) {
+		return provenanceError("Certificate signature is invalid")
+	}
+	if len(geoAndValues) > 0 && Fingerprint(geoAndValues) != c.Fingerprint {
+		return provenanceError("Puzzle doesn't match its certificate")
+	}
+	return nil
+}
+
+// RenderCertificatePDF writes a one-page PDF document of a
+// certificate, with the solved puzzle's grid (givens are as for
+// SVG images) and the certificate's details below it.
+func RenderCertificatePDF(w io.Writer, c Certificate, p Puzzle, givens []int) error {
+	page := pdfPage(newLayout(p, givens, false, false), "Certificate of solve: "+c.PuzzleID)
+	stars := fmt.Sprintf("%d stars", c.Stars)
+	if c.Stars == 1 {
+		stars = "1 star"
+	}
+	lines := []string{
+		"Solved by " + strings.Join(c.Solvers, ", "),
+		fmt.Sprintf("on %s in %s, a %s puzzle", c.Completed.UTC().Format("January 2, 2006 at 15:04 UTC"),
+			time.Duration(c.SolveTime*float64(time.Second)).Round(time.Second), stars),
+		"Puzzle fingerprint " + c.Fingerprint,
+		"Solve " + c.SolveID + ", certified by " + c.Issuer,
+		"Signature " + c.Signature,
+	}
+	var b strings.Builder
+	b.WriteString(page)
+	left := float64(pdfPageWidth-pdfGridWidth) / 2
+	y := pdfPageHeight - pdfGridTop - pdfGridWidth - 36
+	for i, line := range lines {
+		size := 12.0
+		if i == len(lines)-1 {
+			size = 7 // it's long
+		}
+		fmt.Fprintf(&b, "BT 0 0 0 rg /F1 %.2f Tf %.2f %d Td %s Tj ET\n", size, left, y, pdfString(line))
+		y -= 20
+	}
+	return writePDF(w, []string{b.String()})
+}
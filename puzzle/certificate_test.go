@@ -0,0 +1,80 @@
+package puzzle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertificate(t *testing.T) {
+	pub, key, e := ed25519.GenerateKey(nil)
+	if e != nil {
+		t.Fatalf("Failed to generate key: %v", e)
+	}
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	completed := time.Date(2026, 3, 14, 15, 9, 26, 535, time.FixedZone("EST", -5*3600))
+	c := SignCertificate(key, Certificate{
+		PuzzleID: "1-star", SolveID: "1", Fingerprint: Fingerprint(givens),
+		Solvers: []string{"Ann", "Bob"}, Stars: 4, SolveTime: 754.5, Completed: completed, Issuer: "test",
+	})
+	if c.Signature == "" || !c.Completed.Equal(completed.Truncate(time.Second)) || c.Completed.Location() != time.UTC {
+		t.Fatalf("Certificate was %+v", c)
+	}
+	if e := c.Verify(pub, givens); e != nil {
+		t.Errorf("Verify of signed certificate failed: %v", e)
+	}
+	if e := c.Verify(pub, nil); e != nil {
+		t.Errorf("Verify of signature alone failed: %v", e)
+	}
+
+	// changed puzzles, certificates, and keys are caught
+	changed := append([]int(nil), givens...)
+	changed[2] = 3
+	if e := c.Verify(pub, changed); e == nil {
+		t.Errorf("Verify of changed puzzle succeeded")
+	}
+	faster := c
+	faster.SolveTime = 60
+	if e := faster.Verify(pub, givens); e == nil {
+		t.Errorf("Verify of changed certificate succeeded")
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if e := c.Verify(other, givens); e == nil {
+		t.Errorf("Verify with another key succeeded")
+	}
+
+	// a provenance signature isn't a certificate signature
+	pv := SignProvenance(key, "test", "1-star", givens)
+	forged := c
+	forged.Signature = pv.Signature
+	if e := forged.Verify(pub, nil); e == nil {
+		t.Errorf("Verify of provenance signature succeeded")
+	}
+}
+
+func TestRenderCertificatePDF(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(givens)
+	solved, _ := New(append([]int{SudokuGeometryCode}, p.Solutions()[0].Values...))
+	c := SignCertificate(key, Certificate{
+		PuzzleID: "1-star", SolveID: "1", Fingerprint: Fingerprint(givens),
+		Solvers: []string{"Ann"}, Stars: 1, SolveTime: 754.5, Completed: time.Now(), Issuer: "test",
+	})
+	var b bytes.Buffer
+	if e := RenderCertificatePDF(&b, c, solved, givens); e != nil {
+		t.Fatalf("RenderCertificatePDF failed: %v", e)
+	}
+	pdf := b.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || strings.Count(pdf, "/Type /Page ") != 1 {
+		t.Fatalf("RenderCertificatePDF isn't a one-page PDF: %q...", pdf[:20])
+	}
+	for _, text := range []string{"(Certificate of solve: 1-star)", "(Solved by Ann)", "12m35s, a 1 star puzzle",
+		"(Puzzle fingerprint " + c.Fingerprint + ")", "(Signature " + c.Signature + ")"} {
+		if !strings.Contains(pdf, text) {
+			t.Errorf("RenderCertificatePDF is missing %q", text)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package puzzle
+
+/*
+
+Chains
+
+Simple coloring and forcing chains both follow a line of
+reasoning from each of two alternatives (the two colors of a
+value, or the two values of a square) to something that's true
+either way.  The rater keeps that reasoning as a Chain, which
+hints pass on so that a player can be shown why the hinted value
+goes where it does.
+
+Forcing chains only follow singles, and give up on a branch
+after maxChainLength of them, so that they stay short enough for
+a person to check.
+
+*/
+
+// maxChainLength is the most singles that a forcing chain follows
+// from each of its premises.
+const maxChainLength = 10
+
+// A Chain is the reasoning behind a coloring or forcing chain.
+// Each of its branches starts with a premise (the first Choice)
+// and lists the values that follow from it, in order.  For
+// simple coloring, the branches are the squares of the two
+// colors, each with the colored value.  Placed are the values
+// that follow from every branch that doesn't end in a
+// contradiction, and Removed are the pencil marks that none of
+// them allow.
+type Chain struct {
+	Technique string     `json:"technique"`
+	Branches  [][]Choice `json:"branches"`
+	Placed    []Choice   `json:"placed,omitempty"`
+	Removed   []Choice   `json:"removed,omitempty"`
+}
+
+// sees tells whether two squares share a group.
+func (r *rater) sees(a, b int) bool {
+	for _, ga := range r.mapping.ixmap[a] {
+		for _, gb := range r.mapping.ixmap[b] {
+			if ga == gb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coloring colors the squares linked by each value in turn, and
+// removes the value from the first color or squares it finds
+// that can't have it.
+func (r *rater) coloring() bool {
+	for v := 1; v <= r.mapping.sidelen; v++ {
+		links := make(map[int][]int)
+		for gi := 1; gi <= r.mapping.gcount; gi++ {
+			if is := r.where(&r.mapping.gdescs[gi], v); len(is) == 2 {
+				links[is[0]] = append(links[is[0]], is[1])
+				links[is[1]] = append(links[is[1]], is[0])
+			}
+		}
+		colored := make(map[int]bool)
+		for start := 1; start <= r.mapping.scount; start++ {
+			if len(links[start]) == 0 || colored[start] {
+				continue
+			}
+			// color the squares linked to this one
+			var colors [2][]int
+			color := map[int]int{start: 0}
+			for queue := []int{start}; len(queue) > 0; queue = queue[1:] {
+				i := queue[0]
+				colored[i] = true
+				colors[color[i]] = append(colors[color[i]], i)
+				for _, j := range links[i] {
+					if _, ok := color[j]; !ok {
+						color[j] = 1 - color[i]
+						queue = append(queue, j)
+					}
+				}
+			}
+			if len(colors[1]) == 0 {
+				continue
+			}
+			var removed []Choice
+			// a color that sees itself is wrong
+			for c := 0; c < 2 && removed == nil; c++ {
+				for a := 0; a < len(colors[c]) && removed == nil; a++ {
+					for b := a + 1; b < len(colors[c]); b++ {
+						if r.sees(colors[c][a], colors[c][b]) {
+							for _, i := range colors[c] {
+								removed = append(removed, Choice{i, v})
+							}
+							break
+						}
+					}
+				}
+			}
+			// squares that see both colors are wrong
+			if removed == nil {
+				for i := 1; i <= r.mapping.scount; i++ {
+					if _, ok := color[i]; ok || r.values[i] != 0 || !r.cands[i].has(v) {
+						continue
+					}
+					if r.seesAny(i, colors[0]) && r.seesAny(i, colors[1]) {
+						removed = append(removed, Choice{i, v})
+					}
+				}
+			}
+			if removed == nil {
+				continue
+			}
+			for _, choice := range removed {
+				r.cands[choice.Index].remove(v)
+			}
+			r.chain = &Chain{TechniqueColoring, [][]Choice{colorChoices(colors[0], v), colorChoices(colors[1], v)}, nil, removed}
+			return true
+		}
+	}
+	return false
+}
+
+// seesAny tells whether a square shares a group with any of the
+// given squares.
+func (r *rater) seesAny(i int, is []int) bool {
+	for _, j := range is {
+		if r.sees(i, j) {
+			return true
+		}
+	}
+	return false
+}
+
+// colorChoices returns the choices of a value for the squares of
+// a color.
+func colorChoices(is []int, v int) []Choice {
+	choices := make([]Choice, len(is))
+	for k, i := range is {
+		choices[k] = Choice{i, v}
+	}
+	return choices
+}
+
+// forcingChain follows the singles from both values of each
+// square that can only take two, and places the first value that
+// either of them forces.
+func (r *rater) forcingChain() bool {
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] != 0 || r.cands[i].len() != 2 {
+			continue
+		}
+		a, b := Choice{i, r.cands[i].first()}, Choice{i, r.cands[i].last()}
+		ba, okA := r.branch(a)
+		bb, okB := r.branch(b)
+		var placed Choice
+		switch {
+		case okA == okB:
+			if !okA {
+				continue
+			}
+			for ka, ca := range ba[1:] {
+				for kb, cb := range bb[1:] {
+					if ca == cb {
+						placed, ba, bb = ca, ba[:ka+2], bb[:kb+2]
+						break
+					}
+				}
+				if placed.Index != 0 {
+					break
+				}
+			}
+			if placed.Index == 0 {
+				continue
+			}
+		case okA:
+			placed = a
+		default:
+			placed = b
+		}
+		r.place(placed.Index, placed.Value)
+		r.chain = &Chain{TechniqueChain, [][]Choice{ba, bb}, []Choice{placed}, nil}
+		return true
+	}
+	return false
+}
+
+// branch follows the singles from a premise on a copy of the
+// worksheet, returning the premise and the values that follow
+// from it, and whether they're free of contradictions.
+func (r *rater) branch(premise Choice) ([]Choice, bool) {
+	c := &rater{
+		mapping: r.mapping,
+		values:  append([]int(nil), r.values...),
+		cands:   append([]valset(nil), r.cands...),
+		counts:  make([]int, len(techniques)),
+	}
+	c.place(premise.Index, premise.Value)
+	links := []Choice{premise}
+	for {
+		if c.broken() {
+			return links, false
+		}
+		if len(links) > maxChainLength || !(c.nakedSingle() || c.hiddenSingle()) {
+			return links, true
+		}
+		links = append(links, c.placed)
+	}
+}
+
+// broken tells whether the worksheet has a contradiction: an
+// empty square that can't take any value, or a group with no
+// square for a value it needs.
+func (r *rater) broken() bool {
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] == 0 && r.cands[i].empty() {
+			return true
+		}
+	}
+	full := newValsetRange(r.mapping.sidelen)
+	for gi := 1; gi <= r.mapping.gcount; gi++ {
+		gd := &r.mapping.gdescs[gi]
+		var have valset
+		for _, i := range gd.indices {
+			if r.values[i] != 0 {
+				have.insert(r.values[i])
+			}
+			have.merge(r.cands[i])
+		}
+		if have != full {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package puzzle
+
+import (
+	"testing"
+)
+
+// helperChainPuzzle returns a generated puzzle and a rater's
+// worksheet for it.
+func helperChainPuzzle(t *testing.T, seed string) (*puzzle, *rater) {
+	g, e := Generate(GenerateParams{Seed: seed})
+	if e != nil {
+		t.Fatalf("Failed to generate puzzle %s: %v", seed, e)
+	}
+	p, e := New(g.Values)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle %s: %v", seed, e)
+	}
+	return p.(*puzzle), newRater(p.(*puzzle))
+}
+
+func TestChains(t *testing.T) {
+	tcs := []struct {
+		seed      string
+		technique string
+	}{
+		{"1:s22", TechniqueColoring},
+		{"1:s1", TechniqueChain},
+	}
+	for i, tc := range tcs {
+		p, r := helperChainPuzzle(t, tc.seed)
+		solution := p.Solutions()[0].Values
+		before := append([]int(nil), r.values...)
+		for r.chain == nil {
+			copy(before, r.values)
+			if !r.step() {
+				break
+			}
+		}
+		c := r.chain
+		if c == nil || c.Technique != tc.technique || len(c.Branches) != 2 {
+			t.Fatalf("test %d: chain was %+v", i+1, c)
+		}
+		for _, choice := range c.Placed {
+			if solution[choice.Index-1] != choice.Value {
+				t.Errorf("test %d: chain placed %+v, solution has %d", i+1, choice, solution[choice.Index-1])
+			}
+		}
+		for _, choice := range c.Removed {
+			if solution[choice.Index-1] == choice.Value {
+				t.Errorf("test %d: chain removed %+v from the solution", i+1, choice)
+			}
+		}
+		if len(c.Placed)+len(c.Removed) == 0 {
+			t.Errorf("test %d: chain %+v did nothing", i+1, c)
+		}
+		for _, branch := range c.Branches {
+			if len(branch) == 0 || len(branch) > maxChainLength+1 {
+				t.Errorf("test %d: chain has branch %+v", i+1, branch)
+			}
+		}
+
+		// hints carry the chain
+		stuck, e := New(append([]int{SudokuGeometryCode}, before[1:]...))
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		h, e := Suggest(stuck)
+		if e != nil {
+			t.Fatalf("test %d: Suggest failed: %v", i+1, e)
+		}
+		if h.Chain == nil || solution[h.Choice.Index-1] != h.Choice.Value {
+			t.Errorf("test %d: got hint %+v", i+1, h)
+		}
+	}
+}
+
+func TestBranch(t *testing.T) {
+	p, e := helperNewSudokuPuzzle(oneStarValues)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	r := newRater(p)
+	solution := p.Solutions()[0].Values
+	empty := 1
+	for r.values[empty] != 0 {
+		empty++
+	}
+
+	// the right value follows the solution
+	links, ok := r.branch(Choice{empty, solution[empty-1]})
+	if !ok || len(links) != maxChainLength+1 {
+		t.Errorf("Right branch gave %v, %v", links, ok)
+	}
+	for _, choice := range links {
+		if solution[choice.Index-1] != choice.Value {
+			t.Errorf("Right branch placed %+v", choice)
+		}
+	}
+	if r.values[empty] != 0 {
+		t.Errorf("Branching changed the worksheet")
+	}
+
+	// a branch that empties a square is broken
+	p, e = helperNewEmptySudokuPuzzle(4)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	r = newRater(p)
+	r.cands[2] = newValset(1)
+	if links, ok = r.branch(Choice{1, 1}); ok || len(links) != 1 {
+		t.Errorf("Broken branch gave %v, %v", links, ok)
+	}
+}
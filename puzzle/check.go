@@ -0,0 +1,115 @@
+package puzzle
+
+import (
+	"context"
+)
+
+/*
+
+Checking choices
+
+A client that wants to try a choice before committing it can
+check it: the choice is assigned to a copy of the puzzle, so the
+puzzle itself is untouched, and the check says whether the
+assignment is legal, which values it would eliminate from the
+other squares, what errors it would cause, and whether the
+puzzle could still be solved.
+
+*/
+
+// An Elimination is the values a choice would remove from the
+// possible values of an empty square.
+type Elimination struct {
+	Index  int   `json:"index"`
+	Values []int `json:"values"`
+}
+
+// A CheckResult is what assigning a choice would do.  A choice
+// that isn't Legal can't be assigned at all, and Error says why.
+// A legal choice can still cause Errors, in which case the puzzle
+// isn't Solvable; otherwise, it's Solvable if the puzzle still
+// has a solution with the choice.
+type CheckResult struct {
+	Choice       Choice        `json:"choice"`
+	Legal        bool          `json:"legal"`
+	Error        *Error        `json:"error,omitempty"`
+	Eliminations []Elimination `json:"eliminations"`
+	Errors       []Error       `json:"errors,omitempty"`
+	Solvable     bool          `json:"solvable"`
+}
+
+// Check finds what assigning a choice to the puzzle would do,
+// without changing the puzzle.  The search for a solution stops
+// when the context is done, and the check fails.
+func Check(ctx context.Context, p Puzzle, choice Choice) (CheckResult, error) {
+	result := CheckResult{Choice: choice, Eliminations: []Elimination{}}
+	before := p.Squares()
+	next := p.Copy()
+	update, e := next.Assign(choice)
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return result, e
+		}
+		err.Message = err.Error()
+		result.Error = &err
+		return result, nil
+	}
+	result.Legal = true
+	for _, s := range update.Squares {
+		if s.Index == choice.Index || s.Index < 1 || s.Index > len(before) {
+			continue
+		}
+		if removed := subtractValues(possibleValues(before[s.Index-1]), possibleValues(s)); len(removed) > 0 {
+			result.Eliminations = append(result.Eliminations, Elimination{s.Index, removed})
+		}
+	}
+	for _, err := range update.Errors {
+		err.Message = err.Error()
+		result.Errors = append(result.Errors, err)
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+	solutions, e := SolveWatched(next, func(progress Progress) bool {
+		return ctx.Err() == nil && progress.Solutions == 0
+	})
+	if ctx.Err() != nil {
+		return result, canceledError(ctx)
+	}
+	if e != nil && len(solutions) == 0 {
+		return result, e // the search is only stopped once it finds one
+	}
+	result.Solvable = len(solutions) > 0
+	return result, nil
+}
+
+// possibleValues returns the values an empty square can still
+// take, which are none for a filled square.
+func possibleValues(s Square) []int {
+	switch {
+	case s.Aval != 0:
+		return nil
+	case len(s.Pvals) > 0:
+		return s.Pvals
+	case s.Bval != 0:
+		return []int{s.Bval}
+	}
+	return nil
+}
+
+// subtractValues returns the values of one list that aren't in
+// another.
+func subtractValues(from, minus []int) []int {
+	var left []int
+	for _, v := range from {
+		found := false
+		for _, m := range minus {
+			found = found || m == v
+		}
+		if !found {
+			left = append(left, v)
+		}
+	}
+	return left
+}
@@ -0,0 +1,55 @@
+package puzzle
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	p, _ := New(append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...))
+	solution := p.Solutions()[0].Values
+	before := p.Squares()
+
+	// the right value is legal and solvable, and eliminates the
+	// value from its square's empty peers
+	result, e := Check(context.Background(), p, Choice{2, solution[1]})
+	if e != nil || !result.Legal || !result.Solvable || len(result.Errors) != 0 || len(result.Eliminations) == 0 {
+		t.Fatalf("Check of the right value gave %+v, %v", result, e)
+	}
+	for _, elim := range result.Eliminations {
+		if !reflect.DeepEqual(elim.Values, []int{solution[1]}) {
+			t.Errorf("Right value made elimination %+v", elim)
+		}
+	}
+	if !reflect.DeepEqual(p.Squares(), before) {
+		t.Errorf("Check changed the puzzle")
+	}
+
+	// a filled square can't be assigned at all
+	result, e = Check(context.Background(), p, Choice{1, 2})
+	if e != nil || result.Legal || result.Error == nil || result.Error.Message == "" || result.Solvable {
+		t.Errorf("Check of a filled square gave %+v, %v", result, e)
+	}
+
+	// a legal choice can leave the puzzle unsolvable
+	p, _ = New(append([]int{SudokuGeometryCode}, empty4PuzzleValues...))
+	for _, choice := range []Choice{{1, 1}, {2, 2}, {5, 3}} {
+		p.Assign(choice)
+	}
+	result, e = Check(context.Background(), p, Choice{14, 4})
+	if e != nil || !result.Legal || len(result.Errors) == 0 || result.Solvable {
+		t.Errorf("Check of an unsolvable choice gave %+v, %v", result, e)
+	}
+	result, e = Check(context.Background(), p, Choice{3, 3})
+	if e != nil || !result.Legal || len(result.Errors) != 0 || !result.Solvable {
+		t.Errorf("Check of a solvable choice gave %+v, %v", result, e)
+	}
+
+	// and checks give up with their contexts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, e := Check(ctx, p, Choice{3, 3}); e == nil {
+		t.Errorf("Check with a canceled context succeeded")
+	}
+}
@@ -0,0 +1,152 @@
+package puzzle
+
+import (
+	"fmt"
+)
+
+/*
+
+Checkpoints
+
+*/
+
+// A Tag names a checkpoint of a puzzle (see Puzzle.Checkpoint).
+// Tags are only meaningful to the puzzle that issued them, and
+// the zero Tag never names a checkpoint.
+type Tag int
+
+// checkpointError returns the Error for rolling back to a tag
+// that doesn't name one of the puzzle's checkpoints.
+func checkpointError(tag Tag) Error {
+	err := Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{fmt.Sprintf("No checkpoint %d", tag)},
+	}
+	err.Message = err.Error()
+	return err
+}
+
+// Checkpoint saves the puzzle's state, and returns the tag to roll
+// back to it with.  Checkpoints nest: each one is taken after the
+// ones before it, and rolling back to a checkpoint discards the
+// ones taken after it.  Copies of a puzzle start with no
+// checkpoints.
+func (p *puzzle) Checkpoint() Tag {
+	p.saved = append(p.saved, p.copy())
+	return Tag(len(p.saved))
+}
+
+// Rollback returns the puzzle to the state it was in when the
+// tagged checkpoint was taken, discarding every change since then
+// (including pencil marks) and every later checkpoint.  The
+// tagged checkpoint itself is kept, so a puzzle can be rolled
+// back to it again.  It's an Error to roll back to a tag that
+// doesn't name one of the puzzle's remaining checkpoints.
+func (p *puzzle) Rollback(tag Tag) error {
+	if tag < 1 || int(tag) > len(p.saved) {
+		return checkpointError(tag)
+	}
+	return p.watch(p, func() error {
+		saved, watching := p.saved[:tag], p.observers
+		*p = *saved[tag-1].copy()
+		p.saved, p.observers = saved, watching
+		return nil
+	})
+}
+
+// Checkpoint saves the contest puzzle's state, as for puzzles.
+func (c *contestPuzzle) Checkpoint() Tag {
+	c.saved = append(c.saved, c.Copy().(*contestPuzzle))
+	return Tag(len(c.saved))
+}
+
+// Rollback returns the contest puzzle to a checkpoint, as for
+// puzzles.
+func (c *contestPuzzle) Rollback(tag Tag) error {
+	if tag < 1 || int(tag) > len(c.saved) {
+		return checkpointError(tag)
+	}
+	return c.watch(c, func() error {
+		saved, watching := c.saved[:tag], c.observers
+		*c = *saved[tag-1].Copy().(*contestPuzzle)
+		c.saved, c.observers = saved, watching
+		return nil
+	})
+}
+
+// discardCheckpoint drops the tagged checkpoint, and any later
+// ones, from a puzzle that's done with them.
+func discardCheckpoint(p Puzzle, tag Tag) {
+	switch p := p.(type) {
+	case *puzzle:
+		if tag >= 1 && int(tag) <= len(p.saved) {
+			p.saved = p.saved[:tag-1]
+		}
+	case *contestPuzzle:
+		if tag >= 1 && int(tag) <= len(p.saved) {
+			p.saved = p.saved[:tag-1]
+		}
+	case *relaxedPuzzle:
+		if tag >= 1 && int(tag) <= len(p.saved) {
+			p.saved = p.saved[:tag-1]
+		}
+	}
+}
+
+// AssignAll assigns a sequence of choices to a puzzle as a unit:
+// either every choice is assigned, or the puzzle is unchanged.
+// On success, it returns the Update from each assignment, in
+// order.  Otherwise it returns the Error from the first choice
+// that couldn't be assigned.  Choices are assigned in order, so
+// a choice can't be assigned once an earlier one has made the
+// puzzle unsolvable.
+func AssignAll(p Puzzle, choices []Choice) ([]Update, error) {
+	if len(choices) == 0 {
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: EmptyArgumentCondition,
+		}
+	}
+	tag := p.Checkpoint()
+	defer discardCheckpoint(p, tag)
+	updates := make([]Update, 0, len(choices))
+	for _, choice := range choices {
+		update, e := p.Assign(choice)
+		if e != nil {
+			if re := p.Rollback(tag); re != nil {
+				return nil, re
+			}
+			return nil, e
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+// MergeUpdates combines the Updates from a sequence of
+// assignments into a single Update, as if they had been one
+// assignment: each changed square appears once, as it was last
+// changed, and the errors, solved flag, conflicts, and progress
+// are those of the last Update.
+func MergeUpdates(updates []Update) Update {
+	if len(updates) == 0 {
+		return Update{}
+	}
+	last := updates[len(updates)-1]
+	merged := Update{Errors: last.Errors, Solved: last.Solved, Conflicts: last.Conflicts, Progress: last.Progress}
+	where := make(map[int]int)
+	for _, update := range updates {
+		for _, s := range update.Squares {
+			if i, ok := where[s.Index]; ok {
+				merged.Squares[i] = s
+			} else {
+				where[s.Index] = len(merged.Squares)
+				merged.Squares = append(merged.Squares, s)
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,101 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p1, _ := New(givens)
+	p2, _ := NewContest(givens)
+	for i, p := range []Puzzle{p1, p2} {
+		before := p.Squares()
+		tag := p.Checkpoint()
+		for j := 0; j < 2; j++ {
+			if _, e := p.Assign(Choice{13, 2}); e != nil {
+				t.Fatalf("Case %d: Assign failed: %v", i, e)
+			}
+			if _, e := p.MarkCandidate(Choice{2, 4}); e != nil {
+				t.Fatalf("Case %d: Mark failed: %v", i, e)
+			}
+			if e := p.Rollback(tag); e != nil {
+				t.Fatalf("Case %d, rollback %d: Rollback failed: %v", i, j, e)
+			}
+			if after := p.Squares(); !reflect.DeepEqual(after, before) {
+				t.Errorf("Case %d, rollback %d: Squares were %v, expected %v", i, j, after, before)
+			}
+		}
+
+		// nested checkpoints are discarded by rolling back past them
+		p.Assign(Choice{13, 2})
+		middle := p.Squares()
+		inner := p.Checkpoint()
+		p.Assign(Choice{10, 4})
+		if e := p.Rollback(inner); e != nil || !reflect.DeepEqual(p.Squares(), middle) {
+			t.Errorf("Case %d: Rollback to inner checkpoint gave %v", i, e)
+		}
+		if e := p.Rollback(tag); e != nil || !reflect.DeepEqual(p.Squares(), before) {
+			t.Errorf("Case %d: Rollback to outer checkpoint gave %v", i, e)
+		}
+		if e, ok := p.Rollback(inner).(Error); !ok || e.Condition != GeneralCondition {
+			t.Errorf("Case %d: Rollback to discarded checkpoint gave %v", i, e)
+		}
+		if e := p.Rollback(0); e == nil {
+			t.Errorf("Case %d: Rollback to the zero tag succeeded", i)
+		}
+		if e := p.Copy().Rollback(tag); e == nil {
+			t.Errorf("Case %d: Copy kept checkpoints", i)
+		}
+	}
+}
+
+func TestAssignAll(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	choices := []Choice{{13, 2}, {10, 4}, {15, 4}}
+	p1, _ := New(givens)
+	p2, _ := New(givens)
+	updates, e := AssignAll(p1, choices)
+	if e != nil || len(updates) != len(choices) {
+		t.Fatalf("AssignAll gave %v, %v", updates, e)
+	}
+	for i, choice := range choices {
+		if update, _ := p2.Assign(choice); !reflect.DeepEqual(updates[i], update) {
+			t.Errorf("Update %d was %+v, expected %+v", i, updates[i], update)
+		}
+	}
+
+	// a bad choice leaves the puzzle unchanged
+	p, _ := New(givens)
+	before := p.Squares()
+	_, e = AssignAll(p, []Choice{{13, 2}, {10, 4}, {14, 2}})
+	if e == nil || e.(Error).Condition != DuplicateAssignmentCondition {
+		t.Errorf("AssignAll of a bad choice gave error %v", e)
+	}
+	if after := p.Squares(); !reflect.DeepEqual(after, before) {
+		t.Errorf("Failed AssignAll changed the puzzle to %v", after)
+	}
+	if e = p.Rollback(1); e == nil {
+		t.Errorf("AssignAll left its checkpoint behind")
+	}
+	if _, e = AssignAll(p, nil); e == nil || e.(Error).Condition != EmptyArgumentCondition {
+		t.Errorf("AssignAll of no choices gave error %v", e)
+	}
+}
+
+func TestMergeUpdates(t *testing.T) {
+	if merged := MergeUpdates(nil); !reflect.DeepEqual(merged, Update{}) {
+		t.Errorf("Merge of no updates was %+v", merged)
+	}
+	updates := []Update{
+		{Squares: []Square{{Index: 2, Pvals: intset{1, 3}}, {Index: 5, Aval: 1}}},
+		{Squares: []Square{{Index: 2, Aval: 3}, {Index: 7, Bval: 2}}, Solved: true},
+	}
+	expected := Update{
+		Squares: []Square{{Index: 2, Aval: 3}, {Index: 5, Aval: 1}, {Index: 7, Bval: 2}},
+		Solved:  true,
+	}
+	if merged := MergeUpdates(updates); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Merged update was %+v, expected %+v", merged, expected)
+	}
+}
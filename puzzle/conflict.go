@@ -0,0 +1,76 @@
+package puzzle
+
+/*
+
+Conflicts
+
+A puzzle's errors say what went wrong as it was being filled in,
+but not where it stands now.  Its conflicts do: each one is a
+contradiction in the puzzle's current squares, naming the
+squares involved and (for conflicts within a group) the group
+and value, so a client can point them out.
+
+*/
+
+// Conflict kinds.  These are human-readable but not localized.
+const (
+	ConflictDuplicate    = "duplicate"     // a group has a value in more than one square
+	ConflictNoPlace      = "no place"      // a group's empty squares can't take a value it needs
+	ConflictNoCandidates = "no candidates" // an empty square can't take any value
+)
+
+// A Conflict is a contradiction in a puzzle's squares.  Squares
+// are the indices of the squares involved: for a duplicate, the
+// squares with the value; for a value with no place, the empty
+// squares of the group; and for a square with no candidates, the
+// square itself (which isn't in a conflicting group, so it has
+// no Group or Value).
+type Conflict struct {
+	Kind    string   `json:"kind"`
+	Group   *GroupID `json:"group,omitempty"`
+	Value   int      `json:"value,omitempty"`
+	Squares []int    `json:"squares"`
+}
+
+// Conflicts returns the puzzle's conflicts: first those in
+// groups, in group order, and then the squares with no
+// candidates, in index order.  A puzzle with no conflicts may
+// still be unsolvable.
+func (p *puzzle) Conflicts() []Conflict {
+	var conflicts []Conflict
+	for gi := 1; gi <= p.mapping.gcount; gi++ {
+		gd := &p.mapping.gdescs[gi]
+		where := make([]intset, p.mapping.sidelen+1) // 1-based values
+		var empty intset
+		var possible valset
+		for _, i := range gd.indices {
+			if v := p.squares[i].aval; v != 0 {
+				where[v] = append(where[v], i)
+			} else {
+				empty = append(empty, i)
+				possible.merge(p.squares[i].pvals)
+			}
+		}
+		id := gd.id
+		for v := 1; v <= p.mapping.sidelen; v++ {
+			switch {
+			case len(where[v]) > 1:
+				conflicts = append(conflicts, Conflict{ConflictDuplicate, &id, v, where[v]})
+			case len(where[v]) == 0 && len(empty) > 0 && !possible.has(v):
+				conflicts = append(conflicts, Conflict{ConflictNoPlace, &id, v, empty})
+			}
+		}
+	}
+	for i := 1; i <= p.mapping.scount; i++ {
+		if p.squares[i].aval == 0 && p.squares[i].pvals.empty() {
+			conflicts = append(conflicts, Conflict{Kind: ConflictNoCandidates, Squares: []int{i}})
+		}
+	}
+	return conflicts
+}
+
+// Conflicts are never revealed for contest puzzles, since they
+// would say which entries are wrong.
+func (c *contestPuzzle) Conflicts() []Conflict {
+	return nil
+}
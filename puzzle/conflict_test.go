@@ -0,0 +1,55 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConflicts(t *testing.T) {
+	// proper puzzles have no conflicts
+	p, _ := New(append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...))
+	if conflicts := p.Conflicts(); conflicts != nil {
+		t.Errorf("Proper puzzle had conflicts %+v", conflicts)
+	}
+
+	// duplicate entries conflict in every group they share, and
+	// here they also crowd a value out of the tile below
+	dup := append([]int{SudokuGeometryCode}, empty4PuzzleValues...)
+	dup[1], dup[2] = 1, 1
+	p, _ = New(dup)
+	expected := []Conflict{
+		{ConflictDuplicate, &GroupID{GtypeRow, 1}, 1, []int{1, 2}},
+		{ConflictDuplicate, &GroupID{GtypeTile, 1}, 1, []int{1, 2}},
+		{ConflictNoPlace, &GroupID{GtypeTile, 3}, 1, []int{9, 10, 13, 14}},
+	}
+	if conflicts := p.Conflicts(); !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Duplicate entries gave conflicts %+v, expected %+v", conflicts, expected)
+	}
+
+	// a legal assignment can leave a square with no candidates
+	p, _ = New(append([]int{SudokuGeometryCode}, empty4PuzzleValues...))
+	for _, choice := range []Choice{{1, 1}, {2, 2}, {5, 3}} {
+		p.Assign(choice)
+	}
+	update, e := p.Assign(Choice{14, 4})
+	if e != nil {
+		t.Fatalf("Assign failed: %v", e)
+	}
+	expected = []Conflict{
+		{ConflictNoPlace, &GroupID{GtypeCol, 1}, 4, []int{9, 13}},
+		{ConflictNoPlace, &GroupID{GtypeTile, 1}, 4, []int{6}},
+		{Kind: ConflictNoCandidates, Squares: []int{6}},
+	}
+	if !reflect.DeepEqual(update.Conflicts, expected) {
+		t.Errorf("Update conflicts were %+v, expected %+v", update.Conflicts, expected)
+	}
+	if conflicts := p.Conflicts(); !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Puzzle conflicts were %+v, expected %+v", conflicts, expected)
+	}
+
+	// contest puzzles don't reveal conflicts
+	c, _ := NewContest(dup)
+	if conflicts := c.Conflicts(); conflicts != nil {
+		t.Errorf("Contest puzzle had conflicts %+v", conflicts)
+	}
+}
@@ -0,0 +1,154 @@
+package puzzle
+
+/*
+
+Contest puzzles
+
+*/
+
+// A contestPuzzle is a Puzzle implementation that withholds all
+// solution data from its clients: it reports only the given and
+// entered values of its squares (plus any pencil marks), never
+// possible or bound values, and never errors.  Assignments are
+// recorded without being checked against the puzzle's
+// constraints, so the only way to find out whether the entries
+// are right is to submit them for verification.
+type contestPuzzle struct {
+	sidelen int
+	givens  []int // geometry code followed by given values
+	values  []int // geometry code followed by current values
+	marks   []intset
+	saved   []*contestPuzzle // checkpoints, oldest first
+	observers
+}
+
+// NewContest returns a contest Puzzle with the given geometry
+// code and cell values (in the same form passed to New).  The
+// returned Puzzle never reveals possible values, bindings,
+// errors, or solutions.  It returns the same errors as New.
+func NewContest(geoAndValues []int) (Puzzle, error) {
+	p, e := New(geoAndValues)
+	if e != nil {
+		return nil, e
+	}
+	return &contestPuzzle{
+		sidelen: p.State().SideLenth,
+		givens:  append([]int(nil), geoAndValues...),
+		values:  append([]int(nil), geoAndValues...),
+		marks:   make([]intset, len(geoAndValues)),
+	}, nil
+}
+
+// State returns the current values, with no errors.
+func (c *contestPuzzle) State() State {
+	return State{c.values[0], c.sidelen, append([]int(nil), c.values[1:]...), nil, false}
+}
+
+// Squares returns only the values and pencil marks of the squares.
+func (c *contestPuzzle) Squares() []Square {
+	SS := make([]Square, len(c.values)-1)
+	for i := range SS {
+		SS[i] = c.square(i + 1)
+	}
+	return SS
+}
+
+// square returns the redacted Square for an index.
+func (c *contestPuzzle) square(idx int) Square {
+	S := Square{Index: idx, Aval: c.values[idx]}
+	if m := killerMapping(c.values[0]); m != nil {
+		if n := m.cageOf[idx]; n != 0 {
+			S.Cage, S.Sum = n, m.cages[n-1].Sum // cages are structure, not solution data
+		}
+	}
+	if S.Aval == 0 && len(c.marks[idx]) > 0 {
+		S.Marks = newIntsetCopy(c.marks[idx])
+	}
+	return S
+}
+
+// Solutions are never revealed for contest puzzles.
+func (c *contestPuzzle) Solutions() []Solution {
+	return nil
+}
+
+// Assign records the chosen value in the chosen square, without
+// checking it against the puzzle's constraints.  Only
+// out-of-range choices and assignments to already-filled squares
+// are refused.
+func (c *contestPuzzle) Assign(choice Choice) (Update, error) {
+	if err := c.checkChoice(choice); err != nil {
+		return Update{}, err
+	}
+	c.watch(c, func() error {
+		c.values[choice.Index] = choice.Value
+		return nil
+	})
+	return Update{Squares: []Square{c.square(choice.Index)}}, nil
+}
+
+// MarkCandidate pencils a value into an empty square.
+func (c *contestPuzzle) MarkCandidate(choice Choice) (Update, error) {
+	if err := c.checkChoice(choice); err != nil {
+		return Update{}, err
+	}
+	c.marks[choice.Index].insert(choice.Value)
+	return Update{Squares: []Square{c.square(choice.Index)}}, nil
+}
+
+// UnmarkCandidate erases a pencilled value from an empty square.
+func (c *contestPuzzle) UnmarkCandidate(choice Choice) (Update, error) {
+	if err := c.checkChoice(choice); err != nil {
+		return Update{}, err
+	}
+	c.marks[choice.Index].remove(choice.Value)
+	return Update{Squares: []Square{c.square(choice.Index)}}, nil
+}
+
+// checkChoice returns an Error if the choice is out of range or
+// targets a filled square.
+func (c *contestPuzzle) checkChoice(choice Choice) error {
+	idx, val := choice.Index, choice.Value
+	if idx < 1 || idx >= len(c.values) {
+		return rangeError(IndexAttribute, idx, 1, len(c.values)-1)
+	}
+	if val < 1 || val > c.sidelen {
+		return rangeError(ValueAttribute, val, 1, c.sidelen)
+	}
+	if c.values[idx] != 0 {
+		err := Error{
+			Scope:     ArgumentScope,
+			Structure: AttributeValueStructure,
+			Attribute: AssignedValueAttribute,
+			Condition: DuplicateAssignmentCondition,
+			Values:    ErrorData{val, idx, c.values[idx]},
+		}
+		err.Message = err.Error()
+		return err
+	}
+	return nil
+}
+
+// IsProper checks the givens, not the entries: whether the
+// entries are right is only revealed on submission.
+func (c *contestPuzzle) IsProper() error {
+	p, e := New(c.givens)
+	if e != nil {
+		return e
+	}
+	return p.IsProper()
+}
+
+// Copy returns a copy of the contest puzzle (no shared structure).
+func (c *contestPuzzle) Copy() Puzzle {
+	n := &contestPuzzle{
+		sidelen: c.sidelen,
+		givens:  c.givens, // givens are never modified, so they're shared
+		values:  append([]int(nil), c.values...),
+		marks:   make([]intset, len(c.marks)),
+	}
+	for i := range c.marks {
+		n.marks[i] = newIntsetCopy(c.marks[i])
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContestPuzzle(t *testing.T) {
+	if _, e := NewContest([]int{-1, 0, 0, 0, 0}); e == nil {
+		t.Errorf("Contest puzzle with unknown geometry was created")
+	}
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p, e := NewContest(givens)
+	if e != nil {
+		t.Fatalf("Failed to create contest puzzle: %v", e)
+	}
+	for _, S := range p.Squares() {
+		if S.Bval != 0 || S.Bsrc != nil || S.Pvals != nil {
+			t.Errorf("Contest puzzle revealed square data: %+v", S)
+		}
+	}
+	if p.Solutions() != nil {
+		t.Errorf("Contest puzzle revealed solutions")
+	}
+	// a wrong assignment is accepted without complaint
+	u, e := p.Assign(Choice{2, 3})
+	if e != nil || len(u.Errors) != 0 || !reflect.DeepEqual(u.Squares, []Square{{Index: 2, Aval: 3}}) {
+		t.Errorf("Wrong assignment gave update %+v, error %v", u, e)
+	}
+	if state := p.State(); state.Errors != nil || state.Values[1] != 3 {
+		t.Errorf("Contest state is %+v", state)
+	}
+	// but filled squares and out-of-range choices are refused
+	if _, e = p.Assign(Choice{2, 2}); e == nil || e.(Error).Condition != DuplicateAssignmentCondition {
+		t.Errorf("Reassignment produced incorrect error: %v", e)
+	}
+	if _, e = p.Assign(Choice{17, 2}); e == nil || e.(Error).Condition != TooLargeCondition {
+		t.Errorf("Out of range assignment produced incorrect error: %v", e)
+	}
+	// copies don't share state
+	c := p.Copy()
+	if _, e = c.MarkCandidate(Choice{4, 2}); e != nil {
+		t.Fatalf("Mark of contest copy failed: %v", e)
+	}
+	if p.Squares()[3].Marks != nil || c.Squares()[3].Marks == nil {
+		t.Errorf("Contest copy marks were shared or lost")
+	}
+	if _, e = c.UnmarkCandidate(Choice{4, 2}); e != nil || c.Squares()[3].Marks != nil {
+		t.Errorf("Unmark of contest copy failed: %v", e)
+	}
+	// verification tells the truth
+	state := p.State()
+	v, e := Verify(givens, append([]int{state.Geometry}, state.Values...))
+	if e != nil || v.Valid {
+		t.Errorf("Verification of wrong contest entries was %+v, %v", v, e)
+	}
+	// properness is about the givens, not the entries
+	q, _ := New(givens)
+	if pe, qe := p.IsProper(), q.IsProper(); !reflect.DeepEqual(pe, qe) {
+		t.Errorf("Contest puzzle properness was %v, expected %v", pe, qe)
+	}
+}
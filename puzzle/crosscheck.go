@@ -0,0 +1,88 @@
+package puzzle
+
+import (
+	"math/rand"
+	"sync"
+)
+
+/*
+
+Cross-checking
+
+The rater's techniques are logical deductions, so everything they
+conclude has to agree with every solution of the puzzle.  When
+cross-checking is on, the rater compares its worksheet with a
+solution found by the brute-force solver after every technique it
+uses: a placed value that doesn't match, or a pencil mark removed
+from a square's solution value, is a bug in the technique, and
+it's reported as a Discrepancy.  Only the first discrepancy of
+each rating or hint is reported, since the rest follow from it.
+
+Cross-checking is off by default.  Servers can sample a fraction
+of ratings and hints, and tests can check all of them.
+
+*/
+
+// A Discrepancy is a result of the rater's techniques that
+// disagrees with the puzzle's solution.  Encoding is the position
+// that was being worked (see Puzzle.Encoding), Technique is the
+// technique that went wrong, and Choice is the value it placed
+// (or removed, if Removed is set) where the solution has Solution.
+type Discrepancy struct {
+	Encoding  string `json:"encoding"`
+	Technique string `json:"technique"`
+	Choice    Choice `json:"choice"`
+	Removed   bool   `json:"removed,omitempty"`
+	Solution  int    `json:"solution"`
+}
+
+var (
+	crossCheckMutex    sync.RWMutex
+	crossCheckFraction float64
+	crossCheckReport   func(Discrepancy)
+)
+
+// SetCrossCheck sets the fraction (from 0 to 1) of ratings and
+// hints that are cross-checked, and the function that their
+// discrepancies are reported to, which may be called from any
+// goroutine.  A fraction of 0, or a nil report function, turns
+// cross-checking off.
+func SetCrossCheck(fraction float64, report func(Discrepancy)) {
+	crossCheckMutex.Lock()
+	crossCheckFraction, crossCheckReport = fraction, report
+	crossCheckMutex.Unlock()
+}
+
+// crossCheck sets up a rater's worksheet for a puzzle to be
+// cross-checked against a solution of it, if it's sampled.
+func (r *rater) crossCheck(p, solved *puzzle) {
+	crossCheckMutex.RLock()
+	fraction, report := crossCheckFraction, crossCheckReport
+	crossCheckMutex.RUnlock()
+	if report == nil || fraction <= 0 || (fraction < 1 && rand.Float64() >= fraction) {
+		return
+	}
+	r.solution, r.encoding, r.report = solved.allValues(), p.Encoding(), report
+}
+
+// verify checks the worksheet against the solution after a
+// technique has been used, and reports the first discrepancy.
+func (r *rater) verify(technique int) {
+	if r.report == nil {
+		return
+	}
+	for i := 1; i <= r.mapping.scount; i++ {
+		d := Discrepancy{Encoding: r.encoding, Technique: techniques[technique], Solution: r.solution[i-1]}
+		switch {
+		case r.values[i] != 0 && r.values[i] != d.Solution:
+			d.Choice = Choice{i, r.values[i]}
+		case r.values[i] == 0 && !r.cands[i].has(d.Solution):
+			d.Choice, d.Removed = Choice{i, d.Solution}, true
+		default:
+			continue
+		}
+		r.report(d)
+		r.report = nil
+		return
+	}
+}
@@ -0,0 +1,95 @@
+package puzzle
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// discrepancies collects the discrepancies found while the
+// package's tests run, every one of which is cross-checked.
+var discrepancies struct {
+	sync.Mutex
+	found []Discrepancy
+}
+
+func recordDiscrepancy(d Discrepancy) {
+	discrepancies.Lock()
+	discrepancies.found = append(discrepancies.found, d)
+	discrepancies.Unlock()
+}
+
+func TestMain(m *testing.M) {
+	SetCrossCheck(1, recordDiscrepancy)
+	code := m.Run()
+	if len(discrepancies.found) > 0 {
+		for _, d := range discrepancies.found {
+			fmt.Fprintf(os.Stderr, "Cross-check discrepancy: %+v\n", d)
+		}
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+func TestCrossCheck(t *testing.T) {
+	var found []Discrepancy
+	SetCrossCheck(1, func(d Discrepancy) { found = append(found, d) })
+	defer SetCrossCheck(1, recordDiscrepancy)
+
+	p, _ := New(append([]int{SudokuGeometryCode}, oneStarValues...))
+	puz := p.(*puzzle)
+	solved, _ := solve(puz.copy(), nil)
+	solution := solved.allValues()
+	var empty []int
+	for i, v := range oneStarValues {
+		if v == 0 {
+			empty = append(empty, i+1)
+		}
+	}
+
+	// a sound worksheet has nothing to report
+	r := newRater(puz)
+	r.crossCheck(puz, solved)
+	for r.step() {
+	}
+	if len(found) != 0 {
+		t.Fatalf("Sound rating reported %+v", found)
+	}
+
+	// a wrong value is reported, once
+	r = newRater(puz)
+	r.crossCheck(puz, solved)
+	wrong := solution[empty[0]-1]%9 + 1
+	r.place(empty[0], wrong)
+	r.verify(0)
+	r.verify(0)
+	expected := Discrepancy{puz.Encoding(), TechniqueHiddenSingle, Choice{empty[0], wrong}, false, solution[empty[0]-1]}
+	if len(found) != 1 || found[0] != expected {
+		t.Errorf("Wrong value reported %+v, expected %+v", found, expected)
+	}
+
+	// so is a removed solution value
+	found = nil
+	r = newRater(puz)
+	r.crossCheck(puz, solved)
+	r.cands[empty[1]].remove(solution[empty[1]-1])
+	r.verify(3)
+	expected = Discrepancy{puz.Encoding(), TechniquePair, Choice{empty[1], solution[empty[1]-1]}, true, solution[empty[1]-1]}
+	if len(found) != 1 || found[0] != expected {
+		t.Errorf("Removed value reported %+v, expected %+v", found, expected)
+	}
+
+	// unsampled raters aren't checked
+	found = nil
+	SetCrossCheck(0, func(d Discrepancy) { found = append(found, d) })
+	r = newRater(puz)
+	r.crossCheck(puz, solved)
+	r.place(empty[0], wrong)
+	r.verify(0)
+	if len(found) != 0 {
+		t.Errorf("Unsampled rater reported %+v", found)
+	}
+}
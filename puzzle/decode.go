@@ -0,0 +1,272 @@
+package puzzle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+/*
+
+Request decoding
+
+Choices, puzzle values, and claims come from clients, so they're
+decoded carefully before any puzzle sees them:
+
+- a request body can't be bigger than its limit (MaxChoiceBytes
+for a choice, MaxChoicesBytes for a batch of them, and
+MaxPuzzleBytes for a puzzle's values or a claim), and is refused
+with a TooLargeCondition error (and a 413 response) if it is
+
+- a body must be exactly one JSON value of the expected shape,
+with nothing after it
+
+- every number must be an integer no bigger (in magnitude) than
+MaxRequestInt, so fractions, and numbers that would overflow,
+never reach a puzzle; strings, booleans, and the like aren't
+numbers
+
+Errors about a number name it, with its place in the request as
+the Field of the response (such as "index", "[2].value", or
+"values[17]"), so clients can point at the problem.  Fields that
+are missing or null are 0, as they've always been, which puzzles
+then check like any other value.  Integers in paths and queries
+are parsed with ParseInt, by the same rules.
+
+*/
+
+// Limits on the size of request bodies.
+const (
+	MaxChoiceBytes  = 1 << 10   // a choice
+	MaxChoicesBytes = 64 << 10  // a batch of choices
+	MaxPuzzleBytes  = 256 << 10 // a puzzle's values, or a claim
+)
+
+// MaxRequestInt is the biggest number (in magnitude) a request can
+// have.
+const MaxRequestInt = math.MaxInt32
+
+// DecodeChoice decodes a choice from a request body.
+func DecodeChoice(r io.Reader) (Choice, error) {
+	var raw rawChoice
+	if e := decodeBody(r, MaxChoiceBytes, &raw); e != nil {
+		return Choice{}, e
+	}
+	return raw.choice("")
+}
+
+// DecodeChoices decodes a batch of choices from a request body.
+func DecodeChoices(r io.Reader) ([]Choice, error) {
+	var raws []rawChoice
+	if e := decodeBody(r, MaxChoicesBytes, &raws); e != nil {
+		return nil, e
+	}
+	choices := make([]Choice, len(raws))
+	for i, raw := range raws {
+		choice, e := raw.choice(fmt.Sprintf("[%d].", i))
+		if e != nil {
+			return nil, e
+		}
+		choices[i] = choice
+	}
+	return choices, nil
+}
+
+// DecodeSymbolChoice decodes a symbol choice from a request body.
+func DecodeSymbolChoice(r io.Reader) (SymbolChoice, error) {
+	var raw struct {
+		Index  json.RawMessage `json:"index"`
+		Symbol string          `json:"symbol"`
+	}
+	if e := decodeBody(r, MaxChoiceBytes, &raw); e != nil {
+		return SymbolChoice{}, e
+	}
+	index, e := decodeInt("index", raw.Index)
+	return SymbolChoice{Index: index, Symbol: raw.Symbol}, e
+}
+
+// DecodeGeoAndValues decodes a puzzle's geometry code and values (as
+// passed to New) from a request body.
+func DecodeGeoAndValues(r io.Reader) ([]int, error) {
+	var raws []json.RawMessage
+	if e := decodeBody(r, MaxPuzzleBytes, &raws); e != nil {
+		return nil, e
+	}
+	return decodeInts("", raws)
+}
+
+// DecodeClaim decodes a claim from a request body.
+func DecodeClaim(r io.Reader) (Claim, error) {
+	var raw struct {
+		Fingerprint string            `json:"fingerprint"`
+		Values      []json.RawMessage `json:"values"`
+	}
+	if e := decodeBody(r, MaxPuzzleBytes, &raw); e != nil {
+		return Claim{}, e
+	}
+	values, e := decodeInts("values", raw.Values)
+	return Claim{Fingerprint: raw.Fingerprint, Values: values}, e
+}
+
+// ParseInt parses the named integer parameter of a request's path
+// or query, by the same rules as numbers in request bodies.
+func ParseInt(name, s string) (int, error) {
+	return decodeInt(name, json.RawMessage(s))
+}
+
+// ReadBody reads a request body that's no bigger than the limit,
+// for requests that are decoded some other way.
+func ReadBody(r io.Reader, limit int) ([]byte, error) {
+	body, e := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if e != nil {
+		return nil, decodeError(GeneralCondition, e.Error())
+	}
+	if len(body) > limit {
+		return nil, decodeError(TooLargeCondition, fmt.Sprintf("%d bytes", limit))
+	}
+	return body, nil
+}
+
+// DecodingStatus returns the response status for an error from
+// decoding a request: 413 for a body that's too big, and 400
+// otherwise.
+func DecodingStatus(e error) int {
+	if err, ok := e.(Error); ok && err.Attribute == DecodeAttribute && err.Condition == TooLargeCondition {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// A rawChoice is a choice as it's posted, before its numbers are
+// checked.
+type rawChoice struct {
+	Index json.RawMessage `json:"index"`
+	Value json.RawMessage `json:"value"`
+}
+
+// choice returns the Choice of a rawChoice whose fields are named
+// with the given prefix.
+func (raw rawChoice) choice(prefix string) (Choice, error) {
+	index, e := decodeInt(prefix+"index", raw.Index)
+	if e != nil {
+		return Choice{}, e
+	}
+	value, e := decodeInt(prefix+"value", raw.Value)
+	if e != nil {
+		return Choice{}, e
+	}
+	return Choice{index, value}, nil
+}
+
+// decodeBody decodes a request body that's no bigger than the
+// limit into a value.
+func decodeBody(r io.Reader, limit int, v interface{}) error {
+	body, e := ReadBody(r, limit)
+	if e != nil {
+		return e
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return decodeError(EmptyArgumentCondition)
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if e := dec.Decode(v); e != nil {
+		switch e := e.(type) {
+		case *json.SyntaxError:
+			return decodeError(GeneralCondition, fmt.Sprintf("%v (at byte %d)", e, e.Offset))
+		case *json.UnmarshalTypeError:
+			err := decodeError(GeneralCondition, fmt.Sprintf("Expected %v, found %v (at byte %d)", e.Type, e.Value, e.Offset))
+			err.Field = e.Field
+			return err
+		}
+		return decodeError(GeneralCondition, e.Error())
+	}
+	if _, e := dec.Token(); e != io.EOF {
+		return decodeError(GeneralCondition, fmt.Sprintf("Unexpected data after the request (at byte %d)", dec.InputOffset()))
+	}
+	return nil
+}
+
+// decodeError returns the Error for a request body that can't be
+// decoded.
+func decodeError(cond ErrorCondition, values ...interface{}) Error {
+	err := Error{
+		Scope:     RequestScope,
+		Structure: AttributeStructure,
+		Attribute: DecodeAttribute,
+		Condition: cond,
+		Values:    values,
+	}
+	err.Message = err.Error()
+	return err
+}
+
+// decodeInts returns the integers of a list of raw numbers, which
+// are named by their indexes after the given name.
+func decodeInts(name string, raws []json.RawMessage) ([]int, error) {
+	ints := make([]int, len(raws))
+	for i, raw := range raws {
+		n, e := decodeInt(fmt.Sprintf("%s[%d]", name, i), raw)
+		if e != nil {
+			return nil, e
+		}
+		ints[i] = n
+	}
+	return ints, nil
+}
+
+// decodeInt returns the integer of a raw number, or an Error
+// about the named number if it isn't one.  Missing and null
+// numbers are 0.
+func decodeInt(name string, raw json.RawMessage) (int, error) {
+	s := string(bytes.TrimSpace(raw))
+	if s == "" || s == "null" {
+		return 0, nil
+	}
+	if !json.Valid([]byte(s)) || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) {
+		return 0, numberError(name, s, false, "Not a number")
+	}
+	if n, e := strconv.ParseInt(s, 10, 64); e == nil {
+		if n > MaxRequestInt || n < -MaxRequestInt {
+			return 0, numberError(name, s, n > 0)
+		}
+		return int(n), nil
+	}
+	f, _ := strconv.ParseFloat(s, 64) // out of range numbers are infinite
+	switch {
+	case f > MaxRequestInt || f < -MaxRequestInt:
+		return 0, numberError(name, s, f > 0)
+	case f != math.Trunc(f):
+		return 0, numberError(name, s, false, "Not an integer")
+	}
+	return int(f), nil
+}
+
+// numberError returns the Error for a named number that's out of
+// range (too large if large is true), or (given a reason) isn't an
+// integer at all.
+func numberError(name, s string, large bool, reason ...string) Error {
+	if len(s) > 32 {
+		s = s[:32] + "..."
+	}
+	err := Error{
+		Scope:     RequestScope,
+		Structure: AttributeValueStructure,
+		Attribute: NamedAttribute,
+		Condition: TooSmallCondition,
+		Values:    ErrorData{name, s, -MaxRequestInt},
+		Field:     name,
+	}
+	switch {
+	case len(reason) > 0:
+		err.Condition, err.Values[2] = GeneralCondition, reason[0]
+	case large:
+		err.Condition, err.Values[2] = TooLargeCondition, MaxRequestInt
+	}
+	err.Message = err.Error()
+	return err
+}
@@ -0,0 +1,162 @@
+package puzzle
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeChoice(t *testing.T) {
+	cases := []struct {
+		body   string
+		choice Choice
+		field  string
+		cond   ErrorCondition
+	}{
+		{`{"index": 3, "value": 4}`, Choice{3, 4}, "", 0},
+		{` {"value": 4} `, Choice{0, 4}, "", 0},
+		{`{"index": null, "value": 2e0}`, Choice{0, 2}, "", 0},
+		{`{"index": 1.5, "value": 4}`, Choice{}, "index", GeneralCondition},
+		{`{"index": "3", "value": 4}`, Choice{}, "index", GeneralCondition},
+		{`{"index": 3, "value": 1e400}`, Choice{}, "value", TooLargeCondition},
+		{`{"index": -99999999999999999999, "value": 4}`, Choice{}, "index", TooSmallCondition},
+		{`{"index": 2147483648, "value": 4}`, Choice{}, "index", TooLargeCondition},
+		{`{"index": NaN, "value": 4}`, Choice{}, "", GeneralCondition},
+		{`{"index": 3, "value": 4}]`, Choice{}, "", GeneralCondition},
+		{`{"index": 3, "value": 4} {}`, Choice{}, "", GeneralCondition},
+		{`[3, 4]`, Choice{}, "", GeneralCondition},
+		{"  ", Choice{}, "", EmptyArgumentCondition},
+		{`{"index": 3, "value": 4, "pad": "` + strings.Repeat("x", MaxChoiceBytes) + `"}`, Choice{}, "", TooLargeCondition},
+	}
+	for i, c := range cases {
+		choice, e := DecodeChoice(strings.NewReader(c.body))
+		if c.cond == 0 {
+			if e != nil || choice != c.choice {
+				t.Errorf("Case %d: Decoded %+v, %v; expected %+v", i, choice, e, c.choice)
+			}
+			continue
+		}
+		err, ok := e.(Error)
+		if !ok || err.Scope != RequestScope || err.Condition != c.cond || err.Field != c.field || err.Message == "" {
+			t.Errorf("Case %d: Decoding gave %#v", i, e)
+		}
+	}
+}
+
+func TestDecodeLists(t *testing.T) {
+	choices, e := DecodeChoices(strings.NewReader(`[{"index": 1, "value": 2}, {"index": 3, "value": 4}]`))
+	if e != nil || !reflect.DeepEqual(choices, []Choice{{1, 2}, {3, 4}}) {
+		t.Errorf("Decoded choices %v, %v", choices, e)
+	}
+	_, e = DecodeChoices(strings.NewReader(`[{"index": 1, "value": 2}, {"index": 3, "value": 0.5}]`))
+	if err, ok := e.(Error); !ok || err.Field != "[1].value" {
+		t.Errorf("Decoding bad choices gave %#v", e)
+	}
+
+	values, e := DecodeGeoAndValues(strings.NewReader(`[1, 0, 3, 0]`))
+	if e != nil || !reflect.DeepEqual(values, []int{1, 0, 3, 0}) {
+		t.Errorf("Decoded values %v, %v", values, e)
+	}
+	_, e = DecodeGeoAndValues(strings.NewReader(`[1, 0, true]`))
+	if err, ok := e.(Error); !ok || err.Field != "[2]" {
+		t.Errorf("Decoding bad values gave %#v", e)
+	}
+	_, e = DecodeGeoAndValues(strings.NewReader("[" + strings.Repeat("0,", MaxPuzzleBytes/2) + "0]"))
+	if DecodingStatus(e) != http.StatusRequestEntityTooLarge {
+		t.Errorf("Decoding too many values gave %v", e)
+	}
+
+	claim, e := DecodeClaim(strings.NewReader(`{"fingerprint": "abc", "values": [1, 2]}`))
+	if e != nil || claim.Fingerprint != "abc" || !reflect.DeepEqual(claim.Values, []int{1, 2}) {
+		t.Errorf("Decoded claim %+v, %v", claim, e)
+	}
+	_, e = DecodeClaim(strings.NewReader(`{"fingerprint": "abc", "values": [1, 2e10]}`))
+	if err, ok := e.(Error); !ok || err.Field != "values[1]" || err.Condition != TooLargeCondition {
+		t.Errorf("Decoding a bad claim gave %#v", e)
+	}
+	if DecodingStatus(e) != http.StatusBadRequest {
+		t.Errorf("Bad claim had status %d", DecodingStatus(e))
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	for s, n := range map[string]int{"17": 17, "-3": -3, "0": 0} {
+		if got, e := ParseInt("n", s); e != nil || got != n {
+			t.Errorf("ParseInt(%q) gave %d, %v", s, got, e)
+		}
+	}
+	for _, s := range []string{"NaN", "inf", "+5", "0x10", "1.5", "9e99", "5 6", "\"5\""} {
+		if _, e := ParseInt("n", s); e == nil {
+			t.Errorf("ParseInt(%q) succeeded", s)
+		} else if err := e.(Error).Envelope(http.StatusBadRequest, "", ""); err.Field != "n" {
+			t.Errorf("ParseInt(%q) gave %+v", s, err)
+		}
+	}
+}
+
+// checkDecoded fails a fuzz test whose decoding gave a non-Error,
+// or a number out of range.
+func checkDecoded(t *testing.T, e error, numbers ...int) {
+	if e != nil {
+		if _, ok := e.(Error); !ok {
+			t.Fatalf("Decoding gave %#v", e)
+		}
+		return
+	}
+	for _, n := range numbers {
+		if n > MaxRequestInt || n < -MaxRequestInt {
+			t.Fatalf("Decoding gave number %d", n)
+		}
+	}
+}
+
+func FuzzDecodeChoice(f *testing.F) {
+	for _, seed := range []string{`{"index": 3, "value": 4}`, `{"index": 1e3}`, `{"value": -0.0}`, `{]`, ``} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		choice, e := DecodeChoice(strings.NewReader(string(body)))
+		checkDecoded(t, e, choice.Index, choice.Value)
+		if e == nil {
+			encoded, _ := json.Marshal(choice)
+			if again, e := DecodeChoice(strings.NewReader(string(encoded))); e != nil || again != choice {
+				t.Fatalf("Choice %+v decoded again as %+v, %v", choice, again, e)
+			}
+		}
+	})
+}
+
+func FuzzDecodeChoices(f *testing.F) {
+	for _, seed := range []string{`[{"index": 3, "value": 4}]`, `[]`, `[null]`, `[{"index": [1]}]`} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		choices, e := DecodeChoices(strings.NewReader(string(body)))
+		for _, choice := range choices {
+			checkDecoded(t, e, choice.Index, choice.Value)
+		}
+		checkDecoded(t, e)
+	})
+}
+
+func FuzzDecodeGeoAndValues(f *testing.F) {
+	for _, seed := range []string{`[1, 0, 3, 0]`, `[1e9, -1e9]`, `["1"]`, `[`} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		values, e := DecodeGeoAndValues(strings.NewReader(string(body)))
+		checkDecoded(t, e, values...)
+	})
+}
+
+func FuzzParseInt(f *testing.F) {
+	for _, seed := range []string{"17", "-0", "1e2", "NaN", "0x1p-2", "٣"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		n, e := ParseInt("n", s)
+		checkDecoded(t, e, n)
+	})
+}
@@ -0,0 +1,75 @@
+package puzzle
+
+import (
+	"encoding/base64"
+)
+
+/*
+
+Position encodings
+
+A position encoding is a compact, URL-safe string for the values
+on a puzzle's squares, so a position can be passed around in
+links.  It's the unpadded URL-safe base64 of a version byte, the
+geometry code, and one byte per square (0 for empty squares).
+The puzzle decoded from an encoding has the encoded values as
+its givens: an encoding records where a puzzle is, not how it
+got there.
+
+*/
+
+// encodingVersion is the version byte of position encodings.
+const encodingVersion = 1
+
+// encodeValues returns the position encoding of a geometry code
+// and square values.
+func encodeValues(geometry int, values []int) string {
+	bytes := make([]byte, 0, len(values)+2)
+	bytes = append(bytes, encodingVersion, byte(geometry))
+	for _, v := range values {
+		bytes = append(bytes, byte(v)) // values fit in a byte
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// Encoding returns the position encoding of the puzzle's current
+// values.
+func (p *puzzle) Encoding() string {
+	return encodeValues(int(p.mapping.geometry), p.allValues())
+}
+
+// Encoding returns the position encoding of the entered values.
+func (c *contestPuzzle) Encoding() string {
+	return encodeValues(c.values[0], c.values[1:])
+}
+
+// DecodeValues returns the geometry code and values (in the same
+// form passed to New) of a position encoding.  It doesn't check
+// that they make a puzzle.
+func DecodeValues(encoding string) ([]int, error) {
+	bytes, e := base64.RawURLEncoding.DecodeString(encoding)
+	if e != nil || len(bytes) < 2 || bytes[0] != encodingVersion {
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Not a position encoding"},
+		}
+	}
+	vals := make([]int, len(bytes)-1)
+	for i, b := range bytes[1:] {
+		vals[i] = int(b)
+	}
+	return vals, nil
+}
+
+// FromEncoding returns a Puzzle whose givens are the values of a
+// position encoding.  It returns the same errors as New, or an
+// Error if the string isn't a position encoding.
+func FromEncoding(encoding string) (Puzzle, error) {
+	vals, e := DecodeValues(encoding)
+	if e != nil {
+		return nil, e
+	}
+	return New(vals)
+}
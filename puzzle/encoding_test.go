@@ -0,0 +1,43 @@
+package puzzle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncoding(t *testing.T) {
+	geoAndValues := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, e := New(geoAndValues)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	choice := Choice{Index: 2, Value: p.Solutions()[0].Values[1]}
+	if _, e := p.Assign(choice); e != nil {
+		t.Fatalf("Failed to assign: %v", e)
+	}
+	enc := p.Encoding()
+	if strings.ContainsAny(enc, "+/=") || len(enc) != 111 {
+		t.Errorf("Encoding %q isn't compact and URL-safe", enc)
+	}
+	q, e := FromEncoding(enc)
+	if e != nil {
+		t.Fatalf("FromEncoding(%q) failed: %v", enc, e)
+	}
+	if !reflect.DeepEqual(q.State(), p.State()) {
+		t.Errorf("Decoded state is %+v, not %+v", q.State(), p.State())
+	}
+
+	// contest puzzles encode their entries
+	c, _ := NewContest(geoAndValues)
+	c.Assign(choice)
+	if c.Encoding() != enc {
+		t.Errorf("Contest encoding is %q, not %q", c.Encoding(), enc)
+	}
+
+	for _, bad := range []string{"", "not*base64", "AA", encodeValues(SudokuGeometryCode, []int{1, 2, 3})} {
+		if _, e := FromEncoding(bad); e == nil {
+			t.Errorf("FromEncoding(%q) didn't fail", bad)
+		}
+	}
+}
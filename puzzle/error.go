@@ -2,6 +2,8 @@ package puzzle
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 )
 
 /*
@@ -22,7 +24,10 @@ type Error struct {
 	Condition ErrorCondition `json:"condition,omitempty"`
 	Attribute ErrorAttribute `json:"attribute,omitempty"`
 	Values    ErrorData      `json:"values,omitempty"`
-	Message   string         `json:"message,omitempty"` // custom message
+	Message   string         `json:"message,omitempty"`   // custom message
+	RequestID string         `json:"requestID,omitempty"` // the request that failed, if known
+	Code      string         `json:"code,omitempty"`      // of the response status, in responses
+	Field     string         `json:"field,omitempty"`     // the offending field, if known
 }
 
 // An ErrorScope explains what type of thing the error is
@@ -80,6 +85,14 @@ const (
 	NonRectangleCondition
 	InvalidPuzzleAssignmentCondition
 	EmptyArgumentCondition
+	IncompleteSolutionCondition
+	ChangedGivenCondition
+	ImproperPuzzleCondition
+	CageSumCondition
+	NotOneSymbolCondition
+	DuplicateSymbolCondition
+	UnknownSymbolCondition
+	DeadlineExceededCondition
 	MaxCondition
 )
 
@@ -104,6 +117,7 @@ const (
 	RetainedValuesAttribute
 	PuzzleSizeAttribute
 	SideLengthAttribute
+	SymbolAttribute
 	MaxAttribute
 )
 
@@ -121,106 +135,51 @@ type ErrorData []interface{}
 
 // Return an error string from an Error.  If the Error has a
 // pre-canned message, this will use it, otherwise it will
-// produce an appropriate (English, non-localized) message.
+// produce an appropriate (English, non-localized) message.  See
+// Localize for messages in other languages.
 func (e Error) Error() string {
-	es := e.Message
-	if len(es) > 0 {
-		return es
+	if len(e.Message) > 0 {
+		return e.Message
 	}
-	values := e.Values
-	nextVal := func() interface{} {
-		if len(values) == 0 {
-			return "<unknown>"
-		}
-		val := values[0]
-		values = values[1:]
-		return val
-	}
-	switch e.Scope {
-	case RequestScope:
-		es = "Invalid request: "
-	case ArgumentScope:
-		es = "Invalid argument: "
-	case GeometryScope:
-		es = "Invalid geometry: "
-	case GroupScope:
-		es = fmt.Sprintf("Problem in %v: ", nextVal())
-	case SquareScope:
-		es = fmt.Sprintf("Problem in square %v: ", nextVal())
-	case InternalScope:
-		es = "Internal logic error: "
-	default:
-		es = "Unknown error: "
-	}
-	if e.Structure == AttributeStructure || e.Structure == AttributeValueStructure {
-		switch e.Attribute {
-		case DecodeAttribute:
-			es += "JSON Decode error"
-		case EncodeAttribute:
-			es += "JSON Encode error"
-		case URLAttribute:
-			es += "Resource path"
-		case NamedAttribute:
-			es += fmt.Sprint(nextVal())
-		case GeometryAttribute:
-			es += "Geometry"
-		case IndexAttribute:
-			es += "Index"
-		case ValueAttribute:
-			es += "Value"
-		case AssignedValueAttribute:
-			es += "Assigned value"
-		case BoundValueAttribute:
-			es += "Bound value"
-		case RemovedValueAttribute:
-			es += "Removed value"
-		case RemovedValuesAttribute:
-			es += "Removed values"
-		case RetainedValuesAttribute:
-			es += "Retained values"
-		case PuzzleSizeAttribute:
-			es += "Puzzle size"
-		case SideLengthAttribute:
-			es += "Side length"
-		case LocationAttribute:
-			es += fmt.Sprintf("In puzzle.%v", nextVal())
-		default:
-			es += "<Unknown attribute>"
-		}
-		if e.Structure == AttributeValueStructure {
-			es += " (" + fmt.Sprint(nextVal()) + ")"
+	return e.Localize("")
+}
+
+// attributeFields are the request fields named by attributes,
+// for the Field of responses.
+var attributeFields = map[ErrorAttribute]string{
+	URLAttribute:           "path",
+	GeometryAttribute:      "geometry",
+	IndexAttribute:         "index",
+	ValueAttribute:         "value",
+	AssignedValueAttribute: "value",
+	PuzzleSizeAttribute:    "values",
+	SideLengthAttribute:    "sideLength",
+	SymbolAttribute:        "symbol",
+}
+
+// ErrorCode returns the code of the errors in responses with an
+// HTTP status: the status text in snake case, such as
+// "not_found" or "too_many_requests".
+func ErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// Envelope returns the Error as it's sent in a response with the
+// given status, which is the same from every endpoint: with the
+// status's code, the offending field (from its attribute, if it
+// doesn't have one), its message in the given language, and the
+// ID of the request (if it doesn't have one).
+func (e Error) Envelope(status int, language, requestID string) Error {
+	e.Code = ErrorCode(status)
+	if e.Field == "" && e.Structure != ScopeStructure {
+		e.Field = attributeFields[e.Attribute]
+		if e.Attribute == NamedAttribute && len(e.Values) > 0 {
+			e.Field = fmt.Sprint(e.Values[0])
 		}
-		es += ": "
 	}
-	switch e.Condition {
-	case GeneralCondition:
-		es += fmt.Sprint(nextVal())
-	case TooLargeCondition:
-		es += fmt.Sprintf("Must be at most %v", nextVal())
-	case TooSmallCondition:
-		es += fmt.Sprintf("Must be at least %v", nextVal())
-	case DuplicateAssignmentCondition:
-		es += fmt.Sprintf("Square %v is already assigned value %v", nextVal(), nextVal())
-	case NotInSetCondition:
-		es += fmt.Sprintf("Must be in possible values %v", nextVal())
-	case NoPossibleValuesCondition:
-		es += fmt.Sprintf("No remaining possible values")
-	case NoGroupValueCondition:
-		es += fmt.Sprintf("No square can contain %v", nextVal())
-	case DuplicateGroupValuesCondition:
-		es += fmt.Sprintf("Multiple squares have value %v", nextVal())
-	case UnknownGeometryCondition:
-		es += fmt.Sprintf("Not a known geometry")
-	case NonSquareCondition:
-		es += fmt.Sprintf("Not a perfect square")
-	case NonRectangleCondition:
-		es += fmt.Sprintf("Not the product of consecutive integers")
-	case InvalidPuzzleAssignmentCondition:
-		es += fmt.Sprintf("Target puzzle has errors; no assignments are allowed")
-	case EmptyArgumentCondition:
-		es += fmt.Sprintf("Required argument value was empty or not supplied")
-	default:
-		es += fmt.Sprintf("Supplemental data is %v", values)
+	e.Message = e.Localize(language)
+	if e.RequestID == "" {
+		e.RequestID = requestID
 	}
-	return es
+	return e
 }
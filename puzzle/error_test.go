@@ -1,6 +1,7 @@
 package puzzle
 
 import (
+	"net/http"
 	"testing"
 )
 
@@ -33,3 +34,22 @@ func TestErrorNoPanicNoEmpty(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorEnvelope(t *testing.T) {
+	e := rangeError(IndexAttribute, 100, 1, 81)
+	env := e.Envelope(http.StatusBadRequest, "de", "req-1")
+	if env.Code != "bad_request" || env.Field != "index" || env.RequestID != "req-1" ||
+		env.Message != e.Localize("de") || env.Scope != e.Scope || env.Condition != e.Condition {
+		t.Errorf("Envelope of %+v is %+v", e, env)
+	}
+	e = Error{Scope: RequestScope, Structure: AttributeStructure, Attribute: NamedAttribute,
+		Condition: EmptyArgumentCondition, Values: ErrorData{"puzzleID"}, RequestID: "req-2"}
+	if env := e.Envelope(http.StatusTooManyRequests, "", "req-3"); env.Code != "too_many_requests" ||
+		env.Field != "puzzleID" || env.RequestID != "req-2" {
+		t.Errorf("Envelope of %+v is %+v", e, env)
+	}
+	e = Error{Scope: RequestScope, Structure: ScopeStructure, Condition: GeneralCondition, Values: ErrorData{"x"}}
+	if env := e.Envelope(http.StatusNotFound, "", ""); env.Code != "not_found" || env.Field != "" {
+		t.Errorf("Envelope of %+v is %+v", e, env)
+	}
+}
@@ -0,0 +1,128 @@
+package puzzle
+
+import (
+	"reflect"
+)
+
+/*
+
+Change events
+
+A puzzle tells its observers (see Puzzle.OnChange) what each
+change did to it, as a sequence of events:
+
+- AssignedEvent: a square got a value (Index and Value)
+
+- RetractedEvent: a square lost its value (Index and Value), as
+when a relaxed puzzle's square is emptied or refilled, or a
+puzzle is rolled back to a checkpoint
+
+- EliminatedEvent: values were taken out of an empty square's
+possible values (Index and Values)
+
+- ConflictEvent: a contradiction appeared (Conflict)
+
+- CompletedEvent: the puzzle was solved
+
+Assignments and rollbacks are the changes that make events;
+pencil marks don't.  A change's events are sent after it's made,
+for the squares in index order (a retraction before an
+assignment to the same square), then for new conflicts, and then
+for completion.  Puzzles that withhold their possible values and
+errors, such as contest puzzles, only send assignments and
+retractions.  Observers are called in the order they were added,
+on the goroutine that made the change, and mustn't change the
+puzzle.  Copies of a puzzle start without observers, so the
+copies the solver makes don't pay for them.
+
+*/
+
+// An EventKind is the kind of a change event.
+type EventKind string
+
+// The kinds of change events.
+const (
+	AssignedEvent   EventKind = "assigned"
+	RetractedEvent  EventKind = "retracted"
+	EliminatedEvent EventKind = "eliminated"
+	ConflictEvent   EventKind = "conflict"
+	CompletedEvent  EventKind = "completed"
+)
+
+// An Event is one thing a change did to a puzzle.
+type Event struct {
+	Kind     EventKind `json:"kind"`
+	Index    int       `json:"index,omitempty"`
+	Value    int       `json:"value,omitempty"`
+	Values   []int     `json:"values,omitempty"`
+	Conflict *Conflict `json:"conflict,omitempty"`
+}
+
+// observers are the functions watching a puzzle's changes.
+type observers struct {
+	fns []func(Event)
+}
+
+// OnChange adds a function to call with the events of each of the
+// puzzle's changes.
+func (o *observers) OnChange(f func(Event)) {
+	o.fns = append(o.fns, f)
+}
+
+// watch makes a change to a puzzle, and then sends the observers
+// its events, if it was made.  Without observers, it just makes
+// the change.
+func (o *observers) watch(p Puzzle, change func() error) error {
+	if len(o.fns) == 0 {
+		return change()
+	}
+	before, conflicts, done := p.Squares(), p.Conflicts(), p.State().Done
+	if e := change(); e != nil {
+		return e
+	}
+	for _, event := range changeEvents(p, before, conflicts, done) {
+		for _, f := range o.fns {
+			f(event)
+		}
+	}
+	return nil
+}
+
+// changeEvents returns the events of a change to a puzzle, given
+// its squares, conflicts, and whether it was done before.
+func changeEvents(p Puzzle, before []Square, conflicts []Conflict, done bool) []Event {
+	var events []Event
+	for i, s := range p.Squares() {
+		if i >= len(before) {
+			break
+		}
+		b := before[i]
+		switch {
+		case b.Aval != s.Aval:
+			if b.Aval != 0 {
+				events = append(events, Event{Kind: RetractedEvent, Index: s.Index, Value: b.Aval})
+			}
+			if s.Aval != 0 {
+				events = append(events, Event{Kind: AssignedEvent, Index: s.Index, Value: s.Aval})
+			}
+		case s.Aval == 0:
+			if removed := subtractValues(possibleValues(b), possibleValues(s)); len(removed) > 0 {
+				events = append(events, Event{Kind: EliminatedEvent, Index: s.Index, Values: removed})
+			}
+		}
+	}
+	for _, c := range p.Conflicts() {
+		found := false
+		for _, old := range conflicts {
+			found = found || reflect.DeepEqual(c, old)
+		}
+		if !found {
+			c := c
+			events = append(events, Event{Kind: ConflictEvent, Conflict: &c})
+		}
+	}
+	if !done && p.State().Done {
+		events = append(events, Event{Kind: CompletedEvent})
+	}
+	return events
+}
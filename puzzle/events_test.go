@@ -0,0 +1,127 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+// eventRecorder returns a function that records a puzzle's events,
+// and the events it has recorded.
+func eventRecorder(p Puzzle) func() []Event {
+	var events []Event
+	p.OnChange(func(event Event) { events = append(events, event) })
+	return func() []Event {
+		recorded := events
+		events = nil
+		return recorded
+	}
+}
+
+func TestEvents(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p, _ := New(givens)
+	events := eventRecorder(p)
+	tag := p.Checkpoint()
+
+	// an assignment eliminates its value from its neighbors, whose
+	// events come in index order around it
+	if _, e := p.Assign(Choice{13, 2}); e != nil {
+		t.Fatalf("Assign failed: %v", e)
+	}
+	got := events()
+	assigned, last := 0, 0
+	for _, event := range got {
+		switch {
+		case event.Index <= last:
+			t.Errorf("Events are out of order: %+v", got)
+		case reflect.DeepEqual(event, Event{Kind: AssignedEvent, Index: 13, Value: 2}):
+			assigned++
+		case event.Kind != EliminatedEvent || !reflect.DeepEqual(event.Values, []int{2}):
+			t.Errorf("Assignment gave event %+v", event)
+		}
+		last = event.Index
+	}
+	if assigned != 1 || len(got) < 2 {
+		t.Errorf("Assignment gave events %+v", got)
+	}
+
+	// failed assignments, marks, and copies send nothing
+	p.Assign(Choice{13, 4})
+	p.MarkCandidate(Choice{2, 4})
+	p.Copy().Assign(Choice{2, 4})
+	if got := events(); len(got) != 0 {
+		t.Errorf("Unchanged puzzle gave events %+v", got)
+	}
+
+	// rolling back retracts, and observers survive it
+	if e := p.Rollback(tag); e != nil {
+		t.Fatalf("Rollback failed: %v", e)
+	}
+	if got := events(); len(got) != 1 || !reflect.DeepEqual(got[0], Event{Kind: RetractedEvent, Index: 13, Value: 2}) {
+		t.Errorf("Rollback gave events %+v", got)
+	}
+
+	// the last assignment completes the puzzle
+	var choices []Choice
+	for i, v := range p.Solutions()[0].Values {
+		if givens[i+1] == 0 {
+			choices = append(choices, Choice{i + 1, v})
+		}
+	}
+	if _, e := AssignAll(p, choices); e != nil {
+		t.Fatalf("AssignAll failed: %v", e)
+	}
+	got = events()
+	if len(got) == 0 || got[len(got)-1].Kind != CompletedEvent {
+		t.Errorf("Solving gave events %+v", got)
+	}
+	for _, event := range got[:len(got)-1] {
+		if event.Kind == CompletedEvent {
+			t.Errorf("Solving gave an early completion in %+v", got)
+		}
+	}
+}
+
+func TestRelaxedEvents(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p, _ := NewRelaxed(givens)
+	events := eventRecorder(p)
+
+	// a duplicate is reported as a conflict
+	p.Assign(Choice{2, 1})
+	got := events()
+	if len(got) == 0 || !reflect.DeepEqual(got[0], Event{Kind: AssignedEvent, Index: 2, Value: 1}) {
+		t.Fatalf("Duplicate assignment gave events %+v", got)
+	}
+	if last := got[len(got)-1]; last.Kind != ConflictEvent || last.Conflict == nil || last.Conflict.Kind != ConflictDuplicate {
+		t.Errorf("Duplicate assignment gave events %+v", got)
+	}
+
+	// refilling retracts first, and the conflict isn't new
+	p.Assign(Choice{2, 2})
+	got = events()
+	if len(got) < 2 || !reflect.DeepEqual(got[0], Event{Kind: RetractedEvent, Index: 2, Value: 1}) ||
+		!reflect.DeepEqual(got[1], Event{Kind: AssignedEvent, Index: 2, Value: 2}) {
+		t.Fatalf("Reassignment gave events %+v", got)
+	}
+	for _, event := range got {
+		if event.Kind == ConflictEvent {
+			t.Errorf("Reassignment gave event %+v", event)
+		}
+	}
+}
+
+func TestContestEvents(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p, _ := NewContest(givens)
+	events := eventRecorder(p)
+	tag := p.Checkpoint()
+	p.Assign(Choice{2, 1})
+	if got := events(); len(got) != 1 || !reflect.DeepEqual(got[0], Event{Kind: AssignedEvent, Index: 2, Value: 1}) {
+		t.Errorf("Assignment gave events %+v", got)
+	}
+	p.Rollback(tag)
+	if got := events(); len(got) != 1 || !reflect.DeepEqual(got[0], Event{Kind: RetractedEvent, Index: 2, Value: 1}) {
+		t.Errorf("Rollback gave events %+v", got)
+	}
+}
@@ -0,0 +1,152 @@
+package puzzle
+
+/*
+
+Explanations
+
+An explanation is the whole logical solution of a puzzle, step by
+step, as the rater works it: each step is the easiest technique
+that makes progress, with the squares it reasons from and the
+values it places or the pencil marks it removes.  It's meant for
+learners, who can follow it through the puzzle and see why each
+step is sound.
+
+Where the rater would have to guess, the explanation places the
+first empty square's value from the puzzle's solution instead,
+as a guess step, and carries on from there.  So every
+explanation leads to a solution, and replaying the values it
+places solves the puzzle.
+
+*/
+
+// A Step is one step of an explanation.  Squares are the squares
+// the technique reasons from: the square it fills for a single or
+// a guess, the squares of a pattern, or the squares of a chain's
+// branches.  Placed are the values the step places, and Removed
+// are the pencil marks it removes, leaving out the ones that a
+// placed value rules out in its neighbors.  Coloring and forcing
+// chain steps also have the Chain behind them.
+type Step struct {
+	Technique string   `json:"technique"`
+	Squares   []int    `json:"squares"`
+	Placed    []Choice `json:"placed,omitempty"`
+	Removed   []Choice `json:"removed,omitempty"`
+	Chain     *Chain   `json:"chain,omitempty"`
+}
+
+// Explain works out the steps of solving a puzzle from its
+// current state, in order.  It's an error to explain a puzzle
+// that can't be solved, or one that doesn't reveal its contents
+// (such as a contest puzzle).  A filled puzzle has no steps.
+func Explain(p Puzzle) ([]Step, error) {
+	return explain(p, nil)
+}
+
+// explain explains a puzzle, counting its searches and steps with
+// a searcher (if there is one), and stopping when it's stopped.
+func explain(p Puzzle, s *searcher) ([]Step, error) {
+	if rp, ok := p.(*relaxedPuzzle); ok {
+		p = rp.puzzle
+	}
+	puz, ok := p.(*puzzle)
+	if !ok {
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle contents are not available for explanation"},
+		}
+	}
+	solved, t := searchThread(puz.copy(), nil, s)
+	t.release()
+	if s.isStopped() {
+		return nil, stoppedSearchError
+	}
+	if len(solved.errors) > 0 {
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle has no solution"},
+		}
+	}
+	r := newRater(puz)
+	r.crossCheck(puz, solved)
+	if puz.isProper(s) != nil {
+		for i, name := range techniques {
+			r.disabled[i] = name == TechniqueRectangle || name == TechniqueBUG
+		}
+	}
+	solution := solved.allValues()
+	var steps []Step
+	for s.report() {
+		values := append([]int(nil), r.values...)
+		cands := append([]valset(nil), r.cands...)
+		counts := append([]int(nil), r.counts...)
+		r.used, r.chain = nil, nil
+		if !r.step() {
+			empty := 0
+			for i := 1; i <= r.mapping.scount && empty == 0; i++ {
+				if r.values[i] == 0 {
+					empty = i
+				}
+			}
+			if empty == 0 {
+				return steps, nil
+			}
+			r.place(empty, solution[empty-1])
+		}
+		steps = append(steps, r.explainStep(values, cands, counts))
+	}
+	return steps, stoppedSearchError
+}
+
+// explainStep describes the step the rater just took, given its
+// worksheet and technique counts from before the step.
+func (r *rater) explainStep(values []int, cands []valset, counts []int) Step {
+	step := Step{Technique: TechniqueGuess, Chain: r.chain}
+	for i := range counts {
+		if r.counts[i] != counts[i] {
+			step.Technique = techniques[i]
+			break
+		}
+	}
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] != values[i] {
+			step.Placed = append(step.Placed, Choice{i, r.values[i]})
+		}
+	}
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] != 0 {
+			continue
+		}
+		for _, v := range cands[i].minus(r.cands[i]).intset() {
+			ruledOut := false
+			for _, c := range step.Placed {
+				if c.Value == v && r.sees(i, c.Index) {
+					ruledOut = true
+				}
+			}
+			if !ruledOut {
+				step.Removed = append(step.Removed, Choice{i, v})
+			}
+		}
+	}
+	var squares intset
+	switch {
+	case r.used != nil:
+		squares = r.used
+	case r.chain != nil:
+		for _, branch := range r.chain.Branches {
+			for _, c := range branch {
+				squares.insert(c.Index)
+			}
+		}
+	default:
+		for _, c := range step.Placed {
+			squares.insert(c.Index)
+		}
+	}
+	step.Squares = []int(squares)
+	return step
+}
@@ -0,0 +1,105 @@
+package puzzle
+
+import (
+	"testing"
+)
+
+// replay assigns the values an explanation places, and checks
+// that they solve the puzzle.
+func replay(t *testing.T, name string, values []int, steps []Step) {
+	p, _ := helperNewSudokuPuzzle(values)
+	for i, step := range steps {
+		if len(step.Squares) == 0 {
+			t.Errorf("%s step %d (%s) has no squares", name, i+1, step.Technique)
+		}
+		if len(step.Placed) == 0 && len(step.Removed) == 0 {
+			t.Errorf("%s step %d (%s) made no progress", name, i+1, step.Technique)
+		}
+		for _, choice := range step.Placed {
+			if _, e := p.Assign(choice); e != nil {
+				t.Fatalf("%s step %d (%s) placed %+v: %v", name, i+1, step.Technique, choice, e)
+			}
+		}
+	}
+	if errs := p.State().Errors; len(errs) != 0 || !p.isDone() {
+		t.Errorf("%s steps didn't solve the puzzle: %v", name, p.State().Values)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	// an easy puzzle is all hidden singles
+	p, _ := helperNewSudokuPuzzle(oneStarValues)
+	steps, e := Explain(p)
+	if e != nil {
+		t.Fatalf("Explain failed: %v", e)
+	}
+	if len(steps) != 49 {
+		t.Errorf("One-star puzzle had %d steps, expected 49", len(steps))
+	}
+	for i, step := range steps {
+		if step.Technique != TechniqueHiddenSingle || len(step.Placed) != 1 || step.Removed != nil ||
+			len(step.Squares) != 1 || step.Squares[0] != step.Placed[0].Index {
+			t.Errorf("One-star step %d was %+v", i+1, step)
+		}
+	}
+	replay(t, "One-star", oneStarValues, steps)
+
+	// a harder one needs eliminations and a chain
+	p, _ = helperNewSudokuPuzzle(sixStarValues)
+	steps, e = Explain(p)
+	if e != nil {
+		t.Fatalf("Explain failed: %v", e)
+	}
+	used := make(map[string]int)
+	for _, step := range steps {
+		used[step.Technique]++
+		switch step.Technique {
+		case TechniqueLocked:
+			if step.Placed != nil || step.Removed == nil || len(step.Squares) < 2 {
+				t.Errorf("Locked candidates step was %+v", step)
+			}
+		case TechniqueChain:
+			if step.Chain == nil || len(step.Placed) != 1 || len(step.Squares) < 2 {
+				t.Errorf("Forcing chain step was %+v", step)
+			}
+		}
+	}
+	if used[TechniqueLocked] != 1 || used[TechniqueChain] != 1 || used[TechniqueGuess] != 0 {
+		t.Errorf("Six-star techniques were %v", used)
+	}
+	replay(t, "Six-star", sixStarValues, steps)
+
+	// puzzles with more than one solution need guesses
+	p, _ = helperNewSudokuPuzzle(solveSimpleStartValues)
+	steps, e = Explain(p)
+	if e != nil {
+		t.Fatalf("Explain failed: %v", e)
+	}
+	if len(steps) == 0 || steps[0].Technique != TechniqueGuess {
+		t.Errorf("Improper puzzle steps were %+v", steps)
+	}
+	for _, choice := range flatten(steps) {
+		p.Assign(choice)
+	}
+	if !p.isDone() {
+		t.Errorf("Improper puzzle steps didn't solve it: %v", p.State().Values)
+	}
+
+	// filled puzzles have no steps, and hidden ones can't be explained
+	if steps, e = Explain(p); e != nil || steps != nil {
+		t.Errorf("Filled puzzle gave %+v, %v", steps, e)
+	}
+	c, _ := NewContest(append([]int{SudokuGeometryCode}, oneStarValues...))
+	if _, e = Explain(c); e == nil {
+		t.Errorf("Contest puzzle was explained")
+	}
+}
+
+// flatten returns the values placed by a list of steps.
+func flatten(steps []Step) []Choice {
+	var choices []Choice
+	for _, step := range steps {
+		choices = append(choices, step.Placed...)
+	}
+	return choices
+}
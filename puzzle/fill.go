@@ -0,0 +1,112 @@
+package puzzle
+
+import (
+	"math"
+)
+
+/*
+
+Fill progress
+
+Players like to see how far along they are, and how much of the
+rest of the puzzle they can get without thinking hard.  A puzzle's
+FillProgress says how many of its squares are filled and how many
+remain, and what percentage of the remaining squares are forced:
+logically determined at the current position, either because
+they have only one possible value or because they're the only
+place left in one of their groups for a value it needs (that is,
+they're bound; see Square).  Both are kept up to date as
+assignments are made, so measuring them only takes a pass over
+the squares' possible value sets, and no solving.
+
+The forced squares are the puzzle's singles, and NextSingle gives
+one of them to fill, as a Choice and the technique that forces it
+(see rate.go): naked singles, which have only one possible value,
+come before hidden singles, which are bound.  Filling singles one
+after another, until NextSingle finds none, fills everything they
+force.
+
+Only puzzles that reveal their contents have forced squares:
+contest puzzles (see contest.go) only say how many squares are
+filled, and so do puzzles with errors, whose possible values no
+longer follow from logic.
+
+*/
+
+// A FillProgress is how far the filling of a puzzle has got.
+// Forced is a percentage of the Remaining squares, rounded to a
+// tenth.
+type FillProgress struct {
+	Filled    int     `json:"filled"`
+	Remaining int     `json:"remaining"`
+	Forced    float64 `json:"forced"`
+}
+
+// A Single is a choice forced by a technique.
+type Single struct {
+	Choice
+	Technique string `json:"technique"`
+}
+
+// MeasureFill returns the fill progress of a puzzle.
+func MeasureFill(p Puzzle) FillProgress {
+	puz, ok := revealed(p)
+	if !ok {
+		var fp FillProgress
+		for _, v := range p.State().Values {
+			if v == 0 {
+				fp.Remaining++
+			} else {
+				fp.Filled++
+			}
+		}
+		return fp
+	}
+	var fp FillProgress
+	forced := 0
+	for i := 1; i <= puz.mapping.scount; i++ {
+		s := puz.squares[i]
+		if s.aval != 0 {
+			fp.Filled++
+			continue
+		}
+		fp.Remaining++
+		if s.bval != 0 || s.pvals.len() == 1 {
+			forced++
+		}
+	}
+	if fp.Remaining > 0 && len(puz.errors) == 0 {
+		fp.Forced = math.Round(float64(forced)*1000/float64(fp.Remaining)) / 10
+	}
+	return fp
+}
+
+// NextSingle returns a single of a puzzle, or false if it has
+// none.
+func NextSingle(p Puzzle) (Single, bool) {
+	puz, ok := revealed(p)
+	if !ok || len(puz.errors) != 0 {
+		return Single{}, false
+	}
+	for i := 1; i <= puz.mapping.scount; i++ {
+		if s := puz.squares[i]; s.aval == 0 && s.pvals.len() == 1 {
+			return Single{Choice{i, s.pvals.first()}, TechniqueNakedSingle}, true
+		}
+	}
+	for i := 1; i <= puz.mapping.scount; i++ {
+		if s := puz.squares[i]; s.aval == 0 && s.bval != 0 {
+			return Single{Choice{i, s.bval}, TechniqueHiddenSingle}, true
+		}
+	}
+	return Single{}, false
+}
+
+// revealed returns the underlying puzzle of one that reveals its
+// contents, or false for one that doesn't.
+func revealed(p Puzzle) (*puzzle, bool) {
+	if rp, ok := p.(*relaxedPuzzle); ok {
+		p = rp.puzzle
+	}
+	puz, ok := p.(*puzzle)
+	return puz, ok
+}
@@ -0,0 +1,69 @@
+package puzzle
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeasureFill(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(givens)
+	fp := MeasureFill(p)
+	filled, forced := 0, 0
+	for _, s := range p.Squares() {
+		if s.Aval != 0 {
+			filled++
+		} else if s.Bval != 0 || len(s.Pvals) == 1 {
+			forced++
+		}
+	}
+	want := math.Round(float64(forced)*1000/float64(81-filled)) / 10
+	if fp.Filled != filled || fp.Remaining != 81-filled || fp.Forced != want || forced == 0 {
+		t.Errorf("MeasureFill gave %+v, not %d filled, %.1f%% forced", fp, filled, want)
+	}
+
+	// contest puzzles don't say what's forced
+	c, _ := NewContest(givens)
+	if cfp := MeasureFill(c); cfp.Filled != filled || cfp.Remaining != 81-filled || cfp.Forced != 0 {
+		t.Errorf("MeasureFill of contest puzzle gave %+v", cfp)
+	}
+
+	// solved puzzles have nothing left
+	solved, _ := New(append([]int{SudokuGeometryCode}, p.Solutions()[0].Values...))
+	if sfp := MeasureFill(solved); sfp != (FillProgress{Filled: 81}) {
+		t.Errorf("MeasureFill of solved puzzle gave %+v", sfp)
+	}
+}
+
+func TestNextSingle(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(givens)
+	solution := p.Solutions()[0].Values
+	naked := false
+	for n := 0; ; n++ {
+		single, ok := NextSingle(p)
+		if !ok {
+			break
+		}
+		if single.Value != solution[single.Index-1] {
+			t.Fatalf("Single %+v doesn't match the solution", single)
+		}
+		if n == 0 {
+			naked = single.Technique == TechniqueNakedSingle
+		}
+		if _, e := p.Assign(single.Choice); e != nil {
+			t.Fatalf("Assign of single %+v failed: %v", single, e)
+		}
+	}
+	if !naked {
+		t.Errorf("The first single wasn't naked")
+	}
+	if fp := MeasureFill(p); fp.Remaining != 0 {
+		t.Errorf("Singles left the one-star puzzle with %+v", fp)
+	}
+
+	c, _ := NewContest(givens)
+	if single, ok := NextSingle(c); ok {
+		t.Errorf("Contest puzzle has single %+v", single)
+	}
+}
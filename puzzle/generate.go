@@ -0,0 +1,282 @@
+package puzzle
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+
+Puzzle generation
+
+Generated puzzles are reproducible: the same seed and parameters
+give the same puzzle on every machine and in every release.  To
+keep that promise, the generator doesn't use math/rand (whose
+streams aren't guaranteed), but its own small generator
+(SplitMix64) seeded with the FNV-1a hash of the seed text.
+
+Seeds have the form "<version>:<text>", where the version names
+the generation algorithm.  A seed without a version gets the
+current one.  Any change to the algorithm that would change its
+output has to come with a new seed version, so that old seeds
+keep working; the current algorithm (version 1) is:
+
+1. Fill the grid with the standard pattern for its tile size,
+then shuffle it without breaking it: permute the bands of rows,
+the rows within each band, the stacks of columns, the columns
+within each stack, and the values, then maybe transpose it.
+
+2. Visit the squares in a shuffled order, emptying each one and
+its mirror image through the center for as long as the puzzle
+stays proper (has only one solution) and keeps the requested
+number of givens.
+
+Whether a puzzle is proper doesn't depend on how the solver
+finds out, so solver changes don't change generated puzzles.
+
+Puzzles that aren't asked for by seed get a new one from
+NewSeed, whose randomness comes from a source the caller passes
+in: crypto/rand for puzzles no one can predict, or a stream made
+by NewSeedSource, so that tests, and servers given the same
+stream text, make the same puzzles in the same order.
+
+*/
+
+// SeedVersion is the version of the current generation algorithm.
+const SeedVersion = 1
+
+// maxGenerateSideLength is the largest side length that the
+// generator will make puzzles of.  Bigger ones can take seconds
+// to check for properness.
+const maxGenerateSideLength = 9
+
+// GenerateParams are the parameters for generating a puzzle.
+// Seed is the seed for the random choices, and SideLength is the
+// side length of the (Sudoku geometry) puzzle, which must be a
+// perfect square; it defaults to 9.  Givens is the least number
+// of squares that must be left filled; the generator empties as
+// many as it can if it's 0.
+type GenerateParams struct {
+	Seed       string `json:"seed"`
+	SideLength int    `json:"sidelen"`
+	Givens     int    `json:"givens"`
+}
+
+// A Generated puzzle is a puzzle made by Generate, along with
+// the parameters that reproduce it.  The seed is always given
+// with its version, and Values are in the form taken by New.
+type Generated struct {
+	GenerateParams
+	Values []int `json:"values"`
+}
+
+// ParseSeed splits a seed into its version and text, giving it
+// the current version if it doesn't have one.  It's an error if
+// the version isn't one the generator knows.
+func ParseSeed(seed string) (int, string, error) {
+	if i := strings.Index(seed, ":"); i > 0 {
+		if version, e := strconv.Atoi(seed[:i]); e == nil {
+			if version != SeedVersion {
+				return 0, "", Error{
+					Scope:     ArgumentScope,
+					Structure: ScopeStructure,
+					Condition: GeneralCondition,
+					Values:    ErrorData{"Unknown seed version " + seed[:i]},
+				}
+			}
+			return version, seed[i+1:], nil
+		}
+	}
+	return SeedVersion, seed, nil
+}
+
+// NewSeed returns a new seed with the current version, whose
+// text is made from 8 bytes read from the source.
+func NewSeed(source io.Reader) (string, error) {
+	var b [8]byte
+	if _, e := io.ReadFull(source, b[:]); e != nil {
+		return "", e
+	}
+	return strconv.Itoa(SeedVersion) + ":" + hex.EncodeToString(b[:]), nil
+}
+
+// NewSeedSource returns an endless stream of pseudo-random bytes
+// made from the given text, which is the same on every machine
+// and in every release.  It's safe for concurrent use.
+func NewSeedSource(text string) io.Reader {
+	return &seedSource{rng: newSeedRNG(text)}
+}
+
+// A seedSource is a stream of the output of a seedRNG.
+type seedSource struct {
+	mutex sync.Mutex
+	rng   *seedRNG
+	buf   [8]byte
+	left  int // unread bytes at the end of buf
+}
+
+func (s *seedSource) Read(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range b {
+		if s.left == 0 {
+			binary.BigEndian.PutUint64(s.buf[:], s.rng.next())
+			s.left = len(s.buf)
+		}
+		b[i] = s.buf[len(s.buf)-s.left]
+		s.left--
+	}
+	return len(b), nil
+}
+
+// Generate makes a proper puzzle from the given parameters.
+func Generate(params GenerateParams) (Generated, error) {
+	return generate(params, nil)
+}
+
+// generate generates a puzzle, counting its searches with a
+// searcher (if there is one).
+func generate(params GenerateParams, s *searcher) (Generated, error) {
+	version, text, e := ParseSeed(params.Seed)
+	if e != nil {
+		return Generated{}, e
+	}
+	if params.SideLength == 0 {
+		params.SideLength = 9
+	}
+	slen := params.SideLength
+	tlen, ok := findIntSquareRoot(slen)
+	if !ok {
+		return Generated{}, formatError(SideLengthAttribute, slen, NonSquareCondition, 0)
+	}
+	if slen < 4 || slen > maxGenerateSideLength {
+		return Generated{}, rangeError(SideLengthAttribute, slen, 4, maxGenerateSideLength)
+	}
+	if params.Givens < 0 || params.Givens > slen*slen {
+		return Generated{}, rangeError(ValueAttribute, params.Givens, 0, slen*slen)
+	}
+	params.Seed = strconv.Itoa(version) + ":" + text
+
+	rng := newSeedRNG(text)
+	values := generateGrid(rng, slen, tlen)
+	if e := generateGivens(rng, values, params.Givens, s); e != nil {
+		return Generated{}, e
+	}
+	return Generated{params, append([]int{SudokuGeometryCode}, values...)}, nil
+}
+
+// generateGrid fills a grid of the given side and tile lengths,
+// returning its values in square order.
+func generateGrid(rng *seedRNG, slen, tlen int) []int {
+	rows, cols := rng.lineOrder(tlen), rng.lineOrder(tlen)
+	vals := rng.perm(slen)
+	transpose := rng.intn(2) == 1
+	values := make([]int, slen*slen)
+	for r := 0; r < slen; r++ {
+		for c := 0; c < slen; c++ {
+			pr, pc := rows[r], cols[c]
+			if transpose {
+				pr, pc = pc, pr
+			}
+			values[r*slen+c] = vals[(tlen*(pr%tlen)+pr/tlen+pc)%slen] + 1
+		}
+	}
+	return values
+}
+
+// generateGivens empties as many squares of a filled grid as it
+// can, in mirrored pairs, leaving a proper puzzle with at least
+// the given number of givens.  A searcher (if there is one) is
+// told about each square that's tried.
+func generateGivens(rng *seedRNG, values []int, givens int, s *searcher) error {
+	count := len(values)
+	s.tryingSquares(len(values))
+	for _, i := range rng.perm(len(values)) {
+		j := len(values) - 1 - i
+		if !s.triedSquare() {
+			return stoppedSearchError
+		}
+		if values[i] == 0 {
+			continue
+		}
+		removed := 2
+		if i == j {
+			removed = 1
+		}
+		if count-removed < givens {
+			continue
+		}
+		vi, vj := values[i], values[j]
+		values[i], values[j] = 0, 0
+		p, e := New(append([]int{SudokuGeometryCode}, values...))
+		if e != nil {
+			return e
+		}
+		if e := p.(*puzzle).isProper(s); s.isStopped() {
+			return stoppedSearchError
+		} else if e != nil {
+			values[i], values[j] = vi, vj
+			continue
+		}
+		count -= removed
+	}
+	return nil
+}
+
+// A seedRNG is a SplitMix64 pseudo-random generator.  Its output
+// for a given seed must never change.
+type seedRNG struct {
+	state uint64
+}
+
+// newSeedRNG makes a generator seeded from the given text.
+func newSeedRNG(text string) *seedRNG {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return &seedRNG{h.Sum64()}
+}
+
+// next returns the next 64 bits of output.
+func (rng *seedRNG) next() uint64 {
+	rng.state += 0x9e3779b97f4a7c15
+	z := rng.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a number in [0, n).
+func (rng *seedRNG) intn(n int) int {
+	return int(rng.next() % uint64(n))
+}
+
+// perm returns a shuffled list of the numbers in [0, n).
+func (rng *seedRNG) perm(n int) []int {
+	p := make([]int, n)
+	for i := range p {
+		p[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j := rng.intn(i + 1)
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
+// lineOrder returns a shuffled order of the rows (or columns) of
+// a grid with the given tile length, keeping each band (or
+// stack) of lines together.
+func (rng *seedRNG) lineOrder(tlen int) []int {
+	order := make([]int, 0, tlen*tlen)
+	for _, band := range rng.perm(tlen) {
+		for _, line := range rng.perm(tlen) {
+			order = append(order, band*tlen+line)
+		}
+	}
+	return order
+}
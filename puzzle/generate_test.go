@@ -0,0 +1,105 @@
+package puzzle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// goldenSeedValues is the puzzle generated from seed "1:golden".
+// If this test fails, the generator's output has changed, and
+// the change needs a new seed version.
+var goldenSeedValues = []int{
+	SudokuGeometryCode,
+	8, 0, 0, 0, 0, 4, 5, 1, 0,
+	0, 0, 1, 2, 0, 8, 9, 0, 0,
+	4, 0, 9, 5, 1, 0, 0, 0, 0,
+	0, 1, 0, 0, 0, 0, 4, 0, 0,
+	0, 0, 0, 0, 9, 0, 0, 0, 0,
+	0, 0, 4, 0, 0, 0, 0, 8, 0,
+	0, 0, 0, 0, 6, 5, 2, 0, 8,
+	0, 0, 3, 7, 0, 9, 1, 0, 0,
+	0, 5, 6, 3, 0, 0, 0, 0, 9,
+}
+
+func TestGenerate(t *testing.T) {
+	g, e := Generate(GenerateParams{Seed: "golden"})
+	if e != nil {
+		t.Fatalf("Generate failed: %v", e)
+	}
+	if g.Seed != "1:golden" || g.SideLength != 9 {
+		t.Errorf("Generated params are %+v", g.GenerateParams)
+	}
+	if !reflect.DeepEqual(g.Values, goldenSeedValues) {
+		t.Errorf("Seed 1:golden generated %v", g.Values)
+	}
+	again, e := Generate(GenerateParams{Seed: "1:golden", SideLength: 9})
+	if e != nil || !reflect.DeepEqual(again, g) {
+		t.Errorf("Regenerating from %q gave %+v, %v", g.Seed, again, e)
+	}
+
+	for _, params := range []GenerateParams{
+		{Seed: "four", SideLength: 4},
+		{Seed: "easy", Givens: 45},
+		{Seed: ""},
+	} {
+		g, e := Generate(params)
+		if e != nil {
+			t.Fatalf("%+v: Generate failed: %v", params, e)
+		}
+		p, e := New(g.Values)
+		if e != nil {
+			t.Fatalf("%+v: Generated puzzle can't be created: %v", params, e)
+		}
+		if e := p.IsProper(); e != nil {
+			t.Errorf("%+v: Generated puzzle isn't proper: %v", params, e)
+		}
+		givens := 0
+		for _, v := range g.Values[1:] {
+			if v != 0 {
+				givens++
+			}
+		}
+		if givens < params.Givens || givens == len(g.Values)-1 {
+			t.Errorf("%+v: Generated puzzle has %d givens", params, givens)
+		}
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	a, b := NewSeedSource("stream"), NewSeedSource("stream")
+	for i := 0; i < 3; i++ {
+		seedA, e := NewSeed(a)
+		seedB, _ := NewSeed(b)
+		if e != nil || seedA != seedB || !strings.HasPrefix(seedA, "1:") || len(seedA) != 18 {
+			t.Errorf("Seed %d from the same stream was %q and %q, %v", i, seedA, seedB, e)
+		}
+	}
+	first, _ := NewSeed(NewSeedSource("stream"))
+	second, _ := NewSeed(a)
+	if first == second {
+		t.Errorf("A stream repeated its seed %q", first)
+	}
+	if other, _ := NewSeed(NewSeedSource("other")); other == first {
+		t.Errorf("Different streams gave the same seed %q", first)
+	}
+	if _, e := NewSeed(strings.NewReader("short")); e == nil {
+		t.Errorf("NewSeed from a short source succeeded")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, params := range []GenerateParams{
+		{Seed: "2:future"},
+		{Seed: "x", SideLength: 6},
+		{Seed: "x", SideLength: 16},
+		{Seed: "x", Givens: 82},
+	} {
+		if _, e := Generate(params); e == nil {
+			t.Errorf("%+v: Generate didn't fail", params)
+		}
+	}
+	if v, text, e := ParseSeed("no version: here"); e != nil || v != SeedVersion || text != "no version: here" {
+		t.Errorf("ParseSeed gave %d, %q, %v", v, text, e)
+	}
+}
@@ -6,7 +6,9 @@ Puzzle Geometries
 
 In this module, there is only one puzzle implementation, but it
 supports multiple geometries whose only difference is the shape
-and number of the groups.
+and number of the groups (and, for killer geometries, the cages).
+Variant geometries add extra groups to the Sudoku ones, and
+composite geometries overlap several Sudoku grids.
 
 */
 
@@ -21,7 +23,9 @@ type groupDescriptor struct {
 // A puzzleMapping summarizes the geometry parameters of the
 // puzzle, including specifically the indexes in each of the
 // groups, and a mapping from each index to the groups that
-// contain it.
+// contain it.  Killer geometries also have cages, and a mapping
+// from each index to the number of its cage (see killer.go).
+// Composite geometries also have a layout (see samurai.go).
 type puzzleMapping struct {
 	geometry byte
 	sidelen  int
@@ -29,6 +33,9 @@ type puzzleMapping struct {
 	gcount   int
 	gdescs   []groupDescriptor
 	ixmap    [][]int
+	cages    []Cage // cage n is cages[n-1]
+	cageOf   []int  // 1-based indexing, 0 for squares in no cage
+	layout   *gridLayout
 }
 
 /*
@@ -40,6 +47,13 @@ Registered geometries
 const (
 	SudokuGeometryCode = 0
 	DudokuGeometryCode = 1
+	KillerGeometryCode = 2
+	// variants of Sudoku with extra groups (see variants.go)
+	XSudokuGeometryCode      = 3
+	HyperSudokuGeometryCode  = 4
+	HyperXSudokuGeometryCode = 5
+	// overlapping Sudoku grids (see samurai.go)
+	SamuraiGeometryCode = 6
 )
 
 // newSudokuPuzzle creates a Sudoku puzzle from the given values
@@ -156,7 +170,7 @@ func computeSquarePuzzleMapping(slen, tlen int) *puzzleMapping {
 		}
 		gs[tgi] = groupDescriptor{tgi, GroupID{GtypeTile, i + 1}, tile}
 	}
-	return &puzzleMapping{SudokuGeometryCode, slen, scount, gcount, gs, im}
+	return &puzzleMapping{SudokuGeometryCode, slen, scount, gcount, gs, im, nil, nil, nil}
 }
 
 // squarePuzzleMapping returns the puzzle map for a square puzzle
@@ -168,7 +182,7 @@ func squarePuzzleMapping(psize int) (*puzzleMapping, error) {
 	if !ok {
 		return nil, formatError(PuzzleSizeAttribute, psize, NonSquareCondition, 0)
 	}
-	min, max := 4, 225 // largest that fits in a btye
+	min, max := 4, 225 // largest that fits in a byte
 	if sidelen < min {
 		return nil, formatError(SideLengthAttribute, sidelen, TooSmallCondition, min)
 	}
@@ -289,7 +303,7 @@ func computeRectanglePuzzleMapping(slen, low, high int) *puzzleMapping {
 		}
 		gs[tgi] = groupDescriptor{tgi, GroupID{GtypeTile, i + 1}, tile}
 	}
-	return &puzzleMapping{DudokuGeometryCode, slen, scount, gcount, gs, im}
+	return &puzzleMapping{DudokuGeometryCode, slen, scount, gcount, gs, im, nil, nil, nil}
 }
 
 // rectanglePuzzleMapping returns the puzzle map for a square puzzle
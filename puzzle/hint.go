@@ -0,0 +1,89 @@
+package puzzle
+
+/*
+
+Hints
+
+A hint is the next square a person would fill, found the same
+way the rater works the puzzle: with the easiest techniques that
+make progress, until one of them places a value.  If the rater
+would have to guess, the hint comes from the puzzle's solution.
+If the rater used a coloring or forcing chain on the way, the
+hint includes the last one, as the reasoning a player would need.
+
+*/
+
+// A Hint is a suggested next move, plus the hardest technique
+// needed to find it, and the last chain (if any) used to find it.
+type Hint struct {
+	Choice    Choice `json:"choice"`
+	Technique string `json:"technique"`
+	Chain     *Chain `json:"chain,omitempty"`
+}
+
+// Suggest works out a hint for a puzzle in its current state.
+// It's an error to ask for a hint for a puzzle that can't be
+// solved, one that's already filled, or one that doesn't reveal
+// its contents (such as a contest puzzle).
+func Suggest(p Puzzle) (Hint, error) {
+	if rp, ok := p.(*relaxedPuzzle); ok {
+		p = rp.puzzle
+	}
+	puz, ok := p.(*puzzle)
+	if !ok {
+		return Hint{}, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle contents are not available for hints"},
+		}
+	}
+	solved, t := solve(puz.copy(), nil)
+	t.release()
+	if len(solved.errors) > 0 {
+		return Hint{}, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle has no solution"},
+		}
+	}
+	r := newRater(puz)
+	r.crossCheck(puz, solved)
+	before := append([]int(nil), r.values...)
+	for {
+		more := r.step()
+		for i := 1; i <= r.mapping.scount; i++ {
+			if r.values[i] != before[i] {
+				return Hint{Choice{i, r.values[i]}, r.hardest(), r.chain}, nil
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	solution := solved.allValues()
+	for i := 1; i <= puz.mapping.scount; i++ {
+		if puz.squares[i].aval == 0 {
+			return Hint{Choice{i, solution[i-1]}, TechniqueGuess, nil}, nil
+		}
+	}
+	return Hint{}, Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{"Puzzle is already filled"},
+	}
+}
+
+// hardest returns the name of the hardest technique the rater
+// has used.
+func (r *rater) hardest() string {
+	name := techniques[0]
+	for i, count := range r.counts {
+		if count > 0 {
+			name = techniques[i]
+		}
+	}
+	return name
+}
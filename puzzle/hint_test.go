@@ -0,0 +1,53 @@
+package puzzle
+
+import (
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	tcs := []struct {
+		values    []int
+		technique string
+	}{
+		{oneStarValues, TechniqueHiddenSingle},
+		{chronTwoValues, TechniqueHiddenSingle},
+		{solveSimpleStartValues, TechniqueGuess},
+	}
+	for i, tc := range tcs {
+		p, e := helperNewSudokuPuzzle(tc.values)
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		h, e := Suggest(p)
+		if e != nil {
+			t.Fatalf("test %d: Suggest failed: %v", i+1, e)
+		}
+		if h.Technique != tc.technique || p.squares[h.Choice.Index].aval != 0 {
+			t.Errorf("test %d: got hint %+v", i+1, h)
+		}
+		// following the hint leaves the puzzle solvable
+		if _, e := p.Assign(h.Choice); e != nil || len(p.errors) > 0 || len(p.Solutions()) == 0 {
+			t.Errorf("test %d: hint %+v led to %v, %v", i+1, h, e, p.errors)
+		}
+	}
+
+	// puzzles that are filled, unsolvable, or hidden get no hints
+	bad := append([]int(nil), oneStarValues...)
+	bad[1] = bad[0]
+	for i, vals := range [][]int{rotation4Puzzle1Complete1, bad} {
+		p, e := helperNewSudokuPuzzle(vals)
+		if e != nil {
+			t.Fatalf("error test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		if h, e := Suggest(p); e == nil {
+			t.Errorf("error test %d: got hint %+v", i+1, h)
+		}
+	}
+	c, e := NewContest(append([]int{SudokuGeometryCode}, oneStarValues...))
+	if e != nil {
+		t.Fatalf("Failed to create contest puzzle: %v", e)
+	}
+	if h, e := Suggest(c); e == nil {
+		t.Errorf("Contest puzzle got hint %+v", h)
+	}
+}
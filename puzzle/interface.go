@@ -52,12 +52,50 @@ import (
 // can't guarantee that an Error will always be returned.
 // Clients should guard against this possibility, as do the
 // RESTful wrappers built into this module.
+//
+// A proper puzzle is one with exactly one solution.  IsProper
+// returns nil for proper puzzles, and an Error with the
+// ImproperPuzzleCondition for puzzles that have no solution
+// (including ones with contradictions) or more than one.
+//
+// Encoding returns a compact, URL-safe string for the puzzle's
+// current values, which FromEncoding turns back into a Puzzle.
+//
+// Checkpoint saves the puzzle's state and returns a Tag for it,
+// and Rollback returns the puzzle to a tagged state, so callers
+// can try speculative changes and then undo them cleanly.
+//
+// Conflicts returns the contradictions in the puzzle's current
+// squares (see Conflict), which are nil for a puzzle that
+// withholds its errors.
+//
+// OnChange adds a function the puzzle calls with the events of
+// each assignment and rollback (see Event), so a client can follow
+// a puzzle's changes without comparing its squares.
+//
+// Everything a puzzle returns (its State, Squares, Solutions,
+// Conflicts, and Updates, with their errors) belongs to the
+// caller: changing it can't change the puzzle, and changes to the
+// puzzle don't show up in it.  Copy returns a puzzle that shares
+// nothing changeable with the original (though not its
+// checkpoints), so a snapshot can be read, rendered, or solved
+// while the original is played, as long as each is only used by
+// one goroutine at a time.  The implementations built into this
+// module work that way; ones registered by other modules should.
 type Puzzle interface {
 	State() State
 	Squares() []Square
 	Solutions() []Solution
 	Assign(choice Choice) (Update, error)
+	MarkCandidate(choice Choice) (Update, error)
+	UnmarkCandidate(choice Choice) (Update, error)
+	IsProper() error
 	Copy() Puzzle
+	Encoding() string
+	Checkpoint() Tag
+	Rollback(tag Tag) error
+	Conflicts() []Conflict
+	OnChange(f func(Event))
 }
 
 // New either returns a Puzzle with the specified geometry and
@@ -106,19 +144,23 @@ func New(geoAndValues []int) (Puzzle, error) {
 }
 
 // The State of a puzzle gives its geometry, side length,
-// cell values, and any known problems with the puzzle.
+// cell values, and any known problems with the puzzle.  Done
+// says whether the puzzle is solved: every square is filled and
+// there are no problems.
 type State struct {
 	Geometry  int     `json:"geometry"`
 	SideLenth int     `json:"sidelen"`
 	Values    []int   `json:"values"`
 	Errors    []Error `json:"errors,omitempty"`
+	Done      bool    `json:"done,omitempty"`
 }
 
 // A Square in a puzzle gives the square's index, assigned value
-// (if any), bound value (if any, with sources), and possible
-// values (if more than one).  Puzzle squares are numbered
-// left-to-right, top-to-bottom, starting at 1, and the sequence
-// of squares is returned in that order.
+// (if any), bound value (if any, with sources), possible values
+// (if more than one), and user-entered candidate marks (if any).
+// Puzzle squares are numbered left-to-right, top-to-bottom,
+// starting at 1, and the sequence of squares is returned in that
+// order.
 //
 // Only required fields should be specified in a Square, so as to
 // minimize the Square's JSON-encoded form (which is used for
@@ -129,13 +171,34 @@ type State struct {
 // square has only one possible value it should be specified as
 // the Aval or the Bval (bound value).  A Bsrc (bound value
 // source) should only be present if a row, column, or tile
-// requires that bound value be assigned to the Square.
+// requires that bound value be assigned to the Square.  The
+// Marks (pencil marks) field is whatever the user has noted as
+// candidates for an empty square; unlike the Pvals, the server
+// doesn't compute or check these.  Puzzles never set the Guess
+// flag; it's for services that track speculative assignments to
+// mark the assigned squares that are part of a guess.  Nor do
+// they set the Source, which is for services that track where
+// the values of assigned squares came from.  In killer puzzles,
+// the Cage and Sum of a square are the number and sum of the
+// cage it's in, if any; they're present whatever the other
+// fields are, since they're part of the puzzle's structure.  So
+// are the Row and Col of a square in a composite puzzle (such as
+// a Samurai puzzle), which place it in the puzzle's layout,
+// counting from 1 at the top left; squares of other puzzles
+// don't have them, since their places follow from their indices.
 type Square struct {
-	Index int       `json:"index"`
-	Aval  int       `json:"aval,omitempty"`
-	Bval  int       `json:"bval,omitempty"`
-	Bsrc  []GroupID `json:"bsrc,omitempty"`
-	Pvals intset    `json:"pvals,omitempty"`
+	Index  int       `json:"index"`
+	Aval   int       `json:"aval,omitempty"`
+	Bval   int       `json:"bval,omitempty"`
+	Bsrc   []GroupID `json:"bsrc,omitempty"`
+	Pvals  intset    `json:"pvals,omitempty"`
+	Marks  intset    `json:"marks,omitempty"`
+	Guess  bool      `json:"guess,omitempty"`
+	Source string    `json:"source,omitempty"`
+	Cage   int       `json:"cage,omitempty"`
+	Sum    int       `json:"sum,omitempty"`
+	Row    int       `json:"row,omitempty"`
+	Col    int       `json:"col,omitempty"`
 }
 
 // A GroupID names a row, column, tile, diagonal, or other set of
@@ -164,6 +227,8 @@ const (
 	GtypeCol      = "column"
 	GtypeTile     = "tile"
 	GtypeDiagonal = "diagonal"
+	GtypeHyper    = "hyper"
+	GtypeCage     = "cage"
 )
 
 // A Choice assigns a value to a cell.  The cell is referred to
@@ -177,10 +242,19 @@ type Choice struct {
 // any changed squares.  If there was a problem performing the
 // assignment, or if performing the assignment produced errors in
 // the underlying puzzle, they are reported here.  (Any puzzle
-// errors will also be available in the puzzle's state.)
+// errors will also be available in the puzzle's state.)  Solved
+// says whether the assignment solved the puzzle; it's never set
+// for puzzles whose errors are withheld, such as contest puzzles.
+// Conflicts are the puzzle's conflicts after the assignment.
+// Progress is the puzzle's fill progress after the assignment,
+// for services that report it (see fill.go); puzzles leave it
+// unset.
 type Update struct {
-	Squares []Square `json:"squares,omitempty"`
-	Errors  []Error  `json:"conflict,omitempty"`
+	Squares   []Square      `json:"squares,omitempty"`
+	Errors    []Error       `json:"conflict,omitempty"`
+	Solved    bool          `json:"solved,omitempty"`
+	Conflicts []Conflict    `json:"conflicts,omitempty"`
+	Progress  *FillProgress `json:"progress,omitempty"`
 }
 
 // A Solution is a filled-in puzzle (expressed as its values)
@@ -69,12 +69,12 @@ func (p *puzzle) String() (result string) {
 			}
 			if s.aval != 0 {
 				result += fmt.Sprintf(" %s ", vstr(s.aval))
-			} else if len(s.pvals) == 1 {
-				result += fmt.Sprintf("=%s ", vstr(s.pvals[0]))
+			} else if s.pvals.len() == 1 {
+				result += fmt.Sprintf("=%s ", vstr(s.pvals.first()))
 			} else if s.bval != 0 {
 				result += fmt.Sprintf("+%s ", vstr(s.bval))
-			} else if len(s.pvals) == 2 {
-				result += fmt.Sprintf("%s,%s", vstr(s.pvals[0]), vstr(s.pvals[1]))
+			} else if s.pvals.len() == 2 {
+				result += fmt.Sprintf("%s,%s", vstr(s.pvals.first()), vstr(s.pvals.last()))
 			} else {
 				result += fmt.Sprintf(" _ ")
 			}
@@ -0,0 +1,213 @@
+package puzzle
+
+import (
+	"fmt"
+)
+
+/*
+
+Killer Sudoku
+
+A killer puzzle is a Sudoku puzzle whose squares are also
+divided into cages: sets of squares whose values can't repeat,
+and must add up to the cage's sum.  Killer puzzles have few
+givens, or none at all, since their cages make up for them.
+
+Each layout of cages is a geometry of its own (see
+RegisterKiller), so killer puzzles are made, stored, encoded,
+and verified like any others: from a geometry code and cell
+values.  The cages are part of the geometry's mapping, and the
+puzzle model enforces them along with the groups: after each
+assignment, the cages of the squares that changed keep only the
+possible values that can make up what's left of their sums.
+Duplicate values in a cage, and cages whose sums can't be made,
+are group Errors for the cage (with group type GtypeCage).
+
+Killer puzzles have side length 4 or 9, so that their indices and
+sums fit in the bytes of encodings and fingerprints.  There's
+one built-in killer geometry, with KillerGeometryCode.
+
+*/
+
+// A Cage is a set of squares, given by their indices, whose
+// values can't repeat and must add up to the Sum.
+type Cage struct {
+	Sum     int   `json:"sum"`
+	Indices []int `json:"indices"`
+}
+
+// maxKillerSideLength is the largest side length of killer
+// puzzles.
+const maxKillerSideLength = 9
+
+// RegisterKiller registers a killer geometry with the given names
+// and code, for Sudoku puzzles of the given side length with the
+// given cages.  Cages can't overlap, can't have more squares than
+// the side length, and must have sums that distinct values can
+// make.  Squares don't have to be in a cage.
+func RegisterKiller(names []string, code byte, sidelen int, cages []Cage) error {
+	if sidelen > maxKillerSideLength {
+		return fmt.Errorf("Killer puzzles have side length at most %d", maxKillerSideLength)
+	}
+	base, e := squarePuzzleMapping(sidelen * sidelen)
+	if e != nil {
+		return e
+	}
+	m := *base
+	m.geometry = code
+	m.cages = make([]Cage, len(cages))
+	m.cageOf = make([]int, m.scount+1)
+	for ci, c := range cages {
+		n := len(c.Indices)
+		if n == 0 || n > sidelen {
+			return fmt.Errorf("Cage %d has %d squares, which must be from 1 to %d", ci+1, n, sidelen)
+		}
+		if min, max := n*(n+1)/2, n*(2*sidelen-n+1)/2; c.Sum < min || c.Sum > max {
+			return fmt.Errorf("Cage %d has sum %d, which must be from %d to %d", ci+1, c.Sum, min, max)
+		}
+		var indices intset
+		for _, i := range c.Indices {
+			if i < 1 || i > m.scount {
+				return fmt.Errorf("Cage %d has square %d, which must be from 1 to %d", ci+1, i, m.scount)
+			}
+			if m.cageOf[i] != 0 {
+				return fmt.Errorf("Square %d is in cages %d and %d", i, m.cageOf[i], ci+1)
+			}
+			m.cageOf[i] = ci + 1
+			indices.insert(i)
+		}
+		m.cages[ci] = Cage{c.Sum, indices}
+	}
+	gd := GeometryDescriptor{
+		Names: names,
+		Code:  code,
+		New: func(values []int) (Puzzle, error) {
+			if len(values) != m.scount {
+				return nil, Error{
+					Scope:     GeometryScope,
+					Structure: AttributeValueStructure,
+					Attribute: PuzzleSizeAttribute,
+					Condition: GeneralCondition,
+					Values:    ErrorData{len(values), fmt.Sprintf("Must have %d squares", m.scount)},
+				}
+			}
+			return create(&m, values)
+		},
+	}
+	if e := RegisterGeometry(&gd); e != nil {
+		return e
+	}
+	killerMappings[code] = &m
+	return nil
+}
+
+// killerMappings are the mappings of the registered killer
+// geometries, by code.
+var killerMappings = make(map[byte]*puzzleMapping)
+
+// killerMapping returns the mapping of a killer geometry, or nil
+// if the code isn't one.
+func killerMapping(code int) *puzzleMapping {
+	if code < 0 || code > 255 {
+		return nil
+	}
+	return killerMappings[byte(code)]
+}
+
+// KillerCages returns the cages of a killer geometry, and whether
+// the code is one.
+func KillerCages(code int) ([]Cage, bool) {
+	m := killerMapping(code)
+	if m == nil {
+		return nil, false
+	}
+	cages := make([]Cage, len(m.cages))
+	for i, c := range m.cages {
+		cages[i] = Cage{c.Sum, newIntsetCopy(c.Indices)}
+	}
+	return cages, true
+}
+
+// relaxCage checks a cage for duplicate values and for a sum
+// that can't be made, and removes the possible values of its
+// empty squares that can't help make it.  A value can help if
+// it's in a set of distinct values that adds up to what's left
+// of the sum, has a value for each empty square to take, and
+// doesn't repeat an assigned value.
+func relaxCage(m *puzzleMapping, n int, ss []*square) []Error {
+	c, id := &m.cages[n-1], GroupID{GtypeCage, n}
+	var errs []Error
+	var assigned valset
+	remaining := c.Sum
+	var empty intset
+	for _, i := range c.Indices {
+		if v := ss[i].aval; v != 0 {
+			if assigned.has(v) {
+				errs = append(errs, groupError(id, v, DuplicateGroupValuesCondition))
+			}
+			assigned.insert(v)
+			remaining -= v
+		} else {
+			empty = append(empty, i)
+		}
+	}
+	if len(empty) == 0 {
+		if remaining != 0 {
+			errs = append(errs, groupError(id, c.Sum, CageSumCondition))
+		}
+		return errs
+	}
+	var available valset
+	for _, i := range empty {
+		available.merge(ss[i].pvals)
+	}
+	vals := available.minus(assigned).intset()
+	var helps valset
+	combinations(len(vals), len(empty), func(pick []int) bool {
+		var set valset
+		sum := 0
+		for _, p := range pick {
+			set.insert(vals[p])
+			sum += vals[p]
+		}
+		if sum != remaining {
+			return false
+		}
+		for _, i := range empty {
+			if ss[i].pvals.and(set).empty() {
+				return false
+			}
+		}
+		helps.merge(set)
+		return false
+	})
+	if helps.empty() {
+		return append(errs, groupError(id, c.Sum, CageSumCondition))
+	}
+	for _, i := range empty {
+		errs = append(errs, ss[i].intersect(helps)...)
+	}
+	return errs
+}
+
+// killerCages are the cages of the built-in killer geometry.
+var killerCages = []Cage{
+	{24, []int{1, 2, 10, 11}}, {5, []int{3, 12}}, {15, []int{4, 5, 13}},
+	{20, []int{6, 7, 8, 9}}, {22, []int{14, 15, 16}}, {4, []int{17, 18}},
+	{15, []int{19, 28, 37}}, {8, []int{20, 21}}, {18, []int{22, 30, 31, 39}},
+	{13, []int{23, 24, 25}}, {15, []int{26, 27}}, {20, []int{29, 38, 46, 47}},
+	{10, []int{32, 33, 34}}, {17, []int{35, 36, 45}}, {24, []int{40, 41, 50, 58, 59}},
+	{10, []int{42, 43}}, {8, []int{44, 53}}, {17, []int{48, 49, 56, 57}},
+	{24, []int{51, 52, 60, 61}}, {26, []int{54, 63, 72, 80, 81}}, {9, []int{55, 64}},
+	{10, []int{62, 71}}, {12, []int{65, 66}}, {17, []int{67, 68}},
+	{5, []int{69, 70}}, {15, []int{73, 74, 75}}, {8, []int{76, 77}},
+	{14, []int{78, 79}},
+}
+
+// register the built-in killer geometry
+func init() {
+	err := RegisterKiller([]string{"Killer", "killer"}, KillerGeometryCode, 9, killerCages)
+	if err != nil {
+		panic(err) // if we can't register, we can't start up
+	}
+}
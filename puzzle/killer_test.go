@@ -0,0 +1,169 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testKillerGeometryCode is a 4x4 killer layout with two cages:
+// squares 1 and 2 add up to 3, and squares 4 and 5 add up to 7.
+const testKillerGeometryCode = 250
+
+func init() {
+	cages := []Cage{{3, []int{1, 2}}, {7, []int{4, 5}}}
+	if e := RegisterKiller([]string{"test-killer"}, testKillerGeometryCode, 4, cages); e != nil {
+		panic(e)
+	}
+}
+
+func TestKillerGeometry(t *testing.T) {
+	if gd, ok := LookupGeometryByName("killer"); !ok || gd.Code != KillerGeometryCode {
+		t.Fatalf("Killer geometry isn't registered")
+	}
+	cages, ok := KillerCages(KillerGeometryCode)
+	if !ok {
+		t.Fatalf("Killer geometry has no cages")
+	}
+	if _, ok := KillerCages(SudokuGeometryCode); ok {
+		t.Errorf("Sudoku geometry has cages")
+	}
+
+	// the built-in cages cover the board, and add up in the
+	// six-star solution
+	covered := make([]int, 82)
+	for n, c := range cages {
+		sum := 0
+		for _, i := range c.Indices {
+			covered[i]++
+			sum += sixStarSolution.Values[i-1]
+		}
+		if sum != c.Sum {
+			t.Errorf("Cage %d adds up to %d, not %d", n+1, sum, c.Sum)
+		}
+	}
+	for i := 1; i <= 81; i++ {
+		if covered[i] != 1 {
+			t.Errorf("Square %d is in %d cages", i, covered[i])
+		}
+	}
+
+	// the empty puzzle has exactly that solution
+	empty := append([]int{KillerGeometryCode}, make([]int, 81)...)
+	p, e := New(empty)
+	if e != nil {
+		t.Fatalf("Failed to create killer puzzle: %v", e)
+	}
+	if e := p.IsProper(); e != nil {
+		t.Errorf("Killer puzzle isn't proper: %v", e)
+	}
+	if s := p.Solutions(); len(s) != 1 || !reflect.DeepEqual(s[0].Values, sixStarSolution.Values) {
+		t.Errorf("Killer puzzle has solutions %v", s)
+	}
+
+	// squares carry their cages, whether or not they're filled
+	p.Assign(Choice{1, 9})
+	squares := p.Squares()
+	if s := squares[0]; s.Aval != 9 || s.Cage != 1 || s.Sum != 24 {
+		t.Errorf("Square 1 is %+v", s)
+	}
+	if s := squares[80]; s.Cage != 20 || s.Sum != 26 {
+		t.Errorf("Square 81 is %+v", s)
+	}
+	c, _ := NewContest(empty)
+	if s := c.Squares()[2]; s.Cage != 2 || s.Sum != 5 {
+		t.Errorf("Contest square 3 is %+v", s)
+	}
+
+	// killer puzzles are their own canonical forms, and have
+	// to be the right size
+	if canon, e := Canonical(empty); e != nil || !reflect.DeepEqual(canon, empty) {
+		t.Errorf("Canonical killer puzzle is %v, %v", canon, e)
+	}
+	if _, e := New(empty[:17]); e == nil {
+		t.Errorf("Short killer puzzle was created")
+	}
+}
+
+func TestKillerCages(t *testing.T) {
+	// cages rule out values from the start, and after assignments
+	p, e := New(append([]int{testKillerGeometryCode}, make([]int, 16)...))
+	if e != nil {
+		t.Fatalf("Failed to create test killer puzzle: %v", e)
+	}
+	if s := p.Squares()[0]; !reflect.DeepEqual(s.Pvals, intset{1, 2}) {
+		t.Errorf("Square 1 is %+v", s)
+	}
+	update, e := p.Assign(Choice{1, 2})
+	if e != nil || len(update.Errors) != 0 {
+		t.Fatalf("Assignment gave %+v, %v", update, e)
+	}
+	if s := p.Squares()[1]; s.Aval != 0 || (s.Bval != 1 && !reflect.DeepEqual(s.Pvals, intset{1})) {
+		t.Errorf("Square 2 is %+v", s)
+	}
+
+	// repeated values and wrong sums are cage errors
+	cage := GroupID{GtypeCage, 2}
+	tcs := []struct {
+		sq4, sq5 int
+		errors   []Error
+	}{
+		{4, 4, []Error{groupError(cage, 4, DuplicateGroupValuesCondition), groupError(cage, 7, CageSumCondition)}},
+		{4, 2, []Error{groupError(cage, 7, CageSumCondition)}},
+	}
+	for i, tc := range tcs {
+		values := append([]int{testKillerGeometryCode}, make([]int, 16)...)
+		values[4], values[5] = tc.sq4, tc.sq5
+		p, e := New(values)
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		var errs []Error
+		for _, err := range p.State().Errors {
+			if err.Scope == GroupScope && err.Values[0] == cage {
+				err.Message = ""
+				errs = append(errs, err)
+			}
+		}
+		if !reflect.DeepEqual(errs, tc.errors) {
+			t.Errorf("test %d: cage errors were %+v, expected %+v", i+1, errs, tc.errors)
+		}
+	}
+
+	// solutions have to add up
+	solution := append([]int{KillerGeometryCode}, sixStarSolution.Values...)
+	empty := append([]int{KillerGeometryCode}, make([]int, 81)...)
+	if v, e := Verify(empty, solution); e != nil || !v.Valid {
+		t.Errorf("Killer solution was %+v, %v", v, e)
+	}
+	relabeled := append([]int(nil), solution...)
+	for i := 1; i < len(relabeled); i++ {
+		switch relabeled[i] {
+		case 1:
+			relabeled[i] = 2
+		case 2:
+			relabeled[i] = 1
+		}
+	}
+	if v, e := Verify(empty, relabeled); e != nil || v.Valid {
+		t.Errorf("Relabeled killer solution was %+v, %v", v, e)
+	}
+}
+
+func TestRegisterKiller(t *testing.T) {
+	for i, cages := range [][]Cage{
+		{{3, []int{1, 2}}, {5, []int{2, 3}}},   // overlap
+		{{9, []int{1, 2}}},                     // too big
+		{{2, []int{1, 2}}},                     // too small
+		{{10, []int{1, 2, 3, 4, 5}}},           // too many squares
+		{{3, []int{0, 1}}},                     // out of range
+		{{3, []int{}}},                         // empty
+		{{3, []int{1, 2}}, {3, []int{15, 16}}}, // fine, but the code is taken
+	} {
+		if e := RegisterKiller([]string{"bad-killer"}, testKillerGeometryCode, 4, cages); e == nil {
+			t.Errorf("test %d: registration succeeded", i+1)
+		}
+	}
+	if e := RegisterKiller([]string{"big-killer"}, 251, 16, nil); e == nil {
+		t.Errorf("16x16 killer registration succeeded")
+	}
+}
@@ -0,0 +1,393 @@
+package puzzle
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/*
+
+Messages
+
+Errors can describe themselves, and techniques (see rate.go) can
+be named, in other languages than English, from catalogs of
+messages keyed by error scope, attribute, and condition, and by
+technique.  English is built in, as are catalogs for German,
+Spanish, and French, and others can be registered with
+RegisterMessages.  The codes of an Error, and the technique names
+in hints, explanations, and ratings, are the same in every
+language, so that clients can keep matching on them (and do
+their own localization, if they'd rather).
+
+Catalog messages are format strings that take the same values,
+in the same order, as the English ones, though they can use them
+in a different order with explicit argument indexes (such as
+%[2]v).  Whatever a catalog is missing is given in English, and
+so is the free text of errors with GeneralCondition.
+
+*/
+
+// A MessageCatalog has the messages of one language.  Scope
+// messages start an error's message, and include their
+// separator from the rest of it.
+type MessageCatalog struct {
+	Scopes     map[ErrorScope]string
+	Attributes map[ErrorAttribute]string
+	Conditions map[ErrorCondition]string
+	Techniques map[string]string
+}
+
+// englishMessages are the built-in messages.  Techniques are
+// named in English by their own names.
+var englishMessages = MessageCatalog{
+	Scopes: map[ErrorScope]string{
+		UnknownScope:  "Unknown error: ",
+		RequestScope:  "Invalid request: ",
+		ArgumentScope: "Invalid argument: ",
+		GeometryScope: "Invalid geometry: ",
+		GroupScope:    "Problem in %v: ",
+		SquareScope:   "Problem in square %v: ",
+		InternalScope: "Internal logic error: ",
+	},
+	Attributes: map[ErrorAttribute]string{
+		UnknownAttribute:        "<Unknown attribute>",
+		DecodeAttribute:         "JSON Decode error",
+		EncodeAttribute:         "JSON Encode error",
+		URLAttribute:            "Resource path",
+		LocationAttribute:       "In puzzle.%v",
+		NamedAttribute:          "%v",
+		GeometryAttribute:       "Geometry",
+		IndexAttribute:          "Index",
+		ValueAttribute:          "Value",
+		AssignedValueAttribute:  "Assigned value",
+		BoundValueAttribute:     "Bound value",
+		RemovedValueAttribute:   "Removed value",
+		RemovedValuesAttribute:  "Removed values",
+		RetainedValuesAttribute: "Retained values",
+		PuzzleSizeAttribute:     "Puzzle size",
+		SideLengthAttribute:     "Side length",
+		SymbolAttribute:         "Symbol",
+	},
+	Conditions: map[ErrorCondition]string{
+		UnknownCondition:                 "Supplemental data is %v",
+		GeneralCondition:                 "%v",
+		TooLargeCondition:                "Must be at most %v",
+		TooSmallCondition:                "Must be at least %v",
+		DuplicateAssignmentCondition:     "Square %v is already assigned value %v",
+		NotInSetCondition:                "Must be in possible values %v",
+		NoPossibleValuesCondition:        "No remaining possible values",
+		NoGroupValueCondition:            "No square can contain %v",
+		DuplicateGroupValuesCondition:    "Multiple squares have value %v",
+		UnknownGeometryCondition:         "Not a known geometry",
+		NonSquareCondition:               "Not a perfect square",
+		NonRectangleCondition:            "Not the product of consecutive integers",
+		InvalidPuzzleAssignmentCondition: "Target puzzle has errors; no assignments are allowed",
+		EmptyArgumentCondition:           "Required argument value was empty or not supplied",
+		IncompleteSolutionCondition:      "Solution has %v empty square(s)",
+		ChangedGivenCondition:            "Doesn't match the puzzle's given value %v",
+		ImproperPuzzleCondition:          "Must have exactly one solution (found %v)",
+		CageSumCondition:                 "No values can add up to %v",
+		NotOneSymbolCondition:            "Must be a single symbol (found %v)",
+		DuplicateSymbolCondition:         "Same as symbol %v",
+		UnknownSymbolCondition:           "Must be one of the puzzle's symbols %v",
+		DeadlineExceededCondition:        "Not done by the deadline",
+	},
+}
+
+var (
+	catalogMutex sync.RWMutex
+	catalogs     = map[string]MessageCatalog{
+		"de": germanMessages,
+		"es": spanishMessages,
+		"fr": frenchMessages,
+	}
+)
+
+// RegisterMessages sets the catalog for a language, named by its
+// language tag (such as "pt" or "pt-BR"), replacing any catalog
+// it already had.
+func RegisterMessages(language string, catalog MessageCatalog) {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	catalogs[strings.ToLower(language)] = catalog
+}
+
+// findMessages returns the catalog for a language tag: the one
+// registered for the tag, or else the one for its language.  The
+// tag can also be an Accept-Language header, whose first
+// language is used.  Languages without catalogs get an empty
+// one, which gives every message in English.
+func findMessages(language string) MessageCatalog {
+	tag := language
+	if i := strings.IndexAny(tag, ",;"); i >= 0 {
+		tag = tag[:i]
+	}
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	catalogMutex.RLock()
+	defer catalogMutex.RUnlock()
+	if c, ok := catalogs[tag]; ok {
+		return c
+	}
+	return catalogs[strings.SplitN(tag, "-", 2)[0]]
+}
+
+// TechniqueName returns the name of a technique in a language
+// (see findMessages), which is the technique itself in English.
+func TechniqueName(technique, language string) string {
+	if name, ok := findMessages(language).Techniques[technique]; ok {
+		return name
+	}
+	return technique
+}
+
+// Localize returns the Error's message in a language (see
+// findMessages).  It ignores any message the Error already has,
+// which is just its English message.
+func (e Error) Localize(language string) string {
+	c := findMessages(language)
+	values := e.Values
+	// format formats the next values with a message, which takes
+	// as many of them as its English version does
+	format := func(message, english string) string {
+		if message == "" {
+			message = english
+		}
+		args := make([]interface{}, strings.Count(english, "%v"))
+		for i := range args {
+			if len(values) == 0 {
+				args[i] = "<unknown>"
+				continue
+			}
+			args[i], values = values[0], values[1:]
+		}
+		return fmt.Sprintf(message, args...)
+	}
+
+	scope := e.Scope
+	if _, ok := englishMessages.Scopes[scope]; !ok {
+		scope = UnknownScope
+	}
+	es := format(c.Scopes[scope], englishMessages.Scopes[scope])
+	if e.Structure == AttributeStructure || e.Structure == AttributeValueStructure {
+		attribute := e.Attribute
+		if _, ok := englishMessages.Attributes[attribute]; !ok {
+			attribute = UnknownAttribute
+		}
+		es += format(c.Attributes[attribute], englishMessages.Attributes[attribute])
+		if e.Structure == AttributeValueStructure {
+			es += " (" + format("", "%v") + ")"
+		}
+		es += ": "
+	}
+	if english, ok := englishMessages.Conditions[e.Condition]; ok && e.Condition != UnknownCondition {
+		es += format(c.Conditions[e.Condition], english)
+	} else {
+		message := c.Conditions[UnknownCondition]
+		if message == "" {
+			message = englishMessages.Conditions[UnknownCondition]
+		}
+		es += fmt.Sprintf(message, values)
+	}
+	return es
+}
+
+/*
+
+Built-in catalogs
+
+*/
+
+var germanMessages = MessageCatalog{
+	Scopes: map[ErrorScope]string{
+		UnknownScope:  "Unbekannter Fehler: ",
+		RequestScope:  "Ungültige Anfrage: ",
+		ArgumentScope: "Ungültiges Argument: ",
+		GeometryScope: "Ungültige Geometrie: ",
+		GroupScope:    "Problem in %v: ",
+		SquareScope:   "Problem in Feld %v: ",
+		InternalScope: "Interner Logikfehler: ",
+	},
+	Attributes: map[ErrorAttribute]string{
+		UnknownAttribute:        "<Unbekanntes Attribut>",
+		DecodeAttribute:         "JSON-Dekodierfehler",
+		EncodeAttribute:         "JSON-Kodierfehler",
+		URLAttribute:            "Ressourcenpfad",
+		GeometryAttribute:       "Geometrie",
+		IndexAttribute:          "Index",
+		ValueAttribute:          "Wert",
+		AssignedValueAttribute:  "Zugewiesener Wert",
+		BoundValueAttribute:     "Gebundener Wert",
+		RemovedValueAttribute:   "Entfernter Wert",
+		RemovedValuesAttribute:  "Entfernte Werte",
+		RetainedValuesAttribute: "Beibehaltene Werte",
+		PuzzleSizeAttribute:     "Rätselgröße",
+		SideLengthAttribute:     "Seitenlänge",
+		SymbolAttribute:         "Symbol",
+	},
+	Conditions: map[ErrorCondition]string{
+		UnknownCondition:                 "Zusätzliche Daten: %v",
+		TooLargeCondition:                "Darf höchstens %v sein",
+		TooSmallCondition:                "Muss mindestens %v sein",
+		DuplicateAssignmentCondition:     "Feld %v hat bereits den Wert %v",
+		NotInSetCondition:                "Muss einer der möglichen Werte %v sein",
+		NoPossibleValuesCondition:        "Keine möglichen Werte mehr",
+		NoGroupValueCondition:            "Kein Feld kann %v enthalten",
+		DuplicateGroupValuesCondition:    "Mehrere Felder haben den Wert %v",
+		UnknownGeometryCondition:         "Keine bekannte Geometrie",
+		NonSquareCondition:               "Keine Quadratzahl",
+		NonRectangleCondition:            "Kein Produkt aufeinanderfolgender Zahlen",
+		InvalidPuzzleAssignmentCondition: "Das Rätsel hat Fehler; Zuweisungen sind nicht möglich",
+		EmptyArgumentCondition:           "Ein erforderlicher Wert ist leer oder fehlt",
+		IncompleteSolutionCondition:      "Die Lösung hat %v leere(s) Feld(er)",
+		ChangedGivenCondition:            "Stimmt nicht mit dem vorgegebenen Wert %v überein",
+		ImproperPuzzleCondition:          "Muss genau eine Lösung haben (%v gefunden)",
+		CageSumCondition:                 "Keine Werte ergeben zusammen %v",
+		NotOneSymbolCondition:            "Muss ein einzelnes Symbol sein (%v gefunden)",
+		DuplicateSymbolCondition:         "Gleich wie Symbol %v",
+		UnknownSymbolCondition:           "Muss eines der Symbole des Rätsels sein: %v",
+		DeadlineExceededCondition:        "Nicht vor Ablauf der Frist fertig",
+	},
+	Techniques: map[string]string{
+		TechniqueHiddenSingle: "Versteckter Single",
+		TechniqueNakedSingle:  "Nackter Single",
+		TechniqueLocked:       "Gesperrte Kandidaten",
+		TechniquePair:         "Paar",
+		TechniqueTriple:       "Tripel",
+		TechniqueRectangle:    "Eindeutiges Rechteck",
+		TechniqueBUG:          "Bivalentes universelles Grab (BUG)",
+		TechniqueColoring:     "Einfaches Färben",
+		TechniqueChain:        "Erzwingende Kette",
+		TechniqueGuess:        "Raten",
+	},
+}
+
+var spanishMessages = MessageCatalog{
+	Scopes: map[ErrorScope]string{
+		UnknownScope:  "Error desconocido: ",
+		RequestScope:  "Solicitud no válida: ",
+		ArgumentScope: "Argumento no válido: ",
+		GeometryScope: "Geometría no válida: ",
+		GroupScope:    "Problema en %v: ",
+		SquareScope:   "Problema en la casilla %v: ",
+		InternalScope: "Error lógico interno: ",
+	},
+	Attributes: map[ErrorAttribute]string{
+		UnknownAttribute:        "<Atributo desconocido>",
+		DecodeAttribute:         "Error de decodificación JSON",
+		EncodeAttribute:         "Error de codificación JSON",
+		URLAttribute:            "Ruta del recurso",
+		LocationAttribute:       "En puzzle.%v",
+		GeometryAttribute:       "Geometría",
+		IndexAttribute:          "Índice",
+		ValueAttribute:          "Valor",
+		AssignedValueAttribute:  "Valor asignado",
+		BoundValueAttribute:     "Valor ligado",
+		RemovedValueAttribute:   "Valor eliminado",
+		RemovedValuesAttribute:  "Valores eliminados",
+		RetainedValuesAttribute: "Valores conservados",
+		PuzzleSizeAttribute:     "Tamaño del puzle",
+		SideLengthAttribute:     "Longitud del lado",
+		SymbolAttribute:         "Símbolo",
+	},
+	Conditions: map[ErrorCondition]string{
+		UnknownCondition:                 "Datos adicionales: %v",
+		TooLargeCondition:                "Debe ser como máximo %v",
+		TooSmallCondition:                "Debe ser como mínimo %v",
+		DuplicateAssignmentCondition:     "La casilla %v ya tiene el valor %v",
+		NotInSetCondition:                "Debe estar entre los valores posibles %v",
+		NoPossibleValuesCondition:        "No quedan valores posibles",
+		NoGroupValueCondition:            "Ninguna casilla puede contener %v",
+		DuplicateGroupValuesCondition:    "Varias casillas tienen el valor %v",
+		UnknownGeometryCondition:         "No es una geometría conocida",
+		NonSquareCondition:               "No es un cuadrado perfecto",
+		NonRectangleCondition:            "No es el producto de enteros consecutivos",
+		InvalidPuzzleAssignmentCondition: "El puzle tiene errores; no se permiten asignaciones",
+		EmptyArgumentCondition:           "Falta un argumento obligatorio o está vacío",
+		IncompleteSolutionCondition:      "La solución tiene %v casilla(s) vacía(s)",
+		ChangedGivenCondition:            "No coincide con el valor dado %v",
+		ImproperPuzzleCondition:          "Debe tener exactamente una solución (se encontraron %v)",
+		CageSumCondition:                 "Ningún conjunto de valores suma %v",
+		NotOneSymbolCondition:            "Debe ser un solo símbolo (se encontró %v)",
+		DuplicateSymbolCondition:         "Igual que el símbolo %v",
+		UnknownSymbolCondition:           "Debe ser uno de los símbolos del puzle %v",
+		DeadlineExceededCondition:        "No terminó antes del plazo",
+	},
+	Techniques: map[string]string{
+		TechniqueHiddenSingle: "Único oculto",
+		TechniqueNakedSingle:  "Único desnudo",
+		TechniqueLocked:       "Candidatos bloqueados",
+		TechniquePair:         "Pareja",
+		TechniqueTriple:       "Trío",
+		TechniqueRectangle:    "Rectángulo único",
+		TechniqueBUG:          "Tumba universal bivalor (BUG)",
+		TechniqueColoring:     "Coloreado simple",
+		TechniqueChain:        "Cadena forzada",
+		TechniqueGuess:        "Conjetura",
+	},
+}
+
+var frenchMessages = MessageCatalog{
+	Scopes: map[ErrorScope]string{
+		UnknownScope:  "Erreur inconnue : ",
+		RequestScope:  "Requête invalide : ",
+		ArgumentScope: "Argument invalide : ",
+		GeometryScope: "Géométrie invalide : ",
+		GroupScope:    "Problème dans %v : ",
+		SquareScope:   "Problème dans la case %v : ",
+		InternalScope: "Erreur logique interne : ",
+	},
+	Attributes: map[ErrorAttribute]string{
+		UnknownAttribute:        "<Attribut inconnu>",
+		DecodeAttribute:         "Erreur de décodage JSON",
+		EncodeAttribute:         "Erreur d'encodage JSON",
+		URLAttribute:            "Chemin de la ressource",
+		LocationAttribute:       "Dans puzzle.%v",
+		GeometryAttribute:       "Géométrie",
+		IndexAttribute:          "Indice",
+		ValueAttribute:          "Valeur",
+		AssignedValueAttribute:  "Valeur attribuée",
+		BoundValueAttribute:     "Valeur liée",
+		RemovedValueAttribute:   "Valeur retirée",
+		RemovedValuesAttribute:  "Valeurs retirées",
+		RetainedValuesAttribute: "Valeurs conservées",
+		PuzzleSizeAttribute:     "Taille de la grille",
+		SideLengthAttribute:     "Longueur du côté",
+		SymbolAttribute:         "Symbole",
+	},
+	Conditions: map[ErrorCondition]string{
+		UnknownCondition:                 "Données supplémentaires : %v",
+		TooLargeCondition:                "Doit valoir au plus %v",
+		TooSmallCondition:                "Doit valoir au moins %v",
+		DuplicateAssignmentCondition:     "La case %v a déjà la valeur %v",
+		NotInSetCondition:                "Doit être parmi les valeurs possibles %v",
+		NoPossibleValuesCondition:        "Plus aucune valeur possible",
+		NoGroupValueCondition:            "Aucune case ne peut contenir %v",
+		DuplicateGroupValuesCondition:    "Plusieurs cases ont la valeur %v",
+		UnknownGeometryCondition:         "Géométrie inconnue",
+		NonSquareCondition:               "N'est pas un carré parfait",
+		NonRectangleCondition:            "N'est pas le produit d'entiers consécutifs",
+		InvalidPuzzleAssignmentCondition: "La grille a des erreurs ; aucune attribution n'est permise",
+		EmptyArgumentCondition:           "Un argument requis est vide ou absent",
+		IncompleteSolutionCondition:      "La solution a %v case(s) vide(s)",
+		ChangedGivenCondition:            "Ne correspond pas à la valeur donnée %v",
+		ImproperPuzzleCondition:          "Doit avoir exactement une solution (%v trouvée(s))",
+		CageSumCondition:                 "Aucune combinaison de valeurs ne donne %v",
+		NotOneSymbolCondition:            "Doit être un seul symbole (%v trouvé)",
+		DuplicateSymbolCondition:         "Identique au symbole %v",
+		UnknownSymbolCondition:           "Doit être un des symboles de la grille %v",
+		DeadlineExceededCondition:        "Pas terminé avant l'échéance",
+	},
+	Techniques: map[string]string{
+		TechniqueHiddenSingle: "Singleton caché",
+		TechniqueNakedSingle:  "Singleton nu",
+		TechniqueLocked:       "Candidats verrouillés",
+		TechniquePair:         "Paire",
+		TechniqueTriple:       "Triplet",
+		TechniqueRectangle:    "Rectangle unique",
+		TechniqueBUG:          "Tombeau universel bivalué (BUG)",
+		TechniqueColoring:     "Coloriage simple",
+		TechniqueChain:        "Chaîne forcée",
+		TechniqueGuess:        "Essai",
+	},
+}
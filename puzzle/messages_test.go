@@ -0,0 +1,76 @@
+package puzzle
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalize(t *testing.T) {
+	e := Error{
+		Scope:     SquareScope,
+		Structure: AttributeValueStructure,
+		Attribute: AssignedValueAttribute,
+		Condition: TooLargeCondition,
+		Values:    ErrorData{5, 12, 9},
+	}
+	english := "Problem in square 5: Assigned value (12): Must be at most 9"
+	if m := e.Error(); m != english {
+		t.Errorf("English message is %q", m)
+	}
+	cases := map[string]string{
+		"":                english,
+		"tlh":             english,
+		"de":              "Problem in Feld 5: Zugewiesener Wert (12): Darf höchstens 9 sein",
+		"fr-CA,fr;q=0.9":  "Problème dans la case 5 : Valeur attribuée (12): Doit valoir au plus 9",
+		"ES-mx":           "Problema en la casilla 5: Valor asignado (12): Debe ser como máximo 9",
+		"de-AT, en;q=0.5": "Problem in Feld 5: Zugewiesener Wert (12): Darf höchstens 9 sein",
+	}
+	for language, expected := range cases {
+		if m := e.Localize(language); m != expected {
+			t.Errorf("Message in %q is %q, expected %q", language, m, expected)
+		}
+	}
+
+	// custom messages are kept by Error, and ignored by Localize
+	e.Message = "custom"
+	if e.Error() != "custom" || e.Localize("") != english {
+		t.Errorf("Custom message gave %q and %q", e.Error(), e.Localize(""))
+	}
+
+	// registered catalogs can reorder values, and fall back to
+	// English for what they're missing
+	RegisterMessages("x-test", MessageCatalog{
+		Conditions: map[ErrorCondition]string{
+			DuplicateAssignmentCondition: "%[2]v@%[1]v",
+		},
+		Techniques: map[string]string{TechniquePair: "Twosome"},
+	})
+	defer func() {
+		catalogMutex.Lock()
+		delete(catalogs, "x-test")
+		catalogMutex.Unlock()
+	}()
+	e = Error{Scope: RequestScope, Structure: ScopeStructure, Condition: DuplicateAssignmentCondition, Values: ErrorData{3, 7}}
+	if m := e.Localize("X-Test"); m != "Invalid request: 7@3" {
+		t.Errorf("Registered catalog message is %q", m)
+	}
+	if TechniqueName(TechniquePair, "x-test") != "Twosome" || TechniqueName(TechniqueTriple, "x-test") != TechniqueTriple ||
+		TechniqueName(TechniquePair, "de") != "Paar" || TechniqueName(TechniquePair, "") != TechniquePair {
+		t.Errorf("Technique names are wrong")
+	}
+
+	// the built-in catalogs take the same values as English
+	for language := range catalogs {
+		for sc := UnknownScope; sc < MaxScope; sc++ {
+			for at := UnknownAttribute; at < MaxAttribute; at++ {
+				for co := UnknownCondition; co < MaxCondition; co++ {
+					e := Error{Scope: sc, Structure: AttributeValueStructure, Attribute: at, Condition: co,
+						Values: ErrorData{1, 2, 3, 4}}
+					if m := e.Localize(language); strings.Contains(m, "%!") {
+						t.Fatalf("Message in %q for %+v is %q", language, e, m)
+					}
+				}
+			}
+		}
+	}
+}
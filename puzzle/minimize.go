@@ -0,0 +1,63 @@
+package puzzle
+
+/*
+
+Minimizing
+
+A proper puzzle is minimal when emptying any one of its filled
+squares would give it more than one solution.  Minimize makes a
+puzzle minimal by visiting its filled squares in an order
+shuffled by a seed, and emptying each one for good if the puzzle
+stays proper without it.  (A square that can't be emptied when
+it's visited can't be emptied later either, since emptying other
+squares only allows more solutions, so one pass is enough.)
+Unlike the generator (see generate.go), it doesn't keep the
+puzzle symmetric, which is how it gets down to fewer givens.
+
+Minimizing is reproducible in the same way as generation: the
+same puzzle and seed give the same result everywhere, and the
+seed's version names the algorithm.
+
+*/
+
+// Minimize returns the values, in the form taken by New, of a
+// minimal puzzle made from a proper puzzle by emptying squares,
+// in an order given by the seed.  The puzzle's filled squares
+// are all taken as givens.  It's an error if the puzzle doesn't
+// reveal its values (as contest puzzles don't), isn't proper, or
+// the seed has an unknown version.
+func Minimize(p Puzzle, seed string) ([]int, error) {
+	_, text, e := ParseSeed(seed)
+	if e != nil {
+		return nil, e
+	}
+	if _, ok := revealed(p); !ok {
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Only puzzles that reveal their values can be minimized"},
+		}
+	}
+	state := p.State()
+	values := append([]int{state.Geometry}, state.Values...)
+	start, e := New(values)
+	if e != nil {
+		return nil, e
+	}
+	if e := start.IsProper(); e != nil {
+		return nil, e
+	}
+	rng := newSeedRNG(text)
+	for _, i := range rng.perm(len(state.Values)) {
+		v := values[i+1]
+		if v == 0 {
+			continue
+		}
+		values[i+1] = 0
+		if q, e := New(values); e != nil || q.IsProper() != nil {
+			values[i+1] = v
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,67 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMinimize(t *testing.T) {
+	g, e := Generate(GenerateParams{Seed: "minimize"})
+	if e != nil {
+		t.Fatalf("Generate failed: %v", e)
+	}
+	p, _ := New(g.Values)
+	values, e := Minimize(p, "minimize")
+	if e != nil {
+		t.Fatalf("Minimize failed: %v", e)
+	}
+	count := func(vals []int) int {
+		n := 0
+		for _, v := range vals[1:] {
+			if v != 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if count(values) >= count(g.Values) {
+		t.Errorf("Minimize left %d of %d givens", count(values), count(g.Values))
+	}
+	m, _ := New(values)
+	if e := m.IsProper(); e != nil {
+		t.Fatalf("Minimized puzzle isn't proper: %v", e)
+	}
+	if !reflect.DeepEqual(m.Solutions()[0].Values, p.Solutions()[0].Values) {
+		t.Errorf("Minimized puzzle has a different solution")
+	}
+	for i, v := range values[1:] {
+		if v == 0 {
+			continue
+		}
+		fewer := append([]int(nil), values...)
+		fewer[i+1] = 0
+		if q, _ := New(fewer); q.IsProper() == nil {
+			t.Errorf("Square %d of the minimized puzzle can be emptied", i+1)
+		}
+	}
+
+	// the same seed always gives the same puzzle
+	if again, _ := Minimize(p, "1:minimize"); !reflect.DeepEqual(again, values) {
+		t.Errorf("Minimize with the same seed gave a different puzzle")
+	}
+
+	// only proper puzzles that reveal their values can be minimized
+	c, _ := NewContest(g.Values)
+	if _, e := Minimize(c, "minimize"); e == nil {
+		t.Errorf("Minimize of a contest puzzle succeeded")
+	}
+	empty := make([]int, len(g.Values))
+	empty[0] = SudokuGeometryCode
+	q, _ := New(empty)
+	if _, e := Minimize(q, "minimize"); e == nil {
+		t.Errorf("Minimize of an improper puzzle succeeded")
+	}
+	if _, e := Minimize(p, "9:minimize"); e == nil {
+		t.Errorf("Minimize with an unknown seed version succeeded")
+	}
+}
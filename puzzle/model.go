@@ -8,6 +8,7 @@ Sudoku puzzle representation
 
 import (
 	"fmt"
+	"math/bits"
 )
 
 /*
@@ -30,6 +31,8 @@ type puzzle struct {
 	groups  []*group
 	errors  []Error
 	logger  *indexLogger
+	saved   []*puzzle // checkpoints, oldest first
+	observers
 }
 
 // indicesToValues is a helper that takes an intset of indices
@@ -55,7 +58,7 @@ func (p *puzzle) allValues() []int {
 func (p *puzzle) indicesToPossibles(is intset) [][]int {
 	vs := make([][]int, len(is))
 	for i, idx := range is {
-		vs[i] = newIntsetCopy(p.squares[idx].pvals)
+		vs[i] = p.squares[idx].pvals.intset()
 	}
 	return vs
 }
@@ -74,6 +77,14 @@ func (p *puzzle) indicesToSquares(is intset) []Square {
 	for i, idx := range is {
 		S, s := &SS[i], p.squares[idx]
 		S.Index = s.index
+		if p.mapping.cageOf != nil {
+			if n := p.mapping.cageOf[idx]; n != 0 {
+				S.Cage, S.Sum = n, p.mapping.cages[n-1].Sum
+			}
+		}
+		if l := p.mapping.layout; l != nil {
+			S.Row, S.Col = l.positions[idx]/l.side+1, l.positions[idx]%l.side+1
+		}
 		if s.aval != 0 {
 			S.Aval = s.aval
 			continue
@@ -82,7 +93,10 @@ func (p *puzzle) indicesToSquares(is intset) []Square {
 			S.Bval = s.bval
 			S.Bsrc = append(S.Bsrc, s.bsrc...)
 		}
-		S.Pvals = newIntsetCopy(s.pvals)
+		S.Pvals = s.pvals.intset()
+		if len(s.marks) > 0 {
+			S.Marks = newIntsetCopy(s.marks)
+		}
 	}
 	return SS
 }
@@ -93,12 +107,15 @@ func (p *puzzle) allSquares() []Square {
 	return p.indicesToSquares(is)
 }
 
-// allErrors returns the puzzle's Errors.  The returned slice
-// doesn't share storage with the puzzle.
+// allErrors returns the puzzle's Errors.  Neither the returned
+// slice nor the errors' values share storage with the puzzle.
 func (p *puzzle) allErrors(verbose bool) []Error {
 	errs := append([]Error(nil), p.errors...)
-	if verbose {
-		for i := range errs {
+	for i := range errs {
+		if errs[i].Values != nil {
+			errs[i].Values = append(ErrorData(nil), errs[i].Values...)
+		}
+		if verbose {
 			errs[i].Message = errs[i].Error() // verbalize the error
 		}
 	}
@@ -134,7 +151,16 @@ func (p *puzzle) assign(idx, val int) intset {
 	// containing unassigned squares in those three containing
 	// groups (because those unassigned squares will have the
 	// assigned value removed).
-	affected := make([]int, p.mapping.gcount+1) // 1-based group indexes
+	// (The counts are kept on the stack when the puzzle is small
+	// enough, because the solver does lots of assignments; sides
+	// up to 32 have no more than 96 groups.)
+	var counts [3*32 + 1]int
+	var affected []int // 1-based group indexes
+	if p.mapping.gcount < len(counts) {
+		affected = counts[:p.mapping.gcount+1]
+	} else {
+		affected = make([]int, p.mapping.gcount+1)
+	}
 	for _, gi := range p.mapping.ixmap[idx] {
 		// this group needs to be analyzed
 		affected[gi]++
@@ -170,41 +196,102 @@ func (p *puzzle) assign(idx, val int) intset {
 			}
 		}
 	}
+
+	/// Part 4: In killer puzzles, relax the cages of all the
+	/// modified squares, since their sums may now rule out more
+	/// values.
+	if p.mapping.cages != nil {
+		var cages intset
+		for _, i := range p.logger.entries {
+			if n := p.mapping.cageOf[i]; n != 0 {
+				cages.insert(n)
+			}
+		}
+		for _, n := range cages {
+			if errs := relaxCage(p.mapping, n, p.squares); len(errs) > 0 {
+				// cage Errors make the puzzle unsolvable
+				p.errors = append(p.errors, errs...)
+			}
+		}
+	}
 	return p.logger.entries
 }
 
 // copy returns a deep copy of a puzzle
 func (p *puzzle) copy() *puzzle {
+	return p.copyInto(nil)
+}
+
+// copyInto makes a deep copy of a puzzle in the storage of
+// another puzzle, which is overwritten, and returns it.  If there
+// is no other puzzle, or its storage doesn't fit (it has a
+// different number of squares or groups), or it's the puzzle
+// being copied, the copy is newly allocated.  The solver uses
+// this to reuse the storage of the puzzles it's done with.
+func (p *puzzle) copyInto(c *puzzle) *puzzle {
 	if p == nil {
 		return nil
 	}
-	// first the puzzle structure
-	c := &puzzle{
-		mapping: p.mapping,          // mappings are invariant and always shared
-		logger:  &indexLogger{},     // loggers are per-puzzle, initialized empty
-		errors:  p.allErrors(false), // errors are per-puzzle, copied from source
+	if c == nil || c == p || len(c.squares) != len(p.squares) || len(c.groups) != len(p.groups) {
+		// the squares and groups are allocated together because
+		// the solver makes lots of copies
+		c = &puzzle{
+			squares: make([]*square, p.mapping.scount+1), // 1-based indexing
+			groups:  make([]*group, p.mapping.gcount+1),  // 1-based indexing
+			logger:  &indexLogger{},                      // loggers are per-puzzle, initialized empty
+		}
+		squares := make([]square, p.mapping.scount)
+		for i := 1; i <= p.mapping.scount; i++ {
+			c.squares[i] = &squares[i-1]
+		}
+		groups := make([]group, p.mapping.gcount)
+		for i := 1; i <= p.mapping.gcount; i++ {
+			c.groups[i] = &groups[i-1]
+		}
+	} else {
+		*c.logger = indexLogger{entries: c.logger.entries[:0]}
+		c.saved = nil
+		c.observers = observers{}
 	}
-	// then the squares
-	c.squares = make([]*square, c.mapping.scount+1) // 1-based indexing
+	c.mapping = p.mapping         // mappings are invariant and always shared
+	c.errors = p.allErrors(false) // errors are per-puzzle, copied from source
 	for i := 1; i <= c.mapping.scount; i++ {
-		c.squares[i] = &square{
+		*c.squares[i] = square{
 			index:  p.squares[i].index,
 			aval:   p.squares[i].aval,
-			pvals:  newIntsetCopy(p.squares[i].pvals),
+			pvals:  p.squares[i].pvals,
 			bval:   p.squares[i].bval,
-			bsrc:   append([]GroupID(nil), p.squares[i].bsrc...),
+			bsrc:   p.squares[i].bsrc[:len(p.squares[i].bsrc):len(p.squares[i].bsrc)], // only appended to, so shared
+			marks:  newIntsetCopy(p.squares[i].marks),
 			logger: c.logger,
 		}
 	}
-	// then the groups
-	c.groups = make([]*group, c.mapping.gcount+1) // 1-based indexing
+	// then the groups' where and free lists.  Each group's lists
+	// share a region big enough for all its squares to be free,
+	// which its where list's capacity spans, so that the region
+	// can be reused; the free list's capacity is clipped, and
+	// neither list is ever appended to.  The regions of a new
+	// copy are allocated together.
+	var ints []int
 	for i := 1; i <= c.mapping.gcount; i++ {
-		c.groups[i] = &group{
-			desc:  p.groups[i].desc, // descriptors are part of mappings, so shared
-			where: append([]int(nil), p.groups[i].where...),
-			need:  newIntsetCopy(p.groups[i].need),
-			free:  newIntsetCopy(p.groups[i].free),
+		pg, g := p.groups[i], c.groups[i]
+		nw, nf, size := len(pg.where), len(pg.free), len(pg.where)+len(pg.desc.indices)
+		region := g.where[:cap(g.where)]
+		if len(region) < nw+nf {
+			if len(ints) < size {
+				ints = make([]int, c.mapping.gcount*size)
+			}
+			region, ints = ints[:size:size], ints[size:]
+		}
+		g.desc = pg.desc // descriptors are part of mappings, so shared
+		g.where = region[:nw]
+		g.free = nil
+		if pg.free != nil {
+			g.free = intset(region[nw : nw+nf : nw+nf])
 		}
+		g.need = pg.need
+		copy(g.where, pg.where)
+		copy(g.free, pg.free)
 	}
 	return c
 }
@@ -226,9 +313,24 @@ func (p *puzzle) State() State {
 		p.mapping.sidelen,
 		p.allValues(),
 		p.allErrors(true),
+		p.isDone(),
 	}
 }
 
+// isDone tells whether every square has been assigned without
+// making the puzzle unsolvable.
+func (p *puzzle) isDone() bool {
+	if len(p.errors) > 0 {
+		return false
+	}
+	for i := 1; i <= p.mapping.scount; i++ {
+		if p.squares[i].aval == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Squares returns a Square for each of squares in a puzzle (in
 // index order).  The return value does not share underlying
 // storage with the puzzle, so future changes to the puzzle do
@@ -243,6 +345,16 @@ func (p *puzzle) Squares() []Square {
 // out of range, the puzzle isn't updated and an Error is
 // returned.
 func (p *puzzle) Assign(choice Choice) (Update, error) {
+	var update Update
+	e := p.watch(p, func() (e error) {
+		update, e = p.assignChoice(choice)
+		return e
+	})
+	return update, e
+}
+
+// assignChoice does the work of Assign.
+func (p *puzzle) assignChoice(choice Choice) (Update, error) {
 	if count := len(p.errors); count != 0 {
 		err := Error{
 			Scope:     ArgumentScope,
@@ -273,7 +385,7 @@ func (p *puzzle) Assign(choice Choice) (Update, error) {
 
 	// assigning this value to this square is allowed, so try it
 	is := p.assign(idx, val)
-	return Update{p.indicesToSquares(is), p.allErrors(true)}, nil
+	return Update{p.indicesToSquares(is), p.allErrors(true), p.isDone(), p.Conflicts(), nil}, nil
 }
 
 // Copy returns a copy of the wrapped puzzle (no shared structure)
@@ -281,6 +393,52 @@ func (p *puzzle) Copy() Puzzle {
 	return p.copy()
 }
 
+// MarkCandidate pencils the chosen value into the chosen square,
+// returning an Update containing the square.  Marks are notes,
+// so any value in range can be marked in any empty square, even
+// if the puzzle has errors.  Marking an assigned square, or
+// using an out-of-range index or value, returns an Error.
+func (p *puzzle) MarkCandidate(choice Choice) (Update, error) {
+	return p.markCandidate(choice, true)
+}
+
+// UnmarkCandidate erases the chosen value from the chosen
+// square's marks, returning an Update containing the square.
+// The same errors are returned as for MarkCandidate.
+func (p *puzzle) UnmarkCandidate(choice Choice) (Update, error) {
+	return p.markCandidate(choice, false)
+}
+
+// markCandidate is the helper that does the work for
+// MarkCandidate and UnmarkCandidate.
+func (p *puzzle) markCandidate(choice Choice, mark bool) (Update, error) {
+	idx, val := choice.Index, choice.Value
+	if idx < 1 || idx > p.mapping.scount {
+		return Update{}, rangeError(IndexAttribute, idx, 1, p.mapping.scount)
+	}
+	if val < 1 || val > p.mapping.sidelen {
+		return Update{}, rangeError(ValueAttribute, val, 1, p.mapping.sidelen)
+	}
+	s := p.squares[idx]
+	if s.aval != 0 {
+		err := Error{
+			Scope:     ArgumentScope,
+			Structure: AttributeValueStructure,
+			Attribute: AssignedValueAttribute,
+			Condition: DuplicateAssignmentCondition,
+			Values:    ErrorData{val, idx, s.aval},
+		}
+		err.Message = err.Error()
+		return Update{}, err
+	}
+	if mark {
+		s.marks.insert(val)
+	} else {
+		s.marks.remove(val)
+	}
+	return Update{Squares: p.indicesToSquares(intset{idx})}, nil
+}
+
 /*
 
 Puzzle construction
@@ -335,8 +493,17 @@ func create(mapping *puzzleMapping, values []int) (*puzzle, error) {
 		}
 	}
 
+	// Relax the cages of killer puzzles, which removes the
+	// values their sums rule out.
+	for n := 1; n <= len(mapping.cages); n++ {
+		errs = relaxCage(mapping, n, squares)
+		if len(errs) > 0 {
+			errors = append(errors, errs...)
+		}
+	}
+
 	// assemble the puzzle from its pieces
-	return &puzzle{mapping, squares, groups, errors, logger}, nil
+	return &puzzle{mapping, squares, groups, errors, logger, nil, observers{}}, nil
 }
 
 /*
@@ -364,7 +531,7 @@ Groups
 type group struct {
 	desc  *groupDescriptor
 	where []int  // array map: where[v] = index of square with assigned value v
-	need  intset // values the group still needs assigned or bound
+	need  valset // values the group still needs assigned or bound
 	free  intset // indexes of squares not yet assigned or bound
 }
 
@@ -375,7 +542,7 @@ func newGroup(gd *groupDescriptor, ss []*square) (*group, []Error) {
 	// initialize the group members
 	sidelen := len(gd.indices)
 	where := make([]int, sidelen+1) // 1-based values
-	need := newIntsetRange(sidelen)
+	need := newValsetRange(sidelen)
 	free := append(intset(nil), gd.indices...)
 
 	// work in two passes:
@@ -426,42 +593,42 @@ func newGroup(gd *groupDescriptor, ss []*square) (*group, []Error) {
 // the overlapping groups need to be constructed/assigned before
 // all of them can be analyzed together.
 func (g *group) analyze(ss []*square) []Error {
-	counts := make([]int, len(g.desc.indices)+1) // candidate counts for each needed value
-	lasts := make([]int, len(g.desc.indices)+1)  // last candidates for each needed value
-
-	// First walk the list of free squares, collecting which ones
-	// are candidates for which values.  We walk the list back to
-	// front, so we can remove bound values without screwing up
-	// the iteration.
+	// First walk the list of free squares, collecting which
+	// values have candidates, and which have more than one.  We
+	// walk the list back to front, so we can remove bound values
+	// without screwing up the iteration.
+	var once, twice valset
 	for fi := len(g.free) - 1; fi >= 0; fi-- {
 		i := g.free[fi]
-		if len(ss[i].pvals) == 1 {
+		if pvals := ss[i].pvals; pvals.len() == 1 {
 			// this square can only have one value, so it must be
 			// used as the candidate for that value.
 			g.free.remove(i)
-			g.need.remove(ss[i].pvals[0])
+			g.need.remove(pvals.first())
 		} else {
-			// remember this square as a candidate for each of
-			// its possible values
-			for _, v := range ss[i].pvals {
-				counts[v]++
-				lasts[v] = i
-			}
+			once.tally(pvals, &twice)
 		}
 	}
-	// Now walk the list of candidates for each needed value,
-	// raising an Error if there aren't any, and binding them if
-	// they are the only ones.  We walk the list back to front,
-	// so we can remove bound values without screwing up the
-	// iteration.
+	// Now walk the needed values that don't have more than one
+	// candidate, raising an Error if there aren't any candidates,
+	// and binding them if there is only one.  We walk the values
+	// from high to low, as the Errors have always been reported
+	// in that order.
 	var errs []Error
-	for i := len(g.need) - 1; i >= 0; i-- {
-		switch v := g.need[i]; counts[v] {
-		case 0:
+	for need := g.need.minus(twice); !need.empty(); {
+		v := need.last()
+		need.remove(v)
+		switch {
+		case !once.has(v):
 			errs = append(errs, groupError(g.desc.id, v, NoGroupValueCondition))
-		case 1:
-			errs = append(errs, ss[lasts[v]].bind(v, g.desc.id)...)
-			g.free.remove(lasts[v])
+		case !twice.has(v):
+			for _, i := range g.free {
+				if ss[i].pvals.has(v) {
+					errs = append(errs, ss[i].bind(v, g.desc.id)...)
+					g.free.remove(i)
+					break
+				}
+			}
 			g.need.remove(v)
 		}
 	}
@@ -517,20 +684,27 @@ Squares
 // other possible values), the indexes of the group are also
 // recorded for explanation to users.
 //
+// Squares also keep the candidate values the user has pencilled
+// in (their marks).  Marks are notes, not constraints: they can
+// contain values that aren't possible, and they are kept (but
+// not reported) when the square is assigned, so that undoing the
+// assignment brings them back.
+//
 // Squares also have a logger, where they log modifications.
 type square struct {
 	index  int
 	aval   int
-	pvals  intset
+	pvals  valset
 	bval   int
 	bsrc   []GroupID
+	marks  intset
 	logger *indexLogger
 }
 
 // Make an empty square with the given index in a puzzle with the
 // given side length.  Doesn't do error checking.
 func newEmptySquare(index, sidelen int, logger *indexLogger) *square {
-	return &square{index: index, pvals: newIntsetRange(sidelen), logger: logger}
+	return &square{index: index, pvals: newValsetRange(sidelen), logger: logger}
 }
 
 // Make a square with the given index in a puzzle with the given
@@ -549,12 +723,11 @@ func (s *square) assign(aval int) (errs []Error) {
 			errs = append(errs, groupError(s.bsrc[i], s.bval, NoGroupValueCondition))
 		}
 	}
-	_, found := s.pvals.find(aval)
-	if !found {
+	if !s.pvals.has(aval) {
 		errs = append(errs, squareError(s, aval, AssignedValueAttribute, NotInSetCondition))
 	}
 	s.aval = aval
-	s.pvals = nil
+	s.pvals = valset{}
 	s.logger.log(s.index)
 	return
 }
@@ -569,8 +742,7 @@ func (s *square) bind(bval int, bsrc GroupID) (errs []Error) {
 			errs = append(errs, groupError(s.bsrc[i], s.bval, NoGroupValueCondition))
 		}
 	}
-	_, found := s.pvals.find(bval)
-	if !found {
+	if !s.pvals.has(bval) {
 		errs = append(errs, squareError(s, bval, BoundValueAttribute, NotInSetCondition))
 	}
 	s.bval = bval
@@ -590,7 +762,7 @@ func (s *square) remove(val int) (errs []Error) {
 	}
 	removed := s.pvals.remove(val)
 	if removed {
-		if len(s.pvals) == 0 {
+		if s.pvals.empty() {
 			errs = append(errs,
 				squareError(s, s.bval, RemovedValueAttribute, NoPossibleValuesCondition))
 		}
@@ -602,20 +774,20 @@ func (s *square) remove(val int) (errs []Error) {
 // Subtract possible values from a square.  Returns any Errors
 // generated by the removal.  Doesn't guard against the square
 // being assigned, or being left with no possible values.
-func (s *square) subtract(vals intset) []Error {
+func (s *square) subtract(vals valset) []Error {
 	return s.removeMultiple(vals, false)
 }
 
 // Intersect possible values on a square.  Returns any Errors
 // generated by the intersection.  Doesn't guard against the
 // square being assigned, or being left with no possible values.
-func (s *square) intersect(vals intset) []Error {
+func (s *square) intersect(vals valset) []Error {
 	return s.removeMultiple(vals, true)
 }
 
 // Validate and apply the result of a set operation on a square.
 // This is a helper that does the work of subract and intersect.
-func (s *square) removeMultiple(vals intset, keepVals bool) (errs []Error) {
+func (s *square) removeMultiple(vals valset, keepVals bool) (errs []Error) {
 	var remsome, rembound bool
 	var attr ErrorAttribute
 	if keepVals {
@@ -630,8 +802,8 @@ func (s *square) removeMultiple(vals intset, keepVals bool) (errs []Error) {
 			errs = append(errs, groupError(s.bsrc[i], s.bval, NoGroupValueCondition))
 		}
 	}
-	if len(s.pvals) == 0 {
-		errs = append(errs, squareError(s, vals, attr, NoPossibleValuesCondition))
+	if s.pvals.empty() {
+		errs = append(errs, squareError(s, vals.intset(), attr, NoPossibleValuesCondition))
 	}
 	if remsome {
 		s.logger.log(s.index)
@@ -655,7 +827,7 @@ type indexLogger struct {
 func (l *indexLogger) start(idx int) {
 	if l != nil {
 		l.logging = true
-		l.entries = intset{idx}
+		l.entries = append(l.entries[:0], idx)
 	}
 }
 
@@ -841,6 +1013,176 @@ func (ps *intset) intersect(xs intset, marker int) (bool, bool) {
 
 /*
 
+Value sets
+
+*/
+
+// A valset is a set of square values, represented as a bitmask
+// with bit v-1 set for each value v in the set.  We use valsets
+// for sets of possible values, which are updated and copied far
+// more often than anything else in a puzzle, so they have to be
+// fast and small: a valset is a fixed array of words, so it's
+// copied by value and never allocated, and its operations work a
+// word at a time rather than a value at a time.  There are enough
+// words for the values of the largest puzzles.
+type valset [valsetWords]uint64
+
+// valsetWords is the number of words in a valset.  The operations
+// used in the inner loops of propagation are written out for each
+// word, so they have to change along with it.
+const valsetWords = 4
+
+// maxValsetValue is the largest value that fits in a valset.
+const maxValsetValue = 64 * valsetWords
+
+// newValsetRange: Make a valset from a range of values, 1 to max.
+func newValsetRange(max int) valset {
+	var out valset
+	for w := range out {
+		switch n := max - 64*w; {
+		case n >= 64:
+			out[w] = ^uint64(0)
+		case n > 0:
+			out[w] = uint64(1)<<uint(n) - 1
+		}
+	}
+	return out
+}
+
+// newValset: Make a valset containing the given values.
+func newValset(vs ...int) valset {
+	var out valset
+	for _, v := range vs {
+		out.insert(v)
+	}
+	return out
+}
+
+// empty tells whether the valset has no values.
+func (vs valset) empty() bool {
+	return vs[0]|vs[1]|vs[2]|vs[3] == 0
+}
+
+// has tells whether value v is in the valset.
+func (vs valset) has(v int) bool {
+	u := uint(v - 1)
+	return u < maxValsetValue && vs[u>>6&3]&(1<<(u&63)) != 0
+}
+
+// len returns the number of values in the valset.
+func (vs valset) len() int {
+	return bits.OnesCount64(vs[0]) + bits.OnesCount64(vs[1]) +
+		bits.OnesCount64(vs[2]) + bits.OnesCount64(vs[3])
+}
+
+// first returns the smallest value in the valset, or 0 if it's
+// empty.
+func (vs valset) first() int {
+	for i, w := range vs {
+		if w != 0 {
+			return 64*i + bits.TrailingZeros64(w) + 1
+		}
+	}
+	return 0
+}
+
+// last returns the largest value in the valset, or 0 if it's
+// empty.
+func (vs valset) last() int {
+	for i := len(vs) - 1; i >= 0; i-- {
+		if vs[i] != 0 {
+			return 64*i + bits.Len64(vs[i])
+		}
+	}
+	return 0
+}
+
+// and returns the values in both valsets.
+func (vs valset) and(xs valset) valset {
+	return valset{vs[0] & xs[0], vs[1] & xs[1], vs[2] & xs[2], vs[3] & xs[3]}
+}
+
+// minus returns the values in the valset that aren't in xs.
+func (vs valset) minus(xs valset) valset {
+	return valset{vs[0] &^ xs[0], vs[1] &^ xs[1], vs[2] &^ xs[2], vs[3] &^ xs[3]}
+}
+
+// merge adds the values of xs to the valset.
+func (vs *valset) merge(xs valset) {
+	vs[0] |= xs[0]
+	vs[1] |= xs[1]
+	vs[2] |= xs[2]
+	vs[3] |= xs[3]
+}
+
+// tally adds the values of xs to the valset, and adds the ones
+// that were already there to twice, so a valset that tallies a
+// sequence of others collects the values found in at least one
+// of them, and twice those found in at least two.
+func (vs *valset) tally(xs valset, twice *valset) {
+	twice[0] |= vs[0] & xs[0]
+	twice[1] |= vs[1] & xs[1]
+	twice[2] |= vs[2] & xs[2]
+	twice[3] |= vs[3] & xs[3]
+	vs[0] |= xs[0]
+	vs[1] |= xs[1]
+	vs[2] |= xs[2]
+	vs[3] |= xs[3]
+}
+
+// Insert value v, returning whether it was there already.
+func (vs *valset) insert(v int) bool {
+	found := vs.has(v)
+	u := uint(v - 1)
+	vs[u>>6&3] |= 1 << (u & 63)
+	return found
+}
+
+// Remove value v, returning whether it was there.
+func (vs *valset) remove(v int) bool {
+	found := vs.has(v)
+	if found {
+		u := uint(v - 1)
+		vs[u>>6&3] &^= 1 << (u & 63)
+	}
+	return found
+}
+
+// Subtract the passed valset, returning whether anything was
+// removed.  Also takes a marker value and returns whether it was
+// removed.
+func (vs *valset) subtract(xs valset, marker int) (bool, bool) {
+	old := *vs
+	*vs = vs.minus(xs)
+	return *vs != old, old.has(marker) && !vs.has(marker)
+}
+
+// Intersect the passed valset, returning whether anything was
+// removed.  Also takes a marker value and returns whether it was
+// removed.
+func (vs *valset) intersect(xs valset, marker int) (bool, bool) {
+	old := *vs
+	*vs = vs.and(xs)
+	return *vs != old, old.has(marker) && !vs.has(marker)
+}
+
+// intset returns the values in the valset as an intset, which is
+// nil if the valset is empty.
+func (vs valset) intset() intset {
+	if vs.empty() {
+		return nil
+	}
+	out := make(intset, 0, vs.len())
+	for i, w := range vs {
+		for ; w != 0; w &= w - 1 {
+			out = append(out, 64*i+bits.TrailingZeros64(w)+1)
+		}
+	}
+	return out
+}
+
+/*
+
 Errors: used to report problems making and operating on puzzles.
 
 */
@@ -874,7 +1216,7 @@ func squareError(s *square, v interface{}, attr ErrorAttribute, cond ErrorCondit
 	}
 	switch cond {
 	case NotInSetCondition:
-		err.Values = append(err.Values, s.pvals)
+		err.Values = append(err.Values, s.pvals.intset())
 	case NoPossibleValuesCondition:
 	default:
 		panic(fmt.Errorf("Unexpected square error condition (%v) in square %+v", cond, *s))
@@ -892,6 +1234,7 @@ func groupError(gid GroupID, v int, cond ErrorCondition) Error {
 	switch cond {
 	case NoGroupValueCondition:
 	case DuplicateGroupValuesCondition:
+	case CageSumCondition:
 	default:
 		panic(fmt.Errorf("Unexpected group error condition (%v) in group %v", cond, gid))
 	}
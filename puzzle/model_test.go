@@ -24,9 +24,10 @@ func helperDupSquare(sq *square) *square {
 	return &square{
 		sq.index,
 		sq.aval,
-		newIntsetCopy(sq.pvals),
+		sq.pvals,
 		sq.bval,
 		append([]GroupID(nil), sq.bsrc...),
+		newIntsetCopy(sq.marks),
 		sq.logger,
 	}
 }
@@ -34,7 +35,7 @@ func helperDupSquare(sq *square) *square {
 // depends on newEmptySquare and (*square).subtract, test those first
 func helperRestrictedSquare(index, sidelen int, excepts ...int) *square {
 	sp := newEmptySquare(index, sidelen, nil)
-	errs := sp.subtract(excepts)
+	errs := sp.subtract(newValset(excepts...))
 	if len(errs) > 0 {
 		panic(errs[0])
 	}
@@ -320,59 +321,59 @@ var (
 	rotation4Puzzle1PartialSquares = []*square{
 		nil,
 		&square{index: 1, aval: 1},
-		&square{index: 2, pvals: intset{2, 4}},
+		&square{index: 2, pvals: newValset(2, 4)},
 		&square{index: 3, aval: 3},
-		&square{index: 4, pvals: intset{2, 4}},
-		&square{index: 5, pvals: intset{2, 4}},
+		&square{index: 4, pvals: newValset(2, 4)},
+		&square{index: 5, pvals: newValset(2, 4)},
 		&square{index: 6, aval: 3},
-		&square{index: 7, pvals: intset{2, 4}},
+		&square{index: 7, pvals: newValset(2, 4)},
 		&square{index: 8, aval: 1},
 		&square{index: 9, aval: 3},
-		&square{index: 10, pvals: intset{2, 4}},
+		&square{index: 10, pvals: newValset(2, 4)},
 		&square{index: 11, aval: 1},
-		&square{index: 12, pvals: intset{2, 4}},
-		&square{index: 13, pvals: intset{2, 4}},
+		&square{index: 12, pvals: newValset(2, 4)},
+		&square{index: 13, pvals: newValset(2, 4)},
 		&square{index: 14, aval: 1},
-		&square{index: 15, pvals: intset{2, 4}},
+		&square{index: 15, pvals: newValset(2, 4)},
 		&square{index: 16, aval: 3},
 	}
 	rotation4Puzzle1PartialGroups = []*group{
 		nil,
 		&group{ // row 1
-			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, intset{2, 4}, intset{2, 4},
+			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, newValset(2, 4), intset{2, 4},
 		},
 		&group{ // row 2
-			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, intset{2, 4}, intset{5, 7},
+			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, newValset(2, 4), intset{5, 7},
 		},
 		&group{ // row 3
-			&square4Map.gdescs[3], []int{0, 11, 0, 9, 0}, intset{2, 4}, intset{10, 12},
+			&square4Map.gdescs[3], []int{0, 11, 0, 9, 0}, newValset(2, 4), intset{10, 12},
 		},
 		&group{ // row 4
-			&square4Map.gdescs[4], []int{0, 14, 0, 16, 0}, intset{2, 4}, intset{13, 15},
+			&square4Map.gdescs[4], []int{0, 14, 0, 16, 0}, newValset(2, 4), intset{13, 15},
 		},
 		&group{ // column 1
-			&square4Map.gdescs[5], []int{0, 1, 0, 9, 0}, intset{2, 4}, intset{5, 13},
+			&square4Map.gdescs[5], []int{0, 1, 0, 9, 0}, newValset(2, 4), intset{5, 13},
 		},
 		&group{ // column 2
-			&square4Map.gdescs[6], []int{0, 14, 0, 6, 0}, intset{2, 4}, intset{2, 10},
+			&square4Map.gdescs[6], []int{0, 14, 0, 6, 0}, newValset(2, 4), intset{2, 10},
 		},
 		&group{ // column 3
-			&square4Map.gdescs[7], []int{0, 11, 0, 3, 0}, intset{2, 4}, intset{7, 15},
+			&square4Map.gdescs[7], []int{0, 11, 0, 3, 0}, newValset(2, 4), intset{7, 15},
 		},
 		&group{ // column 4
-			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, intset{2, 4}, intset{4, 12},
+			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, newValset(2, 4), intset{4, 12},
 		},
 		&group{ // tile 1
-			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, intset{2, 4}, intset{2, 5},
+			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, newValset(2, 4), intset{2, 5},
 		},
 		&group{ // tile 2
-			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, intset{2, 4}, intset{4, 7},
+			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, newValset(2, 4), intset{4, 7},
 		},
 		&group{ // tile 3
-			&square4Map.gdescs[11], []int{0, 14, 0, 9, 0}, intset{2, 4}, intset{10, 13},
+			&square4Map.gdescs[11], []int{0, 14, 0, 9, 0}, newValset(2, 4), intset{10, 13},
 		},
 		&group{ // tile 4
-			&square4Map.gdescs[12], []int{0, 11, 0, 16, 0}, intset{2, 4}, intset{12, 15},
+			&square4Map.gdescs[12], []int{0, 11, 0, 16, 0}, newValset(2, 4), intset{12, 15},
 		},
 	}
 	rotation4Puzzle1PartialAssign1Values = []int{ // assign(13, 2)
@@ -390,59 +391,59 @@ var (
 	rotation4Puzzle1PartialAssign1Squares = []*square{
 		nil,
 		&square{index: 1, aval: 1},
-		&square{index: 2, pvals: intset{2, 4}, bval: 2, bsrc: helperBsrc(4+2, 8+1)},
+		&square{index: 2, pvals: newValset(2, 4), bval: 2, bsrc: helperBsrc(4+2, 8+1)},
 		&square{index: 3, aval: 3},
-		&square{index: 4, pvals: intset{2, 4}},
-		&square{index: 5, pvals: intset{4}},
+		&square{index: 4, pvals: newValset(2, 4)},
+		&square{index: 5, pvals: newValset(4)},
 		&square{index: 6, aval: 3},
-		&square{index: 7, pvals: intset{2, 4}, bval: 2, bsrc: helperBsrc(0+2, 4+3)},
+		&square{index: 7, pvals: newValset(2, 4), bval: 2, bsrc: helperBsrc(0+2, 4+3)},
 		&square{index: 8, aval: 1},
 		&square{index: 9, aval: 3},
-		&square{index: 10, pvals: intset{4}},
+		&square{index: 10, pvals: newValset(4)},
 		&square{index: 11, aval: 1},
-		&square{index: 12, pvals: intset{2, 4}, bval: 2, bsrc: helperBsrc(0+3, 8+4)},
+		&square{index: 12, pvals: newValset(2, 4), bval: 2, bsrc: helperBsrc(0+3, 8+4)},
 		&square{index: 13, aval: 2},
 		&square{index: 14, aval: 1},
-		&square{index: 15, pvals: intset{4}},
+		&square{index: 15, pvals: newValset(4)},
 		&square{index: 16, aval: 3},
 	}
 	rotation4Puzzle1PartialAssign1Groups = []*group{
 		nil,
 		&group{ // row 1
-			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, intset{2, 4}, intset{2, 4},
+			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, newValset(2, 4), intset{2, 4},
 		},
 		&group{ // row 2
-			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // row 3
-			&square4Map.gdescs[3], []int{0, 11, 0, 9, 0}, intset{}, intset{},
+			&square4Map.gdescs[3], []int{0, 11, 0, 9, 0}, newValset(), intset{},
 		},
 		&group{ // row 4
-			&square4Map.gdescs[4], []int{0, 14, 13, 16, 0}, intset{}, intset{},
+			&square4Map.gdescs[4], []int{0, 14, 13, 16, 0}, newValset(), intset{},
 		},
 		&group{ // column 1
-			&square4Map.gdescs[5], []int{0, 1, 13, 9, 0}, intset{}, intset{},
+			&square4Map.gdescs[5], []int{0, 1, 13, 9, 0}, newValset(), intset{},
 		},
 		&group{ // column 2
-			&square4Map.gdescs[6], []int{0, 14, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[6], []int{0, 14, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // column 3
-			&square4Map.gdescs[7], []int{0, 11, 0, 3, 0}, intset{}, intset{},
+			&square4Map.gdescs[7], []int{0, 11, 0, 3, 0}, newValset(), intset{},
 		},
 		&group{ // column 4
-			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, intset{2, 4}, intset{4, 12},
+			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, newValset(2, 4), intset{4, 12},
 		},
 		&group{ // tile 1
-			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // tile 2
-			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, intset{2, 4}, intset{4, 7},
+			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, newValset(2, 4), intset{4, 7},
 		},
 		&group{ // tile 3
-			&square4Map.gdescs[11], []int{0, 14, 13, 9, 0}, intset{}, intset{},
+			&square4Map.gdescs[11], []int{0, 14, 13, 9, 0}, newValset(), intset{},
 		},
 		&group{ // tile 4
-			&square4Map.gdescs[12], []int{0, 11, 0, 16, 0}, intset{}, intset{},
+			&square4Map.gdescs[12], []int{0, 11, 0, 16, 0}, newValset(), intset{},
 		},
 	}
 	rotation4Puzzle1PartialAssign1CapitalSquares = []Square{
@@ -481,59 +482,59 @@ var (
 	rotation4Puzzle1PartialAssign2Squares = []*square{
 		nil,
 		&square{index: 1, aval: 1},
-		&square{index: 2, pvals: intset{2}, bval: 2, bsrc: helperBsrc(4+2, 8+1)},
+		&square{index: 2, pvals: newValset(2), bval: 2, bsrc: helperBsrc(4+2, 8+1)},
 		&square{index: 3, aval: 3},
-		&square{index: 4, pvals: intset{2, 4}, bval: 4, bsrc: helperBsrc(0+1, 4+4)},
-		&square{index: 5, pvals: intset{4}},
+		&square{index: 4, pvals: newValset(2, 4), bval: 4, bsrc: helperBsrc(0+1, 4+4)},
+		&square{index: 5, pvals: newValset(4)},
 		&square{index: 6, aval: 3},
-		&square{index: 7, pvals: intset{2, 4}, bval: 2, bsrc: helperBsrc(0+2, 4+3)},
+		&square{index: 7, pvals: newValset(2, 4), bval: 2, bsrc: helperBsrc(0+2, 4+3)},
 		&square{index: 8, aval: 1},
 		&square{index: 9, aval: 3},
 		&square{index: 10, aval: 4},
 		&square{index: 11, aval: 1},
-		&square{index: 12, pvals: intset{2}, bval: 2, bsrc: helperBsrc(0+3, 8+4)},
+		&square{index: 12, pvals: newValset(2), bval: 2, bsrc: helperBsrc(0+3, 8+4)},
 		&square{index: 13, aval: 2},
 		&square{index: 14, aval: 1},
-		&square{index: 15, pvals: intset{4}},
+		&square{index: 15, pvals: newValset(4)},
 		&square{index: 16, aval: 3},
 	}
 	rotation4Puzzle1PartialAssign2Groups = []*group{
 		nil,
 		&group{ // row 1
-			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, intset{}, intset{},
+			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, newValset(), intset{},
 		},
 		&group{ // row 2
-			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // row 3
-			&square4Map.gdescs[3], []int{0, 11, 0, 9, 10}, intset{}, intset{},
+			&square4Map.gdescs[3], []int{0, 11, 0, 9, 10}, newValset(), intset{},
 		},
 		&group{ // row 4
-			&square4Map.gdescs[4], []int{0, 14, 13, 16, 0}, intset{}, intset{},
+			&square4Map.gdescs[4], []int{0, 14, 13, 16, 0}, newValset(), intset{},
 		},
 		&group{ // column 1
-			&square4Map.gdescs[5], []int{0, 1, 13, 9, 0}, intset{}, intset{},
+			&square4Map.gdescs[5], []int{0, 1, 13, 9, 0}, newValset(), intset{},
 		},
 		&group{ // column 2
-			&square4Map.gdescs[6], []int{0, 14, 0, 6, 10}, intset{}, intset{},
+			&square4Map.gdescs[6], []int{0, 14, 0, 6, 10}, newValset(), intset{},
 		},
 		&group{ // column 3
-			&square4Map.gdescs[7], []int{0, 11, 0, 3, 0}, intset{}, intset{},
+			&square4Map.gdescs[7], []int{0, 11, 0, 3, 0}, newValset(), intset{},
 		},
 		&group{ // column 4
-			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, intset{}, intset{},
+			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, newValset(), intset{},
 		},
 		&group{ // tile 1
-			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // tile 2
-			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, intset{2, 4}, intset{4, 7},
+			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, newValset(2, 4), intset{4, 7},
 		},
 		&group{ // tile 3
-			&square4Map.gdescs[11], []int{0, 14, 13, 9, 10}, intset{}, intset{},
+			&square4Map.gdescs[11], []int{0, 14, 13, 9, 10}, newValset(), intset{},
 		},
 		&group{ // tile 4
-			&square4Map.gdescs[12], []int{0, 11, 0, 16, 0}, intset{}, intset{},
+			&square4Map.gdescs[12], []int{0, 11, 0, 16, 0}, newValset(), intset{},
 		},
 	}
 	rotation4Puzzle1PartialAssign2CapitalSquares = []Square{
@@ -573,17 +574,17 @@ var (
 	rotation4Puzzle1PartialAssign3Squares = []*square{
 		nil,
 		&square{index: 1, aval: 1},
-		&square{index: 2, pvals: intset{2}, bval: 2, bsrc: helperBsrc(4+2, 8+1)},
+		&square{index: 2, pvals: newValset(2), bval: 2, bsrc: helperBsrc(4+2, 8+1)},
 		&square{index: 3, aval: 3},
-		&square{index: 4, pvals: intset{2, 4}, bval: 4, bsrc: helperBsrc(0+1, 4+4, 8+2)},
-		&square{index: 5, pvals: intset{4}},
+		&square{index: 4, pvals: newValset(2, 4), bval: 4, bsrc: helperBsrc(0+1, 4+4, 8+2)},
+		&square{index: 5, pvals: newValset(4)},
 		&square{index: 6, aval: 3},
-		&square{index: 7, pvals: intset{2}, bval: 2, bsrc: helperBsrc(0+2, 4+3)},
+		&square{index: 7, pvals: newValset(2), bval: 2, bsrc: helperBsrc(0+2, 4+3)},
 		&square{index: 8, aval: 1},
 		&square{index: 9, aval: 3},
 		&square{index: 10, aval: 4},
 		&square{index: 11, aval: 1},
-		&square{index: 12, pvals: intset{2}, bval: 2, bsrc: helperBsrc(0+3, 8+4)},
+		&square{index: 12, pvals: newValset(2), bval: 2, bsrc: helperBsrc(0+3, 8+4)},
 		&square{index: 13, aval: 2},
 		&square{index: 14, aval: 1},
 		&square{index: 15, aval: 4},
@@ -592,40 +593,40 @@ var (
 	rotation4Puzzle1PartialAssign3Groups = []*group{
 		nil,
 		&group{ // row 1
-			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, intset{}, intset{},
+			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, newValset(), intset{},
 		},
 		&group{ // row 2
-			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[2], []int{0, 8, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // row 3
-			&square4Map.gdescs[3], []int{0, 11, 0, 9, 10}, intset{}, intset{},
+			&square4Map.gdescs[3], []int{0, 11, 0, 9, 10}, newValset(), intset{},
 		},
 		&group{ // row 4
-			&square4Map.gdescs[4], []int{0, 14, 13, 16, 15}, intset{}, intset{},
+			&square4Map.gdescs[4], []int{0, 14, 13, 16, 15}, newValset(), intset{},
 		},
 		&group{ // column 1
-			&square4Map.gdescs[5], []int{0, 1, 13, 9, 0}, intset{}, intset{},
+			&square4Map.gdescs[5], []int{0, 1, 13, 9, 0}, newValset(), intset{},
 		},
 		&group{ // column 2
-			&square4Map.gdescs[6], []int{0, 14, 0, 6, 10}, intset{}, intset{},
+			&square4Map.gdescs[6], []int{0, 14, 0, 6, 10}, newValset(), intset{},
 		},
 		&group{ // column 3
-			&square4Map.gdescs[7], []int{0, 11, 0, 3, 15}, intset{}, intset{},
+			&square4Map.gdescs[7], []int{0, 11, 0, 3, 15}, newValset(), intset{},
 		},
 		&group{ // column 4
-			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, intset{}, intset{},
+			&square4Map.gdescs[8], []int{0, 8, 0, 16, 0}, newValset(), intset{},
 		},
 		&group{ // tile 1
-			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, intset{}, intset{},
+			&square4Map.gdescs[9], []int{0, 1, 0, 6, 0}, newValset(), intset{},
 		},
 		&group{ // tile 2
-			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, intset{}, intset{},
+			&square4Map.gdescs[10], []int{0, 8, 0, 3, 0}, newValset(), intset{},
 		},
 		&group{ // tile 3
-			&square4Map.gdescs[11], []int{0, 14, 13, 9, 10}, intset{}, intset{},
+			&square4Map.gdescs[11], []int{0, 14, 13, 9, 10}, newValset(), intset{},
 		},
 		&group{ // tile 4
-			&square4Map.gdescs[12], []int{0, 11, 0, 16, 15}, intset{}, intset{},
+			&square4Map.gdescs[12], []int{0, 11, 0, 16, 15}, newValset(), intset{},
 		},
 	}
 	rotation4Puzzle1PartialAssign3CapitalSquares = []Square{
@@ -702,61 +703,61 @@ var (
 	rotation4Puzzle2PartialSquares = []*square{
 		nil,
 		&square{index: 1, aval: 1},
-		&square{index: 2, pvals: intset{2, 4}},
+		&square{index: 2, pvals: newValset(2, 4)},
 		&square{index: 3, aval: 3},
-		&square{index: 4, pvals: intset{2, 4}},
+		&square{index: 4, pvals: newValset(2, 4)},
 		&square{index: 5, aval: 3},
-		&square{index: 6, pvals: intset{2, 4}},
+		&square{index: 6, pvals: newValset(2, 4)},
 		&square{index: 7, aval: 1},
-		&square{index: 8, pvals: intset{2, 4}},
+		&square{index: 8, pvals: newValset(2, 4)},
 		&square{index: 9, aval: 2},
-		&square{index: 10, pvals: intset{1, 3}},
+		&square{index: 10, pvals: newValset(1, 3)},
 		&square{index: 11, aval: 4},
-		&square{index: 12, pvals: intset{1, 3}},
+		&square{index: 12, pvals: newValset(1, 3)},
 		&square{index: 13, aval: 4},
-		&square{index: 14, pvals: intset{1, 3}},
+		&square{index: 14, pvals: newValset(1, 3)},
 		&square{index: 15, aval: 2},
-		&square{index: 16, pvals: intset{1, 3}},
+		&square{index: 16, pvals: newValset(1, 3)},
 	}
 	rotation4Puzzle2PartialGroups = []*group{
 		nil,
 		&group{ // row 1
-			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, intset{2, 4}, intset{2, 4},
+			&square4Map.gdescs[1], []int{0, 1, 0, 3, 0}, newValset(2, 4), intset{2, 4},
 		},
 		&group{ // row 2
-			&square4Map.gdescs[2], []int{0, 7, 0, 5, 0}, intset{2, 4}, intset{6, 8},
+			&square4Map.gdescs[2], []int{0, 7, 0, 5, 0}, newValset(2, 4), intset{6, 8},
 		},
 		&group{ // row 3
-			&square4Map.gdescs[3], []int{0, 0, 9, 0, 11}, intset{1, 3}, intset{10, 12},
+			&square4Map.gdescs[3], []int{0, 0, 9, 0, 11}, newValset(1, 3), intset{10, 12},
 		},
 		&group{ // row 4
-			&square4Map.gdescs[4], []int{0, 0, 15, 0, 13}, intset{1, 3}, intset{14, 16},
+			&square4Map.gdescs[4], []int{0, 0, 15, 0, 13}, newValset(1, 3), intset{14, 16},
 		},
 		&group{ // column 1
-			&square4Map.gdescs[5], []int{0, 1, 9, 5, 13}, intset{}, intset{},
+			&square4Map.gdescs[5], []int{0, 1, 9, 5, 13}, newValset(), intset{},
 		},
 		&group{ // column 2
 			&square4Map.gdescs[6],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{2, 6, 10, 14},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{2, 6, 10, 14},
 		},
 		&group{ // column 3
-			&square4Map.gdescs[7], []int{0, 7, 15, 3, 11}, intset{}, intset{},
+			&square4Map.gdescs[7], []int{0, 7, 15, 3, 11}, newValset(), intset{},
 		},
 		&group{ // column 4
 			&square4Map.gdescs[8],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{4, 8, 12, 16},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{4, 8, 12, 16},
 		},
 		&group{ // tile 1
-			&square4Map.gdescs[9], []int{0, 1, 0, 5, 0}, intset{2, 4}, intset{2, 6},
+			&square4Map.gdescs[9], []int{0, 1, 0, 5, 0}, newValset(2, 4), intset{2, 6},
 		},
 		&group{ // tile 2
-			&square4Map.gdescs[10], []int{0, 7, 0, 3, 0}, intset{2, 4}, intset{4, 8},
+			&square4Map.gdescs[10], []int{0, 7, 0, 3, 0}, newValset(2, 4), intset{4, 8},
 		},
 		&group{ // tile 3
-			&square4Map.gdescs[11], []int{0, 0, 9, 0, 13}, intset{1, 3}, intset{10, 14},
+			&square4Map.gdescs[11], []int{0, 0, 9, 0, 13}, newValset(1, 3), intset{10, 14},
 		},
 		&group{ // tile 4
-			&square4Map.gdescs[12], []int{0, 0, 15, 0, 11}, intset{1, 3}, intset{12, 16},
+			&square4Map.gdescs[12], []int{0, 0, 15, 0, 11}, newValset(1, 3), intset{12, 16},
 		},
 	}
 	rotation4Puzzle2Complete1 = []int{
@@ -785,22 +786,22 @@ var (
 	}
 	empty4PuzzleSquares = []*square{
 		nil,
-		&square{index: 1, pvals: intset{1, 2, 3, 4}},
-		&square{index: 2, pvals: intset{1, 2, 3, 4}},
-		&square{index: 3, pvals: intset{1, 2, 3, 4}},
-		&square{index: 4, pvals: intset{1, 2, 3, 4}},
-		&square{index: 5, pvals: intset{1, 2, 3, 4}},
-		&square{index: 6, pvals: intset{1, 2, 3, 4}},
-		&square{index: 7, pvals: intset{1, 2, 3, 4}},
-		&square{index: 8, pvals: intset{1, 2, 3, 4}},
-		&square{index: 9, pvals: intset{1, 2, 3, 4}},
-		&square{index: 10, pvals: intset{1, 2, 3, 4}},
-		&square{index: 11, pvals: intset{1, 2, 3, 4}},
-		&square{index: 12, pvals: intset{1, 2, 3, 4}},
-		&square{index: 13, pvals: intset{1, 2, 3, 4}},
-		&square{index: 14, pvals: intset{1, 2, 3, 4}},
-		&square{index: 15, pvals: intset{1, 2, 3, 4}},
-		&square{index: 16, pvals: intset{1, 2, 3, 4}},
+		&square{index: 1, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 2, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 3, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 4, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 5, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 6, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 7, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 8, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 9, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 10, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 11, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 12, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 13, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 14, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 15, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 16, pvals: newValset(1, 2, 3, 4)},
 	}
 	empty4PuzzleCapitalSquares = []Square{
 		Square{Index: 1, Pvals: intset{1, 2, 3, 4}},
@@ -824,51 +825,51 @@ var (
 		nil,
 		&group{ // row 1
 			&square4Map.gdescs[1],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{1, 2, 3, 4},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{1, 2, 3, 4},
 		},
 		&group{ // row 2
 			&square4Map.gdescs[2],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{5, 6, 7, 8},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{5, 6, 7, 8},
 		},
 		&group{ // row 3
 			&square4Map.gdescs[3],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{9, 10, 11, 12},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{9, 10, 11, 12},
 		},
 		&group{ // row 4
 			&square4Map.gdescs[4],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{13, 14, 15, 16},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{13, 14, 15, 16},
 		},
 		&group{ // column 1
 			&square4Map.gdescs[5],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{1, 5, 9, 13},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{1, 5, 9, 13},
 		},
 		&group{ // column 2
 			&square4Map.gdescs[6],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{2, 6, 10, 14},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{2, 6, 10, 14},
 		},
 		&group{ // column 3
 			&square4Map.gdescs[7],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{3, 7, 11, 15},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{3, 7, 11, 15},
 		},
 		&group{ // column 4
 			&square4Map.gdescs[8],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{4, 8, 12, 16},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{4, 8, 12, 16},
 		},
 		&group{ // tile 1
 			&square4Map.gdescs[9],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{1, 2, 5, 6},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{1, 2, 5, 6},
 		},
 		&group{ // tile 2
 			&square4Map.gdescs[10],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{3, 4, 7, 8},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{3, 4, 7, 8},
 		},
 		&group{ // tile 3
 			&square4Map.gdescs[11],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{9, 10, 13, 14},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{9, 10, 13, 14},
 		},
 		&group{ // tile 4
 			&square4Map.gdescs[12],
-			[]int{0, 0, 0, 0, 0}, intset{1, 2, 3, 4}, intset{11, 12, 15, 16},
+			[]int{0, 0, 0, 0, 0}, newValset(1, 2, 3, 4), intset{11, 12, 15, 16},
 		},
 	}
 	empty4PuzzleAssign1Values = []int{
@@ -888,19 +889,19 @@ var (
 		&square{index: 1, aval: 1},
 		&square{index: 2, aval: 2},
 		&square{index: 3, aval: 3},
-		&square{index: 4, pvals: intset{4}},
-		&square{index: 5, pvals: intset{3, 4}},
-		&square{index: 6, pvals: intset{3, 4}},
-		&square{index: 7, pvals: intset{1, 2, 4}},
-		&square{index: 8, pvals: intset{1, 2, 4}},
-		&square{index: 9, pvals: intset{2, 3, 4}},
-		&square{index: 10, pvals: intset{1, 3, 4}},
-		&square{index: 11, pvals: intset{1, 2, 4}},
-		&square{index: 12, pvals: intset{1, 2, 3, 4}},
-		&square{index: 13, pvals: intset{2, 3, 4}},
-		&square{index: 14, pvals: intset{1, 3, 4}},
-		&square{index: 15, pvals: intset{1, 2, 4}},
-		&square{index: 16, pvals: intset{1, 2, 3, 4}},
+		&square{index: 4, pvals: newValset(4)},
+		&square{index: 5, pvals: newValset(3, 4)},
+		&square{index: 6, pvals: newValset(3, 4)},
+		&square{index: 7, pvals: newValset(1, 2, 4)},
+		&square{index: 8, pvals: newValset(1, 2, 4)},
+		&square{index: 9, pvals: newValset(2, 3, 4)},
+		&square{index: 10, pvals: newValset(1, 3, 4)},
+		&square{index: 11, pvals: newValset(1, 2, 4)},
+		&square{index: 12, pvals: newValset(1, 2, 3, 4)},
+		&square{index: 13, pvals: newValset(2, 3, 4)},
+		&square{index: 14, pvals: newValset(1, 3, 4)},
+		&square{index: 15, pvals: newValset(1, 2, 4)},
+		&square{index: 16, pvals: newValset(1, 2, 3, 4)},
 	}
 	conflicting4Puzzle1 = []int{
 		1, 0, 0, 0,
@@ -1416,6 +1417,67 @@ func BenchmarkIntsetSubtractMulti(b *testing.B) {
 
 /*
 
+Valsets
+
+*/
+
+func TestValset(t *testing.T) {
+	// ranges that end in each word, and on word boundaries
+	for _, max := range []int{0, 1, 9, 63, 64, 65, 128, 200, 225, 240, maxValsetValue} {
+		vs := newValsetRange(max)
+		if vs.len() != max || vs.intset() != nil && !reflect.DeepEqual(vs.intset(), newIntsetRange(max)) {
+			t.Errorf("newValsetRange(%d) has %d values: %v", max, vs.len(), vs.intset())
+		}
+		if max > 0 && (vs.first() != 1 || vs.last() != max || !vs.has(max) || vs.has(max+1)) {
+			t.Errorf("newValsetRange(%d) runs from %d to %d", max, vs.first(), vs.last())
+		}
+	}
+	vs := newValset(3, 64, 65, 200, 240)
+	if vs.has(0) || vs.has(-1) || vs.has(maxValsetValue+1) || !vs.has(65) || vs.first() != 3 || vs.last() != 240 {
+		t.Errorf("Valset %v has the wrong members", vs.intset())
+	}
+	if vs.insert(65) != true || vs.insert(129) != false || vs.remove(64) != true || vs.remove(64) != false {
+		t.Errorf("Insertion and removal into %v gave the wrong results", vs.intset())
+	}
+	if !reflect.DeepEqual(vs.intset(), intset{3, 65, 129, 200, 240}) {
+		t.Errorf("Valset is %v, expected [3 65 129 200 240]", vs.intset())
+	}
+
+	// word operations
+	xs := newValset(3, 129, 225)
+	if got := vs.and(xs).intset(); !reflect.DeepEqual(got, intset{3, 129}) {
+		t.Errorf("Intersection is %v", got)
+	}
+	if got := vs.minus(xs).intset(); !reflect.DeepEqual(got, intset{65, 200, 240}) {
+		t.Errorf("Difference is %v", got)
+	}
+	merged := vs
+	merged.merge(xs)
+	if got := merged.intset(); !reflect.DeepEqual(got, intset{3, 65, 129, 200, 225, 240}) {
+		t.Errorf("Union is %v", got)
+	}
+	var once, twice valset
+	for _, x := range []valset{vs, xs, newValset(225, 1)} {
+		once.tally(x, &twice)
+	}
+	if !reflect.DeepEqual(once.intset(), intset{1, 3, 65, 129, 200, 225, 240}) ||
+		!reflect.DeepEqual(twice.intset(), intset{3, 129, 225}) {
+		t.Errorf("Tally gave once %v, twice %v", once.intset(), twice.intset())
+	}
+	if changed, marked := vs.subtract(newValset(200, 240), 240); !changed || !marked ||
+		!reflect.DeepEqual(vs.intset(), intset{3, 65, 129}) {
+		t.Errorf("Subtraction gave %v, %v, %v", changed, marked, vs.intset())
+	}
+	if changed, marked := vs.intersect(newValset(3, 65, 129, 200), 3); changed || marked {
+		t.Errorf("Intersection with a superset gave %v, %v", changed, marked)
+	}
+	if !(valset{}).empty() || vs.empty() || (valset{}).first() != 0 || (valset{}).last() != 0 {
+		t.Errorf("Empty valsets are wrong")
+	}
+}
+
+/*
+
 Squares
 
 */
@@ -1429,7 +1491,7 @@ func TestNewEmptySquares(t *testing.T) {
 		for _, i := range indices {
 			sq := newEmptySquare(i, s, nil)
 			if sq.index != i || sq.aval != 0 || sq.bval != 0 || sq.bsrc != nil ||
-				!reflect.DeepEqual(sq.pvals, newIntsetRange(s)) {
+				sq.pvals != newValsetRange(s) {
 				t.Fatalf("newEmptySquare(%d, %d) incorrect: %v", i, s, sq)
 			}
 		}
@@ -1448,7 +1510,7 @@ func TestNewFilledSquares(t *testing.T) {
 				sq := newFilledSquare(i, s, v, nil)
 				if sq.index != i || sq.aval != v ||
 					sq.bval != 0 || sq.bsrc != nil ||
-					sq.pvals != nil {
+					!sq.pvals.empty() {
 					t.Fatalf("newFilledSquare(%d, %d, %d) incorrect: %v", i, s, v, sq)
 				}
 			}
@@ -1471,12 +1533,12 @@ type squareAssignTestcase struct {
 func TestSquareAssign(t *testing.T) {
 	errcases := []squareAssignErrcase{
 		squareAssignErrcase{
-			&square{index: 2, pvals: intset{3, 4, 5, 7}, bval: 4, bsrc: helperBsrc(5)},
+			&square{index: 2, pvals: newValset(3, 4, 5, 7), bval: 4, bsrc: helperBsrc(5)},
 			3,
 			NoGroupValueCondition,
 		},
 		squareAssignErrcase{
-			&square{index: 1, pvals: intset{3, 5}},
+			&square{index: 1, pvals: newValset(3, 5)},
 			4,
 			NotInSetCondition,
 		},
@@ -1495,27 +1557,27 @@ func TestSquareAssign(t *testing.T) {
 
 	testcases := []squareAssignTestcase{
 		squareAssignTestcase{ // one in the middle
-			&square{index: 1, pvals: intset{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+			&square{index: 1, pvals: newValset(1, 2, 3, 4, 5, 6, 7, 8, 9)},
 			4,
 			nil,
 		},
 		squareAssignTestcase{ // one at the end
-			&square{index: 2, pvals: intset{3, 4, 6, 9}},
+			&square{index: 2, pvals: newValset(3, 4, 6, 9)},
 			9,
 			nil,
 		},
 		squareAssignTestcase{ // one at the beginning
-			&square{index: 3, pvals: intset{3, 4, 6, 9}},
+			&square{index: 3, pvals: newValset(3, 4, 6, 9)},
 			3,
 			nil,
 		},
 		squareAssignTestcase{ // one already bound, with a binding source
-			&square{index: 4, pvals: intset{7, 9}, bval: 9, bsrc: helperBsrc(4)},
+			&square{index: 4, pvals: newValset(7, 9), bval: 9, bsrc: helperBsrc(4)},
 			9,
 			helperBsrc(4),
 		},
 		squareAssignTestcase{ // one already bound, with a double binding source
-			&square{index: 5, pvals: intset{3, 5, 9}, bval: 9, bsrc: helperBsrc(1, 10)},
+			&square{index: 5, pvals: newValset(3, 5, 9), bval: 9, bsrc: helperBsrc(1, 10)},
 			9,
 			helperBsrc(1, 10),
 		},
@@ -1531,7 +1593,7 @@ func TestSquareAssign(t *testing.T) {
 			t.Errorf("Assigning %v to %v gave assignment %v",
 				tc.toassign, *tc.square, input.aval)
 		}
-		if input.pvals != nil {
+		if !input.pvals.empty() {
 			t.Errorf("Assigning %v to %v gave pvals %v",
 				tc.toassign, *tc.square, input.pvals)
 		}
@@ -1559,17 +1621,17 @@ type squareBindTestcase struct {
 func TestSquareBind(t *testing.T) {
 	errcases := []squareBindErrcase{
 		squareBindErrcase{
-			&square{index: 2, bval: 4, bsrc: helperBsrc(6), pvals: intset{3, 4, 5, 6}},
+			&square{index: 2, bval: 4, bsrc: helperBsrc(6), pvals: newValset(3, 4, 5, 6)},
 			3, helperGID(102),
 			NoGroupValueCondition,
 		},
 		squareBindErrcase{
-			&square{index: 3, pvals: intset{3, 5}},
+			&square{index: 3, pvals: newValset(3, 5)},
 			4, helperGID(103),
 			NotInSetCondition,
 		},
 		squareBindErrcase{
-			&square{index: 4, pvals: intset{5}},
+			&square{index: 4, pvals: newValset(5)},
 			4, helperGID(103),
 			NotInSetCondition,
 		},
@@ -1588,32 +1650,32 @@ func TestSquareBind(t *testing.T) {
 
 	testcases := []squareBindTestcase{
 		squareBindTestcase{ // one in the middle
-			&square{index: 1, pvals: intset{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+			&square{index: 1, pvals: newValset(1, 2, 3, 4, 5, 6, 7, 8, 9)},
 			4, helperGID(101),
 			helperBsrc(101),
 		},
 		squareBindTestcase{ // one at the end
-			&square{index: 2, pvals: intset{3, 4, 6, 9}},
+			&square{index: 2, pvals: newValset(3, 4, 6, 9)},
 			9, helperGID(102),
 			helperBsrc(102),
 		},
 		squareBindTestcase{ // one at the beginning
-			&square{index: 3, pvals: intset{3, 4, 6, 9}},
+			&square{index: 3, pvals: newValset(3, 4, 6, 9)},
 			3, helperGID(103),
 			helperBsrc(103),
 		},
 		squareBindTestcase{ // one already bound, with a binding source
-			&square{index: 4, bval: 9, pvals: intset{7, 9}, bsrc: helperBsrc(7)},
+			&square{index: 4, bval: 9, pvals: newValset(7, 9), bsrc: helperBsrc(7)},
 			9, helperGID(6),
 			helperBsrc(7, 6),
 		},
 		squareBindTestcase{ // one already bound, with a double binding source
-			&square{index: 6, pvals: intset{3, 5, 9}, bval: 9, bsrc: helperBsrc(4, 7)},
+			&square{index: 6, pvals: newValset(3, 5, 9), bval: 9, bsrc: helperBsrc(4, 7)},
 			9, helperGID(8),
 			helperBsrc(4, 7, 8),
 		},
 		squareBindTestcase{ // one with a single value
-			&square{index: 7, pvals: intset{1}},
+			&square{index: 7, pvals: newValset(1)},
 			1, helperGID(1),
 			helperBsrc(1),
 		},
@@ -1663,7 +1725,7 @@ func TestSquareRemove(t *testing.T) {
 			NoGroupValueCondition,
 		},
 		squareRemoveErrcase{
-			&square{index: 3, pvals: intset{6}},
+			&square{index: 3, pvals: newValset(6)},
 			6,
 			NoPossibleValuesCondition,
 		},
@@ -1688,19 +1750,19 @@ func TestSquareRemove(t *testing.T) {
 			0, nil,
 		},
 		squareRemoveTestcase{ // input not present
-			&square{index: 3, pvals: intset{3, 4, 6, 9}},
+			&square{index: 3, pvals: newValset(3, 4, 6, 9)},
 			2,
 			intset{3, 4, 6, 9},
 			0, nil,
 		},
 		squareRemoveTestcase{ // input leaves just one possible
-			&square{index: 4, pvals: intset{6, 9}},
+			&square{index: 4, pvals: newValset(6, 9)},
 			9,
 			intset{6},
 			0, nil,
 		},
 		squareRemoveTestcase{ // reduce to already bound
-			&square{index: 105, pvals: intset{3, 12}, bval: 3, bsrc: helperBsrc(5)},
+			&square{index: 105, pvals: newValset(3, 12), bval: 3, bsrc: helperBsrc(5)},
 			12,
 			intset{3},
 			3, helperBsrc(5),
@@ -1713,7 +1775,7 @@ func TestSquareRemove(t *testing.T) {
 		if e != nil {
 			t.Fatalf("Removing %v from %v produced error %v", tc.toremove, tc.square, e)
 		}
-		if !reflect.DeepEqual(input.pvals, tc.remaining) {
+		if !reflect.DeepEqual(input.pvals.intset(), tc.remaining) {
 			t.Errorf("Removing %v from %v left %v not %v",
 				tc.toremove, *tc.square, input.pvals, tc.remaining)
 		}
@@ -1746,14 +1808,14 @@ func TestSquareSubtract(t *testing.T) {
 			NoGroupValueCondition,
 		},
 		squareSubtractErrcase{
-			&square{index: 3, pvals: intset{3, 5}},
+			&square{index: 3, pvals: newValset(3, 5)},
 			intset{1, 3, 5},
 			NoPossibleValuesCondition,
 		},
 	}
 	for _, e := range errcases {
 		input := helperDupSquare(e.square)
-		if errs := input.subtract(e.tosubtract); len(errs) == 0 {
+		if errs := input.subtract(newValset(e.tosubtract...)); len(errs) == 0 {
 			t.Errorf("Removal of %v from %v didn't return error", e.tosubtract, *e.square)
 		} else {
 			t.Logf("Removal of %v from %+v: %v", e.tosubtract, *e.square, errs)
@@ -1777,19 +1839,19 @@ func TestSquareSubtract(t *testing.T) {
 			0, nil,
 		},
 		squareSubtractTestcase{ // input disjoint from range
-			&square{index: 3, pvals: intset{3, 4, 6, 9}},
+			&square{index: 3, pvals: newValset(3, 4, 6, 9)},
 			intset{1, 2, 5, 7, 8},
 			intset{3, 4, 6, 9},
 			0, nil,
 		},
 		squareSubtractTestcase{ // input leaves just one possible
-			&square{index: 4, pvals: intset{3, 4, 6, 9}},
+			&square{index: 4, pvals: newValset(3, 4, 6, 9)},
 			intset{1, 2, 3, 4, 5, 7, 8, 9},
 			intset{6},
 			0, nil,
 		},
 		squareSubtractTestcase{ // reduce to already bound
-			&square{index: 105, pvals: intset{3, 4, 6, 9, 12, 13, 15, 16},
+			&square{index: 105, pvals: newValset(3, 4, 6, 9, 12, 13, 15, 16),
 				bval: 3, bsrc: helperBsrc(9)},
 			intset{1, 2, 4, 5, 6, 7, 8, 9, 12, 13, 15, 16},
 			intset{3},
@@ -1809,13 +1871,13 @@ func TestSquareSubtract(t *testing.T) {
 			0, nil,
 		},
 		squareSubtractTestcase{ // input disjoint from range
-			&square{index: 103, pvals: intset{3, 4, 6, 9, 12, 13, 15, 16}},
+			&square{index: 103, pvals: newValset(3, 4, 6, 9, 12, 13, 15, 16)},
 			intset{1, 2, 5, 7, 8, 10, 11, 14},
 			intset{3, 4, 6, 9, 12, 13, 15, 16},
 			0, nil,
 		},
 		squareSubtractTestcase{ // input leaves just one possible
-			&square{index: 104, pvals: intset{3, 4, 6, 9, 12, 13, 15, 16}},
+			&square{index: 104, pvals: newValset(3, 4, 6, 9, 12, 13, 15, 16)},
 			intset{1, 2, 3, 4, 5, 6, 7, 8, 9, 12, 13, 15},
 			intset{16},
 			0, nil,
@@ -1824,11 +1886,11 @@ func TestSquareSubtract(t *testing.T) {
 	for _, tc := range testcases {
 		// dup input square to preserve test case for error messages
 		input := helperDupSquare(tc.square)
-		e := input.subtract(tc.tosubtract)
+		e := input.subtract(newValset(tc.tosubtract...))
 		if e != nil {
 			t.Fatalf("Removing %v from %v produced error %v", tc.tosubtract, tc.square, e)
 		}
-		if !reflect.DeepEqual(input.pvals, tc.remaining) {
+		if !reflect.DeepEqual(input.pvals.intset(), tc.remaining) {
 			t.Errorf("Removing %v from %v left %v not %v",
 				tc.tosubtract, *tc.square, input.pvals, tc.remaining)
 		}
@@ -1861,14 +1923,14 @@ func TestSquareIntersect(t *testing.T) {
 			NoGroupValueCondition,
 		},
 		squareIntersectErrcase{
-			&square{index: 3, pvals: intset{3, 5}},
+			&square{index: 3, pvals: newValset(3, 5)},
 			intset{1, 2, 4},
 			NoPossibleValuesCondition,
 		},
 	}
 	for _, e := range errcases {
 		input := helperDupSquare(e.square)
-		if errs := input.intersect(e.tointersect); len(errs) == 0 {
+		if errs := input.intersect(newValset(e.tointersect...)); len(errs) == 0 {
 			t.Errorf("Intersection of %v with %v didn't return error",
 				e.tointersect, *e.square)
 		} else {
@@ -1893,19 +1955,19 @@ func TestSquareIntersect(t *testing.T) {
 			0, nil,
 		},
 		squareIntersectTestcase{ // input equal to range
-			&square{index: 3, pvals: intset{3, 4, 6, 9}},
+			&square{index: 3, pvals: newValset(3, 4, 6, 9)},
 			intset{3, 4, 6, 9},
 			intset{3, 4, 6, 9},
 			0, nil,
 		},
 		squareIntersectTestcase{ // input leaves just one possible
-			&square{index: 4, pvals: intset{3, 4, 6, 9}},
+			&square{index: 4, pvals: newValset(3, 4, 6, 9)},
 			intset{6},
 			intset{6},
 			0, nil,
 		},
 		squareIntersectTestcase{ // reduce to already bound
-			&square{index: 105, pvals: intset{3, 4, 6, 9, 12, 13, 15, 16},
+			&square{index: 105, pvals: newValset(3, 4, 6, 9, 12, 13, 15, 16),
 				bval: 3, bsrc: helperBsrc(105)},
 			intset{3},
 			intset{3},
@@ -1925,13 +1987,13 @@ func TestSquareIntersect(t *testing.T) {
 			0, nil,
 		},
 		squareIntersectTestcase{ // input equal to range
-			&square{index: 103, pvals: intset{3, 4, 6, 9, 12, 13, 15, 16}},
+			&square{index: 103, pvals: newValset(3, 4, 6, 9, 12, 13, 15, 16)},
 			intset{3, 4, 6, 9, 12, 13, 15, 16},
 			intset{3, 4, 6, 9, 12, 13, 15, 16},
 			0, nil,
 		},
 		squareIntersectTestcase{ // input leaves just one possible
-			&square{index: 104, pvals: intset{3, 4, 6, 9, 12, 13, 15, 16}},
+			&square{index: 104, pvals: newValset(3, 4, 6, 9, 12, 13, 15, 16)},
 			intset{16},
 			intset{16},
 			0, nil,
@@ -1940,11 +2002,11 @@ func TestSquareIntersect(t *testing.T) {
 	for _, tc := range testcases {
 		// dup input square to preserve test case for error messages
 		input := helperDupSquare(tc.square)
-		e := input.intersect(tc.tointersect)
+		e := input.intersect(newValset(tc.tointersect...))
 		if e != nil {
 			t.Fatalf("Removing %v from %v produced error %v", tc.tointersect, tc.square, e)
 		}
-		if !reflect.DeepEqual(input.pvals, tc.remaining) {
+		if !reflect.DeepEqual(input.pvals.intset(), tc.remaining) {
 			t.Errorf("Removing %v from %v left %v not %v",
 				tc.tointersect, *tc.square, input.pvals, tc.remaining)
 		}
@@ -1980,7 +2042,7 @@ type newGroupTestcase struct {
 	gindex  int
 	vals    []int
 	where   []int
-	need    intset
+	need    valset
 	empty   intset
 }
 
@@ -2006,7 +2068,7 @@ func TestNewGroup(t *testing.T) {
 				nil,
 				newFilledSquare(1, 4, 1, nil),
 				newFilledSquare(2, 4, 2, nil),
-				&square{index: 3, pvals: intset{1, 2}},
+				&square{index: 3, pvals: newValset(1, 2)},
 				newEmptySquare(4, 4, nil),
 			},
 			NotInSetCondition,
@@ -2035,32 +2097,32 @@ func TestNewGroup(t *testing.T) {
 		newGroupTestcase{ // first 2 of 4 assigned, no other info
 			"test 1", 4, GtypeRow, 1,
 			[]int{1, 2, 0, 0},
-			[]int{0, 1, 2, 0, 0}, intset{3, 4}, intset{3, 4},
+			[]int{0, 1, 2, 0, 0}, newValset(3, 4), intset{3, 4},
 		},
 		newGroupTestcase{ // first 3 of 4 assigned, forces last via removal
 			"test 2", 4, GtypeRow, 1,
 			[]int{1, 2, 3, 0},
-			[]int{0, 1, 2, 3, 0}, intset{4}, intset{4},
+			[]int{0, 1, 2, 3, 0}, newValset(4), intset{4},
 		},
 		newGroupTestcase{ // last 2 of 4 assigned, no other info
 			"test 3", 4, GtypeRow, 1,
 			[]int{0, 0, 3, 4},
-			[]int{0, 0, 0, 3, 4}, intset{1, 2}, intset{1, 2},
+			[]int{0, 0, 0, 3, 4}, newValset(1, 2), intset{1, 2},
 		},
 		newGroupTestcase{ // 2 of 4 assigned out of order, with a gap
 			"test 4", 4, GtypeRow, 1,
 			[]int{0, 4, 0, 3},
-			[]int{0, 0, 0, 4, 2}, intset{1, 2}, intset{1, 3},
+			[]int{0, 0, 0, 4, 2}, newValset(1, 2), intset{1, 3},
 		},
 		newGroupTestcase{ // 1 of 4 assigned out of order
 			"test 5", 4, GtypeRow, 1,
 			[]int{0, 0, 0, 3},
-			[]int{0, 0, 0, 4, 0}, intset{1, 2, 4}, intset{1, 2, 3},
+			[]int{0, 0, 0, 4, 0}, newValset(1, 2, 4), intset{1, 2, 3},
 		},
 		newGroupTestcase{ // 1 of 4 assigned, the other three reduced
 			"test 6", 4, GtypeRow, 1,
 			[]int{-2, -1, -4, 3},
-			[]int{0, 0, 0, 4, 0}, intset{1, 2, 4}, intset{1, 2, 3},
+			[]int{0, 0, 0, 4, 0}, newValset(1, 2, 4), intset{1, 2, 3},
 		},
 	}
 	for _, tc := range testcases {
@@ -2090,7 +2152,7 @@ type groupAnalyzeTestcase struct {
 	gindex  int
 	vals    []int
 	where   []int
-	need    intset
+	need    valset
 	empty   intset
 	bs      []binding
 }
@@ -2111,8 +2173,8 @@ func TestGroupAnalyze(t *testing.T) {
 				nil,
 				newFilledSquare(2, 4, 1, nil),
 				newFilledSquare(1, 4, 2, nil),
-				&square{index: 3, pvals: intset{1, 3}},
-				&square{index: 4, pvals: intset{2, 3}},
+				&square{index: 3, pvals: newValset(1, 3)},
+				&square{index: 4, pvals: newValset(2, 3)},
 			},
 			NoGroupValueCondition,
 		},
@@ -2122,8 +2184,8 @@ func TestGroupAnalyze(t *testing.T) {
 				nil,
 				newFilledSquare(2, 4, 1, nil),
 				newFilledSquare(1, 4, 2, nil),
-				&square{index: 3, pvals: intset{1, 3}},
-				&square{index: 4, pvals: intset{3, 4}, bval: 3, bsrc: helperBsrc(2)},
+				&square{index: 3, pvals: newValset(1, 3)},
+				&square{index: 4, pvals: newValset(3, 4), bval: 3, bsrc: helperBsrc(2)},
 			},
 			NoGroupValueCondition,
 		},
@@ -2143,49 +2205,49 @@ func TestGroupAnalyze(t *testing.T) {
 		groupAnalyzeTestcase{ // first 2 of 4 assigned, no other info
 			"test 1", 4, GtypeRow, 1,
 			[]int{2, 1, 0, 0},
-			[]int{0, 2, 1, 0, 0}, intset{3, 4}, intset{3, 4},
+			[]int{0, 2, 1, 0, 0}, newValset(3, 4), intset{3, 4},
 			nil,
 		},
 		groupAnalyzeTestcase{ // first 3 of 4 assigned, forces last
 			"test 2", 4, GtypeRow, 1,
 			[]int{3, 2, 1, 0},
-			[]int{0, 3, 2, 1, 0}, intset{}, intset{},
+			[]int{0, 3, 2, 1, 0}, newValset(), intset{},
 			nil,
 		},
 		groupAnalyzeTestcase{ // last 2 of 4 assigned, no other info
 			"test 3", 4, GtypeRow, 1,
 			[]int{0, 0, 4, 3},
-			[]int{0, 0, 0, 4, 3}, intset{1, 2}, intset{1, 2},
+			[]int{0, 0, 0, 4, 3}, newValset(1, 2), intset{1, 2},
 			nil,
 		},
 		groupAnalyzeTestcase{ // 2 of 4 assigned, with a gap
 			"test 4", 4, GtypeRow, 1,
 			[]int{0, 3, 0, 1},
-			[]int{0, 4, 0, 2, 0}, intset{2, 4}, intset{1, 3},
+			[]int{0, 4, 0, 2, 0}, newValset(2, 4), intset{1, 3},
 			nil,
 		},
 		groupAnalyzeTestcase{ // 1 of 4 assigned
 			"test 5", 4, GtypeRow, 1,
 			[]int{0, 0, 0, 3},
-			[]int{0, 0, 0, 4, 0}, intset{1, 2, 4}, intset{1, 2, 3},
+			[]int{0, 0, 0, 4, 0}, newValset(1, 2, 4), intset{1, 2, 3},
 			nil,
 		},
 		groupAnalyzeTestcase{ // 1 of 4 assigned, the other three reduced
 			"test 6", 4, GtypeRow, 1,
 			[]int{-2, -1, -4, 3},
-			[]int{0, 0, 0, 4, 0}, intset{1, 2, 4}, intset{1, 2, 3},
+			[]int{0, 0, 0, 4, 0}, newValset(1, 2, 4), intset{1, 2, 3},
 			nil,
 		},
 		groupAnalyzeTestcase{ // 2 of 4 assigned, reduction forces binding
 			"test 7", 4, GtypeRow, 1,
 			[]int{0, 4, -1, 2},
-			[]int{0, 0, 4, 0, 2}, intset{}, intset{},
+			[]int{0, 0, 4, 0, 2}, newValset(), intset{},
 			[]binding{binding{1, 1, helperBsrc(0 + 1)}},
 		},
 		groupAnalyzeTestcase{ // like the prior one, but a tile instead.
 			"test 8", 4, GtypeTile, 2,
 			[]int{0, 4, -1, 2},
-			[]int{0, 0, 8, 0, 4}, intset{}, intset{},
+			[]int{0, 0, 8, 0, 4}, newValset(), intset{},
 			[]binding{binding{3, 1, helperBsrc(8 + 2)}},
 		},
 	}
@@ -2365,7 +2427,7 @@ func TestGroupAssign(t *testing.T) {
 			s := ss[si]
 			if si == tc.ai {
 				// make sure group noticed the assignment
-				_, needed := g.need.find(tc.av)
+				needed := g.need.has(tc.av)
 				_, free := g.free.find(tc.ai)
 				if g.where[tc.av] != si || needed || free {
 					t.Errorf("groupAssign case %v: assign(%d, %d) didn't take: %v",
@@ -2580,6 +2642,30 @@ func TestNewEmptySudoku(t *testing.T) {
 	}
 }
 
+// puzzles with values that need more than one word of a valset
+func TestNewLargeSudoku(t *testing.T) {
+	for _, sidelen := range []int{100, 225} {
+		p, e := helperNewEmptySudokuPuzzle(sidelen)
+		if e != nil {
+			t.Fatalf("Creating an empty %dx%d puzzle failed: %v", sidelen, sidelen, e)
+		}
+		if pvals := p.squares[1].pvals; pvals != newValsetRange(sidelen) {
+			t.Fatalf("Square 1 of the %dx%d puzzle has values %v", sidelen, sidelen, pvals.intset())
+		}
+		if _, e := p.Assign(Choice{1, sidelen}); e != nil {
+			t.Fatalf("Assigning %d in the %dx%d puzzle failed: %v", sidelen, sidelen, sidelen, e)
+		}
+		for _, i := range []int{2, sidelen, sidelen + 1, sidelen*sidelen - sidelen + 1} {
+			if s := p.squares[i]; s.pvals.has(sidelen) || s.pvals.len() != sidelen-1 {
+				t.Errorf("Square %d of the %dx%d puzzle has values %v", i, sidelen, sidelen, s.pvals.intset())
+			}
+		}
+		if s := p.squares[sidelen*sidelen]; s.pvals != newValsetRange(sidelen) {
+			t.Errorf("The last square of the %dx%d puzzle lost values", sidelen, sidelen)
+		}
+	}
+}
+
 /*
 
 Puzzle Operations
@@ -2597,17 +2683,17 @@ func TestState(t *testing.T) {
 		stateTestcase{
 			"test 1",
 			rotation4Puzzle1PartialAssign1Values,
-			State{SudokuGeometryCode, 4, rotation4Puzzle1PartialAssign1Values, nil},
+			State{SudokuGeometryCode, 4, rotation4Puzzle1PartialAssign1Values, nil, false},
 		},
 		stateTestcase{
 			"test 2",
 			empty4PuzzleValues,
-			State{SudokuGeometryCode, 4, empty4PuzzleValues, nil},
+			State{SudokuGeometryCode, 4, empty4PuzzleValues, nil, false},
 		},
 		stateTestcase{
 			"test 3",
 			rotation4Puzzle1Complete1,
-			State{SudokuGeometryCode, 4, rotation4Puzzle1Complete1, nil},
+			State{SudokuGeometryCode, 4, rotation4Puzzle1Complete1, nil, true},
 		},
 		stateTestcase{
 			"test 4",
@@ -2634,7 +2720,7 @@ func TestState(t *testing.T) {
 					Values:    ErrorData{GroupID{GtypeTile, 3}, 1},
 					Message:   "Problem in tile 3: No square can contain 1",
 				},
-			}},
+			}, false},
 		},
 	}
 	for _, tc := range testcases {
@@ -2826,6 +2912,64 @@ func TestExternalAssign(t *testing.T) {
 	}
 }
 
+func TestAssignSolved(t *testing.T) {
+	vals := append([]int(nil), rotation4Puzzle1Complete1...)
+	vals[0], vals[15] = 0, 0
+	p, e := helperNewSudokuPuzzle(vals)
+	if e != nil {
+		t.Fatalf("Creation of nearly complete puzzle failed: %v", e)
+	}
+	if u, e := p.Assign(Choice{1, 1}); e != nil || u.Solved || p.State().Done {
+		t.Errorf("First assignment gave update %+v, error %v, done %v", u, e, p.State().Done)
+	}
+	if u, e := p.Assign(Choice{16, 3}); e != nil || !u.Solved || !p.State().Done {
+		t.Errorf("Last assignment gave update %+v, error %v, done %v", u, e, p.State().Done)
+	}
+}
+
+func TestMarkCandidate(t *testing.T) {
+	p, e := helperNewSudokuPuzzle(rotation4Puzzle1PartialValues)
+	if e != nil {
+		t.Fatalf("Creation of rotation4Puzzle1 failed: %v", e)
+	}
+	// error cases
+	if _, e = p.MarkCandidate(Choice{0, 2}); e == nil || e.(Error).Condition != TooSmallCondition {
+		t.Errorf("Mark of index too small produced incorrect error: %v", e)
+	}
+	if _, e = p.MarkCandidate(Choice{2, 5}); e == nil || e.(Error).Condition != TooLargeCondition {
+		t.Errorf("Mark of value too large produced incorrect error: %v", e)
+	}
+	if _, e = p.UnmarkCandidate(Choice{1, 1}); e == nil ||
+		e.(Error).Condition != DuplicateAssignmentCondition {
+		t.Errorf("Unmark of assigned square produced incorrect error: %v", e)
+	}
+	// marks can be any value, and are kept sorted
+	for _, v := range []int{4, 3, 2, 3} {
+		if _, e = p.MarkCandidate(Choice{2, v}); e != nil {
+			t.Fatalf("Mark of square 2 with %d failed: %v", v, e)
+		}
+	}
+	u, e := p.UnmarkCandidate(Choice{2, 3})
+	if e != nil {
+		t.Fatalf("Unmark of square 2 with 3 failed: %v", e)
+	}
+	expected := []Square{{Index: 2, Pvals: intset{2, 4}, Marks: intset{2, 4}}}
+	if !reflect.DeepEqual(u.Squares, expected) {
+		t.Errorf("Unmark update was %+v, expected %+v", u.Squares, expected)
+	}
+	// marks are copied, and are hidden (but kept) on assignment
+	c := p.copy()
+	if !reflect.DeepEqual(c.squares[2].marks, intset{2, 4}) {
+		t.Errorf("Copied marks are %v, expected %v", c.squares[2].marks, intset{2, 4})
+	}
+	if _, e = c.Assign(Choice{2, 2}); e != nil {
+		t.Fatalf("Assign to marked square failed: %v", e)
+	}
+	if S := c.Squares()[1]; S.Marks != nil || !reflect.DeepEqual(p.squares[2].marks, intset{2, 4}) {
+		t.Errorf("Assigned square has marks %v, original has %v", S.Marks, p.squares[2].marks)
+	}
+}
+
 type squaresTestcase struct {
 	name   string
 	ai, av int
@@ -2976,6 +3120,57 @@ func TestPuzzleExternalCopy(t *testing.T) {
 	}
 }
 
+func TestPuzzleOwnership(t *testing.T) {
+	vals := append([]int{SudokuGeometryCode}, oneStarValues...)
+	for _, newPuzzle := range []func([]int) (Puzzle, error){New, NewRelaxed, NewContest} {
+		p, e := newPuzzle(vals)
+		if e != nil {
+			t.Fatalf("Creation failed: %v", e)
+		}
+		p.MarkCandidate(Choice{2, 1})
+		squares, state := p.Squares(), p.State()
+
+		// changing what a puzzle returns doesn't change it
+		mine, myState := p.Squares(), p.State()
+		for i := range mine {
+			mine[i].Aval = 9
+			if len(mine[i].Pvals) > 0 {
+				mine[i].Pvals[0] = 0
+			}
+			if len(mine[i].Marks) > 0 {
+				mine[i].Marks[0] = 0
+			}
+		}
+		myState.Values[1] = 9
+		if !reflect.DeepEqual(p.Squares(), squares) || !reflect.DeepEqual(p.State(), state) {
+			t.Errorf("%T: changing returned squares and state changed the puzzle", p)
+		}
+
+		// changing a copy doesn't change the original
+		c := p.Copy()
+		if _, e := c.Assign(Choice{2, 1}); e != nil {
+			t.Fatalf("%T: assigning to the copy failed: %v", p, e)
+		}
+		c.MarkCandidate(Choice{3, 7})
+		if !reflect.DeepEqual(p.Squares(), squares) || !reflect.DeepEqual(p.State(), state) {
+			t.Errorf("%T: changing a copy changed the original", p)
+		}
+	}
+
+	// nor do changes to returned errors
+	p, _ := NewRelaxed(vals)
+	p.Assign(Choice{2, 4})
+	state := p.State()
+	if len(state.Errors) == 0 || len(state.Errors[0].Values) == 0 {
+		t.Fatalf("Relaxed puzzle with a duplicate has errors %v", state.Errors)
+	}
+	mine := p.State()
+	mine.Errors[0].Values[0] = "mine"
+	if !reflect.DeepEqual(p.State(), state) {
+		t.Errorf("Changing returned errors changed the puzzle")
+	}
+}
+
 func BenchmarkCopy(b *testing.B) {
 	master, e := helperNewSudokuPuzzle(rotation4Puzzle1PartialValues)
 	if e != nil {
@@ -0,0 +1,199 @@
+package puzzle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+
+PDF rendering
+
+A puzzle renders as a printable PDF document of one US Letter
+page, with the puzzle's grid laid out just as for SVG images,
+and optionally a second page with the puzzle's solution.  The
+documents only use the standard Helvetica fonts, so they don't
+embed any.
+
+*/
+
+// PDFOptions controls the rendering of a PDF document.  Title is
+// printed above the grid.  Givens are as for SVG images.  If a
+// Solution (the values of a Solution) is given, it's printed on
+// a second page, with the givens drawn as on the first.
+type PDFOptions struct {
+	Title    string
+	Givens   []int
+	Solution []int
+}
+
+// PDF page dimensions, in points: the page size, the width of
+// the grid, and the distance from the top of the page to the
+// title and to the grid.
+const (
+	pdfPageWidth  = 612
+	pdfPageHeight = 792
+	pdfGridWidth  = 432
+	pdfTitleTop   = 90
+	pdfGridTop    = 144
+)
+
+// helveticaWidths are the widths of the value characters in
+// Helvetica and Helvetica-Bold, in thousandths of the font size.
+// Both fonts have the same digit widths, so only the letters have
+// their own widths.
+var helveticaWidths = map[bool]map[rune]int{
+	false: {'A': 667, 'B': 667, 'C': 722, 'D': 722, 'E': 667, 'F': 611, 'G': 778, 'H': 722, 'I': 278,
+		'J': 500, 'K': 667, 'L': 556, 'M': 833, 'N': 722, 'O': 778, 'P': 667, 'Q': 778, 'R': 722,
+		'S': 667, 'T': 611, 'U': 722, 'V': 667, 'W': 944, 'X': 667, 'Y': 667, 'Z': 611},
+	true: {'A': 722, 'B': 722, 'C': 722, 'D': 722, 'E': 667, 'F': 611, 'G': 778, 'H': 722, 'I': 278,
+		'J': 556, 'K': 722, 'L': 611, 'M': 833, 'N': 722, 'O': 778, 'P': 667, 'Q': 778, 'R': 722,
+		'S': 667, 'T': 611, 'U': 722, 'V': 667, 'W': 944, 'X': 667, 'Y': 667, 'Z': 611},
+}
+
+// textWidth returns the width of a string in Helvetica (or
+// Helvetica-Bold) at the given font size.  Characters without
+// known widths are taken to be as wide as digits.
+func textWidth(s string, bold bool, size float64) float64 {
+	width := 0
+	for _, c := range s {
+		if w, ok := helveticaWidths[bold][c]; ok {
+			width += w
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// pdfString returns s as a PDF string literal.  Only printable
+// ASCII is kept; other characters become '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range s {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < ' ' || c > '~':
+			b.WriteByte('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfPage returns the content stream of a page with a layout.
+func pdfPage(l layout, title string) string {
+	var b strings.Builder
+	size := float64(pdfGridWidth) / float64(l.sidelen)
+	left, top := float64(pdfPageWidth-pdfGridWidth)/2, float64(pdfPageHeight-pdfGridTop)
+	if title != "" {
+		fmt.Fprintf(&b, "BT /F2 18 Tf %.2f %d Td %s Tj ET\n", left, pdfPageHeight-pdfTitleTop, pdfString(title))
+	}
+	thin, thick := size/40, size/13
+	for i := range l.values {
+		x, y := left+float64(i%l.sidelen)*size, top-float64(i/l.sidelen)*size
+		if (i+1)%l.sidelen != 0 {
+			width := thin
+			if l.thickRight(i) {
+				width = thick
+			}
+			fmt.Fprintf(&b, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x+size, y, x+size, y-size)
+		}
+		if i+l.sidelen < len(l.values) {
+			width := thin
+			if l.thickBelow(i) {
+				width = thick
+			}
+			fmt.Fprintf(&b, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x, y-size, x+size, y-size)
+		}
+	}
+	fmt.Fprintf(&b, "%.2f w %.2f %.2f %d %d re S\n", thick, left, top-pdfGridWidth, pdfGridWidth, pdfGridWidth)
+	fontSize := size * 3 / 5
+	for i, v := range l.values {
+		if v == 0 {
+			continue
+		}
+		x, y := left+float64(i%l.sidelen)*size, top-float64(i/l.sidelen)*size
+		font, color := "/F1", "0.11 0.37 0.72"
+		if l.givens[i] {
+			font, color = "/F2", "0 0 0"
+		}
+		s := vstr(v)
+		// Helvetica capitals and digits are about 0.72 em high
+		fmt.Fprintf(&b, "BT %s rg %s %.2f Tf %.2f %.2f Td %s Tj ET\n", color, font, fontSize,
+			x+(size-textWidth(s, l.givens[i], fontSize))/2, y-size/2-fontSize*0.36, pdfString(s))
+	}
+	return b.String()
+}
+
+// RenderPDF writes a PDF document of the puzzle's current state.
+func RenderPDF(w io.Writer, p Puzzle, opts PDFOptions) error {
+	l := newLayout(p, opts.Givens, false, false)
+	pages := []string{pdfPage(l, opts.Title)}
+	if opts.Solution != nil {
+		if len(opts.Solution) != len(l.values) {
+			return Error{
+				Scope:     ArgumentScope,
+				Structure: AttributeValueStructure,
+				Attribute: PuzzleSizeAttribute,
+				Condition: GeneralCondition,
+				Values:    ErrorData{len(opts.Solution), "Solution doesn't have the puzzle's size"},
+			}
+		}
+		solved := l
+		solved.values = opts.Solution
+		title := "Solution"
+		if opts.Title != "" {
+			title = opts.Title + ": solution"
+		}
+		pages = append(pages, pdfPage(solved, title))
+	}
+	return writePDF(w, pages)
+}
+
+// writePDF writes a PDF document with pages that have the given
+// content streams.
+func writePDF(w io.Writer, pages []string) error {
+	// the objects are the catalog, the page tree, the two
+	// fonts, and then each page and its contents
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold >>",
+	}
+	var kids []string
+	for _, content := range pages {
+		n := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", n))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+				"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, n+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, e := w.Write(b.Bytes())
+	return e
+}
@@ -0,0 +1,72 @@
+package puzzle
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRenderPDF(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(givens)
+	solution := p.Solutions()[0].Values
+
+	render := func(opts PDFOptions) string {
+		var b bytes.Buffer
+		if e := RenderPDF(&b, p, opts); e != nil {
+			t.Fatalf("RenderPDF(%+v) failed: %v", opts, e)
+		}
+		pdf := b.String()
+		// the cross-reference table must point at the objects
+		m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindStringSubmatch(pdf)
+		if !strings.HasPrefix(pdf, "%PDF-1.4\n") || m == nil {
+			t.Fatalf("RenderPDF(%+v) isn't a PDF: %q...", opts, pdf[:20])
+		}
+		xref, _ := strconv.Atoi(m[1])
+		if !strings.HasPrefix(pdf[xref:], "xref\n") {
+			t.Fatalf("RenderPDF(%+v) has a bad xref offset %d", opts, xref)
+		}
+		offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[xref:], -1)
+		for i, o := range offsets {
+			offset, _ := strconv.Atoi(o[1])
+			if !strings.HasPrefix(pdf[offset:], strconv.Itoa(i+1)+" 0 obj\n") {
+				t.Errorf("RenderPDF(%+v) has a bad offset for object %d", opts, i+1)
+			}
+		}
+		return pdf
+	}
+
+	pdf := render(PDFOptions{Title: "Puzzle (1-star)", Givens: givens})
+	if n := strings.Count(pdf, "/Type /Page "); n != 1 {
+		t.Errorf("Puzzle PDF has %d pages", n)
+	}
+	if !strings.Contains(pdf, `(Puzzle \(1-star\)) Tj`) {
+		t.Errorf("Puzzle PDF doesn't have its title")
+	}
+	filled := 0
+	for _, v := range oneStarValues {
+		if v != 0 {
+			filled++
+		}
+	}
+	if n := strings.Count(pdf, "/F2 28.80 Tf"); n != filled {
+		t.Errorf("Puzzle PDF has %d givens, not %d", n, filled)
+	}
+
+	pdf = render(PDFOptions{Givens: givens, Solution: solution})
+	if n := strings.Count(pdf, "/Type /Page "); n != 2 || !strings.Contains(pdf, "/Count 2") {
+		t.Errorf("Puzzle and solution PDF has %d pages", n)
+	}
+	if n := strings.Count(pdf, "/F1 28.80 Tf"); n != len(solution)-filled {
+		t.Errorf("Solution page has %d entries, not %d", n, len(solution)-filled)
+	}
+	if !strings.Contains(pdf, "(Solution) Tj") {
+		t.Errorf("Solution page doesn't have its title")
+	}
+
+	if e := RenderPDF(&bytes.Buffer{}, p, PDFOptions{Solution: solution[1:]}); e == nil {
+		t.Errorf("RenderPDF with a short solution succeeded")
+	}
+}
@@ -0,0 +1,142 @@
+package puzzle
+
+/*
+
+Search progress
+
+Solving a big puzzle, or generating one, can search for a long
+time, so the searches can be watched.  The watcher is told how
+the search is going every progressInterval nodes (choices pushed
+on the solver's thread), whenever a solution is found, when
+generating, whenever a square has been tried for removal, and
+once more when the search is done.  It can stop the search by
+returning false, in which case the search returns what it has
+found so far, and an Error.
+
+*/
+
+// A Progress is how far a search has got: how many nodes it has
+// searched, and how many solutions it has found.  When
+// generating, it also says how many of the grid's squares have
+// been tried for removal.
+type Progress struct {
+	Nodes     int `json:"nodes"`
+	Solutions int `json:"solutions"`
+	Tried     int `json:"tried,omitempty"`
+	Squares   int `json:"squares,omitempty"`
+}
+
+// progressInterval is how many nodes a search goes between
+// telling its watcher how it's going.
+const progressInterval = 256
+
+// stoppedSearchError is returned by searches that are stopped
+// by their watchers.
+var stoppedSearchError = Error{
+	Scope:     RequestScope,
+	Structure: ScopeStructure,
+	Condition: GeneralCondition,
+	Values:    ErrorData{"The search was stopped"},
+}
+
+// A searcher keeps the progress of a search for its watcher.
+// All its methods can be used on a nil searcher, which never
+// stops.
+type searcher struct {
+	progress Progress
+	watch    func(Progress) bool
+	stopped  bool
+}
+
+// report tells the watcher how the search is going, and returns
+// whether it should go on.
+func (s *searcher) report() bool {
+	if s == nil {
+		return true
+	}
+	if !s.stopped && !s.watch(s.progress) {
+		s.stopped = true
+	}
+	return !s.stopped
+}
+
+func (s *searcher) isStopped() bool {
+	return s != nil && s.stopped
+}
+
+// node counts a node, and returns whether the search should go
+// on.
+func (s *searcher) node() bool {
+	if s == nil {
+		return true
+	}
+	if s.progress.Nodes++; s.progress.Nodes%progressInterval == 0 {
+		return s.report()
+	}
+	return !s.stopped
+}
+
+// solution counts a solution, and returns whether the search
+// should go on.
+func (s *searcher) solution() bool {
+	if s == nil {
+		return true
+	}
+	s.progress.Solutions++
+	return s.report()
+}
+
+// tryingSquares notes how many squares generation will try.
+func (s *searcher) tryingSquares(n int) {
+	if s != nil {
+		s.progress.Squares = n
+	}
+}
+
+// triedSquare counts a square tried by generation, and returns
+// whether it should go on.
+func (s *searcher) triedSquare() bool {
+	if s == nil {
+		return true
+	}
+	s.progress.Tried++
+	return s.report()
+}
+
+// SolveWatched finds all the solutions of a puzzle, as its
+// Solutions method does, telling the watcher how it's going.
+// It's an error if the puzzle's contents aren't available, or if
+// the watcher stops the search.
+func SolveWatched(p Puzzle, watch func(Progress) bool) ([]Solution, error) {
+	if rp, ok := p.(*relaxedPuzzle); ok {
+		p = rp.puzzle
+	}
+	puz, ok := p.(*puzzle)
+	if !ok {
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle contents are not available for solving"},
+		}
+	}
+	s := &searcher{watch: watch}
+	solutions := puz.allSolutions(s)
+	if s.stopped {
+		return solutions, stoppedSearchError
+	}
+	s.report()
+	return solutions, nil
+}
+
+// GenerateWatched generates a puzzle, as Generate does, telling
+// the watcher how it's going.  It's an error if the watcher
+// stops the generation.
+func GenerateWatched(params GenerateParams, watch func(Progress) bool) (Generated, error) {
+	s := &searcher{watch: watch}
+	g, e := generate(params, s)
+	if e == nil {
+		s.report()
+	}
+	return g, e
+}
@@ -0,0 +1,44 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSolveWatched(t *testing.T) {
+	// an empty 4x4 puzzle has 288 solutions
+	empty, _ := New(append([]int{SudokuGeometryCode}, make([]int, 16)...))
+	var last Progress
+	reports := 0
+	solutions, e := SolveWatched(empty, func(p Progress) bool { last, reports = p, reports+1; return true })
+	if e != nil || len(solutions) != 288 || last.Solutions != 288 || last.Nodes == 0 || reports < 288 {
+		t.Errorf("Watched solve gave %d solutions, %v, last progress %+v in %d reports", len(solutions), e, last, reports)
+	}
+	if !reflect.DeepEqual(solutions, empty.Solutions()) {
+		t.Errorf("Watched solutions differ from the puzzle's")
+	}
+
+	// watchers can stop the search
+	solutions, e = SolveWatched(empty, func(p Progress) bool { return p.Solutions < 3 })
+	if e == nil || len(solutions) != 3 {
+		t.Errorf("Stopped solve gave %d solutions, %v", len(solutions), e)
+	}
+	contest, _ := NewContest(append([]int{SudokuGeometryCode}, make([]int, 16)...))
+	if _, e := SolveWatched(contest, func(Progress) bool { return true }); e == nil {
+		t.Errorf("Contest puzzle was solved")
+	}
+}
+
+func TestGenerateWatched(t *testing.T) {
+	var last Progress
+	g, e := GenerateWatched(GenerateParams{Seed: "golden"}, func(p Progress) bool { last = p; return true })
+	if e != nil || !reflect.DeepEqual(g.Values, goldenSeedValues) {
+		t.Errorf("Watched generation gave %v, %v", g.Values, e)
+	}
+	if last.Squares != 81 || last.Tried != 81 || last.Nodes == 0 {
+		t.Errorf("Watched generation's last progress was %+v", last)
+	}
+	if _, e := GenerateWatched(GenerateParams{Seed: "golden"}, func(p Progress) bool { return p.Tried < 10 }); e == nil {
+		t.Errorf("Stopped generation succeeded")
+	}
+}
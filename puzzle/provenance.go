@@ -0,0 +1,85 @@
+package puzzle
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+)
+
+/*
+
+Provenance signatures
+
+*/
+
+// A Provenance is a signed statement, by the issuer of a
+// catalog, that it published the puzzle with the fingerprint
+// under the ID.  Mirrors and clients that have the issuer's
+// public key can check a puzzle they were given against its
+// Provenance, and so know that the puzzle came from the issuer
+// and hasn't been changed since.  The Signature is the base64url
+// encoding of an Ed25519 signature over the other fields (see
+// SignProvenance).
+type Provenance struct {
+	PuzzleID    string `json:"puzzleID"`
+	Fingerprint string `json:"fingerprint"`
+	Geometry    int    `json:"geometry"`
+	Issuer      string `json:"issuer"`
+	Signature   string `json:"signature,omitempty"`
+}
+
+// provenanceContext is prepended to the signed fields, so
+// provenance signatures can't be mistaken for signatures over
+// anything else made with the same key.
+const provenanceContext = "susen.go puzzle provenance v1\n"
+
+// signedBytes returns the bytes a Provenance's signature is
+// over: the context, then the JSON encoding of the Provenance
+// without its signature.
+func (pv Provenance) signedBytes() []byte {
+	pv.Signature = ""
+	bytes, _ := json.Marshal(pv) // strings and ints always encode
+	return append([]byte(provenanceContext), bytes...)
+}
+
+// SignProvenance returns the Provenance for the puzzle with the
+// given ID, geometry code and cell values (in the same form
+// passed to New), signed by the issuer with the given key.
+func SignProvenance(key ed25519.PrivateKey, issuer, puzzleID string, geoAndValues []int) Provenance {
+	pv := Provenance{PuzzleID: puzzleID, Fingerprint: Fingerprint(geoAndValues), Issuer: issuer}
+	if len(geoAndValues) > 0 {
+		pv.Geometry = geoAndValues[0]
+	}
+	pv.Signature = base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, pv.signedBytes()))
+	return pv
+}
+
+// Verify checks that a Provenance was signed with the private key
+// matching the given public key, and (unless geoAndValues is
+// empty) that it's the Provenance of the puzzle with the given
+// geometry code and cell values.  It returns nil if so, and an
+// Error saying what's wrong if not.
+func (pv Provenance) Verify(key ed25519.PublicKey, geoAndValues []int) error {
+	sig, e := base64.RawURLEncoding.DecodeString(pv.Signature)
+	if e != nil || len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, pv.signedBytes(), sig) {
+		return provenanceError("Provenance signature is invalid")
+	}
+	if len(geoAndValues) > 0 &&
+		(geoAndValues[0] != pv.Geometry || Fingerprint(geoAndValues) != pv.Fingerprint) {
+		return provenanceError("Puzzle doesn't match its provenance")
+	}
+	return nil
+}
+
+// provenanceError returns the Error for a failed provenance
+// check.
+func provenanceError(message string) Error {
+	err := Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{message},
+	}
+	err.Message = err.Error()
+	return err
+}
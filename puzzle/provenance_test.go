@@ -0,0 +1,45 @@
+package puzzle
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	pub, key, e := ed25519.GenerateKey(nil)
+	if e != nil {
+		t.Fatalf("Failed to generate key: %v", e)
+	}
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	pv := SignProvenance(key, "test", "r4-1", givens)
+	if pv.Fingerprint != Fingerprint(givens) || pv.Geometry != SudokuGeometryCode || pv.Signature == "" {
+		t.Fatalf("Provenance was %+v", pv)
+	}
+	if e := pv.Verify(pub, givens); e != nil {
+		t.Errorf("Verify of signed puzzle failed: %v", e)
+	}
+	if e := pv.Verify(pub, nil); e != nil {
+		t.Errorf("Verify of signature alone failed: %v", e)
+	}
+
+	// changed puzzles, statements, and keys are caught
+	changed := append([]int(nil), givens...)
+	changed[2] = 3
+	if e := pv.Verify(pub, changed); e == nil {
+		t.Errorf("Verify of changed puzzle succeeded")
+	}
+	renamed := pv
+	renamed.PuzzleID = "r4-2"
+	if e := renamed.Verify(pub, givens); e == nil {
+		t.Errorf("Verify of renamed provenance succeeded")
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if e := pv.Verify(other, givens); e == nil {
+		t.Errorf("Verify with another key succeeded")
+	}
+	garbled := pv
+	garbled.Signature = "not base64!"
+	if e, ok := garbled.Verify(pub, givens).(Error); !ok || e.Condition != GeneralCondition {
+		t.Errorf("Verify of garbled signature gave %v", e)
+	}
+}
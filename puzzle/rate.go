@@ -0,0 +1,674 @@
+package puzzle
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+
+Difficulty rating
+
+The rater works the puzzle the way a person would, with pencil
+marks, always using the easiest technique that makes progress:
+
+1. A hidden single: a group needs a value that only one of its
+squares can take, so that square gets the value.
+
+2. A naked single: a square can only take one value, so it gets
+that value.
+
+3. Locked candidates: all the squares in a group that can take a
+value are also in a second group, so none of the second group's
+other squares can take the value.  (For rows, columns, and tiles,
+this covers both "pointing" and "claiming".)
+
+4. A naked or hidden pair: two squares of a group can take only
+the same two values, so the group's other squares can't take
+them; or two values of a group can only go in the same two
+squares, so those squares can't take other values.
+
+5. A naked or hidden triple: the same thing with three squares
+and three values.
+
+6. A unique rectangle: three corners of a rectangle of empty
+squares (in two rows, two columns, and two tiles) can only take
+the same two values, so the fourth corner can't take either of
+them.  If it could, the puzzle would have a second solution with
+the two values swapped around the rectangle.
+
+7. A bivalue universal grave (BUG+1): every empty square can take
+exactly two values except one, which can take three, and each
+value can go in exactly two squares of each group, except that
+one of the three values can go in three squares of the odd
+square's groups.  That value goes in the odd square, since
+without it the puzzle would have either no solution or two.
+
+8. Simple coloring: the squares of each group that are the only
+two that can take a value are linked, and linked squares get
+opposite colors.  One color has the value and the other doesn't,
+so a color with two squares in a group can't have it, and
+neither can a square that can see both colors.
+
+9. A forcing chain: a square can only take two values, and
+following the singles (up to a bounded number) from each one
+either leads to a contradiction, so the square takes the other
+value, or fills some other square the same way, so it gets that
+value.  (See chain.go.)
+
+10. Guessing: when none of the above make progress, the solver has
+to try values and backtrack from contradictions.  Nothing after
+the first guess is counted.
+
+The puzzle's star rating is the number of the hardest technique
+needed to solve it.  The rater doesn't use the puzzle's own
+constraint relaxation (which finds hidden and naked singles
+automatically) because it needs to know which of them it used.
+
+Solving communities don't all agree on which techniques a rating
+should allow, so ratings can be made with a profile that turns
+some of them off.  The rater never uses a disabled technique, and
+falls through to the next one it may use, so a puzzle that needs
+pairs is rated as needing a guess by a profile without them.
+Stars are always counted from the full list, so that ratings
+made with different profiles can be compared.  Guessing can't be
+disabled, since without it some puzzles can't be rated at all.
+
+The uniqueness techniques (6 and 7) only work on puzzles that
+are known to have one solution, so the rater doesn't use them on
+improper puzzles, whatever the profile.
+
+*/
+
+// Technique names, in order of difficulty.  These are
+// human-readable but not localized.
+const (
+	TechniqueHiddenSingle = "hidden single"
+	TechniqueNakedSingle  = "naked single"
+	TechniqueLocked       = "locked candidates"
+	TechniquePair         = "pair"
+	TechniqueTriple       = "triple"
+	TechniqueRectangle    = "unique rectangle"
+	TechniqueBUG          = "bivalue universal grave"
+	TechniqueColoring     = "simple coloring"
+	TechniqueChain        = "forcing chain"
+	TechniqueGuess        = "guess"
+)
+
+// techniques lists the technique names, with the number of stars
+// for each technique being its position in the list plus one.
+var techniques = []string{
+	TechniqueHiddenSingle,
+	TechniqueNakedSingle,
+	TechniqueLocked,
+	TechniquePair,
+	TechniqueTriple,
+	TechniqueRectangle,
+	TechniqueBUG,
+	TechniqueColoring,
+	TechniqueChain,
+	TechniqueGuess,
+}
+
+// A RatingProfile names a set of techniques that the rater
+// won't use.
+type RatingProfile struct {
+	Name     string   `json:"name"`
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+// RatingProfiles are the built-in rating profiles.  The first is
+// the standard profile, which uses every technique.
+var RatingProfiles = []RatingProfile{
+	{Name: "standard"},
+	{Name: "no-uniqueness", Disabled: []string{TechniqueRectangle, TechniqueBUG}},
+	{Name: "no-subsets", Disabled: []string{TechniquePair, TechniqueTriple}},
+	{Name: "no-chains", Disabled: []string{TechniqueColoring, TechniqueChain}},
+	{Name: "singles", Disabled: []string{
+		TechniqueLocked, TechniquePair, TechniqueTriple, TechniqueRectangle, TechniqueBUG,
+		TechniqueColoring, TechniqueChain,
+	}},
+}
+
+// FindRatingProfile returns the built-in rating profile with the
+// given name.  The empty name is the standard profile.
+func FindRatingProfile(name string) (RatingProfile, error) {
+	if name == "" {
+		return RatingProfiles[0], nil
+	}
+	for _, profile := range RatingProfiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return RatingProfile{}, Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{"Unknown rating profile " + name},
+	}
+}
+
+// Without returns a profile that also disables the named
+// techniques.  It's an error to name an unknown technique, or to
+// disable guessing.
+func (profile RatingProfile) Without(names ...string) (RatingProfile, error) {
+	result := RatingProfile{Name: profile.Name, Disabled: append([]string(nil), profile.Disabled...)}
+	for _, name := range names {
+		known := false
+		for _, t := range techniques[:len(techniques)-1] {
+			if t == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return RatingProfile{}, Error{
+				Scope:     ArgumentScope,
+				Structure: ScopeStructure,
+				Condition: GeneralCondition,
+				Values:    ErrorData{"Technique " + strconv.Quote(name) + " can't be disabled"},
+			}
+		}
+		if !result.disables(name) {
+			result.Disabled = append(result.Disabled, name)
+			result.Name += "-" + strings.Replace(name, " ", "-", -1)
+		}
+	}
+	return result, nil
+}
+
+// disables tells whether a profile disables a technique.
+func (profile RatingProfile) disables(name string) bool {
+	for _, d := range profile.Disabled {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// A TechniqueCount says how many times a technique was used
+// while rating a puzzle.
+type TechniqueCount struct {
+	Technique string `json:"technique"`
+	Count     int    `json:"count"`
+}
+
+// A Rating is a puzzle's difficulty in stars (from 1 to 10), plus
+// a breakdown of the techniques that were used to solve it, in
+// order of difficulty.  Only techniques that were used appear.
+type Rating struct {
+	Stars      int              `json:"stars"`
+	Techniques []TechniqueCount `json:"techniques"`
+}
+
+// Rate works out the difficulty of solving a puzzle from its
+// current state.  It's an error to rate a puzzle that can't be
+// solved, or one that doesn't reveal its contents (such as a
+// contest puzzle).  Puzzles with more than one solution can't be
+// solved without guessing, so they get the top rating.  Rate
+// uses the standard profile.
+func Rate(p Puzzle) (Rating, error) {
+	return RateWith(p, RatingProfiles[0])
+}
+
+// RateWith rates a puzzle like Rate does, but without the
+// techniques that the profile disables.
+func RateWith(p Puzzle, profile RatingProfile) (Rating, error) {
+	return rateWith(p, profile, nil, nil)
+}
+
+// rateWith rates a puzzle, counting its searches and steps with
+// a searcher (if there is one), and stopping when it's stopped.
+// If it's given technique times, it adds to them the time spent
+// on each technique.
+func rateWith(p Puzzle, profile RatingProfile, s *searcher, times []TechniqueTime) (Rating, error) {
+	if rp, ok := p.(*relaxedPuzzle); ok {
+		p = rp.puzzle
+	}
+	puz, ok := p.(*puzzle)
+	if !ok {
+		return Rating{}, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle contents are not available for rating"},
+		}
+	}
+	solved, t := searchThread(puz.copy(), nil, s)
+	t.release()
+	if s.isStopped() {
+		return Rating{}, stoppedSearchError
+	}
+	if len(solved.errors) > 0 {
+		return Rating{}, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle has no solution"},
+		}
+	}
+	r := newRater(puz)
+	r.times = times
+	r.crossCheck(puz, solved)
+	proper := puz.isProper(s) == nil
+	for i, name := range techniques {
+		r.disabled[i] = profile.disables(name) ||
+			(!proper && (name == TechniqueRectangle || name == TechniqueBUG))
+	}
+	for !s.isStopped() && r.step() {
+		s.report()
+	}
+	if s.isStopped() {
+		return Rating{}, stoppedSearchError
+	}
+	rating := Rating{}
+	for i, name := range techniques {
+		if r.counts[i] > 0 {
+			rating.Stars = i + 1
+			rating.Techniques = append(rating.Techniques, TechniqueCount{name, r.counts[i]})
+		}
+	}
+	if rating.Stars == 0 {
+		rating.Stars = 1 // a filled puzzle is trivial
+	}
+	return rating, nil
+}
+
+// A rater is a worksheet of values and pencil marks for a
+// puzzle, plus counts of the techniques used on it.  It also
+// keeps the last value it placed, the squares behind the last
+// elimination, the reasoning behind the last coloring or forcing
+// chain it used, what it needs to cross-check its work (see
+// crosscheck.go), and, if it's being timed, the time it's spent
+// on each technique (see timing.go).
+type rater struct {
+	mapping  *puzzleMapping
+	values   []int    // 1-based by square index
+	cands    []valset // 1-based by square index
+	counts   []int    // by technique
+	disabled []bool   // by technique
+	placed   Choice
+	used     intset // squares the last technique reasoned from, if not just those it placed
+	chain    *Chain
+	solution []int // by square index less one
+	encoding string
+	report   func(Discrepancy)
+	times    []TechniqueTime // by technique, if it's being timed
+}
+
+// newRater sets up a worksheet with the puzzle's assigned values
+// and the full pencil marks for the empty squares.
+func newRater(p *puzzle) *rater {
+	m := p.mapping
+	r := &rater{
+		mapping:  m,
+		values:   make([]int, m.scount+1),
+		cands:    make([]valset, m.scount+1),
+		counts:   make([]int, len(techniques)),
+		disabled: make([]bool, len(techniques)),
+	}
+	for i := 1; i <= m.scount; i++ {
+		r.cands[i] = newValsetRange(m.sidelen)
+	}
+	for i := 1; i <= m.scount; i++ {
+		if v := p.squares[i].aval; v != 0 {
+			r.place(i, v)
+		}
+	}
+	return r
+}
+
+// place a value in a square, removing it from the pencil marks
+// of the square's neighbors.
+func (r *rater) place(idx, val int) {
+	r.values[idx], r.cands[idx] = val, valset{}
+	r.placed = Choice{idx, val}
+	for _, gi := range r.mapping.ixmap[idx] {
+		for _, ei := range r.mapping.gdescs[gi].indices {
+			r.cands[ei].remove(val)
+		}
+	}
+}
+
+// step makes progress with the easiest technique it can, and
+// returns whether there's more to do.
+func (r *rater) step() bool {
+	empty := false
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] == 0 {
+			empty = true
+			break
+		}
+	}
+	if !empty {
+		return false
+	}
+	progress := []func() bool{
+		r.hiddenSingle,
+		r.nakedSingle,
+		r.lockedCandidates,
+		func() bool { return r.subset(2) },
+		func() bool { return r.subset(3) },
+		r.uniqueRectangle,
+		r.bug,
+		r.coloring,
+		r.forcingChain,
+	}
+	for i, f := range progress {
+		if !r.disabled[i] && r.try(i, f) {
+			r.counts[i]++
+			r.verify(i)
+			return true
+		}
+	}
+	r.counts[len(techniques)-1]++
+	return false
+}
+
+// where returns the indices of a group's empty squares that can
+// take a value.
+func (r *rater) where(gd *groupDescriptor, val int) intset {
+	var is intset
+	for _, i := range gd.indices {
+		if r.values[i] == 0 {
+			if r.cands[i].has(val) {
+				is = append(is, i)
+			}
+		}
+	}
+	return is
+}
+
+// hiddenSingle places the first value that has only one square
+// in one of its groups.
+func (r *rater) hiddenSingle() bool {
+	for gi := 1; gi <= r.mapping.gcount; gi++ {
+		gd := &r.mapping.gdescs[gi]
+		var once, twice valset
+		for _, i := range gd.indices {
+			once.tally(r.cands[i], &twice)
+		}
+		if single := once.minus(twice); !single.empty() {
+			v := single.first()
+			for _, i := range gd.indices {
+				if r.cands[i].has(v) {
+					r.place(i, v)
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// nakedSingle places the first square that has only one value.
+func (r *rater) nakedSingle() bool {
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] == 0 && r.cands[i].len() == 1 {
+			r.place(i, r.cands[i].first())
+			return true
+		}
+	}
+	return false
+}
+
+// lockedCandidates looks for a group whose squares for a value
+// are all in a second group, and removes the value from the
+// second group's other squares.
+func (r *rater) lockedCandidates() bool {
+	for gi := 1; gi <= r.mapping.gcount; gi++ {
+		gd := &r.mapping.gdescs[gi]
+		for v := 1; v <= r.mapping.sidelen; v++ {
+			is := r.where(gd, v)
+			if len(is) < 2 {
+				continue
+			}
+			for _, oi := range r.mapping.ixmap[is[0]] {
+				if oi == gi || !r.contains(oi, is) {
+					continue
+				}
+				removed := false
+				for _, ei := range r.where(&r.mapping.gdescs[oi], v) {
+					if _, ok := is.find(ei); !ok {
+						r.cands[ei].remove(v)
+						removed = true
+					}
+				}
+				if removed {
+					r.used = is
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// contains tells whether a group contains all the given squares.
+func (r *rater) contains(gi int, is intset) bool {
+	for _, i := range is {
+		found := false
+		for _, ogi := range r.mapping.ixmap[i] {
+			if ogi == gi {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// subset looks for naked and hidden subsets of the given size in
+// each group, and uses the first one that removes any pencil
+// marks.
+func (r *rater) subset(size int) bool {
+	for gi := 1; gi <= r.mapping.gcount; gi++ {
+		gd := &r.mapping.gdescs[gi]
+		var free intset
+		for _, i := range gd.indices {
+			if r.values[i] == 0 {
+				free = append(free, i)
+			}
+		}
+		if len(free) <= size {
+			continue
+		}
+		// naked: size squares whose marks together have size values
+		found := false
+		combinations(len(free), size, func(pick []int) bool {
+			var vals valset
+			for _, p := range pick {
+				vals.merge(r.cands[free[p]])
+			}
+			if vals.len() != size {
+				return false
+			}
+			var inside intset
+			for _, p := range pick {
+				inside = append(inside, free[p])
+			}
+			for _, i := range free {
+				if _, ok := inside.find(i); !ok {
+					if changed, _ := r.cands[i].subtract(vals, 0); changed {
+						found = true
+					}
+				}
+			}
+			if found {
+				r.used = inside
+			}
+			return found
+		})
+		if found {
+			return true
+		}
+		// hidden: size values whose squares together are size squares
+		var needed intset
+		for v := 1; v <= r.mapping.sidelen; v++ {
+			if len(r.where(gd, v)) > 0 {
+				needed = append(needed, v)
+			}
+		}
+		if len(needed) <= size {
+			continue
+		}
+		combinations(len(needed), size, func(pick []int) bool {
+			var vals valset
+			var squares intset
+			for _, p := range pick {
+				vals.insert(needed[p])
+				for _, i := range r.where(gd, needed[p]) {
+					squares.insert(i)
+				}
+			}
+			if len(squares) != size {
+				return false
+			}
+			for _, i := range squares {
+				if changed, _ := r.cands[i].intersect(vals, 0); changed {
+					found = true
+				}
+			}
+			if found {
+				r.used = squares
+			}
+			return found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueRectangle looks for a rectangle whose corners could
+// swap two values without breaking any group, three of which can
+// only take those values, and removes them from the fourth.
+func (r *rater) uniqueRectangle() bool {
+	n := r.mapping.sidelen
+	for r1 := 0; r1 < n; r1++ {
+		for r2 := r1 + 1; r2 < n; r2++ {
+			for c1 := 0; c1 < n; c1++ {
+				for c2 := c1 + 1; c2 < n; c2++ {
+					corners := [4]int{r1*n + c1 + 1, r1*n + c2 + 1, r2*n + c1 + 1, r2*n + c2 + 1}
+					var pair valset
+					for _, i := range corners {
+						if r.values[i] == 0 && r.cands[i].len() == 2 {
+							pair = r.cands[i]
+							break
+						}
+					}
+					if pair.empty() {
+						continue
+					}
+					matched, extra := 0, 0
+					for _, i := range corners {
+						switch {
+						case r.values[i] != 0:
+							matched = -1
+						case r.cands[i] == pair:
+							matched++
+						case r.cands[i].and(pair) == pair && extra == 0:
+							extra = i
+						}
+					}
+					if matched == 3 && extra != 0 && r.deadly(corners) {
+						r.cands[extra] = r.cands[extra].minus(pair)
+						r.used = corners[:]
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// deadly tells whether two values could be swapped around the
+// corners of a rectangle (given in reading order) without
+// breaking any group: each group holding a corner must hold all
+// four, or two that share a row or column.
+func (r *rater) deadly(corners [4]int) bool {
+	held := make(map[int][]int) // group index -> corners held
+	for k, i := range corners {
+		for _, gi := range r.mapping.ixmap[i] {
+			held[gi] = append(held[gi], k)
+		}
+	}
+	for _, ks := range held {
+		switch {
+		case len(ks) == 4:
+		case len(ks) == 2 && ks[0]+ks[1] != 3: // corners 0 and 3, and 1 and 2, are opposite
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// bug looks for a bivalue universal grave plus one square, and
+// places the odd square's extra value.
+func (r *rater) bug() bool {
+	odd := 0
+	for i := 1; i <= r.mapping.scount; i++ {
+		if r.values[i] != 0 {
+			continue
+		}
+		switch r.cands[i].len() {
+		case 2:
+		case 3:
+			if odd != 0 {
+				return false
+			}
+			odd = i
+		default:
+			return false
+		}
+	}
+	if odd == 0 {
+		return false
+	}
+	extra := 0
+	for gi := 1; gi <= r.mapping.gcount; gi++ {
+		gd := &r.mapping.gdescs[gi]
+		for v := 1; v <= r.mapping.sidelen; v++ {
+			switch count := len(r.where(gd, v)); {
+			case count == 0 || count == 2:
+			case count == 3 && r.contains(gi, intset{odd}) && (extra == 0 || extra == v):
+				extra = v
+			default:
+				return false
+			}
+		}
+	}
+	if extra == 0 {
+		return false
+	}
+	r.place(odd, extra)
+	return true
+}
+
+// combinations calls f with each size-k subset of 0..n-1, in
+// lexicographic order, until f returns true.
+func combinations(n, k int, f func([]int) bool) {
+	pick := make([]int, k)
+	var walk func(start, depth int) bool
+	walk = func(start, depth int) bool {
+		if depth == k {
+			return f(pick)
+		}
+		for i := start; i <= n-(k-depth); i++ {
+			pick[depth] = i
+			if walk(i+1, depth+1) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(0, 0)
+}
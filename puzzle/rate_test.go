@@ -0,0 +1,250 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+var (
+	// generated from seed 1:s2887; needs a unique rectangle
+	rectangleValues = []int{
+		7, 0, 0, 0, 0, 6, 2, 0, 0,
+		0, 3, 0, 0, 2, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 8, 9, 0, 6,
+		0, 0, 6, 5, 4, 0, 0, 0, 1,
+		5, 0, 0, 0, 0, 0, 0, 0, 3,
+		8, 0, 0, 0, 9, 3, 4, 0, 0,
+		2, 0, 5, 1, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 5, 0, 0, 8, 0,
+		0, 0, 8, 3, 0, 0, 0, 0, 2,
+	}
+	// generated from seed 1:s2468; needs a bivalue universal grave
+	bugValues = []int{
+		0, 0, 4, 0, 7, 9, 0, 2, 0,
+		0, 5, 0, 1, 0, 0, 0, 0, 0,
+		0, 1, 0, 0, 4, 3, 5, 0, 0,
+		4, 0, 0, 0, 0, 0, 9, 0, 0,
+		6, 0, 0, 2, 0, 4, 0, 0, 8,
+		0, 0, 8, 0, 0, 0, 0, 0, 1,
+		0, 0, 9, 4, 2, 0, 0, 5, 0,
+		0, 0, 0, 0, 0, 5, 0, 1, 0,
+		0, 7, 0, 6, 9, 0, 4, 0, 0,
+	}
+)
+
+type rateTestcase struct {
+	values []int
+	rating Rating
+}
+
+func TestRate(t *testing.T) {
+	tcs := []rateTestcase{
+		rateTestcase{oneStarValues, Rating{1, []TechniqueCount{
+			{TechniqueHiddenSingle, 49},
+		}}},
+		rateTestcase{threeStarValues, Rating{2, []TechniqueCount{
+			{TechniqueHiddenSingle, 55}, {TechniqueNakedSingle, 1},
+		}}},
+		rateTestcase{chronTwoValues, Rating{3, []TechniqueCount{
+			{TechniqueHiddenSingle, 54}, {TechniqueNakedSingle, 1}, {TechniqueLocked, 4},
+		}}},
+		rateTestcase{sixStarValues, Rating{9, []TechniqueCount{
+			{TechniqueHiddenSingle, 48}, {TechniqueNakedSingle, 4},
+			{TechniqueLocked, 1}, {TechniqueChain, 1},
+		}}},
+		rateTestcase{solveSimpleStartValues, Rating{10, []TechniqueCount{
+			{TechniqueGuess, 1},
+		}}},
+		rateTestcase{oneStarBoundValues, Rating{1, nil}},
+	}
+	for i, tc := range tcs {
+		p, e := helperNewSudokuPuzzle(tc.values)
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		r, e := Rate(p)
+		if e != nil {
+			t.Errorf("test %d: Rate failed: %v", i+1, e)
+		} else if !reflect.DeepEqual(r, tc.rating) {
+			t.Errorf("test %d: got rating %+v, expected %+v", i+1, r, tc.rating)
+		}
+	}
+
+	// unsolvable and contest puzzles can't be rated
+	bad := append([]int(nil), oneStarValues...)
+	bad[1] = bad[0]
+	p, e := helperNewSudokuPuzzle(bad)
+	if e != nil {
+		t.Fatalf("Failed to create unsolvable puzzle: %v", e)
+	}
+	if r, e := Rate(p); e == nil {
+		t.Errorf("Unsolvable puzzle got rating %+v", r)
+	}
+	c, e := NewContest(append([]int{SudokuGeometryCode}, oneStarValues...))
+	if e != nil {
+		t.Fatalf("Failed to create contest puzzle: %v", e)
+	}
+	if r, e := Rate(c); e == nil {
+		t.Errorf("Contest puzzle got rating %+v", r)
+	}
+}
+
+func TestRaterSubset(t *testing.T) {
+	p, e := helperNewEmptySudokuPuzzle(9)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+
+	// naked pair: squares 1 and 2 (in row 1) can only be 1 or 2
+	r := newRater(p)
+	r.cands[1], r.cands[2] = newValset(1, 2), newValset(1, 2)
+	if !r.subset(2) {
+		t.Fatalf("Naked pair not found")
+	}
+	for i := 3; i <= 9; i++ {
+		if r.cands[i] != newValset(3, 4, 5, 6, 7, 8, 9) {
+			t.Errorf("Naked pair left square %d with %v", i, r.cands[i].intset())
+		}
+	}
+
+	// hidden pair: 1 and 2 can only go in squares 1 and 2 of row 1
+	r = newRater(p)
+	for i := 3; i <= 9; i++ {
+		r.cands[i] = newValset(3, 4, 5, 6, 7, 8, 9)
+	}
+	if !r.subset(2) {
+		t.Fatalf("Hidden pair not found")
+	}
+	if r.cands[1] != newValset(1, 2) || r.cands[2] != newValset(1, 2) {
+		t.Errorf("Hidden pair left squares 1 and 2 with %v and %v", r.cands[1].intset(), r.cands[2].intset())
+	}
+}
+
+func TestRaterUniqueness(t *testing.T) {
+	p, e := helperNewEmptySudokuPuzzle(9)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+
+	// unique rectangle: squares 1, 2, 10, and 11, all in tile 1
+	r := newRater(p)
+	r.cands[1], r.cands[2], r.cands[10] = newValset(1, 2), newValset(1, 2), newValset(1, 2)
+	r.cands[11] = newValset(1, 2, 7)
+	if !r.uniqueRectangle() {
+		t.Fatalf("Unique rectangle not found")
+	}
+	if r.cands[11] != newValset(7) {
+		t.Errorf("Unique rectangle left square 11 with %v", r.cands[11].intset())
+	}
+
+	// the same corners spread over four tiles aren't deadly
+	if r.deadly([4]int{1, 4, 28, 31}) {
+		t.Errorf("Rectangle across four tiles was deadly")
+	}
+	if !r.deadly([4]int{1, 4, 10, 13}) {
+		t.Errorf("Rectangle across two tiles wasn't deadly")
+	}
+
+	// a bivalue grave needs every empty square to have two values
+	if r.bug() {
+		t.Errorf("Found a grave in an empty puzzle")
+	}
+}
+
+func TestRateUniqueness(t *testing.T) {
+	base, _ := FindRatingProfile("no-uniqueness")
+	profile, _ := base.Without(TechniqueColoring, TechniqueChain)
+	for i, tc := range []struct {
+		values []int
+		stars  int
+	}{{rectangleValues, 6}, {bugValues, 7}} {
+		p, e := helperNewSudokuPuzzle(tc.values)
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		if r, e := Rate(p); e != nil || r.Stars != tc.stars {
+			t.Errorf("test %d: got rating %+v, %v, expected %d stars", i+1, r, e, tc.stars)
+		}
+		if r, _ := RateWith(p, profile); r.Stars != 10 {
+			t.Errorf("test %d: got rating %+v without uniqueness, expected 10 stars", i+1, r)
+		}
+	}
+
+	// improper puzzles don't get uniqueness techniques
+	loose := append([]int(nil), rectangleValues...)
+	loose[0], loose[5], loose[6] = 0, 0, 0
+	p, _ := helperNewSudokuPuzzle(loose)
+	if p.IsProper() == nil {
+		t.Fatalf("Loosened puzzle is proper")
+	}
+	r, _ := Rate(p)
+	for _, tc := range r.Techniques {
+		if tc.Technique == TechniqueRectangle || tc.Technique == TechniqueBUG {
+			t.Errorf("Improper puzzle was rated with %s", tc.Technique)
+		}
+	}
+}
+
+func BenchmarkRate(b *testing.B) {
+	var ps []Puzzle
+	for _, vals := range [][]int{sixStarValues, fiveStarValues, helperSixteenValues()} {
+		p, e := helperNewSudokuPuzzle(vals)
+		if e != nil {
+			b.Fatalf("Failed to create puzzle: %v", e)
+		}
+		ps = append(ps, p)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range ps {
+			Rate(p)
+		}
+	}
+}
+
+func TestRateWith(t *testing.T) {
+	p, e := helperNewSudokuPuzzle(chronTwoValues)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	singles, e := FindRatingProfile("singles")
+	if e != nil {
+		t.Fatalf("Failed to find singles profile: %v", e)
+	}
+	r, e := RateWith(p, singles)
+	if e != nil {
+		t.Fatalf("RateWith failed: %v", e)
+	}
+	if r.Stars != 10 {
+		t.Errorf("Singles rating was %+v, expected 10 stars", r)
+	}
+	for _, tc := range r.Techniques {
+		if tc.Technique == TechniqueLocked {
+			t.Errorf("Singles rating used %s", tc.Technique)
+		}
+	}
+	if standard, _ := FindRatingProfile(""); standard.Name != "standard" {
+		t.Errorf("Default profile was %+v", standard)
+	}
+	if _, e := FindRatingProfile("none"); e == nil {
+		t.Errorf("Found an unknown profile")
+	}
+
+	// custom profiles
+	custom, e := RatingProfiles[0].Without(TechniqueLocked, TechniqueLocked)
+	if e != nil || custom.Name != "standard-locked-candidates" || len(custom.Disabled) != 1 {
+		t.Errorf("Custom profile was %+v, %v", custom, e)
+	}
+	// a pair does the work of the locked candidates
+	if r2, _ := RateWith(p, custom); r2.Stars != 4 {
+		t.Errorf("Custom rating was %+v, expected 4 stars", r2)
+	}
+	for _, name := range []string{TechniqueGuess, "x-wing"} {
+		if _, e := custom.Without(name); e == nil {
+			t.Errorf("Disabling %q succeeded", name)
+		}
+	}
+	if len(RatingProfiles[0].Disabled) != 0 {
+		t.Errorf("Without changed the standard profile")
+	}
+}
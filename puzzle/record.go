@@ -0,0 +1,243 @@
+package puzzle
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+/*
+
+Records
+
+A Record is a puzzle and the moves made on it, with a binary
+form small enough to keep in session stores and put in URLs: a
+9x9 puzzle with 50 moves takes about 100 bytes, where the JSON
+arrays of its values and moves take well over a kilobyte.  Its
+text form (which is also its JSON form) is the unpadded URL-safe
+base64 of the binary form.
+
+The binary form starts with a version byte, so the format can
+change without breaking old records: readers reject versions
+they don't know, and every release reads all the versions before
+its own.  Version 1 is:
+
+- the version byte, then as unsigned varints: the number of
+values (including the geometry code), the geometry code, and
+the number of moves
+
+- a byte giving the width, in bits, of a square value: enough for
+the largest value in the squares and moves
+
+- a bit stream, starting with the high bit of each byte, of: one
+bit for each square, set if it has a value; the value of each
+square that has one; and each move, as its index (in as many bits
+as the largest index needs) and its value.  The last byte is
+padded with zeros.
+
+*/
+
+// recordVersion is the version byte of records.
+const recordVersion = 1
+
+// A Record is a puzzle and the moves made on it.
+type Record struct {
+	Values []int    // in the form passed to New
+	Moves  []Choice // in the order they were made
+}
+
+// recordError returns the Error for data that isn't a valid
+// record, or a record that can't be encoded.
+func recordError(format string, args ...interface{}) Error {
+	err := Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{fmt.Sprintf(format, args...)},
+	}
+	err.Message = err.Error()
+	return err
+}
+
+// Puzzle returns the record's puzzle, made by newPuzzle (New,
+// NewRelaxed, or NewContest), with its moves made.  It returns
+// newPuzzle's errors, or the Error of the first move that
+// couldn't be made.
+func (r Record) Puzzle(newPuzzle func([]int) (Puzzle, error)) (Puzzle, error) {
+	p, e := newPuzzle(r.Values)
+	if e != nil || len(r.Moves) == 0 {
+		return p, e
+	}
+	if _, e := AssignAll(p, r.Moves); e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+// A bitWriter packs fields of bits into bytes.
+type bitWriter struct {
+	bytes []byte
+	used  uint // bits used in the last byte
+}
+
+func (w *bitWriter) write(v uint64, width uint) {
+	for i := width; i > 0; i-- {
+		if w.used%8 == 0 {
+			w.bytes, w.used = append(w.bytes, 0), 0
+		}
+		w.bytes[len(w.bytes)-1] |= byte(v>>(i-1)&1) << (7 - w.used)
+		w.used++
+	}
+}
+
+// A bitReader unpacks fields of bits from bytes.
+type bitReader struct {
+	bytes []byte
+	next  uint // the index of the next bit
+}
+
+func (r *bitReader) read(width uint) (uint64, bool) {
+	if r.next+width > uint(len(r.bytes))*8 {
+		return 0, false
+	}
+	var v uint64
+	for i := uint(0); i < width; i++ {
+		bit := r.bytes[(r.next+i)/8] >> (7 - (r.next+i)%8) & 1
+		v = v<<1 | uint64(bit)
+	}
+	r.next += width
+	return v, true
+}
+
+// MarshalBinary returns the binary form of the record.  It's an
+// Error if the record has no values, or has negative values, or
+// moves outside its squares.
+func (r Record) MarshalBinary() ([]byte, error) {
+	if len(r.Values) == 0 {
+		return nil, recordError("A record needs a geometry code")
+	}
+	largest := 0
+	for i, v := range r.Values {
+		if v < 0 {
+			return nil, recordError("Value %d of the record is negative", i)
+		}
+		if i > 0 && v > largest {
+			largest = v
+		}
+	}
+	for _, m := range r.Moves {
+		if m.Index < 1 || m.Index >= len(r.Values) || m.Value < 0 {
+			return nil, recordError("Move %+v of the record is outside its squares", m)
+		}
+		if m.Value > largest {
+			largest = m.Value
+		}
+	}
+	width, indexWidth := uint(bits.Len(uint(largest))), uint(bits.Len(uint(len(r.Values)-1)))
+
+	header := []byte{recordVersion}
+	header = binary.AppendUvarint(header, uint64(len(r.Values)))
+	header = binary.AppendUvarint(header, uint64(r.Values[0]))
+	header = binary.AppendUvarint(header, uint64(len(r.Moves)))
+	header = append(header, byte(width))
+	w := &bitWriter{}
+	for _, v := range r.Values[1:] {
+		if v == 0 {
+			w.write(0, 1)
+		} else {
+			w.write(1, 1)
+		}
+	}
+	for _, v := range r.Values[1:] {
+		if v != 0 {
+			w.write(uint64(v), width)
+		}
+	}
+	for _, m := range r.Moves {
+		w.write(uint64(m.Index), indexWidth)
+		w.write(uint64(m.Value), width)
+	}
+	return append(header, w.bytes...), nil
+}
+
+// UnmarshalBinary sets the record from its binary form.  It's an
+// Error if the data isn't a record, or is a record of a version
+// this release doesn't read.
+func (r *Record) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return recordError("Not a puzzle record")
+	}
+	if data[0] != recordVersion {
+		return recordError("Puzzle record version %d isn't supported", data[0])
+	}
+	data = data[1:]
+	var fields [3]uint64
+	for i := range fields {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return recordError("Not a puzzle record")
+		}
+		fields[i], data = v, data[n:]
+	}
+	count, geometry, moves := fields[0], fields[1], fields[2]
+	// every square and move takes at least a bit
+	if count == 0 || len(data) == 0 || count-1 > uint64(len(data)-1)*8 || moves > uint64(len(data)-1)*8 {
+		return recordError("Not a puzzle record")
+	}
+	width, indexWidth := uint(data[0]), uint(bits.Len(uint(count-1)))
+	if width > 32 {
+		return recordError("Not a puzzle record")
+	}
+	in := &bitReader{bytes: data[1:]}
+	values, filled := make([]int, count), make([]bool, count)
+	values[0] = int(geometry)
+	for i := 1; i < len(values); i++ {
+		bit, ok := in.read(1)
+		if !ok {
+			return recordError("Not a puzzle record")
+		}
+		filled[i] = bit == 1
+	}
+	for i := 1; i < len(values); i++ {
+		if filled[i] {
+			v, ok := in.read(width)
+			if !ok {
+				return recordError("Not a puzzle record")
+			}
+			values[i] = int(v)
+		}
+	}
+	choices := make([]Choice, moves)
+	for i := range choices {
+		index, ok1 := in.read(indexWidth)
+		value, ok2 := in.read(width)
+		if !ok1 || !ok2 || index < 1 || index >= count {
+			return recordError("Not a puzzle record")
+		}
+		choices[i] = Choice{Index: int(index), Value: int(value)}
+	}
+	r.Values, r.Moves = values, choices
+	return nil
+}
+
+// MarshalText returns the text form of the record.
+func (r Record) MarshalText() ([]byte, error) {
+	data, e := r.MarshalBinary()
+	if e != nil {
+		return nil, e
+	}
+	text := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText sets the record from its text form.
+func (r *Record) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.RawURLEncoding.DecodedLen(len(text)))
+	n, e := base64.RawURLEncoding.Decode(data, text)
+	if e != nil {
+		return recordError("Not a puzzle record")
+	}
+	return r.UnmarshalBinary(data[:n])
+}
@@ -0,0 +1,79 @@
+package puzzle
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	values := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(values)
+	solution := p.Solutions()[0].Values
+	record := Record{Values: values}
+	for i, v := range values[1:] {
+		if v == 0 {
+			record.Moves = append(record.Moves, Choice{Index: i + 1, Value: solution[i]})
+		}
+	}
+
+	data, e := record.MarshalBinary()
+	if e != nil {
+		t.Fatalf("MarshalBinary failed: %v", e)
+	}
+	valuesJSON, _ := json.Marshal(record.Values)
+	movesJSON, _ := json.Marshal(record.Moves)
+	if size := len(valuesJSON) + len(movesJSON); len(data)*10 > size {
+		t.Errorf("Binary record takes %d bytes, and JSON only %d", len(data), size)
+	}
+	var decoded Record
+	if e := decoded.UnmarshalBinary(data); e != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", e)
+	}
+	if !reflect.DeepEqual(decoded, record) {
+		t.Errorf("Decoded record is %+v, not %+v", decoded, record)
+	}
+	played, e := decoded.Puzzle(New)
+	if e != nil {
+		t.Fatalf("Playing the record failed: %v", e)
+	}
+	if got := played.State().Values; !reflect.DeepEqual(got, solution) {
+		t.Errorf("Played record has values %v, not %v", got, solution)
+	}
+
+	// the JSON form is the text form, which round-trips too
+	text, _ := record.MarshalText()
+	js, e := json.Marshal(record)
+	if e != nil || string(js) != `"`+string(text)+`"` {
+		t.Errorf("Record JSON is %s (%v), not the text %q", js, e, text)
+	}
+	decoded = Record{}
+	if e := json.Unmarshal(js, &decoded); e != nil || !reflect.DeepEqual(decoded, record) {
+		t.Errorf("Record JSON decoded to %+v (%v)", decoded, e)
+	}
+
+	// records without moves, and of other geometries
+	killer := append([]int{KillerGeometryCode}, make([]int, 81)...)
+	for _, r := range []Record{{Values: values, Moves: []Choice{}}, {Values: killer, Moves: []Choice{{Index: 81, Value: 9}}}} {
+		data, _ := r.MarshalBinary()
+		decoded = Record{}
+		if e := decoded.UnmarshalBinary(data); e != nil || !reflect.DeepEqual(decoded, r) {
+			t.Errorf("Record %+v decoded to %+v (%v)", r, decoded, e)
+		}
+	}
+
+	for _, bad := range []Record{{}, {Values: []int{0, -1}}, {Values: values, Moves: []Choice{{Index: 82, Value: 1}}}} {
+		if _, e := bad.MarshalBinary(); e == nil {
+			t.Errorf("Record %+v was encoded", bad)
+		}
+	}
+	newer := append([]byte{recordVersion + 1}, data[1:]...)
+	for _, bad := range [][]byte{nil, newer, data[:3], data[:len(data)-1], {recordVersion, 0xff}} {
+		if e := decoded.UnmarshalBinary(bad); e == nil {
+			t.Errorf("Data %v was decoded", bad)
+		}
+	}
+	if e := decoded.UnmarshalText([]byte("not*base64")); e == nil {
+		t.Errorf("Text that isn't base64 was decoded")
+	}
+}
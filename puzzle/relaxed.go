@@ -0,0 +1,151 @@
+package puzzle
+
+/*
+
+Relaxed puzzles
+
+A relaxed puzzle lets its player type whatever they like: any
+square but a given can be filled with any value, refilled, or
+emptied again (by assigning 0), whatever the puzzle's errors.
+The rules aren't enforced, they're reported: every update says
+what the puzzle's errors and conflicts are after the assignment,
+so a client can highlight them.
+
+Since an assignment can undo the constraint relaxation of earlier
+ones, a relaxed puzzle is rebuilt from its values each time it's
+assigned, keeping its pencil marks.
+
+*/
+
+// A relaxedPuzzle is a Puzzle implementation that accepts every
+// assignment to a square that isn't given.
+type relaxedPuzzle struct {
+	*puzzle
+	givens []int            // given values, by square index less one
+	saved  []*relaxedPuzzle // checkpoints, oldest first
+	observers
+}
+
+// NewRelaxed returns a relaxed Puzzle with the given geometry code
+// and cell values (in the same form passed to New), which are its
+// givens.  It returns the same errors as New.
+func NewRelaxed(geoAndValues []int) (Puzzle, error) {
+	p, e := New(geoAndValues)
+	if e != nil {
+		return nil, e
+	}
+	return &relaxedPuzzle{puzzle: p.(*puzzle), givens: append([]int(nil), geoAndValues[1:]...)}, nil
+}
+
+// Assign fills, refills, or empties (with a value of 0) a square
+// that isn't given, even if the result breaks the rules or the
+// puzzle already has errors.  The Update has every square that
+// changed.  Only out-of-range choices and assignments to givens
+// return an Error.
+func (rp *relaxedPuzzle) Assign(choice Choice) (Update, error) {
+	var update Update
+	e := rp.watch(rp, func() (e error) {
+		update, e = rp.assignChoice(choice)
+		return e
+	})
+	return update, e
+}
+
+// assignChoice does the work of Assign.
+func (rp *relaxedPuzzle) assignChoice(choice Choice) (Update, error) {
+	idx, val := choice.Index, choice.Value
+	if idx < 1 || idx > rp.mapping.scount {
+		return Update{}, rangeError(IndexAttribute, idx, 1, rp.mapping.scount)
+	}
+	if val < 0 || val > rp.mapping.sidelen {
+		return Update{}, rangeError(ValueAttribute, val, 0, rp.mapping.sidelen)
+	}
+	if given := rp.givens[idx-1]; given != 0 {
+		err := Error{
+			Scope:     ArgumentScope,
+			Structure: AttributeValueStructure,
+			Attribute: AssignedValueAttribute,
+			Condition: DuplicateAssignmentCondition,
+			Values:    ErrorData{val, idx, given},
+		}
+		err.Message = err.Error()
+		return Update{}, err
+	}
+	values := rp.allValues()
+	values[idx-1] = val
+	p, e := create(rp.mapping, values)
+	if e != nil {
+		return Update{}, e
+	}
+	for i := 1; i <= p.mapping.scount; i++ {
+		p.squares[i].marks = newIntsetCopy(rp.squares[i].marks)
+	}
+	before, after := rp.allSquares(), p.allSquares()
+	var changed []Square
+	for i := range after {
+		if i == idx-1 || !squaresEqual(before[i], after[i]) {
+			changed = append(changed, after[i])
+		}
+	}
+	rp.puzzle = p
+	return Update{changed, p.allErrors(true), p.isDone(), p.Conflicts(), nil}, nil
+}
+
+// squaresEqual tells whether two Squares are the same.
+func squaresEqual(a, b Square) bool {
+	if a.Index != b.Index || a.Aval != b.Aval || a.Bval != b.Bval ||
+		len(a.Bsrc) != len(b.Bsrc) || len(a.Pvals) != len(b.Pvals) || len(a.Marks) != len(b.Marks) {
+		return false
+	}
+	for i := range a.Bsrc {
+		if a.Bsrc[i] != b.Bsrc[i] {
+			return false
+		}
+	}
+	for i := range a.Pvals {
+		if a.Pvals[i] != b.Pvals[i] {
+			return false
+		}
+	}
+	for i := range a.Marks {
+		if a.Marks[i] != b.Marks[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsProper checks the givens, not the entries, since entries
+// that break the rules don't change what the puzzle is.
+func (rp *relaxedPuzzle) IsProper() error {
+	p, e := New(append([]int{int(rp.mapping.geometry)}, rp.givens...))
+	if e != nil {
+		return e
+	}
+	return p.IsProper()
+}
+
+// Copy returns a copy of the relaxed puzzle (no shared structure).
+func (rp *relaxedPuzzle) Copy() Puzzle {
+	return &relaxedPuzzle{puzzle: rp.copy(), givens: rp.givens} // givens are never modified, so they're shared
+}
+
+// Checkpoint saves the relaxed puzzle's state, as for puzzles.
+func (rp *relaxedPuzzle) Checkpoint() Tag {
+	rp.saved = append(rp.saved, rp.Copy().(*relaxedPuzzle))
+	return Tag(len(rp.saved))
+}
+
+// Rollback returns the relaxed puzzle to a checkpoint, as for
+// puzzles.
+func (rp *relaxedPuzzle) Rollback(tag Tag) error {
+	if tag < 1 || int(tag) > len(rp.saved) {
+		return checkpointError(tag)
+	}
+	return rp.watch(rp, func() error {
+		saved, watching := rp.saved[:tag], rp.observers
+		*rp = *saved[tag-1].Copy().(*relaxedPuzzle)
+		rp.saved, rp.observers = saved, watching
+		return nil
+	})
+}
@@ -0,0 +1,67 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelaxed(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p, e := NewRelaxed(givens)
+	if e != nil {
+		t.Fatalf("NewRelaxed failed: %v", e)
+	}
+	strict, _ := New(givens)
+	if e := p.IsProper(); !reflect.DeepEqual(e, strict.IsProper()) {
+		t.Errorf("Relaxed puzzle's properness was %v", e)
+	}
+	p.MarkCandidate(Choice{4, 2})
+
+	// a duplicate is accepted and reported
+	update, e := p.Assign(Choice{2, 1})
+	if e != nil {
+		t.Fatalf("Duplicate assignment failed: %v", e)
+	}
+	if len(update.Errors) == 0 || len(update.Conflicts) == 0 || update.Conflicts[0].Kind != ConflictDuplicate {
+		t.Errorf("Duplicate assignment gave update %+v", update)
+	}
+	if len(update.Squares) == 0 || update.Squares[0].Index != 2 || update.Squares[0].Aval != 1 {
+		t.Errorf("Duplicate assignment gave squares %+v", update.Squares)
+	}
+
+	// so is assigning on top of it, which clears the errors
+	update, e = p.Assign(Choice{2, 2})
+	if e != nil || len(update.Errors) != 0 || len(update.Conflicts) != 0 {
+		t.Errorf("Reassignment gave %+v, %v", update, e)
+	}
+	if marks := p.Squares()[3].Marks; !reflect.DeepEqual(marks, intset{2}) {
+		t.Errorf("Reassignment left marks %v", marks)
+	}
+
+	// and squares can be emptied again
+	if _, e = p.Assign(Choice{2, 0}); e != nil || p.State().Values[1] != 0 {
+		t.Errorf("Emptying a square gave %v, values %v", e, p.State().Values)
+	}
+	if _, e = Rate(p); e != nil {
+		t.Errorf("Rating a relaxed puzzle failed: %v", e)
+	}
+
+	// givens and out-of-range choices are refused
+	for _, choice := range []Choice{{1, 2}, {0, 1}, {2, 5}} {
+		if _, e = p.Assign(choice); e == nil {
+			t.Errorf("Assignment of %+v succeeded", choice)
+		}
+	}
+
+	// batches and checkpoints work too
+	tag := p.Checkpoint()
+	if _, e = AssignAll(p, []Choice{{2, 1}, {4, 1}}); e != nil {
+		t.Errorf("Batch assignment failed: %v", e)
+	}
+	if e = p.Rollback(tag); e != nil || p.State().Values[1] != 0 {
+		t.Errorf("Rollback gave %v, values %v", e, p.State().Values)
+	}
+	if c := p.Copy(); c.Rollback(tag) == nil || !reflect.DeepEqual(c.Squares(), p.Squares()) {
+		t.Errorf("Copy doesn't match")
+	}
+}
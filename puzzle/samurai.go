@@ -0,0 +1,163 @@
+package puzzle
+
+import (
+	"fmt"
+)
+
+/*
+
+Samurai Sudoku
+
+A Samurai puzzle is five 9x9 Sudoku grids that overlap: one in
+each corner of a 21x21 layout, and one in the middle that
+shares a corner tile with each of the others.  Its 369 squares
+are numbered in reading order across the whole layout, skipping
+the gaps between the grids.  A square that's in two grids is
+still one square of the puzzle, so a value assigned to it
+constrains both grids at once.
+
+The grids are only put together in the geometry's mapping, so
+the puzzle model, the solver, the rater, and hints handle
+Samurai puzzles like any others.  The rows and columns of each
+grid are groups of their own (row r of grid g, with the grids
+numbered in reading order, has index 9(g-1)+r), and the tiles
+are numbered in reading order across the layout, with each
+shared tile counted once.  The mapping's layout gives the place
+of each square, which clients get as the Row and Col of its
+Square so they can lay the puzzle out.
+
+*/
+
+// A gridLayout places the squares of a composite puzzle on a
+// square grid with the given side, which has gaps where there
+// are no squares.
+type gridLayout struct {
+	side      int
+	positions []int // position of each square (1-based), as row*side+col
+	indexOf   []int // index of the square at each position, 0 for gaps
+}
+
+// expand returns the given square values (in index order) at
+// their positions in the layout, with zeros in the gaps.
+func (l *gridLayout) expand(values []int) []int {
+	grid := make([]int, l.side*l.side)
+	for i, v := range values {
+		grid[l.positions[i+1]] = v
+	}
+	return grid
+}
+
+// compress returns the square values (in index order) at their
+// positions in the layout; it undoes expand.
+func (l *gridLayout) compress(grid []int) []int {
+	values := make([]int, len(l.positions)-1)
+	for pos, i := range l.indexOf {
+		if i != 0 {
+			values[i-1] = grid[pos]
+		}
+	}
+	return values
+}
+
+// compositeLayout returns the layout of a composite geometry, or
+// nil if the code isn't one.
+func compositeLayout(code int) *gridLayout {
+	if code == SamuraiGeometryCode {
+		return samuraiMapping.layout
+	}
+	return nil
+}
+
+// samuraiOrigins are the positions (row and column) of the top
+// left squares of a Samurai puzzle's grids, in reading order,
+// and samuraiSide is the side of its layout.
+var samuraiOrigins = [][2]int{{0, 0}, {0, 12}, {6, 6}, {12, 0}, {12, 12}}
+
+const samuraiSide = 21
+
+// samuraiMapping is the mapping of Samurai puzzles, which only
+// come in one size.
+var samuraiMapping = computeSamuraiMapping()
+
+func computeSamuraiMapping() *puzzleMapping {
+	const slen, tlen = 9, 3
+	l := &gridLayout{samuraiSide, []int{0}, make([]int, samuraiSide*samuraiSide)} // 1-based positions
+	for pos := range l.indexOf {
+		r, c := pos/samuraiSide, pos%samuraiSide
+		for _, o := range samuraiOrigins {
+			if r >= o[0] && r < o[0]+slen && c >= o[1] && c < o[1]+slen {
+				l.indexOf[pos] = len(l.positions)
+				l.positions = append(l.positions, pos)
+				break
+			}
+		}
+	}
+	gs := []groupDescriptor{{}} // 1-based indexing
+	addGroup := func(id GroupID, row, col, height, width int) {
+		indices := make(intset, 0, slen)
+		for r := row; r < row+height; r++ {
+			for c := col; c < col+width; c++ {
+				indices = append(indices, l.indexOf[r*samuraiSide+c])
+			}
+		}
+		gs = append(gs, groupDescriptor{len(gs), id, indices})
+	}
+	for g, o := range samuraiOrigins {
+		for i := 0; i < slen; i++ {
+			addGroup(GroupID{GtypeRow, g*slen + i + 1}, o[0]+i, o[1], 1, slen)
+		}
+	}
+	for g, o := range samuraiOrigins {
+		for i := 0; i < slen; i++ {
+			addGroup(GroupID{GtypeCol, g*slen + i + 1}, o[0], o[1]+i, slen, 1)
+		}
+	}
+	tiles := 0
+	for row := 0; row < samuraiSide; row += tlen {
+		for col := 0; col < samuraiSide; col += tlen {
+			if l.indexOf[row*samuraiSide+col] != 0 {
+				tiles++
+				addGroup(GroupID{GtypeTile, tiles}, row, col, tlen, tlen)
+			}
+		}
+	}
+	scount := len(l.positions) - 1
+	im := make([][]int, scount+1) // 1-based indexing
+	for _, gd := range gs[1:] {
+		for _, si := range gd.indices {
+			im[si] = append(im[si], gd.index)
+		}
+	}
+	return &puzzleMapping{SamuraiGeometryCode, slen, scount, len(gs) - 1, gs, im, nil, nil, l}
+}
+
+// newSamuraiPuzzle creates a Samurai puzzle from the given values
+func newSamuraiPuzzle(values []int) (Puzzle, error) {
+	if len(values) != samuraiMapping.scount {
+		return nil, Error{
+			Scope:     GeometryScope,
+			Structure: AttributeValueStructure,
+			Attribute: PuzzleSizeAttribute,
+			Condition: GeneralCondition,
+			Values:    ErrorData{len(values), fmt.Sprintf("Must have %d squares", samuraiMapping.scount)},
+		}
+	}
+	puzzle, err := create(samuraiMapping, values)
+	if err != nil {
+		return nil, err
+	}
+	return puzzle, nil
+}
+
+// register the Samurai puzzle mapping
+func init() {
+	gd := GeometryDescriptor{
+		Names: []string{"Samurai", "samurai", "gattai-5"},
+		Code:  SamuraiGeometryCode,
+		New:   newSamuraiPuzzle,
+	}
+	err := RegisterGeometry(&gd)
+	if err != nil {
+		panic(err) // if we can't register, we can't start up
+	}
+}
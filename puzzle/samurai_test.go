@@ -0,0 +1,116 @@
+package puzzle
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// helperSamuraiValues returns the values of a solved Samurai
+// puzzle, with the squares at the given layout positions (row and
+// column) that satisfy blank left empty.
+func helperSamuraiValues(blank func(r, c int) bool) []int {
+	m := samuraiMapping
+	values := make([]int, m.scount)
+	for i := range values {
+		r, c := m.layout.positions[i+1]/samuraiSide, m.layout.positions[i+1]%samuraiSide
+		if !blank(r, c) {
+			values[i] = (3*(r%3)+r/3+c)%9 + 1 // a Sudoku pattern in every grid
+		}
+	}
+	return values
+}
+
+func TestSamuraiGeometry(t *testing.T) {
+	if _, ok := LookupGeometryByName("samurai"); !ok {
+		t.Errorf("Samurai geometry isn't registered")
+	}
+	m := samuraiMapping
+	if m.scount != 369 || m.gcount != 45+45+41 || m.sidelen != 9 {
+		t.Errorf("Samurai mapping has %d squares, %d groups, side length %d", m.scount, m.gcount, m.sidelen)
+	}
+	for gi := 1; gi <= m.gcount; gi++ {
+		if len(m.gdescs[gi].indices) != 9 || m.gdescs[gi].index != gi {
+			t.Errorf("Samurai group %d is %+v", gi, m.gdescs[gi])
+		}
+	}
+
+	// squares in the overlapping tiles are in two grids
+	shared := m.layout.indexOf[6*samuraiSide+6]
+	if len(m.ixmap[shared]) != 5 || len(m.ixmap[1]) != 3 {
+		t.Errorf("Shared square %d has groups %v", shared, m.ixmap[shared])
+	}
+	if m.layout.indexOf[0*samuraiSide+9] != 0 || m.layout.indexOf[9*samuraiSide+0] != 0 ||
+		m.layout.indexOf[0*samuraiSide+12] != 10 || m.layout.positions[m.scount] != samuraiSide*samuraiSide-1 {
+		t.Errorf("Samurai layout has the wrong gaps")
+	}
+	if gd := m.gdescs[2*9+1]; gd.id != (GroupID{GtypeRow, 19}) || gd.indices[0] != shared {
+		t.Errorf("First row of the center grid is %+v", gd)
+	}
+	if _, e := New(append([]int{SamuraiGeometryCode}, make([]int, 81)...)); e == nil {
+		t.Errorf("Samurai puzzle was created with 81 squares")
+	}
+}
+
+func TestSamuraiPuzzle(t *testing.T) {
+	solved := helperSamuraiValues(func(r, c int) bool { return false })
+	values := helperSamuraiValues(func(r, c int) bool { return (r+2*c)%5 == 0 })
+	p, e := New(append([]int{SamuraiGeometryCode}, values...))
+	if e != nil {
+		t.Fatalf("Failed to create Samurai puzzle: %v", e)
+	}
+	if e := p.IsProper(); e != nil {
+		t.Errorf("Samurai puzzle isn't proper: %v", e)
+	}
+	if solutions := p.Solutions(); len(solutions) != 1 || !reflect.DeepEqual(solutions[0].Values, solved) {
+		t.Errorf("Samurai puzzle has solutions %v", solutions)
+	}
+
+	// squares have their places in the layout
+	squares := p.Squares()
+	if s := squares[9]; s.Row != 1 || s.Col != 13 {
+		t.Errorf("Square 10 is at row %d, column %d", s.Row, s.Col)
+	}
+	if bs, _ := json.Marshal(squares[367]); string(bs) != `{"index":368,"aval":5,"row":21,"col":20}` {
+		t.Errorf("Square 368 encodes as %s", bs)
+	}
+	plain, _ := New(append([]int{SudokuGeometryCode}, oneStarValues...))
+	if s := plain.Squares()[40]; s.Row != 0 || s.Col != 0 {
+		t.Errorf("Sudoku square has row %d, column %d", s.Row, s.Col)
+	}
+
+	// a shared square constrains both of its grids
+	empty, _ := New(append([]int{SamuraiGeometryCode}, make([]int, 369)...))
+	shared := samuraiMapping.layout.indexOf[6*samuraiSide+6]
+	if _, e := empty.Assign(Choice{Index: shared, Value: 4}); e != nil {
+		t.Fatalf("Assign to shared square failed: %v", e)
+	}
+	for _, pos := range []int{6*samuraiSide + 0, 0*samuraiSide + 6, 6*samuraiSide + 14, 14*samuraiSide + 6} {
+		s := empty.Squares()[samuraiMapping.layout.indexOf[pos]-1]
+		if _, found := s.Pvals.find(4); found {
+			t.Errorf("Square at position %d can still be 4: %v", pos, s.Pvals)
+		}
+	}
+	s := empty.Squares()[samuraiMapping.layout.indexOf[15*samuraiSide+15]-1]
+	if _, found := s.Pvals.find(4); !found {
+		t.Errorf("Square in another grid can't be 4: %v", s.Pvals)
+	}
+	if empty.Assign(Choice{Index: samuraiMapping.layout.indexOf[6*samuraiSide+1], Value: 4}); len(empty.State().Errors) == 0 {
+		t.Errorf("Repeated value in the top left grid isn't an error")
+	}
+
+	// Samurai puzzles have the Sudoku symmetries of their layout
+	l := samuraiMapping.layout
+	grid := l.expand(values)
+	rotated := make([]int, len(grid))
+	for r := 0; r < samuraiSide; r++ {
+		for c := 0; c < samuraiSide; c++ {
+			rotated[c*samuraiSide+(samuraiSide-1-r)] = grid[r*samuraiSide+c]
+		}
+	}
+	c1, e1 := Canonical(append([]int{SamuraiGeometryCode}, values...))
+	c2, e2 := Canonical(append([]int{SamuraiGeometryCode}, l.compress(rotated)...))
+	if e1 != nil || e2 != nil || !reflect.DeepEqual(c1, c2) || len(c1) != 370 {
+		t.Errorf("Samurai canonical forms differ: %v, %v (%v, %v)", c1, c2, e1, e2)
+	}
+}
@@ -0,0 +1,326 @@
+package puzzle
+
+/*
+
+Fast search
+
+Finding all the solutions of a puzzle, and checking that it has
+just one, are what generating, rating, and checking boards spend
+their time on, and Ariadne's thread (see solver.go) does them
+with whole puzzles: every assignment updates the squares, the
+groups, and their Errors, and every guess copies all of that.
+So for geometries whose only rule is that each group has each
+value once (all of them but Killer), these searches run on a
+searchGrid instead, which keeps just the value and the possible
+values of each square, and the values placed in each group, in
+flat slices.
+
+A grid fills in naked singles (squares with one possible value)
+and hidden singles (values with one possible square in a group)
+until there are none left, or there's a contradiction, and then
+guesses just as the thread does: the first empty square with
+the fewest possible values, and its values in order.  The
+singles it fills in don't depend on the order they're found in,
+so at every guess the grid is just where the puzzle would be,
+and the search finds the same solutions, with the same choices,
+after the same number of nodes.  A guess saves the grid's slices
+in a frame on a stack whose storage is reused, so searching
+doesn't allocate once the stack is as deep as it gets.
+
+*/
+
+// A searchGrid is a puzzle reduced to what a search needs.
+type searchGrid struct {
+	mapping *puzzleMapping
+	full    valset // the puzzle's values
+	state   gridState
+	naked   []int  // squares that may have one possible value
+	dirty   []int  // groups that may have hidden singles
+	queued  []bool // 1-based by group index: whether it's dirty
+	frames  []gridFrame
+}
+
+// A gridState is what a grid saves at a guess.
+type gridState struct {
+	values []int    // 1-based by square index, 0 if empty
+	cands  []valset // 1-based by square index, empty if filled
+	placed []valset // 1-based by group index
+	empty  int      // how many squares are empty
+}
+
+// A gridFrame is a guess: the grid before it, the square it
+// fills, the value it tried, and the values still to try.
+type gridFrame struct {
+	saved  gridState
+	cindex int
+	cvalue int
+	cnext  valset
+}
+
+// newSearchGrid returns the grid of a puzzle, or nil if the
+// puzzle can't be searched on a grid: it has Errors, or its
+// geometry has rules other than groups with each value once.
+func newSearchGrid(p *puzzle) *searchGrid {
+	m := p.mapping
+	if len(p.errors) > 0 || m.cages != nil {
+		return nil
+	}
+	for gi := 1; gi <= m.gcount; gi++ {
+		if len(m.gdescs[gi].indices) != m.sidelen {
+			return nil
+		}
+	}
+	g := &searchGrid{
+		mapping: m,
+		full:    newValsetRange(m.sidelen),
+		state:   newGridState(m),
+		queued:  make([]bool, m.gcount+1),
+	}
+	for i := 1; i <= m.scount; i++ {
+		if len(m.ixmap[i]) == 0 {
+			return nil
+		}
+		s := p.squares[i]
+		if s.aval != 0 {
+			g.state.values[i] = s.aval
+			for _, gi := range m.ixmap[i] {
+				g.state.placed[gi].insert(s.aval)
+			}
+		} else {
+			g.state.cands[i] = s.pvals
+			g.state.empty++
+			g.naked = append(g.naked, i)
+		}
+	}
+	for gi := 1; gi <= m.gcount; gi++ {
+		g.touch(gi)
+	}
+	return g
+}
+
+// newGridState allocates the state of a grid with a mapping.
+func newGridState(m *puzzleMapping) gridState {
+	return gridState{
+		values: make([]int, m.scount+1),
+		cands:  make([]valset, m.scount+1),
+		placed: make([]valset, m.gcount+1),
+	}
+}
+
+// copyTo copies a grid state into another of the same size.
+func (st *gridState) copyTo(to *gridState) {
+	copy(to.values, st.values)
+	copy(to.cands, st.cands)
+	copy(to.placed, st.placed)
+	to.empty = st.empty
+}
+
+// search finds the solutions of the grid's puzzle, telling a
+// searcher (if there is one) about its nodes, and stopping when
+// it's stopped.  If keep is true, it keeps the solutions and
+// tells the searcher about them, too; otherwise it just counts
+// them.  It stops after limit solutions, if limit isn't 0.
+func (g *searchGrid) search(s *searcher, keep bool, limit int) ([]Solution, int) {
+	var solutions []Solution
+	count := 0
+	ok := g.propagate()
+	for {
+		if ok && g.state.empty == 0 {
+			count++
+			if keep {
+				solutions = append(solutions, g.solution())
+				if !s.solution() {
+					break
+				}
+			}
+			if count == limit {
+				break
+			}
+			ok = false
+		}
+		if !ok {
+			if len(g.frames) == 0 {
+				break
+			}
+			ok = g.backtrack()
+			continue
+		}
+		if !s.node() {
+			break
+		}
+		ok = g.guess()
+	}
+	return solutions, count
+}
+
+// solution returns the solution the grid has found.
+func (g *searchGrid) solution() Solution {
+	S := Solution{Values: append([]int(nil), g.state.values[1:]...)}
+	if len(g.frames) > 0 {
+		S.Choices = make([]Choice, len(g.frames))
+		for i := range g.frames {
+			S.Choices[i] = Choice{g.frames[i].cindex, g.frames[i].cvalue}
+		}
+	}
+	return S
+}
+
+// guess chooses an empty square, saves the grid in a new frame,
+// and fills the square with its first possible value, returning
+// whether that gives no contradiction (yet).
+func (g *searchGrid) guess() bool {
+	cindex, ccount := 0, g.mapping.sidelen+1
+	for i := 1; i <= g.mapping.scount; i++ {
+		if g.state.values[i] == 0 {
+			count := g.state.cands[i].len()
+			if count == 2 {
+				cindex = i
+				break
+			}
+			if count < ccount {
+				cindex, ccount = i, count
+			}
+		}
+	}
+	if len(g.frames) < cap(g.frames) {
+		g.frames = g.frames[:len(g.frames)+1]
+	} else {
+		g.frames = append(g.frames, gridFrame{})
+	}
+	f := &g.frames[len(g.frames)-1]
+	if f.saved.values == nil {
+		f.saved = newGridState(g.mapping)
+	}
+	g.state.copyTo(&f.saved)
+	f.cindex, f.cnext = cindex, g.state.cands[cindex]
+	f.cvalue = f.cnext.first()
+	f.cnext.remove(f.cvalue)
+	return g.place(cindex, f.cvalue) && g.propagate()
+}
+
+// backtrack drops the frames with no values left to try, and
+// restores the grid from the last one left (if any), filling its
+// square with the next value to try.  It returns whether that
+// gives no contradiction (yet), and false if there are no frames
+// left.
+func (g *searchGrid) backtrack() bool {
+	for n := len(g.frames); n > 0; n = len(g.frames) {
+		f := &g.frames[n-1]
+		if f.cnext.empty() {
+			g.frames = g.frames[:n-1]
+			continue
+		}
+		f.saved.copyTo(&g.state)
+		g.naked, g.dirty = g.naked[:0], g.dirty[:0]
+		for gi := range g.queued {
+			g.queued[gi] = false
+		}
+		f.cvalue = f.cnext.first()
+		f.cnext.remove(f.cvalue)
+		return g.place(f.cindex, f.cvalue) && g.propagate()
+	}
+	return false
+}
+
+// touch queues a group to be checked for hidden singles.
+func (g *searchGrid) touch(gi int) {
+	if !g.queued[gi] {
+		g.queued[gi] = true
+		g.dirty = append(g.dirty, gi)
+	}
+}
+
+// place fills an empty square with a value, removing the value
+// from the square's neighbors, and returns false if that's a
+// contradiction: the square can't take the value, or a
+// neighbor is left with no possible values.
+func (g *searchGrid) place(i, v int) bool {
+	st := &g.state
+	if !st.cands[i].has(v) {
+		return false
+	}
+	st.values[i], st.cands[i] = v, valset{}
+	st.empty--
+	for _, gi := range g.mapping.ixmap[i] {
+		if st.placed[gi].insert(v) {
+			return false
+		}
+		g.touch(gi)
+		for _, j := range g.mapping.gdescs[gi].indices {
+			if st.values[j] == 0 && st.cands[j].remove(v) {
+				switch st.cands[j].len() {
+				case 0:
+					return false
+				case 1:
+					g.naked = append(g.naked, j)
+				}
+				for _, gj := range g.mapping.ixmap[j] {
+					g.touch(gj)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// propagate fills in naked and hidden singles until there are
+// none left, and returns false if it finds a contradiction.
+func (g *searchGrid) propagate() bool {
+	st := &g.state
+	for {
+		if n := len(g.naked); n > 0 {
+			i := g.naked[n-1]
+			g.naked = g.naked[:n-1]
+			if st.values[i] == 0 && st.cands[i].len() == 1 && !g.place(i, st.cands[i].first()) {
+				return false
+			}
+			continue
+		}
+		if n := len(g.dirty); n > 0 {
+			gi := g.dirty[n-1]
+			g.dirty = g.dirty[:n-1]
+			g.queued[gi] = false
+			if !g.hiddenSingles(gi) {
+				return false
+			}
+			continue
+		}
+		return true
+	}
+}
+
+// hiddenSingles fills in the hidden singles of a group, and
+// returns false if the group has a contradiction: a value it
+// still needs can't go in any of its squares.
+func (g *searchGrid) hiddenSingles(gi int) bool {
+	st := &g.state
+	indices := g.mapping.gdescs[gi].indices
+	var once, twice valset
+	for _, i := range indices {
+		if st.values[i] == 0 {
+			once.tally(st.cands[i], &twice)
+		}
+	}
+	need := g.full.minus(st.placed[gi])
+	if !need.minus(once).empty() {
+		return false
+	}
+	for singles := need.minus(twice); !singles.empty(); {
+		v := singles.first()
+		singles.remove(v)
+		found := false
+		for _, i := range indices {
+			if st.cands[i].has(v) {
+				if !g.place(i, v) {
+					return false
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
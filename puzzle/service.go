@@ -6,6 +6,11 @@ import (
 	"net/http"
 )
 
+// RequestIDHeader is the request header that identifies a
+// request.  Error responses to requests that have one carry it
+// as their RequestID, so failures can be matched to server logs.
+const RequestIDHeader = "X-Request-ID"
+
 /*
 
 Puzzle Creation
@@ -20,19 +25,18 @@ Puzzle Creation
 // the error is sent as a 400 response and also returned to the
 // caller.
 //
-// If we can't decode the posted value array, we send a 400
-// reponse and return the error to the caller.
+// If we can't decode the posted value array (see
+// DecodeGeoAndValues), we send a 400 reponse (or a 413, if it's
+// too big) and return the error to the caller.
 //
 // If we can't encode the response to the client (which should
 // never happen), then the client gets an error response and the
 // golang caller gets both the puzzle and the encoding Error (as
 // a signal that the client didn't get the correct response).
 func NewHandler(w http.ResponseWriter, r *http.Request) (Puzzle, error) {
-	dec := json.NewDecoder(r.Body)
-	var geoAndVals []int
-	e := dec.Decode(&geoAndVals)
+	geoAndVals, e := DecodeGeoAndValues(r.Body)
 	if e != nil {
-		return nil, writeError(requestDecodingError, ErrorData{e.Error()}, w, r)
+		return nil, writeDecodingError(e, w, r)
 	}
 	p, e := New(geoAndVals)
 	if e != nil {
@@ -73,14 +77,61 @@ func SquaresHandler(p Puzzle, w http.ResponseWriter, r *http.Request) error {
 }
 
 // SolutionsHandler responds with the Puzzle's solutions (or the
-// Error produced by computing the puzzle's solutions).  If we
+// Error produced by computing the puzzle's solutions).  The
+// search is given up if the request is canceled.  If we can't
+// encode the response to the client successfully, we give both
+// the client and the golang caller an Error response.
+func SolutionsHandler(p Puzzle, w http.ResponseWriter, r *http.Request) error {
+	if p == nil {
+		return writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	solutions, e := SolveContext(r.Context(), p)
+	if e != nil {
+		if r.Context().Err() != nil {
+			err, status := e.(Error), http.StatusServiceUnavailable
+			if err.Condition == DeadlineExceededCondition {
+				status = http.StatusGatewayTimeout
+			}
+			err.Message = err.Error()
+			return writeJSON(err, status, w, r)
+		}
+		solutions = p.Solutions() // its contents aren't available for searching
+	}
+	return writeJSON(solutions, http.StatusOK, w, r)
+}
+
+// ConflictsHandler responds with the Puzzle's conflicts.  If we
 // can't encode the response to the client successfully, we give
 // both the client and the golang caller an Error response.
-func SolutionsHandler(p Puzzle, w http.ResponseWriter, r *http.Request) error {
+func ConflictsHandler(p Puzzle, w http.ResponseWriter, r *http.Request) error {
 	if p == nil {
 		return writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
 	}
-	return writeJSON(p.Solutions(), http.StatusOK, w, r)
+	conflicts := p.Conflicts()
+	if conflicts == nil {
+		conflicts = []Conflict{}
+	}
+	return writeJSON(conflicts, http.StatusOK, w, r)
+}
+
+// UnitsHandler responds with the Puzzle's units (or the Error
+// from finding them).  If we can't encode the response to the
+// client successfully, we give both the client and the golang
+// caller an Error response.
+func UnitsHandler(p Puzzle, w http.ResponseWriter, r *http.Request) error {
+	if p == nil {
+		return writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	units, e := Units(p)
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return writeError(errorFormatError, ErrorData{"UnitsHandler", e.Error()}, w, r)
+		}
+		err.Message = err.Error()
+		return writeJSON(err, http.StatusBadRequest, w, r)
+	}
+	return writeJSON(units, http.StatusOK, w, r)
 }
 
 /*
@@ -93,8 +144,9 @@ Puzzle Updates
 // to a puzzle.  The poster and the caller both get the Update
 // object returned from the assignment (or the error).
 //
-// If we can't decode the posted choice, we send a 400 reponse
-// and return the error to the caller.
+// If we can't decode the posted choice (see DecodeChoice), we
+// send a 400 reponse (or a 413, if it's too big) and return the
+// error to the caller.
 //
 // If we can't encode the response to the client (which should
 // never happen), then the client gets an error response and the
@@ -105,18 +157,76 @@ func AssignHandler(p Puzzle, w http.ResponseWriter, r *http.Request) (Update, er
 		return Update{},
 			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
 	}
-	dec := json.NewDecoder(r.Body)
-	var choice Choice
-	e := dec.Decode(&choice)
+	return choiceHandler(p.Assign, "AssignHandler", w, r)
+}
+
+// AssignBatchHandler is a POST handler that assigns a posted
+// array of choices to a puzzle as a unit, using AssignAll.  The
+// poster gets the merged Update (or the Error from the first
+// choice that couldn't be assigned, in which case the puzzle is
+// unchanged), and the caller gets the Update from each choice.
+// Decoding, encoding, and error handling are otherwise the same
+// as for AssignHandler.
+func AssignBatchHandler(p Puzzle, w http.ResponseWriter, r *http.Request) ([]Update, error) {
+	if p == nil {
+		return nil,
+			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	choices, e := DecodeChoices(r.Body)
+	if e != nil {
+		return nil, writeDecodingError(e, w, r)
+	}
+	updates, e := AssignAll(p, choices)
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return nil,
+				writeError(errorFormatError, ErrorData{"AssignBatchHandler", e.Error()}, w, r)
+		}
+		err.Message = err.Error()
+		return nil, writeJSON(err, http.StatusBadRequest, w, r)
+	}
+	return updates, writeJSON(MergeUpdates(updates), http.StatusOK, w, r)
+}
+
+// MarkHandler is a POST handler that pencils a posted choice
+// into a puzzle's candidate marks.  Decoding, encoding, and
+// error handling are the same as for AssignHandler.
+func MarkHandler(p Puzzle, w http.ResponseWriter, r *http.Request) (Update, error) {
+	if p == nil {
+		return Update{},
+			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	return choiceHandler(p.MarkCandidate, "MarkHandler", w, r)
+}
+
+// UnmarkHandler is a POST handler that erases a posted choice
+// from a puzzle's candidate marks.  Decoding, encoding, and
+// error handling are the same as for AssignHandler.
+func UnmarkHandler(p Puzzle, w http.ResponseWriter, r *http.Request) (Update, error) {
+	if p == nil {
+		return Update{},
+			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	return choiceHandler(p.UnmarkCandidate, "UnmarkHandler", w, r)
+}
+
+// choiceHandler does the work for the handlers that decode a
+// posted choice, apply it to a puzzle with the given operation,
+// and respond with the resulting Update.  The name is used to
+// locate the problem if the operation returns a non-Error.
+func choiceHandler(op func(Choice) (Update, error), name string,
+	w http.ResponseWriter, r *http.Request) (Update, error) {
+	choice, e := DecodeChoice(r.Body)
 	if e != nil {
-		return Update{}, writeError(requestDecodingError, ErrorData{e.Error()}, w, r)
+		return Update{}, writeDecodingError(e, w, r)
 	}
-	update, e := p.Assign(choice)
+	update, e := op(choice)
 	if e != nil {
 		err, ok := e.(Error)
 		if !ok {
 			return Update{},
-				writeError(errorFormatError, ErrorData{"AssignHandler", e.Error()}, w, r)
+				writeError(errorFormatError, ErrorData{name, e.Error()}, w, r)
 		}
 		err.Message = err.Error()
 		return Update{}, writeJSON(err, http.StatusBadRequest, w, r)
@@ -124,6 +234,102 @@ func AssignHandler(p Puzzle, w http.ResponseWriter, r *http.Request) (Update, er
 	return update, writeJSON(update, http.StatusOK, w, r)
 }
 
+// CheckHandler is a POST handler that checks a posted choice
+// against a puzzle, using Check, without changing the puzzle.
+// Both the poster and the caller get the CheckResult.  Choices
+// that can't be assigned aren't errors: their results say why.
+// The search for a solution is given up if the request is
+// canceled.
+func CheckHandler(p Puzzle, w http.ResponseWriter, r *http.Request) (CheckResult, error) {
+	if p == nil {
+		return CheckResult{},
+			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	choice, e := DecodeChoice(r.Body)
+	if e != nil {
+		return CheckResult{}, writeDecodingError(e, w, r)
+	}
+	result, e := Check(r.Context(), p, choice)
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return result, writeError(errorFormatError, ErrorData{"CheckHandler", e.Error()}, w, r)
+		}
+		status := http.StatusBadRequest
+		if r.Context().Err() != nil {
+			status = http.StatusServiceUnavailable
+			if err.Condition == DeadlineExceededCondition {
+				status = http.StatusGatewayTimeout
+			}
+		}
+		err.Message = err.Error()
+		return result, writeJSON(err, status, w, r)
+	}
+	return result, writeJSON(result, http.StatusOK, w, r)
+}
+
+/*
+
+Solution Verification
+
+*/
+
+// VerifyHandler is a POST handler that reads a JSON-encoded
+// Claim from the request body, uses the lookup function to find
+// the geometry code and values of the claimed puzzle from its
+// fingerprint, and verifies the claimed solution.  The poster
+// and the caller both get the resulting Verification.
+//
+// If we can't decode the posted claim (see DecodeClaim), we send
+// a 400 response (or a 413, if it's too big) and return the error
+// to the caller.  If the lookup fails, we
+// send a 404 response and return the error.
+func VerifyHandler(lookup func(string) ([]int, bool),
+	w http.ResponseWriter, r *http.Request) (Verification, error) {
+	claim, e := DecodeClaim(r.Body)
+	if e != nil {
+		return Verification{}, writeDecodingError(e, w, r)
+	}
+	geoAndVals, ok := lookup(claim.Fingerprint)
+	if !ok {
+		return Verification{}, writeError(noPuzzleError,
+			ErrorData{r.URL.Path, "No puzzle with fingerprint " + claim.Fingerprint}, w, r)
+	}
+	v, e := Verify(geoAndVals, claim.Values)
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return v, writeError(errorFormatError, ErrorData{"VerifyHandler", e.Error()}, w, r)
+		}
+		err.Message = err.Error()
+		return v, writeJSON(err, http.StatusBadRequest, w, r)
+	}
+	return v, writeJSON(v, http.StatusOK, w, r)
+}
+
+// SubmitHandler responds with the Verification of a puzzle's
+// current values as a solution to the puzzle with the given
+// geometry code and values.  This is how contest puzzles, which
+// never reveal errors, find out whether they've been solved.
+func SubmitHandler(geoAndValues []int, p Puzzle,
+	w http.ResponseWriter, r *http.Request) (Verification, error) {
+	if p == nil {
+		return Verification{},
+			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	state := p.State()
+	v, e := Verify(geoAndValues, append([]int{state.Geometry}, state.Values...))
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return v, writeError(errorFormatError, ErrorData{"SubmitHandler", e.Error()}, w, r)
+		}
+		err.Message = err.Error()
+		return v, writeJSON(err, http.StatusBadRequest, w, r)
+	}
+	return v, writeJSON(v, http.StatusOK, w, r)
+}
+
 /*
 
 Utilities
@@ -200,6 +406,16 @@ func writeError(et handlerError, ed ErrorData,
 	return writeJSON(err, status, w, r)
 }
 
+// writeDecodingError sends back the error from decoding a
+// request (see DecodeChoice and the like), with its status.
+func writeDecodingError(e error, w http.ResponseWriter, r *http.Request) error {
+	err, ok := e.(Error)
+	if !ok {
+		return writeError(requestDecodingError, ErrorData{e.Error()}, w, r)
+	}
+	return writeJSON(err, DecodingStatus(err), w, r)
+}
+
 // writeJSON is called by handlers to encode and send the client
 // response.  It returns an appropriate error status for the
 // handler to return to its caller, as follows:
@@ -216,8 +432,20 @@ func writeError(et handlerError, ed ErrorData,
 // 3. If no encoding error occurs, and the handler is sending a
 // non-Error object as the response to the client, writeJSON will
 // return nil to the handler.
+//
+// Errors are sent in their envelopes (see Error.Envelope), with
+// their messages in the first language of the request's
+// Accept-Language header (see Error.Localize).
 func writeJSON(obj interface{}, status int, w http.ResponseWriter, r *http.Request) error {
 	err, isErr := obj.(Error)
+	if isErr {
+		var language, requestID string
+		if r != nil {
+			language, requestID = r.Header.Get("Accept-Language"), r.Header.Get(RequestIDHeader)
+		}
+		err = err.Envelope(status, language, requestID)
+		obj = err
+	}
 	bytes, e := json.Marshal(obj)
 	if e != nil {
 		if isErr && err.Scope == InternalScope && err.Attribute == EncodeAttribute {
@@ -28,7 +28,7 @@ var badError = Error{Message: "unencodable error", Values: ErrorData{unencodable
 type badEncoderPuzzle string
 
 func (b badEncoderPuzzle) State() State {
-	return State{SudokuGeometryCode, 0, []int{}, nil}
+	return State{SudokuGeometryCode, 0, []int{}, nil, false}
 }
 
 func (b badEncoderPuzzle) Squares() []Square {
@@ -43,10 +43,41 @@ func (b badEncoderPuzzle) Assign(choice Choice) (Update, error) {
 	return Update{}, badError
 }
 
+func (b badEncoderPuzzle) MarkCandidate(choice Choice) (Update, error) {
+	return Update{}, badError
+}
+
+func (b badEncoderPuzzle) UnmarkCandidate(choice Choice) (Update, error) {
+	return Update{}, badError
+}
+
+func (b badEncoderPuzzle) IsProper() error {
+	return badError
+}
+
 func (b badEncoderPuzzle) Copy() Puzzle {
 	return b
 }
 
+func (b badEncoderPuzzle) Encoding() string {
+	return string(b)
+}
+
+func (b badEncoderPuzzle) Checkpoint() Tag {
+	return 0
+}
+
+func (b badEncoderPuzzle) Rollback(tag Tag) error {
+	return badError
+}
+
+func (b badEncoderPuzzle) Conflicts() []Conflict {
+	return nil
+}
+
+func (b badEncoderPuzzle) OnChange(f func(Event)) {
+}
+
 func newBadEncoder(values []int) (Puzzle, error) {
 	return badEncoderPuzzle(fmt.Sprint(values)), nil
 }
@@ -92,12 +123,14 @@ func TestPuzzleGetHandlers(t *testing.T) {
 			StateHandler,
 			SquaresHandler,
 			SolutionsHandler,
+			ConflictsHandler,
 		}
 		ostate, istate := State{}, p.State()
 		osquares, isquares := []Square{}, p.Squares()
 		osolns, isolns := []Solution{}, p.Solutions()
-		outputs := []interface{}{&ostate, &osquares, &osolns}
-		inputs := []interface{}{&istate, &isquares, &isolns}
+		oconflicts, iconflicts := []Conflict{}, append([]Conflict{}, p.Conflicts()...)
+		outputs := []interface{}{&ostate, &osquares, &osolns, &oconflicts}
+		inputs := []interface{}{&istate, &isquares, &isolns, &iconflicts}
 		for j, handler := range handlers {
 			handlerFunc := func(w http.ResponseWriter, r *http.Request) {
 				err := handler(p, w, r)
@@ -139,6 +172,7 @@ func TestGetHandlerErrors(t *testing.T) {
 		StateHandler,
 		SquaresHandler,
 		SolutionsHandler,
+		ConflictsHandler,
 	}
 	for _, handler := range handlers {
 		handlerFunc := func(w http.ResponseWriter, r *http.Request) {
@@ -409,3 +443,214 @@ func TestAssignHandlerErrors(t *testing.T) {
 	}
 	t.Logf("%s\n", b)
 }
+
+func TestAssignHandlerDecoding(t *testing.T) {
+	p, _ := New(append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...))
+	cases := []struct {
+		body   string
+		status int
+		field  string
+	}{
+		{`{"index": 1e99, "value": 2}`, http.StatusBadRequest, "index"},
+		{`{"index": 13, "value": "2"}`, http.StatusBadRequest, "value"},
+		{`{"index": 13, "value": 2, "x": "` + strings.Repeat(" ", MaxChoiceBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		if _, e := AssignHandler(p, w, httptest.NewRequest("POST", "/", strings.NewReader(c.body))); e == nil {
+			t.Errorf("Case %d: Assignment succeeded", i)
+		}
+		var err Error
+		if e := json.Unmarshal(w.Body.Bytes(), &err); e != nil || w.Code != c.status || err.Field != c.field {
+			t.Errorf("Case %d: Response was %d %s", i, w.Code, w.Body)
+		}
+	}
+	if s := p.Squares()[12]; s.Aval != 0 {
+		t.Errorf("Failed assignments changed square %+v", s)
+	}
+}
+
+func TestAssignBatchHandler(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p1, _ := New(givens)
+	p2, _ := New(givens)
+	before := p1.Squares()
+	cases := []struct {
+		choices []Choice
+		status  int
+	}{
+		{[]Choice{{13, 2}, {14, 2}}, http.StatusBadRequest},
+		{[]Choice{}, http.StatusBadRequest},
+		{[]Choice{{13, 2}, {10, 4}, {15, 4}}, http.StatusOK},
+	}
+	for i, c := range cases {
+		var updates []Update
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			updates, _ = AssignBatchHandler(p1, w, r)
+		}))
+		defer ts.Close()
+
+		bytes, err := json.Marshal(c.choices)
+		if err != nil {
+			t.Fatalf("Case %d: Failed to encode choices: %v", i, err)
+		}
+		r, e := http.Post(ts.URL, "application/json", strings.NewReader(string(bytes)))
+		if e != nil {
+			t.Fatalf("Case %d: Request error: %v", i, e)
+		}
+		b, e := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if e != nil {
+			t.Fatalf("Case %d: Read error on result: %v", i, e)
+		}
+		t.Logf("%s\n", b)
+		if r.StatusCode != c.status {
+			t.Errorf("Case %d: Status was %v, expected %v", i, r.StatusCode, c.status)
+		}
+		if c.status != http.StatusOK {
+			if after := p1.Squares(); !reflect.DeepEqual(after, before) {
+				t.Errorf("Case %d: Failed batch changed the puzzle", i)
+			}
+			continue
+		}
+		expected, _ := AssignAll(p2, c.choices)
+		if !reflect.DeepEqual(updates, expected) {
+			t.Errorf("Case %d: Updates were %+v, expected %+v", i, updates, expected)
+		}
+		var update Update
+		if e = json.Unmarshal(b, &update); e != nil {
+			t.Fatalf("Case %d: Unmarshal failed: %v", i, e)
+		}
+		if !reflect.DeepEqual(update, MergeUpdates(expected)) {
+			t.Errorf("Case %d: Update was %+v, expected %+v", i, update, MergeUpdates(expected))
+		}
+	}
+	if _, e := AssignBatchHandler(nil, httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil)); e == nil {
+		t.Errorf("Batch assign to nil puzzle didn't fail")
+	}
+}
+
+func TestMarkHandlers(t *testing.T) {
+	p, err := New(append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...))
+	if err != nil {
+		t.Fatalf("Failed to create initial puzzle: %v", err)
+	}
+	handlers := []func(Puzzle, http.ResponseWriter, *http.Request) (Update, error){
+		MarkHandler,
+		MarkHandler,
+		UnmarkHandler,
+	}
+	choices := []Choice{{2, 4}, {2, 1}, {2, 4}}
+	marks := []intset{{4}, {1, 4}, {1}}
+	for i, handler := range handlers {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := handler(p, w, r); err != nil {
+				t.Errorf("Case %d: handler failed: %v", i, err)
+			}
+		}))
+		defer ts.Close()
+
+		bytes, err := json.Marshal(choices[i])
+		if err != nil {
+			t.Fatalf("Case %d: Failed to encode choice: %v", i, err)
+		}
+		r, e := http.Post(ts.URL, "application/json", strings.NewReader(string(bytes)))
+		if e != nil {
+			t.Fatalf("Case %d: Request error: %v", i, e)
+		}
+		b, e := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if e != nil {
+			t.Fatalf("Case %d: Read error on result: %v", i, e)
+		}
+		t.Logf("%s\n", b)
+		var update Update
+		if e = json.Unmarshal(b, &update); e != nil {
+			t.Fatalf("Case %d: Unmarshal failed: %v", i, e)
+		}
+		if len(update.Squares) != 1 || !reflect.DeepEqual(update.Squares[0].Marks, marks[i]) {
+			t.Errorf("Case %d: Update was %+v, expected marks %v", i, update, marks[i])
+		}
+	}
+	if _, e := MarkHandler(nil, httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil)); e == nil {
+		t.Errorf("Mark of nil puzzle didn't fail")
+	}
+}
+
+func TestVerifyHandler(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, sixStarValues...)
+	lookup := func(f string) ([]int, bool) {
+		if f == Fingerprint(givens) {
+			return givens, true
+		}
+		return nil, false
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		VerifyHandler(lookup, w, r)
+	}))
+	defer ts.Close()
+
+	solution := append([]int{SudokuGeometryCode}, sixStarSolution.Values...)
+	claims := []Claim{
+		{Fingerprint(givens), solution},
+		{Fingerprint(givens), givens},
+		{"no-such-puzzle", solution},
+	}
+	statuses := []int{http.StatusOK, http.StatusOK, http.StatusNotFound}
+	valids := []bool{true, false, false}
+	for i, claim := range claims {
+		bytes, err := json.Marshal(claim)
+		if err != nil {
+			t.Fatalf("Case %d: Failed to encode claim: %v", i, err)
+		}
+		r, e := http.Post(ts.URL, "application/json", strings.NewReader(string(bytes)))
+		if e != nil {
+			t.Fatalf("Case %d: Request error: %v", i, e)
+		}
+		b, e := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if e != nil {
+			t.Fatalf("Case %d: Read error on result: %v", i, e)
+		}
+		t.Logf("%s\n", b)
+		if r.StatusCode != statuses[i] {
+			t.Errorf("Case %d: Status was %v, expected %v", i, r.StatusCode, statuses[i])
+			continue
+		}
+		var v Verification
+		if e = json.Unmarshal(b, &v); e != nil {
+			t.Fatalf("Case %d: Unmarshal failed: %v", i, e)
+		}
+		if v.Valid != valids[i] {
+			t.Errorf("Case %d: Verification was %+v, expected valid = %v", i, v, valids[i])
+		}
+	}
+}
+
+func TestErrorRequestID(t *testing.T) {
+	p, err := New(append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...))
+	if err != nil {
+		t.Fatalf("Failed to create initial puzzle: %v", err)
+	}
+	var got error
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, got = AssignHandler(p, w, r)
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"index": 14, "value": 2}`))
+	req.Header.Set(RequestIDHeader, "req-42")
+	r, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatalf("Request error: %v", e)
+	}
+	var sent Error
+	e = json.NewDecoder(r.Body).Decode(&sent)
+	r.Body.Close()
+	if e != nil || sent.RequestID != "req-42" {
+		t.Errorf("Sent error is %+v (%v)", sent, e)
+	}
+	if err, ok := got.(Error); !ok || err.RequestID != "req-42" {
+		t.Errorf("Returned error is %#v", got)
+	}
+}
@@ -2,6 +2,7 @@ package puzzle
 
 import (
 	"fmt"
+	"sync"
 )
 
 /*
@@ -61,6 +62,20 @@ solutions.  This algorithm can be easily adapted to find all such
 solutions by changing step 2 to save the solution and jump to
 step 4.
 
+Deep searches (on 16x16 grids, say, or when generating) save and
+restore the puzzle state at every node, so the solver reuses
+puzzle storage rather than leaving it to the garbage collector.
+When a choice has no choices left, or a search is over, its
+saved puzzles go into a pool of spare puzzles, from which the
+states saved by later choices are made, and when a choice is
+rewound, the failed puzzle is overwritten with the saved state
+rather than copying it anew.  The choices still to be tried are
+kept as a valset, so saving them doesn't allocate either.
+
+Finding all the solutions of a puzzle, and checking that it is
+proper, follow this thread on a flat grid of values when the
+puzzle's geometry allows it; see search.go.
+
 */
 
 // A choice is a puzzle, a square to choose, the choice to try
@@ -69,7 +84,44 @@ type choice struct {
 	puz    *puzzle
 	cindex int
 	cvalue int
-	cnext  intset
+	cnext  valset
+}
+
+// spares are pools of puzzles whose storage the solver can reuse,
+// by their number of squares.
+var spares sync.Map
+
+// sparePool returns the pool of spare puzzles with a number of
+// squares.
+func sparePool(scount int) *sync.Pool {
+	pool, ok := spares.Load(scount)
+	if !ok {
+		pool, _ = spares.LoadOrStore(scount, new(sync.Pool))
+	}
+	return pool.(*sync.Pool)
+}
+
+// spare returns a puzzle whose storage can be reused for a copy
+// of the given one, or nil if there isn't one.
+func spare(p *puzzle) *puzzle {
+	c, _ := sparePool(p.mapping.scount).Get().(*puzzle)
+	return c
+}
+
+// recycle puts a puzzle that's no longer used into the spares.
+func recycle(p *puzzle) {
+	if p != nil {
+		sparePool(p.mapping.scount).Put(p)
+	}
+}
+
+// release recycles the puzzles saved in a thread that's no longer
+// used.
+func (t thread) release() {
+	for i := range t {
+		recycle(t[i].puz)
+		t[i] = choice{}
+	}
 }
 
 // A thread is a stack of choices
@@ -80,6 +132,13 @@ type thread []choice
 // the next possible solution and returns the puzzle and stack at
 // time of solution (or unsolvable error).
 func solve(p *puzzle, t thread) (*puzzle, thread) {
+	return searchThread(p, t, nil)
+}
+
+// searchThread solves a puzzle just as solve does, counting the
+// choices it pushes with a searcher (if there is one).  If the
+// searcher is stopped, it returns with an empty thread.
+func searchThread(p *puzzle, t thread, s *searcher) (*puzzle, thread) {
 	for {
 		if len(p.errors) == 0 && assignKnown(p) {
 			return p, t
@@ -91,6 +150,9 @@ func solve(p *puzzle, t thread) (*puzzle, thread) {
 			}
 			continue
 		}
+		if !s.node() {
+			return p, nil
+		}
 		p, t = pushChoice(p, t)
 	}
 }
@@ -99,18 +161,81 @@ func solve(p *puzzle, t thread) (*puzzle, thread) {
 // puzzle is copied first, so it's not altered during the
 // solutions process
 func (p *puzzle) Solutions() []Solution {
+	return p.allSolutions(nil)
+}
+
+// allSolutions finds the solutions of a puzzle, telling a
+// searcher (if there is one) about each of them, and stopping
+// when it's stopped.  It searches on a grid when it can (see
+// search.go).
+func (p *puzzle) allSolutions(s *searcher) []Solution {
+	if g := newSearchGrid(p); g != nil {
+		solutions, _ := g.search(s, true, 0)
+		return solutions
+	}
+	return p.threadSolutions(s)
+}
+
+// threadSolutions finds the solutions of a puzzle just as
+// allSolutions does, but always with Ariadne's thread.
+func (p *puzzle) threadSolutions(s *searcher) []Solution {
 	var solutions []Solution
 	var t thread
-	for p, t = solve(p.copy(), t); len(p.errors) == 0; p, t = solve(p, t) {
+	for p, t = searchThread(p.copy(), t, s); len(p.errors) == 0 && !s.isStopped(); p, t = searchThread(p, t, s) {
 		solutions = append(solutions, newSolution(p, t))
+		if !s.solution() {
+			break
+		}
 		p, t = popChoice(p, t)
 		if len(t) == 0 {
 			break
 		}
 	}
+	t.release()
+	recycle(p)
 	return solutions
 }
 
+// IsProper checks that the puzzle has exactly one solution.
+// The search stops after the second solution, so the Error for
+// a puzzle with many solutions reports that 2 were found.
+func (p *puzzle) IsProper() error {
+	return p.isProper(nil)
+}
+
+// isProper checks that a puzzle is proper, counting its search
+// with a searcher (if there is one).  A stopped search is
+// improper.  It searches on a grid when it can (see search.go).
+func (p *puzzle) isProper(s *searcher) error {
+	count := 0
+	if g := newSearchGrid(p); g != nil {
+		_, count = g.search(s, false, 2)
+	} else if len(p.errors) == 0 {
+		var t thread
+		for p, t = searchThread(p.copy(), t, s); len(p.errors) == 0 && !s.isStopped(); p, t = searchThread(p, t, s) {
+			count++
+			if count > 1 {
+				break
+			}
+			p, t = popChoice(p, t)
+			if len(t) == 0 {
+				break
+			}
+		}
+		t.release()
+		recycle(p)
+	}
+	if count == 1 {
+		return nil
+	}
+	return Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: ImproperPuzzleCondition,
+		Values:    ErrorData{count},
+	}
+}
+
 // assignKnown takes a solvable puzzle and tries to solve it by
 // assigning all the known empty squares (bound to single-valued)
 // to their known value and then looping to see if those
@@ -127,9 +252,9 @@ func assignKnown(p *puzzle) bool {
 				if p.squares[i].bval != 0 {
 					known++
 					p.assign(i, p.squares[i].bval)
-				} else if len(p.squares[i].pvals) == 1 {
+				} else if p.squares[i].pvals.len() == 1 {
 					known++
-					p.assign(i, p.squares[i].pvals[0])
+					p.assign(i, p.squares[i].pvals.first())
 				} else {
 					unknown++
 				}
@@ -148,18 +273,22 @@ func assignKnown(p *puzzle) bool {
 }
 
 // popChoice resets a puzzle to the next choice after the current
-// choice in a thread has failed.  If there is no next choice,
-// the incoming puzzle is returned, along with the empty thread.
+// choice in a thread has failed.  The incoming puzzle's storage
+// is reused for the reset puzzle, so it must not be used after
+// the pop.  If there is no next choice, the incoming puzzle is
+// returned, along with the empty thread.
 func popChoice(p *puzzle, t thread) (*puzzle, thread) {
 	for len(t) > 0 {
 		top := &t[len(t)-1]
-		if len(top.cnext) == 0 {
+		if top.cnext.empty() {
+			recycle(top.puz)
 			*top = choice{} // release storage held in choice before pop
 			t = t[:len(t)-1]
 			continue
 		}
-		new := top.puz.copy()
-		top.cvalue, top.cnext = top.cnext[0], top.cnext[1:]
+		new := top.puz.copyInto(p)
+		top.cvalue = top.cnext.first()
+		top.cnext.remove(top.cvalue)
 		new.assign(top.cindex, top.cvalue) // errors handled by caller
 		return new, t
 	}
@@ -173,7 +302,7 @@ func pushChoice(p *puzzle, t thread) (*puzzle, thread) {
 	cindex, ccount := 0, p.mapping.sidelen+1
 	for i := 1; i <= p.mapping.scount; i++ {
 		if p.squares[i].aval == 0 && p.squares[i].bval == 0 {
-			count := len(p.squares[i].pvals)
+			count := p.squares[i].pvals.len()
 			if count == 2 {
 				cindex, ccount = i, 2
 				break
@@ -188,17 +317,16 @@ func pushChoice(p *puzzle, t thread) (*puzzle, thread) {
 		panic(fmt.Errorf("pushChoice called with no available choices"))
 	}
 	c := choice{
-		puz:    p.copy(),
+		puz:    p.copyInto(spare(p)),
 		cindex: cindex,
-		cvalue: p.squares[cindex].pvals[0],
-		cnext:  newIntsetCopy(p.squares[cindex].pvals[1:]),
+		cnext:  p.squares[cindex].pvals,
 	}
+	c.cvalue = c.cnext.first()
+	c.cnext.remove(c.cvalue)
+	// the choice is one of the square's possible values, but its
+	// consequences may still be contradictory, in which case the
+	// caller will find errors and pop the choice
 	p.assign(c.cindex, c.cvalue)
-	if len(p.errors) > 0 {
-		// can't happen: the choice was unacceptable for the square
-		panic(fmt.Errorf("Assign of %v to %+v failed: %v",
-			c.cvalue, *p.squares[cindex], p.errors))
-	}
 	return p, append(t, c)
 }
 
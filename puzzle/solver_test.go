@@ -2,6 +2,7 @@ package puzzle
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -261,11 +262,11 @@ func TestPopThread(t *testing.T) {
 	if e != nil {
 		t.Fatalf("TestPopThread: Failed to create puzzle: %v", e)
 	}
-	thin := thread{choice{pin, 2, 0, intset{2, 4}}} // artificial stack top
+	thin := thread{choice{pin.copy(), 2, 0, newValset(2, 4)}} // artificial stack top
 	p, th := popChoice(pin, thin)
-	if reflect.DeepEqual(p, pin) ||
+	if p != pin ||
 		len(th) != 1 || th[0].cindex != 2 ||
-		th[0].cvalue != 2 || !reflect.DeepEqual(th[0].cnext, intset{4}) {
+		th[0].cvalue != 2 || th[0].cnext != newValset(4) {
 		t.Errorf("TestPopThread: 1st popped stack top is wrong: %+v", th[0])
 	}
 	if !reflect.DeepEqual(p.allValues(), solveSimpleFirstValues) {
@@ -274,9 +275,9 @@ func TestPopThread(t *testing.T) {
 	}
 	pin, thin = p, th
 	p, th = popChoice(pin, thin)
-	if reflect.DeepEqual(p, pin) ||
+	if p != pin ||
 		len(th) != 1 || th[0].cindex != 2 ||
-		th[0].cvalue != 4 || !reflect.DeepEqual(th[0].cnext, intset{}) {
+		th[0].cvalue != 4 || !th[0].cnext.empty() {
 		t.Errorf("TestPopThread: 2nd popped stack top is wrong: %+v", th[0])
 	}
 	if !reflect.DeepEqual(p.allValues(), solveSimpleSecondValues) {
@@ -307,7 +308,7 @@ func TestPushThread(t *testing.T) {
 	}
 	if reflect.DeepEqual(p, th[0].puz) ||
 		th[0].cindex != 2 || th[0].cvalue != 2 ||
-		!reflect.DeepEqual(th[0].cnext, intset{4}) {
+		th[0].cnext != newValset(4) {
 		t.Errorf("TestPushThread: 1st pushed stack top is wrong: %+v", th[0])
 	}
 	if !reflect.DeepEqual(p.allValues(), solveSimpleFirstValues) {
@@ -325,7 +326,7 @@ func TestPushThread(t *testing.T) {
 	}
 	if reflect.DeepEqual(p, th[0].puz) ||
 		th[0].cindex != 1 || th[0].cvalue != 1 ||
-		!reflect.DeepEqual(th[0].cnext, intset{2, 3, 4}) {
+		th[0].cnext != newValset(2, 3, 4) {
 		t.Errorf("TestPushThread: 2nd pushed stack top is wrong: %+v", th[0])
 	}
 	if !reflect.DeepEqual(p.allValues(), empty4PuzzleAssign1Values) {
@@ -341,7 +342,7 @@ type solveTestcase struct {
 	elen   int
 	elasti int
 	elastv int
-	elastn intset
+	elastn valset
 }
 
 func TestSolve(t *testing.T) {
@@ -366,27 +367,27 @@ func TestSolve(t *testing.T) {
 	tcs := []solveTestcase{
 		solveTestcase{
 			oneStarValues, true, oneStarBoundValues,
-			0, 0, 0, nil,
+			0, 0, 0, valset{},
 		},
 		solveTestcase{
 			oneStarValues, true, oneStarBoundValues,
-			0, 0, 0, nil,
+			0, 0, 0, valset{},
 		},
 		solveTestcase{
 			sixStarValues, true, sixStarSolution.Values,
-			1, 2, 6, intset{},
+			1, 2, 6, valset{},
 		},
 		solveTestcase{
 			chronTwoValues, true, chronTwoSolution.Values,
-			1, 2, 5, intset{},
+			1, 2, 5, valset{},
 		},
 		solveTestcase{
 			solveSimpleStartValues, true, solveSimpleFirstCompleteValues,
-			1, 2, 2, intset{4},
+			1, 2, 2, newValset(4),
 		},
 		solveTestcase{
 			nil, true, solveSimpleSecondCompleteValues,
-			1, 2, 4, intset{},
+			1, 2, 4, valset{},
 		},
 	}
 	for i, tc := range tcs {
@@ -419,7 +420,7 @@ func TestSolve(t *testing.T) {
 			} else if tc.elen > 0 {
 				if th[tc.elen-1].cindex != tc.elasti ||
 					th[tc.elen-1].cvalue != tc.elastv ||
-					!reflect.DeepEqual(th[tc.elen-1].cnext, tc.elastn) {
+					th[tc.elen-1].cnext != tc.elastn {
 					t.Errorf("TestSolve case %d: Last choice is wrong: %+v",
 						i+1, th[tc.elen-1])
 				}
@@ -514,3 +515,195 @@ func TestSolutions(t *testing.T) {
 		}
 	}
 }
+
+// contradictoryChoiceValues is a 16x16 puzzle where the solver's
+// first choice has contradictory consequences.
+var contradictoryChoiceValues = []int{
+	3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 6, 0, 0, 0,
+	9, 6, 0, 4, 0, 0, 14, 0, 15, 10, 0, 8, 0, 5, 0, 2,
+	0, 11, 10, 0, 0, 0, 16, 0, 13, 6, 4, 9, 0, 14, 0, 0,
+	0, 0, 0, 2, 0, 0, 0, 6, 3, 14, 0, 0, 10, 15, 0, 0,
+	0, 5, 0, 0, 4, 6, 0, 0, 0, 3, 14, 12, 0, 8, 0, 0,
+	4, 13, 9, 0, 1, 0, 0, 0, 0, 0, 0, 11, 0, 7, 0, 0,
+	1, 0, 0, 12, 8, 0, 0, 10, 7, 5, 0, 0, 0, 9, 4, 6,
+	0, 10, 0, 11, 0, 0, 0, 0, 0, 0, 6, 4, 14, 0, 1, 12,
+	16, 7, 0, 5, 6, 13, 0, 0, 0, 0, 0, 0, 8, 0, 10, 0,
+	12, 3, 1, 0, 0, 0, 8, 15, 2, 0, 0, 16, 9, 0, 0, 13,
+	0, 0, 4, 0, 12, 0, 0, 0, 0, 0, 0, 10, 0, 2, 16, 5,
+	0, 0, 8, 0, 2, 16, 7, 0, 0, 0, 13, 6, 0, 0, 12, 0,
+	0, 0, 6, 9, 0, 0, 12, 1, 10, 0, 0, 0, 2, 0, 0, 0,
+	0, 0, 11, 0, 16, 5, 2, 7, 0, 4, 0, 0, 0, 12, 14, 0,
+	5, 0, 16, 0, 13, 0, 6, 4, 0, 12, 0, 0, 11, 0, 15, 8,
+	0, 0, 0, 3, 0, 15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9,
+}
+
+func TestIsProper(t *testing.T) {
+	bad := append([]int(nil), oneStarValues...)
+	bad[1] = bad[0]
+	tcs := []struct {
+		values []int
+		count  int // 1 means proper
+	}{
+		{oneStarValues, 1},
+		{sixStarValues, 1},
+		{chronTwoValues, 1},
+		{solveSimpleStartValues, 2},
+		{multiChoiceStartValues, 2},
+		{contradictoryChoiceValues, 1},
+		{bad, 0},
+	}
+	for i, tc := range tcs {
+		p, e := helperNewSudokuPuzzle(tc.values)
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		e = p.IsProper()
+		if tc.count == 1 {
+			if e != nil {
+				t.Errorf("test %d: proper puzzle gave %v", i+1, e)
+			}
+			continue
+		}
+		err, ok := e.(Error)
+		if !ok || err.Condition != ImproperPuzzleCondition || err.Values[0] != tc.count {
+			t.Errorf("test %d: got %v, expected improper with %d solutions", i+1, e, tc.count)
+		}
+	}
+}
+
+func TestSolverReuse(t *testing.T) {
+	// searches of different sizes, at the same time, share
+	// spare puzzles without seeing each other's
+	var ps []*puzzle
+	var expected [][]Solution
+	for _, vals := range [][]int{sixStarValues, chronTwoValues, solveSimpleStartValues, helperSixteenValues()} {
+		p, e := helperNewSudokuPuzzle(vals)
+		if e != nil {
+			t.Fatalf("Failed to create puzzle: %v", e)
+		}
+		ps, expected = append(ps, p), append(expected, p.Solutions())
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				i := (g + n) % len(ps)
+				if got := ps[i].Solutions(); !reflect.DeepEqual(got, expected[i]) {
+					t.Errorf("Search %d of puzzle %d found %v, expected %v", n, i, got, expected[i])
+					return
+				}
+				if e := ps[i].IsProper(); (e == nil) != (len(expected[i]) == 1) {
+					t.Errorf("Search %d of puzzle %d found it proper: %v", n, i, e)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestGridSearch(t *testing.T) {
+	// grid searches find what the thread finds, in the same order,
+	// after the same number of nodes, on every geometry they run on,
+	// whether or not they're stopped (after 3 solutions)
+	dudoku := make([]int, 36)
+	for r := 0; r < 6; r++ {
+		for c := 0; c < 6; c++ {
+			if (r+c)%2 == 0 {
+				dudoku[r*6+c] = (r*3+r/2+c)%6 + 1
+			}
+		}
+	}
+	samurai := helperSamuraiValues(func(r, c int) bool { return (r+2*c)%3 == 0 })
+	tcs := []struct {
+		code   int
+		values []int
+	}{
+		{SudokuGeometryCode, sixStarValues},
+		{SudokuGeometryCode, fiveStarValues},
+		{SudokuGeometryCode, multiChoiceStartValues},
+		{SudokuGeometryCode, contradictoryChoiceValues},
+		{SudokuGeometryCode, helperSixteenValues()},
+		{XSudokuGeometryCode, xSudokuValues},
+		{HyperSudokuGeometryCode, hyperSudokuValues},
+		{DudokuGeometryCode, dudoku},
+		{SamuraiGeometryCode, samurai},
+	}
+	for i, tc := range tcs {
+		pi, e := New(append([]int{tc.code}, tc.values...))
+		if e != nil {
+			t.Fatalf("test %d: Failed to create puzzle: %v", i+1, e)
+		}
+		p := pi.(*puzzle)
+		if newSearchGrid(p) == nil {
+			t.Fatalf("test %d: Puzzle can't be searched on a grid", i+1)
+		}
+		for _, stop := range []int{0, 3} {
+			watch := func(pr Progress) bool { return stop == 0 || pr.Solutions < stop }
+			gs, ts := &searcher{watch: watch}, &searcher{watch: watch}
+			grid, thread := p.allSolutions(gs), p.threadSolutions(ts)
+			if !reflect.DeepEqual(grid, thread) {
+				t.Errorf("test %d: grid found %v, thread found %v", i+1, grid, thread)
+			}
+			if gs.progress != ts.progress || gs.stopped != ts.stopped {
+				t.Errorf("test %d: grid progress %+v, thread progress %+v", i+1, gs.progress, ts.progress)
+			}
+		}
+	}
+
+	// puzzles with Errors, or with cages, are searched on the thread
+	bad := append([]int(nil), oneStarValues...)
+	bad[1] = bad[0]
+	if p, _ := helperNewSudokuPuzzle(bad); newSearchGrid(p) != nil {
+		t.Errorf("Puzzle with errors can be searched on a grid")
+	}
+	if p, _ := New(append([]int{testKillerGeometryCode}, make([]int, 16)...)); newSearchGrid(p.(*puzzle)) != nil {
+		t.Errorf("Killer puzzle can be searched on a grid")
+	}
+}
+
+func BenchmarkSolutions(b *testing.B) {
+	six, e := helperNewSudokuPuzzle(sixStarValues)
+	if e != nil {
+		b.Fatalf("Creation of sixStar puzzle failed: %s", e.Error())
+	}
+	five, e := helperNewSudokuPuzzle(fiveStarValues)
+	if e != nil {
+		b.Fatalf("Creation of fiveStar puzzle failed: %s", e.Error())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		six.Solutions()
+		five.Solutions()
+	}
+}
+
+// helperSixteenValues makes a 16x16 puzzle by blanking every
+// third and every seventh square of a patterned grid.
+func helperSixteenValues() []int {
+	vals := make([]int, 256)
+	for r := 0; r < 16; r++ {
+		for c := 0; c < 16; c++ {
+			vals[r*16+c] = (r*4+r/4+c)%16 + 1
+		}
+	}
+	for i := range vals {
+		if i%3 == 0 || i%7 == 0 {
+			vals[i] = 0
+		}
+	}
+	return vals
+}
+
+func BenchmarkIsProperSixteen(b *testing.B) {
+	p, e := helperNewSudokuPuzzle(helperSixteenValues())
+	if e != nil {
+		b.Fatalf("Creation of 16x16 puzzle failed: %s", e.Error())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.IsProper()
+	}
+}
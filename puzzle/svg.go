@@ -0,0 +1,227 @@
+package puzzle
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+/*
+
+SVG rendering
+
+A puzzle renders as an SVG image of its grid: thin lines between
+squares, thick lines around tiles, the square values centered in
+their squares, and (optionally) small candidate values in the
+empty squares, laid out like a telephone keypad.  Givens are
+drawn darker than entries, when the givens are known.  The
+squares in the extra groups of variant puzzles are shaded.  When
+it's known who filled the squares (say on a shared board), each
+player's entries are drawn in their own color, with a key of
+the players' names under the grid.
+
+*/
+
+// SVGOptions controls the rendering of an SVG image.  Givens, if
+// given, are the puzzle's geometry code and givens (in the same
+// form passed to New), and values that are given are drawn as
+// givens.  Marks pencils in each empty square's marks, and
+// Possibles pencils in the possible values of empty squares that
+// don't have marks.  Players, if given, are the names of the
+// players who filled the squares, and Filled gives the player of
+// each square (by index from 1, as in Givens): 0 for no one, or
+// n for the nth player.
+type SVGOptions struct {
+	SquareSize int // pixels; 0 means 40
+	Givens     []int
+	Marks      bool
+	Possibles  bool
+	Players    []string
+	Filled     []int
+}
+
+// playerColors are the colors of the entries of each player, in
+// turn; entries are in the first color when the players aren't
+// known.
+var playerColors = []string{"#1c5fb8", "#b8321c", "#1c8a3a", "#8a1cb8", "#b8861c", "#1c8ab8"}
+
+// defaultSquareSize is the square size used when none is given.
+const defaultSquareSize = 40
+
+// A layout is what's drawn in each square of a puzzle's grid,
+// indexed from 0.  Squares in different tiles (by tile number)
+// are separated by thick lines, and shaded squares are in extra
+// groups.
+type layout struct {
+	sidelen    int
+	values     []int
+	givens     []bool
+	candidates [][]int
+	tiles      []int
+	shaded     []bool
+}
+
+// newLayout works out the layout of a puzzle.
+func newLayout(p Puzzle, givens []int, marks, possibles bool) layout {
+	state := p.State()
+	count := len(state.Values)
+	l := layout{
+		sidelen:    state.SideLenth,
+		values:     state.Values,
+		givens:     make([]bool, count),
+		candidates: make([][]int, count),
+		tiles:      make([]int, count),
+		shaded:     make([]bool, count),
+	}
+	for i, v := range state.Values {
+		l.givens[i] = v != 0 && (givens == nil || (i+1 < len(givens) && givens[i+1] == v))
+	}
+	if marks || possibles {
+		for i, s := range p.Squares() {
+			if s.Aval != 0 {
+				continue
+			}
+			if marks && len(s.Marks) > 0 {
+				l.candidates[i] = s.Marks
+			} else if possibles {
+				l.candidates[i] = s.Pvals
+			}
+		}
+	}
+	if m := tileMapping(state.Geometry, count); m != nil {
+		for i := range l.tiles {
+			for _, g := range m.ixmap[i+1] {
+				switch m.gdescs[g].id.Gtype {
+				case GtypeTile:
+					l.tiles[i] = g
+				case GtypeDiagonal, GtypeHyper:
+					l.shaded[i] = true
+				}
+			}
+		}
+	}
+	return l
+}
+
+// tileMapping returns the mapping of the built-in, variant, or
+// killer geometry with the given code and square count, or nil if there
+// isn't one.
+func tileMapping(geometry, count int) *puzzleMapping {
+	var m *puzzleMapping
+	switch geometry {
+	case SudokuGeometryCode:
+		m, _ = squarePuzzleMapping(count)
+	case DudokuGeometryCode:
+		m, _ = rectanglePuzzleMapping(count)
+	case XSudokuGeometryCode, HyperSudokuGeometryCode, HyperXSudokuGeometryCode:
+		m, _ = variantPuzzleMapping(byte(geometry), count)
+	default:
+		if km := killerMapping(geometry); km != nil && km.scount == count {
+			m = km
+		}
+	}
+	return m
+}
+
+// thickRight and thickBelow tell whether the line to the right
+// of (or below) a square is a tile boundary.
+func (l layout) thickRight(i int) bool {
+	return (i+1)%l.sidelen != 0 && l.tiles[i] != l.tiles[i+1]
+}
+
+func (l layout) thickBelow(i int) bool {
+	return i+l.sidelen < len(l.tiles) && l.tiles[i] != l.tiles[i+l.sidelen]
+}
+
+// candidateGrid returns how many candidates go on each side of
+// the small grid they're pencilled into.
+func (l layout) candidateGrid() int {
+	n, exact := findIntSquareRoot(l.sidelen)
+	if !exact {
+		n++
+	}
+	return n
+}
+
+// RenderSVG writes an SVG image of the puzzle's current state.
+func RenderSVG(w io.Writer, p Puzzle, opts SVGOptions) error {
+	size := opts.SquareSize
+	if size <= 0 {
+		size = defaultSquareSize
+	}
+	l := newLayout(p, opts.Givens, opts.Marks, opts.Possibles)
+	margin := size / 10
+	if margin < 2 {
+		margin = 2
+	}
+	side := l.sidelen*size + 2*margin
+	height, keyLine := side, size/2
+	if len(opts.Players) > 0 {
+		height += len(opts.Players)*keyLine + margin
+	}
+	thin, thick := float64(size)/40, float64(size)/13
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		side, height, side, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", side, height)
+	for i, shaded := range l.shaded {
+		if shaded {
+			x, y := margin+(i%l.sidelen)*size, margin+(i/l.sidelen)*size
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#e4e4e4"/>`+"\n", x, y, size, size)
+		}
+	}
+	fmt.Fprintf(&b, `<g stroke="black" stroke-linecap="square">`+"\n")
+	for i := range l.values {
+		x, y := margin+(i%l.sidelen)*size, margin+(i/l.sidelen)*size
+		if (i+1)%l.sidelen != 0 {
+			width := thin
+			if l.thickRight(i) {
+				width = thick
+			}
+			fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke-width="%.2f"/>`+"\n",
+				x+size, y, x+size, y+size, width)
+		}
+		if i+l.sidelen < len(l.values) {
+			width := thin
+			if l.thickBelow(i) {
+				width = thick
+			}
+			fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke-width="%.2f"/>`+"\n",
+				x, y+size, x+size, y+size, width)
+		}
+	}
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke-width="%.2f"/>`+"\n",
+		margin, margin, l.sidelen*size, l.sidelen*size, thick)
+	fmt.Fprintf(&b, "</g>\n")
+	fmt.Fprintf(&b, `<g font-family="sans-serif" text-anchor="middle" dominant-baseline="central">`+"\n")
+	grid := l.candidateGrid()
+	for i, v := range l.values {
+		x, y := margin+(i%l.sidelen)*size, margin+(i/l.sidelen)*size
+		if v != 0 {
+			fill, weight := playerColors[0], "normal"
+			if l.givens[i] {
+				fill, weight = "black", "bold"
+			} else if i+1 < len(opts.Filled) && opts.Filled[i+1] > 0 {
+				fill = playerColors[(opts.Filled[i+1]-1)%len(playerColors)]
+			}
+			fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d" font-weight="%s" fill="%s">%s</text>`+"\n",
+				x+size/2, y+size/2, size*3/5, weight, fill, vstr(v))
+			continue
+		}
+		cell := float64(size) / float64(grid)
+		for _, c := range l.candidates[i] {
+			cx := float64(x) + cell*(float64((c-1)%grid)+0.5)
+			cy := float64(y) + cell*(float64((c-1)/grid)+0.5)
+			fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="%.1f" fill="#555">%s</text>`+"\n",
+				cx, cy, cell*0.7, vstr(c))
+		}
+	}
+	for n, name := range opts.Players {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d" text-anchor="start" fill="%s">%s</text>`+"\n",
+			margin, side+n*keyLine+keyLine/2, size*2/5, playerColors[n%len(playerColors)], html.EscapeString(name))
+	}
+	fmt.Fprintf(&b, "</g>\n</svg>\n")
+	_, e := io.WriteString(w, b.String())
+	return e
+}
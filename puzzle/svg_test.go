@@ -0,0 +1,91 @@
+package puzzle
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRenderSVG(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, oneStarValues...)
+	p, _ := New(givens)
+	filled := 0
+	for _, v := range oneStarValues {
+		if v != 0 {
+			filled++
+		}
+	}
+	choice := Choice{Index: 2, Value: p.Solutions()[0].Values[1]}
+	p.Assign(choice)
+	p.MarkCandidate(Choice{Index: 3, Value: 1})
+
+	render := func(opts SVGOptions) string {
+		var b strings.Builder
+		if e := RenderSVG(&b, p, opts); e != nil {
+			t.Fatalf("RenderSVG(%+v) failed: %v", opts, e)
+		}
+		// it must be well-formed XML
+		d := xml.NewDecoder(strings.NewReader(b.String()))
+		for {
+			if _, e := d.Token(); e != nil {
+				if e != io.EOF {
+					t.Fatalf("RenderSVG(%+v) isn't XML: %v", opts, e)
+				}
+				break
+			}
+		}
+		return b.String()
+	}
+
+	svg := render(SVGOptions{})
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="368" height="368"`) {
+		t.Errorf("SVG starts %q", svg[:80])
+	}
+	// tile boundaries are thick: 2 lines of 9 squares each way,
+	// plus the border
+	if n := strings.Count(svg, `stroke-width="3.08"`); n != 37 {
+		t.Errorf("SVG has %d thick lines", n)
+	}
+	if n := strings.Count(svg, `font-weight="bold"`); n != filled+1 {
+		t.Errorf("SVG without givens has %d bold values, not %d", n, filled+1)
+	}
+	svg = render(SVGOptions{SquareSize: 20, Givens: givens})
+	if n := strings.Count(svg, `font-weight="bold"`); n != filled || strings.Count(svg, `font-weight="normal"`) != 1 {
+		t.Errorf("SVG with givens has %d bold values, not %d", n, filled)
+	}
+	if strings.Contains(svg, `fill="#555"`) {
+		t.Errorf("SVG without candidates has candidates")
+	}
+	if svg = render(SVGOptions{Marks: true}); strings.Count(svg, `fill="#555"`) != 1 {
+		t.Errorf("SVG with marks has %d candidates", strings.Count(svg, `fill="#555"`))
+	}
+	possibles := 0
+	for _, s := range p.Squares() {
+		if len(s.Marks) == 0 {
+			possibles += len(s.Pvals)
+		}
+	}
+	if svg = render(SVGOptions{Marks: true, Possibles: true}); strings.Count(svg, `fill="#555"`) != possibles+1 {
+		t.Errorf("SVG with candidates has %d, not %d", strings.Count(svg, `fill="#555"`), possibles+1)
+	}
+
+	// entries are in their players' colors, with a key
+	filledBy := make([]int, len(givens))
+	filledBy[choice.Index] = 2
+	svg = render(SVGOptions{Givens: givens, Players: []string{"ann", "bo & co"}, Filled: filledBy})
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="368" height="412"`) ||
+		strings.Count(svg, `fill="`+playerColors[1]+`"`) != 2 || !strings.Contains(svg, ">bo &amp; co</text>") ||
+		strings.Count(svg, `fill="`+playerColors[0]+`"`) != 1 {
+		t.Errorf("SVG with players is %q", svg)
+	}
+
+	// contest puzzles render their entries
+	c, _ := NewContest(givens)
+	c.Assign(choice)
+	var b strings.Builder
+	if e := RenderSVG(&b, c, SVGOptions{Givens: givens, Possibles: true}); e != nil ||
+		strings.Count(b.String(), `font-weight="normal"`) != 1 || strings.Contains(b.String(), `fill="#555"`) {
+		t.Errorf("Contest SVG is %q, %v", b.String(), e)
+	}
+}
@@ -0,0 +1,271 @@
+package puzzle
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+/*
+
+Symbols
+
+Symbol puzzles (such as wordoku) show their values as symbols
+of the player's choosing, instead of digits.  A SymbolTable
+gives the symbol for each value, and turns what players type
+back into values, so that input can be checked against the
+table on the server rather than trusted from the client.
+
+Each symbol is a single grapheme: a character and the combining
+marks that follow it, or an emoji with its modifiers, joined
+parts, or flag letters.  Symbols and input are compared after
+normalization, which trims surrounding space, composes letters
+with the combining marks that follow them into their
+precomposed forms (for the Latin, Greek, and Cyrillic letters
+that have them, as in Unicode NFC), and folds case, so "e" with
+a combining acute accent matches "É".  Input that isn't a
+single grapheme, or doesn't match a symbol, gets an Error that
+says which.
+
+*/
+
+// A SymbolTable gives the symbols of a puzzle's values: value v
+// is the (v-1)th symbol.
+type SymbolTable struct {
+	symbols []string
+	values  map[string]int // by normalized symbol
+}
+
+// NewSymbolTable returns the table of the given symbols, one for
+// each value.  It's an error for a symbol not to be a single
+// grapheme, or for two symbols to be the same once they're
+// normalized.
+func NewSymbolTable(symbols []string) (*SymbolTable, error) {
+	if len(symbols) == 0 {
+		return nil, symbolError(EmptyArgumentCondition)
+	}
+	t := &SymbolTable{make([]string, len(symbols)), make(map[string]int)}
+	for i, s := range symbols {
+		key, e := symbolKey(s)
+		if e != nil {
+			return nil, e
+		}
+		if v, ok := t.values[key]; ok {
+			return nil, symbolError(DuplicateSymbolCondition, s, symbols[v-1])
+		}
+		t.symbols[i], t.values[key] = strings.TrimSpace(s), i+1
+	}
+	return t, nil
+}
+
+// Symbols returns the table's symbols, in value order.
+func (t *SymbolTable) Symbols() []string {
+	return append([]string(nil), t.symbols...)
+}
+
+// Symbol returns the symbol for a value, or the empty string if
+// the value has none.
+func (t *SymbolTable) Symbol(v int) string {
+	if v < 1 || v > len(t.symbols) {
+		return ""
+	}
+	return t.symbols[v-1]
+}
+
+// Value returns the value of the symbol that input matches.
+func (t *SymbolTable) Value(input string) (int, error) {
+	key, e := symbolKey(input)
+	if e != nil {
+		return 0, e
+	}
+	v, ok := t.values[key]
+	if !ok {
+		return 0, symbolError(UnknownSymbolCondition, input, t.symbols)
+	}
+	return v, nil
+}
+
+// A SymbolChoice assigns the value of a symbol to a cell.  The
+// cell is referred to by its index.
+type SymbolChoice struct {
+	Index  int    `json:"index"`
+	Symbol string `json:"symbol"`
+}
+
+// Choice returns the choice of a symbol's value.
+func (t *SymbolTable) Choice(sc SymbolChoice) (Choice, error) {
+	v, e := t.Value(sc.Symbol)
+	if e != nil {
+		return Choice{}, e
+	}
+	return Choice{sc.Index, v}, nil
+}
+
+// AssignSymbolHandler is a POST handler that assigns a posted
+// SymbolChoice to a puzzle, using the value its symbol has in the
+// table.  Input that doesn't match a symbol gets a 400 response
+// with the symbol Error, which is also returned to the caller.
+// Decoding, encoding, and error handling are otherwise the same
+// as for AssignHandler.
+func AssignSymbolHandler(p Puzzle, t *SymbolTable, w http.ResponseWriter, r *http.Request) (Update, error) {
+	if p == nil {
+		return Update{},
+			writeError(noPuzzleError, ErrorData{r.URL.Path, "No puzzle"}, w, r)
+	}
+	sc, e := DecodeSymbolChoice(r.Body)
+	if e != nil {
+		return Update{}, writeDecodingError(e, w, r)
+	}
+	var choice Choice
+	if t == nil {
+		e = symbolError(GeneralCondition, sc.Symbol, "Puzzle has no symbols")
+	} else {
+		choice, e = t.Choice(sc)
+	}
+	var update Update
+	if e == nil {
+		update, e = p.Assign(choice)
+	}
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			return Update{},
+				writeError(errorFormatError, ErrorData{"AssignSymbolHandler", e.Error()}, w, r)
+		}
+		err.Message = err.Error()
+		return Update{}, writeJSON(err, http.StatusBadRequest, w, r)
+	}
+	return update, writeJSON(update, http.StatusOK, w, r)
+}
+
+// symbolError returns an Error for a symbol, with the given
+// condition and values (starting with the symbol, if there is
+// one).
+func symbolError(cond ErrorCondition, values ...interface{}) Error {
+	if len(values) == 0 {
+		return Error{
+			Scope:     ArgumentScope,
+			Structure: AttributeStructure,
+			Attribute: SymbolAttribute,
+			Condition: cond,
+		}
+	}
+	return Error{
+		Scope:     ArgumentScope,
+		Structure: AttributeValueStructure,
+		Attribute: SymbolAttribute,
+		Condition: cond,
+		Values:    ErrorData(values),
+	}
+}
+
+// symbolKey returns the normalized form of a symbol, which is an
+// Error if it isn't a single grapheme.
+func symbolKey(s string) (string, error) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return "", symbolError(EmptyArgumentCondition)
+	}
+	var runes []rune
+	joining := false // after a zero width joiner
+	flag := false    // after the first of a pair of regional indicators
+	graphemes := 0
+	for _, r := range trimmed {
+		switch {
+		case unicode.Is(unicode.M, r):
+			if n := len(runes); n > 0 {
+				if c, ok := composeRune(runes[n-1], r); ok {
+					runes[n-1] = foldRune(c)
+					continue
+				}
+			}
+		case r == zeroWidthJoiner:
+			joining = true
+		case joining:
+			joining = false
+		case r >= 0x1f3fb && r <= 0x1f3ff: // emoji skin tones
+		case r >= 0x1f1e6 && r <= 0x1f1ff: // regional indicators
+			if flag = !flag; flag {
+				graphemes++
+			}
+		default:
+			graphemes++
+		}
+		runes = append(runes, foldRune(r))
+	}
+	if graphemes != 1 {
+		return "", symbolError(NotOneSymbolCondition, s, graphemes)
+	}
+	return string(runes), nil
+}
+
+// zeroWidthJoiner joins the characters on either side of it into
+// one grapheme, as in many emoji.
+const zeroWidthJoiner = '\u200d'
+
+// foldRune returns the representative of a rune's case: the
+// smallest rune it's equivalent to under simple case folding.
+func foldRune(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// composeRune returns the precomposed form of a character with a
+// combining mark, if it has one.  (Case is folded after
+// composition, so the character's case doesn't matter.)
+func composeRune(base, mark rune) (rune, bool) {
+	pairs := []rune(compositions[mark])
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == base {
+			return pairs[i+1], true
+		}
+	}
+	return 0, false
+}
+
+// compositions are the canonical compositions of the Latin,
+// Greek, and Cyrillic letters (up to U+04FF) with the combining
+// diacritical marks: for each mark, pairs of a character and the
+// character it composes to, taken from the Unicode Character
+// Database.
+var compositions = map[rune]string{
+	// combining grave accent
+	'\u0300': "AÀEÈIÌOÒUÙaàeèiìoòuùÜǛüǜNǸnǹЕЀИЍеѐиѝ",
+	// combining acute accent
+	'\u0301': "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕSŚsśZŹzźÜǗüǘGǴgǵÅǺåǻÆǼæǽØǾøǿ¨΅ΑΆΕΈΗΉΙΊΟΌΥΎΩΏϊΐαάεέηήιίϋΰοόυύωώϒϓГЃКЌгѓкќ",
+	// combining circumflex accent
+	'\u0302': "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝWŴwŵYŶyŷ",
+	// combining tilde
+	'\u0303': "AÃNÑOÕaãnñoõIĨiĩUŨuũ",
+	// combining macron
+	'\u0304': "AĀaāEĒeēIĪiīOŌoōUŪuūÜǕüǖÄǞäǟȦǠȧǡÆǢæǣǪǬǫǭÖȪöȫÕȬõȭȮȰȯȱYȲyȳИӢиӣУӮуӯ",
+	// combining breve
+	'\u0306': "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭУЎИЙийуўЖӁжӂАӐаӑЕӖеӗ",
+	// combining dot above
+	'\u0307': "CĊcċEĖeėGĠgġIİZŻzżAȦaȧOȮoȯ",
+	// combining diaeresis
+	'\u0308': "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸΙΪΥΫιϊυϋϒϔЕЁІЇеёіїАӒаӓӘӚәӛЖӜжӝЗӞзӟИӤиӥОӦоӧӨӪөӫЭӬэӭУӰуӱЧӴчӵЫӸыӹ",
+	// combining ring above
+	'\u030a': "AÅaåUŮuů",
+	// combining double acute accent
+	'\u030b': "OŐoőUŰuűУӲуӳ",
+	// combining caron
+	'\u030c': "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzžAǍaǎIǏiǐOǑoǒUǓuǔÜǙüǚGǦgǧKǨkǩƷǮʒǯjǰHȞhȟ",
+	// combining double grave accent
+	'\u030f': "AȀaȁEȄeȅIȈiȉOȌoȍRȐrȑUȔuȕѴѶѵѷ",
+	// combining inverted breve
+	'\u0311': "AȂaȃEȆeȇIȊiȋOȎoȏRȒrȓUȖuȗ",
+	// combining horn
+	'\u031b': "OƠoơUƯuư",
+	// combining comma below
+	'\u0326': "SȘsșTȚtț",
+	// combining cedilla
+	'\u0327': "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţEȨeȩ",
+	// combining ogonek
+	'\u0328': "AĄaąEĘeęIĮiįUŲuųOǪoǫ",
+}
@@ -0,0 +1,121 @@
+package puzzle
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSymbolTable(t *testing.T) {
+	table, e := NewSymbolTable([]string{"É", "n", "Ø", "ß", "Й", "ά", "👍🏽", "👩‍💻", " x ", "🇫🇷"})
+	if e != nil {
+		t.Fatalf("Failed to create symbol table: %v", e)
+	}
+	if s := table.Symbol(9); s != "x" || table.Symbol(11) != "" || len(table.Symbols()) != 10 {
+		t.Errorf("Symbol 9 is %q, and there are %d symbols", s, len(table.Symbols()))
+	}
+	for i, tc := range []struct {
+		input string
+		value int
+	}{
+		{"É", 1},
+		{"é", 1},
+		{"é", 1},
+		{"É ", 1},
+		{"N", 2},
+		{"ø", 3},
+		{"ß", 4},
+		{"й", 5},
+		{"Ά", 6},
+		{"ά", 6},
+		{"👍🏽", 7},
+		{"👩‍💻", 8},
+		{"X", 9},
+		{"🇫🇷", 10},
+	} {
+		if v, e := table.Value(tc.input); e != nil || v != tc.value {
+			t.Errorf("test %d: %q has value %d, %v", i+1, tc.input, v, e)
+		}
+	}
+	for i, tc := range []struct {
+		input     string
+		condition ErrorCondition
+	}{
+		{"", EmptyArgumentCondition},
+		{"  ", EmptyArgumentCondition},
+		{"e", UnknownSymbolCondition},
+		{"y", UnknownSymbolCondition},
+		{"́", NotOneSymbolCondition},
+		{"ÉN", NotOneSymbolCondition},
+		{"👩💻", NotOneSymbolCondition},
+		{"🇫🇷🇫", NotOneSymbolCondition},
+	} {
+		_, e := table.Value(tc.input)
+		if err, ok := e.(Error); !ok || err.Condition != tc.condition || err.Attribute != SymbolAttribute {
+			t.Errorf("test %d: %q gave error %v", i+1, tc.input, e)
+		}
+	}
+
+	// symbols have to be graphemes, and distinct
+	for i, symbols := range [][]string{
+		nil,
+		{"A", "B", "ab"},
+		{"A", "B", "a"},
+		{"é", "é"},
+	} {
+		if _, e := NewSymbolTable(symbols); e == nil {
+			t.Errorf("test %d: symbol table %q was created", i+1, symbols)
+		}
+	}
+}
+
+func TestAssignSymbolHandler(t *testing.T) {
+	table, _ := NewSymbolTable([]string{"A", "B", "C", "D"})
+	values := []int{
+		1, 0, 3, 0,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	assign := func(p Puzzle, t *SymbolTable, sc SymbolChoice) (*httptest.ResponseRecorder, Update, error) {
+		body, _ := json.Marshal(sc)
+		w := httptest.NewRecorder()
+		update, e := AssignSymbolHandler(p, t, w, httptest.NewRequest("POST", "/api/assign-symbol/", bytes.NewReader(body)))
+		return w, update, e
+	}
+
+	p, _ := helperNewSudokuPuzzle(values)
+	w, update, e := assign(p, table, SymbolChoice{2, "b"})
+	if e != nil || w.Code != http.StatusOK || len(update.Squares) == 0 || p.State().Values[1] != 2 {
+		t.Errorf("Assigning a symbol gave status %d, update %+v, error %v", w.Code, update, e)
+	}
+	var err Error
+	w, _, e = assign(p, table, SymbolChoice{4, "Z"})
+	if json.Unmarshal(w.Body.Bytes(), &err); e == nil || w.Code != http.StatusBadRequest ||
+		err.Condition != UnknownSymbolCondition || err.Message == "" {
+		t.Errorf("Assigning an unknown symbol gave status %d, error %+v", w.Code, err)
+	}
+	w, _, e = assign(p, table, SymbolChoice{17, "C"})
+	if e == nil || w.Code != http.StatusBadRequest {
+		t.Errorf("Assigning a symbol off the board gave status %d", w.Code)
+	}
+	w, _, e = assign(p, nil, SymbolChoice{4, "D"})
+	if e == nil || w.Code != http.StatusBadRequest {
+		t.Errorf("Assigning a symbol without a table gave status %d", w.Code)
+	}
+
+	// symbol choices are decoded like other choices
+	for body, status := range map[string]int{
+		`{"index": 4.5, "symbol": "D"}`:                                         http.StatusBadRequest,
+		`{"index": 4, "symbol": "` + strings.Repeat("D", MaxChoiceBytes) + `"}`: http.StatusRequestEntityTooLarge,
+	} {
+		w := httptest.NewRecorder()
+		_, e := AssignSymbolHandler(p, table, w, httptest.NewRequest("POST", "/api/assign-symbol/", strings.NewReader(body)))
+		if e == nil || w.Code != status {
+			t.Errorf("Assigning %.30s gave status %d", body, w.Code)
+		}
+	}
+}
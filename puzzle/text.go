@@ -0,0 +1,302 @@
+package puzzle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+
+Text formats
+
+Puzzles are commonly passed around in a few plain-text formats,
+all of them for Sudoku geometry:
+
+- the line format is a single line with a character for each
+square, in index order: a value, or '.' or '0' for an empty
+square;
+
+- SadMan Sudoku's SDK format is a grid with a line for each row
+of the puzzle, in the same characters, optionally preceded by
+'#' comment lines and a "[Puzzle]" header (any later section,
+such as "[State]", is ignored);
+
+- SadMan Sudoku's SDM format is a collection of puzzles, one
+per line, in the line format;
+
+- the grid format is a grid with a line for each row, as copied
+out of a spreadsheet or a newspaper.  A row's squares are
+separated by commas, semicolons, or tabs, each a value or '.',
+'0', or nothing for an empty square (spreadsheet rows padded
+with extra empty cells are fine); or, in a row without those
+separators, they are characters as in the SDK format, which can
+be spaced out and grouped by '|'.  Lines of only '-', '+', '=',
+and '|', which draw tile borders, are skipped.
+
+Values past 9 are the letters used by String (or, in separated
+grid rows, numbers).  Blank lines and '#' comment lines are
+allowed anywhere in line, SDM, and grid text.  Errors in grid
+text give the line and column of the problem.
+
+*/
+
+// A TextFormat names one of the plain-text puzzle formats.
+type TextFormat string
+
+// The known text formats.
+const (
+	LineFormat TextFormat = "line"
+	SDKFormat  TextFormat = "sdk"
+	SDMFormat  TextFormat = "sdm"
+	GridFormat TextFormat = "grid"
+)
+
+// valueChars are the square value characters, in value order
+// from 1.
+var valueChars = strings.Join(valueStrings[1:], "")
+
+// textError returns the Error for a problem with puzzle text.
+func textError(format string, args ...interface{}) Error {
+	return Error{
+		Scope:     ArgumentScope,
+		Structure: ScopeStructure,
+		Condition: GeneralCondition,
+		Values:    ErrorData{fmt.Sprintf(format, args...)},
+	}
+}
+
+// textLines returns the lines of text with their line numbers,
+// without blank and comment lines.
+func textLines(text string) ([]string, []int) {
+	var lines []string
+	var numbers []int
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines, numbers = append(lines, line), append(numbers, i+1)
+	}
+	return lines, numbers
+}
+
+// parseSquares appends the values of a line's characters to
+// vals.  The values can't be more than the side length.
+func parseSquares(vals []int, line string, number, sidelen int) ([]int, error) {
+	for _, c := range line {
+		v := strings.IndexRune(valueChars, c) + 1
+		if c == '.' || c == '0' {
+			v = 0
+		} else if v == 0 || v > sidelen {
+			return nil, textError("Line %d: %q is not a square value", number, c)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// gridSeparators are the characters that can separate the
+// squares of a grid row, in the order they're looked for.
+const gridSeparators = ",;\t"
+
+// parseGridCell returns the value of a square in a separated grid
+// row, which is at the given line and column.
+func parseGridCell(cell string, number, column, sidelen int) (int, error) {
+	cell = strings.Trim(strings.TrimSpace(cell), "\"")
+	if cell == "" || cell == "." || cell == "0" {
+		return 0, nil
+	}
+	v, e := strconv.Atoi(cell)
+	if e != nil && utf8.RuneCountInString(cell) == 1 {
+		v, e = strings.Index(valueChars, cell)+1, nil
+	}
+	if e != nil || v < 1 || v > sidelen {
+		return 0, textError("Line %d, column %d: %q is not a square value", number, column, cell)
+	}
+	return v, nil
+}
+
+// parseGridRow appends the values of a grid row's squares to vals.
+func parseGridRow(vals []int, line string, number, sidelen int) ([]int, error) {
+	var row []int
+	if i := strings.IndexAny(line, gridSeparators); i >= 0 {
+		start := 0
+		for _, cell := range strings.Split(line, line[i:i+1]) {
+			column := utf8.RuneCountInString(line[:start]) + 1
+			column += utf8.RuneCountInString(cell) - utf8.RuneCountInString(strings.TrimLeft(cell, " "))
+			v, e := parseGridCell(cell, number, column, sidelen)
+			if e != nil {
+				return nil, e
+			}
+			row, start = append(row, v), start+len(cell)+1
+		}
+		// spreadsheets can pad rows with empty cells
+		for len(row) > sidelen && row[len(row)-1] == 0 {
+			row = row[:len(row)-1]
+		}
+	} else {
+		column := 0
+		for _, c := range line {
+			column++
+			if c == ' ' || c == '|' {
+				continue
+			}
+			v := strings.IndexRune(valueChars, c) + 1
+			if c == '.' || c == '0' {
+				v = 0
+			} else if v == 0 || v > sidelen {
+				return nil, textError("Line %d, column %d: %q is not a square value", number, column, c)
+			}
+			row = append(row, v)
+		}
+	}
+	if len(row) != sidelen {
+		return nil, textError("Line %d: a row of %d squares in a grid of %d rows", number, len(row), sidelen)
+	}
+	return append(vals, row...), nil
+}
+
+// parseGrid returns the geometry code and values of a puzzle in
+// the grid format.
+func parseGrid(text string) ([]int, error) {
+	var rows []string
+	var numbers []int
+	for i, line := range strings.Split(strings.TrimPrefix(text, "\ufeff"), "\n") {
+		line = strings.TrimRight(line, " \r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.Trim(trimmed, "-+=| ") == "" {
+			continue
+		}
+		rows, numbers = append(rows, line), append(numbers, i+1)
+	}
+	vals := []int{SudokuGeometryCode}
+	for i, row := range rows {
+		var e error
+		if vals, e = parseGridRow(vals, row, numbers[i], len(rows)); e != nil {
+			return nil, e
+		}
+	}
+	return vals, nil
+}
+
+// parseLine returns the geometry code and values of a puzzle in
+// the line format.
+func parseLine(line string, number int) ([]int, error) {
+	sidelen, ok := findIntSquareRoot(len(line))
+	if !ok {
+		return nil, textError("Line %d: %d squares don't make a square puzzle", number, len(line))
+	}
+	return parseSquares([]int{SudokuGeometryCode}, line, number, sidelen)
+}
+
+// ParseText returns the puzzles in text of the given format, each
+// as its geometry code and values (in the same form passed to
+// New).  The puzzles aren't checked beyond their format: creating
+// them may still fail.
+func ParseText(text string, format TextFormat) ([][]int, error) {
+	lines, numbers := textLines(text)
+	var puzzles [][]int
+	switch format {
+	case LineFormat, SDMFormat:
+		if format == LineFormat && len(lines) != 1 {
+			return nil, textError("Line format text has %d puzzles, not 1", len(lines))
+		}
+		for i, line := range lines {
+			vals, e := parseLine(line, numbers[i])
+			if e != nil {
+				return nil, e
+			}
+			puzzles = append(puzzles, vals)
+		}
+	case SDKFormat:
+		if len(lines) > 0 && strings.EqualFold(lines[0], "[Puzzle]") {
+			lines, numbers = lines[1:], numbers[1:]
+		}
+		for i, line := range lines {
+			if strings.HasPrefix(line, "[") {
+				lines = lines[:i]
+				break
+			}
+		}
+		vals := []int{SudokuGeometryCode}
+		for i, line := range lines {
+			if len(line) != len(lines) {
+				return nil, textError("Line %d: a row of %d squares in a grid of %d rows", numbers[i], len(line), len(lines))
+			}
+			var e error
+			if vals, e = parseSquares(vals, line, numbers[i], len(lines)); e != nil {
+				return nil, e
+			}
+		}
+		puzzles = append(puzzles, vals)
+	case GridFormat:
+		vals, e := parseGrid(text)
+		if e != nil {
+			return nil, e
+		}
+		puzzles = append(puzzles, vals)
+	default:
+		return nil, textError("Unknown text format %q", format)
+	}
+	if len(puzzles) == 0 || len(puzzles[0]) == 1 {
+		return nil, textError("There are no puzzles in the text")
+	}
+	return puzzles, nil
+}
+
+// WriteText returns the text of puzzles (given as geometry codes
+// and values) in the given format.  Only Sudoku puzzles can be
+// written, and the line, SDK, and grid formats only hold one
+// puzzle.  Grid text is written with comma-separated squares.
+func WriteText(puzzles [][]int, format TextFormat) (string, error) {
+	if f, ok := LookupTextFormat(string(format)); !ok || f != format {
+		return "", textError("Unknown text format %q", format)
+	}
+	if format != SDMFormat && len(puzzles) != 1 {
+		return "", textError("The %s format holds 1 puzzle, not %d", format, len(puzzles))
+	}
+	var b strings.Builder
+	for _, vals := range puzzles {
+		if len(vals) == 0 || vals[0] != SudokuGeometryCode {
+			return "", textError("Only Sudoku puzzles can be written as text")
+		}
+		sidelen, ok := findIntSquareRoot(len(vals) - 1)
+		if !ok || sidelen >= len(valueStrings) {
+			return "", textError("%d squares can't be written as text", len(vals)-1)
+		}
+		for i, v := range vals[1:] {
+			if format == GridFormat && i%sidelen != 0 {
+				b.WriteByte(',')
+			}
+			switch {
+			case v < 0 || v > sidelen:
+				return "", textError("Square %d has value %d", i+1, v)
+			case v > 0:
+				b.WriteString(vstr(v))
+			case format == SDMFormat:
+				b.WriteByte('0')
+			default:
+				b.WriteByte('.')
+			}
+			if (format == SDKFormat || format == GridFormat) && (i+1)%sidelen == 0 {
+				b.WriteByte('\n')
+			}
+		}
+		if format != SDKFormat && format != GridFormat {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), nil
+}
+
+// LookupTextFormat returns the text format with the given name,
+// ignoring case.
+func LookupTextFormat(name string) (TextFormat, bool) {
+	switch f := TextFormat(strings.ToLower(name)); f {
+	case LineFormat, SDKFormat, SDMFormat, GridFormat:
+		return f, true
+	}
+	return "", false
+}
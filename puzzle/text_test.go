@@ -0,0 +1,122 @@
+package puzzle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	oneStar := append([]int{SudokuGeometryCode}, oneStarValues...)
+	line, e := WriteText([][]int{oneStar}, LineFormat)
+	if e != nil || len(line) != 82 || !strings.HasPrefix(line, "4....35.2") {
+		t.Fatalf("Line text is %q, %v", line, e)
+	}
+	sdk, e := WriteText([][]int{oneStar}, SDKFormat)
+	if e != nil || strings.Count(sdk, "\n") != 9 || !strings.HasPrefix(sdk, "4....35.2\n..95.634.\n") {
+		t.Fatalf("SDK text is %q, %v", sdk, e)
+	}
+	sixStar := append([]int{SudokuGeometryCode}, sixStarValues...)
+	sdm, e := WriteText([][]int{oneStar, sixStar}, SDMFormat)
+	if e != nil || strings.Count(sdm, "\n") != 2 || !strings.HasPrefix(sdm, "400003502") {
+		t.Fatalf("SDM text is %q, %v", sdm, e)
+	}
+
+	for _, tc := range []struct {
+		text   string
+		format TextFormat
+		want   [][]int
+	}{
+		{line, LineFormat, [][]int{oneStar}},
+		{"# a comment\n\n" + line, LineFormat, [][]int{oneStar}},
+		{sdk, SDKFormat, [][]int{oneStar}},
+		{"#Aan author\n[Puzzle]\n" + sdk + "[State]\n123\n", SDKFormat, [][]int{oneStar}},
+		{strings.Replace(sdk, "\n", "\r\n", -1), SDKFormat, [][]int{oneStar}},
+		{sdm, SDMFormat, [][]int{oneStar, sixStar}},
+		{"1..4" + strings.Repeat(".", 12), LineFormat, [][]int{append([]int{SudokuGeometryCode, 1, 0, 0, 4}, make([]int, 12)...)}},
+	} {
+		got, e := ParseText(tc.text, tc.format)
+		if e != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseText(%q, %s) gave %v, %v", tc.text, tc.format, got, e)
+		}
+	}
+
+	for _, tc := range []struct {
+		text   string
+		format TextFormat
+	}{
+		{"", LineFormat},
+		{line + line, LineFormat},
+		{"1234567", SDMFormat},
+		{strings.Replace(line, "4", "x", 1), LineFormat},
+		{sdk[10:], SDKFormat},
+		{"[Puzzle]\n", SDKFormat},
+		{line, "csv"},
+	} {
+		if got, e := ParseText(tc.text, tc.format); e == nil {
+			t.Errorf("ParseText(%q, %s) didn't fail: %v", tc.text, tc.format, got)
+		}
+	}
+	for _, puzzles := range [][][]int{
+		{},
+		{oneStar, sixStar},
+		{{DudokuGeometryCode, 1, 0, 0, 4}},
+		{{SudokuGeometryCode, 1, 0, 0, 5}},
+	} {
+		if text, e := WriteText(puzzles, LineFormat); e == nil {
+			t.Errorf("WriteText(%v) didn't fail: %q", puzzles, text)
+		}
+	}
+}
+
+func TestParseGrid(t *testing.T) {
+	oneStar := append([]int{SudokuGeometryCode}, oneStarValues...)
+	grid, e := WriteText([][]int{oneStar}, GridFormat)
+	if e != nil || strings.Count(grid, "\n") != 9 || !strings.HasPrefix(grid, "4,.,.,.,.,3,5,.,2\n") {
+		t.Fatalf("Grid text is %q, %v", grid, e)
+	}
+	sdk, _ := WriteText([][]int{oneStar}, SDKFormat)
+	rows := strings.Split(strings.TrimSpace(sdk), "\n")
+	var spaced, bordered []string
+	for i, row := range rows {
+		spaced = append(spaced, strings.Join(strings.Split(row, ""), " "))
+		if i > 0 && i%3 == 0 {
+			bordered = append(bordered, "------+-------+------")
+		}
+		bordered = append(bordered, row[:3]+" | "+row[3:6]+" | "+row[6:])
+	}
+
+	for _, text := range []string{
+		grid,
+		strings.Replace(grid, ",", ";", -1),
+		strings.Replace(strings.Replace(grid, ",", "\t", -1), ".", "", -1),
+		"\ufeff" + strings.Replace(strings.Replace(grid, ".", "0", -1), "\n", ",,,\r\n", -1),
+		strings.Replace(grid, ",", ", ", -1),
+		`"` + strings.Replace(strings.Replace(grid, ",", `","`, -1), "\n", "\"\n\"", 8),
+		strings.Join(spaced, "\n"),
+		"# from the paper\n" + strings.Join(bordered, "\n"),
+		sdk,
+	} {
+		got, e := ParseText(text, GridFormat)
+		if e != nil || !reflect.DeepEqual(got, [][]int{oneStar}) {
+			t.Errorf("ParseText(%q, grid) gave %v, %v", text, got, e)
+		}
+	}
+	big := "1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16\n" + strings.Repeat(strings.Repeat(",", 15)+"\n", 15)
+	if got, e := ParseText(big, GridFormat); e != nil || got[0][10] != 10 || got[0][16] != 16 {
+		t.Errorf("ParseText of a 16x16 grid gave %v, %v", got, e)
+	}
+
+	for text, message := range map[string]string{
+		strings.Replace(grid, "5,.,2", "5,x,2", 1):        "Line 1, column 15: \"x\" is not a square value",
+		strings.Replace(grid, "\n", "\n  ,10,", 1):        "Line 2, column 4: \"10\" is not a square value",
+		strings.Replace(sdk, "..95", "..9?", 1):           "Line 2, column 4: '?' is not a square value",
+		strings.Replace(grid, "4,.,", "4,", 1):            "Line 1: a row of 8 squares in a grid of 9 rows",
+		strings.Join(strings.Split(grid, "\n")[:8], "\n"): "Line 1: a row of 9 squares in a grid of 8 rows",
+		"": "There are no puzzles in the text",
+	} {
+		if got, e := ParseText(text, GridFormat); e == nil || !strings.HasSuffix(e.Error(), message) {
+			t.Errorf("ParseText(%q, grid) gave %v, %v", text, got, e)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package puzzle
+
+import (
+	"time"
+)
+
+/*
+
+Rater timing
+
+To find out where the rater spends its time (so that changes to
+the techniques can be checked for slowdowns), ratings can be
+timed.  Each time the rater tries a technique, it counts the
+try, and adds the time the technique took to look for progress,
+whether or not it found any.  Guesses aren't timed: the rater
+stops at the first one.
+
+*/
+
+// A TechniqueTime is how often the rater tried a technique, how
+// often the technique made progress, and how long the tries took
+// altogether.
+type TechniqueTime struct {
+	Technique string        `json:"technique"`
+	Tries     int           `json:"tries"`
+	Uses      int           `json:"uses"`
+	Time      time.Duration `json:"time"`
+}
+
+// RateTimed rates a puzzle like RateWith does, also giving the
+// time spent on each technique the rater can use, in order of
+// difficulty.  Times are for the rating's steps only, not for the
+// search that checks that the puzzle can be solved.
+func RateTimed(p Puzzle, profile RatingProfile) (Rating, []TechniqueTime, error) {
+	times := make([]TechniqueTime, len(techniques)-1)
+	for i := range times {
+		times[i].Technique = techniques[i]
+	}
+	rating, e := rateWith(p, profile, nil, times)
+	if e != nil {
+		return rating, nil, e
+	}
+	return rating, times, nil
+}
+
+// try tries the technique with the given number, timing it if the
+// rater is being timed, and returns whether it made progress.
+func (r *rater) try(technique int, f func() bool) bool {
+	if r.times == nil {
+		return f()
+	}
+	began := time.Now()
+	progress := f()
+	t := &r.times[technique]
+	t.Tries++
+	t.Time += time.Since(began)
+	if progress {
+		t.Uses++
+	}
+	return progress
+}
@@ -0,0 +1,44 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRateTimed(t *testing.T) {
+	p, e := helperNewSudokuPuzzle(rectangleValues)
+	if e != nil {
+		t.Fatalf("Failed to create puzzle: %v", e)
+	}
+	rating, times, e := RateTimed(p, RatingProfiles[0])
+	if e != nil {
+		t.Fatalf("RateTimed failed: %v", e)
+	}
+	if untimed, _ := Rate(p); !reflect.DeepEqual(rating, untimed) {
+		t.Errorf("Timed rating %+v differs from %+v", rating, untimed)
+	}
+	if len(times) != len(techniques)-1 {
+		t.Fatalf("Got %d technique times", len(times))
+	}
+	uses := make(map[string]int)
+	for _, tc := range rating.Techniques {
+		uses[tc.Technique] = tc.Count
+	}
+	for i, tt := range times {
+		if tt.Technique != techniques[i] || tt.Uses != uses[tt.Technique] || tt.Uses > tt.Tries {
+			t.Errorf("Technique time %d is %+v, expected %d uses", i, tt, uses[techniques[i]])
+		}
+	}
+	if times[0].Tries == 0 || times[0].Time <= 0 {
+		t.Errorf("Hidden singles weren't timed: %+v", times[0])
+	}
+
+	// disabled techniques aren't tried
+	singles, _ := FindRatingProfile("singles")
+	_, times, _ = RateTimed(p, singles)
+	for _, tt := range times[2:] {
+		if tt.Tries != 0 {
+			t.Errorf("Singles rating tried %+v", tt)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package puzzle
+
+/*
+
+Units
+
+Clients that only summarize a puzzle (such as watch faces,
+terminal clients, and voice assistants) would rather not work
+out its groups from its squares.  Its units do that for them:
+for each group (each row, column, tile, and any others the
+geometry has), the values placed in it, the values it's still
+missing, and its empty squares.  Units only report the values in
+the squares, so contest puzzles have them too.
+
+*/
+
+// A Unit summarizes one group of a puzzle: the values placed in
+// it and the values it's missing (both in order), and the indices
+// of its empty squares.  A group with a value twice has it in
+// Placed once.
+type Unit struct {
+	Group   GroupID `json:"group"`
+	Placed  []int   `json:"placed"`
+	Missing []int   `json:"missing"`
+	Empty   []int   `json:"empty"`
+}
+
+// Units returns the units of a puzzle, in group order.  It's an
+// error if the puzzle's contents aren't available.
+func Units(p Puzzle) ([]Unit, error) {
+	var mapping *puzzleMapping
+	var values []int // by index, from 1
+	switch pz := p.(type) {
+	case *puzzle:
+		mapping, values = pz.mapping, pz.allValues()
+	case *relaxedPuzzle:
+		mapping, values = pz.mapping, pz.allValues()
+	case *contestPuzzle:
+		given, e := New(pz.givens)
+		if e != nil {
+			return nil, e
+		}
+		mapping, values = given.(*puzzle).mapping, pz.values[1:]
+	default:
+		return nil, Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: GeneralCondition,
+			Values:    ErrorData{"Puzzle contents are not available for units"},
+		}
+	}
+	units := make([]Unit, 0, mapping.gcount)
+	for gi := 1; gi <= mapping.gcount; gi++ {
+		gd := &mapping.gdescs[gi]
+		unit := Unit{Group: gd.id, Placed: []int{}, Missing: []int{}, Empty: []int{}}
+		placed := make([]bool, mapping.sidelen+1)
+		for _, i := range gd.indices {
+			if v := values[i-1]; v != 0 {
+				placed[v] = true
+			} else {
+				unit.Empty = append(unit.Empty, i)
+			}
+		}
+		for v := 1; v <= mapping.sidelen; v++ {
+			if placed[v] {
+				unit.Placed = append(unit.Placed, v)
+			} else {
+				unit.Missing = append(unit.Missing, v)
+			}
+		}
+		units = append(units, unit)
+	}
+	return units, nil
+}
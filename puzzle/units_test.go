@@ -0,0 +1,33 @@
+package puzzle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, rotation4Puzzle1PartialValues...)
+	p, _ := New(givens)
+	units, e := Units(p)
+	if e != nil || len(units) != 12 {
+		t.Fatalf("Units gave %d units, %v", len(units), e)
+	}
+	expected := Unit{Group: GroupID{GtypeRow, 1}, Placed: []int{1, 3}, Missing: []int{2, 4}, Empty: []int{2, 4}}
+	if !reflect.DeepEqual(units[0], expected) {
+		t.Errorf("First unit is %+v, expected %+v", units[0], expected)
+	}
+
+	// contest puzzles have units of their entries
+	c, _ := NewContest(givens)
+	c.Assign(Choice{2, 2})
+	units, e = Units(c)
+	expected = Unit{Group: GroupID{GtypeRow, 1}, Placed: []int{1, 2, 3}, Missing: []int{4}, Empty: []int{4}}
+	if e != nil || !reflect.DeepEqual(units[0], expected) {
+		t.Errorf("Contest units start with %+v, %v", units[0], e)
+	}
+
+	// other puzzles don't
+	if _, e := Units(badEncoderPuzzle("opaque")); e == nil {
+		t.Errorf("Units of an opaque puzzle succeeded")
+	}
+}
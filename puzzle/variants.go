@@ -0,0 +1,136 @@
+package puzzle
+
+/*
+
+Sudoku variants
+
+Variant puzzles are Sudoku puzzles with extra groups, which are
+constrained just like the rows, columns, and tiles: X-Sudoku
+adds the two main diagonals of the puzzle (with group type
+GtypeDiagonal, numbered from the top left and then the top
+right), hyper-Sudoku adds the hyper regions (with group type
+GtypeHyper, numbered in reading order), and hyper-X-Sudoku adds
+both.  The hyper regions are tile-sized squares, set apart from
+each other and from the edges of the puzzle by a single row and
+column, so a 9x9 puzzle has four of them, and a 4x4 puzzle has
+one in the middle.
+
+Each variant has its own geometry code, and since the extra
+groups are just more groups in the geometry's mapping, the
+puzzle model, the solver, the rater, and hints all handle them
+without knowing they're there.
+
+*/
+
+// variantPuzzleMaps is where we memoize computed variant puzzle
+// maps for each geometry code and side length we've
+// encountered.
+var variantPuzzleMaps = make(map[[2]int]*puzzleMapping)
+
+// variantPuzzleMapping returns the puzzle map for a variant
+// puzzle of the given geometry with the given number of cells,
+// computing it the first time.  It returns the same errors as
+// squarePuzzleMapping.
+func variantPuzzleMapping(code byte, psize int) (*puzzleMapping, error) {
+	base, e := squarePuzzleMapping(psize)
+	if e != nil {
+		return nil, e
+	}
+	key := [2]int{int(code), base.sidelen}
+	if pm, ok := variantPuzzleMaps[key]; ok {
+		return pm, nil
+	}
+	var extra []groupDescriptor
+	if code == XSudokuGeometryCode || code == HyperXSudokuGeometryCode {
+		extra = append(extra, diagonalGroups(base.sidelen)...)
+	}
+	if code == HyperSudokuGeometryCode || code == HyperXSudokuGeometryCode {
+		extra = append(extra, hyperGroups(base.sidelen)...)
+	}
+	pm := extendPuzzleMapping(base, code, extra)
+	variantPuzzleMaps[key] = pm
+	return pm, nil
+}
+
+// extendPuzzleMapping returns a copy of a puzzle map, with the
+// given geometry code and extra groups, which are indexed after
+// the existing ones.
+func extendPuzzleMapping(base *puzzleMapping, code byte, extra []groupDescriptor) *puzzleMapping {
+	pm := *base
+	pm.geometry = code
+	pm.gcount = base.gcount + len(extra)
+	pm.gdescs = append(append([]groupDescriptor(nil), base.gdescs...), extra...)
+	pm.ixmap = make([][]int, len(base.ixmap))
+	for i, gis := range base.ixmap {
+		pm.ixmap[i] = append([]int(nil), gis...)
+	}
+	for n := range extra {
+		gd := &pm.gdescs[base.gcount+n+1]
+		gd.index = base.gcount + n + 1
+		for _, si := range gd.indices {
+			pm.ixmap[si] = append(pm.ixmap[si], gd.index)
+		}
+	}
+	return &pm
+}
+
+// diagonalGroups returns the two main diagonals of a puzzle with
+// the given side length.
+func diagonalGroups(slen int) []groupDescriptor {
+	down, up := make(intset, slen), make(intset, slen)
+	for i := 0; i < slen; i++ {
+		down[i] = slen*i + i + 1            // 1-based indices
+		up[i] = slen*i + (slen - 1 - i) + 1 // 1-based indices
+	}
+	return []groupDescriptor{
+		{0, GroupID{GtypeDiagonal, 1}, down},
+		{0, GroupID{GtypeDiagonal, 2}, up},
+	}
+}
+
+// hyperGroups returns the hyper regions of a puzzle with the
+// given side length, which must be a perfect square.
+func hyperGroups(slen int) []groupDescriptor {
+	tlen, _ := findIntSquareRoot(slen)
+	var gs []groupDescriptor
+	for hr := 0; hr < tlen-1; hr++ {
+		for hc := 0; hc < tlen-1; hc++ {
+			region := make(intset, 0, slen)
+			baserow, basecol := 1+hr*(tlen+1), 1+hc*(tlen+1)
+			for r := 0; r < tlen; r++ {
+				for c := 0; c < tlen; c++ {
+					region = append(region, slen*(baserow+r)+(basecol+c)+1) // 1-based indices
+				}
+			}
+			gs = append(gs, groupDescriptor{0, GroupID{GtypeHyper, len(gs) + 1}, region})
+		}
+	}
+	return gs
+}
+
+// variantConstructor returns the constructor for variant puzzles
+// of the given geometry.
+func variantConstructor(code byte) func(values []int) (Puzzle, error) {
+	return func(values []int) (Puzzle, error) {
+		mapping, err := variantPuzzleMapping(code, len(values))
+		if err != nil {
+			return nil, err
+		}
+		return create(mapping, values)
+	}
+}
+
+// register the variant puzzle mappings
+func init() {
+	for _, gd := range []GeometryDescriptor{
+		{Names: []string{"X-Sudoku", "x-sudoku", "diagonal"}, Code: XSudokuGeometryCode},
+		{Names: []string{"Hyper-Sudoku", "hyper-sudoku", "hyper", "windoku"}, Code: HyperSudokuGeometryCode},
+		{Names: []string{"Hyper-X-Sudoku", "hyper-x-sudoku", "hyper-x"}, Code: HyperXSudokuGeometryCode},
+	} {
+		gd := gd
+		gd.New = variantConstructor(gd.Code)
+		if err := RegisterGeometry(&gd); err != nil {
+			panic(err) // if we can't register, we can't start up
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package puzzle
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// xSudokuValues and hyperSudokuValues are one-star puzzles that
+// need their extra groups: they have many solutions as plain
+// Sudoku puzzles.
+var (
+	xSudokuValues = []int{
+		0, 0, 0, 0, 0, 6, 0, 8, 0,
+		0, 0, 0, 7, 0, 9, 0, 0, 0,
+		0, 8, 0, 1, 0, 0, 0, 0, 6,
+		0, 3, 0, 0, 0, 0, 8, 0, 7,
+		6, 0, 0, 0, 3, 0, 2, 0, 4,
+		0, 0, 0, 0, 9, 0, 5, 0, 0,
+		0, 0, 0, 0, 1, 0, 0, 0, 0,
+		3, 0, 0, 0, 0, 5, 0, 0, 0,
+		5, 0, 0, 0, 0, 0, 0, 1, 0,
+	}
+	hyperSudokuValues = []int{
+		0, 0, 0, 0, 0, 0, 0, 8, 0,
+		0, 0, 0, 7, 0, 9, 0, 0, 0,
+		0, 8, 0, 1, 0, 0, 0, 0, 6,
+		0, 3, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 3, 0, 5, 0, 7,
+		0, 0, 0, 0, 6, 0, 2, 0, 0,
+		0, 0, 2, 0, 7, 0, 0, 0, 0,
+		9, 0, 8, 0, 0, 0, 0, 0, 0,
+		6, 0, 0, 0, 0, 2, 0, 1, 0,
+	}
+)
+
+func TestVariantGeometries(t *testing.T) {
+	for _, name := range []string{"X-Sudoku", "windoku", "hyper-x"} {
+		if _, ok := LookupGeometryByName(name); !ok {
+			t.Errorf("Geometry %q isn't registered", name)
+		}
+	}
+
+	// the extra groups come after the Sudoku ones
+	x, _ := variantPuzzleMapping(XSudokuGeometryCode, 81)
+	if x.gcount != 29 || len(x.ixmap[41]) != 5 || len(x.ixmap[2]) != 3 {
+		t.Errorf("X-Sudoku mapping has %d groups, %v for the center square", x.gcount, x.ixmap[41])
+	}
+	if gd := x.gdescs[29]; gd.index != 29 || gd.id != (GroupID{GtypeDiagonal, 2}) ||
+		!reflect.DeepEqual(gd.indices, intset{9, 17, 25, 33, 41, 49, 57, 65, 73}) {
+		t.Errorf("X-Sudoku group 29 is %+v", gd)
+	}
+	hyper, _ := variantPuzzleMapping(HyperSudokuGeometryCode, 81)
+	if gd := hyper.gdescs[28]; hyper.gcount != 31 || gd.id != (GroupID{GtypeHyper, 1}) ||
+		!reflect.DeepEqual(gd.indices, intset{11, 12, 13, 20, 21, 22, 29, 30, 31}) {
+		t.Errorf("Hyper-Sudoku has %d groups, and group 28 is %+v", hyper.gcount, gd)
+	}
+	small, _ := variantPuzzleMapping(HyperXSudokuGeometryCode, 16)
+	if gd := small.gdescs[small.gcount]; small.gcount != 15 || !reflect.DeepEqual(gd.indices, intset{6, 7, 10, 11}) {
+		t.Errorf("4x4 hyper-X-Sudoku has %d groups, the last %+v", small.gcount, gd)
+	}
+	if base, _ := squarePuzzleMapping(81); base.gcount != 27 || len(base.ixmap[41]) != 3 {
+		t.Errorf("Sudoku mapping was changed: %d groups", base.gcount)
+	}
+	if _, e := New(append([]int{XSudokuGeometryCode}, make([]int, 80)...)); e == nil {
+		t.Errorf("Non-square X-Sudoku puzzle was created")
+	}
+}
+
+func TestVariantPuzzles(t *testing.T) {
+	for _, tc := range []struct {
+		code   int
+		values []int
+		group  GroupID
+		i, j   int // squares in the group, but no other group together
+	}{
+		{XSudokuGeometryCode, xSudokuValues, GroupID{GtypeDiagonal, 1}, 1, 81},
+		{HyperSudokuGeometryCode, hyperSudokuValues, GroupID{GtypeHyper, 4}, 51, 71},
+	} {
+		plain, _ := New(append([]int{SudokuGeometryCode}, tc.values...))
+		if plain.IsProper() == nil {
+			t.Errorf("Geometry %d puzzle is proper as a Sudoku puzzle", tc.code)
+		}
+		p, e := New(append([]int{tc.code}, tc.values...))
+		if e != nil {
+			t.Fatalf("Failed to create geometry %d puzzle: %v", tc.code, e)
+		}
+		if e := p.IsProper(); e != nil {
+			t.Errorf("Geometry %d puzzle isn't proper: %v", tc.code, e)
+		}
+		if r, e := Rate(p); e != nil || r.Stars != 1 {
+			t.Errorf("Geometry %d puzzle was rated %+v, %v", tc.code, r, e)
+		}
+		if h, e := Suggest(p); e != nil || h.Technique != TechniqueHiddenSingle {
+			t.Errorf("Geometry %d puzzle hint was %+v, %v", tc.code, h, e)
+		}
+
+		// the solution fills the extra groups
+		solution := p.Solutions()[0].Values
+		m, _ := variantPuzzleMapping(byte(tc.code), 81)
+		for gi := 28; gi <= m.gcount; gi++ {
+			var seen valset
+			for _, i := range m.gdescs[gi].indices {
+				seen.insert(solution[i-1])
+			}
+			if seen.len() != 9 {
+				t.Errorf("Geometry %d solution repeats values in %v", tc.code, m.gdescs[gi].id)
+			}
+		}
+
+		// repeated values in an extra group are errors
+		values := append([]int{tc.code}, make([]int, 81)...)
+		values[tc.i], values[tc.j] = 5, 5
+		bad, _ := New(values)
+		found := false
+		for _, err := range bad.State().Errors {
+			if err.Scope == GroupScope && err.Values[0] == tc.group {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Geometry %d repeats in %v had errors %v", tc.code, tc.group, bad.State().Errors)
+		}
+
+		// variants have the Sudoku symmetries
+		rotated := make([]int, 82)
+		rotated[0] = tc.code
+		for r := 0; r < 9; r++ {
+			for c := 0; c < 9; c++ {
+				rotated[c*9+(8-r)+1] = tc.values[r*9+c]
+			}
+		}
+		c1, _ := Canonical(append([]int{tc.code}, tc.values...))
+		c2, _ := Canonical(rotated)
+		if !reflect.DeepEqual(c1, c2) || c1[0] != tc.code {
+			t.Errorf("Geometry %d canonical forms differ: %v, %v", tc.code, c1, c2)
+		}
+	}
+}
+
+func TestVariantSVG(t *testing.T) {
+	p, _ := New(append([]int{XSudokuGeometryCode}, xSudokuValues...))
+	var b bytes.Buffer
+	if e := RenderSVG(&b, p, SVGOptions{}); e != nil {
+		t.Fatalf("RenderSVG failed: %v", e)
+	}
+	if n := strings.Count(b.String(), `fill="#e4e4e4"`); n != 17 {
+		t.Errorf("X-Sudoku image has %d shaded squares", n)
+	}
+}
@@ -0,0 +1,116 @@
+package puzzle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+/*
+
+Fingerprints and solution verification
+
+*/
+
+// Fingerprint returns a short, stable identifier for a puzzle
+// given its geometry code and cell values (in the same form
+// passed to New).  Two puzzles have the same fingerprint exactly
+// when they have the same geometry and the same givens, so
+// fingerprints can be published in place of puzzles by contest
+// systems that need to refer to them.
+func Fingerprint(geoAndValues []int) string {
+	bytes := make([]byte, len(geoAndValues))
+	for i, v := range geoAndValues {
+		bytes[i] = byte(v) // geometry codes and values fit in a byte
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:16])
+}
+
+// A Claim is a claimed solution to the puzzle with the given
+// fingerprint.  The values start with the geometry code, as for
+// New.
+type Claim struct {
+	Fingerprint string `json:"fingerprint"`
+	Values      []int  `json:"values"`
+}
+
+// A Verification is the result of checking a claimed solution
+// against a fingerprinted puzzle.  Errors explain what's wrong
+// with an invalid solution, but never reveal the solution
+// itself.  Unassisted is set by servers that gave the solver no
+// help at all (no possible values, hints, or validation) while
+// the solution was being worked out.
+type Verification struct {
+	Fingerprint string  `json:"fingerprint"`
+	Valid       bool    `json:"valid"`
+	Errors      []Error `json:"errors,omitempty"`
+	Unassisted  bool    `json:"unassisted,omitempty"`
+}
+
+// Verify checks whether the claimed solution (geometry code and
+// values, as for New) is a valid solution to the puzzle with the
+// given geometry code and values.  A valid solution fills every
+// square, keeps every given value, and satisfies all the
+// puzzle's group constraints.
+//
+// An error is returned only if the puzzle itself can't be
+// created; problems with the claimed solution are reported in
+// the returned Verification.
+func Verify(geoAndValues, solution []int) (Verification, error) {
+	v := Verification{Fingerprint: Fingerprint(geoAndValues)}
+	if _, e := New(geoAndValues); e != nil {
+		return v, e
+	}
+	if len(solution) != len(geoAndValues) || solution[0] != geoAndValues[0] {
+		err := Error{
+			Scope:     ArgumentScope,
+			Structure: AttributeValueStructure,
+			Attribute: PuzzleSizeAttribute,
+			Condition: GeneralCondition,
+			Values: ErrorData{len(solution) - 1,
+				"Solution doesn't have the puzzle's geometry and size"},
+		}
+		err.Message = err.Error()
+		v.Errors = append(v.Errors, err)
+		return v, nil
+	}
+	empty := 0
+	for i := 1; i < len(solution); i++ {
+		if solution[i] == 0 {
+			empty++
+		} else if given := geoAndValues[i]; given != 0 && given != solution[i] {
+			err := Error{
+				Scope:     SquareScope,
+				Structure: AttributeValueStructure,
+				Attribute: AssignedValueAttribute,
+				Condition: ChangedGivenCondition,
+				Values:    ErrorData{i, solution[i], given},
+			}
+			err.Message = err.Error()
+			v.Errors = append(v.Errors, err)
+		}
+	}
+	if empty > 0 {
+		err := Error{
+			Scope:     ArgumentScope,
+			Structure: ScopeStructure,
+			Condition: IncompleteSolutionCondition,
+			Values:    ErrorData{empty},
+		}
+		err.Message = err.Error()
+		v.Errors = append(v.Errors, err)
+	}
+	p, e := New(solution)
+	if e != nil {
+		err, ok := e.(Error)
+		if !ok {
+			err = Error{Scope: ArgumentScope, Condition: GeneralCondition, Values: ErrorData{e.Error()}}
+		}
+		err.Message = err.Error()
+		v.Errors = append(v.Errors, err)
+	} else {
+		v.Errors = append(v.Errors, p.State().Errors...)
+	}
+	v.Valid = len(v.Errors) == 0
+	return v, nil
+}
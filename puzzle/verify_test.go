@@ -0,0 +1,61 @@
+package puzzle
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	f1 := Fingerprint(append([]int{SudokuGeometryCode}, oneStarValues...))
+	f2 := Fingerprint(append([]int{SudokuGeometryCode}, oneStarValues...))
+	if f1 != f2 || len(f1) != 32 {
+		t.Errorf("Fingerprints of same puzzle are %q and %q", f1, f2)
+	}
+	f3 := Fingerprint(append([]int{DudokuGeometryCode}, oneStarValues...))
+	if f3 == f1 {
+		t.Errorf("Fingerprints of different geometries are both %q", f1)
+	}
+	f4 := Fingerprint(append([]int{SudokuGeometryCode}, sixStarValues...))
+	if f4 == f1 {
+		t.Errorf("Fingerprints of different puzzles are both %q", f1)
+	}
+}
+
+type verifyTestcase struct {
+	name      string
+	solution  []int
+	valid     bool
+	condition ErrorCondition
+}
+
+func TestVerify(t *testing.T) {
+	givens := append([]int{SudokuGeometryCode}, sixStarValues...)
+	correct := append([]int{SudokuGeometryCode}, sixStarSolution.Values...)
+	incomplete := append([]int(nil), correct...)
+	incomplete[2] = 0
+	changed := append([]int{SudokuGeometryCode}, tileRotationCompleteValues...)
+	swapped := append([]int(nil), correct...)
+	swapped[2], swapped[3] = swapped[3], swapped[2]
+	tcs := []verifyTestcase{
+		{"correct", correct, true, UnknownCondition},
+		{"incomplete", incomplete, false, IncompleteSolutionCondition},
+		{"changed", changed, false, ChangedGivenCondition},
+		{"swapped", swapped, false, DuplicateGroupValuesCondition},
+		{"short", correct[:10], false, GeneralCondition},
+	}
+	for _, tc := range tcs {
+		v, e := Verify(givens, tc.solution)
+		if e != nil {
+			t.Fatalf("%s: Verify failed: %v", tc.name, e)
+		}
+		if v.Valid != tc.valid {
+			t.Errorf("%s: Verify returned valid = %v: %+v", tc.name, v.Valid, v.Errors)
+		}
+		if !tc.valid && !helperCheckCondition(tc.condition, v.Errors) {
+			t.Errorf("%s: Verify errors didn't include condition %v: %+v",
+				tc.name, tc.condition, v.Errors)
+		}
+	}
+	if _, e := Verify([]int{-1, 0, 0, 0, 0}, correct); e == nil {
+		t.Errorf("Verify against unknown geometry didn't fail")
+	}
+}
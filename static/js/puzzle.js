@@ -5,11 +5,14 @@ var puzzleID;
 var puzzleContent = null;
 var guessContent = null;
 var puzzleSideLength = 0;
-var squaresURL = "/api/squares/";
-var assignURL = "/api/assign/";
-var backURL = "/api/back/";
-var resetURL = "/api/reset/";
+var squaresURL = "/api/v1/squares/";
+var assignURL = "/api/v1/assign/";
+var backURL = "/api/v1/back/";
+var certainURL = "/api/v1/back/certain/";
+var resetURL = "/api/v1/reset/";
 var startURL = "/reset/";
+var slotName = new URLSearchParams(window.location.search).get("slot");
+var slotQuery = slotName ? "?slot=" + encodeURIComponent(slotName) : "";
 
 function receivePuzzleSquares() {
     if (this.readyState == 4) {
@@ -36,6 +39,8 @@ function receivePuzzleUpdate() {
 		    messages += "<br />" + result.conflict[i].message
 		}
 		setFeedback("Assign produced errors; puzzle not solvable:" + messages);
+	    } else if (result.solved) {
+		setFeedback("Assign successful; puzzle solved!");
 	    } else {
 		setFeedback("Assign successful; puzzle updated.");
 	    }
@@ -53,7 +58,7 @@ function LoadPuzzle(url) {
 	url = squaresURL;
     }
     console.log("GET request for", url);
-    getPuzzleRequest.open("GET", url, true);
+    getPuzzleRequest.open("GET", url + slotQuery, true);
     getPuzzleRequest.send(null);
 }
 
@@ -70,7 +75,7 @@ function AssignPuzzle(cell, val) {
     var choice = {index: cell, value: val};
     var body = JSON.stringify(choice);
     console.log("POST request to puzzle:", body);
-    postAssignRequest.open("POST", assignURL, true);
+    postAssignRequest.open("POST", assignURL + slotQuery, true);
     postAssignRequest.setRequestHeader("Content-type", "application/json");
     postAssignRequest.send(body);
 }
@@ -92,6 +97,7 @@ function refillPuzzle() {
 	for (pcIdx = 0; pcIdx < puzzleContent.length; pcIdx++) {
 	    var idstr = "c" + (pcIdx + 1);
 	    var cell = document.getElementById(idstr);
+	    cell.setAttribute("guess", puzzleContent[pcIdx].guess ? "yes" : "no");
 	    if ('aval' in puzzleContent[pcIdx]) {
 		cell.innerHTML = puzzleContent[pcIdx].aval;
 		cell.setAttribute("hint", "none");
@@ -328,6 +334,10 @@ function undoGuess() {
     LoadPuzzle(backURL);
 }
 
+function undoMistakes() {
+    LoadPuzzle(certainURL);
+}
+
 function resetPuzzle() {
     LoadPuzzle(resetURL);
 }
@@ -357,7 +367,7 @@ function setPuzzle(pid) {
 }
 
 function newPuzzle(pid) {
-    window.location = startURL + pid;
+    window.location = startURL + pid + slotQuery;
 }
 
 function initializePage(sideLen) {
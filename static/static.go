@@ -0,0 +1,18 @@
+// Copyright 2015 Daniel C. Brotsky.  All rights reserved.
+
+// Package static has the susen server's web assets: the solver
+// client's pages, scripts, styles, and images, and the templates
+// the server renders pages from.  They're compiled into the
+// binary, so the server can be deployed as a single executable
+// that doesn't depend on where it's run from.
+package static
+
+import (
+	"embed"
+)
+
+// Files are the assets, in their directories (css, html, img, js,
+// and tmpl).
+//
+//go:embed css html img js tmpl
+var Files embed.FS
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Schema migrations
+
+The SQL backends (Postgres and SQLite) keep records in a schema
+that changes over time, so the schema's history is kept as
+numbered migrations, embedded in the binary: each has an up
+script, which makes the change, and a down script, which undoes
+it.  They're in the migrations directory, one subdirectory for
+each dialect, named like 0001_records.up.sql.  A database's
+schema_migrations table lists the migrations applied to it.
+
+Each migration is applied (or undone) in its own transaction,
+along with the change to schema_migrations, so a failed
+migration leaves the database at the version before it.  The
+SQL backends need their database drivers (lib/pq for Postgres,
+mattn/go-sqlite3 for SQLite) to be linked into the binary, and
+OpenMigrator says so if they aren't.
+
+*/
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// A Migration is one change to a SQL schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// A MigrationStatus is a migration and whether it's applied.
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// A sqlDialect is what the migrations and the SQL stores need to
+// know about a SQL database.
+type sqlDialect struct {
+	name        string
+	driver      string
+	placeholder string // for the first query argument
+	now         string // the current time
+}
+
+var sqlDialects = []sqlDialect{
+	{"postgres", "postgres", "$1", "now()"},
+	{"sqlite", "sqlite3", "?", "CURRENT_TIMESTAMP"},
+}
+
+// bind rewrites the ? placeholders of a query for the dialect.
+func (d sqlDialect) bind(query string) string {
+	if d.placeholder == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// sqlSource returns the dialect and driver data source of a
+// SQL store's data source name: "postgres://..." (or
+// "postgresql://...") for Postgres, and "sqlite:<path>" for
+// SQLite.
+func sqlSource(dsn string) (sqlDialect, string, bool) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://"):
+		return sqlDialects[0], dsn, true
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return sqlDialects[1], dsn[len("sqlite:"):], true
+	}
+	return sqlDialect{}, "", false
+}
+
+// Migrations returns the migrations of a dialect ("postgres" or
+// "sqlite"), in version order.
+func Migrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, e := migrationFiles.ReadDir(dir)
+	if e != nil {
+		return nil, fmt.Errorf("Unknown SQL dialect: %q", dialect)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base := strings.TrimSuffix(name, ".sql")
+		direction := path.Ext(base)
+		underscore := strings.Index(base, "_")
+		if underscore < 0 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("Misnamed migration file: %s", name)
+		}
+		version, e := strconv.Atoi(base[:underscore])
+		if e != nil || version < 1 {
+			return nil, fmt.Errorf("Misnamed migration file: %s", name)
+		}
+		text, e := migrationFiles.ReadFile(path.Join(dir, name))
+		if e != nil {
+			return nil, e
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: strings.TrimSuffix(base[underscore+1:], direction)}
+			byVersion[version] = m
+		}
+		if direction == ".up" {
+			m.Up = string(text)
+		} else {
+			m.Down = string(text)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("Migration %d (%s) needs both up and down scripts", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// A Migrator applies and undoes the migrations of a database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    sqlDialect
+	migrations []Migration
+}
+
+// OpenMigrator opens the database of a SQL store's data source
+// name (see New) for migration.
+func OpenMigrator(dsn string) (*Migrator, error) {
+	db, dialect, e := openSQL(dsn)
+	if e != nil {
+		return nil, e
+	}
+	return newMigrator(db, dialect)
+}
+
+// openSQL opens the database of a SQL store's data source name.
+func openSQL(dsn string) (*sql.DB, sqlDialect, error) {
+	dialect, source, ok := sqlSource(dsn)
+	if !ok {
+		return nil, dialect, fmt.Errorf("Not a SQL store: %q", dsn)
+	}
+	linked := false
+	for _, driver := range sql.Drivers() {
+		linked = linked || driver == dialect.driver
+	}
+	if !linked {
+		return nil, dialect, fmt.Errorf("This binary has no %s database driver", dialect.name)
+	}
+	db, e := sql.Open(dialect.driver, source)
+	return db, dialect, e
+}
+
+// newMigrator returns a migrator for an open database.
+func newMigrator(db *sql.DB, dialect sqlDialect) (*Migrator, error) {
+	migrations, e := Migrations(dialect.name)
+	if e != nil {
+		return nil, e
+	}
+	_, e = db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)")
+	if e != nil {
+		return nil, fmt.Errorf("Can't make the schema_migrations table: %v", e)
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Close closes the migrator's database.
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// applied returns the versions of the applied migrations.
+func (m *Migrator) applied() (map[int]bool, error) {
+	rows, e := m.db.Query("SELECT version FROM schema_migrations")
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	versions := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if e := rows.Scan(&v); e != nil {
+			return nil, e
+		}
+		versions[v] = true
+	}
+	return versions, rows.Err()
+}
+
+// Status returns every migration, and whether it's applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, e := m.applied()
+	if e != nil {
+		return nil, e
+	}
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i] = MigrationStatus{migration, applied[migration.Version]}
+	}
+	return status, nil
+}
+
+// Up applies the unapplied migrations up to the given version (or
+// all of them, if the version is 0), in order, and returns the
+// ones it applied.  It stops at the first that fails.
+func (m *Migrator) Up(to int) ([]Migration, error) {
+	applied, e := m.applied()
+	if e != nil {
+		return nil, e
+	}
+	var done []Migration
+	for _, migration := range m.migrations {
+		if applied[migration.Version] || (to > 0 && migration.Version > to) {
+			continue
+		}
+		record := "INSERT INTO schema_migrations (version) VALUES (" + m.dialect.placeholder + ")"
+		if e := m.run(migration, migration.Up, record); e != nil {
+			return done, e
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down undoes the applied migrations after the given version, in
+// reverse order, and returns the ones it undid.  It stops at the
+// first that fails.
+func (m *Migrator) Down(to int) ([]Migration, error) {
+	applied, e := m.applied()
+	if e != nil {
+		return nil, e
+	}
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if !applied[migration.Version] || migration.Version <= to {
+			continue
+		}
+		record := "DELETE FROM schema_migrations WHERE version = " + m.dialect.placeholder
+		if e := m.run(migration, migration.Down, record); e != nil {
+			return done, e
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// run runs a migration script and its change to the
+// schema_migrations table in a transaction.
+func (m *Migrator) run(migration Migration, script, record string) error {
+	tx, e := m.db.Begin()
+	if e != nil {
+		return e
+	}
+	if _, e = tx.Exec(script); e == nil {
+		_, e = tx.Exec(record, migration.Version)
+	}
+	if e != nil {
+		tx.Rollback()
+		return fmt.Errorf("Migration %d (%s) failed: %v", migration.Version, migration.Name, e)
+	}
+	return tx.Commit()
+}
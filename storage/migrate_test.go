@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is the database behind the fake SQL driver: all it keeps
+// is the schema_migrations table, the records table (see sql.go),
+// and the other scripts that were run.
+type fakeDB struct {
+	mutex    sync.Mutex
+	versions map[int64]bool
+	records  map[[2]string]string
+	scripts  []string
+	failOn   string // scripts containing this fail
+}
+
+var testDB = &fakeDB{versions: make(map[int64]bool), records: make(map[[2]string]string)}
+
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeStmt struct{ query string }
+type fakeRows struct {
+	column string
+	values []driver.Value
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeConn{}, nil }
+func (fakeConn) Commit() error                             { return nil }
+func (fakeConn) Rollback() error                           { return nil }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	testDB.mutex.Lock()
+	defer testDB.mutex.Unlock()
+	switch {
+	case testDB.failOn != "" && strings.Contains(s.query, testDB.failOn):
+		return nil, fmt.Errorf("syntax error")
+	case strings.HasPrefix(s.query, "INSERT INTO schema_migrations"):
+		testDB.versions[args[0].(int64)] = true
+	case strings.HasPrefix(s.query, "DELETE FROM schema_migrations"):
+		delete(testDB.versions, args[0].(int64))
+	case strings.HasPrefix(s.query, "INSERT INTO records"):
+		key := [2]string{args[0].(string), args[1].(string)}
+		if _, ok := testDB.records[key]; ok && strings.HasSuffix(s.query, "DO NOTHING") {
+			return driver.RowsAffected(0), nil
+		}
+		testDB.records[key] = args[2].(string)
+	case strings.HasPrefix(s.query, "UPDATE records"):
+		key := [2]string{args[1].(string), args[2].(string)}
+		if value, ok := testDB.records[key]; !ok || value != args[3].(string) {
+			return driver.RowsAffected(0), nil
+		}
+		testDB.records[key] = args[0].(string)
+	case strings.HasPrefix(s.query, "DELETE FROM records"):
+		key := [2]string{args[0].(string), args[1].(string)}
+		if value, ok := testDB.records[key]; len(args) > 2 && (!ok || value != args[2].(string)) {
+			return driver.RowsAffected(0), nil
+		}
+		delete(testDB.records, key)
+	default:
+		testDB.scripts = append(testDB.scripts, s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	testDB.mutex.Lock()
+	defer testDB.mutex.Unlock()
+	switch {
+	case testDB.failOn != "" && strings.Contains(s.query, testDB.failOn):
+		return nil, fmt.Errorf("syntax error")
+	case strings.HasPrefix(s.query, "SELECT value FROM records"):
+		rows := &fakeRows{column: "value"}
+		if value, ok := testDB.records[[2]string{args[0].(string), args[1].(string)}]; ok {
+			rows.values = append(rows.values, []byte(value))
+		}
+		return rows, nil
+	case strings.HasPrefix(s.query, "SELECT key FROM records"):
+		rows := &fakeRows{column: "key"}
+		for k := range testDB.records {
+			if k[0] == args[0].(string) {
+				rows.values = append(rows.values, k[1])
+			}
+		}
+		return rows, nil
+	}
+	var versions []int64
+	for v := range testDB.versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	rows := &fakeRows{column: "version"}
+	for _, v := range versions {
+		rows.values = append(rows.values, v)
+	}
+	return rows, nil
+}
+
+func (r *fakeRows) Columns() []string { return []string{r.column} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("fakesql", fakeDriver{})
+}
+
+func TestMigrations(t *testing.T) {
+	for _, dialect := range []string{"postgres", "sqlite"} {
+		migrations, e := Migrations(dialect)
+		if e != nil || len(migrations) == 0 {
+			t.Fatalf("%s migrations are %v, %v", dialect, migrations, e)
+		}
+		for i, m := range migrations {
+			if m.Version != i+1 || m.Name == "" || !strings.Contains(m.Up, "records") || m.Down == "" {
+				t.Errorf("%s migration %d is %+v", dialect, i+1, m)
+			}
+		}
+	}
+	if _, e := Migrations("oracle"); e == nil {
+		t.Errorf("Unknown dialect has migrations")
+	}
+	if _, e := OpenMigrator("dir:/tmp/records"); e == nil {
+		t.Errorf("Directory store has a migrator")
+	}
+	if _, e := OpenMigrator("postgres://localhost/susen"); e == nil || !strings.Contains(e.Error(), "driver") {
+		t.Errorf("Migrator without a driver gave %v", e)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db, _ := sql.Open("fakesql", "")
+	m, e := newMigrator(db, sqlDialect{"sqlite", "fakesql", "?", "CURRENT_TIMESTAMP"})
+	if e != nil {
+		t.Fatalf("Can't make migrator: %v", e)
+	}
+	defer m.Close()
+	all := len(m.migrations)
+	status, e := m.Status()
+	if e != nil || len(status) != all || status[0].Applied {
+		t.Fatalf("Status of new database is %+v, %v", status, e)
+	}
+
+	if done, e := m.Up(1); e != nil || len(done) != 1 || done[0].Version != 1 {
+		t.Errorf("Up to 1 applied %+v, %v", done, e)
+	}
+	if done, e := m.Up(0); e != nil || len(done) != all-1 {
+		t.Errorf("Up applied %+v, %v", done, e)
+	}
+	if done, e := m.Up(0); e != nil || len(done) != 0 {
+		t.Errorf("Second up applied %+v, %v", done, e)
+	}
+	status, _ = m.Status()
+	for _, s := range status {
+		if !s.Applied {
+			t.Errorf("Migration %d isn't applied after up", s.Version)
+		}
+	}
+
+	// down goes in reverse order, and a failure stops it
+	if done, e := m.Down(all - 1); e != nil || len(done) != 1 || done[0].Version != all {
+		t.Errorf("Down one applied %+v, %v", done, e)
+	}
+	testDB.mutex.Lock()
+	testDB.failOn = "DROP TABLE records"
+	testDB.mutex.Unlock()
+	done, e := m.Down(0)
+	if e == nil || len(done) != all-2 {
+		t.Errorf("Failing down undid %+v, %v", done, e)
+	}
+	status, _ = m.Status()
+	if !status[0].Applied {
+		t.Errorf("Failed migration was recorded as undone")
+	}
+	testDB.mutex.Lock()
+	testDB.failOn = ""
+	testDB.mutex.Unlock()
+	if done, e := m.Down(0); e != nil || len(done) != 1 {
+		t.Errorf("Down to 0 undid %+v, %v", done, e)
+	}
+	if last := testDB.scripts[len(testDB.scripts)-1]; !strings.Contains(last, "DROP TABLE records") {
+		t.Errorf("Last script run was %q", last)
+	}
+}
@@ -0,0 +1,8 @@
+-- Records: one row for each record in the store, by kind and key.
+CREATE TABLE records (
+    kind    TEXT NOT NULL,
+    key     TEXT NOT NULL,
+    value   JSONB NOT NULL,
+    updated TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (kind, key)
+);
@@ -0,0 +1,2 @@
+-- Lets old records (such as expired sessions) be found quickly.
+CREATE INDEX records_kind_updated ON records (kind, updated);
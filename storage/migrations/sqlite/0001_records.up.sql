@@ -0,0 +1,8 @@
+-- Records: one row for each record in the store, by kind and key.
+CREATE TABLE records (
+    kind    TEXT NOT NULL,
+    key     TEXT NOT NULL,
+    value   TEXT NOT NULL,
+    updated TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (kind, key)
+);
@@ -0,0 +1 @@
+DROP INDEX records_kind_updated;
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+/*
+
+SQL stores
+
+The SQL backends keep all the records in one table, records,
+with a row for each record: its kind and key, its JSON value,
+and when it was last written (see the migrations directory).
+A SQL store brings its database's schema up to date when it's
+opened, so a new database (such as a freshly provisioned
+Heroku Postgres) needs no setup, and a deploy with new
+migrations applies them as it starts.  Migrations can also be
+applied and undone by hand (see Migrator).
+
+*/
+
+// sqlStore keeps records in a SQL database.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQL returns the store for a SQL data source name (see
+// sqlSource), after applying any migrations its database lacks.
+func NewSQL(dsn string) (Store, error) {
+	db, dialect, e := openSQL(dsn)
+	if e != nil {
+		return nil, e
+	}
+	return newSQLStore(db, dialect)
+}
+
+// newSQLStore returns the store for an open database, after
+// applying any migrations it lacks.
+func newSQLStore(db *sql.DB, dialect sqlDialect) (Store, error) {
+	m, e := newMigrator(db, dialect)
+	if e == nil {
+		_, e = m.Up(0)
+	}
+	if e != nil {
+		db.Close()
+		return nil, fmt.Errorf("Can't migrate the %s store: %v", dialect.name, e)
+	}
+	return &sqlStore{db: db, dialect: dialect}, nil
+}
+
+func (ss *sqlStore) Get(kind, key string, v interface{}) (bool, error) {
+	var bytes []byte
+	e := ss.db.QueryRow(ss.dialect.bind("SELECT value FROM records WHERE kind = ? AND key = ?"), kind, key).Scan(&bytes)
+	if e == sql.ErrNoRows {
+		return false, nil
+	}
+	if e != nil {
+		return false, e
+	}
+	return true, json.Unmarshal(bytes, v)
+}
+
+func (ss *sqlStore) Put(kind, key string, v interface{}) error {
+	bytes, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	_, e = ss.db.Exec(ss.dialect.bind("INSERT INTO records (kind, key, value, updated) VALUES (?, ?, ?, "+ss.dialect.now+
+		") ON CONFLICT (kind, key) DO UPDATE SET value = excluded.value, updated = excluded.updated"),
+		kind, key, string(bytes))
+	return e
+}
+
+func (ss *sqlStore) Delete(kind, key string) error {
+	_, e := ss.db.Exec(ss.dialect.bind("DELETE FROM records WHERE kind = ? AND key = ?"), kind, key)
+	return e
+}
+
+func (ss *sqlStore) Keys(kind string) ([]string, error) {
+	rows, e := ss.db.Query(ss.dialect.bind("SELECT key FROM records WHERE kind = ?"), kind)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if e := rows.Scan(&key); e != nil {
+			return nil, e
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // in byte order, whatever the database's collation
+	return keys, rows.Err()
+}
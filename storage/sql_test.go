@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestSQLDialects(t *testing.T) {
+	query := "SELECT value FROM records WHERE kind = ? AND key = ?"
+	if got := sqlDialects[0].bind(query); got != "SELECT value FROM records WHERE kind = $1 AND key = $2" {
+		t.Errorf("Postgres query is %q", got)
+	}
+	if got := sqlDialects[1].bind(query); got != query {
+		t.Errorf("SQLite query is %q", got)
+	}
+	if _, e := New("postgres://localhost/susen"); e == nil || !strings.Contains(e.Error(), "driver") {
+		t.Errorf("Postgres store without a driver gave %v", e)
+	}
+}
+
+func TestSQL(t *testing.T) {
+	testDB.mutex.Lock()
+	testDB.versions = make(map[int64]bool)
+	testDB.mutex.Unlock()
+	db, _ := sql.Open("fakesql", "")
+	s, e := newSQLStore(db, sqlDialect{"sqlite", "fakesql", "?", "CURRENT_TIMESTAMP"})
+	if e != nil {
+		t.Fatalf("Can't make SQL store: %v", e)
+	}
+
+	// the store migrates its database when it's opened
+	migrations, _ := Migrations("sqlite")
+	testDB.mutex.Lock()
+	applied := len(testDB.versions)
+	testDB.mutex.Unlock()
+	if applied != len(migrations) {
+		t.Errorf("Opened store has %d of %d migrations", applied, len(migrations))
+	}
+	helperExerciseStore(t, "sql", s)
+
+	testDB.mutex.Lock()
+	testDB.versions, testDB.failOn = make(map[int64]bool), "CREATE TABLE records"
+	testDB.mutex.Unlock()
+	defer func() {
+		testDB.mutex.Lock()
+		testDB.failOn = ""
+		testDB.mutex.Unlock()
+	}()
+	db, _ = sql.Open("fakesql", "")
+	if _, e := newSQLStore(db, sqlDialect{"sqlite", "fakesql", "?", "CURRENT_TIMESTAMP"}); e == nil {
+		t.Errorf("Store whose migration failed was opened")
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2015 Daniel C. Brotsky.  All rights reserved.
+
+// Package storage keeps the server's long-lived data: accounts,
+// statistics, leaderboards, and the like.
+//
+// Data is kept as records, each of which is a JSON-encodable
+// value with a kind (which says what sort of record it is, such
+// as "account") and a key that's unique within the kind.  The
+// Store interface is all the server knows about storage, so
+// backends can be swapped by configuration.  This package
+// provides a memory backend (for development and tests), a
+// directory backend (one file per record), and SQL backends
+// (Postgres and SQLite), whose schema migrations it manages.
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Store keeps records.  All Stores are safe for concurrent
+// use.
+type Store interface {
+	// Get decodes the record with the given kind and key into
+	// v, and tells whether there was such a record.
+	Get(kind, key string, v interface{}) (bool, error)
+	// Put stores v as the record with the given kind and key,
+	// replacing any record that was there.
+	Put(kind, key string, v interface{}) error
+	// Delete removes a record.  It's not an error if there was
+	// no such record.
+	Delete(kind, key string) error
+	// Keys lists the keys of all records of a kind, in order.
+	Keys(kind string) ([]string, error)
+}
+
+// New returns the store described by a data source name: the
+// empty string or "memory:" gives a memory store, "dir:<path>"
+// (or just a path) gives a directory store, and the SQL data
+// source names (see sqlSource) give SQL stores.
+func New(dsn string) (Store, error) {
+	if _, _, ok := sqlSource(dsn); ok {
+		return NewSQL(dsn)
+	}
+	switch {
+	case dsn == "" || dsn == "memory:":
+		return NewMemory(), nil
+	case strings.HasPrefix(dsn, "dir:"):
+		return NewDir(dsn[len("dir:"):])
+	case !strings.Contains(dsn, ":") || filepath.IsAbs(dsn):
+		return NewDir(dsn)
+	}
+	return nil, fmt.Errorf("Unknown storage backend: %q", dsn)
+}
+
+/*
+
+Memory stores
+
+*/
+
+// memoryStore keeps encoded records in a map, so that stored
+// values don't share storage with the caller's.
+type memoryStore struct {
+	mutex   sync.RWMutex
+	records map[string]map[string][]byte
+}
+
+// NewMemory returns an empty memory store.  Its records last as
+// long as the process.
+func NewMemory() Store {
+	return &memoryStore{records: make(map[string]map[string][]byte)}
+}
+
+func (ms *memoryStore) Get(kind, key string, v interface{}) (bool, error) {
+	ms.mutex.RLock()
+	bytes, ok := ms.records[kind][key]
+	ms.mutex.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(bytes, v)
+}
+
+func (ms *memoryStore) Put(kind, key string, v interface{}) error {
+	bytes, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.records[kind] == nil {
+		ms.records[kind] = make(map[string][]byte)
+	}
+	ms.records[kind][key] = bytes
+	return nil
+}
+
+func (ms *memoryStore) Delete(kind, key string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.records[kind], key)
+	return nil
+}
+
+func (ms *memoryStore) Keys(kind string) ([]string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	keys := make([]string, 0, len(ms.records[kind]))
+	for key := range ms.records[kind] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+/*
+
+Directory stores
+
+*/
+
+// dirStore keeps each record in a JSON file, in a subdirectory
+// for its kind, named by its (escaped) key.  Writes go through
+// a temporary file, so a record is never half-written.
+type dirStore struct {
+	mutex sync.RWMutex
+	root  string
+}
+
+// NewDir returns a store that keeps its records under the given
+// directory, creating it if necessary.
+func NewDir(root string) (Store, error) {
+	if e := os.MkdirAll(root, 0755); e != nil {
+		return nil, e
+	}
+	return &dirStore{root: root}, nil
+}
+
+func (ds *dirStore) path(kind, key string) string {
+	return filepath.Join(ds.root, url.PathEscape(kind), url.PathEscape(key)+".json")
+}
+
+func (ds *dirStore) Get(kind, key string, v interface{}) (bool, error) {
+	ds.mutex.RLock()
+	bytes, e := ioutil.ReadFile(ds.path(kind, key))
+	ds.mutex.RUnlock()
+	if os.IsNotExist(e) {
+		return false, nil
+	}
+	if e != nil {
+		return false, e
+	}
+	return true, json.Unmarshal(bytes, v)
+}
+
+func (ds *dirStore) Put(kind, key string, v interface{}) error {
+	bytes, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	path := ds.path(kind, key)
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+		return e
+	}
+	temp := path + ".tmp"
+	if e := ioutil.WriteFile(temp, bytes, 0644); e != nil {
+		return e
+	}
+	return os.Rename(temp, path)
+}
+
+func (ds *dirStore) Delete(kind, key string) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	if e := os.Remove(ds.path(kind, key)); e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	return nil
+}
+
+func (ds *dirStore) Keys(kind string) ([]string, error) {
+	ds.mutex.RLock()
+	defer ds.mutex.RUnlock()
+	infos, e := ioutil.ReadDir(filepath.Join(ds.root, url.PathEscape(kind)))
+	if os.IsNotExist(e) {
+		return []string{}, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue // a temporary file, or not ours
+		}
+		key, e := url.PathUnescape(strings.TrimSuffix(name, ".json"))
+		if e != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+/*
+
+Contexts
+
+The backends can't be interrupted once they've started an
+operation, but requests with deadlines shouldn't start store
+operations they can't wait for.
+
+*/
+
+// WithContext returns a Store that does the operations of s
+// until the context is done, and then fails them with the
+// context's error.
+func WithContext(ctx context.Context, s Store) Store {
+	if ctx.Done() == nil {
+		return s // it's never done
+	}
+	return contextStore{ctx, s}
+}
+
+type contextStore struct {
+	ctx context.Context
+	Store
+}
+
+func (cs contextStore) Get(kind, key string, v interface{}) (bool, error) {
+	if e := cs.ctx.Err(); e != nil {
+		return false, e
+	}
+	return cs.Store.Get(kind, key, v)
+}
+
+func (cs contextStore) Put(kind, key string, v interface{}) error {
+	if e := cs.ctx.Err(); e != nil {
+		return e
+	}
+	return cs.Store.Put(kind, key, v)
+}
+
+func (cs contextStore) Delete(kind, key string) error {
+	if e := cs.ctx.Err(); e != nil {
+		return e
+	}
+	return cs.Store.Delete(kind, key)
+}
+
+func (cs contextStore) Keys(kind string) ([]string, error) {
+	if e := cs.ctx.Err(); e != nil {
+		return nil, e
+	}
+	return cs.Store.Keys(kind)
+}
+
+func (cs contextStore) Swap(kind, key string, old, v interface{}) (bool, error) {
+	if e := cs.ctx.Err(); e != nil {
+		return false, e
+	}
+	return Swap(cs.Store, kind, key, old, v)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testRecord struct {
+	Name  string
+	Count int
+}
+
+func helperExerciseStore(t *testing.T, name string, s Store) {
+	var r testRecord
+	if ok, e := s.Get("test", "a", &r); ok || e != nil {
+		t.Errorf("%s: Get of missing record gave %v, %v", name, ok, e)
+	}
+	if keys, e := s.Keys("test"); len(keys) != 0 || e != nil {
+		t.Errorf("%s: Keys of empty kind gave %v, %v", name, keys, e)
+	}
+	for _, key := range []string{"b", "a/b c", "a"} {
+		if e := s.Put("test", key, testRecord{key, len(key)}); e != nil {
+			t.Fatalf("%s: Put of %q failed: %v", name, key, e)
+		}
+	}
+	if ok, e := s.Get("test", "a/b c", &r); !ok || e != nil || r != (testRecord{"a/b c", 5}) {
+		t.Errorf("%s: Get gave %v, %+v, %v", name, ok, r, e)
+	}
+	if e := s.Put("test", "a", testRecord{"replaced", 1}); e != nil {
+		t.Fatalf("%s: Put of replacement failed: %v", name, e)
+	}
+	if s.Get("test", "a", &r); r.Name != "replaced" {
+		t.Errorf("%s: Replaced record is %+v", name, r)
+	}
+	if keys, _ := s.Keys("test"); !reflect.DeepEqual(keys, []string{"a", "a/b c", "b"}) {
+		t.Errorf("%s: Keys gave %v", name, keys)
+	}
+	if keys, _ := s.Keys("other"); len(keys) != 0 {
+		t.Errorf("%s: Kinds aren't separate: %v", name, keys)
+	}
+	if e := s.Delete("test", "b"); e != nil {
+		t.Errorf("%s: Delete failed: %v", name, e)
+	}
+	if e := s.Delete("test", "b"); e != nil {
+		t.Errorf("%s: Delete of missing record failed: %v", name, e)
+	}
+	if ok, _ := s.Get("test", "b", &r); ok {
+		t.Errorf("%s: Deleted record still there", name)
+	}
+
+	// swaps only change records that are as expected
+	for _, swap := range []struct {
+		old, v interface{}
+		ok     bool
+	}{
+		{nil, testRecord{"new", 1}, true},
+		{nil, testRecord{"newer", 2}, false},
+		{testRecord{"old", 1}, testRecord{"newer", 2}, false},
+		{testRecord{"new", 1}, testRecord{"newer", 2}, true},
+		{testRecord{"new", 1}, nil, false},
+		{testRecord{"newer", 2}, nil, true},
+		{nil, nil, true},
+	} {
+		if ok, e := Swap(s, "swap", "a", swap.old, swap.v); ok != swap.ok || e != nil {
+			t.Errorf("%s: Swap of %+v for %+v gave %v, %v", name, swap.v, swap.old, ok, e)
+		}
+	}
+	if ok, _ := s.Get("swap", "a", &r); ok {
+		t.Errorf("%s: Swapped-out record still there: %+v", name, r)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	helperExerciseStore(t, "memory", NewMemory())
+}
+
+func TestDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "store")
+	s, e := NewDir(root)
+	if e != nil {
+		t.Fatalf("NewDir failed: %v", e)
+	}
+	helperExerciseStore(t, "dir", s)
+
+	// records outlast the store that wrote them
+	s2, _ := NewDir(root)
+	var r testRecord
+	if ok, _ := s2.Get("test", "a", &r); !ok || r.Name != "replaced" {
+		t.Errorf("Reopened store gave %v, %+v", ok, r)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, dsn := range []string{"", "memory:", "dir:" + t.TempDir(), t.TempDir()} {
+		if _, e := New(dsn); e != nil {
+			t.Errorf("New(%q) failed: %v", dsn, e)
+		}
+	}
+	if _, e := New("carrier-pigeon:coop"); e == nil {
+		t.Errorf("New of unknown backend succeeded")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	s := NewMemory()
+	if WithContext(context.Background(), s) != s {
+		t.Errorf("Store with a background context was wrapped")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	helperExerciseStore(t, "context", WithContext(ctx, s))
+	cancel()
+	cs := WithContext(ctx, s)
+	var r testRecord
+	if ok, e := cs.Get("test", "a", &r); ok || e != context.Canceled {
+		t.Errorf("Get after cancel gave %v, %v", ok, e)
+	}
+	if e := cs.Put("test", "c", testRecord{"c", 1}); e != context.Canceled {
+		t.Errorf("Put after cancel gave %v", e)
+	}
+	if e := cs.Delete("test", "a"); e != context.Canceled {
+		t.Errorf("Delete after cancel gave %v", e)
+	}
+	if _, e := cs.Keys("test"); e != context.Canceled {
+		t.Errorf("Keys after cancel gave %v", e)
+	}
+	if _, e := Swap(cs, "test", "a", nil, nil); e != context.Canceled {
+		t.Errorf("Swap after cancel gave %v", e)
+	}
+	if ok, _ := s.Get("test", "a", &r); !ok {
+		t.Errorf("Delete after cancel removed the record")
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+
+Swapping
+
+Records that more than one server changes (such as the session
+leases of a deployment with several servers) can't be read and
+then written back, since another server could write in between.
+Instead they're swapped: the new value only replaces the record
+if the record is still the one that was read.  All the backends
+here can swap, as long as a store's records are only changed
+through one store: the memory and directory backends check under
+their lock, and the SQL backends in the statement that writes.
+
+*/
+
+// A Swapper is a Store that can change a record only if it hasn't
+// changed since it was read.
+type Swapper interface {
+	// Swap replaces the record with the given kind and key by
+	// v, if the record is old (and if old is nil, if there's no
+	// record), and tells whether it did.  Records are compared
+	// by their JSON encodings.  If v is nil, the record is
+	// removed instead.
+	Swap(kind, key string, old, v interface{}) (bool, error)
+}
+
+// Swap swaps a record in a store (see Swapper), and fails if the
+// store can't swap records.
+func Swap(s Store, kind, key string, old, v interface{}) (bool, error) {
+	if sw, ok := s.(Swapper); ok {
+		return sw.Swap(kind, key, old, v)
+	}
+	return false, fmt.Errorf("The store can't swap records")
+}
+
+// encodeSwap returns the encodings of a swap's values, which are
+// nil for nil values.
+func encodeSwap(old, v interface{}) (oldBytes, newBytes []byte, e error) {
+	if old != nil {
+		if oldBytes, e = json.Marshal(old); e != nil {
+			return nil, nil, e
+		}
+	}
+	if v != nil {
+		if newBytes, e = json.Marshal(v); e != nil {
+			return nil, nil, e
+		}
+	}
+	return oldBytes, newBytes, nil
+}
+
+// swappable tells whether a record (which is nil if there's none)
+// is the one a swap expects.
+func swappable(current []byte, found bool, old []byte) bool {
+	if old == nil {
+		return !found
+	}
+	return found && bytes.Equal(current, old)
+}
+
+func (ms *memoryStore) Swap(kind, key string, old, v interface{}) (bool, error) {
+	oldBytes, newBytes, e := encodeSwap(old, v)
+	if e != nil {
+		return false, e
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	current, found := ms.records[kind][key]
+	if !swappable(current, found, oldBytes) {
+		return false, nil
+	}
+	if newBytes == nil {
+		delete(ms.records[kind], key)
+		return true, nil
+	}
+	if ms.records[kind] == nil {
+		ms.records[kind] = make(map[string][]byte)
+	}
+	ms.records[kind][key] = newBytes
+	return true, nil
+}
+
+func (ds *dirStore) Swap(kind, key string, old, v interface{}) (bool, error) {
+	oldBytes, newBytes, e := encodeSwap(old, v)
+	if e != nil {
+		return false, e
+	}
+	path := ds.path(kind, key)
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	current, e := ioutil.ReadFile(path)
+	if e != nil && !os.IsNotExist(e) {
+		return false, e
+	}
+	if !swappable(current, e == nil, oldBytes) {
+		return false, nil
+	}
+	if newBytes == nil {
+		if e := os.Remove(path); e != nil && !os.IsNotExist(e) {
+			return false, e
+		}
+		return true, nil
+	}
+	if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+		return false, e
+	}
+	temp := path + ".tmp"
+	if e := ioutil.WriteFile(temp, newBytes, 0644); e != nil {
+		return false, e
+	}
+	return true, os.Rename(temp, path)
+}
+
+func (ss *sqlStore) Swap(kind, key string, old, v interface{}) (bool, error) {
+	oldBytes, newBytes, e := encodeSwap(old, v)
+	if e != nil {
+		return false, e
+	}
+	var query string
+	var args []interface{}
+	switch {
+	case oldBytes == nil && newBytes == nil:
+		var value []byte
+		e := ss.db.QueryRow(ss.dialect.bind("SELECT value FROM records WHERE kind = ? AND key = ?"), kind, key).Scan(&value)
+		if e == sql.ErrNoRows {
+			return true, nil
+		}
+		return false, e
+	case oldBytes == nil:
+		query = "INSERT INTO records (kind, key, value, updated) VALUES (?, ?, ?, " + ss.dialect.now +
+			") ON CONFLICT (kind, key) DO NOTHING"
+		args = []interface{}{kind, key, string(newBytes)}
+	case newBytes == nil:
+		query = "DELETE FROM records WHERE kind = ? AND key = ? AND value = ?"
+		args = []interface{}{kind, key, string(oldBytes)}
+	default:
+		query = "UPDATE records SET value = ?, updated = " + ss.dialect.now + " WHERE kind = ? AND key = ? AND value = ?"
+		args = []interface{}{string(newBytes), kind, key, string(oldBytes)}
+	}
+	result, e := ss.db.Exec(ss.dialect.bind(query), args...)
+	if e != nil {
+		return false, e
+	}
+	n, e := result.RowsAffected()
+	return n == 1, e
+}